@@ -0,0 +1,243 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package apikey has the scoped api keys of the service callers.
+//The keys are stored in the database with the hash of their secret and cached in memory by every instance.
+//The cache is refreshed in the background, so the requests are verified without a database call.
+//A key is allowed to call only the routes requiring any of its scopes
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the api keys, their storage and verification.
+ * The key given to the caller is ck_<id>_<secret>. Only the sha256 hash of the secret is stored.
+ */
+
+//Scopes of the api keys. A scope ending with :* grants all the scopes with its prefix
+const (
+	//ScopeNotifyUser allows sending the notifications to any user
+	ScopeNotifyUser = "notify:user"
+	//ScopeNotifyBroadcast allows broadcasting the notifications to the tenants and the connections
+	ScopeNotifyBroadcast = "notify:broadcast"
+	//ScopeAdminConnections allows managing the websocket connections
+	ScopeAdminConnections = "admin:connections"
+)
+
+//Prefix is the prefix of the api keys
+const Prefix = "ck_"
+
+//SessionPrefix is the prefix of the session id of the requests authenticated with an api key
+const SessionPrefix = "apikey:"
+
+//ErrNotFound is returned when the api key doesn't exist
+var ErrNotFound = errors.New("api key not found")
+
+//Key is an api key of a service caller
+type Key struct {
+	//ID of the key. It is the public part of the key
+	ID string `gorm:"primary_key" json:"id"`
+	//Hash is the hex encoded sha256 hash of the secret of the key
+	Hash string `json:"-"`
+	//Name of the key for the humans
+	Name string `json:"name"`
+	//Tenant to which the requests with the key belong. The requests act only in this tenant even on the admin routes
+	Tenant string `json:"tenant,omitempty"`
+	//UserID is the user as whom the requests with the key are made
+	UserID uint `json:"userId"`
+	//Scopes is the comma separated list of scopes of the key
+	Scopes string `json:"-"`
+	//ScopeList has the scopes of the key
+	ScopeList []string `gorm:"-" json:"scopes"`
+	//CreatedBy is the admin who created the key
+	CreatedBy uint `json:"createdBy"`
+	//CreatedAt is the time at which the key was created
+	CreatedAt time.Time `json:"createdAt"`
+	//ExpiresAt is the time after which the key is not valid. Nil if the key doesn't expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+//TableName returns the table name of the api keys
+func (Key) TableName() string {
+	return "api_keys"
+}
+
+//Valid reports whether the key is valid at the given time
+func (k Key) Valid(t time.Time) bool {
+	return k.ExpiresAt == nil || k.ExpiresAt.After(t)
+}
+
+//Allows reports whether the key has the scope
+func (k Key) Allows(scope string) bool {
+	for _, s := range k.ScopeList {
+		if s == scope || s == "*" || (strings.HasSuffix(s, ":*") && strings.HasPrefix(scope, s[:len(s)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+//splitScopes returns the scope list of the comma separated scopes
+func splitScopes(scopes string) []string {
+	result := []string{}
+	for _, s := range strings.Split(scopes, ",") {
+		if s = strings.TrimSpace(s); len(s) != 0 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+var (
+	//db is the database in which the keys are stored. The keys are kept only in memory if it is nil
+	db *gorm.DB
+	//cache has the keys mapped by their id
+	cache = map[string]Key{}
+	//cacheLock is the lock for the cache. The database isn't called under it
+	cacheLock sync.RWMutex
+)
+
+//Init will migrate the api keys table, load the keys and refresh them in the background.
+//If the db is nil, the keys are kept in memory
+func Init(d *gorm.DB) error {
+	if d == nil {
+		return nil
+	}
+	if err := d.AutoMigrate(&Key{}).Error; err != nil {
+		return err
+	}
+	db = d
+	if err := load(); err != nil {
+		return err
+	}
+	config.Refresh("api-keys", config.APIKeysRefresh, load)
+	return nil
+}
+
+//load loads the keys from the database and swaps them in
+func load() error {
+	if db == nil {
+		return nil
+	}
+	ks := []Key{}
+	if err := db.Find(&ks).Error; err != nil {
+		return err
+	}
+	loaded := make(map[string]Key, len(ks))
+	for _, k := range ks {
+		k.ScopeList = splitScopes(k.Scopes)
+		loaded[k.ID] = k
+	}
+	cacheLock.Lock()
+	cache = loaded
+	cacheLock.Unlock()
+	return nil
+}
+
+//hash returns the hex encoded sha256 hash of the secret
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+//Create creates the key and returns it with the api key to be given to the caller.
+//The api key can't be recovered later
+func Create(k Key) (string, Key, error) {
+	/*
+	 * We will generate the id and the secret of the key
+	 * Then we will save the key with the hash of the secret
+	 */
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", k, err
+	}
+	k.ID = hex.EncodeToString(b[:8])
+	secret := base64.RawURLEncoding.EncodeToString(b[8:])
+	k.Hash = hash(secret)
+	k.ScopeList = splitScopes(strings.Join(k.ScopeList, ","))
+	k.Scopes = strings.Join(k.ScopeList, ",")
+	k.CreatedAt = time.Now()
+
+	//saving the key
+	if db != nil {
+		if err := db.Create(&k).Error; err != nil {
+			return "", k, err
+		}
+	}
+	cacheLock.Lock()
+	cache[k.ID] = k
+	cacheLock.Unlock()
+	return Prefix + k.ID + "_" + secret, k, nil
+}
+
+//Revoke deletes the key with the given id. The other instances stop accepting it after they refresh the keys
+func Revoke(id string) error {
+	if _, ok := Get(id); !ok {
+		return ErrNotFound
+	}
+	if db != nil {
+		if err := db.Where("id = ?", id).Delete(&Key{}).Error; err != nil {
+			return err
+		}
+	}
+	cacheLock.Lock()
+	delete(cache, id)
+	cacheLock.Unlock()
+	return nil
+}
+
+//List returns the keys
+func List() []Key {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	result := make([]Key, 0, len(cache))
+	for _, k := range cache {
+		result = append(result, k)
+	}
+	return result
+}
+
+//Get returns the key with the given id
+func Get(id string) (Key, bool) {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	k, ok := cache[id]
+	return k, ok
+}
+
+//Verify returns the key of the api key if its secret matches and it is valid
+func Verify(apiKey string) (Key, bool) {
+	/*
+	 * We will split the api key into the id and the secret
+	 * Then we will get the key and compare the hash of the secret in constant time
+	 */
+	if !strings.HasPrefix(apiKey, Prefix) {
+		return Key{}, false
+	}
+	parts := strings.SplitN(apiKey[len(Prefix):], "_", 2)
+	if len(parts) != 2 {
+		return Key{}, false
+	}
+	k, ok := Get(parts[0])
+	if !ok || !k.Valid(time.Now()) {
+		return Key{}, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hash(parts[1])), []byte(k.Hash)) != 1 {
+		return Key{}, false
+	}
+	return k, true
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package apikey_test
+
+import (
+	"testing"
+
+	"github.com/cuttle-ai/websockets/apikey"
+)
+
+func TestScopes(t *testing.T) {
+	k := apikey.Key{ScopeList: []string{apikey.ScopeNotifyUser, "admin:*"}}
+	for scope, allowed := range map[string]bool{
+		apikey.ScopeNotifyUser:       true,
+		apikey.ScopeAdminConnections: true,
+		apikey.ScopeNotifyBroadcast:  false,
+		"admin":                      false,
+	} {
+		if k.Allows(scope) != allowed {
+			t.Errorf("expected the scope %s to be allowed %v", scope, allowed)
+		}
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	key, k, err := apikey.Create(apikey.Key{Name: "test", UserID: 7, ScopeList: []string{apikey.ScopeNotifyUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := apikey.Verify(key); !ok || v.ID != k.ID || v.UserID != 7 || !v.Allows(apikey.ScopeNotifyUser) {
+		t.Fatalf("expected the created key to be verified with its scopes. got %+v %v", v, ok)
+	}
+	if _, ok := apikey.Verify(key + "x"); ok {
+		t.Error("expected the key with a wrong secret to be rejected")
+	}
+
+	//the revoked key isn't accepted and can't be revoked again
+	if err := apikey.Revoke(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := apikey.Verify(key); ok {
+		t.Error("expected the revoked key to be rejected")
+	}
+	if err := apikey.Revoke(k.ID); err != apikey.ErrNotFound {
+		t.Errorf("expected the revoked key not to be found. got %v", err)
+	}
+}
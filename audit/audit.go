@@ -0,0 +1,192 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package audit has the audit log of the notifications accepted by the service for the compliance review.
+//Every accepted notification is recorded with its sender, target, event and the hash of its payload
+//and the record is updated with the result of its delivery
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the audit records of the notifications and their storage
+ */
+
+//Results of the delivery of the audited notifications
+const (
+	//ResultAccepted is the result of a notification yet to leave the pipeline
+	ResultAccepted = "accepted"
+	//ResultQueued is the result of a notification queued in the maintenance mode. It is finished once the notification is published
+	ResultQueued = "queued"
+	//ResultSent is the result of a notification sent to at least one connection
+	ResultSent = "sent"
+	//ResultUndelivered is the result of a notification not sent to any connection. It is kept for the replay if not live
+	ResultUndelivered = "undelivered"
+	//ResultFailed is the result of a notification failed in the pipeline
+	ResultFailed = "failed"
+)
+
+//Record is the audit record of a notification
+type Record struct {
+	//ID of the record
+	ID uint64 `gorm:"primary_key" json:"id"`
+	//Source is the ingestion path through which the notification came
+	Source string `json:"source"`
+	//SenderID is the id of the user who sent the notification
+	SenderID uint `gorm:"index" json:"senderId"`
+	//Tenant of the sender
+	Tenant string `gorm:"index" json:"tenant,omitempty"`
+	//TargetUserID is the id of the user to whom the notification was sent. 0 for the room notifications
+	TargetUserID uint `gorm:"index" json:"targetUserId,omitempty"`
+	//Room to which the notification was sent
+	Room string `json:"room,omitempty"`
+	//Event of the notification
+	Event string `gorm:"index" json:"event"`
+	//NotificationID is the id of the notification given by the producer
+	NotificationID string `json:"notificationId,omitempty"`
+	//PayloadHash is the hex encoded sha256 hash of the json encoded payload
+	PayloadHash string `json:"payloadHash"`
+	//Result of the delivery
+	Result string `json:"result"`
+	//Sent is the no. of connections to which the notification was sent
+	Sent int `json:"sent"`
+	//Error with which the notification failed
+	Error string `json:"error,omitempty"`
+	//AcceptedAt is the time at which the notification was accepted
+	AcceptedAt time.Time `gorm:"index" json:"acceptedAt"`
+	//FinishedAt is the time at which the notification left the pipeline
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+//TableName returns the table name of the audit records
+func (Record) TableName() string {
+	return "notification_audits"
+}
+
+//Query filters the audit records. Empty fields match all the records
+type Query struct {
+	//SenderID of the records
+	SenderID uint
+	//TargetUserID of the records
+	TargetUserID uint
+	//Tenant of the records
+	Tenant string
+	//Event of the records
+	Event string
+	//From is the start of the range of the acceptance time
+	From time.Time
+	//To is the end of the range of the acceptance time
+	To time.Time
+	//Offset of the page
+	Offset int
+	//Limit is the max no. of records in the page
+	Limit int
+}
+
+//Init will migrate the audit table and start the purging of the expired records on the leader instance
+func Init(db *gorm.DB) error {
+	if db == nil {
+		return nil
+	}
+	if err := db.AutoMigrate(&Record{}).Error; err != nil {
+		return err
+	}
+	leader.Run("audit-purge", db, func(done <-chan struct{}) {
+		purge(db, done)
+	})
+	return nil
+}
+
+//purge periodically purges the audit records older than the retention till done is closed
+func purge(db *gorm.DB, done <-chan struct{}) {
+	t := time.NewTicker(config.AuditPurgeCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		if err := db.Where("accepted_at < ?", time.Now().Add(-config.AuditRetention)).Delete(&Record{}).Error; err != nil {
+			log.Error("error while purging the expired audit records", err.Error())
+		}
+	}
+}
+
+//PayloadHash returns the hex encoded sha256 hash of the json encoded payload
+func PayloadHash(payload interface{}) string {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+//Accept saves the record of the accepted notification. The records are not kept if the db is nil
+func Accept(db *gorm.DB, r *Record) error {
+	if db == nil {
+		return nil
+	}
+	r.AcceptedAt = time.Now()
+	if len(r.Result) == 0 {
+		r.Result = ResultAccepted
+	}
+	return db.Create(r).Error
+}
+
+//Finish updates the record with the result of the delivery of the notification
+func Finish(db *gorm.DB, r *Record, sent int, err error) error {
+	if db == nil || r.ID == 0 {
+		return nil
+	}
+	now := time.Now()
+	r.Sent, r.FinishedAt, r.Result = sent, &now, ResultSent
+	if err != nil {
+		r.Result, r.Error = ResultFailed, err.Error()
+	} else if sent == 0 {
+		r.Result = ResultUndelivered
+	}
+	return db.Model(r).Updates(map[string]interface{}{"sent": r.Sent, "finished_at": now, "result": r.Result, "error": r.Error}).Error
+}
+
+//Find returns the page of the records matching the query in the order of their acceptance with the total no. of matches
+func Find(db *gorm.DB, q Query) ([]Record, int, error) {
+	s := db.Model(&Record{})
+	if q.SenderID != 0 {
+		s = s.Where("sender_id = ?", q.SenderID)
+	}
+	if q.TargetUserID != 0 {
+		s = s.Where("target_user_id = ?", q.TargetUserID)
+	}
+	if len(q.Tenant) != 0 {
+		s = s.Where("tenant = ?", q.Tenant)
+	}
+	if len(q.Event) != 0 {
+		s = s.Where("event = ?", q.Event)
+	}
+	if !q.From.IsZero() {
+		s = s.Where("accepted_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		s = s.Where("accepted_at < ?", q.To)
+	}
+	total := 0
+	if err := s.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	result := []Record{}
+	err := s.Order("accepted_at, id").Offset(q.Offset).Limit(q.Limit).Find(&result).Error
+	return result, total, err
+}
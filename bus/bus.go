@@ -0,0 +1,200 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package bus has the in process event bus through which every ingestion path publishes the notifications.
+//REST, RPC and the broker consumers publish an event and the bus runs it through the validate, enrich, route
+//and deliver stages. So the common checks and the fanout live in one pipeline instead of each ingestion path.
+//The handlers of the stages are registered with Use, usually in the init of the package owning the stage.
+package bus
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/tracing"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+//Stage is a stage of the pipeline
+type Stage int
+
+const (
+	//Validate stage rejects the invalid events. The events are validated before any side effect
+	Validate Stage = iota
+	//Enrich stage adds the derived information to the event
+	Enrich
+	//Route stage resolves the connections of the targets of the event
+	Route
+	//Deliver stage records and sends the notification to the connections
+	Deliver
+)
+
+//stages are the stages of the pipeline in the order in which they run
+var stages = []Stage{Validate, Enrich, Route, Deliver}
+
+//String returns the name of the stage
+func (s Stage) String() string {
+	switch s {
+	case Validate:
+		return "validate"
+	case Enrich:
+		return "enrich"
+	case Route:
+		return "route"
+	case Deliver:
+		return "deliver"
+	}
+	return fmt.Sprintf("stage(%d)", int(s))
+}
+
+const (
+	//SourceREST is the source of the events published over the http apis
+	SourceREST = "rest"
+	//SourceRPC is the source of the events sent by the internal services over rpc
+	SourceRPC = "rpc"
+	//SourceGRPC is the source of the events sent over the grpc api
+	SourceGRPC = "grpc"
+	//SourceIngest is the source of the events pushed by the third party ingest sources
+	SourceIngest = "ingest"
+	//SourceHook is the source of the events mapped from the payloads posted by the third party systems to the hooks
+	SourceHook = "hook"
+	//SourceNATS is the source of the events mapped from the messages of the nats subjects
+	SourceNATS = "nats"
+	//SourceAMQP is the source of the events consumed from the amqp queue
+	SourceAMQP = "amqp"
+	//SourcePG is the source of the events mapped from the payloads notified on the postgres channels
+	SourcePG = "postgres"
+	//SourceBroadcast is the source of the admin broadcasts
+	SourceBroadcast = "broadcast"
+	//SourceEscalation is the source of the escalated notifications
+	SourceEscalation = "escalation"
+	//SourceUnread is the source of the unread counts sent to the users when their notifications are read
+	SourceUnread = "unread"
+	//SourceRecurring is the source of the recurring notifications run by the scheduler
+	SourceRecurring = "recurring"
+)
+
+//Event is a notification published on the bus
+type Event struct {
+	//Source is the ingestion path through which the event came. Eg. rest or ingest
+	Source string
+	//AppContext of the publisher. Its db and logger are used by the stages
+	AppContext *config.AppContext
+	//Notification to be delivered
+	Notification delivery.Notification
+	//Users are the ids of the users to whom the notification is delivered
+	Users []uint
+	//Room is the tenant scoped room to which the notification is delivered
+	Room string
+	//Live events are only sent to the live connections. They are not recorded for the replay
+	Live bool
+	//Conns has the connections to which the notification is sent mapped by the user id.
+	//It is resolved by the route stage unless given by the publisher. Room connections are mapped to 0
+	Conns map[uint][]socketio.Conn
+	//Sent is the no. of connections to which the notification was sent
+	Sent int
+	//Sampled is set on the events already through the sampling. They are not sampled again
+	Sampled bool
+	//Journal is the id of the event in the write ahead journal. 0 if the event isn't journaled
+	Journal uint64
+}
+
+//Handler handles an event in a stage. An error stops the pipeline for the event
+type Handler func(e *Event) error
+
+//ErrHalt is returned by a handler to stop the pipeline for the event without failing it.
+//Eg. the event is held back to be published later
+var ErrHalt = errors.New("event halted")
+
+//StageError is the error returned by the handler of a stage
+type StageError struct {
+	//Stage in which the handler failed
+	Stage Stage
+	//Err returned by the handler
+	Err error
+}
+
+//Error returns the error message with the stage
+func (s *StageError) Error() string {
+	return s.Stage.String() + ": " + s.Err.Error()
+}
+
+//Finisher is called with the result of the pipeline once an event leaves it, delivered, halted or failed
+type Finisher func(e *Event, err error)
+
+var (
+	//handlers has the handlers of each stage in the order of registration
+	handlers = map[Stage][]Handler{}
+	//finishers are called once an event leaves the pipeline
+	finishers []Finisher
+	//handlersLock is the lock for the handlers
+	handlersLock sync.RWMutex
+)
+
+//Use adds the handler to the stage
+func Use(s Stage, h Handler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers[s] = append(handlers[s], h)
+}
+
+//OnFinish adds the finisher called once an event published on the bus leaves the pipeline
+func OnFinish(f Finisher) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	finishers = append(finishers, f)
+}
+
+//run runs the handlers of the stage on the event and counts it in the metrics of the stage
+func run(s Stage, e *Event) (err error) {
+	handlersLock.RLock()
+	hs := handlers[s]
+	handlersLock.RUnlock()
+	m, started := begin(s), time.Now()
+	defer func() { m.end(started, err) }()
+	for _, h := range hs {
+		if err := h(e); err == ErrHalt {
+			return err
+		} else if err != nil {
+			return &StageError{Stage: s, Err: err}
+		}
+	}
+	return nil
+}
+
+//Check runs only the validate stage on the event. The publishers use it to reject an event before responding
+func Check(e *Event) error {
+	return run(Validate, e)
+}
+
+//Publish runs the event through all the stages of the pipeline. A halted event is not an error.
+//The finishers are called with the result once the event leaves the pipeline.
+//If the notification has a trace context, the pipeline is traced as its child and the deliveries as the children of the pipeline
+func Publish(e *Event) (err error) {
+	if c, ok := tracing.Parse(e.Notification.Traceparent); ok {
+		span := tracing.Start(c, "bus.publish", tracing.KindInternal).Set("bus.source", e.Source).Set("notification.event", e.Notification.Event)
+		e.Notification.Traceparent = span.Context().Traceparent()
+		defer func() { span.Set("bus.sent", e.Sent).End(err) }()
+	}
+	defer func() {
+		handlersLock.RLock()
+		fs := finishers
+		handlersLock.RUnlock()
+		for _, f := range fs {
+			f(e, err)
+		}
+	}()
+	for _, s := range stages {
+		if err := run(s, e); err == ErrHalt {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
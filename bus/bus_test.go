@@ -0,0 +1,48 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bus_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/bus"
+)
+
+func TestPublish(t *testing.T) {
+	ran := []bus.Stage{}
+	for _, s := range []bus.Stage{bus.Deliver, bus.Route, bus.Enrich, bus.Validate} {
+		s := s
+		bus.Use(s, func(e *bus.Event) error {
+			ran = append(ran, s)
+			if e.Source == "invalid" && s == bus.Validate {
+				return errors.New("invalid source")
+			}
+			if e.Source == "held" && s == bus.Enrich {
+				return bus.ErrHalt
+			}
+			return nil
+		})
+	}
+
+	if err := bus.Publish(&bus.Event{Source: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 4 || ran[0] != bus.Validate || ran[1] != bus.Enrich || ran[2] != bus.Route || ran[3] != bus.Deliver {
+		t.Fatalf("expected the stages to run in the pipeline order. got %v", ran)
+	}
+
+	ran = ran[:0]
+	err := bus.Publish(&bus.Event{Source: "invalid"})
+	se, ok := err.(*bus.StageError)
+	if !ok || se.Stage != bus.Validate || len(ran) != 1 {
+		t.Fatalf("expected the pipeline to stop at the validate stage. got %v after %v", err, ran)
+	}
+
+	ran = ran[:0]
+	if err := bus.Publish(&bus.Event{Source: "held"}); err != nil || len(ran) != 2 {
+		t.Fatalf("expected the halted event to stop at the enrich stage without an error. got %v after %v", err, ran)
+	}
+}
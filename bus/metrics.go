@@ -0,0 +1,239 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package bus
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+)
+
+/*
+ * This file contains the metrics and the alarms of the pipeline.
+ * Every stage counts the events processed, the errors, the events in flight and the time spent.
+ * The queues feeding the pipeline and fed by it, like the receipts, are watched for their depth.
+ * A monitor raises an alarm when a stage has events in flight without any progress or a queue stays backed up,
+ * so that the partial failures are visible instead of silent.
+ */
+
+//stageMetrics has the counters of a stage
+type stageMetrics struct {
+	processed    uint64
+	errors       uint64
+	inFlight     int64
+	nanos        int64
+	lastProgress int64
+	//throughput and errorRate are computed for the last check window by the monitor
+	throughput float64
+	errorRate  float64
+	window     [2]uint64
+}
+
+//StageStats are the metrics of a stage
+type StageStats struct {
+	//Stage is the name of the stage
+	Stage string `json:"stage"`
+	//Processed is the no. of events processed by the stage
+	Processed uint64 `json:"processed"`
+	//Errors is the no. of events rejected or failed by the stage
+	Errors uint64 `json:"errors"`
+	//InFlight is the no. of events in the stage now
+	InFlight int64 `json:"inFlight"`
+	//AvgLatencyMs is the average time in milliseconds spent by an event in the stage
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	//Throughput is the no. of events processed per second in the last check window
+	Throughput float64 `json:"throughput"`
+	//ErrorRate is the fraction of the events failed in the last check window
+	ErrorRate float64 `json:"errorRate"`
+	//LastProgress is the time at which the stage last finished an event
+	LastProgress time.Time `json:"lastProgress"`
+}
+
+//QueueStats are the metrics of a watched queue
+type QueueStats struct {
+	//Name of the queue
+	Name string `json:"name"`
+	//Depth is the no. of items in the queue
+	Depth int `json:"depth"`
+	//Capacity of the queue
+	Capacity int `json:"capacity"`
+}
+
+//Alarm is raised when a stage stalls or a queue stays backed up
+type Alarm struct {
+	//Kind of the alarm. stage or queue
+	Kind string `json:"kind"`
+	//Name of the stage or the queue
+	Name string `json:"name"`
+	//Detail of the alarm
+	Detail string `json:"detail"`
+	//Since is the time from which the condition of the alarm holds
+	Since time.Time `json:"since"`
+}
+
+//queue is a watched queue
+type queue struct {
+	depth      func() (int, int)
+	backedUpAt time.Time
+	isBackedUp bool
+}
+
+var (
+	//metrics has the metrics of each stage
+	metrics = map[Stage]*stageMetrics{}
+	//queues has the watched queues mapped by the name
+	queues = map[string]*queue{}
+	//alarms has the active alarms mapped by the kind and name
+	alarms = map[string]Alarm{}
+	//metricsLock is the lock for the queues, the alarms and the window rates of the stages
+	metricsLock sync.Mutex
+)
+
+//WatchQueue watches the queue with the name. depth returns the no. of items in the queue and its capacity
+func WatchQueue(name string, depth func() (int, int)) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	queues[name] = &queue{depth: depth}
+}
+
+//begin counts an event entering the stage
+func begin(s Stage) *stageMetrics {
+	m := metrics[s]
+	atomic.AddInt64(&m.inFlight, 1)
+	return m
+}
+
+//end counts an event leaving the stage after the time spent in it. A halted event is not counted as an error
+func (m *stageMetrics) end(started time.Time, err error) {
+	now := time.Now()
+	atomic.AddInt64(&m.inFlight, -1)
+	atomic.AddUint64(&m.processed, 1)
+	atomic.AddInt64(&m.nanos, int64(now.Sub(started)))
+	atomic.StoreInt64(&m.lastProgress, now.UnixNano())
+	if err != nil && err != ErrHalt {
+		atomic.AddUint64(&m.errors, 1)
+	}
+}
+
+//Stats returns the metrics of the stages in the pipeline order
+func Stats() []StageStats {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	result := make([]StageStats, 0, len(stages))
+	for _, s := range stages {
+		m := metrics[s]
+		st := StageStats{
+			Stage:        s.String(),
+			Processed:    atomic.LoadUint64(&m.processed),
+			Errors:       atomic.LoadUint64(&m.errors),
+			InFlight:     atomic.LoadInt64(&m.inFlight),
+			Throughput:   m.throughput,
+			ErrorRate:    m.errorRate,
+			LastProgress: time.Unix(0, atomic.LoadInt64(&m.lastProgress)),
+		}
+		if st.Processed != 0 {
+			st.AvgLatencyMs = float64(atomic.LoadInt64(&m.nanos)) / float64(st.Processed) / float64(time.Millisecond)
+		}
+		result = append(result, st)
+	}
+	return result
+}
+
+//Queues returns the metrics of the watched queues sorted by the name
+func Queues() []QueueStats {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	result := make([]QueueStats, 0, len(queues))
+	for name, q := range queues {
+		d, c := q.depth()
+		result = append(result, QueueStats{Name: name, Depth: d, Capacity: c})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+//Alarms returns the active alarms sorted by the time since which they hold
+func Alarms() []Alarm {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	result := make([]Alarm, 0, len(alarms))
+	for _, a := range alarms {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Since.Before(result[j].Since) })
+	return result
+}
+
+//setAlarm raises or clears the alarm. It has to be called with the metrics lock
+func setAlarm(a Alarm, raised bool) {
+	key := a.Kind + "/" + a.Name
+	_, active := alarms[key]
+	switch {
+	case raised && !active:
+		alarms[key] = a
+		log.Error("ALARM:", a.Kind, a.Name, a.Detail, "since", a.Since)
+	case !raised && active:
+		delete(alarms, key)
+		log.Info("ALARM CLEARED:", a.Kind, a.Name)
+	}
+}
+
+//check computes the rates of the last window and raises or clears the alarms
+func check(now time.Time, window time.Duration) {
+	/*
+	 * We will compute the throughput and error rate of each stage in the window
+	 * A stage with events in flight and no progress for the stall duration is stalled
+	 * A queue above the alarm fraction of its capacity for the stall duration is backed up
+	 */
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	for _, s := range stages {
+		m := metrics[s]
+		processed, errs := atomic.LoadUint64(&m.processed), atomic.LoadUint64(&m.errors)
+		dp, de := processed-m.window[0], errs-m.window[1]
+		m.window = [2]uint64{processed, errs}
+		m.throughput = float64(dp) / window.Seconds()
+		m.errorRate = 0
+		if dp != 0 {
+			m.errorRate = float64(de) / float64(dp)
+		}
+
+		last := time.Unix(0, atomic.LoadInt64(&m.lastProgress))
+		stalled := atomic.LoadInt64(&m.inFlight) > 0 && now.Sub(last) > config.PipelineStallAfter
+		setAlarm(Alarm{Kind: "stage", Name: s.String(), Detail: "has events in flight without progress", Since: last}, stalled)
+	}
+
+	for name, q := range queues {
+		d, c := q.depth()
+		backedUp := c > 0 && float64(d) >= float64(c)*config.PipelineQueueAlarm
+		if backedUp && !q.isBackedUp {
+			q.backedUpAt = now
+		}
+		q.isBackedUp = backedUp
+		setAlarm(Alarm{Kind: "queue", Name: name, Detail: "is backed up", Since: q.backedUpAt},
+			backedUp && now.Sub(q.backedUpAt) >= config.PipelineStallAfter)
+	}
+}
+
+//monitor checks the pipeline for the alarms periodically
+func monitor() {
+	t := time.NewTicker(config.PipelineCheck)
+	defer t.Stop()
+	for now := range t.C {
+		check(now, config.PipelineCheck)
+	}
+}
+
+func init() {
+	now := time.Now().UnixNano()
+	for _, s := range stages {
+		metrics[s] = &stageMetrics{lastProgress: now}
+	}
+	go monitor()
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//bench pre-warms a websockets instance running in the benchmark mode with synthetic handshakes
+//and reports the handshake throughput and latencies.
+//Eg. bench -url http://localhost:8080/cuttle-websockets/ -key <benchmark key> -n 10000 -c 100
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ * This file contains the benchmark client of the handshakes
+ */
+
+//token returns the benchmark test token of the synthetic user
+func token(key string, userID int, expiry time.Time) string {
+	content := strconv.Itoa(userID) + "." + strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(content))
+	return content + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+//handshake opens the engine.io polling handshake of the synthetic user and returns its latency
+func handshake(client *http.Client, base *url.URL, tok string) (time.Duration, error) {
+	u := *base
+	q := u.Query()
+	q.Set("EIO", "3")
+	q.Set("transport", "polling")
+	q.Set("bench_token", tok)
+	u.RawQuery = q.Encode()
+	start := time.Now()
+	res, err := client.Get(u.String())
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("handshake failed with %s", res.Status)
+	}
+	return time.Since(start), nil
+}
+
+func main() {
+	/*
+	 * We will parse the flags
+	 * Then we will run the handshakes of the synthetic users concurrently
+	 * Then we will report the throughput and the latencies
+	 */
+	//parsing the flags
+	target := flag.String("url", "http://localhost:8080/cuttle-websockets/", "websockets url of the instance")
+	key := flag.String("key", os.Getenv("BENCHMARK_KEY"), "benchmark key of the instance")
+	n := flag.Int("n", 1000, "no. of handshakes")
+	c := flag.Int("c", 50, "no. of concurrent handshakes")
+	from := flag.Int("from", 1000000, "id of the first synthetic user")
+	flag.Parse()
+	base, err := url.Parse(*target)
+	if err != nil || len(*key) == 0 {
+		fmt.Fprintln(os.Stderr, "a valid url and the benchmark key are required")
+		os.Exit(1)
+	}
+
+	//running the handshakes
+	client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: *c}}
+	expiry := time.Now().Add(time.Hour)
+	jobs := make(chan int)
+	latencies := make([]time.Duration, 0, *n)
+	failures := 0
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *c; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				l, err := handshake(client, base, token(*key, u, expiry))
+				lock.Lock()
+				if err != nil {
+					failures++
+				} else {
+					latencies = append(latencies, l)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < *n; i++ {
+		jobs <- *from + i
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	//reporting
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	pct := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	fmt.Printf("handshakes: %d ok, %d failed in %s\n", len(latencies), failures, elapsed)
+	fmt.Printf("throughput: %.1f handshakes/s\n", float64(len(latencies))/elapsed.Seconds())
+	fmt.Printf("latency: p50 %s p90 %s p99 %s max %s\n", pct(0.5), pct(0.9), pct(0.99), pct(1))
+}
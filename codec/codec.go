@@ -0,0 +1,101 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package codec has the serialization codecs used for the payloads exchanged with the clients.
+//A codec is selected per connection at the handshake and for the http requests by the content type.
+//JSON is the default codec. MessagePack and Protobuf are available for the clients which prefer binary frames.
+package codec
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+	"sync"
+)
+
+//Codec encodes and decodes the payloads exchanged with the clients
+type Codec interface {
+	//Name of the codec as negotiated at the handshake
+	Name() string
+	//ContentType is the mime type of the encoded payloads
+	ContentType() string
+	//Marshal encodes the value
+	Marshal(v interface{}) ([]byte, error)
+	//Unmarshal decodes the data into the value pointed by v
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	//codecs has the registered codecs mapped by their name
+	codecs = map[string]Codec{}
+	//contentTypes has the registered codecs mapped by their content type
+	contentTypes = map[string]Codec{}
+	//codecsLock is the lock for the codecs
+	codecsLock sync.RWMutex
+)
+
+//Register registers the codec. A codec registered with an existing name will replace it
+func Register(c Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[c.Name()] = c
+	contentTypes[c.ContentType()] = c
+}
+
+//Get returns the codec registered with the given name
+func Get(name string) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	c, ok := codecs[strings.ToLower(name)]
+	return c, ok
+}
+
+//ForContentType returns the codec for the given content type header.
+//If no codec is registered for the content type, the default codec is returned
+func ForContentType(contentType string) Codec {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Default
+	}
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	c, ok := contentTypes[mt]
+	if !ok {
+		return Default
+	}
+	return c
+}
+
+//normalize converts the value into the generic form having only maps, slices, strings, numbers, bools and nil.
+//The json representation of the value is used so that the json tags are respected by all the codecs
+func normalize(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case nil, bool, string, []byte, float64, float32, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, json.Number, map[string]interface{}, []interface{}:
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	d := json.NewDecoder(strings.NewReader(string(b)))
+	d.UseNumber()
+	err = d.Decode(&result)
+	return result, err
+}
+
+//assign sets the decoded generic value into the value pointed by v.
+//If v is not a pointer to an empty interface, the json representation is used to convert the value
+func assign(generic interface{}, v interface{}) error {
+	if p, ok := v.(*interface{}); ok {
+		*p = generic
+		return nil
+	}
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
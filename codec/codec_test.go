@@ -0,0 +1,102 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package codec_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/codec"
+)
+
+/*
+ * This file contains the tests and the benchmarks comparing the codecs
+ */
+
+//payload is a typical notification payload
+type payload struct {
+	Event    string            `json:"event"`
+	ID       uint              `json:"id"`
+	Progress float64           `json:"progress"`
+	Done     bool              `json:"done"`
+	Tags     []string          `json:"tags"`
+	Meta     map[string]string `json:"meta"`
+	Error    *string           `json:"error"`
+}
+
+var sample = payload{
+	Event:    "query-progress",
+	ID:       1234567,
+	Progress: 0.75,
+	Tags:     []string{"dataset", "sales", "2019"},
+	Meta:     map[string]string{"dashboard": "revenue", "widget": "bar-chart-with-a-long-name-for-str8"},
+}
+
+var codecs = []string{codec.JSON, codec.MsgPack, codec.Protobuf}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range codecs {
+		c, ok := codec.Get(name)
+		if !ok {
+			t.Fatal("codec not registered", name)
+		}
+		b, err := c.Marshal(sample)
+		if err != nil {
+			t.Fatal(name, "marshal", err)
+		}
+		got := payload{}
+		if err := c.Unmarshal(b, &got); err != nil {
+			t.Fatal(name, "unmarshal", err)
+		}
+		if !reflect.DeepEqual(got, sample) {
+			t.Errorf("%s round trip mismatch. got %+v want %+v", name, got, sample)
+		}
+	}
+}
+
+func TestForContentType(t *testing.T) {
+	if c := codec.ForContentType("application/msgpack"); c.Name() != codec.MsgPack {
+		t.Error("expected msgpack got", c.Name())
+	}
+	if c := codec.ForContentType("application/json; charset=utf-8"); c.Name() != codec.JSON {
+		t.Error("expected json got", c.Name())
+	}
+	if c := codec.ForContentType(""); c.Name() != codec.JSON {
+		t.Error("expected the default codec got", c.Name())
+	}
+}
+
+func benchmarkMarshal(b *testing.B, name string) {
+	c, _ := codec.Get(name)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Marshal(sample); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkUnmarshal(b *testing.B, name string) {
+	c, _ := codec.Get(name)
+	data, err := c.Marshal(sample)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		var v interface{}
+		if err := c.Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalJSON(b *testing.B)       { benchmarkMarshal(b, codec.JSON) }
+func BenchmarkMarshalMsgPack(b *testing.B)    { benchmarkMarshal(b, codec.MsgPack) }
+func BenchmarkMarshalProtobuf(b *testing.B)   { benchmarkMarshal(b, codec.Protobuf) }
+func BenchmarkUnmarshalJSON(b *testing.B)     { benchmarkUnmarshal(b, codec.JSON) }
+func BenchmarkUnmarshalMsgPack(b *testing.B)  { benchmarkUnmarshal(b, codec.MsgPack) }
+func BenchmarkUnmarshalProtobuf(b *testing.B) { benchmarkUnmarshal(b, codec.Protobuf) }
@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package codec
+
+import "encoding/json"
+
+/*
+ * This file contains the json codec
+ */
+
+//JSON is the name of the json codec
+const JSON = "json"
+
+//jsonCodec encodes the payloads as json
+type jsonCodec struct{}
+
+//Name returns the name of the codec
+func (jsonCodec) Name() string {
+	return JSON
+}
+
+//ContentType returns the mime type of the json
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+//Marshal encodes the value as json
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+//Unmarshal decodes the json data into the value
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+//Default is the default codec of the application
+var Default Codec = jsonCodec{}
+
+func init() {
+	Register(Default)
+}
@@ -0,0 +1,331 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+/*
+ * This file contains the MessagePack codec.
+ * Only the generic values are supported. Extension types are not supported.
+ */
+
+//MsgPack is the name of the MessagePack codec
+const MsgPack = "msgpack"
+
+//ErrMsgPackMalformed is returned when the MessagePack data can't be decoded
+var ErrMsgPackMalformed = errors.New("malformed messagepack data")
+
+//msgPackCodec encodes the payloads as MessagePack
+type msgPackCodec struct{}
+
+//Name returns the name of the codec
+func (msgPackCodec) Name() string {
+	return MsgPack
+}
+
+//ContentType returns the mime type of the MessagePack
+func (msgPackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+//Marshal encodes the value as MessagePack
+func (msgPackCodec) Marshal(v interface{}) ([]byte, error) {
+	return appendMsgPack(nil, v)
+}
+
+//Unmarshal decodes the MessagePack data into the value
+func (msgPackCodec) Unmarshal(data []byte, v interface{}) error {
+	generic, rest, err := readMsgPack(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return ErrMsgPackMalformed
+	}
+	return assign(generic, v)
+}
+
+//appendUint appends the unsigned integer of given size in big endian with the prefix byte
+func appendUint(b []byte, prefix byte, v uint64, size int) []byte {
+	b = append(b, prefix)
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*uint(i))))
+	}
+	return b
+}
+
+//appendMsgPackLen appends the header of a string, binary, array or map of given length.
+//fix is the prefix of the fixed size representation which is skipped if 0
+func appendMsgPackLen(b []byte, n int, fix, fixMax byte, p8, p16, p32 byte) []byte {
+	switch {
+	case fix != 0 && n <= int(fixMax):
+		return append(b, fix|byte(n))
+	case p8 != 0 && n <= math.MaxUint8:
+		return appendUint(b, p8, uint64(n), 1)
+	case n <= math.MaxUint16:
+		return appendUint(b, p16, uint64(n), 2)
+	}
+	return appendUint(b, p32, uint64(n), 4)
+}
+
+//appendMsgPackInt appends the signed integer in the smallest representation
+func appendMsgPackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return appendMsgPackUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return appendUint(b, 0xd0, uint64(v), 1)
+	case v >= math.MinInt16:
+		return appendUint(b, 0xd1, uint64(v), 2)
+	case v >= math.MinInt32:
+		return appendUint(b, 0xd2, uint64(v), 4)
+	}
+	return appendUint(b, 0xd3, uint64(v), 8)
+}
+
+//appendMsgPackUint appends the unsigned integer in the smallest representation
+func appendMsgPackUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return appendUint(b, 0xcc, v, 1)
+	case v <= math.MaxUint16:
+		return appendUint(b, 0xcd, v, 2)
+	case v <= math.MaxUint32:
+		return appendUint(b, 0xce, v, 4)
+	}
+	return appendUint(b, 0xcf, v, 8)
+}
+
+//appendMsgPack appends the MessagePack encoding of the value
+func appendMsgPack(b []byte, v interface{}) ([]byte, error) {
+	v, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if t {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return appendMsgPackInt(b, int64(t)), nil
+	case int8:
+		return appendMsgPackInt(b, int64(t)), nil
+	case int16:
+		return appendMsgPackInt(b, int64(t)), nil
+	case int32:
+		return appendMsgPackInt(b, int64(t)), nil
+	case int64:
+		return appendMsgPackInt(b, t), nil
+	case uint:
+		return appendMsgPackUint(b, uint64(t)), nil
+	case uint8:
+		return appendMsgPackUint(b, uint64(t)), nil
+	case uint16:
+		return appendMsgPackUint(b, uint64(t)), nil
+	case uint32:
+		return appendMsgPackUint(b, uint64(t)), nil
+	case uint64:
+		return appendMsgPackUint(b, t), nil
+	case float32:
+		return appendUint(b, 0xca, uint64(math.Float32bits(t)), 4), nil
+	case float64:
+		return appendUint(b, 0xcb, math.Float64bits(t), 8), nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return appendMsgPackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(string(t), 10, 64); err == nil {
+			return appendMsgPackUint(b, u), nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendUint(b, 0xcb, math.Float64bits(f), 8), nil
+	case string:
+		b = appendMsgPackLen(b, len(t), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(b, t...), nil
+	case []byte:
+		b = appendMsgPackLen(b, len(t), 0, 0, 0xc4, 0xc5, 0xc6)
+		return append(b, t...), nil
+	case []interface{}:
+		b = appendMsgPackLen(b, len(t), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range t {
+			if b, err = appendMsgPack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendMsgPackLen(b, len(t), 0x80, 15, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = appendMsgPackLen(b, len(k), 0xa0, 31, 0xd9, 0xda, 0xdb)
+			b = append(b, k...)
+			if b, err = appendMsgPack(b, t[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("messagepack can't encode the type %T", v)
+}
+
+//readN reads n bytes from the data
+func readN(data []byte, n uint64) ([]byte, []byte, error) {
+	if uint64(len(data)) < n {
+		return nil, nil, ErrMsgPackMalformed
+	}
+	return data[:n], data[n:], nil
+}
+
+//readUint reads the big endian unsigned integer of given size
+func readUint(data []byte, size int) (uint64, []byte, error) {
+	b, rest, err := readN(data, uint64(size))
+	if err != nil {
+		return 0, nil, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), rest, nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), rest, nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), rest, nil
+	}
+	return binary.BigEndian.Uint64(b), rest, nil
+}
+
+//readMsgPackArray reads n MessagePack values as an array
+func readMsgPackArray(data []byte, n uint64) ([]interface{}, []byte, error) {
+	if n > uint64(len(data)) {
+		return nil, nil, ErrMsgPackMalformed
+	}
+	result := make([]interface{}, 0, n)
+	for i := uint64(0); i < n; i++ {
+		v, rest, err := readMsgPack(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, v)
+		data = rest
+	}
+	return result, data, nil
+}
+
+//readMsgPackMap reads n key value pairs as a map. Non string keys are formatted as strings
+func readMsgPackMap(data []byte, n uint64) (map[string]interface{}, []byte, error) {
+	if n > uint64(len(data)) {
+		return nil, nil, ErrMsgPackMalformed
+	}
+	result := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		k, rest, err := readMsgPack(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, rest, err := readMsgPack(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		result[key] = v
+		data = rest
+	}
+	return result, data, nil
+}
+
+//readMsgPack reads a MessagePack value from the data and returns the remaining data
+func readMsgPack(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, ErrMsgPackMalformed
+	}
+	c, data := data[0], data[1:]
+	switch {
+	case c <= 0x7f:
+		return int64(c), data, nil
+	case c >= 0xe0:
+		return int64(int8(c)), data, nil
+	case c&0xe0 == 0xa0:
+		s, rest, err := readN(data, uint64(c&0x1f))
+		return string(s), rest, err
+	case c&0xf0 == 0x90:
+		return readMsgPackArray(data, uint64(c&0x0f))
+	case c&0xf0 == 0x80:
+		return readMsgPackMap(data, uint64(c&0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, data, nil
+	case 0xc2:
+		return false, data, nil
+	case 0xc3:
+		return true, data, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return readUint(data, 1<<(c-0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, rest, err := readUint(data, size)
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, rest, err
+	case 0xca:
+		u, rest, err := readUint(data, 4)
+		return float64(math.Float32frombits(uint32(u))), rest, err
+	case 0xcb:
+		u, rest, err := readUint(data, 8)
+		return math.Float64frombits(u), rest, err
+	case 0xd9, 0xda, 0xdb, 0xc4, 0xc5, 0xc6:
+		size := map[byte]int{0xd9: 1, 0xda: 2, 0xdb: 4, 0xc4: 1, 0xc5: 2, 0xc6: 4}[c]
+		n, rest, err := readUint(data, size)
+		if err != nil {
+			return nil, nil, err
+		}
+		b, rest, err := readN(rest, n)
+		if c >= 0xd9 {
+			return string(b), rest, err
+		}
+		return append([]byte{}, b...), rest, err
+	case 0xdc, 0xdd:
+		n, rest, err := readUint(data, 2<<(c-0xdc))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgPackArray(rest, n)
+	case 0xde, 0xdf:
+		n, rest, err := readUint(data, 2<<(c-0xde))
+		if err != nil {
+			return nil, nil, err
+		}
+		return readMsgPackMap(rest, n)
+	}
+	return nil, nil, fmt.Errorf("messagepack type 0x%x is not supported", c)
+}
+
+func init() {
+	Register(msgPackCodec{})
+}
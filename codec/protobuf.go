@@ -0,0 +1,318 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package codec
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+ * This file contains the Protobuf codec.
+ * The payloads are schemaless, so they are encoded as the well known google.protobuf.Value message.
+ * Clients can decode them with the struct.proto definitions available in every protobuf runtime.
+ */
+
+//Protobuf is the name of the Protobuf codec
+const Protobuf = "protobuf"
+
+//ErrProtobufMalformed is returned when the Protobuf data can't be decoded
+var ErrProtobufMalformed = errors.New("malformed protobuf data")
+
+//protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+//field numbers of the google.protobuf.Value message
+const (
+	valueNull   = 1
+	valueNumber = 2
+	valueString = 3
+	valueBool   = 4
+	valueStruct = 5
+	valueList   = 6
+)
+
+//protobufCodec encodes the payloads as google.protobuf.Value
+type protobufCodec struct{}
+
+//Name returns the name of the codec
+func (protobufCodec) Name() string {
+	return Protobuf
+}
+
+//ContentType returns the mime type of the Protobuf
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+//Marshal encodes the value as google.protobuf.Value
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	return appendValue(nil, v)
+}
+
+//Unmarshal decodes the google.protobuf.Value data into the value
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	generic, err := readValue(data)
+	if err != nil {
+		return err
+	}
+	return assign(generic, v)
+}
+
+//appendVarint appends the varint encoding of the value
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+//appendTag appends the tag of the field
+func appendTag(b []byte, field, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+//appendBytes appends the length delimited field
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+//appendNumber appends the number field of the value
+func appendNumber(b []byte, f float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	return append(appendTag(b, valueNumber, wireFixed64), buf[:]...)
+}
+
+//appendValue appends the google.protobuf.Value encoding of the value
+func appendValue(b []byte, v interface{}) ([]byte, error) {
+	v, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case nil:
+		return append(appendTag(b, valueNull, wireVarint), 0), nil
+	case bool:
+		if t {
+			return append(appendTag(b, valueBool, wireVarint), 1), nil
+		}
+		return append(appendTag(b, valueBool, wireVarint), 0), nil
+	case int:
+		return appendNumber(b, float64(t)), nil
+	case int8:
+		return appendNumber(b, float64(t)), nil
+	case int16:
+		return appendNumber(b, float64(t)), nil
+	case int32:
+		return appendNumber(b, float64(t)), nil
+	case int64:
+		return appendNumber(b, float64(t)), nil
+	case uint:
+		return appendNumber(b, float64(t)), nil
+	case uint8:
+		return appendNumber(b, float64(t)), nil
+	case uint16:
+		return appendNumber(b, float64(t)), nil
+	case uint32:
+		return appendNumber(b, float64(t)), nil
+	case uint64:
+		return appendNumber(b, float64(t)), nil
+	case float32:
+		return appendNumber(b, float64(t)), nil
+	case float64:
+		return appendNumber(b, t), nil
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendNumber(b, f), nil
+	case string:
+		return appendBytes(b, valueString, []byte(t)), nil
+	case []byte:
+		return appendBytes(b, valueString, []byte(base64.StdEncoding.EncodeToString(t))), nil
+	case []interface{}:
+		//ListValue has the values as the repeated field 1
+		var list []byte
+		for _, e := range t {
+			ev, err := appendValue(nil, e)
+			if err != nil {
+				return nil, err
+			}
+			list = appendBytes(list, 1, ev)
+		}
+		return appendBytes(b, valueList, list), nil
+	case map[string]interface{}:
+		//Struct has the fields as the map field 1 whose entries have key as field 1 and value as field 2
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var st []byte
+		for _, k := range keys {
+			ev, err := appendValue(nil, t[k])
+			if err != nil {
+				return nil, err
+			}
+			entry := appendBytes(nil, 1, []byte(k))
+			entry = appendBytes(entry, 2, ev)
+			st = appendBytes(st, 1, entry)
+		}
+		return appendBytes(b, valueStruct, st), nil
+	}
+	return nil, fmt.Errorf("protobuf can't encode the type %T", v)
+}
+
+//protoField is a decoded field of a protobuf message
+type protoField struct {
+	//num is the field number
+	num int
+	//wire is the wire type of the field
+	wire int
+	//varint has the value of the varint and the fixed width fields
+	varint uint64
+	//bytes has the value of the length delimited fields
+	bytes []byte
+}
+
+//readFields reads all the fields of a protobuf message
+func readFields(data []byte) ([]protoField, error) {
+	fields := []protoField{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, ErrProtobufMalformed
+		}
+		data = data[n:]
+		f := protoField{num: int(tag >> 3), wire: int(tag & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, ErrProtobufMalformed
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, ErrProtobufMalformed
+			}
+			f.varint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, ErrProtobufMalformed
+			}
+			f.varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, ErrProtobufMalformed
+			}
+			f.bytes, data = data[n:n+int(l)], data[n+int(l):]
+		default:
+			return nil, ErrProtobufMalformed
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+//readValue reads a google.protobuf.Value message. Unknown fields are skipped and the last kind wins
+func readValue(data []byte) (interface{}, error) {
+	fields, err := readFields(data)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	for _, f := range fields {
+		switch f.num {
+		case valueNull:
+			result = nil
+		case valueNumber:
+			result = math.Float64frombits(f.varint)
+		case valueString:
+			result = string(f.bytes)
+		case valueBool:
+			result = f.varint != 0
+		case valueList:
+			if result, err = readList(f.bytes); err != nil {
+				return nil, err
+			}
+		case valueStruct:
+			if result, err = readStruct(f.bytes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+//readList reads a google.protobuf.ListValue message
+func readList(data []byte) ([]interface{}, error) {
+	fields, err := readFields(data)
+	if err != nil {
+		return nil, err
+	}
+	result := []interface{}{}
+	for _, f := range fields {
+		if f.num != 1 || f.wire != wireBytes {
+			continue
+		}
+		v, err := readValue(f.bytes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+//readStruct reads a google.protobuf.Struct message
+func readStruct(data []byte) (map[string]interface{}, error) {
+	fields, err := readFields(data)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	for _, f := range fields {
+		if f.num != 1 || f.wire != wireBytes {
+			continue
+		}
+		entry, err := readFields(f.bytes)
+		if err != nil {
+			return nil, err
+		}
+		var key string
+		var value interface{}
+		for _, e := range entry {
+			switch e.num {
+			case 1:
+				key = string(e.bytes)
+			case 2:
+				if value, err = readValue(e.bytes); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+func init() {
+	Register(protobufCodec{})
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the configuration of the admin users of the service
+ */
+
+//AdminUserIDs has the ids of the users who can access the admin apis of the service
+var AdminUserIDs = map[uint]bool{}
+
+func init() {
+	/*
+	 * We will init the admin user ids from the comma separated list
+	 */
+	for _, id := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		//if successful convert the user id
+		if u, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64); err == nil {
+			AdminUserIDs[uint(u)] = true
+		}
+	}
+}
+
+//IsAdmin reports whether the user with given id is an admin of the service
+func IsAdmin(userID uint) bool {
+	return AdminUserIDs[userID]
+}
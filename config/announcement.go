@@ -0,0 +1,31 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the announcements
+ */
+
+//AnnouncementCheck is the interval at which the instance checks for the announcements starting or withdrawn
+var AnnouncementCheck = time.Duration(5 * time.Second)
+
+func init() {
+	/*
+	 * We will init the announcement check interval
+	 */
+	//announcement check
+	if len(os.Getenv("ANNOUNCEMENT_CHECK")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("ANNOUNCEMENT_CHECK"), 10, 64); err == nil && t > 0 {
+			AnnouncementCheck = time.Duration(t * int64(time.Second))
+		}
+	}
+}
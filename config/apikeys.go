@@ -0,0 +1,33 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the scoped api keys of the service callers
+ */
+
+var (
+	//APIKeysRefresh is the interval at which an instance reloads the api keys from the database in the background
+	APIKeysRefresh = time.Duration(30 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the api keys refresh interval
+	 */
+	//api keys refresh
+	if len(os.Getenv("API_KEYS_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("API_KEYS_REFRESH"), 10, 64); err == nil && t > 0 {
+			APIKeysRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the audit log of the notifications
+ */
+
+var (
+	//AuditRetention is the time till which the audit records of the notifications are kept
+	AuditRetention = time.Duration(365 * 24 * time.Hour)
+	//AuditPurgeCheck is the time after which the expired audit records are purged
+	AuditPurgeCheck = time.Duration(time.Hour)
+)
+
+func init() {
+	/*
+	 * We will init the audit retention
+	 */
+	//audit retention
+	if len(os.Getenv("AUDIT_RETENTION")) != 0 {
+		//if successful convert the retention in days
+		if d, err := strconv.ParseInt(os.Getenv("AUDIT_RETENTION"), 10, 64); err == nil && d > 0 {
+			AuditRetention = time.Duration(d * int64(24*time.Hour))
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the auth policies of the websocket namespaces.
+ * Each namespace is mapped to the credential with which its connections have to be authenticated.
+ * So public streams like the status page can be served along with the authenticated user streams.
+ * The websocket handshake records the credential it was authenticated with in the handshake headers
+ * and the connect handler of the namespace checks it against the policy of the namespace.
+ * The anonymous readonly namespaces accept every connection but don't listen to any event from the clients.
+ * The signed tickets are issued to the authenticated users for a namespace.
+ * The ticket is <user id>.<expiry unix timestamp>.<base64url of the namespace>.<hex hmac-sha256 of the rest with the ticket key>.
+ */
+
+const (
+	//AuthCookie authenticates with the auth service session of the auth cookie or the bearer token
+	AuthCookie = "cookie"
+	//AuthBearer authenticates with the auth token in the bearer authorization header
+	AuthBearer = "bearer"
+	//AuthTicket authenticates with a signed ticket issued for the namespace
+	AuthTicket = "ticket"
+	//AuthAnonymousReadonly accepts the connections without any credential. The clients can't send any event
+	AuthAnonymousReadonly = "anonymous-readonly"
+)
+
+const (
+	//AuthMethodHeader is the handshake header with which the credential of the handshake is recorded
+	AuthMethodHeader = "cuttle-ai-auth-method"
+	//TicketNamespaceHeader is the handshake header with which the namespace of the ticket of the handshake is recorded
+	TicketNamespaceHeader = "cuttle-ai-ticket-namespace"
+)
+
+//AuthAnonymous is the auth method of the handshakes without any credential
+const AuthAnonymous = "anonymous"
+
+var (
+	//NamespaceAuthPolicies has the auth policies mapped by the namespace
+	NamespaceAuthPolicies = map[string]string{}
+	//DefaultAuthPolicy is the auth policy of the namespaces without a policy
+	DefaultAuthPolicy = AuthCookie
+	//TicketKey is the key with which the tickets are signed. Tickets are disabled if empty
+	TicketKey = ""
+	//TicketTTL is the time till which a ticket is valid
+	TicketTTL = time.Duration(time.Minute)
+)
+
+//ErrAuthPolicy is returned when a connection isn't authenticated as per the auth policy of the namespace
+var ErrAuthPolicy = errors.New("connection isn't authenticated as per the auth policy of the namespace")
+
+//validAuthPolicy reports whether the policy is known
+func validAuthPolicy(p string) bool {
+	switch p {
+	case AuthCookie, AuthBearer, AuthTicket, AuthAnonymousReadonly:
+		return true
+	}
+	return false
+}
+
+func init() {
+	/*
+	 * We will init the namespace auth policies from the json config
+	 * We will init the default policy
+	 * We will init the ticket key and ttl
+	 */
+	//namespace auth policies
+	if len(os.Getenv("NAMESPACE_AUTH_POLICIES")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("NAMESPACE_AUTH_POLICIES")), &NamespaceAuthPolicies)
+		if err != nil {
+			log.Println("Error while parsing the namespace auth policies. Default policy applies to all the namespaces", err.Error())
+			NamespaceAuthPolicies = map[string]string{}
+		}
+	}
+	for ns, p := range NamespaceAuthPolicies {
+		if !validAuthPolicy(p) {
+			log.Println("Unknown auth policy", p, "of the namespace", ns, "Default policy applies to it")
+			delete(NamespaceAuthPolicies, ns)
+		}
+	}
+
+	//default policy
+	if p := os.Getenv("DEFAULT_AUTH_POLICY"); len(p) != 0 && validAuthPolicy(p) {
+		DefaultAuthPolicy = p
+	}
+
+	//ticket key and ttl
+	TicketKey = os.Getenv("TICKET_KEY")
+	if len(os.Getenv("TICKET_TTL")) != 0 {
+		//if successful convert the ttl
+		if t, err := strconv.ParseInt(os.Getenv("TICKET_TTL"), 10, 64); err == nil && t > 0 {
+			TicketTTL = time.Duration(t * int64(time.Second))
+		}
+	}
+}
+
+//AuthPolicyOf returns the auth policy of the namespace
+func AuthPolicyOf(namespace string) string {
+	if p, ok := NamespaceAuthPolicies[namespace]; ok {
+		return p
+	}
+	return DefaultAuthPolicy
+}
+
+//AllowsAnonymous reports whether any namespace accepts the connections without a credential
+func AllowsAnonymous() bool {
+	if DefaultAuthPolicy == AuthAnonymousReadonly {
+		return true
+	}
+	for _, p := range NamespaceAuthPolicies {
+		if p == AuthAnonymousReadonly {
+			return true
+		}
+	}
+	return false
+}
+
+//ticketSignature returns the signature of the ticket content
+func ticketSignature(content string) string {
+	mac := hmac.New(sha256.New, []byte(TicketKey))
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//SignTicket returns a ticket for the user to connect to the namespace valid till the expiry
+func SignTicket(userID uint, namespace string, expiry time.Time) string {
+	content := strconv.FormatUint(uint64(userID), 10) + "." + strconv.FormatInt(expiry.Unix(), 10) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(namespace))
+	return content + "." + ticketSignature(content)
+}
+
+//VerifyTicket verifies the ticket and returns the id of the user and the namespace for which it was issued.
+//It always fails if the ticket key is not configured
+func VerifyTicket(ticket string) (uint, string, bool) {
+	/*
+	 * We will check whether the tickets are enabled
+	 * Then we will parse the ticket and check the expiry
+	 * Then we will verify the signature in constant time
+	 */
+	if len(TicketKey) == 0 {
+		return 0, "", false
+	}
+	parts := strings.Split(ticket, ".")
+	if len(parts) != 4 {
+		return 0, "", false
+	}
+	userID, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || userID == 0 {
+		return 0, "", false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return 0, "", false
+	}
+	namespace, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, "", false
+	}
+	expected := ticketSignature(strings.Join(parts[:3], "."))
+	if !hmac.Equal([]byte(expected), []byte(parts[3])) {
+		return 0, "", false
+	}
+	return uint(userID), string(namespace), true
+}
+
+//authorized reports whether the handshake of the connection satisfies the auth policy of the namespace
+func authorized(namespace string, conn socketio.Conn) bool {
+	method := conn.RemoteHeader().Get(AuthMethodHeader)
+	switch AuthPolicyOf(namespace) {
+	case AuthAnonymousReadonly:
+		return true
+	case AuthTicket:
+		return method == AuthTicket && conn.RemoteHeader().Get(TicketNamespaceHeader) == namespace
+	case AuthCookie:
+		return method == AuthCookie || method == AuthBearer
+	default:
+		return method == AuthPolicyOf(namespace)
+	}
+}
+
+//authPolicyOnConnect wraps the connect handler of the namespace to enforce its auth policy
+func authPolicyOnConnect(namespace string, f func(socketio.Conn) error) func(socketio.Conn) error {
+	return func(conn socketio.Conn) error {
+		if !authorized(namespace, conn) {
+			log.Println("rejecting the connection", conn.ID(), "to the namespace", namespace, "authenticated with",
+				conn.RemoteHeader().Get(AuthMethodHeader), "as its auth policy is", AuthPolicyOf(namespace))
+			return ErrAuthPolicy
+		}
+		return f(conn)
+	}
+}
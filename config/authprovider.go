@@ -0,0 +1,127 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	aConfig "github.com/cuttle-ai/auth-service/config"
+	aLog "github.com/cuttle-ai/auth-service/log"
+	authModels "github.com/cuttle-ai/auth-service/models"
+)
+
+/*
+ * This file contains the pluggable authentication providers.
+ * The sessions of the users are validated by the provider selected with AUTH_PROVIDER.
+ * The cuttle auth service is the default provider. Deployments with a different identity system
+ * register their provider with RegisterAuthProvider in the init of their package and select it.
+ * The users and the sessions keep the models of the auth service whatever be the provider.
+ */
+
+//AuthProviderService is the name of the provider backed by the cuttle auth service
+const AuthProviderService = "auth-service"
+
+var (
+	//ErrNotSupported is returned by an auth provider for the operations its identity system doesn't support
+	ErrNotSupported = errors.New("operation not supported by the auth provider")
+	//ErrUserNotFound is returned by an auth provider when its identity system doesn't have the user
+	ErrUserNotFound = errors.New("user not found by the auth provider")
+)
+
+//Revocation is a session revoked in the identity system
+type Revocation struct {
+	//Token of the revoked session. Empty if all the sessions of the user were revoked
+	Token string
+	//UserID of the revoked session
+	UserID uint
+}
+
+//AuthProvider validates the sessions of the users with the identity system of the deployment
+type AuthProvider interface {
+	//Init inits the provider. It is called during the bootstrap and can be called again on a retry
+	Init() error
+	//ValidateSession returns the user of the session token and whether the session is valid
+	ValidateSession(token string) (authModels.User, bool)
+	//GetUser returns the user with the id. ErrUserNotFound is returned if the identity system doesn't have the user
+	GetUser(id uint) (authModels.User, error)
+	//WatchRevocations returns the channel on which the revoked sessions are sent till the context is cancelled
+	WatchRevocations(ctx context.Context) (<-chan Revocation, error)
+}
+
+//authServiceProvider is the auth provider backed by the cuttle auth service
+type authServiceProvider struct{}
+
+//Init inits the auth state with the auth service
+func (authServiceProvider) Init() error {
+	return aConfig.InitAuthState(aLog.NewLogger(0))
+}
+
+//ValidateSession returns the user of the session from the auth service
+func (authServiceProvider) ValidateSession(token string) (authModels.User, bool) {
+	return aConfig.GetAutenticatedUser(token)
+}
+
+//GetUser isn't supported by the auth service as the users are looked up only by their sessions
+func (authServiceProvider) GetUser(id uint) (authModels.User, error) {
+	return authModels.User{}, ErrNotSupported
+}
+
+//WatchRevocations isn't supported by the auth service. The revoked sessions expire from the session cache
+func (authServiceProvider) WatchRevocations(ctx context.Context) (<-chan Revocation, error) {
+	return nil, ErrNotSupported
+}
+
+var (
+	//AuthProviderName is the name of the auth provider
+	AuthProviderName = AuthProviderService
+	//authProviders has the registered auth providers mapped by their name
+	authProviders = map[string]AuthProvider{AuthProviderService: authServiceProvider{}}
+	//authProvidersLock is the lock for the auth providers
+	authProvidersLock sync.RWMutex
+)
+
+//RegisterAuthProvider registers the auth provider with the name. It is selected with the AUTH_PROVIDER env
+func RegisterAuthProvider(name string, p AuthProvider) {
+	authProvidersLock.Lock()
+	defer authProvidersLock.Unlock()
+	authProviders[name] = p
+}
+
+//Auth returns the auth provider of the instance. The auth service provider is returned if the selected one isn't registered
+func Auth() AuthProvider {
+	authProvidersLock.RLock()
+	defer authProvidersLock.RUnlock()
+	if p, ok := authProviders[AuthProviderName]; ok {
+		return p
+	}
+	return authProviders[AuthProviderService]
+}
+
+//InitAuth inits the selected auth provider
+func InitAuth() error {
+	authProvidersLock.RLock()
+	p, ok := authProviders[AuthProviderName]
+	authProvidersLock.RUnlock()
+	if !ok {
+		return &InitError{Part: PartConfig, Key: "AUTH_PROVIDER", Err: errors.New("auth provider " + AuthProviderName + " isn't registered")}
+	}
+	if err := p.Init(); err != nil {
+		return &InitError{Part: PartAuth, Err: err}
+	}
+	return nil
+}
+
+func init() {
+	/*
+	 * We will init the auth provider name
+	 */
+	//auth provider
+	if len(os.Getenv("AUTH_PROVIDER")) != 0 {
+		AuthProviderName = os.Getenv("AUTH_PROVIDER")
+	}
+}
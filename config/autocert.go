@@ -0,0 +1,62 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+/*
+ * This file contains the configuration of the automatic certificate management with ACME.
+ * In the autocert mode, the edge deployments obtain and renew the Let's Encrypt certificate of the service domain
+ * by themselves. The subsystem is compiled in only by the full build profile
+ */
+
+var (
+	//Autocert enables the automatic certificate management of the service domain
+	Autocert = false
+	//AutocertCacheDir is the directory in which the obtained certificates and the account key are cached
+	AutocertCacheDir = "autocert-cache"
+	//AutocertEmail is the contact email of the ACME account. Optional
+	AutocertEmail = ""
+	//AutocertDirectoryURL is the directory url of the ACME server. Defaults to the Let's Encrypt production server
+	AutocertDirectoryURL = ""
+	//AutocertHTTPPort is the port on which the http-01 challenges are answered and the other requests redirected to https.
+	//If empty, the challenges are answered only by the server mux
+	AutocertHTTPPort = "80"
+)
+
+func init() {
+	/*
+	 * We will init the autocert mode
+	 * We will init the cache dir, the email, the directory url and the challenge port
+	 * The autocert mode can't be used with the given certificate and needs a domain name
+	 */
+	Autocert = os.Getenv("AUTOCERT") == "true"
+	if !Autocert {
+		return
+	}
+	if len(os.Getenv("AUTOCERT_CACHE_DIR")) != 0 {
+		AutocertCacheDir = os.Getenv("AUTOCERT_CACHE_DIR")
+	}
+	AutocertEmail = os.Getenv("AUTOCERT_EMAIL")
+	AutocertDirectoryURL = os.Getenv("AUTOCERT_DIRECTORY_URL")
+	if p, ok := os.LookupEnv("AUTOCERT_HTTP_PORT"); ok {
+		AutocertHTTPPort = p
+	}
+
+	//checking the conflicts
+	if len(os.Getenv("TLS_CERT")) != 0 || len(os.Getenv("TLS_KEY")) != 0 {
+		initFailed(PartConfig, "AUTOCERT", errors.New("can't be used with TLS_CERT and TLS_KEY"))
+		Autocert = false
+		return
+	}
+	if d := os.Getenv("SERVICE_DOMAIN"); len(d) == 0 || net.ParseIP(d) != nil {
+		initFailed(PartConfig, "SERVICE_DOMAIN", errors.New("has to be a domain name for the autocert mode"))
+		Autocert = false
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the benchmark mode used in the capacity tests.
+ * In the benchmark mode, the handshakes with a signed test token are accepted without calling the auth service.
+ * So the pure handshake and registry throughput can be measured with synthetic users.
+ * The token is <user id>.<expiry unix timestamp>.<hex hmac-sha256 of "<user id>.<expiry>" with the benchmark key>.
+ * The benchmark mode is never enabled in production.
+ */
+
+var (
+	//BenchmarkMode enables the acceptance of the signed test tokens
+	BenchmarkMode = false
+	//BenchmarkKey is the key with which the test tokens are signed
+	BenchmarkKey = ""
+)
+
+func init() {
+	/*
+	 * We will init the benchmark mode
+	 * We will init the benchmark key
+	 */
+	//benchmark mode
+	if len(os.Getenv("BENCHMARK_MODE")) != 0 {
+		//if successful convert the flag
+		if b, err := strconv.ParseBool(os.Getenv("BENCHMARK_MODE")); err == nil {
+			BenchmarkMode = b
+		}
+	}
+
+	//benchmark key
+	if len(os.Getenv("BENCHMARK_KEY")) != 0 {
+		BenchmarkKey = os.Getenv("BENCHMARK_KEY")
+	}
+}
+
+//BenchmarkEnabled reports whether the benchmark mode is enabled. It is never enabled in production or without a key
+func BenchmarkEnabled() bool {
+	return BenchmarkMode && PRODUCTION == 0 && len(BenchmarkKey) != 0
+}
+
+//benchmarkSignature returns the signature of the test token content
+func benchmarkSignature(content string) string {
+	mac := hmac.New(sha256.New, []byte(BenchmarkKey))
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//BenchmarkToken returns a test token for the synthetic user valid till the expiry
+func BenchmarkToken(userID uint, expiry time.Time) string {
+	content := strconv.FormatUint(uint64(userID), 10) + "." + strconv.FormatInt(expiry.Unix(), 10)
+	return content + "." + benchmarkSignature(content)
+}
+
+//VerifyBenchmarkToken verifies the test token and returns the id of the synthetic user.
+//It always fails if the benchmark mode is not enabled
+func VerifyBenchmarkToken(token string) (uint, bool) {
+	/*
+	 * We will check whether the benchmark mode is enabled
+	 * Then we will parse the token and check the expiry
+	 * Then we will verify the signature in constant time
+	 */
+	if !BenchmarkEnabled() {
+		return 0, false
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	userID, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || userID == 0 {
+		return 0, false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return 0, false
+	}
+	expected := benchmarkSignature(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return 0, false
+	}
+	return uint(userID), true
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"log"
+)
+
+/*
+ * This file contains the bootstrap of the service. The package init never exits the process.
+ * The errors of the init are recorded as typed errors and returned by Bootstrap, which also registers
+ * with the discovery service and inits the auth state. The caller decides whether to retry, run degraded or exit.
+ * So the package can be imported by the tests and the tools without the full platform.
+ */
+
+const (
+	//PartVault is the loading of the config from the secrets management service
+	PartVault = "vault"
+	//PartConfig is the parsing of the config
+	PartConfig = "config"
+	//PartDB is the connection to the database
+	PartDB = "db"
+	//PartWebSockets is the websockets server
+	PartWebSockets = "websockets"
+	//PartDiscovery is the registration with the discovery service
+	PartDiscovery = "discovery"
+	//PartAuth is the auth state of the auth service
+	PartAuth = "auth"
+	//PartRPC is the rpc service
+	PartRPC = "rpc"
+	//PartStore is the storage backend of the shared state
+	PartStore = "store"
+)
+
+//ErrMissing is the error of a required config value which is not given
+var ErrMissing = errors.New("required value is missing")
+
+//InitError is the error while initializing a part of the service
+type InitError struct {
+	//Part of the service which failed. Eg. db or discovery
+	Part string
+	//Key is the config key at fault if any
+	Key string
+	//Err is the underlying error
+	Err error
+}
+
+//Error returns the error message with the part and the key
+func (e *InitError) Error() string {
+	if len(e.Key) != 0 {
+		return e.Part + " " + e.Key + ": " + e.Err.Error()
+	}
+	return e.Part + ": " + e.Err.Error()
+}
+
+//Retryable reports whether the part may succeed when Bootstrap is retried. Eg. the discovery agent may not be up yet.
+//The errors of the package init are not retryable as the init runs only once
+func (e *InitError) Retryable() bool {
+	return e.Part == PartDiscovery || e.Part == PartAuth
+}
+
+//Degradable reports whether the service can run without the part. The db is required once it is enabled.
+//The store falls back to the memory store only if it is enabled in the config
+func (e *InitError) Degradable() bool {
+	return e.Part == PartStore && StoreFallback
+}
+
+var (
+	//initErrors has the errors recorded during the package init
+	initErrors []*InitError
+	//degraded has the degradable errors recorded during the package init
+	degraded []*InitError
+)
+
+//initFailed records the error of the part during the package init
+func initFailed(part, key string, err error) {
+	e := &InitError{Part: part, Key: key, Err: err}
+	log.Println("Error while initing the", e.Error())
+	if e.Degradable() {
+		degraded = append(degraded, e)
+		return
+	}
+	initErrors = append(initErrors, e)
+}
+
+//InitFailed records the error of the part during the init of the other packages of the service.
+//Eg. the store records the error of its backend
+func InitFailed(part, key string, err error) {
+	initFailed(part, key, err)
+}
+
+//Degraded returns the errors of the parts without which the service is running
+func Degraded() []*InitError {
+	return append([]*InitError{}, degraded...)
+}
+
+//Bootstrap returns the first error recorded during the package init.
+//Then it registers the service with the discovery service and inits the auth state.
+//The returned error is always an *InitError. Bootstrap can be called again to retry
+func Bootstrap() error {
+	if len(initErrors) != 0 {
+		return initErrors[0]
+	}
+	if err := RegisterDiscovery(); err != nil {
+		return err
+	}
+	return InitAuth()
+}
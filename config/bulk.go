@@ -0,0 +1,65 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ * This file contains the configuration of the bulk notifications
+ */
+
+var (
+	//BulkMaxUsers is the max no. of users in a bulk notification
+	BulkMaxUsers = 10000
+	//BulkBatchSize is the no. of users fanned out together by a worker
+	BulkBatchSize = 500
+	//BulkWorkers is the no. of workers fanning out the batches of a bulk notification
+	BulkWorkers = 8
+	//BulkQueueSize is the max no. of bulk notifications queued for the fan out. More are rejected till the queue drains
+	BulkQueueSize = 100
+)
+
+func init() {
+	/*
+	 * We will init the max users
+	 * We will init the batch size
+	 * We will init the no. of workers
+	 * We will init the queue size
+	 */
+	//max users
+	if len(os.Getenv("BULK_MAX_USERS")) != 0 {
+		//if successful convert max users
+		if m, err := strconv.Atoi(os.Getenv("BULK_MAX_USERS")); err == nil && m > 0 {
+			BulkMaxUsers = m
+		}
+	}
+
+	//batch size
+	if len(os.Getenv("BULK_BATCH_SIZE")) != 0 {
+		//if successful convert batch size
+		if b, err := strconv.Atoi(os.Getenv("BULK_BATCH_SIZE")); err == nil && b > 0 {
+			BulkBatchSize = b
+		}
+	}
+
+	//workers
+	if len(os.Getenv("BULK_WORKERS")) != 0 {
+		//if successful convert workers
+		if w, err := strconv.Atoi(os.Getenv("BULK_WORKERS")); err == nil && w > 0 {
+			BulkWorkers = w
+		}
+	}
+
+	//queue size
+	if len(os.Getenv("BULK_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if q, err := strconv.Atoi(os.Getenv("BULK_QUEUE_SIZE")); err == nil && q > 0 {
+			BulkQueueSize = q
+		}
+	}
+}
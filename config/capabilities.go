@@ -0,0 +1,74 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import "sync"
+
+/*
+ * This file contains the registry of the optional capabilities enabled in the instance.
+ * Subsystems mark their capability enabled when they get initialized.
+ */
+
+const (
+	//CapabilityDB is enabled when the database is connected
+	CapabilityDB = "db"
+	//CapabilityRedisAdapter is enabled when the socket.io redis adapter is used for the multi instance fanout
+	CapabilityRedisAdapter = "redis-adapter"
+	//CapabilityPushFallback is enabled when the push notifications are used as the fallback for offline users
+	CapabilityPushFallback = "push-fallback"
+	//CapabilityIngest is enabled when the third party ingest sources are configured
+	CapabilityIngest = "ingest"
+	//CapabilityWebhookSigning is enabled when the webhook signing secrets are stored in vault
+	CapabilityWebhookSigning = "webhook-signing"
+	//CapabilityReceipts is enabled when the delivery receipts can be sent to the producer callbacks
+	CapabilityReceipts = "receipts"
+	//CapabilityEscalation is enabled when the escalation policies are configured
+	CapabilityEscalation = "escalation"
+	//CapabilitySampling is enabled when the sampling policies are configured
+	CapabilitySampling = "sampling"
+	//CapabilityNamespaceQuotas is enabled when the namespace quotas are configured
+	CapabilityNamespaceQuotas = "namespace-quotas"
+	//CapabilityShadow is enabled when the notifications are mirrored to the staging instance
+	CapabilityShadow = "shadow"
+	//CapabilitySignedSend is enabled when the internal services can send the notifications signed with the shared secret
+	CapabilitySignedSend = "signed-send"
+)
+
+var (
+	//capabilities has the capabilities of the instance
+	capabilities = map[string]bool{
+		CapabilityDB:              false,
+		CapabilityRedisAdapter:    false,
+		CapabilityPushFallback:    false,
+		CapabilityIngest:          false,
+		CapabilityWebhookSigning:  false,
+		CapabilityReceipts:        false,
+		CapabilityEscalation:      false,
+		CapabilitySampling:        false,
+		CapabilityNamespaceQuotas: false,
+		CapabilityShadow:          false,
+		CapabilitySignedSend:      false,
+	}
+	//capabilitiesLock is the lock for the capabilities
+	capabilitiesLock sync.RWMutex
+)
+
+//SetCapability marks the capability as enabled or disabled
+func SetCapability(name string, enabled bool) {
+	capabilitiesLock.Lock()
+	defer capabilitiesLock.Unlock()
+	capabilities[name] = enabled
+}
+
+//Capabilities returns the capabilities of the instance with whether they are enabled
+func Capabilities() map[string]bool {
+	capabilitiesLock.RLock()
+	defer capabilitiesLock.RUnlock()
+	result := make(map[string]bool, len(capabilities))
+	for k, v := range capabilities {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+ * This file contains the application context blob sent by the clients. Eg. the current page, dashboard id, feature flags.
+ * The blob is flattened into tags so that the services can target the connections by what the user is looking at.
+ * A scalar value gives the tag key:value, each element of a list gives key:element and nested objects use dotted keys.
+ * Eg. {"page": "dashboard", "dashboard": {"id": 42}, "flags": ["beta"]} has the tags page:dashboard, dashboard.id:42 and flags:beta
+ */
+
+//ClientContextMaxSize is the max size in bytes of the application context blob sent by a client
+const ClientContextMaxSize = 4 << 10
+
+//ClientContext is the application context blob sent by the client along with its tags
+type ClientContext struct {
+	//Blob is the application context blob
+	Blob map[string]interface{} `json:"blob"`
+	//Tags are the tags flattened from the blob
+	Tags map[string]bool `json:"-"`
+}
+
+//SetClientContext sets the application context blob of the client. It can be called concurrently with the reads
+func (a *AppContext) SetClientContext(blob map[string]interface{}) {
+	tags := map[string]bool{}
+	flatten("", blob, tags)
+	a.clientContext.Store(ClientContext{Blob: blob, Tags: tags})
+}
+
+//ClientContext returns the application context blob of the client
+func (a *AppContext) ClientContext() ClientContext {
+	c, _ := a.clientContext.Load().(ClientContext)
+	return c
+}
+
+//HasTags reports whether the application context of the client has all the tags
+func (a *AppContext) HasTags(tags []string) bool {
+	c := a.ClientContext()
+	for _, t := range tags {
+		if !c.Tags[t] {
+			return false
+		}
+	}
+	return true
+}
+
+//TagList returns the sorted tags of the application context
+func (c ClientContext) TagList() []string {
+	l := make([]string, 0, len(c.Tags))
+	for t := range c.Tags {
+		l = append(l, t)
+	}
+	sort.Strings(l)
+	return l
+}
+
+//flatten adds the tags of the value with the key to the tags
+func flatten(key string, v interface{}, tags map[string]bool) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if len(key) != 0 {
+				k = key + "." + k
+			}
+			flatten(k, val, tags)
+		}
+	case []interface{}:
+		for _, val := range t {
+			flatten(key, val, tags)
+		}
+	case nil:
+	default:
+		tags[key+":"+fmt.Sprint(t)] = true
+	}
+}
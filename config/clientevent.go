@@ -0,0 +1,87 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"reflect"
+	"sync"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the hooks of the events emitted by the clients.
+ * The hooks are called with the args of an event after its handler has handled it. Eg. to forward them to the webhooks.
+ * The ack callbacks of the clients are not passed to the hooks.
+ */
+
+//ClientEventHook is called with the args of the event emitted by the client of the connection in the namespace
+type ClientEventHook func(conn socketio.Conn, namespace, event string, args []interface{})
+
+var (
+	//clientEventHooks are the hooks of the client events
+	clientEventHooks []ClientEventHook
+	//websocketsEvents has the events registered mapped by the namespace
+	websocketsEvents = map[string]map[string]bool{}
+	//clientEventsLock is the lock for the hooks and the registered events
+	clientEventsLock sync.RWMutex
+)
+
+//OnClientEvent registers the hook of the events emitted by the clients
+func OnClientEvent(h ClientEventHook) {
+	clientEventsLock.Lock()
+	defer clientEventsLock.Unlock()
+	clientEventHooks = append(clientEventHooks, h)
+}
+
+//WebsocketEventRegistered reports whether a handler of the event is registered in the namespace
+func WebsocketEventRegistered(namespace, event string) bool {
+	clientEventsLock.RLock()
+	defer clientEventsLock.RUnlock()
+	return websocketsEvents[namespace][event]
+}
+
+//registeredEvent records the event of the namespace as registered
+func registeredEvent(namespace, event string) {
+	clientEventsLock.Lock()
+	defer clientEventsLock.Unlock()
+	if websocketsEvents[namespace] == nil {
+		websocketsEvents[namespace] = map[string]bool{}
+	}
+	websocketsEvents[namespace][event] = true
+}
+
+//hookOnEvent wraps the event handler of the namespace to call the client event hooks once it has handled the event
+func hookOnEvent(namespace, event string, evtHandler interface{}) interface{} {
+	fv := reflect.ValueOf(evtHandler)
+	if fv.Kind() != reflect.Func {
+		return evtHandler
+	}
+	ft := fv.Type()
+	return reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+		out := fv.Call(args)
+		clientEventsLock.RLock()
+		hooks := clientEventHooks
+		clientEventsLock.RUnlock()
+		if len(hooks) == 0 || len(args) == 0 {
+			return out
+		}
+		conn, ok := args[0].Interface().(socketio.Conn)
+		if !ok {
+			return out
+		}
+		values := make([]interface{}, 0, len(args)-1)
+		for _, a := range args[1:] {
+			if a.Kind() == reflect.Func {
+				continue
+			}
+			values = append(values, a.Interface())
+		}
+		for _, h := range hooks {
+			h(conn, namespace, event, values)
+		}
+		return out
+	}).Interface()
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"math/rand"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the catalog of the close and error reasons sent to the clients.
+ * A reason is emitted to the client with the close event before the connection is closed by the service
+ * and with the error event when the connection stays open. The action in the reason tells the client sdks
+ * whether to reconnect, re-authenticate or surface the error to the user.
+ */
+
+const (
+	//CloseEvent is the event with which the close reason is emitted before the connection is closed
+	CloseEvent = "close-reason"
+	//ErrorEvent is the event with which the error reason is emitted when the connection stays open
+	ErrorEvent = "error-reason"
+)
+
+//CloseGrace is the time given to the close reason to be written before the connection is closed
+const CloseGrace = 200 * time.Millisecond
+
+const (
+	//ActionReconnect asks the client to reconnect after the retry duration
+	ActionReconnect = "reconnect"
+	//ActionReauthenticate asks the client to refresh the session of the user and then reconnect
+	ActionReauthenticate = "reauthenticate"
+	//ActionRetry asks the client to retry the event after the retry duration over the same connection
+	ActionRetry = "retry"
+	//ActionFail asks the client to surface the error to the user without reconnecting
+	ActionFail = "fail"
+)
+
+//CloseReason is a close or error reason sent to the client
+type CloseReason struct {
+	//Code of the reason. The codes are in the 4000-4999 range reserved for the applications by rfc6455
+	Code int `json:"code"`
+	//Reason is the name of the reason. Eg. auth_expired
+	Reason string `json:"reason"`
+	//Action to be taken by the client
+	Action string `json:"action"`
+	//RetryAfterMs is the time in milliseconds after which the client can reconnect or retry
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+	//Message is the human readable description of the reason
+	Message string `json:"message,omitempty"`
+}
+
+var (
+	//CloseAuthExpired is sent when the session of the user has expired or was revoked
+	CloseAuthExpired = CloseReason{Code: 4001, Reason: "auth_expired", Action: ActionReauthenticate,
+		Message: "session of the user has expired"}
+	//CloseProtocolViolation is sent when the client sent a packet the service couldn't understand
+	CloseProtocolViolation = CloseReason{Code: 4002, Reason: "protocol_violation", Action: ActionFail,
+		Message: "client sent an invalid packet"}
+	//CloseServerDraining is sent when the instance is shutting down. The client can reconnect to another instance
+	CloseServerDraining = CloseReason{Code: 4003, Reason: "server_draining", Action: ActionReconnect, RetryAfterMs: 1000,
+		Message: "server is shutting down"}
+	//CloseTooManyConnections is sent to the oldest connection of a user evicted for a new one beyond the per user limit
+	CloseTooManyConnections = CloseReason{Code: 4008, Reason: "too_many_connections", Action: ActionFail,
+		Message: "user has opened too many connections"}
+	//CloseForbidden is sent when the user isn't allowed to connect to the namespace
+	CloseForbidden = CloseReason{Code: 4013, Reason: "forbidden", Action: ActionFail,
+		Message: "user isn't allowed to connect to the namespace"}
+	//CloseInternalError is sent when the handler of an event of the client failed unexpectedly
+	CloseInternalError = CloseReason{Code: 4011, Reason: "internal_error", Action: ActionRetry, RetryAfterMs: 1000,
+		Message: "server couldn't handle the event"}
+	//CloseRateLimited is sent when the events of the client were dropped for exceeding the rate
+	CloseRateLimited = CloseReason{Code: 4029, Reason: "rate_limited", Action: ActionRetry, RetryAfterMs: 1000,
+		Message: "events are sent faster than the allowed rate"}
+)
+
+//WithMessage returns a copy of the reason with the message
+func (c CloseReason) WithMessage(message string) CloseReason {
+	c.Message = message
+	return c
+}
+
+//WithJitter returns a copy of the reason with a random delay upto the spread added to the retry duration.
+//So the clients closed together don't reconnect at once
+func (c CloseReason) WithJitter(spread time.Duration) CloseReason {
+	if spread > 0 {
+		c.RetryAfterMs += rand.Int63n(int64(spread/time.Millisecond) + 1)
+	}
+	return c
+}
+
+//Error returns the message of the reason so that it can be returned as an error
+func (c CloseReason) Error() string {
+	return c.Reason + ": " + c.Message
+}
+
+//Disconnect emits the reason with the close event and closes the connection after the grace time
+func Disconnect(conn socketio.Conn, reason CloseReason) {
+	conn.Emit(CloseEvent, reason)
+	time.AfterFunc(CloseGrace, func() {
+		conn.Close()
+	})
+}
+
+//Warn emits the reason with the error event to the client without closing the connection
+func Warn(conn socketio.Conn, reason CloseReason) {
+	conn.Emit(ErrorEvent, reason)
+}
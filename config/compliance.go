@@ -0,0 +1,88 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the compliance sampling of the delivered notifications
+ */
+
+var (
+	//ComplianceSampleRate is the percentage of the delivered notifications of a user whose snapshots are recorded. 0 disables the sampling
+	ComplianceSampleRate = 0.0
+	//ComplianceTenants are the tenants opted in for the compliance sampling of the notifications delivered to their users
+	ComplianceTenants = []string{}
+	//ComplianceRetention is the time after which the compliance snapshots are purged
+	ComplianceRetention = time.Duration(90 * 24 * time.Hour)
+	//ComplianceKey is the aes key with which the compliance snapshots are encrypted at rest
+	ComplianceKey []byte
+)
+
+func init() {
+	/*
+	 * We will init the sample rate
+	 * We will init the opted in tenants
+	 * We will init the retention
+	 * Then we will init the encryption key. It is required if the sampling is enabled
+	 */
+	//sample rate
+	if len(os.Getenv("COMPLIANCE_SAMPLE_RATE")) != 0 {
+		//if successful convert the rate
+		if r, err := strconv.ParseFloat(os.Getenv("COMPLIANCE_SAMPLE_RATE"), 64); err == nil && r >= 0 && r <= 100 {
+			ComplianceSampleRate = r
+		}
+	}
+
+	//opted in tenants
+	for _, t := range strings.Split(os.Getenv("COMPLIANCE_TENANTS"), ",") {
+		if t = strings.TrimSpace(t); len(t) != 0 {
+			ComplianceTenants = append(ComplianceTenants, t)
+		}
+	}
+
+	//retention
+	if len(os.Getenv("COMPLIANCE_RETENTION")) != 0 {
+		//if successful convert the no. of days
+		if r, err := strconv.ParseInt(os.Getenv("COMPLIANCE_RETENTION"), 10, 64); err == nil && r > 0 {
+			ComplianceRetention = time.Duration(r * int64(24*time.Hour))
+		}
+	}
+
+	//encryption key
+	if ComplianceSampleRate == 0 {
+		return
+	}
+	k, err := base64.StdEncoding.DecodeString(os.Getenv("COMPLIANCE_KEY"))
+	if err == nil && len(k) != 16 && len(k) != 24 && len(k) != 32 {
+		err = errors.New("key has to be 16, 24 or 32 bytes")
+	}
+	if len(os.Getenv("COMPLIANCE_KEY")) == 0 {
+		err = ErrMissing
+	}
+	if err != nil {
+		initFailed(PartConfig, "COMPLIANCE_KEY", err)
+		ComplianceSampleRate = 0
+		return
+	}
+	ComplianceKey = k
+}
+
+//ComplianceOptedIn reports whether the tenant has opted in for the compliance sampling
+func ComplianceOptedIn(tenant string) bool {
+	for _, t := range ComplianceTenants {
+		if t == tenant {
+			return true
+		}
+	}
+	return false
+}
@@ -48,28 +48,18 @@ var (
 )
 
 //SkipVault will skip the vault initialization if set true
-var SkipVault bool
+var SkipVault = os.Getenv("SKIP_VAULT") == "true"
 
 //IsTest indicates that the current runtime is for test
-var IsTest bool
+var IsTest = os.Getenv("IS_TEST") == "true"
 
-func init() {
-	/*
-	 * Based on the env variables will set the
-	 *	* SkipVault
-	 *  * IsTest
-	 */
-	sk := os.Getenv("SKIP_VAULT")
-	if sk == "true" {
-		SkipVault = true
-	}
-	iT := os.Getenv("IS_TEST")
-	if iT == "true" {
-		IsTest = true
-	}
-}
+//The config is loaded from vault while initializing the package level vars.
+//Go runs the init funcs of the package in the order of their file names, but after initializing all the package level vars.
+//So the config is loaded in this var for the env variables to have the secrets before the init funcs of any file read them
+var _ = loadVault()
 
-func init() {
+//loadVault loads the config from vault and sets them as the environment variables
+func loadVault() bool {
 	/*
 	 * We will load the config from secrets management service
 	 * Then we will set them as environment variables
@@ -77,32 +67,29 @@ func init() {
 	//getting the configuration
 	log.Println("Getting the config values from vault")
 	if SkipVault {
-		return
+		return false
 	}
 	v, err := config.NewVault()
-	checkError(err)
-	reg, err := regexp.Compile("[^A-Za-z0-9]+")
 	if err != nil {
-		log.Fatal(err)
+		initFailed(PartVault, "", err)
+		return false
 	}
-	configName := strings.ToLower(reg.ReplaceAllString(version.AppName, "-"))
+	configName := strings.ToLower(regexp.MustCompile("[^A-Za-z0-9]+").ReplaceAllString(version.AppName, "-"))
 	if IsTest {
 		configName += "-test"
 	}
 	config, err := v.GetConfig(configName)
-	checkError(err)
+	if err != nil {
+		initFailed(PartVault, configName, err)
+		return false
+	}
 
 	//setting the configs as environment variables
 	for k, v := range config {
 		log.Println("Setting the secret from vault", k)
 		os.Setenv(k, v)
 	}
-}
-
-func checkError(err error) {
-	if err != nil {
-		log.Fatal(err)
-	}
+	return true
 }
 
 func init() {
@@ -124,7 +111,7 @@ func init() {
 		ip, err := strconv.Atoi(Port)
 		if err != nil {
 			//error whoile converting the port to integer
-			log.Fatal("Error while converting the port to integer", err.Error())
+			initFailed(PartConfig, "PORT", err)
 		}
 		IntPort = ip
 	}
@@ -136,7 +123,7 @@ func init() {
 		ip, err := strconv.Atoi(RPCPort)
 		if err != nil {
 			//error whoile converting the rpc port to integer
-			log.Fatal("Error while converting the rpc port to integer", err.Error())
+			initFailed(PartConfig, "RPC_PORT", err)
 		}
 		RPCIntPort = ip
 	}
@@ -200,7 +187,7 @@ func init() {
 	}
 
 	if len(DiscoveryToken) == 0 {
-		log.Fatal("Token for discovery service is missing. Can't start the application without it")
+		initFailed(PartConfig, "DISCOVERY_TOKEN", ErrMissing)
 	}
 
 	//service domain
@@ -8,11 +8,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
+	"time"
 
 	//for initialzing the db
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 
 	authConfig "github.com/cuttle-ai/auth-service/config"
+	"github.com/cuttle-ai/websockets/codec"
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/jinzhu/gorm"
 )
@@ -60,16 +63,15 @@ func NewDbConfig() *DbConfig {
 	return dbC
 }
 
-//Connect will connect the database. Will return an error if anything comes up else nil
-func (d DbConfig) Connect() (*gorm.DB, error) {
-	/*
-	 * We will build the connection string
-	 * Then will connect to the database
-	 */
-	cStr := fmt.Sprintf("host=%s port=%s dbname=%s  user=%s password=%s sslmode=disable",
+//ConnString returns the connection string of the database
+func (d DbConfig) ConnString() string {
+	return fmt.Sprintf("host=%s port=%s dbname=%s  user=%s password=%s sslmode=disable",
 		d.Host, d.Port, d.Database, d.Username, d.Password)
+}
 
-	return gorm.Open("postgres", cStr)
+//Connect will connect the database. Will return an error if anything comes up else nil
+func (d DbConfig) Connect() (*gorm.DB, error) {
+	return gorm.Open("postgres", d.ConnString())
 }
 
 //AppContext contains the
@@ -84,6 +86,37 @@ type AppContext struct {
 	Session authConfig.Session
 	//WebSockets has the web sockets server instance
 	WebSockets *socketio.Server
+	//DeviceID is the id of the client device with which the websocket connection was made
+	DeviceID string
+	//Tenant is the tenant to which the user belongs
+	Tenant string
+	//Role is the role of the user in the tenant
+	Role string
+	//Locale is the locale of the client captured at the handshake. Eg. en-US
+	Locale string
+	//Timezone is the timezone of the client captured at the handshake. Defaults to UTC
+	Timezone *time.Location
+	//Codec is the codec negotiated at the handshake for the payloads emitted to the client
+	Codec codec.Codec
+	//Guest is set for the anonymous guest connections. They aren't part of the app context pool
+	Guest bool
+	//ConnectedAt is the time at which the websocket connection of the context was connected
+	ConnectedAt time.Time
+	//clientContext has the application context blob sent by the client and its tags
+	clientContext atomic.Value
+	//rateLimitedAt is the unix nano time at which the client was last told that its events are rate limited
+	rateLimitedAt int64
+}
+
+//DefaultLocale is the locale used when the client doesn't send one
+const DefaultLocale = "en-US"
+
+//LocalTime returns the given time in the timezone of the client
+func (a *AppContext) LocalTime(t time.Time) time.Time {
+	if a.Timezone == nil {
+		return t.UTC()
+	}
+	return t.In(a.Timezone)
 }
 
 var rootAppContext *AppContext
@@ -91,25 +124,31 @@ var rootAppContext *AppContext
 func init() {
 	/*
 	 * We will initialize the context
-	 * We will connect to the database
+	 * We will connect to the database if it is enabled. The service doesn't start if it can't be connected
 	 * We will init the websockets server
 	 */
 	rootAppContext = &AppContext{}
 
 	err := rootAppContext.ConnectToDB()
 	if err != nil {
-		log.Fatal("Error while creating the root app context. Connecting to DB failed. ", err)
+		initFailed(PartDB, DbHost, err)
 	}
+	SetCapability(CapabilityDB, rootAppContext.Db != nil)
 
 	err = rootAppContext.InitWebSockets()
 	if err != nil {
-		log.Fatal("Error while initalizing the websockets server")
+		initFailed(PartWebSockets, "", err)
 	}
 }
 
 //NewAppContext returns an initlized app context
 func NewAppContext(l Logger, id int) *AppContext {
-	return &AppContext{ID: id, Log: l, Db: rootAppContext.Db, WebSockets: rootAppContext.WebSockets}
+	return &AppContext{ID: id, Log: l, Db: rootAppContext.Db, WebSockets: WebSocketsServer()}
+}
+
+//DB returns the database connection of the service. It is nil if the database is not enabled
+func DB() *gorm.DB {
+	return rootAppContext.Db
 }
 
 //ConnectToDB connects the database and updates the Db property of the context as new connection
@@ -142,48 +181,61 @@ func (a *AppContext) InitWebSockets() error {
 	/*
 	 * We will create a web sockets server
 	 * Assign it to the websockets instance
-	 * Then will start the server
+	 * Then will start the server under supervision
 	 */
 	server, err := socketio.NewServer(nil)
 	if err != nil {
-		a.Log.Error("error while creating the websockets server")
+		log.Println("error while creating the websockets server")
 		return err
 	}
 
 	a.WebSockets = server
 
-	go a.WebSockets.Serve()
+	go superviseWebSockets(server)
 	return nil
 }
 
-//RegisterWebsocketEvents will register websockets events to the websocket server instance
+//RegisterWebsocketEvents will register websockets events to the websocket server instance.
+//If the handler implements Drainer, it is registered as a drainer of the namespace.
+//A panic in the handler is recovered and the client is sent the internal error reason.
+//The client event hooks are called once the handler has handled the event.
+//The events of the anonymous readonly namespaces are not registered
 func RegisterWebsocketEvents(namespace, event string, evtHandler interface{}) {
-	if rootAppContext.WebSockets == nil {
+	if AuthPolicyOf(namespace) == AuthAnonymousReadonly {
+		log.Println("not registering the event", event, "in the readonly namespace", namespace)
 		return
 	}
-	rootAppContext.WebSockets.OnEvent(namespace, event, evtHandler)
+	if d, ok := evtHandler.(Drainer); ok {
+		RegisterDrainer(namespace, d)
+	}
+	registeredEvent(namespace, event)
+	registerWebSockets(func(s *socketio.Server) {
+		s.OnEvent(namespace, event, quotaOnEvent(namespace, event, recoverOnEvent(namespace, event, hookOnEvent(namespace, event, evtHandler))))
+	})
 }
 
-//RegisterWebsocketOnConnect will register the websocket on connect event callback
+//RegisterWebsocketOnConnect will register the websocket on connect event callback.
+//The connections not authenticated as per the auth policy of the namespace are rejected before it
 func RegisterWebsocketOnConnect(namespace string, f func(socketio.Conn) error) {
-	if rootAppContext.WebSockets == nil {
-		return
-	}
-	rootAppContext.WebSockets.OnConnect(namespace, f)
+	registerWebSockets(func(s *socketio.Server) {
+		s.OnConnect(namespace, authPolicyOnConnect(namespace, quotaOnConnect(namespace, f)))
+		if _, ok := NamespaceQuotas[namespace]; ok && !websocketsDisconnects[namespace] {
+			s.OnDisconnect(namespace, quotaOnDisconnect(namespace, nil))
+		}
+	})
 }
 
 //RegisterWebsocketOnError will register the websocket on error event callback
 func RegisterWebsocketOnError(namespace string, f func(socketio.Conn, error)) {
-	if rootAppContext.WebSockets == nil {
-		return
-	}
-	rootAppContext.WebSockets.OnError(namespace, f)
+	registerWebSockets(func(s *socketio.Server) {
+		s.OnError(namespace, f)
+	})
 }
 
 //RegisterWebsocketOnDisconnect will register the websocket on disconnect event callback
 func RegisterWebsocketOnDisconnect(namespace string, f func(socketio.Conn, string)) {
-	if rootAppContext.WebSockets == nil {
-		return
-	}
-	rootAppContext.WebSockets.OnDisconnect(namespace, f)
+	websocketsDisconnects[namespace] = true
+	registerWebSockets(func(s *socketio.Server) {
+		s.OnDisconnect(namespace, quotaOnDisconnect(namespace, f))
+	})
 }
@@ -0,0 +1,32 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the deduplication of the identical notifications
+ */
+
+//DedupWindow is the time within which a notification with the same event and payload as one already sent to a user
+//is suppressed. 0 disables the deduplication unless the notification asks for it
+var DedupWindow time.Duration
+
+func init() {
+	/*
+	 * We will init the dedup window
+	 */
+	//dedup window
+	if len(os.Getenv("DEDUP_WINDOW")) != 0 {
+		//if successful convert the window
+		if t, err := strconv.ParseInt(os.Getenv("DEDUP_WINDOW"), 10, 64); err == nil && t >= 0 {
+			DedupWindow = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration for the notification delivery
+ */
+
+var (
+	//AlertLaneRate is the max no. of alerts emitted to a connection per second
+	AlertLaneRate = 5.0
+	//AlertLaneQueueSize is the max no. of alerts that can wait for delivery on a connection
+	AlertLaneQueueSize = 100
+	//DataLaneRate is the max no. of data frames emitted to a connection per second. 0 means unlimited
+	DataLaneRate = 500.0
+	//DataLaneQueueSize is the max no. of data frames that can wait for delivery on a connection
+	DataLaneQueueSize = 1000
+	//UrgentQueueSize is the max no. of system and high priority notifications that can wait for delivery
+	//ahead of the others in a lane of a connection
+	UrgentQueueSize = 100
+)
+
+func init() {
+	/*
+	 * We will init the alert lane rate
+	 * We will init the alert lane queue size
+	 * We will init the data lane rate
+	 * We will init the data lane queue size
+	 * We will init the urgent queue size
+	 */
+	//alert lane rate
+	if len(os.Getenv("ALERT_LANE_RATE")) != 0 {
+		//if successful convert rate
+		if r, err := strconv.ParseFloat(os.Getenv("ALERT_LANE_RATE"), 64); err == nil {
+			AlertLaneRate = r
+		}
+	}
+
+	//alert lane queue size
+	if len(os.Getenv("ALERT_LANE_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if s, err := strconv.Atoi(os.Getenv("ALERT_LANE_QUEUE_SIZE")); err == nil && s > 0 {
+			AlertLaneQueueSize = s
+		}
+	}
+
+	//data lane rate
+	if len(os.Getenv("DATA_LANE_RATE")) != 0 {
+		//if successful convert rate
+		if r, err := strconv.ParseFloat(os.Getenv("DATA_LANE_RATE"), 64); err == nil {
+			DataLaneRate = r
+		}
+	}
+
+	//data lane queue size
+	if len(os.Getenv("DATA_LANE_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if s, err := strconv.Atoi(os.Getenv("DATA_LANE_QUEUE_SIZE")); err == nil && s > 0 {
+			DataLaneQueueSize = s
+		}
+	}
+
+	//urgent queue size
+	if len(os.Getenv("URGENT_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if s, err := strconv.Atoi(os.Getenv("URGENT_QUEUE_SIZE")); err == nil && s > 0 {
+			UrgentQueueSize = s
+		}
+	}
+}
+
+var (
+	//ReplayRetention is the time till which the delivered notifications are kept for the replay
+	ReplayRetention = time.Duration(24 * time.Hour)
+	//ReplayLogSize is the max no. of delivered notifications kept in memory per user for the replay.
+	//It is also the max no. of notifications returned in a single replay
+	ReplayLogSize = 500
+	//ReplayPurgeCheck is the time after which the expired replay logs are purged
+	ReplayPurgeCheck = time.Duration(10 * time.Minute)
+)
+
+func init() {
+	/*
+	 * We will init the replay retention
+	 * We will init the replay log size
+	 */
+	//replay retention
+	if len(os.Getenv("REPLAY_RETENTION")) != 0 {
+		//if successful convert retention
+		if t, err := strconv.ParseInt(os.Getenv("REPLAY_RETENTION"), 10, 64); err == nil {
+			ReplayRetention = time.Duration(t * int64(time.Minute))
+		}
+	}
+
+	//replay log size
+	if len(os.Getenv("REPLAY_LOG_SIZE")) != 0 {
+		//if successful convert log size
+		if s, err := strconv.Atoi(os.Getenv("REPLAY_LOG_SIZE")); err == nil && s > 0 {
+			ReplayLogSize = s
+		}
+	}
+}
+
+var (
+	//SequenceLeaseSize is the no. of sequence nos. of a user leased by an instance at once from the shared store
+	SequenceLeaseSize uint64 = 100
+	//SequenceLeaseTTL is the time till which an instance can allocate from a leased range
+	SequenceLeaseTTL = time.Duration(time.Minute)
+	//SequenceLeaseGrace is the time after the expiry of a lease after which its unused sequence nos. are voided
+	SequenceLeaseGrace = time.Duration(10 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the sequence lease size
+	 * We will init the sequence lease ttl
+	 */
+	//sequence lease size
+	if len(os.Getenv("SEQUENCE_LEASE_SIZE")) != 0 {
+		//if successful convert lease size
+		if s, err := strconv.ParseUint(os.Getenv("SEQUENCE_LEASE_SIZE"), 10, 64); err == nil && s > 0 {
+			SequenceLeaseSize = s
+		}
+	}
+
+	//sequence lease ttl
+	if len(os.Getenv("SEQUENCE_LEASE_TTL")) != 0 {
+		//if successful convert ttl
+		if t, err := strconv.ParseInt(os.Getenv("SEQUENCE_LEASE_TTL"), 10, 64); err == nil && t > 0 {
+			SequenceLeaseTTL = time.Duration(t * int64(time.Second))
+		}
+	}
+}
+
+var (
+	//WriteTimeout is the time within which a write to a connection has to complete. Else it is a transient failure
+	WriteTimeout = time.Duration(2 * time.Second)
+	//WriteRetries is the no. of times a timed out write is waited for again with a doubled timeout before the
+	//connection is marked bad
+	WriteRetries = 2
+)
+
+func init() {
+	/*
+	 * We will init the write timeout
+	 * We will init the write retries
+	 */
+	//write timeout
+	if len(os.Getenv("WRITE_TIMEOUT")) != 0 {
+		//if successful convert timeout
+		if t, err := strconv.ParseInt(os.Getenv("WRITE_TIMEOUT"), 10, 64); err == nil && t > 0 {
+			WriteTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//write retries
+	if len(os.Getenv("WRITE_RETRIES")) != 0 {
+		//if successful convert retries
+		if r, err := strconv.Atoi(os.Getenv("WRITE_RETRIES")); err == nil && r >= 0 {
+			WriteRetries = r
+		}
+	}
+}
+
+//OfflineRetention is the time till which the notifications sent to a user without a live connection are kept
+//to be sent on the next connect of the user. It is capped by the replay retention
+var OfflineRetention = time.Duration(24 * time.Hour)
+
+func init() {
+	/*
+	 * We will init the offline retention
+	 */
+	if len(os.Getenv("OFFLINE_RETENTION")) != 0 {
+		//if successful convert retention
+		if t, err := strconv.ParseInt(os.Getenv("OFFLINE_RETENTION"), 10, 64); err == nil && t >= 0 {
+			OfflineRetention = time.Duration(t * int64(time.Minute))
+		}
+	}
+}
+
+var (
+	//AckTimeout is the time within which a client has to acknowledge a notification sent in the ack mode.
+	//Else the notification is emitted again
+	AckTimeout = time.Duration(5 * time.Second)
+	//AckRetries is the no. of times a notification in the ack mode is emitted again with a doubled timeout
+	//before it is marked unacknowledged
+	AckRetries = 3
+)
+
+func init() {
+	/*
+	 * We will init the ack timeout
+	 * We will init the ack retries
+	 */
+	//ack timeout
+	if len(os.Getenv("ACK_TIMEOUT")) != 0 {
+		//if successful convert timeout
+		if t, err := strconv.ParseInt(os.Getenv("ACK_TIMEOUT"), 10, 64); err == nil && t > 0 {
+			AckTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//ack retries
+	if len(os.Getenv("ACK_RETRIES")) != 0 {
+		//if successful convert retries
+		if r, err := strconv.Atoi(os.Getenv("ACK_RETRIES")); err == nil && r >= 0 {
+			AckRetries = r
+		}
+	}
+}
+
+//RecentEventsSize is the no. of recently emitted events whose metadata is kept per connection for the client debugging.
+//0 disables it
+var RecentEventsSize = 20
+
+func init() {
+	/*
+	 * We will init the recent events size
+	 */
+	//recent events size
+	if len(os.Getenv("RECENT_EVENTS_SIZE")) != 0 {
+		//if successful convert size
+		if s, err := strconv.Atoi(os.Getenv("RECENT_EVENTS_SIZE")); err == nil && s >= 0 {
+			RecentEventsSize = s
+		}
+	}
+}
@@ -5,13 +5,14 @@
 package config
 
 import (
+	"crypto/tls"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
+	"strconv"
 
 	aConfig "github.com/cuttle-ai/auth-service/config"
-	aLog "github.com/cuttle-ai/auth-service/log"
 	"github.com/hashicorp/consul/api"
 )
 
@@ -25,12 +26,30 @@ var WebsocketsServerID = "Brain-Websockets-Server"
 //WebsocketsServerRPCID is the rpc service id to be used with the discovery service
 var WebsocketsServerRPCID = "Brain-Websockets-Server-RPC"
 
-func init() {
+//WebsocketsServerGRPCID is the grpc service id to be used with the discovery service
+var WebsocketsServerGRPCID = "Brain-Websockets-Server-GRPC"
+
+//CapacityMetaKey is the service meta key with which the instance advertises its connection capacity
+const CapacityMetaKey = "capacity"
+
+//discoveryClient is the client of the discovery service
+var discoveryClient *api.Client
+
+//DiscoveryClient returns the client of the discovery service
+func DiscoveryClient() *api.Client {
+	return discoveryClient
+}
+
+//RegisterDiscovery registers the http and rpc services of the instance with the discovery service.
+//It can be called again to retry as the registration is idempotent
+func RegisterDiscovery() error {
 	/*
 	 * We will communicate with the consul client
-	 * Will prepare the service instance for the http and rpc service
+	 * Will prepare the service instance for the http and rpc service with the readiness check
 	 * Then will register the application with consul
 	 * Then we will register the rpc service with the consul agent
+	 * Then we will register the grpc service with the consul agent
+	 * Then we will put the services in the maintenance mode if the instance is a standby
 	 */
 	//Registering the db with the discovery api
 	// Get a new client
@@ -40,9 +59,9 @@ func init() {
 	dConfig.Token = DiscoveryToken
 	client, err := api.NewClient(dConfig)
 	if err != nil {
-		log.Fatal("Error while initing the discovery service client", err.Error())
-		return
+		return &InitError{Part: PartDiscovery, Key: "DISCOVERY_URL", Err: err}
 	}
+	discoveryClient = client
 
 	//service instances for the http service
 	log.Println("Connected with discovery service")
@@ -51,13 +70,15 @@ func init() {
 		Port:    IntPort,
 		Address: ServiceDomain,
 		Tags:    []string{WebsocketsServerID},
+		Meta:    map[string]string{CapacityMetaKey: strconv.Itoa(MaxRequests)},
+		Check:   readinessCheck(),
 	}
 
 	//registering the service with the agent
 	log.Println("Going to register with the discovery service")
 	err = client.Agent().ServiceRegister(appInstance)
 	if err != nil {
-		log.Fatal("Error while registering with the discovery agent", err.Error())
+		return &InitError{Part: PartDiscovery, Key: WebsocketsServerID, Err: err}
 	}
 
 	//service instance for rpc service
@@ -71,36 +92,123 @@ func init() {
 	log.Println("Going to register the rpc service with the discovery service")
 	err = client.Agent().ServiceRegister(rpcInstance)
 	if err != nil {
-		log.Fatal("Error while registering the rpc service with the discovery agent", err.Error())
+		return &InitError{Part: PartDiscovery, Key: WebsocketsServerRPCID, Err: err}
+	}
+
+	//service instance for grpc service if it is compiled in
+	if Compiled(SubsystemGRPC) {
+		grpcInstance := &api.AgentServiceRegistration{
+			Name:    WebsocketsServerGRPCID,
+			Port:    GRPCIntPort,
+			Address: ServiceDomain,
+			Tags:    []string{WebsocketsServerGRPCID},
+			Meta:    map[string]string{"GRPCService": "yes"},
+		}
+		log.Println("Going to register the grpc service with the discovery service")
+		err = client.Agent().ServiceRegister(grpcInstance)
+		if err != nil {
+			return &InitError{Part: PartDiscovery, Key: WebsocketsServerGRPCID, Err: err}
+		}
+	}
+
+	//standby mode
+	if err := enterStandby(); err != nil {
+		return err
 	}
 
 	log.Println("Successfully registered with the discovery service")
+	return nil
 }
 
-func init() {
-	//we will init the auth service
-	l := aLog.NewLogger(0)
-	err := aConfig.InitAuthState(l)
-	if err != nil {
-		log.Fatal("Error while registering with the auth service agent", err.Error())
+//serviceIDs returns the ids of the services of the instance registered with the discovery service
+func serviceIDs() []string {
+	if Compiled(SubsystemGRPC) {
+		return []string{WebsocketsServerID, WebsocketsServerRPCID, WebsocketsServerGRPCID}
+	}
+	return []string{WebsocketsServerID, WebsocketsServerRPCID}
+}
+
+//DeregisterDiscovery deregisters the http, rpc and grpc services of the instance from the discovery service.
+//All of them are tried and the first error is returned
+func DeregisterDiscovery() error {
+	if discoveryClient == nil {
+		return nil
+	}
+	var first error
+	for _, id := range serviceIDs() {
+		if err := discoveryClient.Agent().ServiceDeregister(id); err != nil {
+			log.Println("error while deregistering", id, "from the discovery service", err.Error())
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+//readinessCheck returns the http check of the readiness of the instance for the discovery service.
+//With TLS, the check is made over https without verifying the certificate as it may not be issued for the service domain
+func readinessCheck() *api.AgentServiceCheck {
+	scheme := "http://"
+	if TLSEnabled() {
+		scheme = "https://"
+	}
+	c := &api.AgentServiceCheck{
+		Name:          WebsocketsServerID + " readiness",
+		HTTP:          scheme + ServiceDomain + ":" + Port + "/ready",
+		Interval:      HealthCheckInterval.String(),
+		Timeout:       HealthCheckTimeout.String(),
+		TLSSkipVerify: TLSEnabled(),
 	}
+	if HealthDeregisterAfter > 0 {
+		c.DeregisterCriticalServiceAfter = HealthDeregisterAfter.String()
+	}
+	return c
 }
 
-//StartRPC service will start the rpc service. It helps the services to communicate between each other
-func StartRPC() {
+//StartRPC service will start the rpc service. It helps the services to communicate between each other.
+//An error is returned if the rpc port couldn't be listened
+func StartRPC() error {
 	/*
 	 * Will register the user auth rpc with rpc package
+	 * Will register the health rpc with rpc package
+	 * Will register the rpc services of the other packages
 	 * We will listen to the http with rpc of auth module
-	 * Then we will start listening to the rpc port
+	 * Then we will start listening to the rpc port. With TLS, the rpc port serves TLS too
+	 * With mutual tls, the rpc port accepts only the internal services with the client certificates
 	 */
-	//Registering the auth model with the rpc package
-	rpc.Register(new(aConfig.RPCAuth))
+	//Registering the auth model with the rpc package when the auth service is the auth provider
+	if AuthProviderName == AuthProviderService {
+		rpc.Register(new(aConfig.RPCAuth))
+	}
+
+	//Registering the health and capability introspection with the rpc package
+	rpc.Register(new(RPCHealth))
+
+	//Registering the rpc services of the other packages
+	rpcServicesLock.Lock()
+	for _, s := range rpcServices {
+		if err := rpc.Register(s); err != nil {
+			rpcServicesLock.Unlock()
+			return &InitError{Part: PartRPC, Err: err}
+		}
+	}
+	rpcServicesLock.Unlock()
 
 	//registering the handler with http
 	rpc.HandleHTTP()
-	l, e := net.Listen("tcp", ":"+RPCPort)
+	var l net.Listener
+	var e error
+	if MTLSEnabled() {
+		l, e = tls.Listen("tcp", ":"+RPCPort, InternalTLSConfig())
+	} else if TLSEnabled() {
+		l, e = tls.Listen("tcp", ":"+RPCPort, TLSConfig())
+	} else {
+		l, e = net.Listen("tcp", ":"+RPCPort)
+	}
 	if e != nil {
-		log.Fatal("Error while listening to the rpc port", e.Error())
+		return &InitError{Part: PartRPC, Key: "RPC_PORT", Err: e}
 	}
 	go http.Serve(l, nil)
+	return nil
 }
@@ -0,0 +1,112 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the drain hook of the websocket event handlers.
+ * Handlers keeping per connection state, like the collaborative edit buffers, implement the Drainer interface
+ * to flush the state before the service closes the connections on shutdown or on an admin drain.
+ * An event handler implementing the interface is registered as a drainer of its namespace by RegisterWebsocketEvents.
+ * Others can register with RegisterDrainer. The connections are drained concurrently within the drain timeout.
+ */
+
+//Drainer is implemented by the handlers having per connection state to be flushed before the connection is closed
+type Drainer interface {
+	//Drain flushes the state of the connection. The connection is still open while it is drained
+	Drain(conn socketio.Conn, reason CloseReason)
+}
+
+//DrainTimeout is the max time given to the drainers of the connections before they are closed
+var DrainTimeout = time.Duration(5 * time.Second)
+
+func init() {
+	/*
+	 * We will init the drain timeout
+	 */
+	if len(os.Getenv("DRAIN_TIMEOUT")) != 0 {
+		//if successful convert the timeout
+		if t, err := strconv.ParseInt(os.Getenv("DRAIN_TIMEOUT"), 10, 64); err == nil && t >= 0 {
+			DrainTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+}
+
+var (
+	//drainers has the drainers mapped by the namespace
+	drainers = map[string][]Drainer{}
+	//drainersLock is the lock for the drainers
+	drainersLock sync.RWMutex
+)
+
+//RegisterDrainer registers the drainer for the connections of the namespace.
+//A drainer registered more than once is called once per registration
+func RegisterDrainer(namespace string, d Drainer) {
+	drainersLock.Lock()
+	defer drainersLock.Unlock()
+	drainers[namespace] = append(drainers[namespace], d)
+}
+
+//drain runs the drainers of the namespace of the connection. A panicking drainer doesn't stop the others
+func drain(conn socketio.Conn, reason CloseReason, ds []Drainer) {
+	for _, d := range ds {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("drainer of the connection", conn.ID(), "panicked", r)
+				}
+			}()
+			d.Drain(conn, reason)
+		}()
+	}
+}
+
+//Drain runs the drainers of the connections concurrently and waits for them till the drain timeout.
+//It reports whether all the connections were drained within the timeout
+func Drain(conns []socketio.Conn, reason CloseReason) bool {
+	/*
+	 * We will drain each connection having drainers in its own go routine
+	 * Then we will wait for them till the timeout
+	 */
+	drainersLock.RLock()
+	defer drainersLock.RUnlock()
+	if len(drainers) == 0 {
+		return true
+	}
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		ds := drainers[conn.Namespace()]
+		if len(ds) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(conn socketio.Conn) {
+			defer wg.Done()
+			drain(conn, reason, ds)
+		}(conn)
+	}
+
+	//waiting for the drainers
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(DrainTimeout):
+		return false
+	}
+}
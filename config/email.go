@@ -0,0 +1,86 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the email fallback of the notifications
+ */
+
+var (
+	//SMTPAddr is the host:port of the smtp server through which the emails are sent. Eg. email-smtp.us-east-1.amazonaws.com:587 for ses.
+	//The email fallback is disabled if it is empty
+	SMTPAddr = ""
+	//SMTPUsername is the username of the smtp server
+	SMTPUsername = ""
+	//SMTPPassword is the password of the smtp server
+	SMTPPassword = ""
+	//EmailFrom is the sender address of the emails
+	EmailFrom = "notifications@cuttle.ai"
+	//EmailTemplate is the template rendering the email body of the notifications without a template of their event
+	EmailTemplate = "email"
+	//EmailWorkers is the no. of workers emailing the queued notifications
+	EmailWorkers = 2
+	//EmailMaxAttempts is the max no. of times an email is tried before it is dropped
+	EmailMaxAttempts = 5
+	//EmailRetryBackoff is the time after which a failed email is retried. It doubles with every attempt
+	EmailRetryBackoff = time.Duration(30 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the smtp server and its credentials
+	 * We will init the sender address
+	 * We will init the email template
+	 * We will init the workers and the retries
+	 */
+	//smtp server
+	if len(os.Getenv("SMTP_ADDR")) != 0 {
+		SMTPAddr = os.Getenv("SMTP_ADDR")
+	}
+	if len(os.Getenv("SMTP_USERNAME")) != 0 {
+		SMTPUsername = os.Getenv("SMTP_USERNAME")
+	}
+	if len(os.Getenv("SMTP_PASSWORD")) != 0 {
+		SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	}
+
+	//sender address
+	if len(os.Getenv("EMAIL_FROM")) != 0 {
+		EmailFrom = os.Getenv("EMAIL_FROM")
+	}
+
+	//email template
+	if len(os.Getenv("EMAIL_TEMPLATE")) != 0 {
+		EmailTemplate = os.Getenv("EMAIL_TEMPLATE")
+	}
+
+	//workers
+	if len(os.Getenv("EMAIL_WORKERS")) != 0 {
+		//if successful convert workers
+		if w, err := strconv.Atoi(os.Getenv("EMAIL_WORKERS")); err == nil && w > 0 {
+			EmailWorkers = w
+		}
+	}
+
+	//retries
+	if len(os.Getenv("EMAIL_MAX_ATTEMPTS")) != 0 {
+		//if successful convert max attempts
+		if a, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS")); err == nil && a > 0 {
+			EmailMaxAttempts = a
+		}
+	}
+	if len(os.Getenv("EMAIL_RETRY_BACKOFF")) != 0 {
+		//if successful convert the backoff
+		if t, err := strconv.ParseInt(os.Getenv("EMAIL_RETRY_BACKOFF"), 10, 64); err == nil && t > 0 {
+			EmailRetryBackoff = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the escalation policies of the unacknowledged notifications
+ */
+
+//EscalationStep is a step of an escalation policy
+type EscalationStep struct {
+	//After is the time in seconds from the original notification after which the step runs if it isn't acknowledged
+	After int64 `json:"after"`
+	//Urgency is the urgency with which the user is re-notified. Eg. high, critical
+	Urgency string `json:"urgency"`
+	//Users are the secondary users notified along with the user
+	Users []uint `json:"users"`
+	//Webhook is the fallback channel url to which the escalation is posted as a signed webhook
+	Webhook string `json:"webhook"`
+}
+
+//Delay returns the time after the original notification at which the step runs
+func (e EscalationStep) Delay() time.Duration {
+	return time.Duration(e.After * int64(time.Second))
+}
+
+//EscalationPolicy is the escalation policy of a category of the notifications
+type EscalationPolicy struct {
+	//Steps are the escalation steps in the order of their delay
+	Steps []EscalationStep `json:"steps"`
+}
+
+var (
+	//EscalationPolicies has the escalation policies mapped by the notification category
+	EscalationPolicies = map[string]EscalationPolicy{}
+	//EscalationCheck is the interval at which the due escalations are checked
+	EscalationCheck = time.Duration(10 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the escalation policies from the json config
+	 * We will init the escalation check interval
+	 */
+	//escalation policies
+	if len(os.Getenv("ESCALATION_POLICIES")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("ESCALATION_POLICIES")), &EscalationPolicies)
+		if err != nil {
+			log.Println("Error while parsing the escalation policies. Escalation is disabled", err.Error())
+			EscalationPolicies = map[string]EscalationPolicy{}
+		}
+	}
+	SetCapability(CapabilityEscalation, len(EscalationPolicies) != 0)
+
+	//escalation check
+	if len(os.Getenv("ESCALATION_CHECK")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("ESCALATION_CHECK"), 10, 64); err == nil && t > 0 {
+			EscalationCheck = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+/*
+ * This file contains the mapping of the messages of the external sources like the nats subjects
+ * or the postgres channels to the events sent to the clients
+ */
+
+//EventMapping maps the messages of a source to an event sent to the clients
+type EventMapping struct {
+	//Event sent to the clients. Defaults to the name of the subject or the channel
+	Event string `json:"event,omitempty"`
+	//Users is the jsonpath selecting the ids of the users from the message. Eg. $.userIds.
+	//The message is sent as a live event to all the connections of the namespace if empty
+	Users string `json:"users,omitempty"`
+	//Namespace whose connections get the messages without the users. Defaults to /
+	Namespace string `json:"namespace,omitempty"`
+	//Payload is the jsonpath selecting the payload of the event from the message. The whole message is sent if empty
+	Payload string `json:"payload,omitempty"`
+}
+
+//withDefaults returns the mapping with the defaults of the event and the namespace set
+func (e EventMapping) withDefaults(name string) EventMapping {
+	if len(e.Event) == 0 {
+		e.Event = name
+	}
+	if len(e.Namespace) == 0 {
+		e.Namespace = Namespace
+	}
+	return e
+}
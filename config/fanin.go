@@ -0,0 +1,64 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the fan-in of the notifications of a key from multiple producers
+ */
+
+var (
+	//SourcePriorities has the priorities of the ingestion sources mapped by the source. Eg. {"ingest": 10, "rest": 5}.
+	//An update from a higher priority source wins over one with the same logical clock. Unlisted sources have 0
+	SourcePriorities = map[string]int{}
+	//FanInWindow is the time for which the latest update of a key is remembered to resolve the conflicts
+	FanInWindow = time.Duration(10 * time.Minute)
+	//FanInMaxKeys is the no. of keys beyond which the instance prunes the expired updates it accepted
+	FanInMaxKeys = 10000
+)
+
+//SourcePriority returns the priority of the ingestion source
+func SourcePriority(source string) int {
+	return SourcePriorities[source]
+}
+
+func init() {
+	/*
+	 * We will init the source priorities from the json config
+	 * We will init the fan-in window
+	 * We will init the max keys of the fan-in
+	 */
+	//source priorities
+	if len(os.Getenv("SOURCE_PRIORITIES")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("SOURCE_PRIORITIES")), &SourcePriorities)
+		if err != nil {
+			log.Println("Error while parsing the source priorities. All the sources have the same priority", err.Error())
+			SourcePriorities = map[string]int{}
+		}
+	}
+
+	//fan-in window
+	if len(os.Getenv("FANIN_WINDOW")) != 0 {
+		//if successful convert the window
+		if t, err := strconv.ParseInt(os.Getenv("FANIN_WINDOW"), 10, 64); err == nil && t > 0 {
+			FanInWindow = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//max keys of the fan-in
+	if len(os.Getenv("FANIN_MAX_KEYS")) != 0 {
+		//if successful convert the max keys
+		if n, err := strconv.Atoi(os.Getenv("FANIN_MAX_KEYS")); err == nil && n > 0 {
+			FanInMaxKeys = n
+		}
+	}
+}
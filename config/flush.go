@@ -0,0 +1,41 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the flush of the notifications in flight on shutdown
+ */
+
+var (
+	//ShutdownFlushTimeout is the max time given to the flush of the notifications in flight on shutdown
+	ShutdownFlushTimeout = time.Duration(5 * time.Second)
+	//FlushDLQPath is the file to which the notifications in flight are appended if they couldn't be flushed to the shared store
+	FlushDLQPath = ""
+)
+
+func init() {
+	/*
+	 * We will init the shutdown flush timeout
+	 * We will init the flush dead letter queue path
+	 */
+	//shutdown flush timeout
+	if len(os.Getenv("SHUTDOWN_FLUSH_TIMEOUT")) != 0 {
+		//if successful convert the timeout
+		if t, err := strconv.ParseInt(os.Getenv("SHUTDOWN_FLUSH_TIMEOUT"), 10, 64); err == nil && t >= 0 {
+			ShutdownFlushTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//flush dead letter queue path
+	if len(os.Getenv("FLUSH_DLQ_PATH")) != 0 {
+		FlushDLQPath = os.Getenv("FLUSH_DLQ_PATH")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ * This file contains the configuration of the grpc api of the service
+ */
+
+var (
+	//GRPCPort in which the application's grpc server is being served
+	GRPCPort = "8080"
+	//GRPCIntPort is the grpc port converted into integer
+	GRPCIntPort = 8080
+	//GRPCToken is the token the callers have to pass in the x-cuttle-token metadata of the grpc calls.
+	//If empty, the grpc calls are rejected
+	GRPCToken = ""
+	//GRPCWatchBuffer is the no. of delivery statuses buffered for a watcher. A slow watcher misses the statuses beyond it
+	GRPCWatchBuffer = 256
+)
+
+func init() {
+	/*
+	 * We will init the grpc port
+	 * We will init the grpc token
+	 * We will init the watch buffer
+	 */
+	//grpc port
+	if len(os.Getenv("GRPC_PORT")) != 0 {
+		GRPCPort = os.Getenv("GRPC_PORT")
+		ip, err := strconv.Atoi(GRPCPort)
+		if err != nil {
+			//error while converting the grpc port to integer
+			initFailed(PartConfig, "GRPC_PORT", err)
+		}
+		GRPCIntPort = ip
+	}
+
+	//grpc token
+	GRPCToken = os.Getenv("GRPC_TOKEN")
+
+	//watch buffer
+	if len(os.Getenv("GRPC_WATCH_BUFFER")) != 0 {
+		//if successful convert the buffer size
+		if s, err := strconv.Atoi(os.Getenv("GRPC_WATCH_BUFFER")); err == nil && s > 0 {
+			GRPCWatchBuffer = s
+		}
+	}
+}
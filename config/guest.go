@@ -0,0 +1,100 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the configuration of the guest connections. The guests are the anonymous connections
+ * to the anonymous readonly namespaces. They have their own pool and stricter limits and
+ * receive only the whitelisted events.
+ */
+
+var (
+	//GuestMaxConnections is the max no. of guest connections of the instance
+	GuestMaxConnections = 1000
+	//GuestEvents are the events which can be emitted to the guests
+	GuestEvents = []string{}
+	//GuestRoom is the room joined by every guest connection. The public notifications are sent to it with an empty tenant
+	GuestRoom = "public"
+	//GuestLaneRate is the max no. of notifications emitted to a guest connection per second
+	GuestLaneRate = 1.0
+	//GuestLaneQueueSize is the max no. of notifications waiting for delivery on a guest connection
+	GuestLaneQueueSize = 10
+	//GuestHandshakeRate is the max no. of guest handshakes per second from an ip
+	GuestHandshakeRate = 1.0
+	//GuestHandshakeBurst is the max no. of guest handshakes an ip can make at once
+	GuestHandshakeBurst = 5
+)
+
+func init() {
+	/*
+	 * We will init the max connections
+	 * We will init the whitelisted events and the room
+	 * We will init the lane rate and queue size
+	 * We will init the handshake rate and burst
+	 */
+	//max connections
+	if len(os.Getenv("GUEST_MAX_CONNECTIONS")) != 0 {
+		//if successful convert the max connections
+		if m, err := strconv.Atoi(os.Getenv("GUEST_MAX_CONNECTIONS")); err == nil && m >= 0 {
+			GuestMaxConnections = m
+		}
+	}
+
+	//whitelisted events and the room
+	if len(os.Getenv("GUEST_EVENTS")) != 0 {
+		for _, e := range strings.Split(os.Getenv("GUEST_EVENTS"), ",") {
+			if e = strings.TrimSpace(e); len(e) != 0 {
+				GuestEvents = append(GuestEvents, e)
+			}
+		}
+	}
+	if len(os.Getenv("GUEST_ROOM")) != 0 {
+		GuestRoom = os.Getenv("GUEST_ROOM")
+	}
+
+	//lane rate and queue size
+	if len(os.Getenv("GUEST_LANE_RATE")) != 0 {
+		//if successful convert the rate
+		if r, err := strconv.ParseFloat(os.Getenv("GUEST_LANE_RATE"), 64); err == nil && r > 0 {
+			GuestLaneRate = r
+		}
+	}
+	if len(os.Getenv("GUEST_LANE_QUEUE_SIZE")) != 0 {
+		//if successful convert the queue size
+		if s, err := strconv.Atoi(os.Getenv("GUEST_LANE_QUEUE_SIZE")); err == nil && s > 0 {
+			GuestLaneQueueSize = s
+		}
+	}
+
+	//handshake rate and burst
+	if len(os.Getenv("GUEST_HANDSHAKE_RATE")) != 0 {
+		//if successful convert the rate
+		if r, err := strconv.ParseFloat(os.Getenv("GUEST_HANDSHAKE_RATE"), 64); err == nil && r > 0 {
+			GuestHandshakeRate = r
+		}
+	}
+	if len(os.Getenv("GUEST_HANDSHAKE_BURST")) != 0 {
+		//if successful convert the burst
+		if b, err := strconv.Atoi(os.Getenv("GUEST_HANDSHAKE_BURST")); err == nil && b > 0 {
+			GuestHandshakeBurst = b
+		}
+	}
+}
+
+//GuestEventAllowed reports whether the event can be emitted to the guests
+func GuestEventAllowed(event string) bool {
+	for _, e := range GuestEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+/*
+ * This file contains the health and readiness checks of the instance.
+ * The readiness is checked by the discovery service so that it stops routing to the instances not ready.
+ */
+
+var (
+	//AuthServiceName is the name with which the auth service is registered with the discovery service
+	AuthServiceName = "Brain-Auth-Server"
+	//HealthCheckInterval is the interval at which the discovery service checks the readiness of the instance
+	HealthCheckInterval = time.Duration(10 * time.Second)
+	//HealthCheckTimeout is the time within which the readiness checks have to complete
+	HealthCheckTimeout = time.Duration(2 * time.Second)
+	//HealthDeregisterAfter is the time after which the discovery service deregisters an instance that is not ready.
+	//0 never deregisters
+	HealthDeregisterAfter = time.Duration(0)
+)
+
+var (
+	//ErrWebSocketsNotServing is returned when the websockets server is not serving
+	ErrWebSocketsNotServing = errors.New("websockets server is not serving")
+	//ErrAuthUnreachable is returned when no healthy instance of the auth service is found
+	ErrAuthUnreachable = errors.New("no healthy instance of the auth service found")
+	//ErrNoDiscovery is returned when the instance isn't connected with the discovery service
+	ErrNoDiscovery = errors.New("not connected with the discovery service")
+)
+
+var (
+	//notReadyReason is the reason for which the instance is marked not ready. Empty if it isn't marked
+	notReadyReason string
+	//notReadyLock is the lock for the not ready reason
+	notReadyLock sync.RWMutex
+)
+
+//SetNotReady marks the instance not ready with the reason, like when it is shutting down
+func SetNotReady(reason string) {
+	notReadyLock.Lock()
+	defer notReadyLock.Unlock()
+	notReadyReason = reason
+}
+
+//NotReady returns the reason for which the instance is marked not ready. Empty if it isn't marked
+func NotReady() string {
+	notReadyLock.RLock()
+	defer notReadyLock.RUnlock()
+	return notReadyReason
+}
+
+//CheckDB checks whether the database is reachable. It reports false if the database isn't used
+func CheckDB(ctx context.Context) (bool, error) {
+	if rootAppContext == nil || rootAppContext.Db == nil {
+		return false, nil
+	}
+	return true, rootAppContext.Db.DB().PingContext(ctx)
+}
+
+//CheckWebSockets checks whether the websockets server is serving
+func CheckWebSockets() error {
+	websocketsLock.RLock()
+	defer websocketsLock.RUnlock()
+	if !websocketsServing || websocketsClosing {
+		return ErrWebSocketsNotServing
+	}
+	return nil
+}
+
+//CheckAuth checks whether a healthy instance of the auth service is registered with the discovery service.
+//The other auth providers aren't checked
+func CheckAuth(ctx context.Context) error {
+	if AuthProviderName != AuthProviderService {
+		return nil
+	}
+	if discoveryClient == nil {
+		return ErrNoDiscovery
+	}
+	entries, _, err := discoveryClient.Health().Service(AuthServiceName, "", true, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return ErrAuthUnreachable
+	}
+	return nil
+}
+
+func init() {
+	/*
+	 * We will init the auth service name
+	 * We will init the check interval and timeout
+	 * We will init the deregister time
+	 */
+	//auth service name
+	if len(os.Getenv("AUTH_SERVICE_NAME")) != 0 {
+		AuthServiceName = os.Getenv("AUTH_SERVICE_NAME")
+	}
+
+	//check interval and timeout
+	if len(os.Getenv("HEALTH_CHECK_INTERVAL")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("HEALTH_CHECK_INTERVAL"), 10, 64); err == nil && t > 0 {
+			HealthCheckInterval = time.Duration(t * int64(time.Second))
+		}
+	}
+	if len(os.Getenv("HEALTH_CHECK_TIMEOUT")) != 0 {
+		//if successful convert the timeout
+		if t, err := strconv.ParseInt(os.Getenv("HEALTH_CHECK_TIMEOUT"), 10, 64); err == nil && t > 0 {
+			HealthCheckTimeout = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//deregister time
+	if len(os.Getenv("HEALTH_DEREGISTER_AFTER")) != 0 {
+		//if successful convert the time
+		if t, err := strconv.ParseInt(os.Getenv("HEALTH_DEREGISTER_AFTER"), 10, 64); err == nil && t >= 0 {
+			HealthDeregisterAfter = time.Duration(t * int64(time.Minute))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the notification history of the users
+ */
+
+//UnreadCountEvent is the event with which the clients of a user are sent the no. of their unread notifications
+const UnreadCountEvent = "unread-count"
+
+var (
+	//HistoryRetention is the time till which the notifications delivered to a user are kept in their history
+	HistoryRetention = time.Duration(30 * 24 * time.Hour)
+	//HistorySize is the max no. of notifications kept in memory per user for the history
+	HistorySize = 200
+	//HistoryPurgeCheck is the time after which the expired history is purged
+	HistoryPurgeCheck = time.Duration(time.Hour)
+)
+
+func init() {
+	/*
+	 * We will init the history retention
+	 * We will init the history size
+	 */
+	//history retention
+	if len(os.Getenv("HISTORY_RETENTION")) != 0 {
+		//if successful convert the retention in days
+		if d, err := strconv.ParseInt(os.Getenv("HISTORY_RETENTION"), 10, 64); err == nil && d > 0 {
+			HistoryRetention = time.Duration(d * int64(24*time.Hour))
+		}
+	}
+
+	//history size
+	if len(os.Getenv("HISTORY_SIZE")) != 0 {
+		//if successful convert history size
+		if s, err := strconv.Atoi(os.Getenv("HISTORY_SIZE")); err == nil && s > 0 {
+			HistorySize = s
+		}
+	}
+}
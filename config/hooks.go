@@ -0,0 +1,88 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the inbound hooks of the third party systems.
+ * A hook maps the payload posted by a source to the event sent to the users selected from the payload
+ */
+
+//HookMapping maps the payloads posted by a third party source to the hooks endpoint to an event
+type HookMapping struct {
+	//Event sent to the users
+	Event string `json:"event"`
+	//Users is the jsonpath selecting the ids of the users from the payload. Eg. $.job.ownerId or $.recipients[*].id
+	Users string `json:"users"`
+	//Payload is the jsonpath selecting the payload of the event. The whole payload posted is sent if empty
+	Payload string `json:"payload,omitempty"`
+	//Secret is the shared secret with which the source signs the payload as hex(hmac-sha256(secret, timestamp + "." + body))
+	Secret string `json:"secret"`
+	//SignatureHeader is the header in which the source sends the signature. Defaults to X-Hub-Signature-256.
+	//The signature can be prefixed with sha256=
+	SignatureHeader string `json:"signatureHeader,omitempty"`
+	//TimestampHeader is the header in which the source sends the signed unix timestamp. Defaults to X-Hook-Timestamp
+	TimestampHeader string `json:"timestampHeader,omitempty"`
+	//Tenant to which the users of the source belong. The users last seen connected in another tenant or never seen
+	//are not notified. The users of all the tenants are notified if empty
+	Tenant string `json:"tenant,omitempty"`
+	//Rate is the max no. of requests per second from the source. Unlimited if zero
+	Rate float64 `json:"rate,omitempty"`
+	//Burst is the max no. of requests the source can make at once
+	Burst int `json:"burst,omitempty"`
+}
+
+const (
+	//DefaultHookSignatureHeader is the header having the signature of the hooks without a signature header
+	DefaultHookSignatureHeader = "X-Hub-Signature-256"
+	//DefaultHookTimestampHeader is the header having the signed timestamp of the hooks without a timestamp header
+	DefaultHookTimestampHeader = "X-Hook-Timestamp"
+)
+
+var (
+	//Hooks has the mappings of the inbound hooks mapped by the source name
+	Hooks = map[string]HookMapping{}
+	//HookMaxSkew is the max allowed difference between the signed timestamp of a hook request and now.
+	//The older requests are rejected so that a captured request can't be replayed
+	HookMaxSkew = time.Duration(5 * time.Minute)
+)
+
+func init() {
+	/*
+	 * We will init the hooks from the json config
+	 * We will init the max skew
+	 */
+	if len(os.Getenv("HOOKS")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("HOOKS")), &Hooks)
+		if err != nil {
+			log.Println("Error while parsing the hook mappings. Inbound hooks are disabled", err.Error())
+			Hooks = map[string]HookMapping{}
+		}
+	}
+	for source, h := range Hooks {
+		if len(h.SignatureHeader) == 0 {
+			h.SignatureHeader = DefaultHookSignatureHeader
+		}
+		if len(h.TimestampHeader) == 0 {
+			h.TimestampHeader = DefaultHookTimestampHeader
+		}
+		Hooks[source] = h
+	}
+
+	//max skew
+	if len(os.Getenv("HOOK_MAX_SKEW")) != 0 {
+		//if successful convert skew
+		if t, err := strconv.ParseInt(os.Getenv("HOOK_MAX_SKEW"), 10, 64); err == nil && t > 0 {
+			HookMaxSkew = time.Duration(t * int64(time.Second))
+		}
+	}
+}
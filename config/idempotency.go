@@ -0,0 +1,48 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the idempotency keys of the notification requests
+ */
+
+var (
+	//IdempotencyWindow is the time till which the result of a request with an idempotency key is kept.
+	//The requests repeated with the key within it get the original result
+	IdempotencyWindow = time.Duration(24 * time.Hour)
+	//IdempotencyLease is the time for which a request being processed holds its idempotency key. It is renewed
+	//while the request is processed, so the key of an instance dying midway is freed for the retries after it
+	IdempotencyLease = time.Duration(30 * time.Second)
+	//IdempotencyMaxBodySize is the max size of a request body with an idempotency key in bytes
+	IdempotencyMaxBodySize int64 = 1 << 20
+)
+
+func init() {
+	/*
+	 * We will init the idempotency window
+	 * We will init the idempotency lease
+	 */
+	//idempotency window
+	if len(os.Getenv("IDEMPOTENCY_WINDOW")) != 0 {
+		//if successful convert the window
+		if t, err := strconv.ParseInt(os.Getenv("IDEMPOTENCY_WINDOW"), 10, 64); err == nil && t > 0 {
+			IdempotencyWindow = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//idempotency lease
+	if len(os.Getenv("IDEMPOTENCY_LEASE")) != 0 {
+		//if successful convert the lease
+		if t, err := strconv.ParseInt(os.Getenv("IDEMPOTENCY_LEASE"), 10, 64); err == nil && t > 0 {
+			IdempotencyLease = time.Duration(t * int64(time.Second))
+		}
+	}
+}
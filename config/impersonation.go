@@ -0,0 +1,47 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the impersonation mode used by the support engineers
+ */
+
+var (
+	//ImpersonationRedactFields are the payload fields redacted in the impersonated view. The match is case insensitive
+	ImpersonationRedactFields = []string{"email", "phone", "password", "token"}
+	//ImpersonationMaxDuration is the time after which an impersonation is stopped automatically
+	ImpersonationMaxDuration = time.Duration(30 * time.Minute)
+)
+
+func init() {
+	/*
+	 * We will init the redact fields
+	 * We will init the max duration
+	 */
+	//redact fields
+	if len(os.Getenv("IMPERSONATION_REDACT_FIELDS")) != 0 {
+		ImpersonationRedactFields = []string{}
+		for _, f := range strings.Split(os.Getenv("IMPERSONATION_REDACT_FIELDS"), ",") {
+			if f = strings.TrimSpace(f); len(f) != 0 {
+				ImpersonationRedactFields = append(ImpersonationRedactFields, f)
+			}
+		}
+	}
+
+	//max duration
+	if len(os.Getenv("IMPERSONATION_MAX_DURATION")) != 0 {
+		//if successful convert max duration
+		if t, err := strconv.ParseInt(os.Getenv("IMPERSONATION_MAX_DURATION"), 10, 64); err == nil {
+			ImpersonationMaxDuration = time.Duration(t * int64(time.Minute))
+		}
+	}
+}
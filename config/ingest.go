@@ -0,0 +1,72 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the inbound event ingestion from the third party systems
+ */
+
+//IngestSource is a third party system allowed to push events through the ingestion endpoint
+type IngestSource struct {
+	//Key is the shared secret with which the source signs the request body
+	Key string `json:"key"`
+	//Events is the allowlist of the event names the source can push
+	Events []string `json:"events"`
+	//Rate is the max no. of requests per second from the source
+	Rate float64 `json:"rate"`
+	//Burst is the max no. of requests the source can make at once
+	Burst int `json:"burst"`
+}
+
+//Allowed reports whether the source can push the event
+func (i IngestSource) Allowed(event string) bool {
+	for _, e := range i.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	//IngestSources has the third party sources allowed to push events mapped by the source name
+	IngestSources = map[string]IngestSource{}
+	//IngestMaxSkew is the max allowed difference between the signed timestamp of an ingest request and now
+	IngestMaxSkew = time.Duration(5 * time.Minute)
+	//IngestMaxBodySize is the max size of an ingest request body in bytes
+	IngestMaxBodySize int64 = 1 << 20
+)
+
+func init() {
+	/*
+	 * We will init the ingest sources from the json config
+	 * We will init the max skew
+	 */
+	//ingest sources
+	if len(os.Getenv("INGEST_SOURCES")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("INGEST_SOURCES")), &IngestSources)
+		if err != nil {
+			log.Println("Error while parsing the ingest sources. Ingestion is disabled", err.Error())
+			IngestSources = map[string]IngestSource{}
+		}
+	}
+	SetCapability(CapabilityIngest, len(IngestSources) != 0)
+
+	//max skew
+	if len(os.Getenv("INGEST_MAX_SKEW")) != 0 {
+		//if successful convert skew
+		if t, err := strconv.ParseInt(os.Getenv("INGEST_MAX_SKEW"), 10, 64); err == nil {
+			IngestMaxSkew = time.Duration(t * int64(time.Second))
+		}
+	}
+}
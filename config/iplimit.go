@@ -0,0 +1,92 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the configuration of the rate limit on the http requests of an ip
+ */
+
+var (
+	//IPRateLimit is the max no. of http requests per second from an ip. 0 disables the limit
+	IPRateLimit = 50.0
+	//IPRateBurst is the max no. of http requests an ip can make at once
+	IPRateBurst = 100
+	//IPLimitersSize is the max no. of ips whose rate limiters are kept. The limiters of the least recently seen ips are evicted beyond it
+	IPLimitersSize = 10000
+	//TrustedProxies are the networks of the proxies whose X-Forwarded-For header is trusted for the client ip
+	TrustedProxies = []*net.IPNet{}
+)
+
+func init() {
+	/*
+	 * We will init the ip rate limit and the burst
+	 * We will init the size of the ip limiters
+	 * Then we will init the trusted proxies
+	 */
+	//ip rate limit
+	if len(os.Getenv("IP_RATE_LIMIT")) != 0 {
+		//if successful convert the rate
+		if r, err := strconv.ParseFloat(os.Getenv("IP_RATE_LIMIT"), 64); err == nil && r >= 0 {
+			IPRateLimit = r
+		}
+	}
+	if len(os.Getenv("IP_RATE_BURST")) != 0 {
+		//if successful convert the burst
+		if b, err := strconv.Atoi(os.Getenv("IP_RATE_BURST")); err == nil && b > 0 {
+			IPRateBurst = b
+		}
+	}
+
+	//ip limiters size
+	if len(os.Getenv("IP_LIMITERS_SIZE")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.Atoi(os.Getenv("IP_LIMITERS_SIZE")); err == nil && s > 0 {
+			IPLimitersSize = s
+		}
+	}
+
+	//trusted proxies
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Println("Ignoring the invalid trusted proxy", p, err.Error())
+			continue
+		}
+		TrustedProxies = append(TrustedProxies, n)
+	}
+}
+
+//TrustedProxy reports whether the ip is of a trusted proxy
+func TrustedProxy(ip string) bool {
+	i := net.ParseIP(ip)
+	if i == nil {
+		return false
+	}
+	for _, n := range TrustedProxies {
+		if n.Contains(i) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ * This file contains the configuration of the write ahead journal of the accepted notifications
+ */
+
+var (
+	//JournalPath is the path of the local journal file. Empty disables the journaling
+	JournalPath = ""
+	//JournalSync syncs every accepted notification to the disk before it is delivered
+	JournalSync = true
+	//JournalMaxSize is the size in bytes beyond which the journal is compacted to the pending notifications
+	JournalMaxSize = int64(64 << 20)
+)
+
+func init() {
+	/*
+	 * We will init the journal path
+	 * We will init the journal sync
+	 * We will init the journal max size
+	 */
+	//journal path
+	JournalPath = os.Getenv("JOURNAL_PATH")
+
+	//journal sync
+	if len(os.Getenv("JOURNAL_SYNC")) != 0 {
+		//if successful convert the flag
+		if s, err := strconv.ParseBool(os.Getenv("JOURNAL_SYNC")); err == nil {
+			JournalSync = s
+		}
+	}
+
+	//journal max size
+	if len(os.Getenv("JOURNAL_MAX_SIZE")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.ParseInt(os.Getenv("JOURNAL_MAX_SIZE"), 10, 64); err == nil && s > 0 {
+			JournalMaxSize = s
+		}
+	}
+}
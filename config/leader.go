@@ -0,0 +1,51 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the leader election for the singleton background workers
+ */
+
+const (
+	//LeaderElectionConsul elects the leader with a consul lock
+	LeaderElectionConsul = "consul"
+	//LeaderElectionPostgres elects the leader with a postgres advisory lock
+	LeaderElectionPostgres = "postgres"
+	//LeaderElectionNone makes every instance the leader. Use it only for the single instance deployments
+	LeaderElectionNone = "none"
+)
+
+var (
+	//LeaderElection is the mechanism used for the leader election
+	LeaderElection = LeaderElectionConsul
+	//LeaderRetry is the interval after which an instance retries to become the leader
+	LeaderRetry = time.Duration(10 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the leader election mechanism
+	 * We will init the leader retry interval
+	 */
+	//leader election
+	switch os.Getenv("LEADER_ELECTION") {
+	case LeaderElectionConsul, LeaderElectionPostgres, LeaderElectionNone:
+		LeaderElection = os.Getenv("LEADER_ELECTION")
+	}
+
+	//leader retry
+	if len(os.Getenv("LEADER_RETRY")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("LEADER_RETRY"), 10, 64); err == nil {
+			LeaderRetry = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -4,7 +4,12 @@
 
 package config
 
-/* This file contains the definitions of logger interface */
+import (
+	"os"
+	"strings"
+)
+
+/* This file contains the definitions of logger interface and the configuration of the logs */
 
 //Logger must be implemented by the logger utilities to be an app logger
 type Logger interface {
@@ -21,3 +26,39 @@ type Logger interface {
 	//GetID returns the ID of the logger
 	GetID() int
 }
+
+const (
+	//LogFormatText writes the logs as the free form text lines
+	LogFormatText = "text"
+	//LogFormatJSON writes the logs as a json object per line for the log aggregators
+	LogFormatJSON = "json"
+)
+
+var (
+	//LogFormat is the format of the logs. text or json
+	LogFormat = LogFormatText
+	//LogLevel is the min level of the logs written. debug, info, warn or error.
+	//Defaults to debug in the production switch and info otherwise
+	LogLevel = "info"
+)
+
+func init() {
+	/*
+	 * We will init the log format
+	 * We will init the log level
+	 */
+	//log format
+	switch os.Getenv("LOG_FORMAT") {
+	case LogFormatText, LogFormatJSON:
+		LogFormat = os.Getenv("LOG_FORMAT")
+	}
+
+	//log level
+	if PRODUCTION != 0 {
+		LogLevel = "debug"
+	}
+	switch l := strings.ToLower(os.Getenv("LOG_LEVEL")); l {
+	case "debug", "info", "warn", "error":
+		LogLevel = l
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the maintenance mode of the service
+ */
+
+//MaintenanceEvent is the event with which the connected clients are told about the maintenance mode
+const MaintenanceEvent = "maintenance"
+
+var (
+	//Maintenance puts the service into the maintenance mode when the instance starts, unless it is already in it
+	Maintenance = false
+	//MaintenanceMessage is the default message shown to the clients in the maintenance mode
+	MaintenanceMessage = "service is under maintenance"
+	//MaintenanceRefresh is the interval at which an instance reloads the maintenance mode from the store in the background
+	MaintenanceRefresh = time.Duration(5 * time.Second)
+	//MaintenanceQueueMax is the max no. of notifications queued in the maintenance mode. The notifications beyond it are rejected
+	MaintenanceQueueMax = 10000
+)
+
+func init() {
+	/*
+	 * We will init the maintenance flag and the message
+	 * We will init the maintenance refresh interval
+	 * We will init the max maintenance queue size
+	 */
+	//maintenance flag and message
+	Maintenance = os.Getenv("MAINTENANCE") == "true"
+	if len(os.Getenv("MAINTENANCE_MESSAGE")) != 0 {
+		MaintenanceMessage = os.Getenv("MAINTENANCE_MESSAGE")
+	}
+
+	//maintenance refresh
+	if len(os.Getenv("MAINTENANCE_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("MAINTENANCE_REFRESH"), 10, 64); err == nil && t > 0 {
+			MaintenanceRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//maintenance queue max
+	if len(os.Getenv("MAINTENANCE_QUEUE_MAX")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.Atoi(os.Getenv("MAINTENANCE_QUEUE_MAX")); err == nil && s > 0 {
+			MaintenanceQueueMax = s
+		}
+	}
+}
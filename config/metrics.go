@@ -0,0 +1,21 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import "os"
+
+/*
+ * This file contains the configuration of the metrics endpoint
+ */
+
+//MetricsToken is the bearer token the scrapers have to pass to the metrics endpoint. If empty, it is not checked
+var MetricsToken = ""
+
+func init() {
+	/*
+	 * We will init the metrics token
+	 */
+	MetricsToken = os.Getenv("METRICS_TOKEN")
+}
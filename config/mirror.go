@@ -0,0 +1,59 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the mirroring of the events of a user to a developer
+ */
+
+var (
+	//MirrorRequireConsent denotes whether the user has to consent before the events are mirrored
+	MirrorRequireConsent = true
+	//MirrorMaxDuration is the max time till which a mirror stays active after it is consented
+	MirrorMaxDuration = time.Duration(time.Hour)
+	//MirrorRefresh is the interval after which an instance reloads the mirrors from the store
+	MirrorRefresh = time.Duration(5 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the consent flag
+	 * We will init the max duration
+	 * We will init the refresh interval
+	 */
+	//consent flag
+	if len(os.Getenv("MIRROR_REQUIRE_CONSENT")) != 0 {
+		//if successful convert the flag
+		if b, err := strconv.ParseBool(os.Getenv("MIRROR_REQUIRE_CONSENT")); err == nil {
+			MirrorRequireConsent = b
+		}
+	}
+	if !MirrorRequireConsent {
+		log.Println("Mirroring without the consent of the users is enabled")
+	}
+
+	//max duration
+	if len(os.Getenv("MIRROR_MAX_DURATION")) != 0 {
+		//if successful convert max duration
+		if t, err := strconv.ParseInt(os.Getenv("MIRROR_MAX_DURATION"), 10, 64); err == nil && t > 0 {
+			MirrorMaxDuration = time.Duration(t * int64(time.Minute))
+		}
+	}
+
+	//refresh interval
+	if len(os.Getenv("MIRROR_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("MIRROR_REFRESH"), 10, 64); err == nil && t > 0 {
+			MirrorRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+/*
+ * This file contains the mutual tls configuration of the internal service endpoints.
+ * With the client ca given, the rpc port and the internal listener accept only the clients with a certificate signed by it
+ */
+
+var (
+	//InternalPort is the port of the internal listener serving the internal routes with mutual tls. It is disabled if empty
+	InternalPort = ""
+	//InternalClientCA is the path of the pem encoded ca bundle verifying the certificates of the internal services
+	InternalClientCA = ""
+	//InternalClientNames are the common or dns names of the client certificates allowed. Any client certificate signed by the ca is allowed if empty
+	InternalClientNames = []string{}
+	//internalCAs is the pool of the client ca. It is nil if mutual tls is disabled
+	internalCAs *x509.CertPool
+)
+
+//ErrClientNotAllowed is returned when the verified client certificate doesn't have any of the names allowed
+var ErrClientNotAllowed = errors.New("client certificate is not allowed")
+
+func init() {
+	/*
+	 * We will init the internal port, the client ca and the client names
+	 * The internal listener requires the client ca and the client ca requires tls
+	 * Then we will load the ca bundle
+	 */
+	InternalPort = os.Getenv("INTERNAL_PORT")
+	InternalClientCA = os.Getenv("INTERNAL_CLIENT_CA")
+	if len(os.Getenv("INTERNAL_CLIENT_NAMES")) != 0 {
+		//if successful split the names
+		for _, n := range strings.Split(os.Getenv("INTERNAL_CLIENT_NAMES"), ",") {
+			if n = strings.TrimSpace(n); len(n) != 0 {
+				InternalClientNames = append(InternalClientNames, n)
+			}
+		}
+	}
+	if len(InternalClientCA) == 0 {
+		if len(InternalPort) != 0 {
+			initFailed(PartConfig, "INTERNAL_CLIENT_CA", ErrMissing)
+		}
+		return
+	}
+
+	//checking the tls config. The tls config is loaded later, so the environment is checked
+	if len(os.Getenv("TLS_CERT")) == 0 && os.Getenv("AUTOCERT") != "true" {
+		initFailed(PartConfig, "INTERNAL_CLIENT_CA", errors.New("mutual tls requires TLS_CERT and TLS_KEY or AUTOCERT"))
+		return
+	}
+
+	//loading the ca bundle
+	pem, err := ioutil.ReadFile(InternalClientCA)
+	if err != nil {
+		initFailed(PartConfig, "INTERNAL_CLIENT_CA", err)
+		return
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		initFailed(PartConfig, "INTERNAL_CLIENT_CA", errors.New("no pem encoded certificate found in "+InternalClientCA))
+		return
+	}
+	internalCAs = pool
+}
+
+//MTLSEnabled reports whether the internal service endpoints require the client certificates
+func MTLSEnabled() bool {
+	return internalCAs != nil
+}
+
+//InternalTLSConfig returns the tls config of the internal service endpoints requiring and verifying the client certificates.
+//It is nil if mutual tls is disabled
+func InternalTLSConfig() *tls.Config {
+	c := TLSConfig()
+	if c == nil || internalCAs == nil {
+		return nil
+	}
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+	c.ClientCAs = internalCAs
+	if len(InternalClientNames) != 0 {
+		c.VerifyPeerCertificate = verifyClientName
+	}
+	return c
+}
+
+//verifyClientName checks whether the leaf of a verified chain has any of the client names allowed
+func verifyClientName(raw [][]byte, chains [][]*x509.Certificate) error {
+	for _, chain := range chains {
+		if len(chain) != 0 && InternalClientAllowed(chain[0]) {
+			return nil
+		}
+	}
+	return ErrClientNotAllowed
+}
+
+//InternalClientAllowed reports whether the client certificate has any of the client names allowed
+func InternalClientAllowed(cert *x509.Certificate) bool {
+	if len(InternalClientNames) == 0 {
+		return true
+	}
+	for _, n := range InternalClientNames {
+		if cert.Subject.CommonName == n {
+			return true
+		}
+		for _, d := range cert.DNSNames {
+			if d == n {
+				return true
+			}
+		}
+	}
+	return false
+}
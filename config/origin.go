@@ -0,0 +1,71 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+/*
+ * This file contains the origins allowed to open the websocket connections.
+ * Browsers send the origin of the page with the websocket handshakes. Checking it stops an arbitrary web page
+ * from opening a connection with the cookies of the user, ie. the cross-site websocket hijacking.
+ * An origin is given as the scheme and the host like https://app.cuttle.ai or only as the host to allow any scheme.
+ * The host can start with *. to allow its subdomains and * allows every origin.
+ */
+
+//AllowedOrigins are the origins allowed to open the websocket connections. Every origin is allowed if empty
+var AllowedOrigins = []string{}
+
+func init() {
+	/*
+	 * We will init the allowed origins
+	 */
+	for _, o := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		o = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(o)), "/")
+		if len(o) != 0 {
+			AllowedOrigins = append(AllowedOrigins, o)
+		}
+	}
+}
+
+//OriginAllowed reports whether the origin can open the websocket connections.
+//The requests without the origin header are not from the browsers and are allowed
+func OriginAllowed(origin string) bool {
+	/*
+	 * We will allow every origin if the allowed origins are not given
+	 * Then we will parse the scheme and the host of the origin
+	 * Then we will match it with the allowed origins
+	 */
+	if len(AllowedOrigins) == 0 || len(origin) == 0 {
+		return true
+	}
+
+	//parsing the origin
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return false
+	}
+
+	//matching the allowed origins
+	for _, a := range AllowedOrigins {
+		if a == "*" {
+			return true
+		}
+		host := a
+		if i := strings.Index(a, "://"); i >= 0 {
+			if a[:i] != u.Scheme {
+				continue
+			}
+			host = a[i+3:]
+		}
+		if host == u.Host || (strings.HasPrefix(host, "*.") && strings.HasSuffix(u.Host, host[1:])) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	defer func(old []string) { config.AllowedOrigins = old }(config.AllowedOrigins)
+
+	cases := []struct {
+		name    string
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{"empty allowlist allows every origin", []string{}, "https://evil.example", true},
+		{"no origin header", []string{"https://app.cuttle.ai"}, "", true},
+		{"exact origin", []string{"https://app.cuttle.ai"}, "https://app.cuttle.ai", true},
+		{"case insensitive", []string{"https://app.cuttle.ai"}, "HTTPS://App.Cuttle.AI", true},
+		{"other scheme", []string{"https://app.cuttle.ai"}, "http://app.cuttle.ai", false},
+		{"other port", []string{"https://app.cuttle.ai"}, "https://app.cuttle.ai:8443", false},
+		{"host allows any scheme", []string{"app.cuttle.ai"}, "http://app.cuttle.ai", true},
+		{"other host", []string{"https://app.cuttle.ai"}, "https://evil.example", false},
+		{"host as a prefix", []string{"https://app.cuttle.ai"}, "https://app.cuttle.ai.evil.example", false},
+		{"wildcard subdomain", []string{"*.cuttle.ai"}, "https://reports.cuttle.ai", true},
+		{"wildcard nested subdomain", []string{"https://*.cuttle.ai"}, "https://a.b.cuttle.ai", true},
+		{"wildcard doesn't match the apex", []string{"*.cuttle.ai"}, "https://cuttle.ai", false},
+		{"wildcard doesn't match a suffix", []string{"*.cuttle.ai"}, "https://evilcuttle.ai", false},
+		{"wildcard with the other scheme", []string{"https://*.cuttle.ai"}, "http://reports.cuttle.ai", false},
+		{"star allows every origin", []string{"https://app.cuttle.ai", "*"}, "https://evil.example", true},
+		{"null origin", []string{"https://app.cuttle.ai"}, "null", false},
+		{"origin without a host", []string{"https://app.cuttle.ai"}, "https://", false},
+	}
+	for _, c := range cases {
+		config.AllowedOrigins = c.allowed
+		if got := config.OriginAllowed(c.origin); got != c.ok {
+			t.Errorf("%s: expected the origin %q to be allowed %t. got %t", c.name, c.origin, c.ok, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the opentelemetry tracing.
+ * The standard OTEL env variables are used so that the service is configured like the other traced services
+ */
+
+var (
+	//OTelEndpoint is the base url of the OTLP http collector to which the spans are exported.
+	//Spans are not exported if empty, but the trace context is still propagated
+	OTelEndpoint = ""
+	//OTelServiceName is the name of the service in the exported spans
+	OTelServiceName = "websockets"
+	//OTelSampleRatio is the ratio of the new traces sampled. The traces started by the callers follow their sampling
+	OTelSampleRatio = 1.0
+	//OTelQueueSize is the max no. of spans waiting to be exported. Spans are dropped when it is full
+	OTelQueueSize = 2048
+	//OTelBatchSize is the max no. of spans exported at once
+	OTelBatchSize = 512
+	//OTelExportInterval is the interval at which the spans are exported
+	OTelExportInterval = time.Duration(5 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the collector endpoint
+	 * We will init the service name
+	 * We will init the sample ratio
+	 * We will init the queue and batch sizes
+	 * We will init the export interval
+	 */
+	//endpoint
+	if len(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) != 0 {
+		OTelEndpoint = strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/")
+	}
+
+	//service name
+	if len(os.Getenv("OTEL_SERVICE_NAME")) != 0 {
+		OTelServiceName = os.Getenv("OTEL_SERVICE_NAME")
+	}
+
+	//sample ratio
+	if len(os.Getenv("OTEL_TRACES_SAMPLER_ARG")) != 0 {
+		//if successful convert the ratio
+		if r, err := strconv.ParseFloat(os.Getenv("OTEL_TRACES_SAMPLER_ARG"), 64); err == nil && r >= 0 && r <= 1 {
+			OTelSampleRatio = r
+		}
+	}
+
+	//queue size
+	if len(os.Getenv("OTEL_BSP_MAX_QUEUE_SIZE")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.Atoi(os.Getenv("OTEL_BSP_MAX_QUEUE_SIZE")); err == nil && s > 0 {
+			OTelQueueSize = s
+		}
+	}
+
+	//batch size
+	if len(os.Getenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.Atoi(os.Getenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE")); err == nil && s > 0 {
+			OTelBatchSize = s
+		}
+	}
+
+	//export interval
+	if len(os.Getenv("OTEL_BSP_SCHEDULE_DELAY")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("OTEL_BSP_SCHEDULE_DELAY"), 10, 64); err == nil && t > 0 {
+			OTelExportInterval = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+}
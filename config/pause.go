@@ -0,0 +1,60 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of pausing the delivery of the events
+ */
+
+const (
+	//PauseQueue queues the events published while their delivery is paused. They are delivered on resume
+	PauseQueue = "queue"
+	//PauseDrop drops the events published while their delivery is paused
+	PauseDrop = "drop"
+)
+
+var (
+	//PauseMode is the default mode of a pause. queue or drop
+	PauseMode = PauseQueue
+	//PauseRefresh is the time after which an instance reloads the paused events from the store
+	PauseRefresh = time.Duration(5 * time.Second)
+	//PauseQueueMax is the max no. of events queued for a paused event. The events beyond it are dropped
+	PauseQueueMax = 10000
+)
+
+func init() {
+	/*
+	 * We will init the pause mode
+	 * We will init the pause refresh interval
+	 * We will init the max pause queue size
+	 */
+	//pause mode
+	switch os.Getenv("PAUSE_MODE") {
+	case PauseQueue, PauseDrop:
+		PauseMode = os.Getenv("PAUSE_MODE")
+	}
+
+	//pause refresh
+	if len(os.Getenv("PAUSE_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("PAUSE_REFRESH"), 10, 64); err == nil && t > 0 {
+			PauseRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//pause queue max
+	if len(os.Getenv("PAUSE_QUEUE_MAX")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.Atoi(os.Getenv("PAUSE_QUEUE_MAX")); err == nil && s > 0 {
+			PauseQueueMax = s
+		}
+	}
+}
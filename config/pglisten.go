@@ -0,0 +1,35 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+/*
+ * This file contains the configuration of the postgres channels listened to.
+ * The payloads notified on the channels, eg. by the triggers, are sent to the clients as events
+ */
+
+//PGListenChannels has the mappings of the postgres channels listened to mapped by the channel
+var PGListenChannels = map[string]EventMapping{}
+
+func init() {
+	/*
+	 * We will init the channels from the json config
+	 */
+	if len(os.Getenv("PG_LISTEN_CHANNELS")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("PG_LISTEN_CHANNELS")), &PGListenChannels)
+		if err != nil {
+			log.Println("Error while parsing the postgres listen channels. No channel is listened to", err.Error())
+			PGListenChannels = map[string]EventMapping{}
+		}
+	}
+	for channel, m := range PGListenChannels {
+		PGListenChannels[channel] = m.withDefaults(channel)
+	}
+}
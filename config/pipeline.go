@@ -0,0 +1,56 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the alarms of the delivery pipeline
+ */
+
+var (
+	//PipelineCheck is the interval at which the stages and the queues of the pipeline are checked for the alarms
+	PipelineCheck = time.Duration(10 * time.Second)
+	//PipelineStallAfter is the time after which a stage with events in flight and no progress is alarmed as stalled.
+	//It is also the time for which a queue has to stay backed up to be alarmed
+	PipelineStallAfter = time.Duration(30 * time.Second)
+	//PipelineQueueAlarm is the fraction of the capacity of a queue above which it is backed up
+	PipelineQueueAlarm = 0.9
+)
+
+func init() {
+	/*
+	 * We will init the check interval
+	 * We will init the stall duration
+	 * We will init the queue alarm fraction
+	 */
+	//check interval
+	if len(os.Getenv("PIPELINE_CHECK")) != 0 {
+		//if successful convert check interval
+		if c, err := strconv.ParseInt(os.Getenv("PIPELINE_CHECK"), 10, 64); err == nil && c > 0 {
+			PipelineCheck = time.Duration(c * int64(time.Second))
+		}
+	}
+
+	//stall duration
+	if len(os.Getenv("PIPELINE_STALL_AFTER")) != 0 {
+		//if successful convert stall duration
+		if s, err := strconv.ParseInt(os.Getenv("PIPELINE_STALL_AFTER"), 10, 64); err == nil && s > 0 {
+			PipelineStallAfter = time.Duration(s * int64(time.Second))
+		}
+	}
+
+	//queue alarm fraction
+	if len(os.Getenv("PIPELINE_QUEUE_ALARM")) != 0 {
+		//if successful convert fraction
+		if f, err := strconv.ParseFloat(os.Getenv("PIPELINE_QUEUE_ALARM"), 64); err == nil && f > 0 && f <= 1 {
+			PipelineQueueAlarm = f
+		}
+	}
+}
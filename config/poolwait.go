@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the wait queue of the app context pool and the retry of the rejected requests
+ */
+
+var (
+	//PoolWaitTimeout is the max time a request waits for an app context once the pool is exhausted. 0 rejects the request right away
+	PoolWaitTimeout = time.Duration(0)
+	//PoolRetryAfter is the time after which the clients rejected as the pool is exhausted are asked to retry
+	PoolRetryAfter = time.Duration(time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the pool wait timeout
+	 * We will init the pool retry after
+	 */
+	//pool wait timeout
+	if len(os.Getenv("POOL_WAIT_TIMEOUT")) != 0 {
+		//if successful convert the timeout
+		if t, err := strconv.ParseInt(os.Getenv("POOL_WAIT_TIMEOUT"), 10, 64); err == nil && t >= 0 {
+			PoolWaitTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//pool retry after
+	if len(os.Getenv("POOL_RETRY_AFTER")) != 0 {
+		//if successful convert the duration
+		if t, err := strconv.ParseInt(os.Getenv("POOL_RETRY_AFTER"), 10, 64); err == nil && t > 0 {
+			PoolRetryAfter = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+}
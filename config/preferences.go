@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the notification preferences of the users
+ */
+
+var (
+	//PreferencesRefresh is the time after which an instance reloads the cached preferences of a user from the database
+	PreferencesRefresh = time.Duration(30 * time.Second)
+	//PreferencesCacheSize is the max no. of users whose preferences are cached. The least recently used are evicted beyond it
+	PreferencesCacheSize = 10000
+)
+
+func init() {
+	/*
+	 * We will init the preferences refresh interval
+	 * We will init the preferences cache size
+	 */
+	//preferences refresh
+	if len(os.Getenv("PREFERENCES_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("PREFERENCES_REFRESH"), 10, 64); err == nil && t > 0 {
+			PreferencesRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//preferences cache size
+	if len(os.Getenv("PREFERENCES_CACHE_SIZE")) != 0 {
+		//if successful convert the size
+		if n, err := strconv.Atoi(os.Getenv("PREFERENCES_CACHE_SIZE")); err == nil && n > 0 {
+			PreferencesCacheSize = n
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the presence of the users
+ */
+
+var (
+	//PresenceTTL is the time after which the presence kept by an instance expires unless refreshed.
+	//It bounds the time for which the users of a crashed instance are reported online
+	PresenceTTL = time.Duration(time.Minute)
+	//PresenceMaxUsers is the max no. of users whose presence is queried or subscribed at once
+	PresenceMaxUsers = 1000
+)
+
+func init() {
+	/*
+	 * We will init the presence ttl
+	 * We will init the max users of a presence query
+	 */
+	//presence ttl
+	if len(os.Getenv("PRESENCE_TTL")) != 0 {
+		//if successful convert the ttl
+		if t, err := strconv.ParseInt(os.Getenv("PRESENCE_TTL"), 10, 64); err == nil && t > 0 {
+			PresenceTTL = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//max users
+	if len(os.Getenv("PRESENCE_MAX_USERS")) != 0 {
+		//if successful convert the no. of users
+		if m, err := strconv.Atoi(os.Getenv("PRESENCE_MAX_USERS")); err == nil && m > 0 {
+			PresenceMaxUsers = m
+		}
+	}
+}
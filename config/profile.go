@@ -0,0 +1,77 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+ * This file contains the build profiles of the binary. The heavy optional subsystems are compiled in
+ * only by the profiles needing them, so the default binary stays small.
+ *   minimal  - built with -tags minimal. Only the websocket, http and rpc transports
+ *   standard - the default build. Adds the grpc api
+ *   full     - built with -tags full. Adds the heavy integrations like the push providers and the brokers
+ * A subsystem has its wiring in the files with the build tags of the profiles compiling it
+ * and registers itself with RegisterSubsystem in their init.
+ */
+
+const (
+	//ProfileMinimal is the build profile without the optional subsystems
+	ProfileMinimal = "minimal"
+	//ProfileStandard is the default build profile
+	ProfileStandard = "standard"
+	//ProfileFull is the build profile with all the optional subsystems
+	ProfileFull = "full"
+)
+
+const (
+	//SubsystemGRPC is the grpc api of the notification service
+	SubsystemGRPC = "grpc"
+	//SubsystemACME is the automatic management of the tls certificates with ACME
+	SubsystemACME = "acme"
+	//SubsystemWebPush is the web push fallback for the offline users
+	SubsystemWebPush = "webpush"
+	//SubsystemMobilePush is the fcm and apns push bridge for the mobile devices
+	SubsystemMobilePush = "mobilepush"
+	//SubsystemNATS is the nats subject bridge
+	SubsystemNATS = "nats"
+	//SubsystemAMQP is the amqp consumer ingesting the notifications
+	SubsystemAMQP = "amqp"
+)
+
+var (
+	//subsystems has the optional subsystems compiled into the binary
+	subsystems = map[string]bool{}
+	//subsystemsLock is the lock for the subsystems
+	subsystemsLock sync.RWMutex
+)
+
+//RegisterSubsystem marks the optional subsystem as compiled into the binary
+func RegisterSubsystem(name string) {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+	subsystems[name] = true
+}
+
+//Compiled reports whether the optional subsystem is compiled into the binary
+func Compiled(name string) bool {
+	subsystemsLock.RLock()
+	defer subsystemsLock.RUnlock()
+	return subsystems[name]
+}
+
+//Subsystems returns the optional subsystems compiled into the binary in the sorted order
+func Subsystems() []string {
+	subsystemsLock.RLock()
+	defer subsystemsLock.RUnlock()
+	result := make([]string, 0, len(subsystems))
+	for s := range subsystems {
+		result = append(result, s)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,11 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build full && !minimal
+// +build full,!minimal
+
+package config
+
+//BuildProfile is the build profile of the binary
+const BuildProfile = ProfileFull
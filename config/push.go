@@ -0,0 +1,152 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the push notifications sent as the fallback to the offline users
+ */
+
+var (
+	//WebPush enables the web push notifications to the browsers of the users without a live connection
+	WebPush = false
+	//VapidPath is the kv v2 path in vault under which the vapid keys of the web push are stored
+	VapidPath = "secret/data/websockets/vapid"
+	//VapidPrivateKey is the base64 url encoded vapid private key used when the vault is skipped
+	VapidPrivateKey = ""
+	//VapidSubject is the contact of the service sent to the push services. Eg. mailto:ops@cuttle.ai
+	VapidSubject = "mailto:admin@cuttle.ai"
+	//FCM enables the push notifications to the mobile devices through firebase cloud messaging
+	FCM = false
+	//FCMPath is the kv v2 path in vault under which the fcm service account json is stored as serviceAccount
+	FCMPath = "secret/data/websockets/fcm"
+	//FCMServiceAccount is the fcm service account json used when the vault is skipped
+	FCMServiceAccount = ""
+	//APNS enables the push notifications to the apple devices through apns
+	APNS = false
+	//APNSPath is the kv v2 path in vault under which the pem encoded .p8 apns signing key is stored as key
+	APNSPath = "secret/data/websockets/apns"
+	//APNSKey is the pem encoded .p8 apns signing key used when the vault is skipped
+	APNSKey = ""
+	//APNSKeyID is the id of the apns signing key
+	APNSKeyID = ""
+	//APNSTeamID is the id of the apple developer team
+	APNSTeamID = ""
+	//APNSTopic is the bundle id of the ios app
+	APNSTopic = ""
+	//APNSSandbox sends the apns notifications to the development environment
+	APNSSandbox = false
+	//PushTTL is the time for which the push services keep a push notification for an unreachable device.
+	//The ttl of the notification is used if it has one
+	PushTTL = time.Duration(24 * time.Hour)
+	//PushHosts are the hosts of the web push services allowed as the subscription endpoints.
+	//A host starting with *. allows its subdomains
+	PushHosts = []string{"fcm.googleapis.com", "updates.push.services.mozilla.com", "*.push.apple.com", "*.notify.windows.com"}
+	//PushWorkers is the no. of workers sending the push notifications
+	PushWorkers = 8
+	//PushQueueSize is the max no. of push notifications waiting to be sent. The notifications beyond are dropped
+	PushQueueSize = 10000
+)
+
+func init() {
+	/*
+	 * We will init the web push flag
+	 * We will init the vapid keys path, the private key and the subject
+	 * We will init the fcm config
+	 * We will init the apns config
+	 * We will init the push ttl
+	 * We will init the allowed web push hosts
+	 * We will init the no. of workers and the queue size
+	 */
+	//web push
+	if os.Getenv("WEB_PUSH") == "true" {
+		WebPush = true
+	}
+
+	//vapid keys
+	if len(os.Getenv("VAPID_PATH")) != 0 {
+		VapidPath = os.Getenv("VAPID_PATH")
+	}
+	if len(os.Getenv("VAPID_PRIVATE_KEY")) != 0 {
+		VapidPrivateKey = os.Getenv("VAPID_PRIVATE_KEY")
+	}
+	if len(os.Getenv("VAPID_SUBJECT")) != 0 {
+		VapidSubject = os.Getenv("VAPID_SUBJECT")
+	}
+
+	//fcm
+	if os.Getenv("FCM") == "true" {
+		FCM = true
+	}
+	if len(os.Getenv("FCM_PATH")) != 0 {
+		FCMPath = os.Getenv("FCM_PATH")
+	}
+	if len(os.Getenv("FCM_SERVICE_ACCOUNT")) != 0 {
+		FCMServiceAccount = os.Getenv("FCM_SERVICE_ACCOUNT")
+	}
+
+	//apns
+	if os.Getenv("APNS") == "true" {
+		APNS = true
+	}
+	if len(os.Getenv("APNS_PATH")) != 0 {
+		APNSPath = os.Getenv("APNS_PATH")
+	}
+	if len(os.Getenv("APNS_KEY")) != 0 {
+		APNSKey = os.Getenv("APNS_KEY")
+	}
+	if len(os.Getenv("APNS_KEY_ID")) != 0 {
+		APNSKeyID = os.Getenv("APNS_KEY_ID")
+	}
+	if len(os.Getenv("APNS_TEAM_ID")) != 0 {
+		APNSTeamID = os.Getenv("APNS_TEAM_ID")
+	}
+	if len(os.Getenv("APNS_TOPIC")) != 0 {
+		APNSTopic = os.Getenv("APNS_TOPIC")
+	}
+	if os.Getenv("APNS_SANDBOX") == "true" {
+		APNSSandbox = true
+	}
+
+	//push ttl
+	if len(os.Getenv("PUSH_TTL")) != 0 {
+		//if successful convert the ttl
+		if t, err := strconv.ParseInt(os.Getenv("PUSH_TTL"), 10, 64); err == nil && t >= 0 {
+			PushTTL = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//push hosts
+	if len(os.Getenv("PUSH_HOSTS")) != 0 {
+		PushHosts = []string{}
+		for _, h := range strings.Split(os.Getenv("PUSH_HOSTS"), ",") {
+			if h = strings.TrimSpace(h); len(h) != 0 {
+				PushHosts = append(PushHosts, h)
+			}
+		}
+	}
+
+	//workers
+	if len(os.Getenv("PUSH_WORKERS")) != 0 {
+		//if successful convert workers
+		if w, err := strconv.Atoi(os.Getenv("PUSH_WORKERS")); err == nil && w > 0 {
+			PushWorkers = w
+		}
+	}
+
+	//queue size
+	if len(os.Getenv("PUSH_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if q, err := strconv.Atoi(os.Getenv("PUSH_QUEUE_SIZE")); err == nil && q > 0 {
+			PushQueueSize = q
+		}
+	}
+}
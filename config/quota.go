@@ -0,0 +1,188 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/limiter"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the per namespace quotas of the websockets server.
+ * A namespace can be capped by the max no. of connections and the max no. of events per second it handles.
+ * So an experimental feature namespace can't starve the core notifications namespace on a shared instance.
+ * The quotas are enforced by wrapping the handlers registered for the namespace.
+ */
+
+//NamespaceQuota is the quota of a namespace
+type NamespaceQuota struct {
+	//MaxConnections is the max no. of connections to the namespace. 0 means unlimited
+	MaxConnections int `json:"maxConnections"`
+	//EventRate is the max no. of events handled per second in the namespace. 0 means unlimited
+	EventRate float64 `json:"eventRate"`
+	//EventBurst is the max no. of events handled at once in the namespace. Defaults to the event rate
+	EventBurst int `json:"eventBurst"`
+}
+
+//ErrNamespaceFull is returned to the client when the namespace has reached its max connections
+var ErrNamespaceFull = errors.New("namespace has reached its max connections. Please try after some time")
+
+var (
+	//NamespaceQuotas has the quotas mapped by the namespace
+	NamespaceQuotas = map[string]NamespaceQuota{}
+	//namespaceConns has the ids of the connections of each namespace having a quota
+	namespaceConns = map[string]map[string]bool{}
+	//namespaceBuckets has the event rate limiters of the namespaces
+	namespaceBuckets = map[string]*limiter.Bucket{}
+	//namespaceLock is the lock for the namespace connections
+	namespaceLock sync.Mutex
+)
+
+func init() {
+	/*
+	 * We will init the namespace quotas from the json config
+	 * Then we will create the event rate limiters
+	 */
+	if len(os.Getenv("NAMESPACE_QUOTAS")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("NAMESPACE_QUOTAS")), &NamespaceQuotas)
+		if err != nil {
+			log.Println("Error while parsing the namespace quotas. Quotas are disabled", err.Error())
+			NamespaceQuotas = map[string]NamespaceQuota{}
+		}
+	}
+	SetCapability(CapabilityNamespaceQuotas, len(NamespaceQuotas) != 0)
+	for ns, q := range NamespaceQuotas {
+		if q.EventRate <= 0 {
+			continue
+		}
+		burst := q.EventBurst
+		if burst <= 0 {
+			burst = int(q.EventRate)
+		}
+		namespaceBuckets[ns] = limiter.NewBucket(q.EventRate, burst)
+	}
+}
+
+//NamespaceConnections returns the no. of connections of the namespaces having a quota
+func NamespaceConnections() map[string]int {
+	namespaceLock.Lock()
+	defer namespaceLock.Unlock()
+	result := map[string]int{}
+	for ns, conns := range namespaceConns {
+		result[ns] = len(conns)
+	}
+	return result
+}
+
+//resetNamespaceConns forgets the connections of all the namespaces. Called when the websockets server is restarted
+func resetNamespaceConns() {
+	namespaceLock.Lock()
+	defer namespaceLock.Unlock()
+	namespaceConns = map[string]map[string]bool{}
+}
+
+//quotaOnConnect wraps the connect handler of the namespace to enforce the max connections
+func quotaOnConnect(namespace string, f func(socketio.Conn) error) func(socketio.Conn) error {
+	q, ok := NamespaceQuotas[namespace]
+	if !ok || q.MaxConnections <= 0 {
+		return f
+	}
+	return func(conn socketio.Conn) error {
+		namespaceLock.Lock()
+		conns, ok := namespaceConns[namespace]
+		if !ok {
+			conns = map[string]bool{}
+			namespaceConns[namespace] = conns
+		}
+		if len(conns) >= q.MaxConnections {
+			namespaceLock.Unlock()
+			log.Println("rejecting the connection", conn.ID(), "as the namespace", namespace, "has reached its max connections")
+			return ErrNamespaceFull
+		}
+		conns[conn.ID()] = true
+		namespaceLock.Unlock()
+		err := f(conn)
+		if err != nil {
+			releaseNamespaceConn(namespace, conn)
+		}
+		return err
+	}
+}
+
+//warnRateLimited sends the rate limited reason to the connection unless it was sent within the retry duration
+func warnRateLimited(conn socketio.Conn) {
+	appCtx, ok := conn.Context().(*AppContext)
+	if !ok {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&appCtx.rateLimitedAt)
+	if now-last < int64(CloseRateLimited.RetryAfterMs)*int64(time.Millisecond) ||
+		!atomic.CompareAndSwapInt64(&appCtx.rateLimitedAt, last, now) {
+		return
+	}
+	Warn(conn, CloseRateLimited)
+}
+
+//releaseNamespaceConn removes the connection from the connections of the namespace
+func releaseNamespaceConn(namespace string, conn socketio.Conn) {
+	namespaceLock.Lock()
+	defer namespaceLock.Unlock()
+	delete(namespaceConns[namespace], conn.ID())
+}
+
+//quotaOnDisconnect wraps the disconnect handler of the namespace to release the connection from the quota
+func quotaOnDisconnect(namespace string, f func(socketio.Conn, string)) func(socketio.Conn, string) {
+	q, ok := NamespaceQuotas[namespace]
+	if !ok || q.MaxConnections <= 0 {
+		return f
+	}
+	return func(conn socketio.Conn, message string) {
+		releaseNamespaceConn(namespace, conn)
+		if f != nil {
+			f(conn, message)
+		}
+	}
+}
+
+//quotaOnEvent wraps the event handler of the namespace to enforce the event rate.
+//The events beyond the rate are dropped and the handler returns the zero values.
+//The client is told about the dropped events at most once in the retry duration of the rate limited reason
+func quotaOnEvent(namespace, event string, evtHandler interface{}) interface{} {
+	b, ok := namespaceBuckets[namespace]
+	if !ok {
+		return evtHandler
+	}
+	fv := reflect.ValueOf(evtHandler)
+	if fv.Kind() != reflect.Func {
+		return evtHandler
+	}
+	ft := fv.Type()
+	return reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+		if b.Allow() {
+			return fv.Call(args)
+		}
+		log.Println("dropping the event", event, "as the namespace", namespace, "has exceeded its event rate")
+		if len(args) != 0 {
+			if conn, ok := args[0].Interface().(socketio.Conn); ok {
+				warnRateLimited(conn)
+			}
+		}
+		out := make([]reflect.Value, ft.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(ft.Out(i))
+		}
+		return out
+	}).Interface()
+}
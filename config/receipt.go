@@ -0,0 +1,58 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the delivery receipts sent to the producer callbacks
+ */
+
+var (
+	//ReceiptCallbackHosts are the hosts allowed as the receipt callbacks. Receipts are disabled if empty
+	ReceiptCallbackHosts = []string{}
+	//ReceiptTimeout is the timeout for sending a receipt to the callback
+	ReceiptTimeout = time.Duration(5 * time.Second)
+	//ReceiptQueueSize is the max no. of receipts waiting to be sent. Receipts beyond are dropped
+	ReceiptQueueSize = 1000
+)
+
+func init() {
+	/*
+	 * We will init the allowed callback hosts
+	 * We will init the timeout
+	 * We will init the queue size
+	 */
+	//allowed callback hosts
+	if len(os.Getenv("RECEIPT_CALLBACK_HOSTS")) != 0 {
+		for _, h := range strings.Split(os.Getenv("RECEIPT_CALLBACK_HOSTS"), ",") {
+			if h = strings.TrimSpace(h); len(h) != 0 {
+				ReceiptCallbackHosts = append(ReceiptCallbackHosts, h)
+			}
+		}
+	}
+	SetCapability(CapabilityReceipts, len(ReceiptCallbackHosts) != 0)
+
+	//timeout
+	if len(os.Getenv("RECEIPT_TIMEOUT")) != 0 {
+		//if successful convert timeout
+		if t, err := strconv.ParseInt(os.Getenv("RECEIPT_TIMEOUT"), 10, 64); err == nil {
+			ReceiptTimeout = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//queue size
+	if len(os.Getenv("RECEIPT_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if s, err := strconv.Atoi(os.Getenv("RECEIPT_QUEUE_SIZE")); err == nil {
+			ReceiptQueueSize = s
+		}
+	}
+}
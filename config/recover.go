@@ -0,0 +1,85 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the recovery of the panics in the route and event handlers.
+ * A panic is logged with its stack and counted by the handler so that it is reported in the metrics.
+ * The request or the event fails instead of the server.
+ */
+
+var (
+	//panics has the no. of panics recovered mapped by the handler
+	panics = map[string]uint64{}
+	//panicsLock is the lock for the panics
+	panicsLock sync.Mutex
+)
+
+//Panicked logs the recovered panic of the handler with the stack and counts it
+func Panicked(handler string, r interface{}) {
+	log.Println("PANIC: handler", handler, "panicked", r, "\n"+string(debug.Stack()))
+	panicsLock.Lock()
+	defer panicsLock.Unlock()
+	panics[handler]++
+}
+
+//Panic is the no. of panics recovered in a handler
+type Panic struct {
+	//Handler that panicked. Eg. route:/notification/send or event:/:join
+	Handler string
+	//Count is the no. of panics
+	Count uint64
+}
+
+//Panics returns the no. of panics recovered in each handler sorted by the handler
+func Panics() []Panic {
+	panicsLock.Lock()
+	defer panicsLock.Unlock()
+	result := make([]Panic, 0, len(panics))
+	for h, c := range panics {
+		result = append(result, Panic{Handler: h, Count: c})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Handler < result[j].Handler })
+	return result
+}
+
+//recoverOnEvent wraps the event handler of the namespace to recover from a panic in it.
+//The client is sent the internal error reason and the handler returns the zero values
+func recoverOnEvent(namespace, event string, evtHandler interface{}) interface{} {
+	fv := reflect.ValueOf(evtHandler)
+	if fv.Kind() != reflect.Func {
+		return evtHandler
+	}
+	ft := fv.Type()
+	return reflect.MakeFunc(ft, func(args []reflect.Value) (out []reflect.Value) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			Panicked("event:"+namespace+":"+event, r)
+			if len(args) != 0 {
+				if conn, ok := args[0].Interface().(socketio.Conn); ok {
+					Warn(conn, CloseInternalError)
+				}
+			}
+			out = make([]reflect.Value, ft.NumOut())
+			for i := range out {
+				out[i] = reflect.Zero(ft.Out(i))
+			}
+		}()
+		return fv.Call(args)
+	}).Interface()
+}
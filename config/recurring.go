@@ -0,0 +1,33 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the recurring notifications
+ */
+
+var (
+	//RecurringCheck is the interval at which the due recurring notifications are checked
+	RecurringCheck = time.Duration(15 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the recurring check interval
+	 */
+	//recurring check
+	if len(os.Getenv("RECURRING_CHECK")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("RECURRING_CHECK"), 10, 64); err == nil && t > 0 {
+			RecurringCheck = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"time"
+)
+
+/*
+ * This file contains the background refresh of the caches of the shared state, like the paused events, the rules
+ * and the api keys. The requests are served from the cache without touching the store or the database. The cache is
+ * reloaded in the background at an interval and swapped in once loaded. While the reload fails, the interval backs off
+ * and the cache loaded last is served.
+ */
+
+//refreshMaxBackoff is the max interval to which a failing refresh backs off
+const refreshMaxBackoff = 5 * time.Minute
+
+//Refresh runs the load of the cache in the background as a supervised worker with the name.
+//It loads at once and then after every interval. The interval is doubled while the load fails
+func Refresh(name string, interval time.Duration, load func() error) {
+	SuperviseWorkers("refresh-"+name, 1, func() {
+		wait := interval
+		for {
+			if err := load(); err != nil {
+				if wait *= 2; wait > refreshMaxBackoff {
+					wait = refreshMaxBackoff
+				}
+				if wait < interval {
+					wait = interval
+				}
+				log.Println("error while refreshing the", name, "cache. retrying in", wait, err.Error())
+			} else {
+				wait = interval
+			}
+			time.Sleep(wait)
+		}
+	})
+}
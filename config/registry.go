@@ -0,0 +1,30 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ * This file contains the configuration of the registry of the app contexts and the websocket connections
+ */
+
+//RegistryShards is the no. of shards of the registry. The requests to the registry are served by as many go routines
+var RegistryShards = 16
+
+func init() {
+	/*
+	 * We will init the no. of registry shards
+	 */
+	//registry shards
+	if len(os.Getenv("REGISTRY_SHARDS")) != 0 {
+		//if successful convert the no. of shards
+		if s, err := strconv.Atoi(os.Getenv("REGISTRY_SHARDS")); err == nil && s > 0 {
+			RegistryShards = s
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the app context slots reserved for the internal services.
+ * The reserved slots are outside the MaxRequests pool of the users
+ */
+
+var (
+	//ReservedRequests is the no. of app context slots which can be reserved by the internal services
+	ReservedRequests = 100
+	//MaxReservation is the max no. of slots reserved by a service
+	MaxReservation = 20
+	//ReservationTTL is the time after which a reservation not renewed by the service is released
+	ReservationTTL = time.Duration(time.Hour)
+)
+
+func init() {
+	/*
+	 * We will init the no. of reservable slots
+	 * We will init the max reservation of a service
+	 * We will init the reservation ttl
+	 */
+	//reservable slots
+	if len(os.Getenv("RESERVED_REQUESTS")) != 0 {
+		//if successful convert the no. of slots
+		if r, err := strconv.Atoi(os.Getenv("RESERVED_REQUESTS")); err == nil && r >= 0 {
+			ReservedRequests = r
+		}
+	}
+
+	//max reservation
+	if len(os.Getenv("MAX_RESERVATION")) != 0 {
+		//if successful convert the no. of slots
+		if r, err := strconv.Atoi(os.Getenv("MAX_RESERVATION")); err == nil && r >= 0 {
+			MaxReservation = r
+		}
+	}
+
+	//reservation ttl
+	if len(os.Getenv("RESERVATION_TTL")) != 0 {
+		//if successful convert the ttl
+		if t, err := strconv.ParseInt(os.Getenv("RESERVATION_TTL"), 10, 64); err == nil && t > 0 {
+			ReservationTTL = time.Duration(t * int64(time.Minute))
+		}
+	}
+}
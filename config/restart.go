@@ -0,0 +1,157 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+/*
+ * This file contains the rolling restart coordinator.
+ * Before draining, an instance acquires the restart lock in consul so that only one instance drains at a time.
+ * Holding the lock, it waits till the capacity of the other healthy instances is above the floor.
+ * The lock is released once the instance has drained.
+ */
+
+var (
+	//RestartCoordination enables the coordination of the restarts with the other instances
+	RestartCoordination = false
+	//RestartLockKey is the consul kv key used as the restart lock
+	RestartLockKey = "service/websockets/restart-lock"
+	//RestartCapacityFloor is the min aggregate connection capacity of the other healthy instances
+	//to be available before an instance can drain
+	RestartCapacityFloor = 0
+	//RestartWaitTimeout is the max time an instance waits for its turn to restart
+	RestartWaitTimeout = time.Duration(5 * time.Minute)
+	//RestartCapacityCheck is the interval at which the capacity of the other instances is checked
+	RestartCapacityCheck = time.Duration(5 * time.Second)
+)
+
+//ErrRestartTimeout is returned when the instance couldn't get its turn to restart in time
+var ErrRestartTimeout = errors.New("timed out while waiting for the turn to restart")
+
+func init() {
+	/*
+	 * We will init the restart coordination switch
+	 * We will init the restart lock key
+	 * We will init the capacity floor
+	 * We will init the wait timeout
+	 */
+	//restart coordination
+	if os.Getenv("RESTART_COORDINATION") == "true" {
+		RestartCoordination = true
+	}
+
+	//restart lock key
+	if len(os.Getenv("RESTART_LOCK_KEY")) != 0 {
+		RestartLockKey = os.Getenv("RESTART_LOCK_KEY")
+	}
+
+	//capacity floor
+	if len(os.Getenv("RESTART_CAPACITY_FLOOR")) != 0 {
+		//if successful convert the floor
+		if c, err := strconv.Atoi(os.Getenv("RESTART_CAPACITY_FLOOR")); err == nil {
+			RestartCapacityFloor = c
+		}
+	}
+
+	//wait timeout
+	if len(os.Getenv("RESTART_WAIT_TIMEOUT")) != 0 {
+		//if successful convert timeout
+		if t, err := strconv.ParseInt(os.Getenv("RESTART_WAIT_TIMEOUT"), 10, 64); err == nil {
+			RestartWaitTimeout = time.Duration(t * int64(time.Second))
+		}
+	}
+}
+
+//OthersCapacity returns the aggregate connection capacity of the other healthy instances of the service
+func OthersCapacity() (int, error) {
+	entries, _, err := discoveryClient.Health().Service(WebsocketsServerID, "", true, nil)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, e := range entries {
+		if e.Service == nil || (e.Service.Address == ServiceDomain && e.Service.Port == IntPort) {
+			continue
+		}
+		c, err := strconv.Atoi(e.Service.Meta[CapacityMetaKey])
+		if err != nil {
+			c = MaxRequests
+		}
+		total += c
+	}
+	return total, nil
+}
+
+//CoordinateRestart blocks till it is the turn of this instance to drain and restart.
+//It returns the release func to be called once the instance has drained.
+//If the coordination is disabled, it returns right away
+func CoordinateRestart() (func(), error) {
+	/*
+	 * We will create the lock
+	 * We will wait for the lock till the timeout
+	 * Holding the lock, we will wait till the other instances have enough capacity
+	 */
+	noop := func() {}
+	if !RestartCoordination || discoveryClient == nil {
+		return noop, nil
+	}
+
+	//creating the lock
+	lock, err := discoveryClient.LockOpts(&api.LockOptions{
+		Key:         RestartLockKey,
+		Value:       []byte(ServiceDomain + ":" + Port),
+		SessionName: WebsocketsServerID + "-restart",
+		SessionTTL:  "30s",
+	})
+	if err != nil {
+		return noop, err
+	}
+
+	//waiting for the lock
+	stop := make(chan struct{})
+	timer := time.AfterFunc(RestartWaitTimeout, func() { close(stop) })
+	defer timer.Stop()
+	log.Println("Waiting for the restart lock", RestartLockKey)
+	lost, err := lock.Lock(stop)
+	if err != nil {
+		return noop, err
+	}
+	if lost == nil {
+		return noop, ErrRestartTimeout
+	}
+	release := func() {
+		if err := lock.Unlock(); err != nil {
+			log.Println("Error while releasing the restart lock", err.Error())
+		}
+	}
+
+	//waiting for the capacity of the other instances
+	for {
+		c, err := OthersCapacity()
+		if err == nil && c >= RestartCapacityFloor {
+			log.Println("Acquired the restart lock. Capacity of the other instances is", c)
+			return release, nil
+		}
+		if err != nil {
+			log.Println("Error while checking the capacity of the other instances", err.Error())
+		}
+		select {
+		case <-time.After(RestartCapacityCheck):
+		case <-lost:
+			return noop, errors.New("lost the restart lock while waiting for the capacity")
+		case <-stop:
+			release()
+			return noop, ErrRestartTimeout
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the access control of the rooms.
+ * A room named with the user prefix and the id of a user, eg. user:42:drafts, can be joined only by that user.
+ * The other rooms can be joined only if the role of the user is allowed by the acl entry of the longest matching prefix.
+ * The rooms without a matching acl entry can be joined only by the admins.
+ */
+
+//RoomUserPrefix is the prefix of the private rooms of a user. It is followed by the user id and a colon
+const RoomUserPrefix = "user:"
+
+//RoomAnyRole allows every role of the tenant in an acl entry
+const RoomAnyRole = "*"
+
+//RoomACL has the roles allowed to join the rooms mapped by the prefix of the room name
+var RoomACL = map[string][]string{}
+
+func init() {
+	/*
+	 * We will init the room acl from the json config
+	 */
+	//room acl
+	if len(os.Getenv("ROOM_ACL")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("ROOM_ACL")), &RoomACL)
+		if err != nil {
+			log.Println("Error while parsing the room acl. Only the admins and the private rooms of the users can be joined", err.Error())
+			RoomACL = map[string][]string{}
+		}
+	}
+}
+
+//CanJoinRoom reports whether the user with the role can join the room
+func CanJoinRoom(userID uint, role, room string) bool {
+	/*
+	 * The admins can join every room
+	 * The private rooms can be joined only by their user
+	 * Then we will check the role against the acl entry with the longest matching prefix
+	 */
+	if IsAdmin(userID) {
+		return true
+	}
+	if strings.HasPrefix(room, RoomUserPrefix) {
+		return strings.HasPrefix(room, RoomUserPrefix+strconv.FormatUint(uint64(userID), 10)+":")
+	}
+	prefix, found := "", false
+	for p := range RoomACL {
+		if strings.HasPrefix(room, p) && (!found || len(p) > len(prefix)) {
+			prefix, found = p, true
+		}
+	}
+	if !found {
+		return false
+	}
+	for _, r := range RoomACL[prefix] {
+		if r == RoomAnyRole || (len(role) != 0 && r == role) {
+			return true
+		}
+	}
+	return false
+}
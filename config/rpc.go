@@ -0,0 +1,100 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/version"
+)
+
+/*
+ * This file contains the rpc methods for the health and capability introspection of the instance
+ * and the registry of the rpc services of the other packages
+ */
+
+//RPCSendToken is the token the internal services have to pass to send the notifications over rpc.
+//If empty, the notifications can't be sent over rpc
+var RPCSendToken = ""
+
+func init() {
+	/*
+	 * We will init the rpc send token
+	 */
+	RPCSendToken = os.Getenv("RPC_SEND_TOKEN")
+	if len(RPCSendToken) == 0 {
+		log.Println("RPC_SEND_TOKEN is not set. The notifications sent over rpc will be rejected")
+	}
+}
+
+var (
+	//rpcServices has the rpc services registered by the other packages
+	rpcServices []interface{}
+	//rpcServicesLock is the lock for the rpc services
+	rpcServicesLock sync.Mutex
+)
+
+//RegisterRPC registers the rpc service to be served by the rpc service. It has to be called before StartRPC
+func RegisterRPC(rcvr interface{}) {
+	rpcServicesLock.Lock()
+	defer rpcServicesLock.Unlock()
+	rpcServices = append(rpcServices, rcvr)
+}
+
+//RPCHealth has the rpc methods to verify the liveness and the capabilities of the instance
+type RPCHealth struct{}
+
+//startedAt is the time at which the instance started
+var startedAt = time.Now()
+
+//HealthArgs is the argument of the health rpc methods
+type HealthArgs struct {
+	//Caller is the name of the service calling the method. Used only for logging
+	Caller string
+}
+
+//PingReply is the reply of the ping rpc method
+type PingReply struct {
+	//Alive is true if the instance is alive
+	Alive bool
+	//Uptime is the time since the instance started
+	Uptime time.Duration
+	//WebSocketsRestarts is the no. of times the websockets server was restarted after its serve loop exited
+	WebSocketsRestarts int
+	//WebSocketsError is the last error with which the serve loop of the websockets server exited
+	WebSocketsError string
+}
+
+//VersionReply is the reply of the version rpc method
+type VersionReply struct {
+	//AppName is the name of the application
+	AppName string
+	//Version is the version of the application
+	Version version.Version
+}
+
+//Ping replies if the instance is alive along with its uptime
+func (r *RPCHealth) Ping(args HealthArgs, reply *PingReply) error {
+	reply.Alive = true
+	reply.Uptime = time.Since(startedAt)
+	reply.WebSocketsRestarts, reply.WebSocketsError = WebSocketsHealth()
+	return nil
+}
+
+//Version replies with the version of the application
+func (r *RPCHealth) Version(args HealthArgs, reply *VersionReply) error {
+	reply.AppName = version.AppName
+	reply.Version = version.Default
+	return nil
+}
+
+//Capabilities replies with the optional capabilities of the instance and whether they are enabled
+func (r *RPCHealth) Capabilities(args HealthArgs, reply *map[string]bool) error {
+	*reply = Capabilities()
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the routing rules of the notifications
+ */
+
+var (
+	//RulesRefresh is the interval at which an instance reloads the routing rules from the store in the background
+	RulesRefresh = time.Duration(10 * time.Second)
+	//MaxRules is the max no. of routing rules
+	MaxRules = 500
+)
+
+func init() {
+	/*
+	 * We will init the rules refresh interval
+	 * We will init the max no. of rules
+	 */
+	//rules refresh
+	if len(os.Getenv("RULES_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("RULES_REFRESH"), 10, 64); err == nil && t > 0 {
+			RulesRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//max rules
+	if len(os.Getenv("MAX_RULES")) != 0 {
+		//if successful convert the no. of rules
+		if m, err := strconv.Atoi(os.Getenv("MAX_RULES")); err == nil && m > 0 {
+			MaxRules = m
+		}
+	}
+}
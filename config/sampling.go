@@ -0,0 +1,57 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the sampling of the high volume events
+ */
+
+//SamplingPolicy is the sampling policy of an event. The latest event held back by the sampling is always delivered
+//once the events of the user go quiet for the sampling flush interval
+type SamplingPolicy struct {
+	//OneIn delivers 1 in every N events to a user. 0 or 1 delivers every event
+	OneIn int `json:"oneIn"`
+	//PerMinute is the max no. of events delivered to a user per minute. 0 means unlimited
+	PerMinute int `json:"perMinute"`
+}
+
+var (
+	//SamplingPolicies has the sampling policies mapped by the event
+	SamplingPolicies = map[string]SamplingPolicy{}
+	//SamplingFlush is the time for which the events of a user have to be quiet before the latest held event is delivered
+	SamplingFlush = time.Duration(time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the sampling policies from the json config
+	 * We will init the sampling flush interval
+	 */
+	//sampling policies
+	if len(os.Getenv("SAMPLING_POLICIES")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("SAMPLING_POLICIES")), &SamplingPolicies)
+		if err != nil {
+			log.Println("Error while parsing the sampling policies. Sampling is disabled", err.Error())
+			SamplingPolicies = map[string]SamplingPolicy{}
+		}
+	}
+	SetCapability(CapabilitySampling, len(SamplingPolicies) != 0)
+
+	//sampling flush
+	if len(os.Getenv("SAMPLING_FLUSH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("SAMPLING_FLUSH"), 10, 64); err == nil && t > 0 {
+			SamplingFlush = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the configuration of the internal services of the platform.
+ * The internal services authenticate with their service users and can send notifications to any user.
+ */
+
+//ServiceUserIDs has the ids of the users with which the internal services authenticate
+var ServiceUserIDs = map[uint]bool{}
+
+func init() {
+	/*
+	 * We will init the service user ids from the comma separated list
+	 */
+	for _, id := range strings.Split(os.Getenv("SERVICE_USER_IDS"), ",") {
+		//if successful convert the user id
+		if u, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64); err == nil {
+			ServiceUserIDs[uint(u)] = true
+		}
+	}
+}
+
+//IsService reports whether the user with given id is an internal service
+func IsService(userID uint) bool {
+	return ServiceUserIDs[userID]
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the local cache of the sessions validated by the auth provider
+ * and of the revocations shared by the instances
+ */
+
+var (
+	//SessionCacheTTL is the time for which a session validated by the auth service is cached. 0 disables the cache
+	SessionCacheTTL = time.Duration(30 * time.Second)
+	//SessionCacheSize is the max no. of sessions cached
+	SessionCacheSize = 10000
+	//RevocationRefresh is the interval at which an instance applies the session revocations published by the other instances
+	RevocationRefresh = time.Duration(2 * time.Second)
+	//RevocationRetention is the time for which a published session revocation is kept in the store for the instances to apply it
+	RevocationRetention = time.Duration(5 * time.Minute)
+)
+
+func init() {
+	/*
+	 * We will init the session cache ttl
+	 * We will init the session cache size
+	 * We will init the revocation refresh interval and retention
+	 */
+	//session cache ttl
+	if len(os.Getenv("SESSION_CACHE_TTL")) != 0 {
+		//if successful convert the ttl
+		if t, err := strconv.ParseInt(os.Getenv("SESSION_CACHE_TTL"), 10, 64); err == nil && t >= 0 {
+			SessionCacheTTL = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//session cache size
+	if len(os.Getenv("SESSION_CACHE_SIZE")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.Atoi(os.Getenv("SESSION_CACHE_SIZE")); err == nil && s > 0 {
+			SessionCacheSize = s
+		}
+	}
+
+	//revocation refresh
+	if len(os.Getenv("REVOCATION_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("REVOCATION_REFRESH"), 10, 64); err == nil && t > 0 {
+			RevocationRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//revocation retention
+	if len(os.Getenv("REVOCATION_RETENTION")) != 0 {
+		//if successful convert the retention
+		if t, err := strconv.ParseInt(os.Getenv("REVOCATION_RETENTION"), 10, 64); err == nil && t > 0 {
+			RevocationRetention = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the configuration of the shadow mode which mirrors a sample of the inbound notifications
+ * to a staging instance through its ingestion endpoint
+ */
+
+var (
+	//ShadowURL is the url of the ingestion endpoint of the staging instance. Shadow mode is disabled if empty
+	ShadowURL = ""
+	//ShadowSource is the ingest source name with which the mirrored notifications are signed
+	ShadowSource = "shadow"
+	//ShadowKey is the ingest source key with which the mirrored notifications are signed
+	ShadowKey = ""
+	//ShadowSampleRate is the fraction of the inbound notifications mirrored to the staging instance
+	ShadowSampleRate = 0.01
+	//ShadowScrubFields are the payload fields whose values are scrubbed before mirroring. The match is case insensitive
+	ShadowScrubFields = []string{"email", "phone", "password", "token", "name"}
+	//ShadowQueueSize is the max no. of mirrored notifications waiting to be sent. Notifications beyond are dropped
+	ShadowQueueSize = 1000
+)
+
+func init() {
+	/*
+	 * We will init the shadow url, source and key
+	 * We will init the sample rate
+	 * We will init the scrub fields
+	 * We will init the queue size
+	 */
+	//shadow url, source and key
+	if len(os.Getenv("SHADOW_URL")) != 0 {
+		ShadowURL = os.Getenv("SHADOW_URL")
+	}
+	SetCapability(CapabilityShadow, len(ShadowURL) != 0)
+	if len(os.Getenv("SHADOW_SOURCE")) != 0 {
+		ShadowSource = os.Getenv("SHADOW_SOURCE")
+	}
+	if len(os.Getenv("SHADOW_KEY")) != 0 {
+		ShadowKey = os.Getenv("SHADOW_KEY")
+	}
+
+	//sample rate
+	if len(os.Getenv("SHADOW_SAMPLE_RATE")) != 0 {
+		//if successful convert sample rate
+		if r, err := strconv.ParseFloat(os.Getenv("SHADOW_SAMPLE_RATE"), 64); err == nil && r >= 0 && r <= 1 {
+			ShadowSampleRate = r
+		}
+	}
+
+	//scrub fields
+	if len(os.Getenv("SHADOW_SCRUB_FIELDS")) != 0 {
+		ShadowScrubFields = []string{}
+		for _, f := range strings.Split(os.Getenv("SHADOW_SCRUB_FIELDS"), ",") {
+			if f = strings.TrimSpace(f); len(f) != 0 {
+				ShadowScrubFields = append(ShadowScrubFields, f)
+			}
+		}
+	}
+
+	//queue size
+	if len(os.Getenv("SHADOW_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if s, err := strconv.Atoi(os.Getenv("SHADOW_QUEUE_SIZE")); err == nil {
+			ShadowQueueSize = s
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the signed notification requests of the internal services.
+ * The shared secrets are loaded from vault along with the other config values. During a rotation,
+ * both the new and the old secrets are given so that the senders can move to the new secret.
+ */
+
+var (
+	//SigningSecrets are the shared secrets with which the internal services sign the notification requests. Newest first
+	SigningSecrets = []string{}
+	//SigningUserID is the service user to which the signed notification requests are attributed. It is added to the ServiceUserIDs
+	SigningUserID uint
+	//SigningMaxSkew is the max allowed difference between the signed timestamp of a notification request and now
+	SigningMaxSkew = time.Duration(5 * time.Minute)
+	//SigningMaxBodySize is the max size of a signed notification request body in bytes
+	SigningMaxBodySize int64 = 1 << 20
+)
+
+func init() {
+	/*
+	 * We will init the signing secrets from the comma separated list
+	 * The signed requests require the service user to which they are attributed. It is registered as a service user
+	 * so that the signed requests can send to the other users
+	 * We will init the max skew
+	 */
+	//signing secrets
+	for _, s := range strings.Split(os.Getenv("NOTIFICATION_SIGNING_SECRETS"), ",") {
+		if s = strings.TrimSpace(s); len(s) != 0 {
+			SigningSecrets = append(SigningSecrets, s)
+		}
+	}
+	SetCapability(CapabilitySignedSend, len(SigningSecrets) != 0)
+
+	//signing user
+	if len(SigningSecrets) != 0 {
+		//if successful convert the user id
+		u, err := strconv.ParseUint(os.Getenv("NOTIFICATION_SIGNING_USER"), 10, 64)
+		if err != nil {
+			initFailed(PartConfig, "NOTIFICATION_SIGNING_USER", err)
+		}
+		SigningUserID = uint(u)
+		ServiceUserIDs[SigningUserID] = true
+	}
+
+	//max skew
+	if len(os.Getenv("NOTIFICATION_SIGNING_MAX_SKEW")) != 0 {
+		//if successful convert skew
+		if t, err := strconv.ParseInt(os.Getenv("NOTIFICATION_SIGNING_MAX_SKEW"), 10, 64); err == nil {
+			SigningMaxSkew = time.Duration(t * int64(time.Second))
+		}
+	}
+}
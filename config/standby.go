@@ -0,0 +1,142 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ * This file contains the warm standby mode of the instance.
+ * A standby instance registers with the discovery service with its services in the maintenance mode,
+ * so no traffic is routed to it, while its health checks keep passing. It keeps its caches warm and doesn't
+ * campaign for the singleton workers. It is promoted to active with an admin call during an incident,
+ * which only takes the services out of the maintenance mode.
+ */
+
+//StandbyReason is the maintenance reason with which the services of a standby instance are registered
+const StandbyReason = "warm standby"
+
+var (
+	//StandbyWarmInterval is the interval at which the caches of the standby instance are refreshed
+	StandbyWarmInterval = time.Duration(30 * time.Second)
+	//standby reports whether the instance is in the standby mode
+	standby = false
+	//promoted is closed when the instance is active. It is closed right away if the instance isn't a standby
+	promoted = make(chan struct{})
+	//warmers are the funcs warming the caches mapped by the name
+	warmers = map[string]func(){}
+	//standbyLock is the lock for the standby state and the warmers
+	standbyLock sync.Mutex
+)
+
+//Standby reports whether the instance is a standby yet to be promoted
+func Standby() bool {
+	standbyLock.Lock()
+	defer standbyLock.Unlock()
+	return standby
+}
+
+//Active returns a channel closed once the instance is active. The singleton workers wait on it
+func Active() <-chan struct{} {
+	return promoted
+}
+
+//RegisterWarmer registers the func warming a cache while the instance is a standby
+func RegisterWarmer(name string, f func()) {
+	standbyLock.Lock()
+	defer standbyLock.Unlock()
+	warmers[name] = f
+}
+
+//enterStandby puts the services registered with the discovery service in the maintenance mode if the instance is a standby
+func enterStandby() error {
+	if !Standby() {
+		return nil
+	}
+	for _, id := range serviceIDs() {
+		if err := discoveryClient.Agent().EnableServiceMaintenance(id, StandbyReason); err != nil {
+			return &InitError{Part: PartDiscovery, Key: id, Err: err}
+		}
+	}
+	log.Println("Registered with the discovery service as a standby")
+	return nil
+}
+
+//Promote promotes the standby instance to active by taking its services out of the maintenance mode.
+//It reports false if the instance wasn't a standby
+func Promote() (bool, error) {
+	standbyLock.Lock()
+	defer standbyLock.Unlock()
+	if !standby {
+		return false, nil
+	}
+	if discoveryClient != nil {
+		for _, id := range serviceIDs() {
+			if err := discoveryClient.Agent().DisableServiceMaintenance(id); err != nil {
+				return false, err
+			}
+		}
+	}
+	standby = false
+	close(promoted)
+	return true, nil
+}
+
+//WarmStandby runs the warmers at the warm interval till the standby instance is promoted
+func WarmStandby() {
+	if !Standby() {
+		return
+	}
+	go func() {
+		t := time.NewTicker(StandbyWarmInterval)
+		defer t.Stop()
+		for {
+			standbyLock.Lock()
+			fs := make([]func(), 0, len(warmers))
+			for _, f := range warmers {
+				fs = append(fs, f)
+			}
+			standbyLock.Unlock()
+			for _, f := range fs {
+				f()
+			}
+			select {
+			case <-promoted:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func init() {
+	/*
+	 * We will init the standby mode
+	 * We will init the warm interval
+	 */
+	//standby mode
+	if len(os.Getenv("STANDBY")) != 0 {
+		//if successful convert the flag
+		if s, err := strconv.ParseBool(os.Getenv("STANDBY")); err == nil {
+			standby = s
+		}
+	}
+	if !standby {
+		close(promoted)
+	}
+
+	//warm interval
+	if len(os.Getenv("STANDBY_WARM_INTERVAL")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("STANDBY_WARM_INTERVAL"), 10, 64); err == nil && t > 0 {
+			StandbyWarmInterval = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the storage backend of the offline queues, dedup stores and presence state
+ */
+
+const (
+	//StoreBackendMemory keeps the state in the memory of the instance. Use it only for the single instance deployments
+	StoreBackendMemory = "memory"
+	//StoreBackendRedis keeps the state in redis
+	StoreBackendRedis = "redis"
+	//StoreBackendPostgres keeps the state in the postgres database of the service
+	StoreBackendPostgres = "postgres"
+)
+
+var (
+	//StoreBackend is the storage backend
+	StoreBackend = StoreBackendMemory
+	//StoreFallback lets the service run with the memory store when the configured backend can't be opened.
+	//The state is then not shared across the instances, so it is off by default and the service fails to start
+	StoreFallback = false
+	//StorePurgeCheck is the time after which the expired keys and queues are purged from the store
+	StorePurgeCheck = time.Duration(time.Minute)
+	//RedisAddr is the address of the redis server
+	RedisAddr = "127.0.0.1:6379"
+	//RedisPassword is the password of the redis server
+	RedisPassword = ""
+	//RedisDB is the redis database to use
+	RedisDB = 0
+	//RedisKeyPrefix is the prefix of all the keys stored by the service in redis
+	RedisKeyPrefix = "websockets:"
+	//RedisPoolSize is the max no. of idle connections to the redis server
+	RedisPoolSize = 10
+)
+
+func init() {
+	/*
+	 * We will init the store backend and the fallback
+	 * We will init the purge check interval
+	 * We will init the redis address, password, db and key prefix
+	 * We will init the redis pool size
+	 */
+	//store backend
+	switch os.Getenv("STORE_BACKEND") {
+	case StoreBackendMemory, StoreBackendRedis, StoreBackendPostgres:
+		StoreBackend = os.Getenv("STORE_BACKEND")
+	}
+	StoreFallback = os.Getenv("STORE_FALLBACK") == "true"
+
+	//purge check
+	if len(os.Getenv("STORE_PURGE_CHECK")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("STORE_PURGE_CHECK"), 10, 64); err == nil && t > 0 {
+			StorePurgeCheck = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//redis address, password, db and key prefix
+	if len(os.Getenv("REDIS_ADDR")) != 0 {
+		RedisAddr = os.Getenv("REDIS_ADDR")
+	}
+	if len(os.Getenv("REDIS_PASSWORD")) != 0 {
+		RedisPassword = os.Getenv("REDIS_PASSWORD")
+	}
+	if len(os.Getenv("REDIS_DB")) != 0 {
+		//if successful convert db
+		if d, err := strconv.Atoi(os.Getenv("REDIS_DB")); err == nil {
+			RedisDB = d
+		}
+	}
+	if len(os.Getenv("REDIS_KEY_PREFIX")) != 0 {
+		RedisKeyPrefix = os.Getenv("REDIS_KEY_PREFIX")
+	}
+
+	//redis pool size
+	if len(os.Getenv("REDIS_POOL_SIZE")) != 0 {
+		//if successful convert pool size
+		if s, err := strconv.Atoi(os.Getenv("REDIS_POOL_SIZE")); err == nil {
+			RedisPoolSize = s
+		}
+	}
+}
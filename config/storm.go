@@ -0,0 +1,66 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the absorption of the reconnect storms.
+ * The clients closed together are asked to reconnect after a jittered delay spread over the reconnect spread.
+ * The handshakes are admitted at the handshake rate. The ones beyond it wait in the admission queue
+ * and are shed with a reconnect hint once the queue is full.
+ */
+
+var (
+	//ReconnectSpread is the duration over which the reconnect hints of the connections closed together are spread
+	ReconnectSpread = time.Duration(10 * time.Second)
+	//HandshakeRate is the max no. of websocket handshakes admitted per second. 0 admits all the handshakes
+	HandshakeRate = 200.0
+	//HandshakeBurst is the max no. of websocket handshakes admitted at once
+	HandshakeBurst = 200
+	//HandshakeQueueSize is the max no. of websocket handshakes waiting for the admission
+	HandshakeQueueSize = 2000
+)
+
+func init() {
+	/*
+	 * We will init the reconnect spread
+	 * We will init the handshake rate and the burst
+	 * Then we will init the handshake queue size
+	 */
+	//reconnect spread
+	if len(os.Getenv("RECONNECT_SPREAD")) != 0 {
+		//if successful convert the spread
+		if t, err := strconv.ParseInt(os.Getenv("RECONNECT_SPREAD"), 10, 64); err == nil && t >= 0 {
+			ReconnectSpread = time.Duration(t * int64(time.Millisecond))
+		}
+	}
+
+	//handshake rate
+	if len(os.Getenv("HANDSHAKE_RATE")) != 0 {
+		//if successful convert the rate
+		if r, err := strconv.ParseFloat(os.Getenv("HANDSHAKE_RATE"), 64); err == nil && r >= 0 {
+			HandshakeRate = r
+		}
+	}
+	if len(os.Getenv("HANDSHAKE_BURST")) != 0 {
+		//if successful convert the burst
+		if b, err := strconv.Atoi(os.Getenv("HANDSHAKE_BURST")); err == nil && b > 0 {
+			HandshakeBurst = b
+		}
+	}
+
+	//handshake queue size
+	if len(os.Getenv("HANDSHAKE_QUEUE_SIZE")) != 0 {
+		//if successful convert the size
+		if s, err := strconv.Atoi(os.Getenv("HANDSHAKE_QUEUE_SIZE")); err == nil && s >= 0 {
+			HandshakeQueueSize = s
+		}
+	}
+}
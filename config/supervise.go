@@ -0,0 +1,158 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the supervision of the websockets server.
+ * If the serve loop of the server exits due to an internal error, the server silently stops accepting the events.
+ * So the serve loop is supervised. When it exits, the error is logged and recorded and a new server is created
+ * with all the registered namespaces and handlers after a backoff.
+ * The handlers must get the current server with WebSocketsServer as the server changes after a restart.
+ */
+
+const (
+	//websocketsMinBackoff is the initial backoff before restarting the websockets server
+	websocketsMinBackoff = time.Second
+	//websocketsMaxBackoff is the max backoff before restarting the websockets server
+	websocketsMaxBackoff = 30 * time.Second
+	//websocketsStableAfter is the time after which a restarted server is considered stable and the backoff is reset
+	websocketsStableAfter = time.Minute
+)
+
+var (
+	//websocketsRegistrations has the registrations of the namespaces and handlers. They are replayed on a restart
+	websocketsRegistrations []func(*socketio.Server)
+	//websocketsDisconnects has the namespaces having a disconnect handler registered
+	websocketsDisconnects = map[string]bool{}
+	//websocketsRestarts is the no. of times the websockets server was restarted
+	websocketsRestarts int
+	//websocketsError is the last error with which the serve loop exited
+	websocketsError string
+	//websocketsServing is set while the serve loop of the server is running
+	websocketsServing bool
+	//websocketsClosing is set when the websockets server is closed for the shutdown
+	websocketsClosing bool
+	//websocketsLock is the lock for the websockets server of the root app context and its supervision state
+	websocketsLock sync.RWMutex
+)
+
+//WebSocketsServer returns the current websockets server
+func WebSocketsServer() *socketio.Server {
+	websocketsLock.RLock()
+	defer websocketsLock.RUnlock()
+	return rootAppContext.WebSockets
+}
+
+//WebSocketsHealth returns the no. of times the websockets server was restarted and the last error of its serve loop
+func WebSocketsHealth() (int, string) {
+	websocketsLock.RLock()
+	defer websocketsLock.RUnlock()
+	return websocketsRestarts, websocketsError
+}
+
+//CloseWebSockets closes the websockets server for the shutdown. It won't be restarted after this
+func CloseWebSockets() error {
+	websocketsLock.Lock()
+	defer websocketsLock.Unlock()
+	websocketsClosing = true
+	if rootAppContext.WebSockets == nil {
+		return nil
+	}
+	return rootAppContext.WebSockets.Close()
+}
+
+//registerWebSockets records the registration and applies it to the current server
+func registerWebSockets(f func(*socketio.Server)) {
+	websocketsLock.Lock()
+	defer websocketsLock.Unlock()
+	websocketsRegistrations = append(websocketsRegistrations, f)
+	if rootAppContext.WebSockets != nil {
+		f(rootAppContext.WebSockets)
+	}
+}
+
+//serveWebSockets runs the serve loop of the server. A panic in the loop is returned as an error
+func serveWebSockets(s *socketio.Server) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("websockets server panicked: %v", r)
+		}
+	}()
+	err = s.Serve()
+	if err == nil {
+		err = errors.New("websockets server stopped serving")
+	}
+	return err
+}
+
+//superviseWebSockets runs the server and restarts it whenever its serve loop exits till the server is closed
+func superviseWebSockets(s *socketio.Server) {
+	/*
+	 * We will run the serve loop
+	 * If the server was closed for the shutdown, we will stop
+	 * Else we will record the error and wait for the backoff
+	 * Then we will create a new server with the registered namespaces and handlers and swap it in
+	 */
+	backoff := websocketsMinBackoff
+	for {
+		started := time.Now()
+		websocketsLock.Lock()
+		websocketsServing = true
+		websocketsLock.Unlock()
+		err := serveWebSockets(s)
+
+		websocketsLock.Lock()
+		websocketsServing = false
+		if websocketsClosing {
+			websocketsLock.Unlock()
+			return
+		}
+		websocketsError = err.Error()
+		websocketsLock.Unlock()
+
+		//waiting for the backoff
+		if time.Since(started) > websocketsStableAfter {
+			backoff = websocketsMinBackoff
+		}
+		log.Println("websockets server exited. restarting it in", backoff, err.Error())
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > websocketsMaxBackoff {
+			backoff = websocketsMaxBackoff
+		}
+
+		//creating the new server
+		ns, nErr := socketio.NewServer(nil)
+		if nErr != nil {
+			log.Println("error while creating the websockets server for the restart", nErr.Error())
+			continue
+		}
+		websocketsLock.Lock()
+		if websocketsClosing {
+			websocketsLock.Unlock()
+			ns.Close()
+			return
+		}
+		for _, f := range websocketsRegistrations {
+			f(ns)
+		}
+		old := rootAppContext.WebSockets
+		rootAppContext.WebSockets = ns
+		resetNamespaceConns()
+		websocketsRestarts++
+		websocketsLock.Unlock()
+		old.Close()
+		s = ns
+	}
+}
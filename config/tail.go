@@ -0,0 +1,50 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ * This file contains the configuration of the live event tail of the admin namespace
+ */
+
+const (
+	//TailNamespace is the websocket namespace in which the admins subscribe to the live event tail
+	TailNamespace = "/admin"
+	//TailEvent is the event with which the sampled events are emitted to the subscribers of the tail
+	TailEvent = "tail"
+)
+
+var (
+	//TailSampleRate is the percentage of the events flowing through the instance sent to the tail
+	TailSampleRate float64 = 10
+	//TailBuffer is the no. of sampled events buffered for a subscriber. The events beyond it are dropped
+	TailBuffer = 256
+)
+
+func init() {
+	/*
+	 * We will init the tail sample rate
+	 * We will init the tail buffer
+	 */
+	//tail sample rate
+	if len(os.Getenv("TAIL_SAMPLE_RATE")) != 0 {
+		//if successful convert the rate
+		if r, err := strconv.ParseFloat(os.Getenv("TAIL_SAMPLE_RATE"), 64); err == nil && r >= 0 && r <= 100 {
+			TailSampleRate = r
+		}
+	}
+
+	//tail buffer
+	if len(os.Getenv("TAIL_BUFFER")) != 0 {
+		//if successful convert the size
+		if b, err := strconv.Atoi(os.Getenv("TAIL_BUFFER")); err == nil && b > 0 {
+			TailBuffer = b
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the payload templates of the notifications
+ */
+
+var (
+	//Templates has the payload templates given in the config mapped by the name. The ones saved in the database override them
+	Templates = map[string]string{}
+	//TemplatesRefresh is the time after which an instance reloads the payload templates from the database
+	TemplatesRefresh = time.Duration(30 * time.Second)
+)
+
+func init() {
+	/*
+	 * We will init the templates from the json config
+	 * We will init the templates refresh interval
+	 */
+	//templates
+	if len(os.Getenv("TEMPLATES")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("TEMPLATES")), &Templates)
+		if err != nil {
+			log.Println("Error while parsing the payload templates. Only the templates in the database are used", err.Error())
+			Templates = map[string]string{}
+		}
+	}
+
+	//templates refresh
+	if len(os.Getenv("TEMPLATES_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("TEMPLATES_REFRESH"), 10, 64); err == nil && t > 0 {
+			TemplatesRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/tls"
+	"os"
+)
+
+/*
+ * This file contains the tls configuration of the http and rpc listeners.
+ * With the certificate and the key given, the service serves tls by itself without a tls terminating sidecar
+ */
+
+var (
+	//TLSCert is the path of the pem encoded certificate chain of the listeners. TLS is disabled if empty
+	TLSCert = ""
+	//TLSKey is the path of the pem encoded private key of the certificate
+	TLSKey = ""
+	//tlsConfig is the tls config of the listeners. It is nil if TLS is disabled
+	tlsConfig *tls.Config
+)
+
+func init() {
+	/*
+	 * We will init the certificate and the key paths
+	 * Both of them are required to enable TLS
+	 * Then we will load the key pair
+	 */
+	TLSCert, TLSKey = os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+	if len(TLSCert) == 0 && len(TLSKey) == 0 {
+		return
+	}
+
+	//checking the certificate and the key
+	if len(TLSCert) == 0 {
+		initFailed(PartConfig, "TLS_CERT", ErrMissing)
+		return
+	}
+	if len(TLSKey) == 0 {
+		initFailed(PartConfig, "TLS_KEY", ErrMissing)
+		return
+	}
+
+	//loading the key pair
+	cert, err := tls.LoadX509KeyPair(TLSCert, TLSKey)
+	if err != nil {
+		initFailed(PartConfig, "TLS_CERT", err)
+		return
+	}
+	tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+}
+
+//TLSEnabled reports whether the listeners serve TLS with the given certificate or the certificates managed with ACME
+func TLSEnabled() bool {
+	return tlsConfig != nil || Autocert
+}
+
+//SetTLSConfig sets the tls config of the listeners. It is set by the certificate management before the listeners start
+func SetTLSConfig(c *tls.Config) {
+	tlsConfig = c
+}
+
+//TLSConfig returns a copy of the tls config of the listeners. It is nil if TLS is disabled
+func TLSConfig() *tls.Config {
+	if tlsConfig == nil {
+		return nil
+	}
+	return tlsConfig.Clone()
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the notification tracing
+ */
+
+var (
+	//TraceMaxTTL is the max time till which a trace flag stays active. It is also the default
+	TraceMaxTTL = time.Duration(time.Hour)
+	//TraceRetention is the time till which the report of an expired trace flag is kept
+	TraceRetention = time.Duration(24 * time.Hour)
+)
+
+func init() {
+	/*
+	 * We will init the max ttl of the trace flags
+	 * We will init the retention of the trace reports
+	 */
+	//max ttl
+	if len(os.Getenv("TRACE_MAX_TTL")) != 0 {
+		//if successful convert ttl
+		if t, err := strconv.ParseInt(os.Getenv("TRACE_MAX_TTL"), 10, 64); err == nil {
+			TraceMaxTTL = time.Duration(t * int64(time.Minute))
+		}
+	}
+
+	//retention
+	if len(os.Getenv("TRACE_RETENTION")) != 0 {
+		//if successful convert retention
+		if t, err := strconv.ParseInt(os.Getenv("TRACE_RETENTION"), 10, 64); err == nil {
+			TraceRetention = time.Duration(t * int64(time.Minute))
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the tenant usage reports
+ */
+
+var (
+	//UsageFlush is the interval at which the usage counters of the instance are flushed to the hourly samples
+	UsageFlush = time.Duration(time.Minute)
+	//UsageSampleRetention is the time till which the hourly usage samples are kept after the reports are generated
+	UsageSampleRetention = time.Duration(35 * 24 * time.Hour)
+)
+
+func init() {
+	/*
+	 * We will init the flush interval of the usage counters
+	 * We will init the retention of the usage samples
+	 */
+	//flush interval
+	if len(os.Getenv("USAGE_FLUSH")) != 0 {
+		//if successful convert flush interval
+		if f, err := strconv.ParseInt(os.Getenv("USAGE_FLUSH"), 10, 64); err == nil && f > 0 {
+			UsageFlush = time.Duration(f * int64(time.Second))
+		}
+	}
+
+	//sample retention
+	if len(os.Getenv("USAGE_SAMPLE_RETENTION")) != 0 {
+		//if successful convert retention
+		if r, err := strconv.ParseInt(os.Getenv("USAGE_SAMPLE_RETENTION"), 10, 64); err == nil && r > 0 {
+			UsageSampleRetention = time.Duration(r * int64(24*time.Hour))
+		}
+	}
+}
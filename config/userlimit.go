@@ -0,0 +1,48 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"strconv"
+)
+
+/*
+ * This file contains the configuration of the limit on the websocket connections of a user
+ */
+
+const (
+	//UserLimitReject rejects the handshake of a user who has reached the limit
+	UserLimitReject = "reject"
+	//UserLimitEvict closes the oldest connection of the user to accept the new one
+	UserLimitEvict = "evict"
+)
+
+var (
+	//MaxConnectionsPerUser is the max no. of websocket connections of a user in the instance. 0 is unlimited
+	MaxConnectionsPerUser = 0
+	//UserLimitPolicy is the policy applied to the handshakes of a user who has reached the limit. reject or evict
+	UserLimitPolicy = UserLimitReject
+)
+
+func init() {
+	/*
+	 * We will init the max connections per user
+	 * We will init the user limit policy
+	 */
+	//max connections per user
+	if len(os.Getenv("MAX_CONNECTIONS_PER_USER")) != 0 {
+		//if successful convert the no. of connections
+		if m, err := strconv.Atoi(os.Getenv("MAX_CONNECTIONS_PER_USER")); err == nil && m >= 0 {
+			MaxConnectionsPerUser = m
+		}
+	}
+
+	//user limit policy
+	switch os.Getenv("USER_LIMIT_POLICY") {
+	case UserLimitReject, UserLimitEvict:
+		UserLimitPolicy = os.Getenv("USER_LIMIT_POLICY")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*
+ * This file contains the configuration of the outbound webhooks
+ */
+
+var (
+	//VaultAddr is the address of the vault server storing the webhook signing secrets
+	VaultAddr = "http://127.0.0.1:8200"
+	//VaultToken is the token to access the vault server
+	VaultToken = ""
+	//WebhookSecretsPath is the kv v2 path in vault under which the per tenant webhook signing secrets are stored
+	WebhookSecretsPath = "secret/data/websockets/webhook-secrets"
+	//WebhookSecretGrace is the time till which a rotated webhook signing secret remains valid
+	WebhookSecretGrace = time.Duration(24 * time.Hour)
+	//WebhookSecretsRefresh is the time after which the cached webhook signing secrets of a tenant are fetched again from vault
+	WebhookSecretsRefresh = time.Duration(5 * time.Minute)
+	//ClientWebhooks has the webhook urls to which the events emitted by the clients are forwarded mapped by the event.
+	//The endpoints saved in the database are used along with them
+	ClientWebhooks = map[string][]string{}
+	//WebhooksRefresh is the time after which an instance reloads the webhook endpoints from the database
+	WebhooksRefresh = time.Duration(30 * time.Second)
+	//WebhookRetries is the no. of times a forwarded client event is posted again to a failing webhook
+	WebhookRetries = 3
+	//WebhookQueueSize is the size of the queue of the client events waiting to be forwarded. The events are dropped when it is full
+	WebhookQueueSize = 1000
+)
+
+func init() {
+	/*
+	 * We will init the vault address
+	 * We will init the vault token
+	 * We will init the webhook secrets path
+	 * We will init the webhook secret grace period
+	 * We will init the webhook secrets refresh interval
+	 * We will init the client webhooks from the json config and their refresh interval
+	 * We will init the webhook retries and the queue size
+	 */
+	//vault address
+	if len(os.Getenv("VAULT_ADDR")) != 0 {
+		VaultAddr = os.Getenv("VAULT_ADDR")
+	}
+
+	//vault token
+	if len(os.Getenv("VAULT_TOKEN")) != 0 {
+		VaultToken = os.Getenv("VAULT_TOKEN")
+	}
+
+	//webhook secrets path
+	if len(os.Getenv("WEBHOOK_SECRETS_PATH")) != 0 {
+		WebhookSecretsPath = os.Getenv("WEBHOOK_SECRETS_PATH")
+	}
+
+	//webhook secret grace period
+	if len(os.Getenv("WEBHOOK_SECRET_GRACE")) != 0 {
+		//if successful convert grace period
+		if t, err := strconv.ParseInt(os.Getenv("WEBHOOK_SECRET_GRACE"), 10, 64); err == nil {
+			WebhookSecretGrace = time.Duration(t * int64(time.Minute))
+		}
+	}
+
+	//webhook secrets refresh interval
+	if len(os.Getenv("WEBHOOK_SECRETS_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("WEBHOOK_SECRETS_REFRESH"), 10, 64); err == nil && t > 0 {
+			WebhookSecretsRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//client webhooks
+	if len(os.Getenv("CLIENT_WEBHOOKS")) != 0 {
+		err := json.Unmarshal([]byte(os.Getenv("CLIENT_WEBHOOKS")), &ClientWebhooks)
+		if err != nil {
+			log.Println("Error while parsing the client webhooks. Only the webhook endpoints in the database are used", err.Error())
+			ClientWebhooks = map[string][]string{}
+		}
+	}
+	if len(os.Getenv("WEBHOOKS_REFRESH")) != 0 {
+		//if successful convert the interval
+		if t, err := strconv.ParseInt(os.Getenv("WEBHOOKS_REFRESH"), 10, 64); err == nil && t > 0 {
+			WebhooksRefresh = time.Duration(t * int64(time.Second))
+		}
+	}
+
+	//webhook retries
+	if len(os.Getenv("WEBHOOK_RETRIES")) != 0 {
+		//if successful convert retries
+		if r, err := strconv.Atoi(os.Getenv("WEBHOOK_RETRIES")); err == nil && r >= 0 {
+			WebhookRetries = r
+		}
+	}
+
+	//webhook queue size
+	if len(os.Getenv("WEBHOOK_QUEUE_SIZE")) != 0 {
+		//if successful convert queue size
+		if q, err := strconv.Atoi(os.Getenv("WEBHOOK_QUEUE_SIZE")); err == nil && q > 0 {
+			WebhookQueueSize = q
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * This file contains the registry of the supervised background workers.
+ * A pool of workers is registered by its name. A worker which panics is recovered, the panic is counted
+ * and the worker is restarted after a backoff, so a bad job doesn't shrink the pool silently.
+ * The state of the pools is exposed in the metrics.
+ */
+
+const (
+	//workerMinBackoff is the initial backoff before restarting a worker
+	workerMinBackoff = 100 * time.Millisecond
+	//workerMaxBackoff is the max backoff before restarting a worker
+	workerMaxBackoff = 30 * time.Second
+	//workerStableAfter is the time after which a restarted worker is considered stable and the backoff is reset
+	workerStableAfter = time.Minute
+)
+
+//Worker is the state of a registered pool of workers
+type Worker struct {
+	//Name of the pool
+	Name string `json:"name"`
+	//Size is the no. of workers started in the pool
+	Size int `json:"size"`
+	//Running is the no. of workers of the pool running now
+	Running int `json:"running"`
+	//Restarts is the no. of times a worker of the pool was restarted after a panic
+	Restarts int `json:"restarts"`
+}
+
+var (
+	//workers has the registered pools of workers mapped by their name
+	workers = map[string]*Worker{}
+	//workersLock is the lock for the workers
+	workersLock sync.Mutex
+)
+
+//SuperviseWorkers registers the pool with the name and starts n workers running f under supervision.
+//A worker whose f panics is restarted after a backoff. A worker whose f returns isn't restarted
+func SuperviseWorkers(name string, n int, f func()) {
+	workersLock.Lock()
+	w, ok := workers[name]
+	if !ok {
+		w = &Worker{Name: name}
+		workers[name] = w
+	}
+	w.Size += n
+	w.Running += n
+	workersLock.Unlock()
+	for i := 0; i < n; i++ {
+		go superviseWorker(w, f)
+	}
+}
+
+//runWorker runs f and reports whether it panicked
+func runWorker(name string, f func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			Panicked("worker:"+name, r)
+			panicked = true
+		}
+	}()
+	f()
+	return false
+}
+
+//superviseWorker runs f and restarts it whenever it panics
+func superviseWorker(w *Worker, f func()) {
+	/*
+	 * We will run the worker
+	 * If it returned without a panic, we will stop
+	 * Else we will wait for the backoff and restart it
+	 */
+	backoff := workerMinBackoff
+	for {
+		started := time.Now()
+		if !runWorker(w.Name, f) {
+			break
+		}
+
+		//waiting for the backoff
+		if time.Since(started) > workerStableAfter {
+			backoff = workerMinBackoff
+		}
+		log.Println("worker", w.Name, "panicked. restarting it in", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > workerMaxBackoff {
+			backoff = workerMaxBackoff
+		}
+		workersLock.Lock()
+		w.Restarts++
+		workersLock.Unlock()
+	}
+	workersLock.Lock()
+	w.Running--
+	workersLock.Unlock()
+}
+
+//Workers returns the state of the registered pools of workers sorted by their name
+func Workers() []Worker {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	result := make([]Worker, 0, len(workers))
+	for _, w := range workers {
+		result = append(result, *w)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
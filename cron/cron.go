@@ -0,0 +1,175 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package cron parses the standard five field cron expressions and finds their next run.
+//The fields are minute, hour, day of the month, month and day of the week. A field is *, a value, a range a-b
+//or a list of them separated by commas, each with an optional step /n. Sunday is 0 or 7 in the day of the week.
+//If both the day of the month and the day of the week are restricted, a day matching either runs.
+//The descriptors @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly are supported
+package cron
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the parsing of the cron expressions and the calculation of their next run
+ */
+
+//ErrInvalid is returned when the cron expression couldn't be parsed
+var ErrInvalid = errors.New("invalid cron expression")
+
+//descriptors are the cron expressions of the supported descriptors
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+//bounds are the min and max values of a field
+type bounds struct {
+	min, max uint
+}
+
+//fields has the bounds of the fields in their order in the expression
+var fields = []bounds{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+//maxSearch is the time after which the search for the next run gives up. Eg. 30 2 31 2 * never runs
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+//Schedule is a parsed cron expression. Each field is the bit set of the values it matches
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	//domStar and dowStar are set if the day of the month and the day of the week are not restricted
+	domStar, dowStar bool
+}
+
+//Parse parses the cron expression
+func Parse(expr string) (Schedule, error) {
+	/*
+	 * We will expand the descriptor
+	 * Then we will parse the five fields
+	 * Sunday as 7 is folded into 0
+	 */
+	expr = strings.TrimSpace(expr)
+	if d, ok := descriptors[strings.ToLower(expr)]; ok {
+		expr = d
+	}
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return Schedule{}, ErrInvalid
+	}
+
+	//parsing the fields
+	sets := make([]uint64, len(fields))
+	for i, p := range parts {
+		set, err := parseField(p, fields[i])
+		if err != nil {
+			return Schedule{}, err
+		}
+		sets[i] = set
+	}
+	s := Schedule{minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4]}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar, s.dowStar = strings.HasPrefix(parts[2], "*"), strings.HasPrefix(parts[4], "*")
+	return s, nil
+}
+
+//parseField returns the bit set of the values matched by the field
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		//parsing the step
+		step := uint(1)
+		if i := strings.Index(item, "/"); i >= 0 {
+			s, err := strconv.ParseUint(item[i+1:], 10, 8)
+			if err != nil || s == 0 {
+				return 0, ErrInvalid
+			}
+			step, item = uint(s), item[:i]
+		}
+
+		//parsing the range
+		lo, hi := b.min, b.max
+		if item != "*" {
+			r := strings.SplitN(item, "-", 2)
+			v, err := strconv.ParseUint(r[0], 10, 8)
+			if err != nil {
+				return 0, ErrInvalid
+			}
+			lo, hi = uint(v), uint(v)
+			if len(r) == 2 {
+				v, err := strconv.ParseUint(r[1], 10, 8)
+				if err != nil {
+					return 0, ErrInvalid
+				}
+				hi = uint(v)
+			} else if step != 1 {
+				hi = b.max
+			}
+		}
+		if lo < b.min || hi > b.max || lo > hi {
+			return 0, ErrInvalid
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+//has reports whether the bit set has the value
+func has(set uint64, v int) bool {
+	return set&(1<<uint(v)) != 0
+}
+
+//matchDay reports whether the schedule runs on the day of the time
+func (s Schedule) matchDay(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+//Next returns the first time after the given time at which the schedule runs, in the location of the given time.
+//It returns the zero time if the schedule doesn't run in the next five years
+func (s Schedule) Next(t time.Time) time.Time {
+	/*
+	 * We will start from the next minute
+	 * Then we will skip the months, days, hours and minutes not matching till all of them match
+	 */
+	loc := t.Location()
+	end := t.Add(maxSearch)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(end) {
+		if !has(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !has(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !has(s.minute, t.Minute()) {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
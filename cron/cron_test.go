@@ -0,0 +1,57 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/cron"
+)
+
+func TestParseRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		if _, err := cron.Parse(expr); err == nil {
+			t.Errorf("expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	//a wednesday
+	from := time.Date(2019, time.October, 16, 10, 30, 45, 0, time.UTC)
+	for expr, want := range map[string]time.Time{
+		"* * * * *":       time.Date(2019, time.October, 16, 10, 31, 0, 0, time.UTC),
+		"*/15 * * * *":    time.Date(2019, time.October, 16, 10, 45, 0, 0, time.UTC),
+		"0 9 * * 1":       time.Date(2019, time.October, 21, 9, 0, 0, 0, time.UTC),
+		"0 9 * * 7":       time.Date(2019, time.October, 20, 9, 0, 0, 0, time.UTC),
+		"0 0 1 * *":       time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 13 * 5":      time.Date(2019, time.October, 18, 0, 0, 0, 0, time.UTC),
+		"30 8-10 * * *":   time.Date(2019, time.October, 17, 8, 30, 0, 0, time.UTC),
+		"0 12 29 2 *":     time.Date(2020, time.February, 29, 12, 0, 0, 0, time.UTC),
+		"@weekly":         time.Date(2019, time.October, 20, 0, 0, 0, 0, time.UTC),
+		"0 0 31 2 *":      {},
+		"15,45 10 16 * *": time.Date(2019, time.October, 16, 10, 45, 0, 0, time.UTC),
+	} {
+		s, err := cron.Parse(expr)
+		if err != nil {
+			t.Fatal(expr, err)
+		}
+		if got := s.Next(from); !got.Equal(want) {
+			t.Errorf("expected the next run of %q to be %s. got %s", expr, want, got)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the ack mode of the notifications.
+ * A notification in the ack mode is emitted with an ack callback. If the client doesn't acknowledge it within
+ * the ack timeout, it is emitted again with a doubled timeout till the ack retries are exhausted.
+ * So the clients may receive a notification more than once and have to dedupe it by its sequence no.
+ * The final status of the notification is recorded in the replay log. Once acknowledged by any connection
+ * of the user, the notification stays acknowledged. The notifications not acknowledged by any connection of the user
+ * are handed to the undelivered handlers, like the email fallback.
+ * A single receipt is sent for the user once the last of its connections awaiting the notification settles.
+ */
+
+//AckStatus is the delivery status of a notification sent in the ack mode
+type AckStatus string
+
+const (
+	//StatusPending is the status of the notification waiting for the acknowledgement
+	StatusPending AckStatus = "pending"
+	//StatusAcked is the status of the notification acknowledged by a connection of the user
+	StatusAcked AckStatus = "acked"
+	//StatusUnacked is the status of the notification not acknowledged after the retries
+	StatusUnacked AckStatus = "unacked"
+)
+
+var (
+	//ackRetried is the no. of times the notifications in the ack mode were emitted again
+	ackRetried uint64
+	//acked is the no. of notification copies acknowledged by the clients
+	acked uint64
+	//unacked is the no. of notification copies not acknowledged after the retries
+	unacked uint64
+)
+
+//UndeliveredHandler handles the notification not acknowledged by any connection of the user
+type UndeliveredHandler func(userID uint, n Notification)
+
+var (
+	//undeliveredHandlers are the handlers of the undelivered notifications
+	undeliveredHandlers []UndeliveredHandler
+	//undeliveredLock is the lock for the undelivered handlers
+	undeliveredLock sync.RWMutex
+)
+
+//OnUndelivered registers the handler of the notifications in the ack mode not acknowledged by any connection of the user
+func OnUndelivered(h UndeliveredHandler) {
+	undeliveredLock.Lock()
+	defer undeliveredLock.Unlock()
+	undeliveredHandlers = append(undeliveredHandlers, h)
+}
+
+//ackFunc returns the ack callback to be emitted with the notification and the channel closed once it is called
+func ackFunc() (func(), chan struct{}) {
+	done := make(chan struct{})
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }, done
+}
+
+//awaitAck waits for the acknowledgement of the notification emitted with the args to the connection of the lane.
+//On timeout the notification is emitted again with the same ack callback
+func (l *lane) awaitAck(n Notification, ack <-chan struct{}, args ...interface{}) {
+	/*
+	 * We will keep the notification as awaiting the ack till it is resolved, so that it is flushed on shutdown
+	 * We will wait for the ack within the timeout
+	 * On timeout we will emit again and wait with the doubled timeout till the retries are exhausted
+	 * Then we will record the final status of the connection and settle the notification for the user
+	 */
+	userID := userOf(l.conn)
+	release := awaiting(userID, n)
+	wait := config.AckTimeout
+	for attempt := 0; ; attempt++ {
+		t := time.NewTimer(wait)
+		select {
+		case <-ack:
+			t.Stop()
+			atomic.AddUint64(&acked, 1)
+			Trace(StageAcked, userID, n, "by the connection ", l.conn.ID())
+			l.ackStatus(n, StatusAcked)
+			l.settle(userID, n, release, true)
+			return
+		case <-l.done:
+			t.Stop()
+			atomic.AddUint64(&unacked, 1)
+			l.ackStatus(n, StatusUnacked)
+			l.settle(userID, n, release, false)
+			return
+		case <-t.C:
+		}
+		if attempt >= config.AckRetries {
+			break
+		}
+		atomic.AddUint64(&ackRetried, 1)
+		Trace(StageEmitted, userID, n, "again to the connection ", l.conn.ID(), " as it wasn't acknowledged")
+		if err := l.write(n.Event, args...); err != nil {
+			log.Error("error while emitting again the unacknowledged notification", n.Event, "to the connection", l.conn.ID(), err.Error())
+			break
+		}
+		wait *= 2
+	}
+	atomic.AddUint64(&unacked, 1)
+	Trace(StageDropped, userID, n, "from the connection ", l.conn.ID(), " as it wasn't acknowledged")
+	l.ackStatus(n, StatusUnacked)
+	l.settle(userID, n, release, false)
+}
+
+//settle releases the notification awaited by the connection of the lane. Once the last connection of the user awaiting it
+//is released, a single receipt is sent for the user. It is delivered if any connection acknowledged it.
+//Else the notification is handed to the undelivered handlers unless a connection on another instance acknowledged it
+func (l *lane) settle(userID uint, n Notification, release func(bool) (bool, bool), acked bool) {
+	last, anyAcked := release(acked)
+	if !last {
+		return
+	}
+	if anyAcked {
+		notifyReceipt(userID, n, ReceiptDelivered)
+		return
+	}
+	if n.Seq == 0 {
+		notifyReceipt(userID, n, ReceiptFailed)
+		return
+	}
+	appCtx, ok := l.conn.Context().(*config.AppContext)
+	if !ok {
+		return
+	}
+	if s, err := statusOf(appCtx.Db, userID, n.Seq); err != nil || s == StatusAcked {
+		return
+	}
+	notifyReceipt(userID, n, ReceiptFailed)
+	undeliveredLock.RLock()
+	defer undeliveredLock.RUnlock()
+	for _, h := range undeliveredHandlers {
+		h(userID, n)
+	}
+}
+
+//statusOf returns the delivery status of the notification with the sequence no. sent to the user
+func statusOf(db *gorm.DB, userID uint, seq uint64) (AckStatus, error) {
+	if db != nil {
+		d := Delivered{}
+		err := db.Where("user_id = ? AND seq = ?", userID, seq).First(&d).Error
+		return d.Status, err
+	}
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	for _, d := range replayLogs[userID] {
+		if d.Seq == seq {
+			return d.Status, nil
+		}
+	}
+	return "", nil
+}
+
+//ackStatus records the status of the notification sent to the user of the connection of the lane
+func (l *lane) ackStatus(n Notification, status AckStatus) {
+	appCtx, ok := l.conn.Context().(*config.AppContext)
+	if !ok || n.Seq == 0 {
+		return
+	}
+	if err := SetStatus(appCtx.Db, appCtx.Session.User.ID, n.Seq, status); err != nil {
+		log.Error("error while recording the status", status, "of the notification", n.Seq, "of user", appCtx.Session.User.ID, err.Error())
+	}
+}
+
+//SetStatus records the delivery status of the notification with the sequence no. sent to the user.
+//An acknowledged notification is never marked otherwise
+func SetStatus(db *gorm.DB, userID uint, seq uint64, status AckStatus) error {
+	if db != nil {
+		return db.Model(&Delivered{}).Where("user_id = ? AND seq = ? AND status <> ?", userID, seq, StatusAcked).
+			Update("status", status).Error
+	}
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	l := replayLogs[userID]
+	for i := range l {
+		if l[i].Seq == seq && l[i].Status != StatusAcked {
+			l[i].Status = status
+		}
+	}
+	return nil
+}
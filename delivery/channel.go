@@ -0,0 +1,56 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import "errors"
+
+/*
+ * This file contains the delivery channels requested by the producers on top of the websocket connections.
+ * The notifications are always sent to the live connections. The notifications of the users without a live
+ * connection are pushed to their devices. A notification requesting a channel is sent through it irrespective
+ * of the connections of the user. A notification with a fallback is sent through it only if the realtime delivery fails.
+ */
+
+const (
+	//ChannelPush sends the notification to the push subscriptions and the mobile devices of the user
+	ChannelPush = "push"
+	//FallbackEmail emails the notification to the user if it couldn't be delivered in realtime
+	FallbackEmail = "email"
+)
+
+var (
+	//ErrInvalidChannel is returned when the notification requests an unknown channel
+	ErrInvalidChannel = errors.New("channels can only have push")
+	//ErrInvalidFallback is returned when the notification requests an unknown fallback
+	ErrInvalidFallback = errors.New("fallback can only be email")
+)
+
+//CheckChannels checks whether the channels requested by the notification are known
+func (n Notification) CheckChannels() error {
+	for _, c := range n.Channels {
+		if c != ChannelPush {
+			return ErrInvalidChannel
+		}
+	}
+	return nil
+}
+
+//CheckFallback checks whether the fallback requested by the notification is known
+func (n Notification) CheckFallback() error {
+	if len(n.Fallback) != 0 && n.Fallback != FallbackEmail {
+		return ErrInvalidFallback
+	}
+	return nil
+}
+
+//Wants reports whether the notification requested the channel
+func (n Notification) Wants(channel string) bool {
+	for _, c := range n.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
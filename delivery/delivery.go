@@ -0,0 +1,113 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package delivery has the delivery of notifications to the websocket connections.
+//Every connection is opened with an outbox having separate lanes for alerts and data frames.
+//Notifications sent to a connection are queued in the lane and emitted by the lane's go routine.
+package delivery
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/usage"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+//ErrNoOutbox is returned when the connection doesn't have an outbox opened
+var ErrNoOutbox = errors.New("couldn't find the outbox of the connection")
+
+//ErrGuestEvent is returned when the event isn't whitelisted for the guest connections
+var ErrGuestEvent = errors.New("event can't be emitted to the guest connections")
+
+//Outbox has the delivery lanes of a websocket connection
+type Outbox struct {
+	//lanes of the outbox
+	lanes map[Lane]*lane
+	//recent has the events recently emitted in the lanes
+	recent *recentEvents
+}
+
+//outboxes has the outboxes of the connections mapped by the connection id
+var outboxes = map[string]*Outbox{}
+
+//outboxesLock is the lock for the outboxes map
+var outboxesLock sync.RWMutex
+
+//Open will open an outbox for the connection. If the outbox is already opened, it is returned
+func Open(conn socketio.Conn) *Outbox {
+	outboxesLock.Lock()
+	defer outboxesLock.Unlock()
+	if o, ok := outboxes[conn.ID()]; ok {
+		return o
+	}
+	recent := newRecentEvents(config.RecentEventsSize)
+	o := &Outbox{recent: recent, lanes: map[Lane]*lane{
+		AlertLane: newLane(AlertLane, conn, recent),
+		DataLane:  newLane(DataLane, conn, recent),
+	}}
+	outboxes[conn.ID()] = o
+	usage.Connected(tenantOf(conn))
+	return o
+}
+
+//Close will close the outbox of the connection. The connection leaves its rooms and the taps watched by it are stopped
+func Close(conn socketio.Conn) {
+	leaveAll(conn)
+	for _, u := range untapAll(conn) {
+		log.Info("AUDIT: impersonation of user", u, "stopped as the watcher connection", conn.ID(), "closed")
+	}
+	outboxesLock.Lock()
+	o, ok := outboxes[conn.ID()]
+	delete(outboxes, conn.ID())
+	outboxesLock.Unlock()
+	if !ok {
+		return
+	}
+	usage.Disconnected(tenantOf(conn))
+	for _, l := range o.lanes {
+		l.close()
+	}
+}
+
+//Send will queue the notification for the delivery to the connection in the lane of the notification.
+//Only the whitelisted events are sent to the guest connections
+func Send(conn socketio.Conn, n Notification) error {
+	outboxesLock.RLock()
+	o, ok := outboxes[conn.ID()]
+	outboxesLock.RUnlock()
+	if !ok {
+		return ErrNoOutbox
+	}
+	if isGuest(conn) && !config.GuestEventAllowed(n.Event) {
+		return ErrGuestEvent
+	}
+	return o.Send(n)
+}
+
+//Send will queue the notification in the lane of the notification. Notifications without a known lane
+//are queued in the data lane
+func (o *Outbox) Send(n Notification) error {
+	l, ok := o.lanes[n.Lane]
+	if !ok {
+		l = o.lanes[DataLane]
+	}
+	return l.push(n)
+}
+
+//isGuest reports whether the connection is of a guest
+func isGuest(conn socketio.Conn) bool {
+	appCtx, ok := conn.Context().(*config.AppContext)
+	return ok && appCtx.Guest
+}
+
+//tenantOf returns the tenant of the user of the connection
+func tenantOf(conn socketio.Conn) string {
+	if appCtx, ok := conn.Context().(*config.AppContext); ok {
+		return appCtx.Tenant
+	}
+	return ""
+}
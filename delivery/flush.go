@@ -0,0 +1,203 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the notifications in flight taken for the flush on shutdown. They are the notifications
+ * waiting in the lanes of the connections, the ones awaiting the acknowledgement and the offline ones kept in memory.
+ * The copies of a notification sent to the multiple connections of a user are taken once, so the user gets it once
+ * on reconnecting. They are ordered by the priority so that the critical ones are flushed first if the flush runs out of time.
+ */
+
+const (
+	//PriorityCritical is the priority of the alerts sent in the ack mode
+	PriorityCritical = iota
+	//PriorityAlert is the priority of the alerts and the escalated notifications
+	PriorityAlert
+	//PriorityAck is the priority of the data notifications sent in the ack mode
+	PriorityAck
+	//PriorityData is the priority of the data notifications
+	PriorityData
+)
+
+//Pending is a notification in flight of a user taken for the flush
+type Pending struct {
+	//UserID to whom the notification is to be delivered
+	UserID uint `json:"userId"`
+	//Seq is the sequence no. of the notification for the user
+	Seq uint64 `json:"seq,omitempty"`
+	//Priority of the notification. Lower is more critical
+	Priority int `json:"priority"`
+	//Notification in flight
+	Notification Notification `json:"notification"`
+}
+
+//priorityOf returns the priority of the notification. The urgent notifications are flushed with the alerts
+func priorityOf(n Notification) int {
+	alert := n.Lane == AlertLane || len(n.Urgency) != 0 || n.Priority.Urgent()
+	switch {
+	case alert && n.Ack:
+		return PriorityCritical
+	case alert:
+		return PriorityAlert
+	case n.Ack:
+		return PriorityAck
+	}
+	return PriorityData
+}
+
+//newPending returns the pending notification of the user with its priority
+func newPending(userID uint, n Notification) Pending {
+	return Pending{UserID: userID, Seq: n.Seq, Priority: priorityOf(n), Notification: n}
+}
+
+var (
+	//awaitingAcks has the notifications awaiting the acknowledgement mapped by an id
+	awaitingAcks = map[uint64]Pending{}
+	//awaitingSeq is the id of the last notification awaiting the acknowledgement
+	awaitingSeq uint64
+	//awaitingAcked has the notifications acknowledged by a connection of the user while the other connections
+	//of the user are still awaiting them. They are mapped by the user and the sequence no.
+	awaitingAcked = map[string]bool{}
+	//awaitingLock is the lock for the notifications awaiting the acknowledgement
+	awaitingLock sync.Mutex
+)
+
+//awaiting keeps the notification of the user as awaiting the acknowledgement. The returned func removes it with
+//whether the connection acknowledged it. It reports whether no other connection of the user is awaiting the acknowledgement
+//of the notification and if so, whether any connection of the user acknowledged it
+func awaiting(userID uint, n Notification) func(acked bool) (last, anyAcked bool) {
+	awaitingLock.Lock()
+	defer awaitingLock.Unlock()
+	awaitingSeq++
+	id := awaitingSeq
+	awaitingAcks[id] = newPending(userID, n)
+	key := strconv.FormatUint(uint64(userID), 10) + "/" + strconv.FormatUint(n.Seq, 10)
+	var once sync.Once
+	return func(acked bool) (last, anyAcked bool) {
+		awaitingLock.Lock()
+		defer awaitingLock.Unlock()
+		once.Do(func() {
+			delete(awaitingAcks, id)
+			if acked {
+				awaitingAcked[key] = true
+			}
+			for _, p := range awaitingAcks {
+				if p.UserID == userID && p.Seq == n.Seq {
+					return
+				}
+			}
+			last = true
+			anyAcked = awaitingAcked[key]
+			delete(awaitingAcked, key)
+		})
+		return last, anyAcked
+	}
+}
+
+//pendingKey returns the key identifying the copies of the pending notification of the user.
+//Empty if the notification has neither a sequence no. nor an id
+func pendingKey(p Pending) string {
+	u := strconv.FormatUint(uint64(p.UserID), 10)
+	if p.Seq != 0 {
+		return u + "/" + strconv.FormatUint(p.Seq, 10)
+	}
+	if len(p.Notification.ID) != 0 {
+		return u + "/id/" + p.Notification.Event + "/" + p.Notification.ID
+	}
+	return ""
+}
+
+//take takes the notifications waiting in the queues of the lane without blocking
+func (l *lane) take() []Notification {
+	ns := []Notification{}
+	for {
+		select {
+		case n := <-l.urgent:
+			ns = append(ns, n)
+			continue
+		default:
+		}
+		select {
+		case n := <-l.queue:
+			ns = append(ns, n)
+		default:
+			return ns
+		}
+	}
+}
+
+//TakePending takes the notifications in flight of the instance ordered by the priority.
+//The queued ones are removed from the lanes. The offline ones are taken only if kept in memory, i.e. the db is nil
+func TakePending(db *gorm.DB) []Pending {
+	/*
+	 * We will take the notifications queued in the lanes of the connections
+	 * Then we will take the notifications awaiting the acknowledgement
+	 * Then we will take the offline notifications kept in memory
+	 * Then we will sort them by the priority
+	 * Then we will drop the copies of the same notification of a user keeping the most critical one
+	 */
+	//taking the queued notifications
+	ps := []Pending{}
+	outboxesLock.RLock()
+	for _, o := range outboxes {
+		for _, l := range o.lanes {
+			u := userOf(l.conn)
+			for _, n := range l.take() {
+				ps = append(ps, newPending(u, n))
+			}
+		}
+	}
+	outboxesLock.RUnlock()
+
+	//taking the notifications awaiting the acknowledgement
+	awaitingLock.Lock()
+	for _, p := range awaitingAcks {
+		ps = append(ps, p)
+	}
+	awaitingLock.Unlock()
+
+	//taking the offline notifications in memory
+	if db == nil {
+		replayLock.Lock()
+		users := make([]uint, 0, len(offline))
+		for u := range offline {
+			users = append(users, u)
+		}
+		replayLock.Unlock()
+		for _, u := range users {
+			ds, _ := TakeOffline(nil, u)
+			for _, d := range ds {
+				ps = append(ps, newPending(u, d.Notification()))
+			}
+		}
+	}
+
+	//sorting by the priority
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].Priority < ps[j].Priority
+	})
+
+	//dropping the copies
+	seen := map[string]bool{}
+	unique := ps[:0]
+	for _, p := range ps {
+		k := pendingKey(p)
+		if len(k) != 0 && seen[k] {
+			continue
+		}
+		seen[k] = true
+		unique = append(unique, p)
+	}
+	return unique
+}
@@ -0,0 +1,241 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the notification history of the users for the notification center of the frontend.
+ * Unlike the replay log which is kept only till the clients catch up, the history is kept till the history retention.
+ * The history is read newest first in pages. The cursor of a page is the opaque encoding of the last sequence no. in it.
+ * The clients mark the notifications in the history as read. The unread ones in the retention are counted for the badge.
+ * If the database is enabled, the history is persisted else it is kept in memory.
+ */
+
+//ErrInvalidCursor is returned when the cursor of the history page couldn't be decoded
+var ErrInvalidCursor = errors.New("invalid history cursor")
+
+//History is a notification in the history of a user
+type History struct {
+	//ID is the id of the record
+	ID uint `gorm:"primary_key" json:"-"`
+	//UserID is the id of the user to whom the notification was delivered
+	UserID uint `gorm:"index" json:"-"`
+	//Seq is the sequence no. of the notification for the user
+	Seq uint64 `gorm:"index" json:"seq"`
+	//NotificationID is the id of the notification given by the producer
+	NotificationID string `json:"id,omitempty"`
+	//Event is the event name of the notification
+	Event string `json:"event"`
+	//Payload is the json encoded payload of the notification
+	Payload string `gorm:"type:text" json:"payload"`
+	//ReadAt is the time at which the user read the notification. Nil if it is unread
+	ReadAt *time.Time `json:"readAt,omitempty"`
+	//CreatedAt is the time at which the notification was delivered
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+}
+
+//TableName returns the table name of the notification history
+func (History) TableName() string {
+	return "notification_history"
+}
+
+//HistoryPage is a page of the notification history of a user
+type HistoryPage struct {
+	//Notifications in the page, newest first
+	Notifications []History `json:"notifications"`
+	//Next is the cursor of the next page. Empty if there are no older notifications
+	Next string `json:"next,omitempty"`
+}
+
+//histories has the in memory history of each user. Used when the database is not enabled
+var histories = map[uint][]History{}
+
+//InitHistory will migrate the history table and start the purging of the expired history on the leader instance.
+//If the db is nil, the history is kept in memory
+func InitHistory(db *gorm.DB) error {
+	if db == nil {
+		return nil
+	}
+	if err := db.AutoMigrate(&History{}).Error; err != nil {
+		return err
+	}
+	leader.Run("history-purge", db, func(done <-chan struct{}) {
+		purgeHistory(db, done)
+	})
+	return nil
+}
+
+//purgeHistory periodically purges the history older than the retention till done is closed
+func purgeHistory(db *gorm.DB, done <-chan struct{}) {
+	t := time.NewTicker(config.HistoryPurgeCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		if err := db.Where("created_at < ?", time.Now().Add(-config.HistoryRetention)).Delete(&History{}).Error; err != nil {
+			log.Error("error while purging the expired notification history", err.Error())
+		}
+	}
+}
+
+//AddHistory adds the notification recorded for the user to their history.
+//In memory, the history is trimmed to the history size and the retention
+func AddHistory(db *gorm.DB, userID uint, n Notification) error {
+	p, err := json.Marshal(n.Payload)
+	if err != nil {
+		return err
+	}
+	h := History{UserID: userID, Seq: n.Seq, NotificationID: n.ID, Event: n.Event, Payload: string(p), CreatedAt: time.Now()}
+	if db != nil {
+		return db.Create(&h).Error
+	}
+
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	l := append(histories[userID], h)
+	expiry := h.CreatedAt.Add(-config.HistoryRetention)
+	for len(l) > 0 && (len(l) > config.HistorySize || l[0].CreatedAt.Before(expiry)) {
+		l = l[1:]
+	}
+	histories[userID] = l
+	return nil
+}
+
+//EncodeCursor returns the cursor of the history page ending at the sequence no.
+func EncodeCursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(seq, 10)))
+}
+
+//DecodeCursor returns the sequence no. at which the history page of the cursor ended
+func DecodeCursor(cursor string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	seq, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil || seq == 0 {
+		return 0, ErrInvalidCursor
+	}
+	return seq, nil
+}
+
+//HistoryBefore returns the page of the history of the user older than the cursor, newest first.
+//If the cursor is empty, the page starts from the latest notification
+func HistoryBefore(db *gorm.DB, userID uint, cursor string, limit int) (HistoryPage, error) {
+	/*
+	 * We will decode the cursor
+	 * Then we will get one notification more than the limit to know if there is a next page
+	 * Then we will set the cursor of the next page
+	 */
+	var before uint64
+	if len(cursor) != 0 {
+		seq, err := DecodeCursor(cursor)
+		if err != nil {
+			return HistoryPage{}, err
+		}
+		before = seq
+	}
+
+	//getting the notifications
+	expiry := time.Now().Add(-config.HistoryRetention)
+	hs := []History{}
+	if db != nil {
+		s := db.Where("user_id = ? AND created_at > ?", userID, expiry)
+		if before != 0 {
+			s = s.Where("seq < ?", before)
+		}
+		if err := s.Order("seq desc").Limit(limit + 1).Find(&hs).Error; err != nil {
+			return HistoryPage{}, err
+		}
+	} else {
+		replayLock.Lock()
+		l := histories[userID]
+		for i := len(l) - 1; i >= 0 && len(hs) <= limit; i-- {
+			if (before == 0 || l[i].Seq < before) && l[i].CreatedAt.After(expiry) {
+				hs = append(hs, l[i])
+			}
+		}
+		replayLock.Unlock()
+	}
+
+	//setting the next cursor
+	p := HistoryPage{Notifications: hs}
+	if len(hs) > limit {
+		p.Notifications = hs[:limit]
+		p.Next = EncodeCursor(hs[limit-1].Seq)
+	}
+	return p, nil
+}
+
+//MarkRead marks the notifications with the sequence nos. in the history of the user as read.
+//If the sequence nos. are empty, all the notifications of the user are marked. It returns the no. of notifications marked
+func MarkRead(db *gorm.DB, userID uint, seqs []uint64) (int, error) {
+	now := time.Now()
+	if db != nil {
+		s := db.Model(&History{}).Where("user_id = ? AND read_at IS NULL", userID)
+		if len(seqs) != 0 {
+			s = s.Where("seq IN (?)", seqs)
+		}
+		s = s.Update("read_at", now)
+		return int(s.RowsAffected), s.Error
+	}
+
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	marked := 0
+	l := histories[userID]
+	for i := range l {
+		if l[i].ReadAt != nil || (len(seqs) != 0 && !containsSeq(seqs, l[i].Seq)) {
+			continue
+		}
+		l[i].ReadAt = &now
+		marked++
+	}
+	return marked, nil
+}
+
+//containsSeq reports whether the sequence nos. have the sequence no.
+func containsSeq(seqs []uint64, seq uint64) bool {
+	for _, s := range seqs {
+		if s == seq {
+			return true
+		}
+	}
+	return false
+}
+
+//UnreadCount returns the no. of unread notifications in the history of the user
+func UnreadCount(db *gorm.DB, userID uint) (int, error) {
+	expiry := time.Now().Add(-config.HistoryRetention)
+	n := 0
+	if db != nil {
+		err := db.Model(&History{}).Where("user_id = ? AND read_at IS NULL AND created_at > ?", userID, expiry).Count(&n).Error
+		return n, err
+	}
+
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	for _, h := range histories[userID] {
+		if h.ReadAt == nil && h.CreatedAt.After(expiry) {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/codec"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/limiter"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/tracing"
+	"github.com/cuttle-ai/websockets/usage"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the definition of the delivery lanes of a connection.
+ * Each lane has its own queues, rate limit and go routine emitting to the connection.
+ * So a flood of data frames will never delay an alert. Within a lane, the urgent notifications are queued
+ * separately and emitted ahead of the others.
+ */
+
+//Lane is a logical delivery lane of a connection
+type Lane string
+
+const (
+	//AlertLane is the low rate lane for the critical user facing alerts and toasts
+	AlertLane Lane = "alert"
+	//DataLane is the high throughput lane for the data frames
+	DataLane Lane = "data"
+)
+
+//ErrLaneFull is returned when the queue of the lane is full
+var ErrLaneFull = errors.New("delivery lane of the connection is full")
+
+//ErrLaneClosed is returned when the lane is already closed
+var ErrLaneClosed = errors.New("delivery lane of the connection is closed")
+
+//lane is a delivery lane of a connection
+type lane struct {
+	//name of the lane
+	name Lane
+	//conn is the websocket connection to which the lane emits
+	conn socketio.Conn
+	//urgent has the system and high priority notifications waiting for the delivery
+	urgent chan Notification
+	//queue has the other notifications waiting for the delivery
+	queue chan Notification
+	//bucket limits the rate of emits in the lane
+	bucket *limiter.Bucket
+	//done is closed when the lane is closed
+	done chan struct{}
+	//recent has the events recently emitted to the connection
+	recent *recentEvents
+}
+
+//newLane returns a lane for the given connection with its configuration. The emitted events are kept in recent.
+//The lane starts delivering right away
+func newLane(name Lane, conn socketio.Conn, recent *recentEvents) *lane {
+	rate, size, urgent := config.DataLaneRate, config.DataLaneQueueSize, config.UrgentQueueSize
+	if name == AlertLane {
+		rate, size = config.AlertLaneRate, config.AlertLaneQueueSize
+	}
+	if isGuest(conn) {
+		if rate <= 0 || rate > config.GuestLaneRate {
+			rate = config.GuestLaneRate
+		}
+		if size > config.GuestLaneQueueSize {
+			size = config.GuestLaneQueueSize
+		}
+		if urgent > config.GuestLaneQueueSize {
+			urgent = config.GuestLaneQueueSize
+		}
+	}
+	l := &lane{
+		name:   name,
+		conn:   conn,
+		urgent: make(chan Notification, urgent),
+		queue:  make(chan Notification, size),
+		bucket: limiter.NewBucket(rate, int(rate)),
+		done:   make(chan struct{}),
+		recent: recent,
+	}
+	go l.deliver()
+	return l
+}
+
+//push will queue the notification in the lane without blocking. The urgent notifications are queued ahead of the others
+func (l *lane) push(n Notification) error {
+	n.ResolveDeadline(time.Now())
+	select {
+	case <-l.done:
+		return ErrLaneClosed
+	default:
+	}
+	q := l.queue
+	if n.Priority.Urgent() {
+		q = l.urgent
+	}
+	n.tracker.add()
+	select {
+	case q <- n:
+		Trace(StageQueued, userOf(l.conn), n, "in the ", l.name, " lane of the connection ", l.conn.ID())
+		return nil
+	default:
+		n.tracker.Release()
+		Trace(StageDropped, userOf(l.conn), n, "as the ", l.name, " lane of the connection ", l.conn.ID(), " is full")
+		return ErrLaneFull
+	}
+}
+
+//deliver emits the queued notifications to the connection as per the rate limit of the lane.
+//The notification to be emitted is picked once the rate limit allows so that the urgent ones queued meanwhile go first.
+//Notifications whose deadline has passed while waiting in the queue are dropped. So are the ones left once the lane is closed
+func (l *lane) deliver() {
+	defer func() {
+		for _, n := range l.take() {
+			n.tracker.Release()
+		}
+	}()
+	for {
+		if !l.bucket.Wait(l.done) {
+			return
+		}
+		n, ok := l.next()
+		if !ok {
+			return
+		}
+		if n.Expired(time.Now()) {
+			n.tracker.Release()
+			countExpired(n, "connection "+l.conn.ID())
+			Trace(StageDropped, userOf(l.conn), n, "from the connection ", l.conn.ID(), " as its deadline passed")
+			if appCtx, ok := l.conn.Context().(*config.AppContext); ok {
+				notifyReceipt(appCtx.Session.User.ID, n, ReceiptExpired)
+			}
+			continue
+		}
+		l.emit(n)
+	}
+}
+
+//next returns the next notification to be emitted, urgent ones first. It blocks till a notification is queued.
+//It returns false if the lane is closed
+func (l *lane) next() (Notification, bool) {
+	select {
+	case n := <-l.urgent:
+		return n, true
+	default:
+	}
+	select {
+	case <-l.done:
+		return Notification{}, false
+	case n := <-l.urgent:
+		return n, true
+	case n := <-l.queue:
+		return n, true
+	}
+}
+
+//emit emits the notification to the connection. The payload is encoded with the codec of the connection.
+//For the json codec, the payload is emitted as it is
+func (l *lane) emit(n Notification) {
+	/*
+	 * We will start the span of the emit if the notification has a trace context
+	 * We will encode the payload if the connection uses a binary codec
+	 * Then we will write the notification along with its delivery metadata. If the write fails the connection is closed
+	 * In the ack mode, the ack callback is written too and the acknowledgement is awaited
+	 * Then we will keep the metadata of the event in the recent events of the connection
+	 * Then we will send the delivered receipt and mirror the notification to the watchers of the user
+	 */
+	defer n.tracker.Release()
+	var span *tracing.Span
+	if c, ok := tracing.Parse(n.Traceparent); ok {
+		span = tracing.Start(c, "socketio.emit", tracing.KindProducer).Set("notification.event", n.Event).
+			Set("notification.seq", n.Seq).Set("delivery.lane", string(l.name)).Set("connection.id", l.conn.ID())
+	}
+	payload := n.Payload
+	appCtx, ok := l.conn.Context().(*config.AppContext)
+	if ok && appCtx.Codec != nil && appCtx.Codec.Name() != codec.JSON {
+		b, err := appCtx.Codec.Marshal(n.Payload)
+		if err != nil {
+			span.End(err)
+			log.Error("error while encoding the payload of", n.Event, "with the codec", appCtx.Codec.Name(), "for the connection", l.conn.ID(), err.Error(), log.F("event", n.Event))
+			Trace(StageDropped, userOf(l.conn), n, "from the connection ", l.conn.ID(), " as its encoding failed: ", err.Error())
+			atomic.AddUint64(&failed, 1)
+			return
+		}
+		payload = b
+	}
+	args := []interface{}{payload}
+	if n.Seq != 0 {
+		args = append(args, n.Meta())
+	}
+	var ack chan struct{}
+	if n.Ack {
+		var f func()
+		f, ack = ackFunc()
+		args = append(args, f)
+	}
+	started := time.Now()
+	if err := l.write(n.Event, args...); err != nil {
+		span.End(err)
+		Trace(StageDropped, userOf(l.conn), n, "from the connection ", l.conn.ID(), " as the write failed: ", err.Error())
+		atomic.AddUint64(&failed, 1)
+		if err == ErrLaneClosed {
+			return
+		}
+		log.Error("error while writing the notification", n.Event, "to the connection", l.conn.ID(), ". Marking the connection bad", err.Error(), log.F("event", n.Event))
+		if err != ErrWriteTimeout {
+			CountWriteError(err)
+		}
+		l.conn.Close()
+		return
+	}
+	observeEmit(time.Since(started))
+	span.End(nil)
+	atomic.AddUint64(&sent, 1)
+	Trace(StageEmitted, userOf(l.conn), n, "to the connection ", l.conn.ID())
+	size := payloadSize(payload)
+	usage.Sent(tenantOf(l.conn), size)
+	l.recent.add(EmittedEvent{Event: n.Event, Lane: l.name, Seq: n.Seq, Size: size, At: started})
+	if n.Ack {
+		go l.awaitAck(n, ack, args...)
+	}
+	if ok {
+		if !n.Ack {
+			notifyReceipt(appCtx.Session.User.ID, n, ReceiptDelivered)
+		}
+		tap(appCtx.Session.User.ID, l.conn, n)
+	}
+}
+
+//payloadSize returns the size in bytes of the emitted payload. The payloads not encoded by a binary codec are sent as json
+func payloadSize(payload interface{}) int {
+	if b, ok := payload.([]byte); ok {
+		return len(b)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+//close stops the delivery of the lane. The notifications in the queue are dropped
+func (l *lane) close() {
+	close(l.done)
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"time"
+
+	"github.com/cuttle-ai/brain/models"
+)
+
+/*
+ * This file contains the definition of the notification accepted for delivery
+ */
+
+//Notification is the notification accepted by the service for delivery.
+//It embeds the brain notification model and carries the delivery options on top of it
+type Notification struct {
+	models.Notification
+	//Lane is the delivery lane to be used for the notification. Defaults to the data lane
+	Lane Lane `json:"lane,omitempty"`
+	//Priority of the notification. Defaults to normal. The system and high priority ones jump ahead of the queued ones
+	Priority Priority `json:"priority,omitempty"`
+	//Channels are the delivery channels through which the notification is sent irrespective of the connections of the user.
+	//Eg. ["push"]
+	Channels []string `json:"channels,omitempty"`
+	//Fallback is the channel through which the notification is sent if the realtime delivery fails. Eg. email.
+	//The notifications with a fallback are sent in the ack mode so that a notification lost with a closed tab falls back
+	Fallback string `json:"fallback,omitempty"`
+	//Seq is the sequence no. of the notification for the user. It is allocated by the service
+	Seq uint64 `json:"-"`
+	//Deadline is the time after which the undelivered copies of the notification are dropped
+	Deadline *time.Time `json:"deadline,omitempty"`
+	//DeadlineMs is the deadline in milliseconds from the time the notification is accepted.
+	//It is used only if the deadline is not given
+	DeadlineMs int64 `json:"deadlineMs,omitempty"`
+	//TTL is the time to live in seconds from the time the notification is accepted. The copies queued for the offline
+	//users or in the maintenance mode are dropped and marked expired after it. It is used only if the deadline is not given
+	TTL int64 `json:"ttl,omitempty"`
+	//ID is the id of the notification given by the producer. It is sent back in the receipts
+	ID string `json:"id,omitempty"`
+	//Callback is the url or the rpc endpoint of the producer to which the receipts are sent
+	Callback string `json:"callback,omitempty"`
+	//Category of the notification. The escalation policy of the category applies if the notification isn't acknowledged
+	Category string `json:"category,omitempty"`
+	//Escalation is the level of escalation of the notification. 0 for the original notification
+	Escalation int `json:"escalation,omitempty"`
+	//Urgency of the notification set by the escalation policy
+	Urgency string `json:"urgency,omitempty"`
+	//Room targets the connections in the room instead of the user. Room notifications are live only
+	Room string `json:"room,omitempty"`
+	//TargetUserID is the id of the user to whom the notification is sent. Defaults to the sender.
+	//Only the internal services can send to another user
+	TargetUserID uint `json:"targetUserId,omitempty"`
+	//Ack sends the notification in the ack mode. It is emitted again till a client of the user acknowledges it
+	Ack bool `json:"ack,omitempty"`
+	//Key is the logical key of the entity updated by the notification. The updates of a key arriving from
+	//multiple producers are resolved by the fan-in so that only the latest one is delivered
+	Key string `json:"key,omitempty"`
+	//Clock is the logical clock of the update of the key given by the producer. Defaults to the time of acceptance
+	Clock uint64 `json:"clock,omitempty"`
+	//Template is the name of the payload template with which the payload is rendered from the vars
+	Template string `json:"template,omitempty"`
+	//Vars are the values with which the payload template is rendered
+	Vars map[string]interface{} `json:"vars,omitempty"`
+	//DedupWindow is the time in seconds within which the same event with the same payload sent to a user again
+	//is suppressed. Defaults to the dedup window of the service
+	DedupWindow int64 `json:"dedupWindow,omitempty"`
+	//Traceparent is the W3C trace context of the notification. The spans of its delivery are traced as its children.
+	//It is set from the traceparent header for the http requests
+	Traceparent string `json:"traceparent,omitempty"`
+	//tracker tracks the copies of the notification queued to the connections till they are emitted or dropped
+	tracker *Tracker
+}
+
+//ResolveDeadline sets the deadline from the relative deadline or the ttl if the deadline is not set.
+//The notifications held before their delivery resolve it at the time of acceptance
+func (n *Notification) ResolveDeadline(accepted time.Time) {
+	if n.Deadline != nil {
+		return
+	}
+	var d time.Time
+	switch {
+	case n.DeadlineMs > 0:
+		d = accepted.Add(time.Duration(n.DeadlineMs) * time.Millisecond)
+	case n.TTL > 0:
+		d = accepted.Add(time.Duration(n.TTL) * time.Second)
+	default:
+		return
+	}
+	n.Deadline = &d
+}
+
+//Expired reports whether the deadline of the notification has passed at the given time
+func (n Notification) Expired(t time.Time) bool {
+	return n.Deadline != nil && n.Deadline.Before(t)
+}
+
+//Expire drops the notification held for the users before its delivery since its deadline has passed.
+//The dropped copies are counted and the expired receipts are sent to the producer
+func Expire(users []uint, n Notification, where string) {
+	if len(users) == 0 {
+		countExpired(n, where)
+		return
+	}
+	for _, u := range users {
+		countExpired(n, where)
+		notifyReceipt(u, n, ReceiptExpired)
+	}
+}
+
+//Meta is the delivery metadata emitted along with the payload of the notification
+type Meta struct {
+	//Seq is the sequence no. of the notification for the user. Clients acknowledge it for the replay
+	Seq uint64 `json:"seq"`
+	//Escalation is the level of escalation of the notification. Omitted for the original notification
+	Escalation int `json:"escalation,omitempty"`
+	//Urgency of the escalated notification
+	Urgency string `json:"urgency,omitempty"`
+}
+
+//Meta returns the delivery metadata of the notification
+func (n Notification) Meta() Meta {
+	return Meta{Seq: n.Seq, Escalation: n.Escalation, Urgency: n.Urgency}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the notifications of the offline users. When a notification recorded for the replay
+ * couldn't be sent to any live connection of the user, its sequence no. is kept as offline.
+ * On the next connect of the user, the offline notifications are taken from the replay log and sent to the connection.
+ * The offline marks are kept till the offline retention window. The replay log keeps the notifications themselves.
+ */
+
+//Offline marks a notification recorded for the replay which couldn't be sent to the user
+type Offline struct {
+	//UserID is the id of the user
+	UserID uint `gorm:"primary_key;auto_increment:false"`
+	//Seq is the sequence no. of the notification for the user
+	Seq uint64 `gorm:"primary_key;auto_increment:false"`
+	//CreatedAt is the time at which the notification was marked offline
+	CreatedAt time.Time `gorm:"index"`
+}
+
+//TableName returns the table name of the offline notifications
+func (Offline) TableName() string {
+	return "offline_notifications"
+}
+
+//offline has the in memory offline marks of each user. Used when the database is not enabled
+var offline = map[uint][]Offline{}
+
+//SaveOffline marks the notification with the sequence no. of the user as offline.
+//In memory, the marks are trimmed like the replay log
+func SaveOffline(db *gorm.DB, userID uint, seq uint64) error {
+	if seq == 0 || config.OfflineRetention <= 0 {
+		return nil
+	}
+	o := Offline{UserID: userID, Seq: seq, CreatedAt: time.Now()}
+	if db != nil {
+		return db.Create(&o).Error
+	}
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	l := append(offline[userID], o)
+	expiry := o.CreatedAt.Add(-config.OfflineRetention)
+	for len(l) > 0 && (len(l) > config.ReplayLogSize || l[0].CreatedAt.Before(expiry)) {
+		l = l[1:]
+	}
+	offline[userID] = l
+	return nil
+}
+
+//takeOfflineSQL deletes the unexpired offline marks of the user and returns their sequence nos.
+//So the marks are taken by only one connection even across the instances
+const takeOfflineSQL = `DELETE FROM offline_notifications WHERE user_id = ? AND created_at > ? RETURNING seq`
+
+//TakeOffline removes the offline marks of the user and returns their notifications from the replay log
+//in the order of the sequence nos. The notifications past their deadline are dropped
+func TakeOffline(db *gorm.DB, userID uint) ([]Delivered, error) {
+	/*
+	 * We will take the unexpired offline marks of the user
+	 * Then we will get their notifications from the replay log
+	 */
+	now := time.Now()
+	expiry := now.Add(-config.OfflineRetention)
+	if db != nil {
+		rows, err := db.Raw(takeOfflineSQL, userID, expiry).Rows()
+		if err != nil {
+			return nil, err
+		}
+		seqs := []uint64{}
+		for rows.Next() {
+			var s uint64
+			if err := rows.Scan(&s); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			seqs = append(seqs, s)
+		}
+		rows.Close()
+		logs := []Delivered{}
+		if len(seqs) == 0 {
+			return logs, nil
+		}
+		err = db.Where("user_id = ? AND seq IN (?) AND created_at > ?", userID, seqs, now.Add(-config.ReplayRetention)).
+			Order("seq").Find(&logs).Error
+		return unexpired(logs, now), err
+	}
+
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	marked := map[uint64]bool{}
+	for _, o := range offline[userID] {
+		if o.CreatedAt.After(expiry) {
+			marked[o.Seq] = true
+		}
+	}
+	delete(offline, userID)
+	logs := []Delivered{}
+	for _, d := range replayLogs[userID] {
+		if marked[d.Seq] {
+			logs = append(logs, d)
+		}
+	}
+	return unexpired(logs, now), nil
+}
+
+//purgeOffline purges the offline marks older than the offline retention window from the db
+func purgeOffline(db *gorm.DB) error {
+	return db.Where("created_at < ?", time.Now().Add(-config.OfflineRetention)).Delete(&Offline{}).Error
+}
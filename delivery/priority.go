@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import "errors"
+
+/*
+ * This file contains the priority levels of the notifications given by the producers.
+ * The system and high priority notifications are urgent. They jump ahead of the queued normal and low priority
+ * notifications in the lanes of the connections and are never held back by the sampling.
+ */
+
+//Priority is the priority level of a notification
+type Priority string
+
+const (
+	//PrioritySystem is the priority of the notifications sent by the service itself like the maintenance notices
+	PrioritySystem Priority = "system"
+	//PriorityHigh is the priority of the notifications which have to reach the user before the others
+	PriorityHigh Priority = "high"
+	//PriorityNormal is the default priority of the notifications
+	PriorityNormal Priority = "normal"
+	//PriorityLow is the priority of the notifications which can wait like the digests
+	PriorityLow Priority = "low"
+)
+
+//ErrInvalidPriority is returned when the priority of the notification is not a known priority level
+var ErrInvalidPriority = errors.New("priority has to be system, high, normal or low")
+
+//Check checks whether the priority is a known priority level. An empty priority is normal
+func (p Priority) Check() error {
+	switch p {
+	case "", PrioritySystem, PriorityHigh, PriorityNormal, PriorityLow:
+		return nil
+	}
+	return ErrInvalidPriority
+}
+
+//Urgent reports whether the notifications of the priority jump ahead of the others
+func (p Priority) Urgent() bool {
+	return p == PrioritySystem || p == PriorityHigh
+}
@@ -0,0 +1,327 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/store"
+	"github.com/cuttle-ai/websockets/tracing"
+	"github.com/cuttle-ai/websockets/webhook"
+)
+
+/*
+ * This file contains the delivery receipts sent to the callbacks of the producers.
+ * A producer can set a callback in the notification. It is notified when the notification is delivered, read or expired.
+ * The callback is either a http(s) url receiving the signed receipt as a webhook or
+ * an rpc endpoint as rpc://host:port/Service.Method receiving the receipt as the args.
+ * The callback of a notification is kept in the store by its sequence no. so that the receipts of
+ * the replayed and read notifications can be sent from any instance.
+ * Each status of a notification is sent once even if the user has many connections.
+ * The statuses of all the notifications can also be watched in process, Eg. by the grpc watchers.
+ */
+
+//ReceiptStatus is the status of the notification in a receipt
+type ReceiptStatus string
+
+const (
+	//ReceiptDelivered is sent when the notification is emitted to a connection of the user.
+	//For the ack mode, it is sent when a connection of the user acknowledges the notification
+	ReceiptDelivered ReceiptStatus = "delivered"
+	//ReceiptRead is sent when a client of the user marks the notification as read
+	ReceiptRead ReceiptStatus = "read"
+	//ReceiptExpired is sent when the notification is dropped since its deadline passed
+	ReceiptExpired ReceiptStatus = "expired"
+	//ReceiptFailed is sent when the notification sent in the ack mode isn't acknowledged after the retries
+	ReceiptFailed ReceiptStatus = "failed"
+)
+
+//Receipt is sent to the callback of the producer when the status of its notification changes
+type Receipt struct {
+	//ID is the id of the notification given by the producer
+	ID string `json:"id"`
+	//Event is the event of the notification
+	Event string `json:"event"`
+	//UserID is the id of the user to whom the notification was sent
+	UserID uint `json:"userId"`
+	//Seq is the sequence no. of the notification for the user
+	Seq uint64 `json:"seq"`
+	//Status is the status of the notification
+	Status ReceiptStatus `json:"status"`
+	//At is the time at which the status changed
+	At time.Time `json:"at"`
+}
+
+//ReceiptReply is the reply expected from the rpc callbacks
+type ReceiptReply struct {
+	//OK is set by the callback once it has processed the receipt
+	OK bool
+}
+
+//ErrCallbackNotAllowed is returned when the callback of the notification is not in the allowed hosts
+var ErrCallbackNotAllowed = errors.New("callback of the notification is not allowed")
+
+//receiptTarget is the callback of a notification kept in the store
+type receiptTarget struct {
+	ID       string `json:"id"`
+	Event    string `json:"event"`
+	Callback string `json:"callback"`
+}
+
+//receiptJob is a receipt waiting to be sent to the callback
+type receiptJob struct {
+	Receipt
+	callback string
+	//traceparent is the trace context of the notification propagated to the http callbacks
+	traceparent string
+}
+
+var (
+	//receipts has the receipts waiting to be sent
+	receipts chan receiptJob
+	//startReceipts starts the receipt senders once
+	startReceipts sync.Once
+	//receiptClient is the http client used to send the receipts
+	receiptClient = &http.Client{Timeout: config.ReceiptTimeout}
+)
+
+//receiptSenders is the no. of go routines sending the receipts
+const receiptSenders = 4
+
+//CheckCallback checks whether the callback is a valid url or rpc endpoint to an allowed host.
+//An empty callback is valid
+func CheckCallback(callback string) error {
+	if len(callback) == 0 {
+		return nil
+	}
+	u, err := url.Parse(callback)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "rpc" {
+		return errors.New("callback scheme has to be http, https or rpc")
+	}
+	for _, h := range config.ReceiptCallbackHosts {
+		if h == u.Host || h == u.Hostname() {
+			return nil
+		}
+	}
+	return ErrCallbackNotAllowed
+}
+
+//receiptKey returns the store key of the callback of the notification sent to the user
+func receiptKey(userID uint, seq uint64) string {
+	return "receipt/" + strconv.FormatUint(uint64(userID), 10) + "/" + strconv.FormatUint(seq, 10)
+}
+
+//keepCallback keeps the callback of the notification sent to the user in the store till the replay retention
+func keepCallback(userID uint, n *Notification) {
+	/*
+	 * We will drop the callback if it is not allowed
+	 * Then we will save it in the store
+	 */
+	if len(n.Callback) == 0 {
+		return
+	}
+	if err := CheckCallback(n.Callback); err != nil {
+		log.Warn("dropping the callback", n.Callback, "of the notification", n.Event, err.Error())
+		n.Callback = ""
+		return
+	}
+	b, err := json.Marshal(receiptTarget{ID: n.ID, Event: n.Event, Callback: n.Callback})
+	if err == nil {
+		err = store.Default.Set(receiptKey(userID, n.Seq), b, config.ReplayRetention)
+	}
+	if err != nil {
+		log.Error("error while keeping the callback of the notification", n.Seq, "of user", userID, err.Error())
+	}
+}
+
+//receiptQueue returns the queue of the receipts. The senders are started with the first call
+func receiptQueue() chan receiptJob {
+	startReceipts.Do(func() {
+		receipts = make(chan receiptJob, config.ReceiptQueueSize)
+		for i := 0; i < receiptSenders; i++ {
+			go sendReceipts()
+		}
+	})
+	return receipts
+}
+
+//ReceiptQueue returns the no. of receipts waiting to be sent and the capacity of the queue
+func ReceiptQueue() (int, int) {
+	q := receiptQueue()
+	return len(q), cap(q)
+}
+
+var (
+	//watchers has the channels of the watchers of the receipts
+	watchers = map[chan Receipt]struct{}{}
+	//watchersLock is the lock for the watchers
+	watchersLock sync.Mutex
+)
+
+//WatchReceipts returns the channel on which the receipts of all the notifications sent from the instance
+//are sent along with the func to stop watching. The receipts are sent for every connection without the callbacks.
+//The receipts are dropped when the buffer of the watcher is full
+func WatchReceipts(buffer int) (<-chan Receipt, func()) {
+	ch := make(chan Receipt, buffer)
+	watchersLock.Lock()
+	watchers[ch] = struct{}{}
+	watchersLock.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			watchersLock.Lock()
+			delete(watchers, ch)
+			watchersLock.Unlock()
+		})
+	}
+}
+
+//watched sends the receipt to the watchers without blocking
+func watched(r Receipt) {
+	watchersLock.Lock()
+	defer watchersLock.Unlock()
+	for ch := range watchers {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+}
+
+//notifyReceipt sends the receipt of the notification sent to the user to the watchers and
+//queues it if the notification has a callback. It never blocks. If the queue is full, the receipt is dropped.
+//The notifications dropped before their sequence no. was allocated get the receipt only with their own callback
+func notifyReceipt(userID uint, n Notification, status ReceiptStatus) {
+	/*
+	 * We will send the receipt to the watchers
+	 * We will find the callback from the notification or from the store
+	 * Then we will make sure the status is sent only once
+	 * Then we will queue the receipt
+	 */
+	if n.Seq == 0 && len(n.Callback) == 0 {
+		return
+	}
+	watched(Receipt{ID: n.ID, Event: n.Event, UserID: userID, Seq: n.Seq, Status: status, At: time.Now()})
+	if len(config.ReceiptCallbackHosts) == 0 {
+		return
+	}
+	t := receiptTarget{ID: n.ID, Event: n.Event, Callback: n.Callback}
+	if len(t.Callback) == 0 {
+		b, err := store.Default.Get(receiptKey(userID, n.Seq))
+		if err != nil || json.Unmarshal(b, &t) != nil {
+			return
+		}
+	}
+
+	//sending the status only once. The notifications without the sequence no. are dropped only once
+	if n.Seq != 0 {
+		key := receiptKey(userID, n.Seq) + "/" + string(status)
+		if ok, err := store.Default.SetNX(key, []byte{1}, config.ReplayRetention); err != nil || !ok {
+			return
+		}
+	}
+
+	//queueing the receipt
+	r := Receipt{ID: t.ID, Event: t.Event, UserID: userID, Seq: n.Seq, Status: status, At: time.Now()}
+	select {
+	case receiptQueue() <- receiptJob{Receipt: r, callback: t.Callback, traceparent: n.Traceparent}:
+	default:
+		log.Warn("receipt queue is full. dropping the", status, "receipt of the notification", n.Seq, "of user", userID)
+	}
+}
+
+//Read sends the read receipt of the notification with the sequence no. of the user
+func Read(userID uint, seq uint64) {
+	notifyReceipt(userID, Notification{Seq: seq}, ReceiptRead)
+}
+
+//sendReceipts sends the queued receipts to the callbacks
+func sendReceipts() {
+	for j := range receipts {
+		var err error
+		if strings.HasPrefix(j.callback, "rpc://") {
+			err = callReceipt(j.callback, j.Receipt)
+		} else {
+			err = postReceipt(j.callback, j.traceparent, j.Receipt)
+		}
+		if err != nil {
+			log.Warn("error while sending the", j.Status, "receipt of the notification", j.Seq, "of user", j.UserID, "to", j.callback, err.Error())
+		}
+	}
+}
+
+//postReceipt posts the receipt to the url as a signed webhook. The call is traced as a child of the trace context of the notification
+func postReceipt(callback, traceparent string, r Receipt) (err error) {
+	/*
+	 * We will start the span of the call if the notification has a trace context
+	 * We will encode the receipt
+	 * Then we will sign it
+	 * Then we will post it
+	 */
+	var span *tracing.Span
+	if c, ok := tracing.Parse(traceparent); ok {
+		span = tracing.Start(c, "receipt.post", tracing.KindClient).Set("http.url", callback).Set("receipt.status", string(r.Status))
+		defer func() { span.End(err) }()
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	id := "receipt_" + strconv.FormatUint(uint64(r.UserID), 10) + "_" + strconv.FormatUint(r.Seq, 10) + "_" + string(r.Status)
+	h, err := webhook.Sign(webhook.DefaultTenant, id, r.At, body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(req.Header, span.Context())
+	res, err := receiptClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.New("callback responded with " + res.Status)
+	}
+	return nil
+}
+
+//callReceipt calls the rpc endpoint rpc://host:port/Service.Method with the receipt
+func callReceipt(callback string, r Receipt) error {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return err
+	}
+	client, err := rpc.Dial("tcp", u.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	call := client.Go(strings.TrimPrefix(u.Path, "/"), r, &ReceiptReply{}, nil)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(config.ReceiptTimeout):
+		return errors.New("rpc callback timed out")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sync"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the ring buffer of the events recently emitted to a connection.
+ * Only the metadata of the events is kept, never the payload. The clients fetch it to confirm what the server sent them.
+ */
+
+//EmittedEvent is the metadata of an event emitted to a connection
+type EmittedEvent struct {
+	//Event emitted
+	Event string `json:"event"`
+	//Lane in which the event was emitted
+	Lane Lane `json:"lane"`
+	//Seq is the sequence no. of the notification. 0 for the notifications not recorded for the replay
+	Seq uint64 `json:"seq,omitempty"`
+	//Size is the size of the payload in bytes
+	Size int `json:"size"`
+	//At is the time at which the event was emitted
+	At time.Time `json:"at"`
+}
+
+//recentEvents is the ring buffer of the events recently emitted to a connection
+type recentEvents struct {
+	//events in the ring
+	events []EmittedEvent
+	//next is the index at which the next event is kept
+	next int
+	//full is true once the ring has wrapped around
+	full bool
+	//lock for the ring
+	lock sync.Mutex
+}
+
+//newRecentEvents returns the ring buffer of the size. nil is returned if the size is 0
+func newRecentEvents(size int) *recentEvents {
+	if size <= 0 {
+		return nil
+	}
+	return &recentEvents{events: make([]EmittedEvent, size)}
+}
+
+//add keeps the event in the ring, overwriting the oldest one if the ring is full
+func (r *recentEvents) add(e EmittedEvent) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+//list returns the events in the ring, the oldest first
+func (r *recentEvents) list() []EmittedEvent {
+	if r == nil {
+		return []EmittedEvent{}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.full {
+		return append([]EmittedEvent{}, r.events[:r.next]...)
+	}
+	return append(append([]EmittedEvent{}, r.events[r.next:]...), r.events[:r.next]...)
+}
+
+//Recent returns the metadata of the events recently emitted to the connection, the oldest first
+func Recent(conn socketio.Conn) ([]EmittedEvent, error) {
+	outboxesLock.RLock()
+	o, ok := outboxes[conn.ID()]
+	outboxesLock.RUnlock()
+	if !ok {
+		return nil, ErrNoOutbox
+	}
+	return o.recent.list(), nil
+}
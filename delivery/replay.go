@@ -0,0 +1,353 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/filter"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the sequencing of the notifications delivered to the users and the per device cursors.
+ * Every notification sent to a user gets a sequence no. and is kept in the replay log till the retention window.
+ * Devices acknowledge the sequence no. they have seen and the replay resumes from there.
+ * If the database is enabled, the replay log and the cursors are persisted else they are kept in memory.
+ */
+
+//Delivered is a notification delivered to a user. It is kept in the replay log till the retention window
+type Delivered struct {
+	//ID is the id of the record
+	ID uint `gorm:"primary_key" json:"-"`
+	//UserID is the id of the user to whom the notification was delivered
+	UserID uint `gorm:"index" json:"-"`
+	//Seq is the sequence no. of the notification for the user
+	Seq uint64 `gorm:"index" json:"seq"`
+	//Event is the event name of the notification
+	Event string `json:"event"`
+	//Payload is the json encoded payload of the notification
+	Payload string `gorm:"type:text" json:"payload"`
+	//Deadline is the time after which the notification must not be replayed
+	Deadline *time.Time `json:"deadline,omitempty"`
+	//Ack is set if the notification was sent in the ack mode
+	Ack bool `json:"ack,omitempty"`
+	//Status is the delivery status of the notification sent in the ack mode
+	Status AckStatus `gorm:"index" json:"status,omitempty"`
+	//CreatedAt is the time at which the notification was delivered
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//TableName returns the table name of the replay log
+func (Delivered) TableName() string {
+	return "delivered_notifications"
+}
+
+//Notification returns the notification to be sent for the replay
+func (d Delivered) Notification() Notification {
+	n := Notification{Seq: d.Seq, Deadline: d.Deadline, Ack: d.Ack}
+	n.Event = d.Event
+	if err := json.Unmarshal([]byte(d.Payload), &n.Payload); err != nil {
+		log.Error("error while decoding the payload of the replayed notification", d.Seq, "of user", d.UserID, err.Error())
+	}
+	return n
+}
+
+//Cursor is the delivery cursor of a device of a user
+type Cursor struct {
+	//UserID is the id of the user
+	UserID uint `gorm:"primary_key;auto_increment:false"`
+	//DeviceID is the id of the device of the user
+	DeviceID string `gorm:"primary_key"`
+	//Seq is the last sequence no. acknowledged by the device
+	Seq uint64
+	//UpdatedAt is the time at which the cursor was last moved
+	UpdatedAt time.Time
+}
+
+var (
+	//replayLogs has the in memory replay log of each user. Used when the database is not enabled
+	replayLogs = map[uint][]Delivered{}
+	//cursors has the in memory cursors of the devices of each user. Used when the database is not enabled
+	cursors = map[uint]map[string]uint64{}
+	//replayLock is the lock for the replay logs and cursors
+	replayLock sync.Mutex
+)
+
+//InitReplay will migrate the replay tables and start the purging of the expired replay logs.
+//If the db is nil, the replay logs are kept in memory
+func InitReplay(db *gorm.DB) error {
+	/*
+	 * If the db is not there we will skip the migration
+	 * We will migrate the tables
+	 * Then we will start the purge routine and the sequence lease reaper on the leader instance
+	 */
+	if db == nil {
+		return nil
+	}
+	if err := db.AutoMigrate(&Delivered{}, &Cursor{}, &SequenceCounter{}, &SequenceLease{}, &Offline{}).Error; err != nil {
+		return err
+	}
+	leader.Run("replay-purge", db, func(done <-chan struct{}) {
+		purgeReplay(db, done)
+	})
+	leader.Run("sequence-reaper", db, func(done <-chan struct{}) {
+		reapLeases(db, done)
+	})
+	return nil
+}
+
+//purgeReplay periodically purges the replay logs and the offline marks older than their retention windows
+//from the db till done is closed. It runs only on the leader instance
+func purgeReplay(db *gorm.DB, done <-chan struct{}) {
+	t := time.NewTicker(config.ReplayPurgeCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		err := db.Where("created_at < ?", time.Now().Add(-config.ReplayRetention)).Delete(&Delivered{}).Error
+		if err != nil {
+			log.Error("error while purging the expired replay logs", err.Error())
+		}
+		if err := purgeOffline(db); err != nil {
+			log.Error("error while purging the expired offline notifications", err.Error())
+		}
+	}
+}
+
+//TableName returns the table name of the device cursors
+func (Cursor) TableName() string {
+	return "device_cursors"
+}
+
+//recordLocks serialize the allocation of the sequence nos. of the users and the recording in the in memory replay log
+//so that the replay log of a user is always in the order of the sequence nos. The lock of a user is picked by the user id.
+//The insert into the db replay log is done after the lock is released as the db replay log is read in the order of the sequence nos.
+var recordLocks [64]sync.Mutex
+
+//Record will allocate the sequence no. for the notification sent to the user and add it to the replay log
+func Record(db *gorm.DB, userID uint, n *Notification) error {
+	/*
+	 * We will encode the payload
+	 * Then we will allocate the sequence no. and record it under the record lock of the user
+	 * If the db is enabled we will persist it after the lock is released
+	 */
+	p, err := json.Marshal(n.Payload)
+	if err != nil {
+		return err
+	}
+	d, err := allocate(db, userID, n, p)
+	if err != nil || db == nil {
+		return err
+	}
+	return db.Create(&d).Error
+}
+
+//allocate allocates the sequence no. for the notification sent to the user under the record lock of the user
+//and returns the record of the replay log. Without the db the record is added to the in memory replay log
+func allocate(db *gorm.DB, userID uint, n *Notification, p []byte) (Delivered, error) {
+	/*
+	 * We will take the record lock of the user
+	 * We will allocate the sequence no. and resolve the deadline
+	 * We will keep the callback of the notification for the receipts
+	 * If the db is not enabled we will add it to the in memory replay log trimming the expired and the overflowing ones
+	 */
+	l := &recordLocks[userID%uint(len(recordLocks))]
+	l.Lock()
+	defer l.Unlock()
+	seq, err := nextSeq(db, userID)
+	if err != nil {
+		return Delivered{}, err
+	}
+	n.Seq = seq
+	n.ResolveDeadline(time.Now())
+	keepCallback(userID, n)
+	Trace(StageRecorded, userID, *n)
+
+	d := Delivered{UserID: userID, Seq: n.Seq, Event: n.Event, Payload: string(p), Deadline: n.Deadline, Ack: n.Ack, CreatedAt: time.Now()}
+	if n.Ack {
+		d.Status = StatusPending
+	}
+	if db != nil {
+		return d, nil
+	}
+
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	logs := append(replayLogs[userID], d)
+	expiry := d.CreatedAt.Add(-config.ReplayRetention)
+	for len(logs) > 0 && (len(logs) > config.ReplayLogSize || logs[0].CreatedAt.Before(expiry)) {
+		logs = logs[1:]
+	}
+	replayLogs[userID] = logs
+	return d, nil
+}
+
+//Ack will move the cursor of the device of the user to the given sequence no.
+//The cursor never moves backwards
+func Ack(db *gorm.DB, userID uint, deviceID string, seq uint64) error {
+	Trace(StageAcked, userID, Notification{Seq: seq}, "by the device ", deviceID)
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	if db == nil {
+		c, ok := cursors[userID]
+		if !ok {
+			c = map[string]uint64{}
+			cursors[userID] = c
+		}
+		if c[deviceID] < seq {
+			c[deviceID] = seq
+		}
+		return nil
+	}
+
+	c := &Cursor{}
+	err := db.Where(Cursor{UserID: userID, DeviceID: deviceID}).FirstOrInit(c).Error
+	if err != nil {
+		return err
+	}
+	if c.Seq >= seq {
+		return nil
+	}
+	c.Seq = seq
+	return db.Save(c).Error
+}
+
+//LastAcked returns the last sequence no. acknowledged by the device of the user
+func LastAcked(db *gorm.DB, userID uint, deviceID string) (uint64, error) {
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	if db == nil {
+		return cursors[userID][deviceID], nil
+	}
+	c := &Cursor{}
+	err := db.Where(Cursor{UserID: userID, DeviceID: deviceID}).First(c).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0, nil
+	}
+	return c.Seq, err
+}
+
+//Acked reports whether any device of the user has acknowledged the sequence no.
+func Acked(db *gorm.DB, userID uint, seq uint64) (bool, error) {
+	replayLock.Lock()
+	defer replayLock.Unlock()
+	if db == nil {
+		for _, s := range cursors[userID] {
+			if s >= seq {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	n := 0
+	err := db.Model(&Cursor{}).Where("user_id = ? AND seq >= ?", userID, seq).Count(&n).Error
+	return n != 0, err
+}
+
+//DeliveredSchema is the filter schema of the delivered notifications
+var DeliveredSchema = filter.Schema{
+	Fields: map[string]filter.Field{
+		"event":     {Column: "event", Type: filter.String, Ops: filter.Text},
+		"seq":       {Column: "seq", Type: filter.Uint, Ops: filter.Comparison, Sortable: true},
+		"status":    {Column: "status", Type: filter.String, Ops: []filter.Op{filter.Eq}},
+		"createdAt": {Column: "created_at", Type: filter.Time, Ops: filter.Comparison, Sortable: true},
+	},
+	MaxLimit: 500,
+}
+
+//field returns the value of the field of the delivered notification as per the filter schema
+func (d Delivered) field(name string) interface{} {
+	switch name {
+	case "event":
+		return d.Event
+	case "seq":
+		return d.Seq
+	case "status":
+		return string(d.Status)
+	case "createdAt":
+		return d.CreatedAt
+	}
+	return nil
+}
+
+//Since returns the notifications delivered to the user after the given sequence no. matching the filter conditions.
+//If the sequence no. is 0, the notifications after the last acknowledged sequence no. of the device are returned.
+//They are in the order of the sequence nos. unless the query has a sort order and are paginated as per the query.
+//At most the replay log size notifications are returned
+func Since(db *gorm.DB, userID uint, deviceID string, since uint64, q filter.Query) ([]Delivered, error) {
+	/*
+	 * If the since is not given we will take it from the cursor of the device
+	 * Then we will get the notifications from the replay log
+	 * Then we will sort and paginate them
+	 */
+	if since == 0 {
+		s, err := LastAcked(db, userID, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		since = s
+	}
+	if q.Limit <= 0 || q.Limit > config.ReplayLogSize {
+		q.Limit = config.ReplayLogSize
+	}
+
+	now := time.Now()
+	expiry := now.Add(-config.ReplayRetention)
+	if db != nil {
+		logs := []Delivered{}
+		err := q.Apply(db.Where("user_id = ? AND seq > ? AND created_at > ?", userID, since, expiry)).
+			Order("seq").Find(&logs).Error
+		return unexpired(logs, now), err
+	}
+
+	replayLock.Lock()
+	logs := []Delivered{}
+	for _, d := range replayLogs[userID] {
+		if d.Seq > since && d.CreatedAt.After(expiry) && q.Match(d.field) {
+			logs = append(logs, d)
+		}
+	}
+	replayLock.Unlock()
+
+	//sorting and paginating
+	sort.SliceStable(logs, func(i, j int) bool {
+		return q.Less(logs[i].field, logs[j].field)
+	})
+	if q.Offset >= len(logs) {
+		return []Delivered{}, nil
+	}
+	logs = logs[q.Offset:]
+	if len(logs) > q.Limit {
+		logs = logs[:q.Limit]
+	}
+	return unexpired(logs, now), nil
+}
+
+//unexpired filters out the notifications whose deadline has passed. The dropped notifications are counted
+//and their expired receipts are sent
+func unexpired(logs []Delivered, now time.Time) []Delivered {
+	result := []Delivered{}
+	for _, d := range logs {
+		if d.Deadline != nil && d.Deadline.Before(now) {
+			n := d.Notification()
+			countExpired(n, "replay of user "+strconv.FormatUint(uint64(d.UserID), 10))
+			notifyReceipt(d.UserID, n, ReceiptExpired)
+			continue
+		}
+		result = append(result, d)
+	}
+	return result
+}
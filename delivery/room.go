@@ -0,0 +1,83 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sort"
+	"sync"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the rooms of the connections. A room is a named group of connections which can be targeted
+ * by a notification. A connection leaves all its rooms when its outbox is closed.
+ */
+
+var (
+	//rooms has the connections of the rooms mapped by the room and the connection id
+	rooms = map[string]map[string]socketio.Conn{}
+	//roomsLock is the lock for the rooms
+	roomsLock sync.RWMutex
+)
+
+//Join adds the connection to the room
+func Join(room string, conn socketio.Conn) {
+	roomsLock.Lock()
+	defer roomsLock.Unlock()
+	r, ok := rooms[room]
+	if !ok {
+		r = map[string]socketio.Conn{}
+		rooms[room] = r
+	}
+	r[conn.ID()] = conn
+}
+
+//Leave removes the connection from the room
+func Leave(room string, conn socketio.Conn) {
+	roomsLock.Lock()
+	defer roomsLock.Unlock()
+	delete(rooms[room], conn.ID())
+	if len(rooms[room]) == 0 {
+		delete(rooms, room)
+	}
+}
+
+//leaveAll removes the connection from all the rooms
+func leaveAll(conn socketio.Conn) {
+	roomsLock.Lock()
+	defer roomsLock.Unlock()
+	for room, r := range rooms {
+		delete(r, conn.ID())
+		if len(r) == 0 {
+			delete(rooms, room)
+		}
+	}
+}
+
+//Members returns the connections in the room
+func Members(room string) []socketio.Conn {
+	roomsLock.RLock()
+	defer roomsLock.RUnlock()
+	result := make([]socketio.Conn, 0, len(rooms[room]))
+	for _, conn := range rooms[room] {
+		result = append(result, conn)
+	}
+	return result
+}
+
+//RoomsOf returns the sorted rooms of the connection
+func RoomsOf(conn socketio.Conn) []string {
+	roomsLock.RLock()
+	defer roomsLock.RUnlock()
+	result := []string{}
+	for room, r := range rooms {
+		if _, ok := r[conn.ID()]; ok {
+			result = append(result, room)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the allocation of the sequence nos. of the users across the instances.
+ * When the database is enabled, an instance leases a range of sequence nos. for a user from the shared counter
+ * and allocates from it till the range is exhausted or the lease expires. So the instances never collide.
+ * Every allocated sequence no. is accounted for in the replay log either as a notification or in a void marker.
+ * The leader reaps the expired leases and records their unused sequence nos. as void markers.
+ * So a client seeing a gap can always resolve it through the replay and tell a skipped range from a lost notification.
+ * Without the database, the sequences are allocated in memory as there is a single instance.
+ */
+
+//VoidEvent is the event of the marker recorded in the replay log for a range of unused sequence nos.
+//The payload of the marker has the from and to of the range both inclusive
+const VoidEvent = "sequence-void"
+
+//VoidRange is the payload of the void marker
+type VoidRange struct {
+	//From is the first sequence no. of the unused range
+	From uint64 `json:"from"`
+	//To is the last sequence no. of the unused range
+	To uint64 `json:"to"`
+}
+
+//SequenceCounter is the shared counter of the sequence nos. of a user
+type SequenceCounter struct {
+	//UserID is the id of the user
+	UserID uint `gorm:"primary_key;auto_increment:false"`
+	//Next is the next sequence no. to be leased
+	Next uint64
+}
+
+//TableName returns the table name of the sequence counters
+func (SequenceCounter) TableName() string {
+	return "sequence_counters"
+}
+
+//SequenceLease is a range of sequence nos. of a user leased by an instance
+type SequenceLease struct {
+	//ID of the lease
+	ID uint `gorm:"primary_key"`
+	//UserID is the id of the user
+	UserID uint `gorm:"index"`
+	//Start is the first sequence no. of the lease
+	Start uint64
+	//End is the last sequence no. of the lease
+	End uint64
+	//Owner is the instance holding the lease
+	Owner string
+	//ExpiresAt is the time after which the lease can't be used
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+//TableName returns the table name of the sequence leases
+func (SequenceLease) TableName() string {
+	return "sequence_leases"
+}
+
+var (
+	//sequences has the last sequence no. allocated for each user when the db is not enabled
+	sequences = map[uint]uint64{}
+	//leases has the active lease of each user held by the instance
+	leases = map[uint]*SequenceLease{}
+	//sequencesLock is the lock for the sequences and leases maps. It is never held across the db
+	sequencesLock sync.Mutex
+)
+
+//leaseSQL atomically moves the shared counter of the user by the lease size and returns the next sequence no.
+//The counter of a new user starts after the sequence nos. already in the replay log
+const leaseSQL = `INSERT INTO sequence_counters (user_id, next)
+VALUES (?, (SELECT COALESCE(MAX(seq), 0) FROM delivered_notifications WHERE user_id = ?) + 1 + ?)
+ON CONFLICT (user_id) DO UPDATE SET next = sequence_counters.next + ?
+RETURNING next`
+
+//nextSeq returns the next sequence no. for the user.
+//The caller must hold the record lock of the user, which serializes the allocations of the user
+//so the new range can be leased from the db without blocking the allocations of the other users
+func nextSeq(db *gorm.DB, userID uint) (uint64, error) {
+	/*
+	 * Without the db, we will allocate in memory
+	 * If the instance doesn't have a usable lease for the user, we will lease a new range outside the sequences lock
+	 * Then we will allocate from the lease
+	 */
+	sequencesLock.Lock()
+	if db == nil {
+		sequences[userID]++
+		seq := sequences[userID]
+		sequencesLock.Unlock()
+		return seq, nil
+	}
+	l, ok := leases[userID]
+	sequencesLock.Unlock()
+
+	if !ok || l.Start > l.End || time.Now().After(l.ExpiresAt) {
+		nl, err := lease(db, userID)
+		if err != nil {
+			return 0, err
+		}
+		l = nl
+		sequencesLock.Lock()
+		leases[userID] = l
+		sequencesLock.Unlock()
+	}
+	seq := l.Start
+	l.Start++
+	return seq, nil
+}
+
+//lease leases a new range of sequence nos. for the user from the shared counter
+func lease(db *gorm.DB, userID uint) (*SequenceLease, error) {
+	var next uint64
+	size := config.SequenceLeaseSize
+	err := db.Raw(leaseSQL, userID, userID, size, size).Row().Scan(&next)
+	if err != nil {
+		return nil, err
+	}
+	l := &SequenceLease{
+		UserID:    userID,
+		Start:     next - size,
+		End:       next - 1,
+		Owner:     config.ServiceDomain + ":" + config.Port,
+		ExpiresAt: time.Now().Add(config.SequenceLeaseTTL),
+	}
+	if err := db.Create(l).Error; err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+//reapLeases periodically voids the unused sequence nos. of the expired leases till done is closed.
+//It runs only on the leader instance
+func reapLeases(db *gorm.DB, done <-chan struct{}) {
+	t := time.NewTicker(config.SequenceLeaseTTL)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		expired := []SequenceLease{}
+		err := db.Where("expires_at < ?", time.Now().Add(-config.SequenceLeaseGrace)).Find(&expired).Error
+		if err != nil {
+			log.Error("error while getting the expired sequence leases", err.Error())
+			continue
+		}
+		for _, l := range expired {
+			if err := reapLease(db, l); err != nil {
+				log.Error("error while reaping the sequence lease", l.ID, "of user", l.UserID, err.Error())
+			}
+		}
+	}
+}
+
+//reapLease records the void markers for the sequence nos. of the lease missing in the replay log and deletes the lease
+func reapLease(db *gorm.DB, l SequenceLease) error {
+	/*
+	 * We will get the sequence nos. of the lease used in the replay log
+	 * Then we will find the unused ranges and record the void markers for them
+	 * Then we will delete the lease
+	 */
+	used := []uint64{}
+	err := db.Model(&Delivered{}).Where("user_id = ? AND seq BETWEEN ? AND ?", l.UserID, l.Start, l.End).
+		Order("seq").Pluck("seq", &used).Error
+	if err != nil {
+		return err
+	}
+	voids := []VoidRange{}
+	from := l.Start
+	for _, u := range append(used, l.End+1) {
+		if u > from {
+			voids = append(voids, VoidRange{From: from, To: u - 1})
+		}
+		if u >= from {
+			from = u + 1
+		}
+	}
+	for _, v := range voids {
+		p, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		d := Delivered{UserID: l.UserID, Seq: v.From, Event: VoidEvent, Payload: string(p), CreatedAt: time.Now()}
+		if err := db.Create(&d).Error; err != nil {
+			return err
+		}
+	}
+	return db.Delete(&l).Error
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/log"
+)
+
+/*
+ * This file contains the counters of the delivery
+ */
+
+var (
+	//expired is the no. of notification copies dropped since their deadline passed before the delivery
+	expired uint64
+	//writeTransient is the no. of transient write failures. Eg. a write not completing within the write timeout
+	writeTransient uint64
+	//writeRecovered is the no. of writes which completed after a transient failure
+	writeRecovered uint64
+	//writeFatal is the no. of fatal write failures after which the connection was marked bad
+	writeFatal uint64
+	//sent is the no. of notification copies emitted to the connections
+	sent uint64
+	//failed is the no. of notification copies which couldn't be emitted. Eg. the write or the encoding failed
+	failed uint64
+	//superseded is the no. of notifications dropped as a later or higher priority update of their key was accepted
+	superseded uint64
+	//deduplicated is the no. of notification copies suppressed as the same one was sent to the user within the dedup window
+	deduplicated uint64
+)
+
+//EmitLatencyBuckets are the upper bounds in seconds of the buckets of the emit latency histogram
+var EmitLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+var (
+	//emitLatencyCounts has the no. of emits in each bucket of the emit latency. The last one is the +Inf bucket
+	emitLatencyCounts = make([]uint64, len(EmitLatencyBuckets)+1)
+	//emitLatencyNanos is the total time spent in the emits
+	emitLatencyNanos uint64
+)
+
+//observeEmit counts the time taken by an emit in the emit latency histogram
+func observeEmit(d time.Duration) {
+	atomic.AddUint64(&emitLatencyNanos, uint64(d))
+	s := d.Seconds()
+	for i, b := range EmitLatencyBuckets {
+		if s <= b {
+			atomic.AddUint64(&emitLatencyCounts[i], 1)
+			return
+		}
+	}
+	atomic.AddUint64(&emitLatencyCounts[len(EmitLatencyBuckets)], 1)
+}
+
+//EmitLatency returns the non cumulative no. of emits in each bucket of the emit latency histogram,
+//the last one being the +Inf bucket, and the total time spent in the emits in seconds
+func EmitLatency() ([]uint64, float64) {
+	counts := make([]uint64, len(emitLatencyCounts))
+	for i := range emitLatencyCounts {
+		counts[i] = atomic.LoadUint64(&emitLatencyCounts[i])
+	}
+	return counts, time.Duration(atomic.LoadUint64(&emitLatencyNanos)).Seconds()
+}
+
+//countExpired counts the notification dropped due to the deadline
+func countExpired(n Notification, where string) {
+	atomic.AddUint64(&expired, 1)
+	log.Warn("dropping the notification", n.Event, "with seq", n.Seq, "from the", where, "since its deadline", n.Deadline, "has passed")
+}
+
+//CountSuperseded counts the notification dropped by the fan-in as superseded
+func CountSuperseded() {
+	atomic.AddUint64(&superseded, 1)
+}
+
+//CountDeduplicated counts the notification copy suppressed by the deduplication
+func CountDeduplicated() {
+	atomic.AddUint64(&deduplicated, 1)
+}
+
+//Counters returns the counters of the delivery
+func Counters() map[string]uint64 {
+	return map[string]uint64{
+		"expired":         atomic.LoadUint64(&expired),
+		"write_transient": atomic.LoadUint64(&writeTransient),
+		"write_recovered": atomic.LoadUint64(&writeRecovered),
+		"write_fatal":     atomic.LoadUint64(&writeFatal),
+		"sent":            atomic.LoadUint64(&sent),
+		"failed":          atomic.LoadUint64(&failed),
+		"ack_retried":     atomic.LoadUint64(&ackRetried),
+		"acked":           atomic.LoadUint64(&acked),
+		"unacked":         atomic.LoadUint64(&unacked),
+		"superseded":      atomic.LoadUint64(&superseded),
+		"deduplicated":    atomic.LoadUint64(&deduplicated),
+	}
+}
+
+//CountWriteError counts the write error of a connection as transient or fatal
+func CountWriteError(err error) {
+	if Transient(err) {
+		atomic.AddUint64(&writeTransient, 1)
+		return
+	}
+	atomic.AddUint64(&writeFatal, 1)
+}
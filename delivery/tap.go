@@ -0,0 +1,129 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/redact"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the taps on the event stream of the users used for the impersonation.
+ * Every notification emitted to a connection of a tapped user is mirrored to the watcher connections
+ * with the sensitive payload fields redacted. The watchers only receive, so the view is read only.
+ */
+
+//TapEvent is the event with which the mirrored notifications are emitted to the watchers
+const TapEvent = "impersonation"
+
+//Tapped is a notification emitted to a tapped user mirrored to the watchers
+type Tapped struct {
+	//UserID is the id of the tapped user
+	UserID uint `json:"userId"`
+	//ConnID is the id of the connection of the user to which the notification was emitted
+	ConnID string `json:"connId"`
+	//Event is the event of the notification
+	Event string `json:"event"`
+	//Payload is the redacted payload of the notification
+	Payload interface{} `json:"payload"`
+	//Meta is the delivery metadata emitted along with the notification
+	Meta Meta `json:"meta"`
+	//At is the time at which the notification was emitted
+	At time.Time `json:"at"`
+}
+
+var (
+	//taps has the watcher connections of the tapped users
+	taps = map[uint]map[string]socketio.Conn{}
+	//tapsLock is the lock for the taps
+	tapsLock sync.RWMutex
+)
+
+//Tap starts mirroring the notifications emitted to the user to the watcher connection
+func Tap(userID uint, watcher socketio.Conn) {
+	tapsLock.Lock()
+	defer tapsLock.Unlock()
+	w, ok := taps[userID]
+	if !ok {
+		w = map[string]socketio.Conn{}
+		taps[userID] = w
+	}
+	w[watcher.ID()] = watcher
+}
+
+//Untap stops mirroring the notifications of the user to the watcher connection. It reports whether the tap existed
+func Untap(userID uint, watcher socketio.Conn) bool {
+	tapsLock.Lock()
+	defer tapsLock.Unlock()
+	w, ok := taps[userID]
+	if !ok || w[watcher.ID()] == nil {
+		return false
+	}
+	delete(w, watcher.ID())
+	if len(w) == 0 {
+		delete(taps, userID)
+	}
+	return true
+}
+
+//untapAll stops all the taps of the watcher connection and returns the users who were tapped
+func untapAll(watcher socketio.Conn) []uint {
+	tapsLock.Lock()
+	defer tapsLock.Unlock()
+	users := []uint{}
+	for u, w := range taps {
+		if _, ok := w[watcher.ID()]; !ok {
+			continue
+		}
+		users = append(users, u)
+		delete(w, watcher.ID())
+		if len(w) == 0 {
+			delete(taps, u)
+		}
+	}
+	return users
+}
+
+//tap mirrors the notification emitted to the connection of the user to the watchers of the user
+func tap(userID uint, conn socketio.Conn, n Notification) {
+	/*
+	 * The mirrored notifications are not mirrored again
+	 * We will get the watchers of the user
+	 * Then we will send the redacted notification to them
+	 */
+	if n.Event == TapEvent {
+		return
+	}
+	tapsLock.RLock()
+	watchers := make([]socketio.Conn, 0, len(taps[userID]))
+	for _, w := range taps[userID] {
+		watchers = append(watchers, w)
+	}
+	tapsLock.RUnlock()
+	if len(watchers) == 0 {
+		return
+	}
+	t := Tapped{
+		UserID:  userID,
+		ConnID:  conn.ID(),
+		Event:   n.Event,
+		Payload: redact.Fields(n.Payload, config.ImpersonationRedactFields),
+		Meta:    n.Meta(),
+		At:      time.Now(),
+	}
+	m := Notification{Lane: DataLane}
+	m.Event = TapEvent
+	m.Payload = t
+	for _, w := range watchers {
+		if err := Send(w, m); err != nil {
+			log.Warn("error while mirroring the notification", n.Event, "of user", userID, "to the watcher", w.ID(), err.Error())
+		}
+	}
+}
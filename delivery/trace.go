@@ -0,0 +1,226 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the tracing of the notifications for the targeted debugging.
+ * An admin sets a trace flag for a user and/or an event. The flag expires automatically.
+ * The lifecycle stages of the matching notifications are logged at the debug level and collected in the trace report.
+ * The traces are kept in the memory of the instance.
+ */
+
+//Stage is a lifecycle stage of a notification
+type Stage string
+
+const (
+	//StageAccepted is when the notification is accepted for the delivery by the service
+	StageAccepted Stage = "accepted"
+	//StageRecorded is when the notification is recorded in the replay log with its sequence no.
+	StageRecorded Stage = "recorded"
+	//StageQueued is when the notification is queued in the lane of a connection
+	StageQueued Stage = "queued"
+	//StageDropped is when the notification is dropped from a connection
+	StageDropped Stage = "dropped"
+	//StageEmitted is when the notification is emitted to a connection
+	StageEmitted Stage = "emitted"
+	//StageAcked is when a device of the user acknowledges the notification
+	StageAcked Stage = "acked"
+)
+
+//TraceMaxEntries is the max no. of entries kept in a trace report. The oldest entries are dropped beyond it
+const TraceMaxEntries = 1000
+
+//TraceFlag is a flag to trace the notifications of a user and/or an event
+type TraceFlag struct {
+	//ID of the flag
+	ID string `json:"id"`
+	//UserID is the user whose notifications are traced. 0 matches every user
+	UserID uint `json:"userId"`
+	//Event is the event of the notifications traced. Empty matches every event
+	Event string `json:"event"`
+	//CreatedBy is the id of the admin who set the flag
+	CreatedBy uint `json:"createdBy"`
+	//ExpiresAt is the time after which the flag expires
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+//matches reports whether the flag matches the notification of the user at the given time
+func (t TraceFlag) matches(userID uint, event string, now time.Time) bool {
+	return now.Before(t.ExpiresAt) && (t.UserID == 0 || t.UserID == userID) && (len(t.Event) == 0 || t.Event == event)
+}
+
+//TraceEntry is an entry of the trace report
+type TraceEntry struct {
+	//Stage of the notification
+	Stage Stage `json:"stage"`
+	//UserID is the id of the user of the notification
+	UserID uint `json:"userId"`
+	//Event of the notification
+	Event string `json:"event"`
+	//Seq is the sequence no. of the notification
+	Seq uint64 `json:"seq"`
+	//Detail has the details of the stage. Eg. the connection id
+	Detail string `json:"detail,omitempty"`
+	//At is the time of the stage
+	At time.Time `json:"at"`
+}
+
+//TraceReport is the report of a trace flag
+type TraceReport struct {
+	//Flag is the trace flag
+	Flag TraceFlag `json:"flag"`
+	//Entries are the lifecycle entries of the matching notifications
+	Entries []TraceEntry `json:"entries"`
+}
+
+//trace is a trace flag with its entries kept in a ring buffer. It has its own lock so that the notifications
+//traced by the different flags don't contend
+type trace struct {
+	flag TraceFlag
+	//entries has at most TraceMaxEntries entries
+	entries []TraceEntry
+	//oldest is the index of the oldest entry once the ring buffer is full
+	oldest int
+	lock   sync.Mutex
+}
+
+//add adds the entry to the ring buffer overwriting the oldest one if it is full
+func (t *trace) add(e TraceEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.entries) < TraceMaxEntries {
+		t.entries = append(t.entries, e)
+		return
+	}
+	t.entries[t.oldest] = e
+	t.oldest = (t.oldest + 1) % TraceMaxEntries
+}
+
+//report returns the report of the trace with the entries from the oldest one
+func (t *trace) report() TraceReport {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	entries := make([]TraceEntry, 0, len(t.entries))
+	entries = append(entries, t.entries[t.oldest:]...)
+	entries = append(entries, t.entries[:t.oldest]...)
+	return TraceReport{Flag: t.flag, Entries: entries}
+}
+
+var (
+	//traces has the traces mapped by the flag id
+	traces = map[string]*trace{}
+	//tracesLock is the lock for the traces map. The entries are guarded by the lock of their trace
+	tracesLock sync.RWMutex
+	//traceFlags is the no. of the trace flags. The notifications are not traced without taking any lock when it is 0
+	traceFlags int32
+)
+
+//AddTrace sets the trace flag for the user and/or the event till the ttl and returns it
+func AddTrace(userID uint, event string, ttl time.Duration, createdBy uint) TraceFlag {
+	b := make([]byte, 8)
+	rand.Read(b)
+	if ttl <= 0 || ttl > config.TraceMaxTTL {
+		ttl = config.TraceMaxTTL
+	}
+	f := TraceFlag{ID: hex.EncodeToString(b), UserID: userID, Event: event, CreatedBy: createdBy, ExpiresAt: time.Now().Add(ttl)}
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	traces[f.ID] = &trace{flag: f}
+	atomic.StoreInt32(&traceFlags, int32(len(traces)))
+	return f
+}
+
+//RemoveTrace removes the trace flag and its report. It reports whether the flag existed
+func RemoveTrace(id string) bool {
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	_, ok := traces[id]
+	delete(traces, id)
+	atomic.StoreInt32(&traceFlags, int32(len(traces)))
+	return ok
+}
+
+//Traces returns the trace flags. The reports of the flags expired beyond the retention are purged
+func Traces() []TraceFlag {
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	now := time.Now()
+	result := []TraceFlag{}
+	for id, t := range traces {
+		if now.Sub(t.flag.ExpiresAt) > config.TraceRetention {
+			delete(traces, id)
+			continue
+		}
+		result = append(result, t.flag)
+	}
+	atomic.StoreInt32(&traceFlags, int32(len(traces)))
+	return result
+}
+
+//Report returns the trace report of the flag
+func Report(id string) (TraceReport, bool) {
+	tracesLock.RLock()
+	t, ok := traces[id]
+	tracesLock.RUnlock()
+	if !ok {
+		return TraceReport{}, false
+	}
+	return t.report(), true
+}
+
+//Trace records the stage of the notification of the user in the reports of the matching trace flags
+func Trace(stage Stage, userID uint, n Notification, detail ...interface{}) {
+	/*
+	 * If there are no trace flags we will return without taking any lock
+	 * We will find the matching flags
+	 * Then we will log the stage at the debug level and add it to their reports
+	 */
+	if atomic.LoadInt32(&traceFlags) == 0 {
+		return
+	}
+	now := time.Now()
+	matched := []*trace{}
+	tracesLock.RLock()
+	for _, t := range traces {
+		if t.flag.matches(userID, n.Event, now) {
+			matched = append(matched, t)
+		}
+	}
+	tracesLock.RUnlock()
+	if len(matched) == 0 {
+		return
+	}
+
+	//adding to the reports
+	e := TraceEntry{Stage: stage, UserID: userID, Event: n.Event, Seq: n.Seq, At: now}
+	if len(detail) != 0 {
+		e.Detail = fmt.Sprint(detail...)
+	}
+	log.Debug("TRACE:", stage, "notification", n.Event, "with seq", n.Seq, "of user", userID, e.Detail)
+	for _, t := range matched {
+		t.add(e)
+	}
+}
+
+//userOf returns the id of the user of the connection
+func userOf(conn socketio.Conn) uint {
+	if appCtx, ok := conn.Context().(*config.AppContext); ok && appCtx.Session.User != nil {
+		return appCtx.Session.User.ID
+	}
+	return 0
+}
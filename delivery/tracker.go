@@ -0,0 +1,57 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * This file contains the tracking of the copies of a notification queued to the lanes of the connections.
+ * The publisher holds the tracker while it queues the copies and releases it once done. The done func of the tracker
+ * is called once it is released and every copy queued is emitted or dropped. Eg. the journal marks the notification done.
+ */
+
+//Tracker tracks the copies of a notification queued to the connections
+type Tracker struct {
+	//pending is the no. of copies queued not yet emitted or dropped along with the hold of the publisher
+	pending int32
+	//done is called once nothing is pending
+	done func()
+	//once makes sure that done is called only once
+	once sync.Once
+}
+
+//NewTracker returns a tracker held by the publisher. The done func is called once it is released
+//and the copies queued are emitted or dropped
+func NewTracker(done func()) *Tracker {
+	return &Tracker{pending: 1, done: done}
+}
+
+//add adds a copy queued to a connection
+func (t *Tracker) add() {
+	if t != nil {
+		atomic.AddInt32(&t.pending, 1)
+	}
+}
+
+//Release releases the hold of the publisher or a copy once it is emitted or dropped
+func (t *Tracker) Release() {
+	if t != nil && atomic.AddInt32(&t.pending, -1) == 0 {
+		t.once.Do(t.done)
+	}
+}
+
+//Track returns the notification with its copies queued to the connections tracked by the tracker
+func (n Notification) Track(t *Tracker) Notification {
+	n.tracker = t
+	return n
+}
+
+//Tracker returns the tracker of the notification. It is nil if the notification isn't tracked
+func (n Notification) Tracker() *Tracker {
+	return n.tracker
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"sync"
+
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the lookup of the users known to the identity system, so that the sources not knowing the users
+ * can check them. Eg. the amqp consumer. The users are looked up with the auth provider. The auth service doesn't
+ * look up the users by their id, so with it the users table shared with the auth service is checked.
+ * The known users are cached as a user isn't expected to be deleted while the notifications are sent to them
+ */
+
+var (
+	//known has the users found to be known
+	known = map[uint]bool{}
+	//knownLock is the lock for the known users
+	knownLock sync.RWMutex
+)
+
+//lookupUser reports whether the identity system has the user
+func lookupUser(userID uint) (bool, error) {
+	/*
+	 * We will look up the user with the auth provider
+	 * If the provider can't look up the users, we will check the users table
+	 * If the database is not enabled, the user can't be checked and is taken as known
+	 */
+	_, err := config.Auth().GetUser(userID)
+	if err == nil {
+		return true, nil
+	}
+	if err == config.ErrUserNotFound {
+		return false, nil
+	}
+	if err != config.ErrNotSupported {
+		return false, err
+	}
+
+	//checking the users table
+	db := config.DB()
+	if db == nil {
+		return true, nil
+	}
+	err = db.Where("id = ?", userID).First(&authModels.User{}).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//Known reports whether the user is known to the identity system
+func Known(userID uint) (bool, error) {
+	knownLock.RLock()
+	ok := known[userID]
+	knownLock.RUnlock()
+	if ok {
+		return true, nil
+	}
+	ok, err := lookupUser(userID)
+	if !ok || err != nil {
+		return false, err
+	}
+	knownLock.Lock()
+	known[userID] = true
+	knownLock.Unlock()
+	return true, nil
+}
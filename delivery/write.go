@@ -0,0 +1,77 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the writes to the websocket connections.
+ * The transient write failures are told apart from the fatal ones.
+ * A write not completing within the write timeout is transient. It is the same write which is waited for again
+ * with a backoff, as emitting again would duplicate the notification. Once the retries are exhausted or on a
+ * fatal failure, the connection is marked bad and closed so that the client reconnects and replays.
+ */
+
+//ErrWriteTimeout is returned when a write to the connection doesn't complete within the write timeout and the retries
+var ErrWriteTimeout = errors.New("write to the connection timed out")
+
+//Transient reports whether the write error is transient. Timeouts and temporary network errors are transient
+func Transient(err error) bool {
+	if err == ErrWriteTimeout {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && (ne.Timeout() || ne.Temporary())
+}
+
+//write emits the event with the args to the connection of the lane within the write timeout.
+//The pending write is waited for again with a backoff till the write retries are exhausted
+func (l *lane) write(event string, args ...interface{}) error {
+	/*
+	 * We will emit in a separate go routine. A panic in the emit is a fatal error
+	 * Then we will wait for the emit within the write timeout
+	 * On timeout we will count the transient failure and wait again with the doubled timeout
+	 */
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("emit to the connection panicked: %v", r)
+			}
+		}()
+		l.conn.Emit(event, args...)
+		done <- nil
+	}()
+
+	wait := config.WriteTimeout
+	for attempt := 0; ; attempt++ {
+		t := time.NewTimer(wait)
+		select {
+		case err := <-done:
+			t.Stop()
+			if err == nil && attempt > 0 {
+				atomic.AddUint64(&writeRecovered, 1)
+			}
+			return err
+		case <-l.done:
+			t.Stop()
+			return ErrLaneClosed
+		case <-t.C:
+		}
+		CountWriteError(ErrWriteTimeout)
+		if attempt >= config.WriteRetries {
+			return ErrWriteTimeout
+		}
+		wait *= 2
+	}
+}
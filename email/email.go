@@ -0,0 +1,288 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package email emails the notifications whose realtime delivery failed to the users.
+//The emails are sent through the smtp server of the config. Ses is used through its smtp endpoint.
+//The address of a user is resolved from the users of the auth provider. If the provider can't look up the users,
+//the address remembered from the session of the user when they connect is used.
+//The emails are queued in the store and sent by a pool of workers, so they survive a restart of the instance.
+//A failed email is retried with a backoff
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/store"
+	"github.com/cuttle-ai/websockets/templates"
+)
+
+/*
+ * This file contains the addresses of the users and the emailing of the notifications.
+ * The body of an email is rendered by the email-<event> template, or the email template of the config.
+ * A plain body with the event and the payload is sent if neither exists.
+ */
+
+const (
+	//keyPrefix is the prefix of the store keys of the addresses of the users
+	keyPrefix = "email/"
+	//queue is the store queue of the notifications to be emailed
+	queue = "email-queue"
+	//maxRetryBackoff is the max time after which a failed email is retried
+	maxRetryBackoff = time.Hour
+)
+
+var (
+	//addresses has the addresses of the users remembered by this instance
+	addresses = map[uint]string{}
+	//lock is the lock for the addresses
+	lock sync.Mutex
+)
+
+//Enabled returns whether the emails can be sent
+func Enabled() bool {
+	return len(config.SMTPAddr) != 0
+}
+
+//Remember remembers the email address of the user
+func Remember(userID uint, address string) {
+	if len(address) == 0 {
+		return
+	}
+	lock.Lock()
+	same := addresses[userID] == address
+	addresses[userID] = address
+	lock.Unlock()
+	if same {
+		return
+	}
+	if err := store.Default.Set(keyPrefix+strconv.FormatUint(uint64(userID), 10), []byte(address), 0); err != nil {
+		log.Error("error while saving the email address of user", userID, err.Error())
+	}
+}
+
+//Address returns the email address of the user from the auth provider. If the provider can't look up the users,
+//the address remembered from the session of the user is returned. It is empty if the provider doesn't have the user or the user never connected
+func Address(userID uint) (string, error) {
+	u, err := config.Auth().GetUser(userID)
+	if err == nil && len(u.Email) != 0 {
+		return u.Email, nil
+	}
+	if err == config.ErrUserNotFound {
+		return "", nil
+	}
+	if err != nil && err != config.ErrNotSupported {
+		return "", err
+	}
+	lock.Lock()
+	a, ok := addresses[userID]
+	lock.Unlock()
+	if ok {
+		return a, nil
+	}
+	b, err := store.Default.Get(keyPrefix + strconv.FormatUint(uint64(userID), 10))
+	if err == store.ErrNotFound {
+		return "", nil
+	}
+	return string(b), err
+}
+
+//Send sends the plain text email to the address
+func Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if len(config.SMTPUsername) != 0 {
+		host, _, err := net.SplitHostPort(config.SMTPAddr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+	}
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", config.EmailFrom)
+	fmt.Fprintf(msg, "To: %s\r\n", to)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(config.SMTPAddr, auth, config.EmailFrom, []string{to}, msg.Bytes())
+}
+
+//subject returns the title of the payload or the event as the subject of the email of the notification
+func subject(n delivery.Notification) string {
+	if m, ok := n.Payload.(map[string]interface{}); ok {
+		if t, ok := m["title"].(string); ok && len(t) != 0 {
+			return t
+		}
+	}
+	return n.Event
+}
+
+//body renders the body of the email of the notification
+func body(userID uint, n delivery.Notification) (string, error) {
+	/*
+	 * We will render the template of the event
+	 * Then we will render the template of the config
+	 * If neither exists, we will write the event and the payload
+	 */
+	data := map[string]interface{}{"event": n.Event, "payload": n.Payload, "id": n.ID, "userId": userID}
+	for _, name := range []string{"email-" + n.Event, config.EmailTemplate} {
+		text, err := templates.Text(name, data)
+		if err == templates.ErrNotFound {
+			continue
+		}
+		return text, err
+	}
+
+	//plain body
+	b, err := json.MarshalIndent(n.Payload, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return "You have a new notification " + n.Event + "\n\n" + string(b) + "\n", nil
+}
+
+//job is a notification queued to be emailed to the user
+type job struct {
+	//UserID is the id of the user
+	UserID uint `json:"userId"`
+	//Notification to be emailed
+	Notification delivery.Notification `json:"notification"`
+	//Attempts is the no. of times the email failed
+	Attempts int `json:"attempts,omitempty"`
+	//NotBefore is the time before which the failed email isn't retried
+	NotBefore time.Time `json:"notBefore,omitempty"`
+}
+
+var (
+	//send sends the email. It is replaced in the tests
+	send = Send
+	//pollInterval is the time for which a worker waits when the queue has no email due
+	pollInterval = time.Second
+)
+
+//push queues the job in the store
+func push(j job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return store.Default.Push(queue, b, 0)
+}
+
+//Notify queues the notification to be emailed to the user. It is skipped if the emails are not enabled
+func Notify(userID uint, n delivery.Notification) {
+	if !Enabled() {
+		return
+	}
+	if err := push(job{UserID: userID, Notification: n}); err != nil {
+		log.Error("error while queuing the email of the notification", n.Event, "of user", userID, err.Error())
+	}
+}
+
+//backoff returns the time after which the email failed the given no. of times is retried
+func backoff(attempts int) time.Duration {
+	wait := config.EmailRetryBackoff
+	for i := 1; i < attempts && wait < maxRetryBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxRetryBackoff {
+		wait = maxRetryBackoff
+	}
+	return wait
+}
+
+//work emails the queued notifications. The emails not yet due are queued back
+func work() {
+	for {
+		vs, err := store.Default.Pop(queue, 1)
+		if err != nil {
+			log.Error("error while getting the queued emails", err.Error())
+			time.Sleep(pollInterval)
+			continue
+		}
+		if len(vs) == 0 {
+			time.Sleep(pollInterval)
+			continue
+		}
+		j := job{}
+		if err := json.Unmarshal(vs[0], &j); err != nil {
+			log.Error("dropping the invalid queued email", err.Error())
+			continue
+		}
+		if wait := time.Until(j.NotBefore); wait > 0 {
+			if err := push(j); err != nil {
+				log.Error("error while queuing back the email of the notification", j.Notification.Event, "of user", j.UserID, err.Error())
+			}
+			if wait > pollInterval {
+				wait = pollInterval
+			}
+			time.Sleep(wait)
+			continue
+		}
+		deliver(j)
+	}
+}
+
+//deliver emails the notification of the job. If it fails, it is queued again with a backoff till the max attempts
+func deliver(j job) {
+	retry, err := email(j.UserID, j.Notification)
+	if err == nil {
+		return
+	}
+	j.Attempts++
+	if !retry || j.Attempts >= config.EmailMaxAttempts {
+		log.Error("dropping the email of the notification", j.Notification.Event, "of user", j.UserID, "after", j.Attempts, "attempts", err.Error())
+		return
+	}
+	j.NotBefore = time.Now().Add(backoff(j.Attempts))
+	log.Warn("retrying the email of the notification", j.Notification.Event, "of user", j.UserID, "at", j.NotBefore, err.Error())
+	if err := push(j); err != nil {
+		log.Error("error while queuing the retry of the email of the notification", j.Notification.Event, "of user", j.UserID, err.Error())
+	}
+}
+
+//email emails the notification to the user. It is skipped if the address of the user is not known.
+//It reports whether the failure is worth retrying
+func email(userID uint, n delivery.Notification) (bool, error) {
+	/*
+	 * We will get the address of the user
+	 * Then we will render the body and email the notification
+	 */
+	to, err := Address(userID)
+	if err != nil {
+		return true, err
+	}
+	if len(to) == 0 {
+		log.Info("skipping the email of the notification", n.Event, "as the email address of user", userID, "is not known")
+		return false, nil
+	}
+	b, err := body(userID, n)
+	if err != nil {
+		return false, err
+	}
+	if err := send(to, subject(n), b); err != nil {
+		return true, err
+	}
+	log.Info("emailed the notification", n.Event, "to user", userID)
+	return false, nil
+}
+
+func init() {
+	if Enabled() {
+		config.SuperviseWorkers("email", config.EmailWorkers, work)
+	}
+}
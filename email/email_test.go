@@ -0,0 +1,134 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/templates"
+)
+
+//usersProvider is a test auth provider knowing the address of the user 1
+type usersProvider struct{}
+
+func (usersProvider) Init() error {
+	return nil
+}
+
+func (usersProvider) ValidateSession(token string) (authModels.User, bool) {
+	return authModels.User{}, false
+}
+
+func (usersProvider) GetUser(id uint) (authModels.User, error) {
+	if id == 1 {
+		return authModels.User{ID: 1, Email: "one@cuttle.ai"}, nil
+	}
+	return authModels.User{}, config.ErrNotSupported
+}
+
+func (usersProvider) WatchRevocations(ctx context.Context) (<-chan config.Revocation, error) {
+	return nil, config.ErrNotSupported
+}
+
+func init() {
+	config.RegisterAuthProvider("email-test", usersProvider{})
+	config.AuthProviderName = "email-test"
+}
+
+//notification returns the test notification of the event
+func notification(event string) delivery.Notification {
+	n := delivery.Notification{}
+	n.Event = event
+	n.Payload = map[string]interface{}{"name": "sales"}
+	return n
+}
+
+func TestAddress(t *testing.T) {
+	//the address is resolved from the users of the provider
+	Remember(1, "old@cuttle.ai")
+	if a, err := Address(1); err != nil || a != "one@cuttle.ai" {
+		t.Errorf("expected the address of the provider. got %q %v", a, err)
+	}
+
+	//else the remembered address is used
+	Remember(2, "two@cuttle.ai")
+	if a, err := Address(2); err != nil || a != "two@cuttle.ai" {
+		t.Errorf("expected the remembered address. got %q %v", a, err)
+	}
+	if a, err := Address(3); err != nil || len(a) != 0 {
+		t.Errorf("expected no address for the unknown user. got %q %v", a, err)
+	}
+}
+
+func TestBody(t *testing.T) {
+	if _, err := templates.Set(templates.Template{Name: "email-dataset-ready", Body: `{{.payload.name}} is ready`}); err != nil {
+		t.Fatal(err)
+	}
+	n := notification("dataset-ready")
+	if b, err := body(1, n); err != nil || b != "sales is ready" {
+		t.Errorf("expected the body of the event template. got %q %v", b, err)
+	}
+	if s := subject(n); s != "dataset-ready" {
+		t.Errorf("expected the event as the subject. got %q", s)
+	}
+	n.Payload = map[string]interface{}{"title": "Dataset ready"}
+	if s := subject(n); s != "Dataset ready" {
+		t.Errorf("expected the title as the subject. got %q", s)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	config.EmailRetryBackoff = 10 * time.Millisecond
+	config.EmailMaxAttempts = 3
+	pollInterval = 5 * time.Millisecond
+	sent := map[string]int{}
+	failures := map[string]int{"flaky": 1, "down": 10}
+	lock := sync.Mutex{}
+	send = func(to, subject, body string) error {
+		lock.Lock()
+		defer lock.Unlock()
+		if failures[subject] > 0 {
+			failures[subject]--
+			return errors.New("smtp is down")
+		}
+		sent[subject]++
+		return nil
+	}
+	go work()
+
+	//the failed email is retried after the backoff and the one failing every time is dropped after the max attempts
+	for _, event := range []string{"flaky", "down"} {
+		if err := push(job{UserID: 1, Notification: notification(event)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		lock.Lock()
+		done := sent["flaky"] == 1 && failures["down"] == 7
+		lock.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	//no more attempts are made after the max
+	time.Sleep(50 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if sent["flaky"] != 1 {
+		t.Errorf("expected the flaky email to be sent once after its retry. got %d", sent["flaky"])
+	}
+	if failures["down"] != 7 || sent["down"] != 0 {
+		t.Errorf("expected the failing email to be tried 3 times. got %d", 10-failures["down"])
+	}
+}
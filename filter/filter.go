@@ -0,0 +1,393 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package filter has the safe dynamic filtering of the list endpoints.
+//Each list endpoint declares a schema with the whitelisted fields, their types and the allowed operators.
+//The user supplied filters are parsed against the schema into typed conditions. Only the column names from the
+//schema and the operators from a fixed set reach the sql. The values are always bound as the query args.
+//
+//The filters are passed as the query params field[op]=value. Eg. event[eq]=alert&seq[gte]=10&createdAt[lt]=2019-10-02T00:00:00Z.
+//field=value is same as field[eq]=value. The in operator takes comma separated values.
+//The sort param takes comma separated fields with - prefix for the descending order. Eg. sort=-createdAt,seq.
+//The limit and offset params paginate the result.
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//Type is the type of a field
+type Type int
+
+const (
+	//String field
+	String Type = iota
+	//Int field
+	Int
+	//Uint field
+	Uint
+	//Time field. The values are in RFC3339 format
+	Time
+	//Bool field
+	Bool
+)
+
+//Op is a filter operator
+type Op string
+
+const (
+	//Eq is equal to
+	Eq Op = "eq"
+	//Ne is not equal to
+	Ne Op = "ne"
+	//Gt is greater than
+	Gt Op = "gt"
+	//Gte is greater than or equal to
+	Gte Op = "gte"
+	//Lt is less than
+	Lt Op = "lt"
+	//Lte is less than or equal to
+	Lte Op = "lte"
+	//In is any of the comma separated values
+	In Op = "in"
+	//Contains is the substring match of the string fields
+	Contains Op = "contains"
+	//Prefix is the prefix match of the string fields
+	Prefix Op = "prefix"
+)
+
+//sqlOps has the sql of the operators
+var sqlOps = map[Op]string{
+	Eq:       "= ?",
+	Ne:       "<> ?",
+	Gt:       "> ?",
+	Gte:      ">= ?",
+	Lt:       "< ?",
+	Lte:      "<= ?",
+	In:       "IN (?)",
+	Contains: `LIKE ? ESCAPE '\'`,
+	Prefix:   `LIKE ? ESCAPE '\'`,
+}
+
+//Comparison are the operators allowed for the ordered fields
+var Comparison = []Op{Eq, Ne, Gt, Gte, Lt, Lte, In}
+
+//Text are the operators allowed for the string fields
+var Text = []Op{Eq, Ne, In, Contains, Prefix}
+
+//Field is a whitelisted field of a schema
+type Field struct {
+	//Column is the database column of the field
+	Column string
+	//Type is the type of the field
+	Type Type
+	//Ops are the operators allowed on the field
+	Ops []Op
+	//Sortable fields can be used in the sort param
+	Sortable bool
+}
+
+//allows reports whether the operator is allowed on the field
+func (f Field) allows(op Op) bool {
+	for _, o := range f.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+//Schema has the whitelisted fields of a list endpoint mapped by the name used in the query params
+type Schema struct {
+	//Fields are the whitelisted fields
+	Fields map[string]Field
+	//MaxLimit is the max no. of records returned at once. It is also the default limit
+	MaxLimit int
+}
+
+//Condition is a typed filter condition
+type Condition struct {
+	//Field is the name of the field
+	Field string
+	//Op is the operator
+	Op Op
+	//Values are the typed values. Only the in operator has more than one value
+	Values []interface{}
+}
+
+//Order is a sort order
+type Order struct {
+	//Field is the name of the field
+	Field string
+	//Desc is true for the descending order
+	Desc bool
+}
+
+//Query is the parsed filters, sort order and pagination of a list request
+type Query struct {
+	schema Schema
+	//Conditions are the filter conditions. All of them must match
+	Conditions []Condition
+	//Sort is the sort order
+	Sort []Order
+	//Limit is the max no. of records
+	Limit int
+	//Offset is the no. of records to skip
+	Offset int
+}
+
+//reserved are the query params which are not filters
+var reserved = map[string]bool{"sort": true, "limit": true, "offset": true}
+
+//Parse parses the query params into a query as per the schema. The params not in the schema are ignored
+//unless they use the field[op] syntax. An error is returned for an unknown field, disallowed operator or invalid value
+func Parse(s Schema, params url.Values, ignore ...string) (Query, error) {
+	/*
+	 * We will parse the filter conditions
+	 * Then we will parse the sort order
+	 * Then we will parse the limit and offset
+	 */
+	q := Query{schema: s, Limit: s.MaxLimit}
+	skip := map[string]bool{}
+	for _, i := range ignore {
+		skip[i] = true
+	}
+
+	//parsing the conditions
+	for k, vs := range params {
+		if reserved[k] || skip[k] {
+			continue
+		}
+		name, op := k, Eq
+		if i := strings.Index(k, "["); i > 0 && strings.HasSuffix(k, "]") {
+			name, op = k[:i], Op(k[i+1:len(k)-1])
+		}
+		f, ok := s.Fields[name]
+		if !ok {
+			if name == k {
+				continue
+			}
+			return q, errors.New("unknown filter field " + name)
+		}
+		if !f.allows(op) {
+			return q, fmt.Errorf("operator %s is not allowed on the field %s", op, name)
+		}
+		for _, v := range vs {
+			c, err := condition(name, f, op, v)
+			if err != nil {
+				return q, err
+			}
+			q.Conditions = append(q.Conditions, c)
+		}
+	}
+
+	//parsing the sort order
+	if sort := params.Get("sort"); len(sort) != 0 {
+		for _, o := range strings.Split(sort, ",") {
+			ord := Order{Field: strings.TrimPrefix(o, "-"), Desc: strings.HasPrefix(o, "-")}
+			if f, ok := s.Fields[ord.Field]; !ok || !f.Sortable {
+				return q, errors.New("can't sort by the field " + ord.Field)
+			}
+			q.Sort = append(q.Sort, ord)
+		}
+	}
+
+	//parsing the limit and offset
+	if l := params.Get("limit"); len(l) != 0 {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			return q, errors.New("invalid limit " + l)
+		}
+		if s.MaxLimit == 0 || n < s.MaxLimit {
+			q.Limit = n
+		}
+	}
+	if o := params.Get("offset"); len(o) != 0 {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 {
+			return q, errors.New("invalid offset " + o)
+		}
+		q.Offset = n
+	}
+	return q, nil
+}
+
+//condition returns the typed condition of the field
+func condition(name string, f Field, op Op, value string) (Condition, error) {
+	c := Condition{Field: name, Op: op}
+	raw := []string{value}
+	if op == In {
+		raw = strings.Split(value, ",")
+	}
+	for _, r := range raw {
+		v, err := parse(f.Type, r)
+		if err != nil {
+			return c, fmt.Errorf("invalid value %q for the field %s: %s", r, name, err.Error())
+		}
+		c.Values = append(c.Values, v)
+	}
+	return c, nil
+}
+
+//parse parses the value as per the type
+func parse(t Type, v string) (interface{}, error) {
+	switch t {
+	case Int:
+		return strconv.ParseInt(v, 10, 64)
+	case Uint:
+		return strconv.ParseUint(v, 10, 64)
+	case Time:
+		return time.Parse(time.RFC3339, v)
+	case Bool:
+		return strconv.ParseBool(v)
+	}
+	return v, nil
+}
+
+//likeEscaper escapes the wildcards of the like pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+//Where adds the conditions of the query to the db query
+func (q Query) Where(db *gorm.DB) *gorm.DB {
+	for _, c := range q.Conditions {
+		col := q.schema.Fields[c.Field].Column
+		var arg interface{} = c.Values[0]
+		switch c.Op {
+		case In:
+			arg = c.Values
+		case Contains:
+			arg = "%" + likeEscaper.Replace(c.Values[0].(string)) + "%"
+		case Prefix:
+			arg = likeEscaper.Replace(c.Values[0].(string)) + "%"
+		}
+		db = db.Where(col+" "+sqlOps[c.Op], arg)
+	}
+	return db
+}
+
+//Apply adds the conditions, sort order and pagination of the query to the db query
+func (q Query) Apply(db *gorm.DB) *gorm.DB {
+	db = q.Where(db)
+	for _, o := range q.Sort {
+		col := q.schema.Fields[o.Field].Column
+		if o.Desc {
+			col += " DESC"
+		}
+		db = db.Order(col)
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+	if q.Offset > 0 {
+		db = db.Offset(q.Offset)
+	}
+	return db
+}
+
+//Match reports whether the record matches the conditions of the query. It is used for the in memory records.
+//value returns the value of the field of the record as string, int64, uint64, time.Time or bool
+func (q Query) Match(value func(field string) interface{}) bool {
+	for _, c := range q.Conditions {
+		if !match(c, value(c.Field)) {
+			return false
+		}
+	}
+	return true
+}
+
+//Less reports whether the record a sorts before the record b as per the sort order of the query.
+//It is used to sort the in memory records. value returns the value of the field of a record as in Match
+func (q Query) Less(a, b func(field string) interface{}) bool {
+	for _, o := range q.Sort {
+		r := compare(a(o.Field), b(o.Field))
+		if r == 0 || r == incomparable {
+			continue
+		}
+		return (r == -1) != o.Desc
+	}
+	return false
+}
+
+//match reports whether the value matches the condition
+func match(c Condition, v interface{}) bool {
+	switch c.Op {
+	case In:
+		for _, cv := range c.Values {
+			if compare(v, cv) == 0 {
+				return true
+			}
+		}
+		return false
+	case Contains:
+		s, ok := v.(string)
+		return ok && strings.Contains(s, c.Values[0].(string))
+	case Prefix:
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, c.Values[0].(string))
+	}
+	r := compare(v, c.Values[0])
+	switch c.Op {
+	case Eq:
+		return r == 0
+	case Ne:
+		return r != 0 && r != incomparable
+	case Gt:
+		return r == 1
+	case Gte:
+		return r == 1 || r == 0
+	case Lt:
+		return r == -1
+	case Lte:
+		return r == -1 || r == 0
+	}
+	return false
+}
+
+//incomparable is returned by compare when the values are of different types
+const incomparable = 2
+
+//compare compares the values of the same type. It returns -1, 0 or 1 and incomparable for different types
+func compare(a, b interface{}) int {
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y)
+		}
+	case int64:
+		if y, ok := b.(int64); ok {
+			return order(x < y, x > y)
+		}
+	case uint64:
+		if y, ok := b.(uint64); ok {
+			return order(x < y, x > y)
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return order(x.Before(y), x.After(y))
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return order(!x && y, x && !y)
+		}
+	}
+	return incomparable
+}
+
+//order returns the comparison result from less and greater
+func order(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
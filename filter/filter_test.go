@@ -0,0 +1,77 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package filter_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/filter"
+)
+
+var schema = filter.Schema{
+	Fields: map[string]filter.Field{
+		"event": {Column: "event", Type: filter.String, Ops: filter.Text},
+		"seq":   {Column: "seq", Type: filter.Uint, Ops: filter.Comparison, Sortable: true},
+	},
+	MaxLimit: 100,
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, raw := range []string{
+		"user_id[eq]=1",
+		"event[gt]=a",
+		"seq[eq]=1%3BDROP%20TABLE%20users",
+		"sort=event",
+		"sort=seq%3BDROP%20TABLE%20users",
+		"limit=-1",
+	} {
+		params, _ := url.ParseQuery(raw)
+		if _, err := filter.Parse(schema, params); err == nil {
+			t.Errorf("expected %s to be rejected", raw)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	params, _ := url.ParseQuery("event[prefix]=dash&seq[in]=1,3&limit=1000")
+	q, err := filter.Parse(schema, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != 100 {
+		t.Errorf("expected the limit to be capped at 100. got %d", q.Limit)
+	}
+	records := []map[string]interface{}{
+		{"event": "dashboard", "seq": uint64(3)},
+		{"event": "dashboard", "seq": uint64(2)},
+		{"event": "alert", "seq": uint64(1)},
+	}
+	matched := 0
+	for _, r := range records {
+		if q.Match(func(f string) interface{} { return r[f] }) {
+			matched++
+		}
+	}
+	if matched != 1 {
+		t.Errorf("expected 1 record to match. got %d", matched)
+	}
+}
+
+func TestLess(t *testing.T) {
+	params, _ := url.ParseQuery("sort=-seq")
+	q, err := filter.Parse(schema, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := func(f string) interface{} { return map[string]interface{}{"seq": uint64(1)}[f] }
+	b := func(f string) interface{} { return map[string]interface{}{"seq": uint64(2)}[f] }
+	if q.Less(a, b) || !q.Less(b, a) {
+		t.Error("expected the records to be sorted by the descending seq")
+	}
+	if (filter.Query{}).Less(a, b) {
+		t.Error("expected the records to keep their order without a sort")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package journal has the local write ahead journal of the accepted notifications.
+//A notification is appended to the journal when it is accepted and marked done once it is delivered or persisted.
+//The notifications not marked done when the instance crashed are returned when the journal is opened again,
+//so that they can be replayed. The journal is compacted to the pending notifications as it grows.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	//opAccept is the op of the record appended when a notification is accepted
+	opAccept = "accept"
+	//opDone is the op of the record appended when a notification is delivered or persisted
+	opDone = "done"
+	//maxRecordSize is the max size of a record read from the journal
+	maxRecordSize = 16 << 20
+)
+
+//ErrClosed is returned when the journal is already closed
+var ErrClosed = errors.New("journal is closed")
+
+//record is a line of the journal
+type record struct {
+	//ID of the notification in the journal
+	ID uint64 `json:"id"`
+	//Op is accept or done
+	Op string `json:"op"`
+	//Data of the accepted notification
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+//Record is a notification accepted but not yet marked done
+type Record struct {
+	//ID of the notification in the journal
+	ID uint64
+	//Data of the notification
+	Data []byte
+}
+
+//Journal is an append only log of the accepted notifications
+type Journal struct {
+	//path of the journal file
+	path string
+	//sync syncs every accepted record to the disk before returning
+	sync bool
+	//maxSize is the size of the journal file beyond which it is compacted. 0 never compacts
+	maxSize int64
+	//f is the journal file opened for appending
+	f *os.File
+	//size of the journal file
+	size int64
+	//next is the id of the next accepted notification
+	next uint64
+	//pending has the data of the notifications not yet marked done mapped by their ids
+	pending map[uint64][]byte
+	//lock for the journal
+	lock sync.Mutex
+}
+
+//Open opens the journal at the path, creating it if it doesn't exist. The notifications accepted but not
+//marked done in the journal are returned in the order of their acceptance. Partially written records
+//left by a crash are skipped. The journal is compacted to the returned notifications before it is opened
+func Open(path string, sync bool, maxSize int64) (*Journal, []Record, error) {
+	/*
+	 * We will read the existing records of the journal
+	 * Then we will compact the journal to the pending notifications
+	 * Then we will return the pending notifications in the order of their ids
+	 */
+	//reading the existing records
+	j := &Journal{path: path, sync: sync, maxSize: maxSize, next: 1, pending: map[uint64][]byte{}}
+	if err := j.load(); err != nil {
+		return nil, nil, err
+	}
+
+	//compacting the journal
+	if err := j.compact(); err != nil {
+		return nil, nil, err
+	}
+
+	//pending notifications
+	rs := make([]Record, 0, len(j.pending))
+	for id, d := range j.pending {
+		rs = append(rs, Record{ID: id, Data: d})
+	}
+	sort.Slice(rs, func(i, k int) bool { return rs[i].ID < rs[k].ID })
+	return j, rs, nil
+}
+
+//load reads the records in the journal file into the pending notifications
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for s.Scan() {
+		r := record{}
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil || r.ID == 0 {
+			continue
+		}
+		if r.ID >= j.next {
+			j.next = r.ID + 1
+		}
+		switch r.Op {
+		case opAccept:
+			j.pending[r.ID] = r.Data
+		case opDone:
+			delete(j.pending, r.ID)
+		}
+	}
+	return s.Err()
+}
+
+//compact rewrites the journal file with only the pending notifications and opens it for appending.
+//The new file is written aside and renamed over the journal, so a crash while compacting keeps the old journal
+func (j *Journal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	size := int64(0)
+	for id, d := range j.pending {
+		b, _ := json.Marshal(record{ID: id, Op: opAccept, Data: d})
+		n, _ := w.Write(append(b, '\n'))
+		size += int64(n)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	j.size = size
+	return nil
+}
+
+//write appends the record to the journal file
+func (j *Journal) write(r record) error {
+	if j.f == nil {
+		return ErrClosed
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	n, err := j.f.Write(append(b, '\n'))
+	j.size += int64(n)
+	return err
+}
+
+//Append appends the accepted notification to the journal and returns its id.
+//The record is synced to the disk before returning if the journal syncs
+func (j *Journal) Append(data []byte) (uint64, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	id := j.next
+	if err := j.write(record{ID: id, Op: opAccept, Data: data}); err != nil {
+		return 0, err
+	}
+	if j.sync {
+		if err := j.f.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	j.next++
+	j.pending[id] = data
+	return id, nil
+}
+
+//Done marks the notification done in the journal. The journal is compacted if it has grown beyond the max size.
+//The done records aren't synced as losing them only replays an already delivered notification
+func (j *Journal) Done(id uint64) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if _, ok := j.pending[id]; !ok {
+		return nil
+	}
+	if err := j.write(record{ID: id, Op: opDone}); err != nil {
+		return err
+	}
+	delete(j.pending, id)
+	if j.maxSize > 0 && j.size > j.maxSize {
+		return j.compact()
+	}
+	return nil
+}
+
+//Pending returns the no. of notifications not yet marked done
+func (j *Journal) Pending() int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return len(j.pending)
+}
+
+//Close closes the journal. The pending notifications are kept in the journal to be replayed when it is opened again
+func (j *Journal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
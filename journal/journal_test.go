@@ -0,0 +1,78 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package journal_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/journal"
+)
+
+func TestReplayPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.log")
+
+	j, rs, err := journal.Open(path, true, 0)
+	if err != nil || len(rs) != 0 {
+		t.Fatalf("expected an empty journal. got %v %v", rs, err)
+	}
+	ids := []uint64{}
+	for _, v := range []string{`"a"`, `"b"`, `"c"`} {
+		id, err := j.Append([]byte(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	j.Done(ids[1])
+	j.Close()
+
+	//a partial record left by a crash is skipped
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"id":9,"op":"acc`)
+	f.Close()
+
+	j, rs, err = journal.Open(path, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || string(rs[0].Data) != `"a"` || string(rs[1].Data) != `"c"` {
+		t.Fatalf("expected a and c to be pending. got %q", rs)
+	}
+	if id, _ := j.Append([]byte(`"d"`)); id <= ids[2] {
+		t.Fatalf("expected the ids to continue after %d. got %d", ids[2], id)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.log")
+
+	j, _, err := journal.Open(path, false, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		id, _ := j.Append([]byte(`"x"`))
+		j.Done(id)
+	}
+	if st, err := os.Stat(path); err != nil || st.Size() > 256 {
+		t.Fatalf("expected the journal to be compacted below 256 bytes. got %v %v", st, err)
+	}
+	if j.Pending() != 0 {
+		t.Fatalf("expected no pending notifications. got %d", j.Pending())
+	}
+}
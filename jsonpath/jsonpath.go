@@ -0,0 +1,143 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package jsonpath selects the values from the decoded json documents with the jsonpath expressions.
+//The subset of jsonpath supported is the root $, the child .name or ['name'], the index [n] and the wildcard .* or [*].
+//Eg. $.job.owner.id or $.recipients[*].id
+package jsonpath
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/*
+ * This file contains the parsing and the evaluation of the jsonpath expressions
+ */
+
+//ErrInvalid is returned when the expression is not a valid jsonpath
+var ErrInvalid = errors.New("invalid jsonpath")
+
+//step is a step of the path selecting the children of a value
+type step struct {
+	//key of the object member selected
+	key string
+	//index of the array element selected. -1 if the step selects a key or is a wildcard
+	index int
+	//wildcard selects all the members of an object or the elements of an array
+	wildcard bool
+}
+
+//Path is a compiled jsonpath expression
+type Path struct {
+	//expr is the expression of the path
+	expr  string
+	steps []step
+}
+
+//String returns the expression of the path
+func (p Path) String() string {
+	return p.expr
+}
+
+//Compile parses the jsonpath expression
+func Compile(expr string) (Path, error) {
+	/*
+	 * We will check the root
+	 * Then we will parse the dot and the bracket steps till the end
+	 */
+	p := Path{expr: expr}
+	if !strings.HasPrefix(expr, "$") {
+		return p, ErrInvalid
+	}
+	rest := expr[1:]
+	for len(rest) != 0 {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			if len(name) == 0 {
+				return p, ErrInvalid
+			}
+			if name == "*" {
+				p.steps = append(p.steps, step{index: -1, wildcard: true})
+				continue
+			}
+			p.steps = append(p.steps, step{key: name, index: -1})
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return p, ErrInvalid
+			}
+			s, err := bracket(rest[1:end])
+			if err != nil {
+				return p, err
+			}
+			p.steps = append(p.steps, s)
+			rest = rest[end+1:]
+		default:
+			return p, ErrInvalid
+		}
+	}
+	return p, nil
+}
+
+//bracket parses the step within the brackets
+func bracket(b string) (step, error) {
+	b = strings.TrimSpace(b)
+	if b == "*" {
+		return step{index: -1, wildcard: true}, nil
+	}
+	if len(b) >= 2 && (b[0] == '\'' || b[0] == '"') && b[len(b)-1] == b[0] {
+		return step{key: b[1 : len(b)-1], index: -1}, nil
+	}
+	i, err := strconv.Atoi(b)
+	if err != nil || i < 0 {
+		return step{}, ErrInvalid
+	}
+	return step{index: i}, nil
+}
+
+//Get returns the values selected by the path from the decoded json document. It is empty if nothing matches
+func (p Path) Get(doc interface{}) []interface{} {
+	values := []interface{}{doc}
+	for _, s := range p.steps {
+		next := []interface{}{}
+		for _, v := range values {
+			switch t := v.(type) {
+			case map[string]interface{}:
+				if s.wildcard {
+					for _, c := range t {
+						next = append(next, c)
+					}
+				} else if c, ok := t[s.key]; ok && s.index < 0 {
+					next = append(next, c)
+				}
+			case []interface{}:
+				if s.wildcard {
+					next = append(next, t...)
+				} else if s.index >= 0 && s.index < len(t) {
+					next = append(next, t[s.index])
+				}
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+//Get compiles the expression and returns the values selected by it from the decoded json document
+func Get(expr string, doc interface{}) ([]interface{}, error) {
+	p, err := Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return p.Get(doc), nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/jsonpath"
+)
+
+func TestGet(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{"job": {"owner": {"id": 7}, "name": "sales"}, "recipients": [{"id": 1}, {"id": 2}], "a.b": "dotted"}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string][]interface{}{
+		"$.job.owner.id":     {float64(7)},
+		"$['job']['name']":   {"sales"},
+		"$.recipients[*].id": {float64(1), float64(2)},
+		"$.recipients[1].id": {float64(2)},
+		"$['a.b']":           {"dotted"},
+		"$.job.missing":      {},
+		"$.recipients[5]":    {},
+	}
+	for expr, want := range cases {
+		got, err := jsonpath.Get(expr, doc)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v. got %v", expr, want, got)
+		}
+	}
+	for _, expr := range []string{"job.id", "$.", "$[", "$[-1]", "$[x]"} {
+		if _, err := jsonpath.Compile(expr); err != jsonpath.ErrInvalid {
+			t.Errorf("%s: expected %v. got %v", expr, jsonpath.ErrInvalid, err)
+		}
+	}
+}
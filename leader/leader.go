@@ -0,0 +1,190 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package leader has the leader election for the singleton background workers like schedulers,
+//purge workers and outbox relays which must run on exactly one instance.
+//A worker runs only while the instance holds the leadership for the worker. When the leadership is lost,
+//the worker is stopped and the instance campaigns again so that another instance can take over.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/hashicorp/consul/api"
+	"github.com/jinzhu/gorm"
+)
+
+//Worker is a singleton background worker. It must return when the done channel is closed
+type Worker func(done <-chan struct{})
+
+//KeyPrefix is the prefix of the consul keys used as the leader locks
+const KeyPrefix = "service/websockets/leader/"
+
+//errNoBackend is returned when the backend for the configured election is not available
+var errNoBackend = errors.New("the backend for the leader election is not available")
+
+var (
+	//leading has the names of the workers for which the instance is the leader
+	leading = map[string]bool{}
+	//leadingLock is the lock for the leading map
+	leadingLock sync.RWMutex
+)
+
+//IsLeader reports whether the instance is the leader for the worker
+func IsLeader(name string) bool {
+	leadingLock.RLock()
+	defer leadingLock.RUnlock()
+	return leading[name]
+}
+
+//setLeader sets whether the instance is the leader for the worker
+func setLeader(name string, l bool) {
+	leadingLock.Lock()
+	defer leadingLock.Unlock()
+	leading[name] = l
+}
+
+//Run runs the worker on the leader instance for the name. The db is used for the postgres election.
+//It returns right away and the election happens in a go routine
+func Run(name string, db *gorm.DB, w Worker) {
+	go campaign(name, db, w)
+}
+
+//campaign keeps campaigning for the leadership of the worker. Once elected, it runs the worker till the
+//leadership is lost. A standby instance campaigns only after it is promoted
+func campaign(name string, db *gorm.DB, w Worker) {
+	<-config.Active()
+	for {
+		lost, release, err := elect(name, db)
+		if err != nil {
+			log.Error("error while electing the leader for", name, err.Error())
+			time.Sleep(config.LeaderRetry)
+			continue
+		}
+		log.Info("elected as the leader for", name)
+		setLeader(name, true)
+		done := make(chan struct{})
+		finished := make(chan struct{})
+		go func() {
+			w(done)
+			close(finished)
+		}()
+		select {
+		case <-lost:
+			log.Warn("lost the leadership for", name)
+		case <-finished:
+		}
+		setLeader(name, false)
+		close(done)
+		<-finished
+		release()
+		time.Sleep(config.LeaderRetry)
+	}
+}
+
+//elect blocks till the instance becomes the leader for the name. It returns a channel closed when the
+//leadership is lost and the func to release the leadership
+func elect(name string, db *gorm.DB) (<-chan struct{}, func(), error) {
+	switch config.LeaderElection {
+	case config.LeaderElectionNone:
+		return make(chan struct{}), func() {}, nil
+	case config.LeaderElectionPostgres:
+		return electPostgres(name, db)
+	}
+	return electConsul(name)
+}
+
+//electConsul elects the leader with a consul lock
+func electConsul(name string) (<-chan struct{}, func(), error) {
+	client := config.DiscoveryClient()
+	if client == nil {
+		return nil, nil, errNoBackend
+	}
+	lock, err := client.LockOpts(&api.LockOptions{
+		Key:         KeyPrefix + name,
+		Value:       []byte(config.ServiceDomain + ":" + config.Port),
+		SessionName: config.WebsocketsServerID + "-leader-" + name,
+		SessionTTL:  "15s",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	lost, err := lock.Lock(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lost, func() {
+		if err := lock.Unlock(); err != nil {
+			log.Error("error while releasing the leadership for", name, err.Error())
+		}
+	}, nil
+}
+
+//electPostgres elects the leader with a postgres session level advisory lock held on a dedicated connection.
+//The leadership is lost when the connection is lost
+func electPostgres(name string, db *gorm.DB) (<-chan struct{}, func(), error) {
+	/*
+	 * We will take a dedicated connection from the pool
+	 * We will keep trying the advisory lock on the connection
+	 * Once we have the lock, we will watch the connection
+	 */
+	if db == nil {
+		return nil, nil, errNoBackend
+	}
+	h := fnv.New64a()
+	h.Write([]byte(KeyPrefix + name))
+	key := int64(h.Sum64())
+
+	conn, err := db.DB().Conn(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		var locked bool
+		err := conn.QueryRowContext(context.Background(), "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		if locked {
+			break
+		}
+		time.Sleep(config.LeaderRetry)
+	}
+
+	lost := make(chan struct{})
+	stop := make(chan struct{})
+	go watchConn(conn, lost, stop)
+	return lost, func() {
+		close(stop)
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			log.Error("error while releasing the leadership for", name, err.Error())
+		}
+		conn.Close()
+	}, nil
+}
+
+//watchConn closes the lost channel when the connection holding the advisory lock is lost
+func watchConn(conn *sql.Conn, lost, stop chan struct{}) {
+	t := time.NewTicker(config.LeaderRetry)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := conn.PingContext(context.Background()); err != nil {
+				close(lost)
+				return
+			}
+		}
+	}
+}
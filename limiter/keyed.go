@@ -0,0 +1,71 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package limiter
+
+import (
+	"container/list"
+	"sync"
+)
+
+/*
+ * This file contains the token buckets of the keys like the ips. The no. of buckets is bounded.
+ * Beyond it, the bucket of the least recently used key is evicted, so the keys seen recently keep their limits
+ * while a flood of new keys can't grow the memory.
+ */
+
+//keyedBucket is the bucket of a key in the lru list
+type keyedBucket struct {
+	key    string
+	bucket *Bucket
+}
+
+//Buckets has the token buckets of the keys with the least recently used ones evicted beyond the size.
+//It is safe for concurrent use
+type Buckets struct {
+	//rate and burst of the buckets created
+	rate  float64
+	burst int
+	//size is the max no. of buckets kept
+	size int
+	//lru has the buckets from the most recently used one
+	lru *list.List
+	//keys has the elements of the lru list mapped by the key
+	keys map[string]*list.Element
+	//m is the lock for the buckets
+	m sync.Mutex
+}
+
+//NewBuckets returns the buckets keeping upto size buckets, each refilling at rate tokens per second and holding upto burst tokens
+func NewBuckets(rate float64, burst, size int) *Buckets {
+	if size < 1 {
+		size = 1
+	}
+	return &Buckets{rate: rate, burst: burst, size: size, lru: list.New(), keys: map[string]*list.Element{}}
+}
+
+//Get returns the bucket of the key creating it if required. The least recently used bucket is evicted if the size is exceeded
+func (b *Buckets) Get(key string) *Bucket {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if e, ok := b.keys[key]; ok {
+		b.lru.MoveToFront(e)
+		return e.Value.(*keyedBucket).bucket
+	}
+	kb := &keyedBucket{key: key, bucket: NewBucket(b.rate, b.burst)}
+	b.keys[key] = b.lru.PushFront(kb)
+	if b.lru.Len() > b.size {
+		oldest := b.lru.Back()
+		b.lru.Remove(oldest)
+		delete(b.keys, oldest.Value.(*keyedBucket).key)
+	}
+	return kb.bucket
+}
+
+//Len returns the no. of buckets kept
+func (b *Buckets) Len() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.lru.Len()
+}
@@ -0,0 +1,34 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package limiter_test
+
+import (
+	"testing"
+
+	"github.com/cuttle-ai/websockets/limiter"
+)
+
+func TestBucketsEviction(t *testing.T) {
+	bs := limiter.NewBuckets(0.001, 1, 2)
+
+	//the exhausted bucket of a recently used key is kept while the new keys come in
+	if !bs.Get("a").Allow() || bs.Get("a").Allow() {
+		t.Fatal("expected the bucket of a to allow only its burst")
+	}
+	bs.Get("b")
+	bs.Get("a")
+	bs.Get("c")
+	if bs.Len() != 2 {
+		t.Fatalf("expected 2 buckets to be kept. got %d", bs.Len())
+	}
+	if bs.Get("a").Allow() {
+		t.Error("expected the recently used bucket of a to stay exhausted")
+	}
+
+	//the least recently used key was evicted and starts with a full bucket
+	if !bs.Get("b").Allow() {
+		t.Error("expected the evicted bucket of b to start full")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package limiter has the token bucket rate limiter used across the application
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * This file contains the definition of the token bucket
+ */
+
+//Bucket is a token bucket rate limiter. It is safe for concurrent use
+type Bucket struct {
+	//rate is the no. of tokens added to the bucket per second
+	rate float64
+	//burst is the max no. of tokens the bucket can hold
+	burst float64
+	//tokens is the no. of tokens available in the bucket
+	tokens float64
+	//last is the last time the tokens were refilled
+	last time.Time
+	//m is the lock for the bucket
+	m sync.Mutex
+}
+
+//NewBucket returns a full bucket which refills at rate tokens per second and can hold upto burst tokens.
+//If the rate is not positive, the bucket is unlimited
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+//refill will add the tokens accumulated since the last refill. It has to be called with the lock held
+func (b *Bucket) refill(n time.Time) {
+	b.tokens += n.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = n
+}
+
+//Allow reports whether a token is available now. If available the token is consumed
+func (b *Bucket) Allow() bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//Delay returns the duration after which a token will be available without consuming it.
+//Zero duration means a token is available now
+func (b *Bucket) Delay() time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+//Reserve consumes a token and returns the duration after which the token will be available.
+//Zero duration means the token can be used right away
+func (b *Bucket) Reserve() time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.refill(time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+//Wait blocks till a token is available or the done channel is closed.
+//It returns false if the done channel got closed before the token became available
+func (b *Bucket) Wait(done <-chan struct{}) bool {
+	d := b.Reserve()
+	if d == 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	}
+}
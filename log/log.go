@@ -2,12 +2,19 @@
 // Use of this source code is governed by a MIT-style
 // license that can be found in the LICENSE file.
 
-//Package log is used to print logs based of log types
+//Package log is used to print logs based of log types.
+//The logs are written as the free form text lines or as a json object per line as per the log format.
+//The fields passed with F, like the event name, are written as the keys of the json object
 package log
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/cuttle-ai/websockets/config"
 )
@@ -26,34 +33,104 @@ const (
 	PANIC = "PANIC"
 )
 
+//levels has the severity of the log types
+var levels = map[string]int{DEBUG: 0, INFO: 1, WARN: 2, ERROR: 3, PANIC: 4}
+
+//Field is a named field of a log. In the json format it is written as a key of the log object
+type Field struct {
+	//Key of the field
+	Key string
+	//Value of the field
+	Value interface{}
+}
+
+//F returns the field with the key and the value to be passed along with the log message. Eg. log.F("event", n.Event)
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+//String returns the field as key=value for the text logs
+func (f Field) String() string {
+	return f.Key + "=" + fmt.Sprint(f.Value)
+}
+
+//jsonLock is the lock for writing the json logs so that the lines don't interleave
+var jsonLock sync.Mutex
+
+//enabled reports whether the logs of the type are written as per the log level
+func enabled(typ string) bool {
+	return levels[typ] >= levels[strings.ToUpper(config.LogLevel)]
+}
+
+//write writes the log of the type with the args. The fields in the args are written as the keys of the json logs
+func write(typ string, l []interface{}) {
+	/*
+	 * We will write the text log if the format isn't json
+	 * Else we will separate the fields from the message
+	 * Then we will write the log object as a json line
+	 */
+	if config.LogFormat != config.LogFormatJSON {
+		log.Print(typ+": ", fmt.Sprintln(l...))
+		return
+	}
+
+	//separating the fields
+	entry := map[string]interface{}{}
+	msg := make([]interface{}, 0, len(l))
+	for _, a := range l {
+		if f, ok := a.(Field); ok {
+			entry[f.Key] = f.Value
+			continue
+		}
+		msg = append(msg, a)
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = strings.ToLower(typ)
+	entry["msg"] = strings.TrimSuffix(fmt.Sprintln(msg...), "\n")
+
+	//writing the log
+	b, err := json.Marshal(entry)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{"time": entry["time"], "level": entry["level"], "msg": entry["msg"]})
+	}
+	jsonLock.Lock()
+	defer jsonLock.Unlock()
+	os.Stderr.Write(append(b, '\n'))
+}
+
 //Info logs the info logs of the application
 func Info(l ...interface{}) {
-	log.Print(INFO+": ", fmt.Sprintln(l...))
+	if enabled(INFO) {
+		write(INFO, l)
+	}
 }
 
 //Debug logs the debug logs of the application if debug logs are not switched off
 func Debug(l ...interface{}) {
-	//Checking if Debug log is off
-	if config.PRODUCTION == 0 {
-		return
+	if enabled(DEBUG) {
+		write(DEBUG, l)
 	}
-	log.Print(DEBUG+": ", fmt.Sprintln(l...))
 }
 
 //Warn logs the warning logs of the application
 func Warn(l ...interface{}) {
-	log.Print(WARN+": ", fmt.Sprintln(l...))
+	if enabled(WARN) {
+		write(WARN, l)
+	}
 }
 
 //Error logs the error logs of the application
 func Error(l ...interface{}) {
-	log.Print(ERROR+": ", fmt.Sprintln(l...))
+	if enabled(ERROR) {
+		write(ERROR, l)
+	}
 }
 
 //Fatal is used to print logs for events which causes the app to exit
 func Fatal(l ...interface{}) {
 	/*
-	 * We will call log.Fatal
+	 * We will write the log and exit
 	 */
-	log.Fatal(PANIC+": ", fmt.Sprintln(l...))
+	write(PANIC, l)
+	os.Exit(1)
 }
@@ -4,12 +4,18 @@
 
 package log
 
+import "github.com/cuttle-ai/websockets/config"
+
 /* This file contains the definitions of logger interface */
 
 //Logger must be implemented by the logger utilities to be an app logger
 type Logger struct {
-	//ID of the logger
+	//ID of the logger. It is the id of the app context
 	ID int
+	//RequestID is the id of the request served with the logger
+	RequestID string
+	//UserID is the id of the user of the request
+	UserID uint
 }
 
 //NewLogger returns the new logger with ID initiated
@@ -22,32 +28,43 @@ func (lo *Logger) GetID() int {
 	return lo.ID
 }
 
+//args returns the args of the log prefixed with the id of the logger.
+//In the json logs the id, the request id and the user id are written as the fields
+func (lo *Logger) args(l []interface{}) []interface{} {
+	if config.LogFormat != config.LogFormatJSON {
+		return append([]interface{}{"ID:", lo.ID}, l...)
+	}
+	p := []interface{}{F("appContextId", lo.ID)}
+	if len(lo.RequestID) != 0 {
+		p = append(p, F("requestId", lo.RequestID))
+	}
+	if lo.UserID != 0 {
+		p = append(p, F("userId", lo.UserID))
+	}
+	return append(p, l...)
+}
+
 //Info logs the informative logs
 func (lo *Logger) Info(l ...interface{}) {
-	p := append([]interface{}{"ID:", lo.ID}, l...)
-	Info(p...)
+	Info(lo.args(l)...)
 }
 
 //Debug logs for the debugging logs
 func (lo *Logger) Debug(l ...interface{}) {
-	p := append([]interface{}{"ID:", lo.ID}, l...)
-	Info(p...)
+	Debug(lo.args(l)...)
 }
 
 //Warn logs the warning logs
 func (lo *Logger) Warn(l ...interface{}) {
-	p := append([]interface{}{"ID:", lo.ID}, l...)
-	Info(p...)
+	Warn(lo.args(l)...)
 }
 
 //Error logs the error
 func (lo *Logger) Error(l ...interface{}) {
-	p := append([]interface{}{"ID:", lo.ID}, l...)
-	Info(p...)
+	Error(lo.args(l)...)
 }
 
 //Fatal logs the fatal issues
 func (lo *Logger) Fatal(l ...interface{}) {
-	p := append([]interface{}{"ID:", lo.ID}, l...)
-	Info(p...)
+	Fatal(lo.args(l)...)
 }
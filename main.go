@@ -10,25 +10,68 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/cuttle-ai/websockets/config"
 	"github.com/cuttle-ai/websockets/log"
 	"github.com/cuttle-ai/websockets/routes"
+	"github.com/cuttle-ai/websockets/tracing"
 )
 
 /*
  * This file contains the main start point of the application
  */
 
+//bootstrapRetries is the no. of times the bootstrap is retried on a retryable error
+const bootstrapRetries = 5
+
+//bootstrap bootstraps the config, retrying with a backoff while the error is retryable.
+//The service exits if the bootstrap fails
+func bootstrap() {
+	backoff := time.Second
+	for i := 0; ; i++ {
+		err := config.Bootstrap()
+		if err == nil {
+			break
+		}
+		if iErr, ok := err.(*config.InitError); !ok || !iErr.Retryable() || i == bootstrapRetries {
+			log.Fatal("Couldn't bootstrap the service", err.Error())
+		}
+		log.Warn("Couldn't bootstrap the service. Retrying in", backoff, err.Error())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	for _, d := range config.Degraded() {
+		log.Warn("Running in the degraded mode without the", d.Part, d.Error())
+	}
+}
+
 func main() {
 	/*
+	 * Bootstrap the config
 	 * Create a new Server mux
 	 * Create a default server
 	 * Init the routes
-	 * Now listen and serve
-	 * Listen to the os signals for exit
+	 * Start the certificate management in the autocert mode and set the tls config of the server
+	 * Trace the database calls
+	 * Log the startup banner
+	 * Watch the sessions revoked in the identity system
+	 * Keep the caches warm if the instance is a standby
+	 * Replay the notifications left pending in the journal
+	 * Now listen and serve. TLS is served if the certificate is configured
+	 * Listen and serve the internal listener with mutual tls if it is configured
+	 * Listen to the os signals for exit and mark the instance not ready
+	 * Coordinate the restart with the other instances
+	 * Deregister from the discovery service
+	 * Flush the notifications in flight to the shared store, the critical ones first
+	 * Tell the connected clients that the server is draining
 	 * Graceful exit when command comes
+	 * Close the journal
 	 */
+	//bootstrapping the config
+	bootstrap()
+
 	//creating a new server mux
 	m := http.NewServeMux()
 
@@ -43,27 +86,126 @@ func main() {
 
 	//inited the routes
 	routes.InitRoutes(m)
+	if err := routes.StartAutocert(m); err != nil {
+		log.Fatal("Couldn't start the automatic certificate management", err.Error())
+	}
+	if err := routes.StartWebPush(); err != nil {
+		log.Fatal("Couldn't start the web push", err.Error())
+	}
+	if err := routes.StartMobilePush(); err != nil {
+		log.Fatal("Couldn't start the mobile push bridge", err.Error())
+	}
+	if err := routes.StartNATS(); err != nil {
+		log.Fatal("Couldn't start the nats bridge", err.Error())
+	}
+	if err := routes.StartAMQP(); err != nil {
+		log.Fatal("Couldn't start the amqp consumer", err.Error())
+	}
+	if err := routes.StartPGListen(); err != nil {
+		log.Fatal("Couldn't listen to the postgres channels", err.Error())
+	}
+	s.TLSConfig = config.TLSConfig()
+	is := &http.Server{
+		Addr:           ":" + config.InternalPort,
+		Handler:        m,
+		ReadTimeout:    config.RequestRTimeout,
+		WriteTimeout:   config.ResponseWTimeout,
+		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      config.InternalTLSConfig(),
+	}
+	routes.LogBanner()
+	tracing.TraceDB(config.DB())
+	routes.WatchRevocations(context.Background())
+	config.WarmStandby()
+	if config.Standby() {
+		log.Warn("Running as a warm standby. Promote the instance to serve the traffic")
+	}
+	if config.BenchmarkEnabled() {
+		log.Warn("Benchmark mode is enabled. Handshakes with the signed test tokens bypass the auth service")
+	}
+
+	//replaying the journal
+	if err := routes.OpenJournal(); err != nil {
+		log.Fatal("Couldn't open the notification journal", err.Error())
+	}
 
 	//listen and serve to the server
 	go func() {
+		if config.TLSEnabled() {
+			log.Info("Starting the server with TLS at :" + config.Port)
+			log.Error(s.ListenAndServeTLS("", ""))
+			return
+		}
 		log.Info("Starting the server at :" + config.Port)
 		log.Error(s.ListenAndServe())
 	}()
-	go func() {
-		log.Info("Starting the rpc service at :" + config.RPCPort)
-		config.StartRPC()
-	}()
+
+	//listen and serve to the internal server
+	if len(config.InternalPort) != 0 {
+		go func() {
+			log.Info("Starting the internal server with mutual TLS at :" + config.InternalPort)
+			log.Error(is.ListenAndServeTLS("", ""))
+		}()
+	}
+	log.Info("Starting the rpc service at :" + config.RPCPort)
+	if err := config.StartRPC(); err != nil {
+		log.Fatal("Couldn't start the rpc service", err.Error())
+	}
+	log.Info("Starting the grpc service at :" + config.GRPCPort)
+	if err := routes.StartGRPC(); err != nil {
+		log.Fatal("Couldn't start the grpc service", err.Error())
+	}
 
 	//listening for syscalls
 	var gracefulStop = make(chan os.Signal, 1)
-	signal.Notify(gracefulStop, os.Interrupt)
+	signal.Notify(gracefulStop, os.Interrupt, syscall.SIGTERM)
 	sig := <-gracefulStop
 
-	//gracefulling exiting when request comes in
+	//waiting for the turn to restart
 	log.Info("Received the interrupt", sig)
+	config.SetNotReady("shutting down")
+	release, err := config.CoordinateRestart()
+	if err != nil {
+		log.Warn("Couldn't coordinate the restart with the other instances. Going ahead with the shutdown", err.Error())
+	}
+	defer release()
+
+	//deregistering from the discovery service so that no new traffic is routed to the instance
+	if err := config.DeregisterDiscovery(); err != nil {
+		log.Error("Couldn't deregister from the discovery service", err.Error())
+	}
+
+	//flushing the notifications in flight
+	routes.FlushPending()
+
+	//closing the websocket connections with the draining reason
+	if routes.CloseConnections(config.CloseServerDraining) != 0 {
+		time.Sleep(config.CloseGrace)
+	}
+
+	//gracefulling exiting when request comes in
 	log.Info("Shutting down the server")
-	err := s.Shutdown(context.Background())
+	routes.StopGRPC()
+	err = s.Shutdown(context.Background())
 	if err != nil {
 		log.Error("Couldn't end the server gracefully")
 	}
+	if len(config.InternalPort) != 0 {
+		if err := is.Shutdown(context.Background()); err != nil {
+			log.Error("Couldn't end the internal server gracefully")
+		}
+	}
+	if err := config.CloseWebSockets(); err != nil {
+		log.Error("Couldn't close the websockets server", err.Error())
+	}
+
+	//closing the journal
+	if err := routes.CloseJournal(); err != nil {
+		log.Error("Couldn't close the notification journal", err.Error())
+	}
+
+	//exporting the spans left
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		log.Error("Couldn't export the trace spans left", err.Error())
+	}
 }
@@ -0,0 +1,276 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package preference has the notification preferences of the users.
+//A user can disable the notifications of a category or mute them till a time.
+//The category of a notification is its category if given else its event name.
+//The preferences are stored in the database and cached per user by every instance.
+//The cache keeps the recently used users. The database isn't called under the cache lock
+//and a user whose preferences failed to load is retried after a backoff
+package preference
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the preferences, their storage and enforcement
+ */
+
+//ErrNotFound is returned when the preference doesn't exist
+var ErrNotFound = errors.New("preference not found")
+
+//Preference is the preference of a user for the notifications of a category
+type Preference struct {
+	//UserID is the id of the user
+	UserID uint `gorm:"primary_key;auto_increment:false" json:"-"`
+	//Category of the notifications. It is the event name for the notifications without a category
+	Category string `gorm:"primary_key" json:"category"`
+	//Enabled is false if the user doesn't want the notifications of the category. Defaults to true
+	Enabled bool `json:"enabled"`
+	//MutedUntil is the time till which the notifications of the category are muted
+	MutedUntil *time.Time `json:"mutedUntil,omitempty"`
+	//UpdatedAt is the time at which the preference was last changed
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+//TableName returns the table name of the preferences
+func (Preference) TableName() string {
+	return "notification_preferences"
+}
+
+//Allows reports whether the notifications are allowed by the preference at the given time
+func (p Preference) Allows(t time.Time) bool {
+	return p.Enabled && (p.MutedUntil == nil || !p.MutedUntil.After(t))
+}
+
+//UnmarshalJSON decodes the preference with the notifications enabled unless it is disabled explicitly
+func (p *Preference) UnmarshalJSON(b []byte) error {
+	type preference Preference
+	d := preference{Enabled: true}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return err
+	}
+	*p = Preference(d)
+	return nil
+}
+
+//maxBackoff is the max time after which the failed load of the preferences of a user is retried
+const maxBackoff = 5 * time.Minute
+
+//userPreferences are the cached preferences of a user
+type userPreferences struct {
+	//userID of the user
+	userID uint
+	//prefs has the preferences mapped by the category. It isn't modified once cached, it is replaced
+	prefs map[string]Preference
+	//loadedAt is the time at which the preferences were loaded. Zero if they were never loaded
+	loadedAt time.Time
+	//failures is the no. of consecutive failed loads
+	failures int
+	//retryAt is the time after which the failed load is retried
+	retryAt time.Time
+	//err is the error of the last failed load
+	err error
+}
+
+var (
+	//db is the database in which the preferences are stored. The preferences are kept only in memory if it is nil
+	db *gorm.DB
+	//cache has the cached preferences in the lru list mapped by the user id
+	cache = map[uint]*list.Element{}
+	//lru has the cached preferences from the most recently used one
+	lru = list.New()
+	//cacheLock is the lock for the cache. The database isn't called under it
+	cacheLock sync.Mutex
+)
+
+//Init will migrate the preferences table. If the db is nil, the preferences are kept in memory
+func Init(d *gorm.DB) error {
+	if d == nil {
+		return nil
+	}
+	if err := d.AutoMigrate(&Preference{}).Error; err != nil {
+		return err
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	db = d
+	return nil
+}
+
+//cached returns the cached preferences of the user marking them as recently used. It has to be called with the cache lock
+func cached(userID uint) (*userPreferences, bool) {
+	e, ok := cache[userID]
+	if !ok {
+		return nil, false
+	}
+	lru.MoveToFront(e)
+	return e.Value.(*userPreferences), true
+}
+
+//keep caches the preferences of the user. The least recently used users are evicted beyond the cache size.
+//Without the database, the cache is the storage and nothing is evicted. It has to be called with the cache lock
+func keep(u *userPreferences) {
+	if e, ok := cache[u.userID]; ok {
+		e.Value = u
+		lru.MoveToFront(e)
+		return
+	}
+	cache[u.userID] = lru.PushFront(u)
+	for db != nil && lru.Len() > config.PreferencesCacheSize {
+		oldest := lru.Back()
+		lru.Remove(oldest)
+		delete(cache, oldest.Value.(*userPreferences).userID)
+	}
+}
+
+//backoff returns the time after which the load failed the given no. of times is retried
+func backoff(failures int) time.Duration {
+	wait := config.PreferencesRefresh
+	for i := 1; i < failures && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+//load returns the preferences of the user mapped by the category reloading them from the database if they are older
+//than the refresh interval. If the reload fails, the preferences loaded last are returned and the reload is retried
+//after a backoff. The returned map must not be modified
+func load(userID uint, now time.Time) (map[string]Preference, error) {
+	/*
+	 * We will return the cached preferences if they are fresh or the failed load is backing off
+	 * Then we will load the preferences from the database without the lock
+	 * Then we will cache them or record the failure
+	 */
+	cacheLock.Lock()
+	u, ok := cached(userID)
+	if db == nil || (ok && (now.Sub(u.loadedAt) < config.PreferencesRefresh || now.Before(u.retryAt))) {
+		defer cacheLock.Unlock()
+		if !ok {
+			return map[string]Preference{}, nil
+		}
+		if u.loadedAt.IsZero() {
+			return nil, u.err
+		}
+		return u.prefs, nil
+	}
+	cacheLock.Unlock()
+
+	//loading the preferences
+	ps := []Preference{}
+	err := db.Where("user_id = ?", userID).Find(&ps).Error
+
+	//caching them
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if err != nil {
+		failed := &userPreferences{userID: userID, prefs: map[string]Preference{}, err: err}
+		if u, ok := cached(userID); ok {
+			failed.prefs, failed.loadedAt, failed.failures = u.prefs, u.loadedAt, u.failures
+		}
+		failed.failures++
+		failed.retryAt = now.Add(backoff(failed.failures))
+		keep(failed)
+		if failed.loadedAt.IsZero() {
+			return nil, err
+		}
+		return failed.prefs, nil
+	}
+	loaded := &userPreferences{userID: userID, prefs: make(map[string]Preference, len(ps)), loadedAt: now}
+	for _, p := range ps {
+		loaded.prefs[p.Category] = p
+	}
+	keep(loaded)
+	return loaded.prefs, nil
+}
+
+//update replaces the cached preference of the user for the category. A nil preference removes it.
+//The users not cached are loaded from the database when they are used next
+func update(userID uint, category string, p *Preference) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	u, ok := cached(userID)
+	if !ok && db != nil {
+		return
+	}
+	updated := &userPreferences{userID: userID, prefs: map[string]Preference{}, loadedAt: time.Now()}
+	if ok {
+		updated.loadedAt = u.loadedAt
+		for c, v := range u.prefs {
+			updated.prefs[c] = v
+		}
+	}
+	if p != nil {
+		updated.prefs[category] = *p
+	} else {
+		delete(updated.prefs, category)
+	}
+	keep(updated)
+}
+
+//List returns the preferences of the user
+func List(userID uint) ([]Preference, error) {
+	prefs, err := load(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Preference, 0, len(prefs))
+	for _, p := range prefs {
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+//Set saves the preference of the user for the category
+func Set(p Preference) (Preference, error) {
+	p.UpdatedAt = time.Now()
+	if db != nil {
+		if err := db.Save(&p).Error; err != nil {
+			return p, err
+		}
+	}
+	update(p.UserID, p.Category, &p)
+	return p, nil
+}
+
+//Delete deletes the preference of the user for the category. The notifications of the category are allowed again
+func Delete(userID uint, category string) error {
+	prefs, err := load(userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if _, ok := prefs[category]; !ok {
+		return ErrNotFound
+	}
+	if db != nil {
+		if err := db.Where("user_id = ? AND category = ?", userID, category).Delete(&Preference{}).Error; err != nil {
+			return err
+		}
+	}
+	update(userID, category, nil)
+	return nil
+}
+
+//Allows reports whether the user allows the notifications of the category at the given time.
+//The notifications are allowed if the user has no preference for the category
+//or if the preferences couldn't be loaded
+func Allows(userID uint, category string, t time.Time) (bool, error) {
+	prefs, err := load(userID, t)
+	if err != nil {
+		return true, err
+	}
+	p, ok := prefs[category]
+	return !ok || p.Allows(t), nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package preference_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/preference"
+)
+
+func TestEnabledByDefault(t *testing.T) {
+	p := preference.Preference{}
+	if err := json.Unmarshal([]byte(`{"category":"muted","mutedUntil":"2100-01-01T00:00:00Z"}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Enabled {
+		t.Error("expected the preference to be enabled when it isn't disabled explicitly")
+	}
+	if err := json.Unmarshal([]byte(`{"category":"off","enabled":false}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Enabled {
+		t.Error("expected the preference disabled explicitly to stay disabled")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	now := time.Now()
+	if _, err := preference.Set(preference.Preference{UserID: 7, Category: "off"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := preference.Allows(7, "off", now); err != nil || ok {
+		t.Errorf("expected the disabled category not to be allowed. got %v %v", ok, err)
+	}
+	if ok, err := preference.Allows(7, "other", now); err != nil || !ok {
+		t.Errorf("expected the category without a preference to be allowed. got %v %v", ok, err)
+	}
+
+	//deleting the preference allows the category again
+	if err := preference.Delete(7, "off"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := preference.Allows(7, "off", now); !ok {
+		t.Error("expected the category to be allowed after deleting its preference")
+	}
+	if err := preference.Delete(7, "off"); err != preference.ErrNotFound {
+		t.Errorf("expected deleting a missing preference to fail with not found. got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package apns sends the push notifications to the apple devices through the http/2 api of the apple push notification service.
+//The service authenticates with the provider token signed by the .p8 signing key of the team
+package apns
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/push"
+)
+
+/*
+ * This file contains the provider token and the sending of the apns notifications.
+ * The notifications whose payload has a title or a body are sent as alerts. The others are sent as background
+ * notifications waking the app to fetch them. The event, the payload, the id and the seq are sent as the custom keys.
+ */
+
+//Provider is the push provider of the apns device tokens
+const Provider = "apns"
+
+const (
+	//productionHost is the host of the production apns
+	productionHost = "https://api.push.apple.com"
+	//sandboxHost is the host of the development apns
+	sandboxHost = "https://api.sandbox.push.apple.com"
+	//tokenRefresh is the time after which the provider token is signed again. Apns rejects the tokens older than an hour
+	tokenRefresh = 50 * time.Minute
+	//maxTokenLength is the max length of a hex encoded device token
+	maxTokenLength = 200
+)
+
+//ErrInvalidKey is returned when the signing key couldn't be decoded
+var ErrInvalidKey = errors.New("invalid apns signing key")
+
+//Sender sends the notifications to the apns device tokens
+type Sender struct {
+	//KeyID is the id of the signing key
+	KeyID string
+	//TeamID is the id of the developer team
+	TeamID string
+	//Topic is the bundle id of the app
+	Topic string
+	//Host is the apns host to which the notifications are sent
+	Host string
+	//TTL is the time for which apns keeps a notification without a ttl
+	TTL time.Duration
+	//Client is the http client used to talk to apns
+	Client *http.Client
+	//key is the signing key
+	key *ecdsa.PrivateKey
+	//token is the cached provider token
+	token string
+	//issuedAt is the time at which the cached provider token was signed
+	issuedAt time.Time
+	//lock is the lock for the provider token
+	lock sync.Mutex
+}
+
+//NewSender returns an apns sender of the pem encoded .p8 signing key. The sandbox sends to the development apns
+func NewSender(key []byte, keyID, teamID, topic string, sandbox bool, ttl time.Duration) (*Sender, error) {
+	b, _ := pem.Decode(key)
+	if b == nil {
+		return nil, ErrInvalidKey
+	}
+	k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	ek, ok := k.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	host := productionHost
+	if sandbox {
+		host = sandboxHost
+	}
+	return &Sender{KeyID: keyID, TeamID: teamID, Topic: topic, Host: host, TTL: ttl, Client: &http.Client{Timeout: 10 * time.Second}, key: ek}, nil
+}
+
+//pad left pads the big endian bytes with zeros to the size
+func pad(b []byte, size int) []byte {
+	result := make([]byte, size)
+	copy(result[size-len(b):], b)
+	return result
+}
+
+//providerToken returns the cached provider token or signs a new one
+func (s *Sender) providerToken() (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if len(s.token) != 0 && now.Sub(s.issuedAt) < tokenRefresh {
+		return s.token, nil
+	}
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": s.KeyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": s.TeamID, "iat": now.Unix()})
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	h := sha256.Sum256([]byte(unsigned))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, h[:])
+	if err != nil {
+		return "", err
+	}
+	s.token, s.issuedAt = unsigned+"."+enc.EncodeToString(append(pad(r.Bytes(), 32), pad(sig.Bytes(), 32)...)), now
+	return s.token, nil
+}
+
+//alert returns the alert of the payload if it has a title or a body
+func alert(payload interface{}) map[string]string {
+	m, ok := payload.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	a := map[string]string{}
+	for _, k := range []string{"title", "body"} {
+		if v, ok := m[k].(string); ok && len(v) != 0 {
+			a[k] = v
+		}
+	}
+	if len(a) == 0 {
+		return nil
+	}
+	return a
+}
+
+//CheckToken checks whether the device token is hex encoded
+func (s *Sender) CheckToken(token string) error {
+	if len(token) == 0 || len(token) > maxTokenLength {
+		return push.ErrInvalidToken
+	}
+	if _, err := hex.DecodeString(token); err != nil {
+		return push.ErrInvalidToken
+	}
+	return nil
+}
+
+//Send sends the notification to the device token as an alert or a background notification
+func (s *Sender) Send(sub push.Subscription, n delivery.Notification) error {
+	/*
+	 * We will build the payload as an alert or a background notification
+	 * Then we will send it with the provider token
+	 * The tokens not valid anymore are reported to be removed
+	 */
+	aps := map[string]interface{}{"content-available": 1}
+	pushType, priority := "background", "5"
+	if a := alert(n.Payload); a != nil {
+		aps = map[string]interface{}{"alert": a, "sound": "default"}
+		pushType = "alert"
+		if n.Priority.Urgent() {
+			priority = "10"
+		}
+	}
+	body := map[string]interface{}{"aps": aps, "event": n.Event, "payload": n.Payload}
+	if len(n.ID) != 0 {
+		body["id"] = n.ID
+	}
+	if n.Seq != 0 {
+		body["seq"] = n.Seq
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if err := s.CheckToken(sub.Token); err != nil {
+		return err
+	}
+	token, err := s.providerToken()
+	if err != nil {
+		return err
+	}
+
+	//sending the notification
+	expiration := time.Now().Add(s.TTL)
+	if n.Deadline != nil {
+		expiration = *n.Deadline
+	}
+	req, err := http.NewRequest(http.MethodPost, s.Host+"/3/device/"+sub.Token, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+token)
+	req.Header.Set("apns-topic", s.Topic)
+	req.Header.Set("apns-push-type", pushType)
+	req.Header.Set("apns-priority", priority)
+	req.Header.Set("apns-expiration", strconv.FormatInt(expiration.Unix(), 10))
+	if len(n.ID) != 0 && len(n.ID) <= 64 {
+		req.Header.Set("apns-collapse-id", n.ID)
+	}
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode == http.StatusGone || strings.Contains(string(msg), "BadDeviceToken") {
+		return push.ErrGone
+	}
+	return fmt.Errorf("apns responded with status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package push
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the checks of the web push endpoints given by the browsers.
+ * The service makes the requests to the endpoints, so an endpoint has to be an https url of an allowed push service
+ * resolving to the public addresses. The client sending to the endpoints refuses to dial a private address
+ * so that an endpoint resolving to one after it was checked is still not reached.
+ */
+
+var (
+	//ErrEndpointNotAllowed is returned when the endpoint is not an https url of an allowed push service
+	ErrEndpointNotAllowed = errors.New("endpoint has to be an https url of an allowed push service")
+	//ErrPrivateAddress is returned when the endpoint resolves to a loopback, private or link local address
+	ErrPrivateAddress = errors.New("endpoint resolves to a private address")
+)
+
+//allowedHost reports whether the host is one of the allowed push hosts
+func allowedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range config.PushHosts {
+		h = strings.ToLower(h)
+		if h == host || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+//PublicIP reports whether the ip is a public address
+func PublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+//CheckEndpoint checks whether the endpoint is an https url of an allowed push service resolving to the public addresses
+func CheckEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || !allowedHost(u.Hostname()) {
+		return ErrEndpointNotAllowed
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if !PublicIP(ip) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+//dialPublic refuses to connect to the addresses which aren't public
+func dialPublic(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+//NewClient returns the http client sending the push notifications with the timeout. It connects only to the public addresses
+func NewClient(timeout time.Duration) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{Timeout: timeout, Control: dialPublic}).DialContext
+	return &http.Client{Timeout: timeout, Transport: t}
+}
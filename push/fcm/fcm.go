@@ -0,0 +1,233 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package fcm sends the push notifications to the android and the ios devices through the http v1 api of firebase cloud messaging.
+//The service authenticates with the oauth2 access token of a google service account
+package fcm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/push"
+)
+
+/*
+ * This file contains the access token of the service account and the sending of the fcm messages
+ */
+
+//Provider is the push provider of the fcm device tokens
+const Provider = "fcm"
+
+const (
+	//scope is the oauth2 scope of the fcm api
+	scope = "https://www.googleapis.com/auth/firebase.messaging"
+	//sendURL is the url format of the send api of the project
+	sendURL = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+	//tokenLifetime is the lifetime of the jwt exchanged for the access token
+	tokenLifetime = time.Hour
+)
+
+//ErrInvalidAccount is returned when the service account couldn't be decoded
+var ErrInvalidAccount = errors.New("invalid fcm service account")
+
+//ServiceAccount is the google service account with which the service sends the messages
+type ServiceAccount struct {
+	//ProjectID is the id of the firebase project
+	ProjectID string `json:"project_id"`
+	//ClientEmail is the email of the service account
+	ClientEmail string `json:"client_email"`
+	//PrivateKey is the pem encoded rsa private key of the service account
+	PrivateKey string `json:"private_key"`
+	//TokenURI is the oauth2 token endpoint
+	TokenURI string `json:"token_uri"`
+}
+
+//Sender sends the notifications to the fcm device tokens
+type Sender struct {
+	//Account is the service account of the project
+	Account ServiceAccount
+	//TTL is the time for which fcm keeps a notification without a ttl
+	TTL time.Duration
+	//Client is the http client used to talk to fcm
+	Client *http.Client
+	//key is the private key of the service account
+	key *rsa.PrivateKey
+	//token is the cached access token
+	token string
+	//expiry is the time at which the cached access token expires
+	expiry time.Time
+	//lock is the lock for the access token
+	lock sync.Mutex
+}
+
+//NewSender returns a fcm sender of the json service account
+func NewSender(account []byte, ttl time.Duration) (*Sender, error) {
+	/*
+	 * We will decode the service account
+	 * Then we will parse its private key
+	 */
+	a := ServiceAccount{}
+	if err := json.Unmarshal(account, &a); err != nil || len(a.ProjectID) == 0 || len(a.ClientEmail) == 0 {
+		return nil, ErrInvalidAccount
+	}
+	if len(a.TokenURI) == 0 {
+		a.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	b, _ := pem.Decode([]byte(a.PrivateKey))
+	if b == nil {
+		return nil, ErrInvalidAccount
+	}
+	k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, ErrInvalidAccount
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidAccount
+	}
+	return &Sender{Account: a, TTL: ttl, Client: &http.Client{Timeout: 10 * time.Second}, key: key}, nil
+}
+
+//accessToken returns the cached access token or exchanges a signed jwt for a new one
+func (s *Sender) accessToken() (string, error) {
+	/*
+	 * We will return the cached token if it is valid for a minute more
+	 * Then we will sign the jwt assertion of the service account
+	 * Then we will exchange it for the access token
+	 */
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if len(s.token) != 0 && now.Add(time.Minute).Before(s.expiry) {
+		return s.token, nil
+	}
+
+	//signing the jwt
+	enc := base64.RawURLEncoding
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   s.Account.ClientEmail,
+		"scope": scope,
+		"aud":   s.Account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(tokenLifetime).Unix(),
+	})
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	h := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+
+	//exchanging for the access token
+	res, err := s.Client.PostForm(s.Account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error while getting the fcm access token. status %d", res.StatusCode)
+	}
+	t := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return "", err
+	}
+	s.token, s.expiry = t.AccessToken, now.Add(time.Duration(t.ExpiresIn)*time.Second)
+	return s.token, nil
+}
+
+//message is the fcm message of a notification. The data values have to be strings
+type message struct {
+	Token   string            `json:"token"`
+	Data    map[string]string `json:"data"`
+	Android struct {
+		Priority string `json:"priority"`
+		TTL      string `json:"ttl"`
+	} `json:"android"`
+}
+
+//Send sends the notification to the device token as a data message. The payload is sent json encoded
+func (s *Sender) Send(sub push.Subscription, n delivery.Notification) error {
+	/*
+	 * We will build the message
+	 * Then we will send it with the access token
+	 * The tokens not registered anymore are reported to be removed
+	 */
+	p, err := json.Marshal(n.Payload)
+	if err != nil {
+		return err
+	}
+	m := message{Token: sub.Token, Data: map[string]string{"event": n.Event, "payload": string(p)}}
+	if len(n.ID) != 0 {
+		m.Data["id"] = n.ID
+	}
+	if n.Seq != 0 {
+		m.Data["seq"] = strconv.FormatUint(n.Seq, 10)
+	}
+	ttl := s.TTL
+	if n.Deadline != nil {
+		ttl = time.Until(*n.Deadline)
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	m.Android.TTL = strconv.FormatInt(int64(ttl/time.Second), 10) + "s"
+	m.Android.Priority = "normal"
+	if n.Priority.Urgent() {
+		m.Android.Priority = "high"
+	}
+	b, err := json.Marshal(map[string]interface{}{"message": m})
+	if err != nil {
+		return err
+	}
+	token, err := s.accessToken()
+	if err != nil {
+		return err
+	}
+
+	//sending the message
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(sendURL, s.Account.ProjectID), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if res.StatusCode == http.StatusNotFound || strings.Contains(string(msg), "UNREGISTERED") {
+		return push.ErrGone
+	}
+	return fmt.Errorf("fcm responded with status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+}
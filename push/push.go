@@ -0,0 +1,336 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package push has the push subscriptions of the users and sends the notifications of the offline users to them.
+//A subscription belongs to a push provider like the web push of the browsers or fcm and apns of the mobile devices.
+//The browsers subscribe with their endpoint and keys and the mobile devices with their device token. The senders of the providers
+//are registered by the build profiles compiling them. The subscriptions of a provider without a sender are skipped.
+//The subscriptions are stored in the database and kept in memory if it isn't connected.
+//The notifications are queued and sent to the subscriptions by a pool of workers
+package push
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the push subscriptions, their storage and the sending of the notifications to them
+ */
+
+var (
+	//ErrNotFound is returned when the subscription doesn't exist
+	ErrNotFound = errors.New("push subscription not found")
+	//ErrGone is returned by a sender when the subscription has expired or was unsubscribed. It is removed
+	ErrGone = errors.New("push subscription is gone")
+	//ErrTaken is returned when the endpoint or the token is subscribed by another user.
+	//The other user has to unsubscribe it first. Eg. by logging out of the device
+	ErrTaken = errors.New("push subscription belongs to another user")
+	//ErrInvalidToken is returned when the device token isn't valid for its provider
+	ErrInvalidToken = errors.New("device token is not valid for the push provider")
+)
+
+//Subscription is a push subscription of a device of a user
+type Subscription struct {
+	//ID of the subscription
+	ID uint `gorm:"primary_key" json:"id"`
+	//UserID is the id of the user
+	UserID uint `gorm:"index" json:"-"`
+	//Provider of the subscription. Eg. webpush, fcm or apns
+	Provider string `json:"provider"`
+	//Endpoint is the url of the web push service to which the notifications are sent
+	Endpoint string `gorm:"type:text;index" json:"endpoint,omitempty"`
+	//Token is the device token of the mobile devices
+	Token string `gorm:"type:text;index" json:"token,omitempty"`
+	//P256dh is the base64 url encoded public key of the browser with which the payloads are encrypted
+	P256dh string `json:"p256dh,omitempty"`
+	//Auth is the base64 url encoded authentication secret of the browser
+	Auth string `json:"auth,omitempty"`
+	//CreatedAt is the time at which the device subscribed
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//TableName returns the table name of the push subscriptions
+func (Subscription) TableName() string {
+	return "push_subscriptions"
+}
+
+//Address returns the address of the device to which the notifications are sent. It is unique for a subscription
+func (s Subscription) Address() string {
+	if len(s.Token) != 0 {
+		return s.Token
+	}
+	return s.Endpoint
+}
+
+//Sender sends the notification to a subscription of its provider
+type Sender interface {
+	//Send sends the notification to the subscription. ErrGone is returned if the subscription no longer exists
+	Send(s Subscription, n delivery.Notification) error
+}
+
+//TokenChecker is implemented by the senders whose provider has a format for the device tokens
+type TokenChecker interface {
+	//CheckToken returns ErrInvalidToken if the device token isn't valid for the provider
+	CheckToken(token string) error
+}
+
+//oldEndpointIndex is the unique index on the endpoint created before the device tokens were subscribed.
+//The subscriptions of the device tokens have no endpoint, so it is dropped
+const oldEndpointIndex = "uix_push_subscriptions_endpoint"
+
+var (
+	//db is the database in which the subscriptions are stored. The subscriptions are kept only in memory if it is nil
+	db *gorm.DB
+	//memory has the subscriptions mapped by the user id when the db is nil
+	memory = map[uint][]Subscription{}
+	//nextID is the id of the next subscription kept in memory
+	nextID uint
+	//senders has the senders mapped by the provider
+	senders = map[string]Sender{}
+	//lock is the lock for the subscriptions in memory and the senders
+	lock sync.RWMutex
+)
+
+//Init will migrate the push subscriptions table. If the db is nil, the subscriptions are kept in memory
+func Init(d *gorm.DB) error {
+	if d == nil {
+		return nil
+	}
+	if err := d.AutoMigrate(&Subscription{}).Error; err != nil {
+		return err
+	}
+	if d.Dialect().HasIndex(Subscription{}.TableName(), oldEndpointIndex) {
+		if err := d.Model(&Subscription{}).RemoveIndex(oldEndpointIndex).Error; err != nil {
+			return err
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	db = d
+	return nil
+}
+
+//Register registers the sender of the provider
+func Register(provider string, s Sender) {
+	lock.Lock()
+	defer lock.Unlock()
+	senders[provider] = s
+}
+
+//Enabled reports whether the sender of the provider is registered
+func Enabled(provider string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	_, ok := senders[provider]
+	return ok
+}
+
+//sender returns the sender of the provider
+func sender(provider string) (Sender, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	s, ok := senders[provider]
+	return s, ok
+}
+
+//List returns the subscriptions of the user
+func List(userID uint) ([]Subscription, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if db == nil {
+		return append([]Subscription{}, memory[userID]...), nil
+	}
+	ss := []Subscription{}
+	err := db.Where("user_id = ?", userID).Find(&ss).Error
+	return ss, err
+}
+
+//checkToken checks the device token with the sender of its provider
+func checkToken(provider, token string) error {
+	snd, ok := sender(provider)
+	if !ok {
+		return nil
+	}
+	if c, ok := snd.(TokenChecker); ok {
+		return c.CheckToken(token)
+	}
+	return nil
+}
+
+//Subscribe saves the subscription of the user. An existing subscription of the user with the endpoint or the token is replaced.
+//The web push endpoints have to be the https urls of the allowed push services and the device tokens valid for their provider.
+//ErrTaken is returned if another user has subscribed the endpoint or the token
+func Subscribe(s Subscription) (Subscription, error) {
+	/*
+	 * We will check the web push endpoint or the device token
+	 * Then we will find the existing subscription of the endpoint or the token
+	 * If it belongs to another user, we will refuse it
+	 * Else we will replace it or create the subscription
+	 */
+	if len(s.Token) == 0 {
+		if err := CheckEndpoint(s.Endpoint); err != nil {
+			return s, err
+		}
+	} else if err := checkToken(s.Provider, s.Token); err != nil {
+		return s, err
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	s.CreatedAt = time.Now()
+	if db != nil {
+		old := Subscription{}
+		err := db.Where("endpoint = ? AND token = ?", s.Endpoint, s.Token).First(&old).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			return s, err
+		}
+		if err == nil && old.UserID != s.UserID {
+			return s, ErrTaken
+		}
+		s.ID = old.ID
+		return s, db.Save(&s).Error
+	}
+	for u, ss := range memory {
+		for _, o := range ss {
+			if u != s.UserID && o.Address() == s.Address() {
+				return s, ErrTaken
+			}
+		}
+	}
+	memory[s.UserID] = without(memory[s.UserID], func(o Subscription) bool { return o.Address() == s.Address() })
+	nextID++
+	s.ID = nextID
+	memory[s.UserID] = append(memory[s.UserID], s)
+	return s, nil
+}
+
+//Unsubscribe deletes the subscription of the user
+func Unsubscribe(userID, id uint) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if db != nil {
+		d := db.Where("user_id = ? AND id = ?", userID, id).Delete(&Subscription{})
+		if d.Error != nil {
+			return d.Error
+		}
+		if d.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	}
+	ss := memory[userID]
+	memory[userID] = without(ss, func(o Subscription) bool { return o.ID == id })
+	if len(memory[userID]) == len(ss) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//UnsubscribeToken deletes the subscription of the device token of the user. Eg. when the user logs out of the app
+func UnsubscribeToken(userID uint, token string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if db != nil {
+		d := db.Where("user_id = ? AND token = ?", userID, token).Delete(&Subscription{})
+		if d.Error != nil {
+			return d.Error
+		}
+		if d.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	}
+	ss := memory[userID]
+	memory[userID] = without(ss, func(o Subscription) bool { return len(token) != 0 && o.Token == token })
+	if len(memory[userID]) == len(ss) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//without returns the subscriptions without the ones matching the func
+func without(ss []Subscription, match func(Subscription) bool) []Subscription {
+	result := ss[:0]
+	for _, s := range ss {
+		if !match(s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+//job is a notification queued to be pushed to the subscriptions of the user
+type job struct {
+	userID uint
+	n      delivery.Notification
+}
+
+//jobs has the notifications queued to be pushed
+var jobs = make(chan job, config.PushQueueSize)
+
+//Notify queues the notification to be sent to the subscriptions of the user in the background.
+//The notification is dropped if the queue is full
+func Notify(userID uint, n delivery.Notification) {
+	/*
+	 * We will skip if no sender is registered
+	 * Then we will queue the notification without blocking
+	 */
+	lock.RLock()
+	none := len(senders) == 0
+	lock.RUnlock()
+	if none {
+		return
+	}
+	select {
+	case jobs <- job{userID: userID, n: n}:
+	default:
+		log.Error("push queue is full. dropping the push notification", n.Event, "with seq", n.Seq, "of user", userID)
+	}
+}
+
+//send sends the queued notifications to the subscriptions of their users
+func send() {
+	for j := range jobs {
+		notify(j.userID, j.n)
+	}
+}
+
+//notify sends the notification to each subscription of the user with a sender.
+//The subscriptions gone from the push services are removed
+func notify(userID uint, n delivery.Notification) {
+	ss, err := List(userID)
+	if err != nil {
+		log.Error("error while getting the push subscriptions of user", userID, err.Error())
+		return
+	}
+	for _, s := range ss {
+		snd, ok := sender(s.Provider)
+		if !ok {
+			continue
+		}
+		err := snd.Send(s, n)
+		if err == ErrGone {
+			log.Info("removing the push subscription", s.ID, "of user", userID, "as it is gone")
+			if err := Unsubscribe(userID, s.ID); err != nil && err != ErrNotFound {
+				log.Error("error while removing the push subscription", s.ID, "of user", userID, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			log.Error("error while sending the push notification", n.Event, "to the subscription", s.ID, "of user", userID, err.Error())
+			continue
+		}
+		log.Info("sent the push notification", n.Event, "with seq", n.Seq, "to the subscription", s.ID, "of user", userID)
+	}
+}
+
+func init() {
+	config.SuperviseWorkers("push", config.PushWorkers, send)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package push_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/push"
+)
+
+//upperSender is a test sender accepting only the upper case device tokens
+type upperSender struct{}
+
+func (upperSender) Send(s push.Subscription, n delivery.Notification) error {
+	return nil
+}
+
+func (upperSender) CheckToken(token string) error {
+	if strings.ToUpper(token) != token {
+		return push.ErrInvalidToken
+	}
+	return nil
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	config.PushHosts = []string{"*.push.example.com", "127.0.0.1"}
+	cases := map[string]error{
+		"http://a.push.example.com/send/1": push.ErrEndpointNotAllowed,
+		"https://evil.example.com/send/1":  push.ErrEndpointNotAllowed,
+		"https://push.example.com.evil/1":  push.ErrEndpointNotAllowed,
+		"https://127.0.0.1/send/1":         push.ErrPrivateAddress,
+	}
+	for endpoint, want := range cases {
+		if err := push.CheckEndpoint(endpoint); err != want {
+			t.Errorf("expected %v for %s. got %v", want, endpoint, err)
+		}
+	}
+}
+
+func TestSubscribeTaken(t *testing.T) {
+	if _, err := push.Subscribe(push.Subscription{UserID: 1, Provider: "fcm", Token: "device-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := push.Subscribe(push.Subscription{UserID: 1, Provider: "fcm", Token: "device-1"}); err != nil {
+		t.Errorf("expected the user to replace its own subscription. got %v", err)
+	}
+	if _, err := push.Subscribe(push.Subscription{UserID: 2, Provider: "fcm", Token: "device-1"}); err != push.ErrTaken {
+		t.Errorf("expected %v. got %v", push.ErrTaken, err)
+	}
+	ss, _ := push.List(1)
+	if len(ss) != 1 {
+		t.Errorf("expected the subscription to stay with the user. got %v", ss)
+	}
+
+	//once the user unsubscribes, another user can subscribe the device
+	if err := push.UnsubscribeToken(1, "device-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := push.Subscribe(push.Subscription{UserID: 2, Provider: "fcm", Token: "device-1"}); err != nil {
+		t.Errorf("expected the device to be subscribed by the other user. got %v", err)
+	}
+}
+
+func TestSubscribeInvalidToken(t *testing.T) {
+	push.Register("upper", upperSender{})
+	if _, err := push.Subscribe(push.Subscription{UserID: 1, Provider: "upper", Token: "lower"}); err != push.ErrInvalidToken {
+		t.Errorf("expected %v. got %v", push.ErrInvalidToken, err)
+	}
+	if _, err := push.Subscribe(push.Subscription{UserID: 1, Provider: "upper", Token: "UPPER"}); err != nil {
+		t.Errorf("expected the valid token to be subscribed. got %v", err)
+	}
+}
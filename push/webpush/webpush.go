@@ -0,0 +1,277 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package webpush sends the push notifications to the browsers with the web push protocol.
+//The payloads are encrypted for the browser as per RFC 8291 and the service identifies itself
+//to the push services with the vapid keys as per RFC 8292
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/push"
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+ * This file contains the encryption of the payloads, the vapid authorization and the sending of the web push notifications
+ */
+
+//Provider is the push provider of the web push subscriptions
+const Provider = "webpush"
+
+const (
+	//recordSize is the record size of the encrypted content. The payload is sent in a single record
+	recordSize = 4096
+	//MaxPayload is the max size of the payload which fits in a record after the padding delimiter and the auth tag
+	MaxPayload = recordSize - 17
+	//vapidExpiry is the time for which the vapid authorization is valid. The push services allow 24 hours at most
+	vapidExpiry = 12 * time.Hour
+)
+
+//ErrInvalidKey is returned when the vapid key or the keys of the subscription couldn't be decoded
+var ErrInvalidKey = errors.New("invalid web push key")
+
+//decode decodes the base64 url encoded key with or without the padding
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+//encode encodes the bytes with the unpadded base64 url encoding
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//pad left pads the big endian bytes with zeros to the size
+func pad(b []byte, size int) []byte {
+	result := make([]byte, size)
+	copy(result[size-len(b):], b)
+	return result
+}
+
+//ParseKey returns the vapid private key from its base64 url encoded 32 byte scalar
+func ParseKey(s string) (*ecdsa.PrivateKey, error) {
+	d, err := decode(s)
+	if err != nil || len(d) != 32 {
+		return nil, ErrInvalidKey
+	}
+	k := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	k.Curve = elliptic.P256()
+	k.X, k.Y = k.Curve.ScalarBaseMult(d)
+	return k, nil
+}
+
+//PublicKey returns the base64 url encoded uncompressed public key of the vapid key.
+//The browsers subscribe with it as the application server key
+func PublicKey(k *ecdsa.PrivateKey) string {
+	return encode(elliptic.Marshal(k.Curve, k.X, k.Y))
+}
+
+//Encrypt encrypts the payload for the browser with its public key and the authentication secret
+//in the aes128gcm content encoding
+func Encrypt(payload []byte, p256dh, auth string) ([]byte, error) {
+	/*
+	 * We will decode the keys of the browser
+	 * Then we will derive the shared secret with an ephemeral key
+	 * Then we will derive the content encryption key and the nonce
+	 * Then we will encrypt the padded payload in a single record after the header
+	 */
+	if len(payload) > MaxPayload {
+		return nil, errors.New("web push payload is larger than " + strconv.Itoa(MaxPayload) + " bytes")
+	}
+	uaPublic, err := decode(p256dh)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	authSecret, err := decode(auth)
+	if err != nil || len(authSecret) == 0 {
+		return nil, ErrInvalidKey
+	}
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, ErrInvalidKey
+	}
+
+	//deriving the shared secret
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, asX, asY)
+	sx, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := pad(sx.Bytes(), 32)
+
+	//deriving the key and the nonce
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ecdhSecret, authSecret, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	//encrypting the record
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[16:], recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, append(payload, 2), nil), nil
+}
+
+//Sender sends the notifications to the web push subscriptions
+type Sender struct {
+	//Key is the vapid private key of the service
+	Key *ecdsa.PrivateKey
+	//Subject is the contact of the service sent to the push services. Eg. mailto:ops@cuttle.ai
+	Subject string
+	//TTL is the time for which the push services keep a notification without a ttl
+	TTL time.Duration
+	//Client is the http client used to talk to the push services
+	Client *http.Client
+}
+
+//NewSender returns a web push sender with the vapid key and the subject
+func NewSender(key *ecdsa.PrivateKey, subject string, ttl time.Duration) *Sender {
+	return &Sender{Key: key, Subject: subject, TTL: ttl, Client: push.NewClient(10 * time.Second)}
+}
+
+//message is the payload sent to the browser
+type message struct {
+	Event   string      `json:"event"`
+	Payload interface{} `json:"payload,omitempty"`
+	ID      string      `json:"id,omitempty"`
+	Seq     uint64      `json:"seq,omitempty"`
+}
+
+//authorization returns the vapid authorization of the push service of the endpoint
+func (s *Sender) authorization(endpoint string) (string, error) {
+	/*
+	 * We will find the audience of the endpoint
+	 * Then we will sign the jwt with the vapid key
+	 */
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := encode([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidExpiry).Unix(),
+		"sub": s.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + encode(claims)
+
+	//signing the jwt
+	h := sha256.Sum256([]byte(unsigned))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.Key, h[:])
+	if err != nil {
+		return "", err
+	}
+	signature := append(pad(r.Bytes(), 32), pad(sig.Bytes(), 32)...)
+	return "vapid t=" + unsigned + "." + encode(signature) + ", k=" + PublicKey(s.Key), nil
+}
+
+//Send encrypts and sends the notification to the web push subscription.
+//The payload of the notification is left out if it doesn't fit
+func (s *Sender) Send(sub push.Subscription, n delivery.Notification) error {
+	/*
+	 * We will encode and encrypt the notification
+	 * Then we will post it to the endpoint with the vapid authorization
+	 * The subscriptions not found or gone are reported to be removed
+	 */
+	m := message{Event: n.Event, Payload: n.Payload, ID: n.ID, Seq: n.Seq}
+	b, err := json.Marshal(m)
+	if err == nil && len(b) > MaxPayload {
+		m.Payload = nil
+		b, err = json.Marshal(m)
+	}
+	if err != nil {
+		return err
+	}
+	body, err := Encrypt(b, sub.P256dh, sub.Auth)
+	if err != nil {
+		return err
+	}
+	auth, err := s.authorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	//posting to the endpoint
+	ttl := s.TTL
+	if n.Deadline != nil {
+		ttl = time.Until(*n.Deadline)
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.FormatInt(int64(ttl/time.Second), 10))
+	if n.Priority.Urgent() {
+		req.Header.Set("Urgency", "high")
+	}
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone {
+		return push.ErrGone
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("push service responded with status %d: %s", res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
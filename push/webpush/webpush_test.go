@@ -0,0 +1,68 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+//decrypt decrypts the aes128gcm content as the browser with the private key and the authentication secret
+func decrypt(t *testing.T, body, uaPrivate, uaPublic, authSecret []byte) []byte {
+	salt, rs, idLen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	asPublic, record := body[21:21+idLen], body[21+idLen:]
+	if rs != recordSize {
+		t.Fatalf("expected the record size %d. got %d", recordSize, rs)
+	}
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, asPublic)
+	sx, _ := curve.ScalarMult(x, y, uaPrivate)
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaPublic...), asPublic...)
+	ikm, cek, nonce := make([]byte, 32), make([]byte, 16), make([]byte, 12)
+	io.ReadFull(hkdf.New(sha256.New, pad(sx.Bytes(), 32), authSecret, keyInfo), ikm)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain[len(plain)-1] != 2 {
+		t.Fatal("expected the last record delimiter")
+	}
+	return plain[:len(plain)-1]
+}
+
+func TestEncrypt(t *testing.T) {
+	uaPrivate, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic := elliptic.Marshal(elliptic.P256(), x, y)
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	payload := []byte(`{"event":"dataset-ready"}`)
+	body, err := Encrypt(payload, encode(uaPublic), encode(authSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decrypt(t, body, uaPrivate, uaPublic, authSecret); !bytes.Equal(got, payload) {
+		t.Errorf("expected %s. got %s", payload, got)
+	}
+	if _, err := Encrypt(make([]byte, MaxPayload+1), encode(uaPublic), encode(authSecret)); err == nil {
+		t.Error("expected the payload larger than a record to be rejected")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package redact has the redaction of the sensitive fields of the notification payloads
+//before they are shown or sent outside the user's own stream.
+package redact
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+//Value is the value with which the redacted payload fields are replaced
+const Value = "[scrubbed]"
+
+//Fields returns a copy of the payload with the values of the given fields replaced at any depth.
+//The field names are matched case insensitively
+func Fields(payload interface{}, fields []string) interface{} {
+	/*
+	 * We will normalize the payload to the generic json values
+	 * Then we will walk the payload redacting the fields
+	 */
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	return redact(v, fields)
+}
+
+//redact walks the generic json value redacting the fields
+func redact(v interface{}, fields []string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if redacted(k, fields) {
+				t[k] = Value
+				continue
+			}
+			t[k] = redact(val, fields)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redact(val, fields)
+		}
+	}
+	return v
+}
+
+//redacted reports whether the field has to be redacted
+func redacted(field string, fields []string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/version"
+)
+
+/*
+ * This file contains the capability report of the deployment. It is logged as the startup banner
+ * and served without the user session so that the operators and the client sdks can adapt to what
+ * the deployment supports.
+ */
+
+//Transport is a transport through which the clients and the services reach the instance
+type Transport struct {
+	//Name of the transport
+	Name string `json:"name"`
+	//Port in which the transport is served
+	Port string `json:"port"`
+	//Path of the transport if it is served under a path
+	Path string `json:"path,omitempty"`
+}
+
+//BuildInfo is the build information of the binary
+type BuildInfo struct {
+	//Commit from which the binary is built
+	Commit string `json:"commit"`
+	//Time at which the binary is built
+	Time string `json:"time"`
+	//GoVersion with which the binary is built
+	GoVersion string `json:"goVersion"`
+	//Platform of the binary as os/arch
+	Platform string `json:"platform"`
+	//Profile is the build profile of the binary. minimal, standard or full
+	Profile string `json:"profile"`
+	//Subsystems are the optional subsystems compiled into the binary
+	Subsystems []string `json:"subsystems"`
+}
+
+//About is the capability report of the deployment
+type About struct {
+	//App is the name of the application
+	App string `json:"app"`
+	//Version is the semver code of the application
+	Version string `json:"version"`
+	//API is the default api version
+	API string `json:"api"`
+	//Capabilities has the optional subsystems with whether they are enabled
+	Capabilities map[string]bool `json:"capabilities"`
+	//Transports are the configured transports
+	Transports []Transport `json:"transports"`
+	//StoreBackend is the storage backend of the offline queues, dedup stores and presence
+	StoreBackend string `json:"storeBackend"`
+	//Build is the build information
+	Build BuildInfo `json:"build"`
+}
+
+//AboutReport returns the capability report of the deployment
+func AboutReport() About {
+	transports := []Transport{
+		{Name: "websocket", Port: config.Port, Path: "/cuttle-websockets/"},
+		{Name: "http", Port: config.Port},
+		{Name: "rpc", Port: config.RPCPort},
+	}
+	if config.Compiled(config.SubsystemGRPC) {
+		transports = append(transports, Transport{Name: "grpc", Port: config.GRPCPort})
+	}
+	return About{
+		App:          version.AppName,
+		Version:      version.Default.Code,
+		API:          version.Default.API,
+		Capabilities: config.Capabilities(),
+		Transports:   transports,
+		StoreBackend: config.StoreBackend,
+		Build: BuildInfo{
+			Commit:     version.Commit,
+			Time:       version.BuildTime,
+			GoVersion:  runtime.Version(),
+			Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+			Profile:    config.BuildProfile,
+			Subsystems: config.Subsystems(),
+		},
+	}
+}
+
+//LogBanner logs the capability report as the startup banner in a single json line
+func LogBanner() {
+	b, err := json.Marshal(AboutReport())
+	if err != nil {
+		log.Error("error while encoding the startup banner", err.Error())
+		return
+	}
+	log.Info("ABOUT:", string(b))
+}
+
+//AboutDeployment writes the capability report of the deployment
+func AboutDeployment(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	response.Write(res, response.Message{Message: "about the deployment", Data: AboutReport()})
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:         "v1",
+		HandlerFunc:     AboutDeployment,
+		Pattern:         "/about",
+		Unauthenticated: true,
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the utilities for the admin routes.
+ * The api keys having the scope of an admin route can call it, but they aren't admins. They act only in the tenant of the key
+ */
+
+//Admin wraps the handler func so that only the admin users and the api keys having the scope of the route can access it.
+//The handlers restrict the requests with an api key to the tenant of the key
+func Admin(h HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+		appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+		if !config.IsAdmin(appCtx.Session.User.ID) && !scoped(ctx) {
+			appCtx.Log.Warn("non admin user", appCtx.Session.User.ID, "tried to access the admin route", req.URL.Path)
+			response.WriteError(res, response.Error{Err: "Only admins can access this route"}, http.StatusForbidden)
+			return
+		}
+		h(ctx, res, req)
+	}
+}
+
+//keyTenant returns the tenant of the api key of the request and whether the request was made with an api key.
+//The requests with an api key act only in the tenant of the key, even if the user of the key is an admin
+func keyTenant(ctx context.Context) (string, bool) {
+	k, ok := ctx.Value(APIKeyKey).(apikey.Key)
+	return k.Tenant, ok
+}
+
+//inTenant reports whether the request can act in the tenant
+func inTenant(ctx context.Context, tenant string) bool {
+	t, ok := keyTenant(ctx)
+	return !ok || t == tenant
+}
+
+//restrictTenants restricts the tenants targeted by the request to the tenant of its api key. The empty tenants
+//target every tenant, so they are set to the tenant of the key. It returns false if the request targets another tenant
+func restrictTenants(ctx context.Context, tenants []string) ([]string, bool) {
+	t, ok := keyTenant(ctx)
+	if !ok {
+		return tenants, true
+	}
+	if len(tenants) == 0 {
+		return []string{t}, true
+	}
+	for _, v := range tenants {
+		if v != t {
+			return tenants, false
+		}
+	}
+	return tenants, true
+}
+
+//writeTenantForbidden writes the error response for the request targeting a tenant other than the one of its api key
+func writeTenantForbidden(res http.ResponseWriter) {
+	response.WriteError(res, response.Error{Err: "API key can't act outside its tenant"}, http.StatusForbidden)
+}
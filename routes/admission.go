@@ -0,0 +1,53 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/limiter"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the admission queue of the websocket handshakes. After a mass disconnect the clients
+ * reconnect together. The handshakes are admitted at the handshake rate and the ones beyond it wait in the queue,
+ * so the storm is smoothed instead of exhausting the app context pool. Once the queue is full the handshakes
+ * are shed with a jittered Retry-After hint.
+ */
+
+var (
+	//handshakeBucket admits the handshakes at the handshake rate
+	handshakeBucket = limiter.NewBucket(config.HandshakeRate, config.HandshakeBurst)
+	//handshakesWaiting is the no. of handshakes waiting in the admission queue
+	handshakesWaiting int64
+	//handshakesShed is the no. of handshakes shed as the admission queue was full
+	handshakesShed int64
+)
+
+//admitHandshake waits till the handshake is admitted. It reports false if the queue is full or the client went away,
+//writing the error response with the reconnect hint for the full queue
+func admitHandshake(res http.ResponseWriter, req *http.Request) bool {
+	if config.HandshakeRate <= 0 {
+		return true
+	}
+	defer atomic.AddInt64(&handshakesWaiting, -1)
+	if atomic.AddInt64(&handshakesWaiting, 1) > int64(config.HandshakeQueueSize) {
+		atomic.AddInt64(&handshakesShed, 1)
+		hint := config.CloseServerDraining.WithJitter(config.ReconnectSpread)
+		response.WriteRetry(res, response.Error{Err: "Too many clients are connecting. Please reconnect after some time.", Code: response.CodeHandshakeQueueFull},
+			http.StatusServiceUnavailable, time.Duration(hint.RetryAfterMs)*time.Millisecond)
+		return false
+	}
+	return handshakeBucket.Wait(req.Context().Done())
+}
+
+//HandshakeQueueStats returns the no. of handshakes waiting for the admission and the no. of handshakes shed
+func HandshakeQueueStats() (waiting, shed int64) {
+	return atomic.LoadInt64(&handshakesWaiting), atomic.LoadInt64(&handshakesShed)
+}
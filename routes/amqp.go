@@ -0,0 +1,295 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build full && !minimal
+// +build full,!minimal
+
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/streadway/amqp"
+)
+
+/*
+ * This file contains the amqp consumer ingesting the notifications from the brokers like rabbitmq.
+ * The queue is bound to the exchanges of the config and its messages have the same body as the ingest events.
+ * The event defaults to the routing key. The messages targeting the users unknown to the identity system are dead lettered.
+ * A message targeting both the known and the unknown users is sent to the known ones and dead lettered with only the unknown ones.
+ * The dead lettered messages are published with the publisher confirms and the message is acknowledged only once the broker confirms them.
+ * Else the message is requeued after a backoff growing with the consecutive failures.
+ */
+
+const (
+	//amqpConsumerTag is the consumer tag of the instances
+	amqpConsumerTag = "cuttle-ai-websockets"
+	//amqpMinBackoff is the initial time waited before dialing the broker again or requeueing a message
+	amqpMinBackoff = time.Second
+	//amqpMaxBackoff is the max time waited before dialing the broker again or requeueing a message
+	amqpMaxBackoff = 30 * time.Second
+	//amqpConfirmTimeout is the max time waited for the broker to confirm a published message
+	amqpConfirmTimeout = 10 * time.Second
+)
+
+//errAMQPNacked is returned when the broker doesn't confirm a published message
+var errAMQPNacked = errors.New("amqp broker didn't confirm the message")
+
+//amqpConsumer is the connection to the broker consuming the queue
+type amqpConsumer struct {
+	//conn is the connection to the broker
+	conn *amqp.Connection
+	//ch is the channel consuming the queue and publishing the dead lettered messages in the confirm mode
+	ch *amqp.Channel
+	//deliveries has the messages of the queue. It is closed when the connection breaks
+	deliveries <-chan amqp.Delivery
+	//confirms has the confirms of the published messages in their order
+	confirms <-chan amqp.Confirmation
+	//closed gets the error with which the connection broke
+	closed <-chan *amqp.Error
+	//failures is the no. of consecutive messages requeued
+	failures int
+}
+
+//consumeAMQP dials the broker, declares and binds the queue and starts consuming it
+func consumeAMQP() (*amqpConsumer, error) {
+	/*
+	 * We will dial the broker and open the channel
+	 * Then we will declare and bind the queue
+	 * Then we will put the channel in the confirm mode
+	 * Then we will start consuming the queue
+	 */
+	conn, err := amqp.Dial(config.AMQPURL)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c := &amqpConsumer{conn: conn, ch: ch, closed: conn.NotifyClose(make(chan *amqp.Error, 1))}
+
+	//declaring and binding the queue
+	if _, err := ch.QueueDeclare(config.AMQPQueue, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, b := range config.AMQPBindings {
+		if err := ch.QueueBind(config.AMQPQueue, b.RoutingKey, b.Exchange, false, nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	//confirm mode
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	//consuming the queue
+	if err := ch.Qos(config.AMQPPrefetch, 0, false); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.deliveries, err = ch.Consume(config.AMQPQueue, amqpConsumerTag, false, false, false, false, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+//publish publishes the persistent json message with the headers to the exchange and waits for the broker to confirm it.
+//The connection is closed if the confirm doesn't come in time, so that a late confirm isn't taken for the next message
+func (c *amqpConsumer) publish(exchange, key string, headers amqp.Table, body []byte) error {
+	err := c.ch.Publish(exchange, key, false, false, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case conf, ok := <-c.confirms:
+		if !ok {
+			return amqp.ErrClosed
+		}
+		if !conf.Ack {
+			return errAMQPNacked
+		}
+		return nil
+	case <-time.After(amqpConfirmTimeout):
+		c.conn.Close()
+		return errors.New("timed out waiting for the amqp broker to confirm the message")
+	}
+}
+
+//ack acknowledges the message and resets the consecutive failures
+func (c *amqpConsumer) ack(d amqp.Delivery) {
+	c.failures = 0
+	if err := d.Ack(false); err != nil {
+		log.Error("error while acknowledging the amqp message", d.DeliveryTag, err.Error())
+	}
+}
+
+//requeue requeues the message after a backoff growing with the consecutive failures,
+//so that a failing broker or a failing lookup of the users isn't retried in a hot loop
+func (c *amqpConsumer) requeue(d amqp.Delivery) {
+	backoff := amqpMinBackoff
+	for i := 0; i < c.failures && backoff < amqpMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > amqpMaxBackoff {
+		backoff = amqpMaxBackoff
+	}
+	c.failures++
+	log.Warn("requeueing the amqp message", d.DeliveryTag, "with the routing key", d.RoutingKey, "in", backoff)
+	time.Sleep(backoff)
+	if err := d.Nack(false, true); err != nil {
+		log.Error("error while requeueing the amqp message", d.DeliveryTag, err.Error())
+	}
+}
+
+//deadLetter publishes the message to the dead letter exchange with the reason and acknowledges it once the broker confirms it.
+//If the publish fails, the message is requeued. If no dead letter exchange is configured, the message is rejected
+func deadLetter(c *amqpConsumer, d amqp.Delivery, body []byte, reason string) {
+	log.Warn("dead lettering the amqp message", d.DeliveryTag, "with the routing key", d.RoutingKey, "as", reason)
+	if len(config.AMQPDeadLetterExchange) == 0 {
+		if err := d.Reject(false); err != nil {
+			log.Error("error while rejecting the amqp message", d.DeliveryTag, err.Error())
+		}
+		return
+	}
+	headers := amqp.Table{"x-dead-letter-reason": reason, "x-original-exchange": d.Exchange}
+	if err := c.publish(config.AMQPDeadLetterExchange, d.RoutingKey, headers, body); err != nil {
+		log.Error("error while publishing the amqp message", d.DeliveryTag, "to the dead letter exchange", err.Error())
+		c.requeue(d)
+		return
+	}
+	c.ack(d)
+}
+
+//handleAMQP sends the notification of the message to its known users and dead letters it for the unknown ones
+func handleAMQP(c *amqpConsumer, d amqp.Delivery) {
+	/*
+	 * We will parse the message
+	 * Then we will split the known and the unknown users
+	 * Then we will validate the event
+	 * If there are unknown users we will dead letter the message with them before sending it to the known ones,
+	 * so that a failed dead letter requeues the message without the known users getting it twice
+	 * Then we will publish the event to the known users and acknowledge the message
+	 */
+	e := &IngestEvent{}
+	if err := json.Unmarshal(d.Body, e); err != nil {
+		deadLetter(c, d, d.Body, "invalid message: "+err.Error())
+		return
+	}
+	if len(e.Event) == 0 {
+		e.Event = d.RoutingKey
+	}
+
+	//splitting the users
+	known, unknown := []uint{}, []uint{}
+	for _, u := range e.Users {
+		ok, err := delivery.Known(u)
+		if err != nil {
+			log.Error("error while checking whether the user", u, "of the amqp message", d.DeliveryTag, "is known", err.Error())
+			c.requeue(d)
+			return
+		}
+		if ok {
+			known = append(known, u)
+		} else {
+			unknown = append(unknown, u)
+		}
+	}
+	if len(known) == 0 {
+		deadLetter(c, d, d.Body, "unknown users")
+		return
+	}
+
+	//validating the event
+	be := &bus.Event{Source: bus.SourceAMQP, AppContext: config.NewAppContext(log.NewLogger(0), 0), Notification: e.Notification, Users: known}
+	if err := bus.Check(be); err != nil {
+		deadLetter(c, d, d.Body, "invalid event: "+err.Error())
+		return
+	}
+
+	//dead lettering for the unknown users
+	if len(unknown) != 0 {
+		e.Users = unknown
+		b, _ := json.Marshal(e)
+		if len(config.AMQPDeadLetterExchange) != 0 {
+			headers := amqp.Table{"x-dead-letter-reason": "unknown users", "x-original-exchange": d.Exchange}
+			if err := c.publish(config.AMQPDeadLetterExchange, d.RoutingKey, headers, b); err != nil {
+				log.Error("error while publishing the unknown users of the amqp message", d.DeliveryTag, "to the dead letter exchange", err.Error())
+				c.requeue(d)
+				return
+			}
+		} else {
+			log.Warn("dropping the", len(unknown), "unknown users of the amqp message", d.DeliveryTag, "as no dead letter exchange is configured")
+		}
+	}
+
+	//publishing the event
+	log.Info("ingesting the amqp event", e.Event, "with the routing key", d.RoutingKey, "for", len(known), "users")
+	if err := bus.Publish(be); err != nil {
+		log.Error("error while publishing the amqp event", e.Event, err.Error())
+	}
+	c.ack(d)
+}
+
+//runAMQP handles the messages of the consumer and dials the broker again with a backoff when the connection breaks
+func runAMQP(c *amqpConsumer) {
+	for {
+		for d := range c.deliveries {
+			handleAMQP(c, d)
+		}
+		//the channel can break without the connection. Eg. on publishing to a missing exchange
+		c.conn.Close()
+		log.Warn("amqp connection broke. dialing again", <-c.closed)
+		backoff := amqpMinBackoff
+		for {
+			time.Sleep(backoff)
+			next, err := consumeAMQP()
+			if err == nil {
+				c = next
+				break
+			}
+			log.Error("error while dialing the amqp broker", err.Error())
+			if backoff *= 2; backoff > amqpMaxBackoff {
+				backoff = amqpMaxBackoff
+			}
+		}
+		log.Info("consuming the amqp queue", config.AMQPQueue, "again")
+	}
+}
+
+//StartAMQP starts consuming the notifications from the amqp queue if the amqp consumer is enabled
+func StartAMQP() error {
+	if len(config.AMQPURL) == 0 {
+		return nil
+	}
+	c, err := consumeAMQP()
+	if err != nil {
+		return err
+	}
+	go runAMQP(c)
+	log.Info("Consuming the notifications from the amqp queue", config.AMQPQueue, "bound to", len(config.AMQPBindings), "exchanges")
+	return nil
+}
+
+func init() {
+	config.RegisterSubsystem(config.SubsystemAMQP)
+}
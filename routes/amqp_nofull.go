@@ -0,0 +1,27 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !full || minimal
+// +build !full minimal
+
+package routes
+
+import (
+	"errors"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the amqp consumer of the build profiles without it.
+ * The amqp consumer is compiled in only by the full build profile
+ */
+
+//StartAMQP fails if the amqp consumer is enabled as it is not compiled into the build
+func StartAMQP() error {
+	if len(config.AMQPURL) == 0 {
+		return nil
+	}
+	return errors.New("amqp consumer is not compiled into the " + config.BuildProfile + " build. Build with -tags full")
+}
@@ -0,0 +1,275 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the announcements scheduled by the admins for all the users or the users of a tenant.
+ * An announcement is emitted to the connected users when its window starts and to the users connecting during
+ * its window. A withdrawn announcement is removed and the announcement-withdrawn event is emitted with its id.
+ * The announcements are kept in the store. Each instance checks them periodically and emits to its own connections.
+ */
+
+const (
+	//AnnouncementEvent is the default event with which the announcements are emitted
+	AnnouncementEvent = "announcement"
+	//AnnouncementWithdrawnEvent is emitted with the id of a withdrawn announcement
+	AnnouncementWithdrawnEvent = "announcement-withdrawn"
+	//announcementPrefix is the store key prefix of the announcements
+	announcementPrefix = "announcement/"
+)
+
+//Announcement is a banner or an announcement shown to the users in a time window
+type Announcement struct {
+	//ID of the announcement
+	ID string `json:"id"`
+	//Tenant whose users get the announcement. Empty announces to all the users
+	Tenant string `json:"tenant,omitempty"`
+	//Event with which the announcement is emitted. Defaults to announcement
+	Event string `json:"event,omitempty"`
+	//Lane in which the announcement is emitted. Defaults to the alert lane
+	Lane delivery.Lane `json:"lane,omitempty"`
+	//Payload of the announcement like the message and the link of the banner
+	Payload interface{} `json:"payload"`
+	//StartsAt is the time from which the announcement is shown. Defaults to now
+	StartsAt time.Time `json:"startsAt"`
+	//EndsAt is the time till which the announcement is shown
+	EndsAt time.Time `json:"endsAt"`
+	//CreatedBy is the id of the admin who scheduled the announcement
+	CreatedBy uint `json:"createdBy"`
+}
+
+//active reports whether the announcement is in its window at the time
+func (a Announcement) active(t time.Time) bool {
+	return !t.Before(a.StartsAt) && t.Before(a.EndsAt)
+}
+
+//notification returns the notification with which the announcement is emitted
+func (a Announcement) notification() delivery.Notification {
+	n := delivery.Notification{Lane: a.Lane}
+	n.Event = a.Event
+	n.Payload = a
+	return n
+}
+
+var (
+	//activeAnnouncements has the announcements in their window emitted by the instance mapped by the id
+	activeAnnouncements = map[string]Announcement{}
+	//announcementsLock is the lock for the active announcements
+	announcementsLock sync.RWMutex
+)
+
+//listAnnouncements returns the announcements in the store
+func listAnnouncements() ([]Announcement, error) {
+	as, err := store.Default.Scan(announcementPrefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Announcement, 0, len(as))
+	for _, b := range as {
+		a := Announcement{}
+		if err := json.Unmarshal(b, &a); err != nil {
+			log.Error("error while decoding the announcement", err.Error())
+			continue
+		}
+		result = append(result, a)
+	}
+	return result, nil
+}
+
+//checkAnnouncements emits the announcements whose window has started since the last check to the connections
+//of the instance and the withdrawn event for the active ones no more in the store
+func checkAnnouncements(now time.Time) {
+	/*
+	 * We will load the announcements
+	 * Then we will find the started and the withdrawn ones
+	 * Then we will emit them to the connections of the instance
+	 */
+	//loading the announcements
+	as, err := listAnnouncements()
+	if err != nil {
+		log.Error("error while loading the announcements", err.Error())
+		return
+	}
+
+	//finding the started and withdrawn announcements
+	active := map[string]Announcement{}
+	started := []Announcement{}
+	inStore := map[string]bool{}
+	for _, a := range as {
+		inStore[a.ID] = true
+		if !a.active(now) {
+			continue
+		}
+		active[a.ID] = a
+	}
+	withdrawn := []Announcement{}
+	announcementsLock.Lock()
+	for id, a := range active {
+		if _, ok := activeAnnouncements[id]; !ok {
+			started = append(started, a)
+		}
+	}
+	for id, a := range activeAnnouncements {
+		if !inStore[id] {
+			withdrawn = append(withdrawn, a)
+		}
+	}
+	activeAnnouncements = active
+	announcementsLock.Unlock()
+	if len(started) == 0 && len(withdrawn) == 0 {
+		return
+	}
+
+	//emitting to the connections
+	appCtxReq := AppContextRequest{Type: FetchAllWs, Out: make(chan AppContextRequest)}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	conns := (<-appCtxReq.Out).UsersWsConns
+	for _, a := range started {
+		log.Info("announcement", a.ID, "started for the tenant", a.Tenant, "till", a.EndsAt)
+		emitAnnouncement(conns, a, a.notification())
+	}
+	for _, a := range withdrawn {
+		n := delivery.Notification{Lane: a.Lane}
+		n.Event = AnnouncementWithdrawnEvent
+		n.Payload = map[string]string{"id": a.ID}
+		emitAnnouncement(conns, a, n)
+	}
+}
+
+//emitAnnouncement sends the notification of the announcement to the connections of its tenant
+func emitAnnouncement(conns map[uint][]socketio.Conn, a Announcement, n delivery.Notification) {
+	for _, cs := range conns {
+		for _, conn := range cs {
+			appCtx, ok := conn.Context().(*config.AppContext)
+			if !ok || (len(a.Tenant) != 0 && appCtx.Tenant != a.Tenant) {
+				continue
+			}
+			if err := delivery.Send(conn, n); err != nil {
+				log.Error("error while sending the announcement", a.ID, "to the connection", conn.ID(), err.Error())
+			}
+		}
+	}
+}
+
+//sendAnnouncements sends the announcements in their window to the newly connected connection
+func sendAnnouncements(conn socketio.Conn, appCtx *config.AppContext) {
+	announcementsLock.RLock()
+	as := make([]Announcement, 0, len(activeAnnouncements))
+	for _, a := range activeAnnouncements {
+		if len(a.Tenant) == 0 || a.Tenant == appCtx.Tenant {
+			as = append(as, a)
+		}
+	}
+	announcementsLock.RUnlock()
+	for _, a := range as {
+		if err := delivery.Send(conn, a.notification()); err != nil {
+			appCtx.Log.Error("error while sending the announcement", a.ID, "to the connection", conn.ID(), err.Error())
+		}
+	}
+}
+
+//runAnnouncements checks the announcements periodically
+func runAnnouncements() {
+	for {
+		time.Sleep(config.AnnouncementCheck)
+		checkAnnouncements(time.Now())
+	}
+}
+
+//Announcements schedules an announcement with POST, lists the announcements with GET
+//and withdraws the announcement given in the id query param with DELETE
+func Announcements(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	id := req.URL.Query().Get("id")
+
+	switch req.Method {
+	case http.MethodPost:
+		a := &Announcement{}
+		if err := decode(req, a); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the announcement", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		now := time.Now()
+		if a.StartsAt.IsZero() {
+			a.StartsAt = now
+		}
+		if !a.EndsAt.After(a.StartsAt) || !a.EndsAt.After(now) {
+			response.WriteError(res, response.Error{Err: "Invalid Params endsAt has to be after startsAt and now"}, http.StatusBadRequest)
+			return
+		}
+		if len(a.Event) == 0 {
+			a.Event = AnnouncementEvent
+		}
+		if len(a.Lane) == 0 {
+			a.Lane = delivery.AlertLane
+		}
+		a.ID, a.CreatedBy = newID(), appCtx.Session.User.ID
+		b, err := json.Marshal(a)
+		if err == nil {
+			err = store.Default.Set(announcementPrefix+a.ID, b, time.Until(a.EndsAt))
+		}
+		if err != nil {
+			appCtx.Log.Error("error while saving the announcement", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't schedule the announcement"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: announcement", a.ID, "for the tenant", a.Tenant, "from", a.StartsAt, "till", a.EndsAt, "scheduled by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "announcement scheduled", Data: a})
+	case http.MethodGet:
+		as, err := listAnnouncements()
+		if err != nil {
+			appCtx.Log.Error("error while listing the announcements", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't list the announcements"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "announcements", Data: as})
+	case http.MethodDelete:
+		if _, err := store.Default.Get(announcementPrefix + id); err != nil {
+			response.WriteError(res, response.Error{Err: "Couldn't find the announcement " + id}, http.StatusNotFound)
+			return
+		}
+		if err := store.Default.Delete(announcementPrefix + id); err != nil {
+			appCtx.Log.Error("error while withdrawing the announcement", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't withdraw the announcement"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: announcement", id, "withdrawn by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "announcement withdrawn"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	go runAnnouncements()
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Announcements),
+		Pattern:     "/admin/announcements",
+	})
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the authentication of the service callers with the scoped api keys and their admin api.
+ * The requests with an api key are served only on the routes having a scope granted to the key.
+ */
+
+//APIKeyHeader is the header with which the service callers pass the api key
+const APIKeyHeader = "X-Cuttle-Api-Key"
+
+type apiKeyCtxKey struct {
+	key string
+}
+
+//APIKeyKey is the key with which the api key of the request is saved in the request context
+var APIKeyKey = apiKeyCtxKey{key: "api-key"}
+
+//APIKeyRequest is the request to create an api key
+type APIKeyRequest struct {
+	//Name of the key
+	Name string `json:"name"`
+	//Tenant to which the requests with the key belong
+	Tenant string `json:"tenant"`
+	//UserID is the user as whom the requests with the key are made. Defaults to the admin creating the key
+	UserID uint `json:"userId"`
+	//Scopes of the key
+	Scopes []string `json:"scopes"`
+	//TTL is the no. of seconds after which the key expires. The key doesn't expire if zero
+	TTL int64 `json:"ttl"`
+}
+
+//apiKeySession returns the session and the key of the api key of the request.
+//If the key isn't valid, the error response is written and false is returned
+func apiKeySession(res http.ResponseWriter, req *http.Request) (authConfig.Session, apikey.Key, bool) {
+	k, ok := apikey.Verify(req.Header.Get(APIKeyHeader))
+	if !ok {
+		log.Warn("invalid api key from the ip", clientIP(req))
+		response.WriteError(res, response.Error{Err: "Invalid api key"}, http.StatusUnauthorized)
+		return authConfig.Session{}, k, false
+	}
+	return authConfig.Session{ID: apikey.SessionPrefix + k.ID, Authenticated: true, User: &authModels.User{ID: k.UserID}}, k, true
+}
+
+//scoped reports whether the request was made with an api key having the scope of the route
+func scoped(ctx context.Context) bool {
+	_, ok := ctx.Value(APIKeyKey).(apikey.Key)
+	return ok
+}
+
+//APIKeys creates an api key with POST, lists the keys with GET
+//and revokes the key given in the id query param with DELETE.
+//The api key is returned only once in the response of the creation
+func APIKeys(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	switch req.Method {
+	case http.MethodPost:
+		r := &APIKeyRequest{}
+		if err := decode(req, r); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the api key request", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if len(r.Name) == 0 || len(r.Scopes) == 0 {
+			response.WriteError(res, response.Error{Err: "Invalid Params name and scopes are required"}, http.StatusBadRequest)
+			return
+		}
+		k := apikey.Key{Name: r.Name, Tenant: r.Tenant, UserID: r.UserID, ScopeList: r.Scopes, CreatedBy: appCtx.Session.User.ID}
+		if k.UserID == 0 {
+			k.UserID = appCtx.Session.User.ID
+		}
+		if r.TTL > 0 {
+			t := time.Now().Add(time.Duration(r.TTL) * time.Second)
+			k.ExpiresAt = &t
+		}
+		key, k, err := apikey.Create(k)
+		if err != nil {
+			appCtx.Log.Error("error while creating the api key", r.Name, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't create the api key"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: api key", k.ID, k.Name, "with scopes", k.Scopes, "created by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "api key created", Data: map[string]interface{}{"key": key, "apiKey": k}})
+	case http.MethodGet:
+		response.Write(res, response.Message{Message: "api keys", Data: apikey.List()})
+	case http.MethodDelete:
+		id := req.URL.Query().Get("id")
+		err := apikey.Revoke(id)
+		if err == apikey.ErrNotFound {
+			response.WriteError(res, response.Error{Err: "Couldn't find the api key " + id}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while revoking the api key", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't revoke the api key"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: api key", id, "revoked by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "api key revoked"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	if err := apikey.Init(config.DB()); err != nil {
+		log.Error("error while initing the api keys", err.Error())
+	}
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(APIKeys),
+		Pattern:     "/admin/api-keys",
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/routes"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+//callWithKey calls the route with the api key and returns the response
+func callWithKey(r routes.Route, key string) *httptest.ResponseRecorder {
+	return postWithKey(r, key, "")
+}
+
+//postWithKey posts the json body to the route with the api key and returns the response
+func postWithKey(r routes.Route, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/scoped", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(routes.APIKeyHeader, key)
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, req)
+	return res
+}
+
+func TestAPIKeyScope(t *testing.T) {
+	var served apikey.Key
+	r := routes.Route{
+		Version: "v1",
+		Pattern: "/scoped",
+		Scope:   apikey.ScopeNotifyUser,
+		HandlerFunc: func(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+			served, _ = ctx.Value(routes.APIKeyKey).(apikey.Key)
+			response.Write(res, response.Message{Message: "served"})
+		},
+	}
+	key, k, err := apikey.Create(apikey.Key{Name: "scoped", UserID: 8, Tenant: "acme", ScopeList: []string{apikey.ScopeNotifyUser}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := apikey.Create(apikey.Key{Name: "other", UserID: 9, ScopeList: []string{apikey.ScopeAdminConnections}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the key with the scope of the route is served with the verified key in the context
+	if res := callWithKey(r, key); res.Code != http.StatusOK || served.ID != k.ID {
+		t.Fatalf("expected the key with the scope to be served. got %d %s", res.Code, res.Body.String())
+	}
+
+	//the key without the scope of the route is rejected
+	if res := callWithKey(r, other); res.Code != http.StatusForbidden || !strings.Contains(res.Body.String(), response.CodeScopeNotGranted) {
+		t.Errorf("expected the key without the scope to be forbidden. got %d %s", res.Code, res.Body.String())
+	}
+
+	//the revoked key is rejected
+	if err := apikey.Revoke(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if res := callWithKey(r, key); res.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked key to be unauthorized. got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestAPIKeyTenant(t *testing.T) {
+	r := routes.Route{
+		Version:     "v1",
+		Pattern:     "/scoped",
+		Scope:       apikey.ScopeNotifyBroadcast,
+		HandlerFunc: routes.Admin(routes.SendBroadcast),
+	}
+	key, _, err := apikey.Create(apikey.Key{Name: "tenant", UserID: 10, Tenant: "acme", ScopeList: []string{apikey.ScopeNotifyBroadcast}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		tenants string
+		code    int
+	}{
+		{"own tenant", `["acme"]`, http.StatusOK},
+		{"every tenant is restricted to the own tenant", `[]`, http.StatusOK},
+		{"other tenant", `["globex"]`, http.StatusForbidden},
+		{"own and other tenant", `["acme","globex"]`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		body := `{"event":"key-tenant-test","dryRun":true,"audience":{"tenants":` + c.tenants + `}}`
+		if res := postWithKey(r, key, body); res.Code != c.code {
+			t.Errorf("%s: expected %d. got %d %s", c.name, c.code, res.Code, res.Body.String())
+		}
+	}
+}
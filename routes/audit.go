@@ -0,0 +1,132 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/audit"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the admin api of the audit log of the notifications
+ */
+
+const (
+	//DefaultAuditLimit is the no. of records in a page of the audit log
+	DefaultAuditLimit = 100
+	//MaxAuditLimit is the max no. of records in a page of the audit log
+	MaxAuditLimit = 1000
+)
+
+//AuditPage is a page of the audit log of the notifications
+type AuditPage struct {
+	//Total is the no. of records matching the query
+	Total int `json:"total"`
+	//Offset of the page
+	Offset int `json:"offset"`
+	//Limit is the max no. of records in the page
+	Limit int `json:"limit"`
+	//Records in the page
+	Records []audit.Record `json:"records"`
+}
+
+//auditQuery returns the query of the audit log from the query params of the request
+func auditQuery(req *http.Request) (audit.Query, error) {
+	/*
+	 * We will parse the sender and the target user
+	 * Then we will parse the time range in rfc3339
+	 * Then we will parse the page
+	 */
+	q := audit.Query{Tenant: req.URL.Query().Get("tenant"), Event: req.URL.Query().Get("event")}
+	for param, v := range map[string]*uint{"sender": &q.SenderID, "target": &q.TargetUserID} {
+		if s := req.URL.Query().Get(param); len(s) != 0 {
+			u, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return q, err
+			}
+			*v = uint(u)
+		}
+	}
+
+	//parsing the time range
+	for param, v := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if s := req.URL.Query().Get(param); len(s) != 0 {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, err
+			}
+			*v = t
+		}
+	}
+
+	//parsing the page
+	var err error
+	if q.Offset, err = queryInt(req, "offset", 0); err != nil {
+		return q, err
+	}
+	if q.Limit, err = queryInt(req, "limit", DefaultAuditLimit); err != nil {
+		return q, err
+	}
+	if q.Limit == 0 || q.Limit > MaxAuditLimit {
+		q.Limit = MaxAuditLimit
+	}
+	return q, nil
+}
+
+//AuditLog returns a page of the audit records of the notifications in the order of their acceptance.
+//The records are filtered with the sender, target, tenant, event, from and to query params
+//and the page is given with the offset and limit query params
+func AuditLog(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context and the query
+	 * The audit log requires the database
+	 * Then we will find the records and write the response
+	 */
+	//getting the app ctx and the query
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodGet {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := auditQuery(req)
+	if err != nil {
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//checking the database
+	if appCtx.Db == nil {
+		response.WriteError(res, response.Error{Err: "Audit log requires the database"}, http.StatusServiceUnavailable)
+		return
+	}
+
+	//finding the records
+	rs, total, err := audit.Find(appCtx.Db, q)
+	if err != nil {
+		appCtx.Log.Error("error while finding the audit records", err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't find the audit records"}, http.StatusInternalServerError)
+		return
+	}
+	log.Info("AUDIT: audit log with the sender", q.SenderID, "target", q.TargetUserID, "tenant", q.Tenant, "event", q.Event, "reviewed by admin", appCtx.Session.User.ID)
+	response.Write(res, response.Message{Message: "audit log", Data: AuditPage{Total: total, Offset: q.Offset, Limit: q.Limit, Records: rs}})
+}
+
+func init() {
+	if err := audit.Init(config.DB()); err != nil {
+		log.Error("error while initing the audit log", err.Error())
+	}
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(AuditLog),
+		Pattern:     "/admin/audit",
+	})
+}
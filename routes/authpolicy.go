@@ -0,0 +1,151 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the authentication of the websocket handshakes as per the auth policies of the namespaces.
+ * The handshake is authenticated with the first valid credential among the auth cookie, the bearer token,
+ * the auth token in the query param or the sub protocol and the ticket.
+ * Without any, the handshake is served as a guest if a namespace allows anonymous connections. The credential is recorded
+ * in the handshake headers, overwriting the ones sent by the client, and the namespaces check it on connect.
+ */
+
+//TicketParam is the query param with which the clients pass the ticket in the websocket handshake
+const TicketParam = "ticket"
+
+//TicketRequest is the request to issue a ticket
+type TicketRequest struct {
+	//Namespace for which the ticket is issued
+	Namespace string `json:"namespace"`
+}
+
+//Ticket is a ticket issued to the user to connect to a namespace
+type Ticket struct {
+	//Ticket to be passed in the ticket query param of the handshake
+	Ticket string `json:"ticket"`
+	//Namespace for which the ticket is issued
+	Namespace string `json:"namespace"`
+	//ExpiresAt is the time after which the ticket can't be used
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+//bearerToken returns the token in the bearer authorization header of the request
+func bearerToken(req *http.Request) string {
+	h := req.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+//handshakeCredential returns the session of the handshake with the auth method and the namespace of the ticket
+func handshakeCredential(req *http.Request) (authConfig.Session, string, string, bool) {
+	/*
+	 * We will check the benchmark test token and the auth cookie
+	 * Then we will check the bearer token and the auth token in the query param or the sub protocol
+	 * Then we will check the ticket
+	 */
+	//checking the benchmark test token and the cookie
+	if config.BenchmarkEnabled() {
+		token := req.Header.Get(BenchmarkTokenHeader)
+		if len(token) == 0 {
+			token = req.URL.Query().Get(BenchmarkTokenParam)
+		}
+		if uID, ok := config.VerifyBenchmarkToken(token); ok {
+			return authConfig.Session{ID: token, Authenticated: true, User: &authModels.User{ID: uID}}, config.AuthCookie, "", true
+		}
+	}
+	if cookie, err := req.Cookie(authConfig.AuthHeaderKey); err == nil {
+		if u, ok := authenticatedUser(cookie.Value); ok {
+			return authConfig.Session{ID: cookie.Value, Authenticated: true, User: &u}, config.AuthCookie, "", true
+		}
+	}
+
+	//checking the bearer token and the token passed by the browsers in the query param or the sub protocol
+	for _, token := range []string{bearerToken(req), handshakeToken(req)} {
+		if len(token) == 0 {
+			continue
+		}
+		if u, ok := authenticatedUser(token); ok {
+			return authConfig.Session{ID: token, Authenticated: true, User: &u}, config.AuthBearer, "", true
+		}
+	}
+
+	//checking the ticket
+	if ticket := req.URL.Query().Get(TicketParam); len(ticket) != 0 {
+		if uID, ns, ok := config.VerifyTicket(ticket); ok {
+			return authConfig.Session{ID: ticket, Authenticated: true, User: &authModels.User{ID: uID}}, config.AuthTicket, ns, true
+		}
+	}
+	return authConfig.Session{}, "", "", false
+}
+
+//handshakeSession authenticates the websocket handshake and records its credential in the handshake headers.
+//The handshakes without any credential are served as the guests before it
+func handshakeSession(res http.ResponseWriter, req *http.Request) (authConfig.Session, bool) {
+	sess, method, ns, ok := handshakeCredential(req)
+	if !ok {
+		log.Warn("websocket handshake without a valid credential")
+		response.WriteError(res, response.Error{Err: "Couldn't authenticate the websocket handshake"}, http.StatusForbidden)
+		return sess, false
+	}
+	req.Header.Set(config.AuthMethodHeader, method)
+	req.Header.Set(config.TicketNamespaceHeader, ns)
+	return sess, true
+}
+
+//IssueTicket issues a ticket to the user to connect to a namespace having the ticket auth policy
+func IssueTicket(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request
+	 * Then we will issue the ticket if the namespace has the ticket auth policy
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parsing the request
+	t := &TicketRequest{}
+	if err := decode(req, t); err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the ticket request", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+
+	//issuing the ticket
+	if config.AuthPolicyOf(t.Namespace) != config.AuthTicket || len(config.TicketKey) == 0 {
+		response.WriteError(res, response.Error{Err: "Invalid Params tickets are not issued for the namespace " + t.Namespace}, http.StatusBadRequest)
+		return
+	}
+	expiry := time.Now().Add(config.TicketTTL)
+	response.Write(res, response.Message{Message: "ticket issued", Data: Ticket{
+		Ticket:    config.SignTicket(appCtx.Session.User.ID, t.Namespace, expiry),
+		Namespace: t.Namespace,
+		ExpiresAt: expiry,
+	}})
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: IssueTicket,
+		Pattern:     "/tickets",
+	})
+}
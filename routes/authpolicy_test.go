@@ -0,0 +1,69 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+//withKey returns the ticket signed with the ticket key
+func withKey(key string, sign func() string) string {
+	old := config.TicketKey
+	config.TicketKey = key
+	defer func() { config.TicketKey = old }()
+	return sign()
+}
+
+//replacePart returns the ticket with its ith part replaced by the value
+func replacePart(ticket string, i int, value string) string {
+	parts := strings.Split(ticket, ".")
+	parts[i] = value
+	return strings.Join(parts, ".")
+}
+
+func TestTicketForgery(t *testing.T) {
+	config.TicketKey = "ticket-key"
+	defer func() { config.TicketKey = "" }()
+	expiry := time.Now().Add(time.Minute)
+	valid := config.SignTicket(42, "/reports", expiry)
+
+	cases := []struct {
+		name   string
+		key    string
+		ticket string
+		valid  bool
+	}{
+		{"valid", "ticket-key", valid, true},
+		{"signed with another key", "ticket-key", withKey("other-key", func() string { return config.SignTicket(42, "/reports", expiry) }), false},
+		{"expired", "ticket-key", config.SignTicket(42, "/reports", time.Now().Add(-time.Second)), false},
+		{"other user", "ticket-key", replacePart(valid, 0, "43"), false},
+		{"extended expiry", "ticket-key", replacePart(valid, 1, "99999999999"), false},
+		{"other namespace", "ticket-key", replacePart(valid, 2, base64.RawURLEncoding.EncodeToString([]byte("/admin"))), false},
+		{"truncated signature", "ticket-key", valid[:len(valid)-2], false},
+		{"zero user", "ticket-key", config.SignTicket(0, "/reports", expiry), false},
+		{"missing part", "ticket-key", valid[strings.Index(valid, ".")+1:], false},
+		{"tickets disabled", "", valid, false},
+	}
+	for _, c := range cases {
+		config.TicketKey = c.key
+		req := httptest.NewRequest(http.MethodGet, "/socket.io/?"+TicketParam+"="+url.QueryEscape(c.ticket), nil)
+		sess, method, ns, ok := handshakeCredential(req)
+		if ok != c.valid {
+			t.Errorf("%s: expected the ticket to be valid %t. got %t", c.name, c.valid, ok)
+			continue
+		}
+		if ok && (sess.User.ID != 42 || method != config.AuthTicket || ns != "/reports") {
+			t.Errorf("%s: expected the user 42 with the ticket of /reports. got %d %s %s", c.name, sess.User.ID, method, ns)
+		}
+	}
+}
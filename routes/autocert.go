@@ -0,0 +1,71 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build full && !minimal
+// +build full,!minimal
+
+package routes
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+/*
+ * This file contains the automatic management of the certificate of the service domain with ACME.
+ * The certificate is obtained on the first tls handshake and renewed before it expires. It is cached in the cache dir
+ * so that the restarts don't hit the rate limits of the ACME server.
+ * The http-01 challenges are answered by the server mux and the challenge port. The tls-alpn-01 challenges are answered
+ * by the tls listener itself.
+ */
+
+//acmeChallengePath is the path prefix of the http-01 challenges
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+//StartAutocert starts the certificate management in the autocert mode. The http-01 challenges are handled in the mux
+//and the tls config of the listeners is set to get the managed certificate
+func StartAutocert(m *http.ServeMux) error {
+	/*
+	 * We will create the certificate manager of the service domain
+	 * Then we will answer the http-01 challenges in the mux and the challenge port
+	 * Then we will set the tls config of the listeners
+	 */
+	if !config.Autocert {
+		return nil
+	}
+	mgr := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.AutocertCacheDir),
+		HostPolicy: autocert.HostWhitelist(config.ServiceDomain),
+		Email:      config.AutocertEmail,
+	}
+	if len(config.AutocertDirectoryURL) != 0 {
+		mgr.Client = &acme.Client{DirectoryURL: config.AutocertDirectoryURL}
+	}
+
+	//answering the challenges
+	m.Handle(acmeChallengePath, mgr.HTTPHandler(nil))
+	if len(config.AutocertHTTPPort) != 0 {
+		go func() {
+			log.Info("Answering the acme challenges at :" + config.AutocertHTTPPort)
+			log.Error(http.ListenAndServe(":"+config.AutocertHTTPPort, mgr.HTTPHandler(nil)))
+		}()
+	}
+
+	//setting the tls config
+	c := mgr.TLSConfig()
+	c.MinVersion = tls.VersionTLS12
+	config.SetTLSConfig(c)
+	log.Info("Managing the certificate of", config.ServiceDomain, "with acme. Certificates are cached in", config.AutocertCacheDir)
+	return nil
+}
+
+func init() {
+	config.RegisterSubsystem(config.SubsystemACME)
+}
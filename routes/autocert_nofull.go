@@ -0,0 +1,28 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !full || minimal
+// +build !full minimal
+
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the automatic certificate management of the build profiles without it.
+ * The certificate management with ACME is compiled in only by the full build profile
+ */
+
+//StartAutocert fails in the autocert mode as the certificate management is not compiled into the build
+func StartAutocert(m *http.ServeMux) error {
+	if !config.Autocert {
+		return nil
+	}
+	return errors.New("automatic certificate management is not compiled into the " + config.BuildProfile + " build. Build with -tags full")
+}
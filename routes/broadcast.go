@@ -0,0 +1,213 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the admin broadcast of notifications to an audience
+ */
+
+const (
+	//PresenceOnline targets only the users having a live connection
+	PresenceOnline = "online"
+	//PresenceAny targets the explicitly listed users even if they are offline.
+	//Offline users get the broadcast through the replay
+	PresenceAny = "any"
+)
+
+//BroadcastSampleSize is the no. of sample users returned in the broadcast preview
+const BroadcastSampleSize = 10
+
+//Audience is the filter of the users to whom a broadcast has to be sent.
+//Empty filters match everyone
+type Audience struct {
+	//Tenants to which the users must belong
+	Tenants []string `json:"tenants"`
+	//Roles which the users must have
+	Roles []string `json:"roles"`
+	//Users has the ids of the users to be targeted
+	Users []uint `json:"users"`
+	//Presence of the users to be targeted. Default is online
+	Presence string `json:"presence"`
+}
+
+//Broadcast is a broadcast composed by an admin
+type Broadcast struct {
+	delivery.Notification
+	//Audience of the broadcast
+	Audience Audience `json:"audience"`
+	//DryRun if true will only preview the audience without sending the broadcast
+	DryRun bool `json:"dryRun"`
+}
+
+//BroadcastPreview has the audience matched by a broadcast
+type BroadcastPreview struct {
+	//AudienceSize is the no. of users matched
+	AudienceSize int `json:"audienceSize"`
+	//Online is the no. of users matched who have a live connection
+	Online int `json:"online"`
+	//Sample has the ids of a few users matched
+	Sample []uint `json:"sample"`
+}
+
+//contains reports whether the list contains the string. Empty list contains everything
+func contains(l []string, s string) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+//match returns the connections of the users matching the audience mapped by the user id.
+//Offline users matching the audience will have no connections
+func (a Audience) match(users map[uint][]socketio.Conn) map[uint][]socketio.Conn {
+	/*
+	 * We will go through the online users and match their connections
+	 * If the presence is any we will add the listed users who are offline
+	 */
+	listed := map[uint]bool{}
+	for _, u := range a.Users {
+		listed[u] = true
+	}
+	result := map[uint][]socketio.Conn{}
+	for uID, conns := range users {
+		if len(listed) != 0 && !listed[uID] {
+			continue
+		}
+		for _, conn := range conns {
+			appCtx, ok := conn.Context().(*config.AppContext)
+			if !ok || !contains(a.Tenants, appCtx.Tenant) || !contains(a.Roles, appCtx.Role) {
+				continue
+			}
+			result[uID] = conns
+			break
+		}
+	}
+	if a.Presence != PresenceAny {
+		return result
+	}
+	for u := range listed {
+		if _, ok := result[u]; !ok && len(a.Tenants) == 0 && len(a.Roles) == 0 {
+			result[u] = nil
+		}
+	}
+	return result
+}
+
+//preview returns the preview of the matched audience
+func preview(matched map[uint][]socketio.Conn) BroadcastPreview {
+	p := BroadcastPreview{AudienceSize: len(matched), Sample: []uint{}}
+	ids := make([]uint, 0, len(matched))
+	for u, conns := range matched {
+		ids = append(ids, u)
+		if len(conns) != 0 {
+			p.Online++
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > BroadcastSampleSize {
+		ids = ids[:BroadcastSampleSize]
+	}
+	p.Sample = append(p.Sample, ids...)
+	return p
+}
+
+//broadcastEvent returns the event of the broadcast to its audience matched among the websocket connections
+//of all the users along with the preview of the audience
+func broadcastEvent(appCtx *config.AppContext, b *Broadcast) (*bus.Event, BroadcastPreview) {
+	appCtxReq := AppContextRequest{
+		Type: FetchAllWs,
+		Out:  make(chan AppContextRequest),
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+
+	matched := b.Audience.match(resCtx.UsersWsConns)
+	e := &bus.Event{Source: bus.SourceBroadcast, AppContext: appCtx, Notification: b.Notification, Conns: matched}
+	for uID := range matched {
+		e.Users = append(e.Users, uID)
+	}
+	return e, preview(matched)
+}
+
+//SendBroadcast sends the broadcast composed by an admin to the matching audience.
+//In the dry run mode only the preview of the audience is returned
+func SendBroadcast(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request payload and restrict the tenants to the one of the api key
+	 * We will match the audience and validate the broadcast
+	 * If it is a dry run, we will write the preview
+	 * Else we will audit log the broadcast, write the response and publish the broadcast to the bus
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parse the request payload
+	b := &Broadcast{}
+	err := decode(req, b)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the broadcast", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	var ok bool
+	if b.Audience.Tenants, ok = restrictTenants(ctx, b.Audience.Tenants); !ok {
+		appCtx.Log.Warn("api key of user", appCtx.Session.User.ID, "tried to broadcast to the tenants", b.Audience.Tenants)
+		writeTenantForbidden(res)
+		return
+	}
+
+	//matching the audience
+	e, p := broadcastEvent(appCtx, b)
+	if err := bus.Check(e); err != nil {
+		appCtx.Log.Error("error while validating the broadcast", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if b.DryRun {
+		response.Write(res, response.Message{Message: "broadcast preview", Data: p})
+		return
+	}
+
+	//audit logging the broadcast
+	log.Info("AUDIT: broadcast event", b.Event, "sent by admin", appCtx.Session.User.ID, "to", p.AudienceSize,
+		"users with tenants", b.Audience.Tenants, "roles", b.Audience.Roles, "users", b.Audience.Users, "presence", b.Audience.Presence)
+	response.Write(res, response.Message{Message: "sending broadcast", Data: p})
+
+	//publishing the broadcast
+	if err := bus.Publish(e); err != nil {
+		appCtx.Log.Error("error while publishing the broadcast event", b.Event, err.Error())
+	}
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(SendBroadcast),
+		Pattern:     "/admin/broadcast",
+		Scope:       apikey.ScopeNotifyBroadcast,
+	})
+}
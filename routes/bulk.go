@@ -0,0 +1,240 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the bulk notifications sent by the internal services to a list of users.
+ * The notification is queued and the request returns. The users are split into batches and a pool of
+ * supervised workers publishes the batches to the bus. The urgent notifications are queued and split separately
+ * and their batches are published by the workers ahead of the others.
+ * Each batch fetches the connections of its users from the registry in a single request.
+ */
+
+//BulkNotification is a notification with a shared payload sent to a list of users
+type BulkNotification struct {
+	delivery.Notification
+	//Users are the ids of the users to whom the notification is sent
+	Users []uint `json:"users"`
+}
+
+//SendBulkNotification sends the notification to the listed users. Only the internal services can send bulk notifications
+func SendBulkNotification(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Only the internal services and the api keys having the notify user scope can send bulk notifications
+	 * Then we will parse the request payload and dedupe the users
+	 * Then we will validate the event
+	 * Then we will queue the fan out of the batches of the users
+	 * Will write the response
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if !config.IsService(appCtx.Session.User.ID) && !scoped(ctx) {
+		appCtx.Log.Warn("non service user", appCtx.Session.User.ID, "tried to send bulk notification")
+		response.WriteError(res, response.Error{Err: "Only internal services can send bulk notifications"}, http.StatusForbidden)
+		return
+	}
+
+	//parse the request payload
+	b := &BulkNotification{}
+	err := decode(req, b)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the bulk notification", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	users := dedupe(b.Users)
+	if len(users) > config.BulkMaxUsers {
+		response.WriteError(res, response.Error{Err: "Invalid Params more than " + strconv.Itoa(config.BulkMaxUsers) + " users"}, http.StatusBadRequest)
+		return
+	}
+
+	//validating the event
+	e := &bus.Event{Source: bus.SourceREST, AppContext: detached(appCtx), Notification: b.Notification, Users: users}
+	if err := bus.Check(e); err != nil {
+		appCtx.Log.Error("error while validating the bulk notification", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//queuing the fan out
+	if !queueBulk(e) {
+		appCtx.Log.Warn("bulk notification queue is full. rejecting the bulk notification event", b.Event, "of service user", appCtx.Session.User.ID)
+		response.WriteRetry(res, response.Error{Err: "Too many bulk notifications are queued"}, http.StatusServiceUnavailable, time.Second)
+		return
+	}
+	appCtx.Log.Info("service user", appCtx.Session.User.ID, "queued bulk notification event", b.Event, "to", len(users), "users")
+
+	//sending response
+	response.Write(res, response.Message{Message: "queued bulk notifications", Data: map[string]int{"users": len(users)}})
+}
+
+//detached returns an app context for the event published after the request is finished.
+//The app context of the request goes back to the pool once the request is finished
+func detached(appCtx *config.AppContext) *config.AppContext {
+	d := config.NewAppContext(appCtx.Log, appCtx.ID)
+	d.Session = appCtx.Session
+	d.Tenant = appCtx.Tenant
+	d.Role = appCtx.Role
+	return d
+}
+
+//dedupe returns the users without the duplicates and the 0 ids in the order of their first occurrence
+func dedupe(users []uint) []uint {
+	seen := make(map[uint]bool, len(users))
+	result := make([]uint, 0, len(users))
+	for _, u := range users {
+		if u == 0 || seen[u] {
+			continue
+		}
+		seen[u] = true
+		result = append(result, u)
+	}
+	return result
+}
+
+//bulkJob is a bulk notification queued for the fan out
+type bulkJob struct {
+	e *bus.Event
+	//pending is the no. of batches of the job not yet published
+	pending int32
+	//sent is the no. of connections to which the notification was sent
+	sent int64
+	//done is closed once the last batch is done. It is nil if no one waits for the job
+	done chan struct{}
+}
+
+//bulkBatch is a batch of the users of a bulk notification
+type bulkBatch struct {
+	job   *bulkJob
+	users []uint
+}
+
+var (
+	//bulkJobs has the bulk notifications queued for the fan out
+	bulkJobs = make(chan *bulkJob, config.BulkQueueSize)
+	//urgentBulkJobs has the urgent bulk notifications queued for the fan out
+	urgentBulkJobs = make(chan *bulkJob, config.BulkQueueSize)
+	//bulkBatches has the batches of the users being fanned out by the workers
+	bulkBatches = make(chan bulkBatch)
+	//urgentBulkBatches has the batches of the users of the urgent notifications. The workers publish them first
+	urgentBulkBatches = make(chan bulkBatch)
+)
+
+//bulkQueue returns the queue of the bulk notifications for the event as per its priority
+func bulkQueue(e *bus.Event) chan *bulkJob {
+	if e.Notification.Priority.Urgent() {
+		return urgentBulkJobs
+	}
+	return bulkJobs
+}
+
+//newBulkJob returns the job fanning out the event in the batches
+func newBulkJob(e *bus.Event) *bulkJob {
+	return &bulkJob{e: e, pending: int32((len(e.Users) + config.BulkBatchSize - 1) / config.BulkBatchSize)}
+}
+
+//queueBulk queues the event for the fan out without blocking. It reports false if the queue is full
+func queueBulk(e *bus.Event) bool {
+	select {
+	case bulkQueue(e) <- newBulkJob(e):
+		return true
+	default:
+		return false
+	}
+}
+
+//fanOutWait queues the event for the fan out and waits till its batches are published.
+//It returns the no. of connections to which the notification was sent
+func fanOutWait(e *bus.Event) int {
+	j := newBulkJob(e)
+	j.done = make(chan struct{})
+	bulkQueue(e) <- j
+	<-j.done
+	return int(atomic.LoadInt64(&j.sent))
+}
+
+//splitBulk returns the worker splitting the queued bulk notifications into the batches of the users for the workers
+func splitBulk(jobs <-chan *bulkJob, batches chan<- bulkBatch) func() {
+	return func() {
+		for j := range jobs {
+			for i := 0; i < len(j.e.Users); i += config.BulkBatchSize {
+				end := i + config.BulkBatchSize
+				if end > len(j.e.Users) {
+					end = len(j.e.Users)
+				}
+				batches <- bulkBatch{job: j, users: j.e.Users[i:end]}
+			}
+		}
+	}
+}
+
+//fanOut publishes the batches of the users to the bus with the batches of the urgent notifications first.
+//A batch is counted as done even if its publish panics so that the job is finished
+func fanOut() {
+	for {
+		select {
+		case b := <-urgentBulkBatches:
+			publishBatch(b)
+			continue
+		default:
+		}
+		select {
+		case b := <-urgentBulkBatches:
+			publishBatch(b)
+		case b := <-bulkBatches:
+			publishBatch(b)
+		}
+	}
+}
+
+//publishBatch publishes the batch of the users to the bus and logs the bulk notification once its last batch is done
+func publishBatch(b bulkBatch) {
+	e := b.job.e
+	defer func() {
+		if atomic.AddInt32(&b.job.pending, -1) != 0 {
+			return
+		}
+		e.AppContext.Log.Info("sent bulk notification event", e.Notification.Event, "to", atomic.LoadInt64(&b.job.sent), "connections")
+		if b.job.done != nil {
+			close(b.job.done)
+		}
+	}()
+	be := &bus.Event{Source: e.Source, AppContext: e.AppContext, Notification: e.Notification, Users: b.users}
+	if err := bus.Publish(be); err != nil {
+		e.AppContext.Log.Error("error while publishing the batch of", len(b.users), "users of the bulk notification", err.Error())
+	}
+	atomic.AddInt64(&b.job.sent, int64(be.Sent))
+}
+
+func init() {
+	config.SuperviseWorkers("bulk-split", 1, splitBulk(bulkJobs, bulkBatches))
+	config.SuperviseWorkers("bulk-split", 1, splitBulk(urgentBulkJobs, urgentBulkBatches))
+	config.SuperviseWorkers("bulk-fanout", config.BulkWorkers, fanOut)
+	AddRoutes(Route{
+		Version:      "v1",
+		HandlerFunc:  SendBulkNotification,
+		Pattern:      "/notification/send/bulk",
+		Authenticate: signedSession,
+		Internal:     true,
+		Scope:        apikey.ScopeNotifyUser,
+	})
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes"
+)
+
+//bulkServiceID is the id of the service user sending the bulk notifications in the tests
+const bulkServiceID = 4242
+
+var (
+	//bulkRouted has the batches of the users routed for each bulk test event
+	bulkRouted = map[string][][]uint{}
+	//bulkRoutedLock is the lock for the bulkRouted
+	bulkRoutedLock sync.Mutex
+	//bulkRelease blocks the batches of the bulk test events till it is closed
+	bulkRelease = make(chan struct{})
+)
+
+func init() {
+	config.ServiceUserIDs[bulkServiceID] = true
+	bus.Use(bus.Route, func(e *bus.Event) error {
+		if !strings.HasPrefix(e.Notification.Event, "bulk-test") {
+			return nil
+		}
+		<-bulkRelease
+		if e.Notification.Event == "bulk-test-panic" {
+			panic("bulk test panic")
+		}
+		bulkRoutedLock.Lock()
+		defer bulkRoutedLock.Unlock()
+		bulkRouted[e.Notification.Event] = append(bulkRouted[e.Notification.Event], e.Users)
+		return nil
+	})
+}
+
+//sendBulk sends the bulk notification request as the service user and returns the status code
+func sendBulk(t *testing.T, body string) int {
+	ctx := config.NewAppContext(log.NewLogger(0), 0)
+	ctx.Session = authConfig.Session{User: &authModels.User{ID: bulkServiceID}}
+	req := httptest.NewRequest(http.MethodPost, "/notification/send/bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	routes.SendBulkNotification(context.WithValue(context.Background(), routes.AppContextKey, ctx), res, req)
+	return res.Code
+}
+
+//routedBulk waits till the users of the event are routed and returns the batches
+func routedBulk(t *testing.T, event string, users int) [][]uint {
+	for i := 0; i < 200; i++ {
+		bulkRoutedLock.Lock()
+		batches := bulkRouted[event]
+		n := 0
+		for _, b := range batches {
+			n += len(b)
+		}
+		bulkRoutedLock.Unlock()
+		if n >= users {
+			return batches
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for the %d users of %s to be routed", users, event)
+	return nil
+}
+
+func TestBulkFanOut(t *testing.T) {
+	//the handler returns before the batches are published
+	config.BulkBatchSize = 2
+	if code := sendBulk(t, `{"event":"bulk-test","users":[1,2,2,0,3,4,5]}`); code != http.StatusOK {
+		t.Fatalf("expected the bulk notification to be queued. got %d", code)
+	}
+	bulkRoutedLock.Lock()
+	routed := len(bulkRouted["bulk-test"])
+	bulkRoutedLock.Unlock()
+	if routed != 0 {
+		t.Fatal("expected the handler not to wait for the fan out")
+	}
+
+	//a panicking batch restarts its worker and the pool keeps fanning out
+	if code := sendBulk(t, `{"event":"bulk-test-panic","users":[1]}`); code != http.StatusOK {
+		t.Fatalf("expected the bulk notification to be queued. got %d", code)
+	}
+	if code := sendBulk(t, `{"event":"bulk-test-after-panic","users":[6,7]}`); code != http.StatusOK {
+		t.Fatalf("expected the bulk notification to be queued. got %d", code)
+	}
+	close(bulkRelease)
+
+	//the deduped users are published in the batches
+	seen := map[uint]bool{}
+	for _, b := range routedBulk(t, "bulk-test", 5) {
+		if len(b) > 2 {
+			t.Errorf("expected the batches of at most 2 users. got %v", b)
+		}
+		for _, u := range b {
+			if u == 0 || seen[u] {
+				t.Errorf("expected the users to be deduped. got %d again", u)
+			}
+			seen[u] = true
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("expected 5 users to be routed. got %d", len(seen))
+	}
+	routedBulk(t, "bulk-test-after-panic", 2)
+
+	for i := 0; i < 200; i++ {
+		for _, w := range config.Workers() {
+			if w.Name == "bulk-fanout" && w.Restarts == 1 && w.Running == w.Size {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected the panicked bulk worker to be restarted. got %+v", config.Workers())
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the closing of the websocket connections with a reason from the close reason catalog.
+ * The drain hooks of the event handlers get to flush the state of the connections before they are closed
+ */
+
+//CloseConnections runs the drain hooks of all the websocket connections of the instance,
+//then sends the reason to them and closes them. It returns the no. of connections closed
+func CloseConnections(reason config.CloseReason) int {
+	return closeConnections(reason, func(conn socketio.Conn) bool { return true })
+}
+
+//closeConnections drains and closes the websocket connections of the instance matching the filter with the reason.
+//It returns the no. of connections closed
+func closeConnections(reason config.CloseReason, match func(conn socketio.Conn) bool) int {
+	/*
+	 * We will get the websocket connections of all the users matching the filter
+	 * Then we will drain them
+	 * Then we will disconnect them with the reason having a jittered reconnect hint
+	 */
+	appCtxReq := AppContextRequest{
+		Type: FetchAllWs,
+		Out:  make(chan AppContextRequest),
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+	all := []socketio.Conn{}
+	for _, conns := range resCtx.UsersWsConns {
+		for _, conn := range conns {
+			if match(conn) {
+				all = append(all, conn)
+			}
+		}
+	}
+
+	//draining the connections
+	if !config.Drain(all, reason) {
+		log.Warn("drain hooks of the websocket connections didn't finish within", config.DrainTimeout, ". Closing them anyway")
+	}
+
+	//disconnecting the connections. The reconnect hints are jittered so that the clients don't reconnect at once
+	for _, conn := range all {
+		r := reason
+		if r.Action == config.ActionReconnect {
+			r = r.WithJitter(config.ReconnectSpread)
+		}
+		config.Disconnect(conn, r)
+	}
+	log.Info("closing", len(all), "websocket connections with the reason", reason.Reason)
+	return len(all)
+}
+
+//DrainConnections drains and closes all the websocket connections of the instance with the draining reason.
+//The requests with an api key drain only the connections of its tenant. The clients reconnect after the retry duration of the reason
+func DrainConnections(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodPost {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	closed := closeConnections(config.CloseServerDraining.WithMessage("server is draining the connections"), func(conn socketio.Conn) bool {
+		tenant := ""
+		if cCtx, ok := conn.Context().(*config.AppContext); ok {
+			tenant = cCtx.Tenant
+		}
+		return inTenant(ctx, tenant)
+	})
+	log.Info("AUDIT:", closed, "websocket connections drained by admin", appCtx.Session.User.ID)
+	response.Write(res, response.Message{Message: "connections drained", Data: map[string]int{"closed": closed}})
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(DrainConnections),
+		Pattern:     "/admin/drain",
+		Scope:       apikey.ScopeAdminConnections,
+	})
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the compliance sampling of the delivered notifications. A percentage of the notifications
+ * delivered to each user of the opted in tenants is recorded with the full payload for the compliance review.
+ * The opt in is of the tenant of the recipient, so a publisher can't get the notifications of the other tenants sampled.
+ * The snapshots are encrypted with aes-gcm before they are stored and are purged by the store after the retention.
+ * The admins review the snapshots of a tenant page by page with the admin api. Every review is audited.
+ * Every instance samples the notifications delivered to its own connections, so the users connected to
+ * the other instances of the cluster are sampled by those instances. The tenant of a connection is the one
+ * verified at the handshake.
+ */
+
+const (
+	//compliancePrefix is the store key prefix of the compliance snapshots
+	compliancePrefix = "compliance/"
+	//DefaultComplianceLimit is the no. of snapshots in a page of the compliance review
+	DefaultComplianceLimit = 50
+	//MaxComplianceLimit is the max no. of snapshots in a page of the compliance review
+	MaxComplianceLimit = 500
+)
+
+//ComplianceSnapshot is the snapshot of a notification delivered to a user recorded for the compliance review
+type ComplianceSnapshot struct {
+	//Tenant of the user
+	Tenant string `json:"tenant"`
+	//UserID to whom the notification was delivered. 0 for the room notifications
+	UserID uint `json:"userId"`
+	//Room to which the notification was delivered
+	Room string `json:"room,omitempty"`
+	//Source is the ingestion path of the notification
+	Source string `json:"source"`
+	//Event of the notification
+	Event string `json:"event"`
+	//ID of the notification given by the producer
+	ID string `json:"id,omitempty"`
+	//Payload of the notification
+	Payload interface{} `json:"payload"`
+	//DeliveredAt is the time at which the notification was delivered
+	DeliveredAt time.Time `json:"deliveredAt"`
+}
+
+//CompliancePage is a page of the compliance snapshots of a tenant
+type CompliancePage struct {
+	//Snapshots in the page in the order of the delivery
+	Snapshots []ComplianceSnapshot `json:"snapshots"`
+	//Next is the cursor of the next page. Empty if there are no more snapshots
+	Next string `json:"next,omitempty"`
+}
+
+//recipientTenant returns the tenant of the user of the connections. Empty if there are no connections
+func recipientTenant(conns []socketio.Conn) string {
+	for _, c := range conns {
+		if appCtx, ok := c.Context().(*config.AppContext); ok {
+			return appCtx.Tenant
+		}
+	}
+	return ""
+}
+
+//sampleCompliance records the snapshots of the delivered event for the sampled users of the opted in tenants
+func sampleCompliance(e *bus.Event, err error) {
+	/*
+	 * We will skip the failed and undelivered events
+	 * Then we will sample the users to whom the event was delivered, if their tenant has opted in
+	 * Then we will encrypt and store the snapshots of the sampled users
+	 */
+	//skipping the events not to be sampled
+	if config.ComplianceSampleRate == 0 || err != nil || e.Sent == 0 || e.AppContext == nil {
+		return
+	}
+
+	//sampling the users
+	now := time.Now()
+	for u, conns := range e.Conns {
+		tenant := recipientTenant(conns)
+		if len(tenant) == 0 || !config.ComplianceOptedIn(tenant) || mrand.Float64()*100 >= config.ComplianceSampleRate {
+			continue
+		}
+		s := ComplianceSnapshot{
+			Tenant:      tenant,
+			UserID:      u,
+			Room:        e.Notification.Room,
+			Source:      e.Source,
+			Event:       e.Notification.Event,
+			ID:          e.Notification.ID,
+			Payload:     e.Notification.Payload,
+			DeliveredAt: now,
+		}
+
+		//storing the encrypted snapshot
+		b, err := json.Marshal(s)
+		if err == nil {
+			b, err = sealCompliance(b)
+		}
+		if err == nil {
+			key := compliancePrefix + s.Tenant + "/" + strconv.FormatInt(now.UnixNano(), 10) + "-" + newID()
+			err = store.Default.Set(key, b, config.ComplianceRetention)
+		}
+		if err != nil {
+			e.AppContext.Log.Error("error while recording the compliance snapshot of the event", s.Event, "of user", u, err.Error())
+		}
+	}
+}
+
+//sealCompliance encrypts the snapshot with the compliance key. The nonce is prefixed to the cipher text
+func sealCompliance(b []byte) ([]byte, error) {
+	gcm, err := complianceCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, b, nil), nil
+}
+
+//openCompliance decrypts the snapshot sealed with the compliance key
+func openCompliance(b []byte) ([]byte, error) {
+	gcm, err := complianceCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < gcm.NonceSize() {
+		return nil, errors.New("compliance snapshot is too short")
+	}
+	return gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+}
+
+//complianceCipher returns the aes-gcm cipher of the compliance key
+func complianceCipher() (cipher.AEAD, error) {
+	c, err := aes.NewCipher(config.ComplianceKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+//ComplianceSnapshots returns a page of the compliance snapshots of the tenant given in the tenant query param
+//in the order of the delivery. The user query param filters the snapshots of a user. The page is given with the limit
+//query param and the later pages with the cursor query param set to the next cursor of the previous page
+func ComplianceSnapshots(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context and the params
+	 * Then we will load the snapshots of the tenant after the cursor
+	 * Then we will decrypt the snapshots of the page
+	 * Then we will write the response
+	 */
+	//getting the app ctx and the params
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	tenant := req.URL.Query().Get("tenant")
+	if len(tenant) == 0 {
+		response.WriteError(res, response.Error{Err: "Invalid Params tenant is missing"}, http.StatusBadRequest)
+		return
+	}
+	var user uint64
+	if u := req.URL.Query().Get("user"); len(u) != 0 {
+		var err error
+		if user, err = strconv.ParseUint(u, 10, 64); err != nil {
+			response.WriteError(res, response.Error{Err: "Invalid Params user " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	limit, err := queryInt(req, "limit", DefaultComplianceLimit)
+	if err != nil {
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if limit == 0 || limit > MaxComplianceLimit {
+		limit = MaxComplianceLimit
+	}
+	cursor := req.URL.Query().Get("cursor")
+
+	//loading and decrypting the page
+	/*
+	 * The snapshots are read with the key range of the store after the cursor, so a page doesn't read
+	 * the whole tenant. The user filter can skip the snapshots, so the ranges are read till the page is full
+	 */
+	prefix := compliancePrefix + tenant + "/"
+	after := ""
+	if len(cursor) != 0 {
+		after = prefix + cursor
+	}
+	p := CompliancePage{Snapshots: []ComplianceSnapshot{}}
+	for len(p.Snapshots) < limit {
+		kvs, err := store.Default.Range(prefix, after, limit)
+		if err != nil {
+			appCtx.Log.Error("error while loading the compliance snapshots of the tenant", tenant, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't load the compliance snapshots"}, http.StatusInternalServerError)
+			return
+		}
+		for _, kv := range kvs {
+			if len(p.Snapshots) == limit {
+				break
+			}
+			after = kv.Key
+			p.Next = kv.Key[len(prefix):]
+			b, err := openCompliance(kv.Value)
+			s := ComplianceSnapshot{}
+			if err == nil {
+				err = json.Unmarshal(b, &s)
+			}
+			if err != nil {
+				appCtx.Log.Error("error while decrypting the compliance snapshot", kv.Key, err.Error())
+				continue
+			}
+			if user != 0 && uint64(s.UserID) != user {
+				continue
+			}
+			p.Snapshots = append(p.Snapshots, s)
+		}
+		if len(kvs) < limit {
+			break
+		}
+	}
+	if len(p.Snapshots) < limit {
+		p.Next = ""
+	}
+
+	//writing the response
+	log.Info("AUDIT: compliance snapshots of the tenant", tenant, "user", user, "after", cursor, "reviewed by admin", appCtx.Session.User.ID)
+	response.Write(res, response.Message{Message: "compliance snapshots", Data: p})
+}
+
+func init() {
+	bus.OnFinish(sampleCompliance)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(ComplianceSnapshots),
+		Pattern:     "/admin/compliance",
+	})
+}
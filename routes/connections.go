@@ -0,0 +1,178 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the listing of the websocket connections of the instance and its admin api
+ */
+
+const (
+	//DefaultConnectionsLimit is the no. of connections in a page of the connections listing
+	DefaultConnectionsLimit = 100
+	//MaxConnectionsLimit is the max no. of connections in a page of the connections listing
+	MaxConnectionsLimit = 1000
+)
+
+//ConnectionInfo is the information of a websocket connection of the instance
+type ConnectionInfo struct {
+	//ID of the connection
+	ID string `json:"id"`
+	//UserID is the id of the user of the connection
+	UserID uint `json:"userId"`
+	//Namespace of the connection
+	Namespace string `json:"namespace"`
+	//RemoteAddr is the remote address of the connection
+	RemoteAddr string `json:"remoteAddr"`
+	//DeviceID is the id of the client device
+	DeviceID string `json:"deviceId,omitempty"`
+	//Tenant of the user
+	Tenant string `json:"tenant,omitempty"`
+	//Role of the user in the tenant
+	Role string `json:"role,omitempty"`
+	//Rooms joined by the connection
+	Rooms []string `json:"rooms"`
+	//ConnectedAt is the time at which the connection was connected
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+//ConnectionsPage is a page of the websocket connections of the instance
+type ConnectionsPage struct {
+	//Total is the no. of connections matching the filter
+	Total int `json:"total"`
+	//Offset of the page
+	Offset int `json:"offset"`
+	//Limit is the max no. of connections in the page
+	Limit int `json:"limit"`
+	//Connections in the page
+	Connections []ConnectionInfo `json:"connections"`
+}
+
+//listConnections returns the websocket connections of the instance sorted by the user and the connection id.
+//If the user id is not 0, only the connections of the user are returned
+func listConnections(userID uint) []ConnectionInfo {
+	/*
+	 * We will get the websocket connections of all the users
+	 * Then we will describe the connections of the user or all of them
+	 */
+	appCtxReq := AppContextRequest{
+		Type: FetchAllWs,
+		Out:  make(chan AppContextRequest),
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+
+	result := []ConnectionInfo{}
+	for uID, conns := range resCtx.UsersWsConns {
+		if userID != 0 && uID != userID {
+			continue
+		}
+		for _, conn := range conns {
+			c := ConnectionInfo{ID: conn.ID(), UserID: uID, Namespace: conn.Namespace(), Rooms: delivery.RoomsOf(conn)}
+			if addr := conn.RemoteAddr(); addr != nil {
+				c.RemoteAddr = addr.String()
+			}
+			if appCtx, ok := conn.Context().(*config.AppContext); ok {
+				c.DeviceID, c.Tenant, c.Role, c.ConnectedAt = appCtx.DeviceID, appCtx.Tenant, appCtx.Role, appCtx.ConnectedAt
+			}
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].UserID != result[j].UserID {
+			return result[i].UserID < result[j].UserID
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+//queryInt returns the non negative integer query param of the request or the default value if it is not given
+func queryInt(req *http.Request, param string, def int) (int, error) {
+	v := req.URL.Query().Get(param)
+	if len(v) == 0 {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err == nil && i < 0 {
+		err = strconv.ErrRange
+	}
+	return i, err
+}
+
+//Connections returns a page of the websocket connections of the instance sorted by the user and the connection id.
+//The page is given with the offset and limit query params and the user query param filters the connections of a user
+func Connections(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context and the params
+	 * Then we will list the connections
+	 * Then we will write the page of the connections
+	 */
+	//getting the app ctx and the params
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodGet {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	var user uint64
+	if u := req.URL.Query().Get("user"); len(u) != 0 {
+		var err error
+		if user, err = strconv.ParseUint(u, 10, 64); err != nil {
+			response.WriteError(res, response.Error{Err: "Invalid Params user " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+	}
+	offset, err := queryInt(req, "offset", 0)
+	if err != nil {
+		response.WriteError(res, response.Error{Err: "Invalid Params offset " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(req, "limit", DefaultConnectionsLimit)
+	if err != nil || limit == 0 || limit > MaxConnectionsLimit {
+		response.WriteError(res, response.Error{Err: "Invalid Params limit should be between 1 and " + strconv.Itoa(MaxConnectionsLimit)}, http.StatusBadRequest)
+		return
+	}
+
+	//listing the connections. The requests with an api key list only the connections of its tenant
+	conns := []ConnectionInfo{}
+	for _, c := range listConnections(uint(user)) {
+		if inTenant(ctx, c.Tenant) {
+			conns = append(conns, c)
+		}
+	}
+	p := ConnectionsPage{Total: len(conns), Offset: offset, Limit: limit, Connections: []ConnectionInfo{}}
+	if offset < len(conns) {
+		end := offset + limit
+		if end > len(conns) {
+			end = len(conns)
+		}
+		p.Connections = conns[offset:end]
+	}
+
+	//writing the response
+	appCtx.Log.Info("listed", len(p.Connections), "of", p.Total, "connections of user", user, "for", appCtx.Session.User.ID)
+	response.Write(res, response.Message{Message: "connections", Data: p})
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Connections),
+		Pattern:     "/admin/connections",
+		Scope:       apikey.ScopeAdminConnections,
+	})
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the self service debugging of the clients. A client emits the debug:recent event and gets
+ * back the metadata of the events recently emitted to its connection with the same event. The reply is emitted
+ * directly to the connection, so it isn't kept in the recent events itself.
+ */
+
+//DebugRecentEvent is the event with which the clients ask for and get the recently emitted events
+const DebugRecentEvent = "debug:recent"
+
+//onDebugRecent emits the metadata of the events recently emitted to the connection
+func onDebugRecent(conn socketio.Conn) {
+	appCtx := conn.Context().(*config.AppContext)
+	es, err := delivery.Recent(conn)
+	if err != nil {
+		appCtx.Log.Error("error while getting the recent events of the connection", conn.ID(), err.Error())
+		return
+	}
+	conn.Emit(DebugRecentEvent, es)
+}
+
+func init() {
+	config.RegisterWebsocketEvents(config.Namespace, DebugRecentEvent, onDebugRecent)
+}
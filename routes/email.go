@@ -0,0 +1,28 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/email"
+)
+
+/*
+ * This file contains the email fallback of the notifications.
+ * The notifications flagged with the email fallback are emailed to the user when their realtime delivery fails,
+ * ie. the user has no live connection or no connection of the user acknowledged it. Such notifications are always sent in the ack mode
+ */
+
+//fallback emails the notification to the user if it is flagged with the email fallback
+func fallback(userID uint, n delivery.Notification) {
+	if n.Fallback != delivery.FallbackEmail {
+		return
+	}
+	email.Notify(userID, n)
+}
+
+func init() {
+	delivery.OnUndelivered(fallback)
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/store"
+	"github.com/cuttle-ai/websockets/tracing"
+	"github.com/cuttle-ai/websockets/webhook"
+)
+
+/*
+ * This file contains the escalation of the unacknowledged notifications as per the policy of their category.
+ * The pending escalations are kept in the store so that they survive the restarts and are run by the leader instance.
+ * If the notification isn't acknowledged by any device of the user when a step is due, the user is re-notified
+ * with the urgency of the step along with the secondary users and the fallback webhook of the step.
+ */
+
+//escalationPrefix is the store key prefix of the pending escalations
+const escalationPrefix = "escalation/"
+
+//Escalation is a pending escalation of a notification
+type Escalation struct {
+	//UserID is the id of the user to whom the notification was sent
+	UserID uint `json:"userId"`
+	//Seq is the sequence no. of the original notification
+	Seq uint64 `json:"seq"`
+	//Notification is the original notification
+	Notification delivery.Notification `json:"notification"`
+	//Step is the index of the next step of the policy
+	Step int `json:"step"`
+	//SentAt is the time at which the original notification was sent
+	SentAt time.Time `json:"sentAt"`
+}
+
+//key returns the store key of the escalation
+func (e Escalation) key() string {
+	return escalationPrefix + strconv.FormatUint(uint64(e.UserID), 10) + "/" + strconv.FormatUint(e.Seq, 10)
+}
+
+//escalate keeps the escalation of the notification sent to the user if its category has an escalation policy
+func escalate(userID uint, n delivery.Notification) {
+	p, ok := config.EscalationPolicies[n.Category]
+	if !ok || len(p.Steps) == 0 || n.Escalation != 0 || n.Seq == 0 {
+		return
+	}
+	saveEscalation(Escalation{UserID: userID, Seq: n.Seq, Notification: n, SentAt: time.Now()})
+}
+
+//saveEscalation saves the pending escalation in the store
+func saveEscalation(e Escalation) {
+	b, err := json.Marshal(e)
+	if err == nil {
+		err = store.Default.Set(e.key(), b, config.ReplayRetention)
+	}
+	if err != nil {
+		log.Error("error while saving the escalation of the notification", e.Seq, "of user", e.UserID, err.Error())
+	}
+}
+
+//runEscalations periodically runs the due escalations till done is closed. It runs only on the leader instance
+func runEscalations(done <-chan struct{}) {
+	/*
+	 * We will get the pending escalations from the store
+	 * Then we will run the due ones
+	 */
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	t := time.NewTicker(config.EscalationCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		pending, err := store.Default.Scan(escalationPrefix)
+		if err != nil {
+			log.Error("error while getting the pending escalations", err.Error())
+			continue
+		}
+		for _, b := range pending {
+			e := Escalation{}
+			if err := json.Unmarshal(b, &e); err != nil {
+				log.Error("error while decoding the pending escalation", err.Error())
+				continue
+			}
+			runEscalation(appCtx, e)
+		}
+	}
+}
+
+//runEscalation runs the next step of the escalation if it is due and the notification isn't acknowledged yet
+func runEscalation(appCtx *config.AppContext, e Escalation) {
+	/*
+	 * We will drop the escalation if the policy no more has the step
+	 * We will skip the escalation if the step is not due
+	 * We will drop the escalation if the notification is acknowledged
+	 * Then we will re-notify the user and the secondary users with the urgency of the step
+	 * Then we will post the escalation to the fallback webhook of the step
+	 * Then we will save the escalation with the next step if any
+	 */
+	p := config.EscalationPolicies[e.Notification.Category]
+	if e.Step >= len(p.Steps) {
+		store.Default.Delete(e.key())
+		return
+	}
+	step := p.Steps[e.Step]
+	if time.Since(e.SentAt) < step.Delay() {
+		return
+	}
+	acked, err := delivery.Acked(appCtx.Db, e.UserID, e.Seq)
+	if err != nil {
+		log.Error("error while checking the acknowledgement of the notification", e.Seq, "of user", e.UserID, err.Error())
+		return
+	}
+	if acked {
+		store.Default.Delete(e.key())
+		return
+	}
+
+	//re-notifying the users
+	log.Info("escalating the notification", e.Seq, "of user", e.UserID, "to level", e.Step+1, "with urgency", step.Urgency)
+	n := e.Notification
+	n.Escalation = e.Step + 1
+	n.Urgency = step.Urgency
+	n.Lane = delivery.AlertLane
+	be := &bus.Event{Source: bus.SourceEscalation, AppContext: appCtx, Notification: n, Users: append([]uint{e.UserID}, step.Users...)}
+	if err := bus.Publish(be); err != nil {
+		log.Error("error while publishing the escalation of the notification", e.Seq, "of user", e.UserID, err.Error())
+	}
+
+	//posting to the fallback webhook
+	if len(step.Webhook) != 0 {
+		if err := postEscalation(step.Webhook, e); err != nil {
+			log.Error("error while posting the escalation of the notification", e.Seq, "of user", e.UserID, "to", step.Webhook, err.Error())
+		}
+	}
+
+	//saving the next step
+	e.Step++
+	if e.Step >= len(p.Steps) {
+		store.Default.Delete(e.key())
+		return
+	}
+	saveEscalation(e)
+}
+
+//escalationClient is the http client used to post the escalations to the fallback webhooks
+var escalationClient = &http.Client{Timeout: 10 * time.Second}
+
+//postEscalation posts the escalation to the fallback webhook as a signed webhook.
+//The call is traced as a child of the trace context of the notification
+func postEscalation(url string, e Escalation) (err error) {
+	var span *tracing.Span
+	if c, ok := tracing.Parse(e.Notification.Traceparent); ok {
+		span = tracing.Start(c, "escalation.post", tracing.KindClient).Set("http.url", url).Set("escalation.step", e.Step)
+		defer func() { span.End(err) }()
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	id := "escalation_" + strconv.FormatUint(uint64(e.UserID), 10) + "_" + strconv.FormatUint(e.Seq, 10) + "_" + strconv.Itoa(e.Step+1)
+	h, err := webhook.Sign(webhook.DefaultTenant, id, time.Now(), body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = h
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(req.Header, span.Context())
+	res, err := escalationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.New("fallback webhook responded with " + res.Status)
+	}
+	return nil
+}
+
+func init() {
+	if len(config.EscalationPolicies) == 0 {
+		return
+	}
+	leader.Run("escalation", config.NewAppContext(log.NewLogger(0), 0).Db, runEscalations)
+}
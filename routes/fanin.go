@@ -0,0 +1,144 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the fan-in of the notifications of a logical key arriving from multiple producers.
+ * Eg. the same job status update published over http by one service and pushed by an ingest source.
+ * The latest accepted update of each key is remembered in the store for the fan-in window.
+ * An update is delivered only if its logical clock is later than the remembered one, or the same with
+ * a higher source priority. The others are dropped as superseded, so the users don't get contradictory updates.
+ * The updates without a clock are clocked with the time of acceptance. The keys are scoped by the tenant of the publisher.
+ * The check and the update of the remembered clock are serialized within the instance only. The instance remembers
+ * the updates it accepted in memory too, so the store is read and written outside the lock.
+ */
+
+//faninPrefix is the store key prefix of the latest updates of the keys
+const faninPrefix = "fanin/"
+
+//faninUpdate is the latest accepted update of a key
+type faninUpdate struct {
+	//Clock of the update
+	Clock uint64 `json:"clock"`
+	//Source of the update
+	Source string `json:"source"`
+	//Priority of the source
+	Priority int `json:"priority"`
+}
+
+//supersedes reports whether the update wins over the other one
+func (f faninUpdate) supersedes(o faninUpdate) bool {
+	return f.Clock > o.Clock || (f.Clock == o.Clock && f.Priority > o.Priority)
+}
+
+//faninAccepted is an update accepted by the instance
+type faninAccepted struct {
+	update faninUpdate
+	//expiresAt is the end of the fan-in window of the update
+	expiresAt time.Time
+}
+
+var (
+	//faninAccepts has the latest updates accepted by the instance mapped by the store key
+	faninAccepts = map[string]faninAccepted{}
+	//faninLock serializes the resolution of the updates within the instance. The store isn't called under it
+	faninLock sync.Mutex
+)
+
+//faninKey returns the store key of the latest update of the key of the event scoped by the tenant of the publisher
+func faninKey(e *bus.Event) string {
+	tenant := ""
+	if e.AppContext != nil {
+		tenant = e.AppContext.Tenant
+	}
+	return faninPrefix + tenant + "/" + e.Notification.Event + "/" + e.Notification.Key
+}
+
+//acceptUpdate accepts the update of the key if it supersedes the latest one in the store and the one accepted by the instance.
+//Else the latest one is returned
+func acceptUpdate(k string, u, stored faninUpdate, found bool, now time.Time) (faninUpdate, bool) {
+	faninLock.Lock()
+	defer faninLock.Unlock()
+	if found && !u.supersedes(stored) {
+		return stored, false
+	}
+	if a, ok := faninAccepts[k]; ok && now.Before(a.expiresAt) && !u.supersedes(a.update) {
+		return a.update, false
+	}
+	if len(faninAccepts) >= config.FanInMaxKeys {
+		for key, a := range faninAccepts {
+			if !now.Before(a.expiresAt) {
+				delete(faninAccepts, key)
+			}
+		}
+	}
+	faninAccepts[k] = faninAccepted{update: u, expiresAt: now.Add(config.FanInWindow)}
+	return u, true
+}
+
+//faninEvent drops the update of a key if a later or a higher priority update of the key was already accepted.
+//The urgent notifications are never dropped
+func faninEvent(e *bus.Event) error {
+	/*
+	 * We will skip the events without a key
+	 * Then we will get the latest accepted update from the store
+	 * Then we will compare the update with it and the one accepted by the instance
+	 * Then we will remember the update if it wins
+	 */
+	if len(e.Notification.Key) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if e.Notification.Clock == 0 {
+		e.Notification.Clock = uint64(now.UnixNano())
+	}
+	u := faninUpdate{Clock: e.Notification.Clock, Source: e.Source, Priority: config.SourcePriority(e.Source)}
+	k := faninKey(e)
+
+	//getting the latest update
+	stored := faninUpdate{}
+	b, err := store.Default.Get(k)
+	if err != nil && err != store.ErrNotFound {
+		e.AppContext.Log.Error("error while getting the latest update of the key", e.Notification.Key, ". Resolving the update within the instance", err.Error())
+	}
+	found := err == nil && json.Unmarshal(b, &stored) == nil
+
+	//comparing with the latest update
+	latest, ok := acceptUpdate(k, u, stored, found, now)
+	if !ok && e.Notification.Priority.Urgent() {
+		e.AppContext.Log.Info("delivering the urgent update", u.Clock, "of the key", e.Notification.Key, "from", e.Source, "though superseded by", latest.Clock, "from", latest.Source)
+		return nil
+	}
+	if !ok {
+		delivery.CountSuperseded()
+		for _, user := range e.Users {
+			delivery.Trace(delivery.StageDropped, user, e.Notification, "as the update ", latest.Clock, " of its key from ", latest.Source, " supersedes it")
+		}
+		e.AppContext.Log.Info("dropping the update", u.Clock, "of the key", e.Notification.Key, "from", e.Source, "superseded by", latest.Clock, "from", latest.Source)
+		return bus.ErrHalt
+	}
+
+	//remembering the update
+	b, _ = json.Marshal(u)
+	if err := store.Default.Set(k, b, config.FanInWindow); err != nil {
+		e.AppContext.Log.Error("error while keeping the latest update of the key", e.Notification.Key, err.Error())
+	}
+	return nil
+}
+
+func init() {
+	bus.Use(bus.Enrich, faninEvent)
+}
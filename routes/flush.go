@@ -0,0 +1,126 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/store"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the flush of the notifications in flight on shutdown. The notifications waiting in the lanes,
+ * awaiting the acknowledgement and kept offline in memory are flushed to the queues of their users in the shared store,
+ * the critical ones first. The users get them on their next connect to any instance. If the store is in memory
+ * or the flush to it fails, they are appended to the dead letter queue file. The ones left after the flush timeout are lost
+ * and counted in the log.
+ */
+
+//flushPrefix is the store key prefix of the queues of the flushed notifications of the users
+const flushPrefix = "flush/"
+
+//FlushPending flushes the notifications in flight of the instance to the shared store or the dead letter queue.
+//It returns the no. of notifications flushed
+func FlushPending() int {
+	/*
+	 * We will take the notifications in flight ordered by the priority
+	 * Then we will flush them one by one till the timeout
+	 * Then we will log the ones lost
+	 */
+	//taking the notifications in flight
+	ps := delivery.TakePending(config.NewAppContext(log.NewLogger(0), 0).Db)
+	if len(ps) == 0 {
+		return 0
+	}
+	log.Info("flushing", len(ps), "notifications in flight")
+
+	//flushing them
+	deadline := time.Now().Add(config.ShutdownFlushTimeout)
+	var dlq *os.File
+	defer func() {
+		if dlq != nil {
+			dlq.Close()
+		}
+	}()
+	flushed, lost := 0, map[int]int{}
+	for _, p := range ps {
+		if time.Now().After(deadline) {
+			lost[p.Priority]++
+			continue
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			log.Error("error while encoding the notification", p.Notification.Event, "of user", p.UserID, "for the flush", err.Error())
+			lost[p.Priority]++
+			continue
+		}
+		if config.StoreBackend != config.StoreBackendMemory {
+			err = store.Default.Push(flushPrefix+strconv.FormatUint(uint64(p.UserID), 10), b, config.OfflineRetention)
+			if err == nil {
+				flushed++
+				continue
+			}
+			log.Error("error while flushing the notification", p.Notification.Event, "of user", p.UserID, "to the store", err.Error())
+		}
+		if len(config.FlushDLQPath) == 0 {
+			lost[p.Priority]++
+			continue
+		}
+		if dlq == nil {
+			if dlq, err = os.OpenFile(config.FlushDLQPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+				log.Error("error while opening the flush dead letter queue", config.FlushDLQPath, err.Error())
+				dlq = nil
+				lost[p.Priority]++
+				continue
+			}
+		}
+		if _, err := dlq.Write(append(b, '\n')); err != nil {
+			log.Error("error while writing the notification", p.Notification.Event, "of user", p.UserID, "to the flush dead letter queue", err.Error())
+			lost[p.Priority]++
+			continue
+		}
+		flushed++
+	}
+
+	//logging the lost ones
+	if len(lost) != 0 {
+		log.Error("lost the notifications in flight by the priority", lost, "while flushing on shutdown")
+	}
+	log.Info("flushed", flushed, "notifications in flight")
+	return flushed
+}
+
+//sendFlushed sends the notifications flushed by the instances shut down while the user was connected to them
+func sendFlushed(conn socketio.Conn, appCtx *config.AppContext) {
+	q := flushPrefix + strconv.FormatUint(uint64(appCtx.Session.User.ID), 10)
+	n, err := store.Default.Len(q)
+	if err != nil || n == 0 {
+		return
+	}
+	bs, err := store.Default.Pop(q, n)
+	if err != nil {
+		appCtx.Log.Error("error while getting the flushed notifications of the user", appCtx.Session.User.ID, err.Error())
+		return
+	}
+	appCtx.Log.Info("sending", len(bs), "flushed notifications to the connection", conn.ID())
+	for _, b := range bs {
+		p := delivery.Pending{}
+		if err := json.Unmarshal(b, &p); err != nil {
+			appCtx.Log.Error("error while decoding the flushed notification of the user", appCtx.Session.User.ID, err.Error())
+			continue
+		}
+		p.Notification.Seq = p.Seq
+		if err := delivery.Send(conn, p.Notification); err != nil {
+			appCtx.Log.Error("error while sending the flushed notification", p.Notification.Event, "to the connection", conn.ID(), err.Error())
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !minimal
+// +build !minimal
+
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/codec"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+/*
+ * This file contains the grpc api of the notification service for the services not written in go.
+ * The messages are json encoded with the same shapes as the http apis, so no generated code is needed.
+ * The clients use a json serializer for the requests and the replies of the methods under
+ * /cuttle.websockets.Notifications/. The token, if configured, is passed in the x-cuttle-token metadata.
+ * The server is served in its own port and registered with the discovery service.
+ * It is not compiled into the minimal build profile.
+ */
+
+//GRPCServiceName is the name of the grpc notification service
+const GRPCServiceName = "cuttle.websockets.Notifications"
+
+//GRPCTokenMetadata is the metadata key with which the callers pass the grpc token
+const GRPCTokenMetadata = "x-cuttle-token"
+
+//ListConnectionsRequest is the request of the list connections grpc method
+type ListConnectionsRequest struct {
+	//UserID whose connections are listed. 0 lists the connections of all the users
+	UserID uint `json:"userId"`
+}
+
+//ListConnectionsReply is the reply of the list connections grpc method
+type ListConnectionsReply struct {
+	//Connections of the instance
+	Connections []ConnectionInfo `json:"connections"`
+}
+
+//WatchRequest is the request of the watch deliveries grpc method
+type WatchRequest struct {
+	//Users whose delivery statuses are watched. Empty watches every user
+	Users []uint `json:"users"`
+	//Events whose delivery statuses are watched. Empty watches every event
+	Events []string `json:"events"`
+}
+
+//matches reports whether the receipt is watched
+func (w WatchRequest) matches(r delivery.Receipt) bool {
+	if len(w.Events) != 0 && !contains(w.Events, r.Event) {
+		return false
+	}
+	if len(w.Users) == 0 {
+		return true
+	}
+	for _, u := range w.Users {
+		if u == r.UserID {
+			return true
+		}
+	}
+	return false
+}
+
+//NotificationServer is the grpc notification service
+type NotificationServer interface {
+	//SendNotification sends the notification to the users or the room
+	SendNotification(ctx context.Context, args *SendArgs) (*SendReply, error)
+	//Broadcast sends the broadcast to the matching audience connected to the instance
+	Broadcast(ctx context.Context, b *Broadcast) (*BroadcastPreview, error)
+	//ListConnections lists the websocket connections of the instance
+	ListConnections(ctx context.Context, req *ListConnectionsRequest) (*ListConnectionsReply, error)
+	//WatchDeliveries streams the delivery statuses of the notifications sent from the instance
+	WatchDeliveries(req *WatchRequest, stream grpc.ServerStream) error
+}
+
+//grpcNotifications implements the grpc notification service
+type grpcNotifications struct{}
+
+//SendNotification sends the notification to the users or the room and replies once it is delivered.
+//The trace context is taken from the traceparent metadata if the notification doesn't have one
+func (grpcNotifications) SendNotification(ctx context.Context, args *SendArgs) (*SendReply, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if t := md.Get(tracing.Header); len(args.Notification.Traceparent) == 0 && len(t) != 0 {
+		args.Notification.Traceparent = t[0]
+	}
+	reply := &SendReply{}
+	if err := sendFromService(bus.SourceGRPC, *args, reply); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return reply, nil
+}
+
+//Broadcast sends the broadcast to the matching audience connected to the instance.
+//In the dry run mode only the preview of the audience is returned
+func (grpcNotifications) Broadcast(ctx context.Context, b *Broadcast) (*BroadcastPreview, error) {
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	e, p := broadcastEvent(appCtx, b)
+	e.Source = bus.SourceGRPC
+	if err := bus.Check(e); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if b.DryRun {
+		return &p, nil
+	}
+	log.Info("AUDIT: broadcast event", b.Event, "sent over grpc to", p.AudienceSize,
+		"users with tenants", b.Audience.Tenants, "roles", b.Audience.Roles, "users", b.Audience.Users, "presence", b.Audience.Presence)
+	if err := bus.Publish(e); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &p, nil
+}
+
+//ListConnections lists the websocket connections of the instance
+func (grpcNotifications) ListConnections(ctx context.Context, req *ListConnectionsRequest) (*ListConnectionsReply, error) {
+	return &ListConnectionsReply{Connections: listConnections(req.UserID)}, nil
+}
+
+//WatchDeliveries streams the delivery statuses of the watched notifications till the caller cancels.
+//The statuses are dropped if the caller is slower than the watch buffer
+func (grpcNotifications) WatchDeliveries(req *WatchRequest, stream grpc.ServerStream) error {
+	ch, stop := delivery.WatchReceipts(config.GRPCWatchBuffer)
+	defer stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case r := <-ch:
+			if !req.matches(r) {
+				continue
+			}
+			if err := stream.SendMsg(&r); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//unaryMethod returns the grpc method handler decoding the request into the value returned by newReq and calling the method
+func unaryMethod(method string, newReq func() interface{}, call func(srv NotificationServer, ctx context.Context, req interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(NotificationServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + GRPCServiceName + "/" + method}, handler)
+		},
+	}
+}
+
+//notificationServiceDesc is the description of the grpc notification service
+var notificationServiceDesc = grpc.ServiceDesc{
+	ServiceName: GRPCServiceName,
+	HandlerType: (*NotificationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("SendNotification", func() interface{} { return &SendArgs{} },
+			func(srv NotificationServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.SendNotification(ctx, req.(*SendArgs))
+			}),
+		unaryMethod("Broadcast", func() interface{} { return &Broadcast{} },
+			func(srv NotificationServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.Broadcast(ctx, req.(*Broadcast))
+			}),
+		unaryMethod("ListConnections", func() interface{} { return &ListConnectionsRequest{} },
+			func(srv NotificationServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.ListConnections(ctx, req.(*ListConnectionsRequest))
+			}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchDeliveries",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &WatchRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(NotificationServer).WatchDeliveries(req, stream)
+			},
+		},
+	},
+}
+
+//grpcAuthorized checks the grpc token in the metadata of the call. The calls are rejected if the grpc token isn't configured
+func grpcAuthorized(ctx context.Context, method string) error {
+	if len(config.GRPCToken) == 0 {
+		log.Warn("grpc call to", method, "rejected as the grpc token isn't configured")
+		return status.Error(codes.Unauthenticated, "grpc token isn't configured")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, t := range md.Get(GRPCTokenMetadata) {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(config.GRPCToken)) == 1 {
+			return nil
+		}
+	}
+	log.Warn("grpc call to", method, "with an invalid token")
+	return status.Error(codes.Unauthenticated, "invalid grpc token")
+}
+
+//grpcServer is the grpc server of the instance
+var grpcServer *grpc.Server
+
+//StartGRPC starts the grpc server in the grpc port.
+//An error is returned if the grpc port couldn't be listened
+func StartGRPC() error {
+	/*
+	 * We will create the server with the json codec and the token interceptors
+	 * Then we will register the notification service
+	 * Then we will start listening to the grpc port
+	 */
+	grpcServer = grpc.NewServer(
+		grpc.ForceServerCodec(codec.Default),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := grpcAuthorized(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := grpcAuthorized(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	grpcServer.RegisterService(&notificationServiceDesc, grpcNotifications{})
+	if len(config.GRPCToken) == 0 {
+		log.Warn("GRPC_TOKEN is not set. The grpc calls will be rejected")
+	}
+
+	l, err := net.Listen("tcp", ":"+config.GRPCPort)
+	if err != nil {
+		return &config.InitError{Part: config.PartRPC, Key: "GRPC_PORT", Err: err}
+	}
+	go func() {
+		if err := grpcServer.Serve(l); err != nil {
+			log.Error("grpc server exited", err.Error())
+		}
+	}()
+	return nil
+}
+
+//StopGRPC stops the grpc server gracefully. The calls still running after the drain timeout, like the watchers, are cut
+func StopGRPC() {
+	if grpcServer == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(config.DrainTimeout):
+		grpcServer.Stop()
+	}
+}
+
+func init() {
+	config.RegisterSubsystem(config.SubsystemGRPC)
+}
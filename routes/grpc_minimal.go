@@ -0,0 +1,25 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build minimal
+// +build minimal
+
+package routes
+
+import (
+	"github.com/cuttle-ai/websockets/log"
+)
+
+/*
+ * This file contains the grpc api of the minimal build profile. The grpc server is not compiled in
+ */
+
+//StartGRPC does nothing as the grpc api is not compiled into the minimal build
+func StartGRPC() error {
+	log.Info("grpc api is not compiled into the minimal build")
+	return nil
+}
+
+//StopGRPC does nothing as the grpc api is not compiled into the minimal build
+func StopGRPC() {}
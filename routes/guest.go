@@ -0,0 +1,195 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/limiter"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the guest connections. The websocket handshakes without any credential are served as guests
+ * if an anonymous readonly namespace exists. The guests don't take the app contexts from the pool, they have
+ * their own pool with negative ids. The handshakes are rate limited per ip. The guests can connect only to the
+ * anonymous readonly namespaces, join the guest room and receive only the whitelisted events at a stricter rate.
+ * They are not registered as the connections of any user.
+ */
+
+//guestContextTTL is the time after which a guest app context without a connection is released
+const guestContextTTL = time.Minute
+
+//ErrGuestContext is returned when the app context of a guest connection couldn't be found
+var ErrGuestContext = errors.New("error while connecting. Couldn't find the guest context")
+
+//guestContext is the app context of a guest handshake
+type guestContext struct {
+	//appCtx is the app context
+	appCtx *config.AppContext
+	//createdAt is the time at which the handshake was made
+	createdAt time.Time
+	//conns is the no. of namespace connections using the app context
+	conns int
+}
+
+var (
+	//guests has the app contexts of the guests mapped by their id
+	guests = map[int]*guestContext{}
+	//guestSeq is the id of the last guest app context. The ids are negative
+	guestSeq int
+	//guestHandshakes has the handshake rate limiters of the ips. The least recently used ones are evicted
+	//beyond the max guest connections
+	guestHandshakes = limiter.NewBuckets(config.GuestHandshakeRate, config.GuestHandshakeBurst, config.GuestMaxConnections)
+	//guestsLock is the lock for the guests
+	guestsLock sync.Mutex
+)
+
+//hasCredential reports whether the handshake carries any credential. Such handshakes are never served as guests
+func hasCredential(req *http.Request) bool {
+	if _, err := req.Cookie(authConfig.AuthHeaderKey); err == nil {
+		return true
+	}
+	return len(bearerToken(req)) != 0 || len(handshakeToken(req)) != 0 || len(req.URL.Query().Get(TicketParam)) != 0 ||
+		len(req.Header.Get(BenchmarkTokenHeader)) != 0 || len(req.URL.Query().Get(BenchmarkTokenParam)) != 0
+}
+
+//clientIP returns the ip of the client of the request. If the request came from a trusted proxy,
+//the X-Forwarded-For header is walked from the right and the first ip not of a trusted proxy is the client
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !config.TrustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(hops[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !config.TrustedProxy(ip) {
+			break
+		}
+	}
+	return host
+}
+
+//newGuestContext returns a new guest app context for the handshake from the ip.
+//The error response is written if the ip is rate limited or the guest pool is exhausted
+func newGuestContext(res http.ResponseWriter, ip string, now time.Time) (*config.AppContext, bool) {
+	/*
+	 * We will release the stale guest app contexts
+	 * Then we will check the rate limit of the ip and the pool
+	 * Then we will create the app context
+	 */
+	guestsLock.Lock()
+	defer guestsLock.Unlock()
+	for id, g := range guests {
+		if g.conns == 0 && now.Sub(g.createdAt) > guestContextTTL {
+			delete(guests, id)
+		}
+	}
+
+	//checking the rate limit and the pool
+	b := guestHandshakes.Get(ip)
+	if !b.Allow() {
+		log.Warn("rate limiting the guest handshakes from", ip)
+		response.WriteRetry(res, response.Error{Err: "Too many guest connections. Please try after some time.", Code: response.CodeRateLimited}, http.StatusTooManyRequests, b.Delay())
+		return nil, false
+	}
+	if len(guests) >= config.GuestMaxConnections {
+		log.Warn("guest connections exhausted. rejecting the guest handshake from", ip)
+		response.WriteRetry(res, response.Error{Err: "We have exhuasted the guest connections. Please try after some time.", Code: response.CodePoolExhausted}, http.StatusTooManyRequests, config.PoolRetryAfter)
+		return nil, false
+	}
+
+	//creating the app context
+	guestSeq--
+	appCtx := config.NewAppContext(log.NewLogger(guestSeq), guestSeq)
+	appCtx.Session = authConfig.Session{User: &authModels.User{}}
+	appCtx.Guest = true
+	guests[guestSeq] = &guestContext{appCtx: appCtx, createdAt: now}
+	return appCtx, true
+}
+
+//guestHandshake serves the websocket handshake without any credential as a guest. It reports whether it was served.
+//The requests of the established engine sessions are passed through
+func guestHandshake(res http.ResponseWriter, req *http.Request) bool {
+	if !config.AllowsAnonymous() || hasCredential(req) {
+		return false
+	}
+	if len(req.URL.Query().Get("sid")) == 0 {
+		appCtx, ok := newGuestContext(res, clientIP(req), time.Now())
+		if !ok {
+			return true
+		}
+		req.Header.Set("cuttle-ai-context-id", strconv.Itoa(appCtx.ID))
+	}
+	req.Header.Set(config.AuthMethodHeader, config.AuthAnonymous)
+	req.Header.Set(config.TicketNamespaceHeader, "")
+	config.WebSocketsServer().ServeHTTP(res, req)
+	return true
+}
+
+//onGuestConnect connects the guest connection with its app context and joins it to the guest room
+func onGuestConnect(conn socketio.Conn, contextID int) error {
+	guestsLock.Lock()
+	g, ok := guests[contextID]
+	if ok {
+		g.conns++
+	}
+	guestsLock.Unlock()
+	if !ok {
+		log.Error("couldn't find the guest context", contextID)
+		return ErrGuestContext
+	}
+
+	conn.SetContext(g.appCtx)
+	g.appCtx.DeviceID = deviceID(conn)
+	g.appCtx.Locale = locale(conn)
+	g.appCtx.Timezone = timezone(conn, g.appCtx.Log)
+	g.appCtx.Codec = connCodec(conn, g.appCtx.Log)
+	delivery.Open(conn)
+	delivery.Join(roomKey("", config.GuestRoom), conn)
+	g.appCtx.Log.Info("Guest connected with id", conn.ID(), "to the namespace", conn.Namespace())
+	return nil
+}
+
+//onGuestDisconnect releases the app context of the guest once all its connections are closed
+func onGuestDisconnect(conn socketio.Conn, appCtx *config.AppContext) {
+	delivery.Close(conn)
+	unsubscribeAllState(conn)
+	guestsLock.Lock()
+	if g, ok := guests[appCtx.ID]; ok {
+		if g.conns--; g.conns <= 0 {
+			delete(guests, appCtx.ID)
+		}
+	}
+	guestsLock.Unlock()
+	appCtx.Log.Info("Guest disconnected with id", conn.ID())
+}
+
+//GuestStats returns the no. of guest app contexts in use and the max allowed
+func GuestStats() (int, int) {
+	guestsLock.Lock()
+	defer guestsLock.Unlock()
+	return len(guests), config.GuestMaxConnections
+}
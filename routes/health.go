@@ -0,0 +1,111 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the health and readiness endpoints.
+ * Health reports whether the instance is alive with the websockets server serving.
+ * Readiness additionally checks the database and the auth service. The discovery service checks it
+ * and stops routing to the instance when it isn't ready.
+ */
+
+const (
+	//CheckOK is the status of a passed check
+	CheckOK = "ok"
+	//CheckFailed is the status of a failed check
+	CheckFailed = "failed"
+	//CheckDisabled is the status of a check of a subsystem not used by the instance
+	CheckDisabled = "disabled"
+)
+
+//Check is the result of a health check
+type Check struct {
+	//Status of the check
+	Status string `json:"status"`
+	//Error of the failed check
+	Error string `json:"error,omitempty"`
+}
+
+//HealthReport is the report of the health checks
+type HealthReport struct {
+	//Status is ok if all the checks passed
+	Status string `json:"status"`
+	//Checks has the results of the checks mapped by the name
+	Checks map[string]Check `json:"checks"`
+}
+
+//add adds the result of the check to the report
+func (h *HealthReport) add(name string, err error) {
+	if err == nil {
+		h.Checks[name] = Check{Status: CheckOK}
+		return
+	}
+	h.Status = CheckFailed
+	h.Checks[name] = Check{Status: CheckFailed, Error: err.Error()}
+}
+
+//writeReport writes the report with the service unavailable status if a check failed
+func writeReport(res http.ResponseWriter, h HealthReport) {
+	if h.Status != CheckOK {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	response.Write(res, response.Message{Message: h.Status, Data: h})
+}
+
+//Health reports whether the instance is alive with the websockets server serving
+func Health(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	h := HealthReport{Status: CheckOK, Checks: map[string]Check{}}
+	h.add("websockets", config.CheckWebSockets())
+	writeReport(res, h)
+}
+
+//Ready reports whether the instance can serve the traffic. The websockets server, the database and the auth service
+//are checked within the check timeout. The instance is not ready once it is marked so, like when shutting down
+func Ready(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * We will check whether the instance is marked not ready
+	 * Then we will run the checks within the timeout
+	 */
+	h := HealthReport{Status: CheckOK, Checks: map[string]Check{}}
+	if reason := config.NotReady(); len(reason) != 0 {
+		h.Status = CheckFailed
+		h.Checks["instance"] = Check{Status: CheckFailed, Error: reason}
+	}
+
+	//running the checks
+	cCtx, cancel := context.WithTimeout(ctx, config.HealthCheckTimeout)
+	defer cancel()
+	h.add("websockets", config.CheckWebSockets())
+	if used, err := config.CheckDB(cCtx); used {
+		h.add("db", err)
+	} else {
+		h.Checks["db"] = Check{Status: CheckDisabled}
+	}
+	h.add("auth", config.CheckAuth(cCtx))
+	writeReport(res, h)
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:         "v1",
+		HandlerFunc:     Health,
+		Pattern:         "/health",
+		Unauthenticated: true,
+	})
+	AddRoutes(Route{
+		Version:         "v1",
+		HandlerFunc:     Ready,
+		Pattern:         "/ready",
+		Unauthenticated: true,
+	})
+}
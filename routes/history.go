@@ -0,0 +1,218 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the notification history api for the notification center of the frontend.
+ * The clients mark the notifications read over the api or the read event. Every connection of the user
+ * is sent the unread count on connect and whenever the notifications are read so that the badge is same across the devices.
+ */
+
+const (
+	//DefaultHistoryLimit is the no. of notifications in a page of the history
+	DefaultHistoryLimit = 20
+	//MaxHistoryLimit is the max no. of notifications in a page of the history
+	MaxHistoryLimit = 100
+)
+
+//NotificationHistory returns a page of the notifications delivered to the user, newest first.
+//The page is given with the limit query param and the older pages with the cursor query param
+//set to the next cursor of the previous page
+func NotificationHistory(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the query params
+	 * Then we will get the page of the history and write the response
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodGet {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//parsing the query params
+	limit, err := queryInt(req, "limit", DefaultHistoryLimit)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the limit param", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if limit == 0 || limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+
+	//getting the history
+	p, err := delivery.HistoryBefore(appCtx.Db, appCtx.Session.User.ID, req.URL.Query().Get("cursor"), limit)
+	if err == delivery.ErrInvalidCursor {
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		appCtx.Log.Error("error while getting the notification history of user", appCtx.Session.User.ID, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't get the notification history"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(res, response.Message{Message: "notification history", Data: p})
+}
+
+//UnreadCount is the no. of unread notifications of the user sent with the unread count event
+type UnreadCount struct {
+	//Count of the unread notifications
+	Count int `json:"count"`
+}
+
+//ReadRequest is the request to mark the notifications of the user as read
+type ReadRequest struct {
+	//Seqs are the sequence nos. of the notifications to be marked as read
+	Seqs []uint64 `json:"seqs"`
+	//All marks all the notifications of the user as read
+	All bool `json:"all"`
+}
+
+//sendUnreadCount sends the unread count of the user to the connection
+func sendUnreadCount(conn socketio.Conn, appCtx *config.AppContext) {
+	c, err := delivery.UnreadCount(appCtx.Db, appCtx.Session.User.ID)
+	if err != nil {
+		appCtx.Log.Error("error while counting the unread notifications of user", appCtx.Session.User.ID, err.Error())
+		return
+	}
+	n := delivery.Notification{}
+	n.Event = config.UnreadCountEvent
+	n.Payload = UnreadCount{Count: c}
+	if err := delivery.Send(conn, n); err != nil {
+		appCtx.Log.Error("error while sending the unread count to the connection", conn.ID(), err.Error())
+	}
+}
+
+//markRead marks the notifications of the user as read, sends their read receipts and publishes the new unread count
+//to all the connections of the user. If the sequence nos. are empty, all the notifications are marked.
+//It returns the unread count of the user
+func markRead(appCtx *config.AppContext, seqs []uint64) (int, error) {
+	/*
+	 * We will mark the notifications as read
+	 * Then we will send the read receipts
+	 * Then we will publish the unread count as a live event if any notification was marked
+	 */
+	userID := appCtx.Session.User.ID
+	marked, err := delivery.MarkRead(appCtx.Db, userID, seqs)
+	if err != nil {
+		return 0, err
+	}
+	for _, seq := range seqs {
+		delivery.Read(userID, seq)
+	}
+
+	//publishing the unread count
+	c, err := delivery.UnreadCount(appCtx.Db, userID)
+	if err != nil || marked == 0 {
+		return c, err
+	}
+	n := delivery.Notification{}
+	n.Event = config.UnreadCountEvent
+	n.Payload = UnreadCount{Count: c}
+	e := &bus.Event{Source: bus.SourceUnread, AppContext: appCtx, Notification: n, Users: []uint{userID}, Live: true}
+	if err := bus.Publish(e); err != nil {
+		appCtx.Log.Error("error while publishing the unread count of user", userID, err.Error())
+	}
+	return c, nil
+}
+
+//onReadAll marks all the notifications of the connection's user as read
+func onReadAll(conn socketio.Conn) {
+	appCtx := conn.Context().(*config.AppContext)
+	if _, err := markRead(appCtx, nil); err != nil {
+		appCtx.Log.Error("error while marking all the notifications of user", appCtx.Session.User.ID, "as read", err.Error())
+	}
+}
+
+//MarkRead marks the notifications of the user with the sequence nos. in the request as read and returns the unread count.
+//If all is set in the request, all the notifications of the user are marked
+func MarkRead(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request
+	 * Then we will mark the notifications and write the response
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodPost {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//parsing the request
+	r := &ReadRequest{}
+	if err := decode(req, r); err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the read request", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if len(r.Seqs) == 0 && !r.All {
+		response.WriteError(res, response.Error{Err: "Invalid Params seqs or all is required"}, http.StatusBadRequest)
+		return
+	}
+	if r.All {
+		r.Seqs = nil
+	}
+
+	//marking the notifications
+	c, err := markRead(appCtx, r.Seqs)
+	if err != nil {
+		appCtx.Log.Error("error while marking the notifications of user", appCtx.Session.User.ID, "as read", err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't mark the notifications as read"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(res, response.Message{Message: "marked the notifications as read", Data: UnreadCount{Count: c}})
+}
+
+//Unread returns the no. of unread notifications of the user
+func Unread(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	c, err := delivery.UnreadCount(appCtx.Db, appCtx.Session.User.ID)
+	if err != nil {
+		appCtx.Log.Error("error while counting the unread notifications of user", appCtx.Session.User.ID, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't count the unread notifications"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(res, response.Message{Message: "unread notifications", Data: UnreadCount{Count: c}})
+}
+
+func init() {
+	if err := delivery.InitHistory(config.DB()); err != nil {
+		log.Error("error while initing the notification history", err.Error())
+	}
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: NotificationHistory,
+		Pattern:     "/notification/history",
+	})
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: MarkRead,
+		Pattern:     "/notification/read",
+	})
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Unread,
+		Pattern:     "/notification/unread-count",
+	})
+	config.RegisterWebsocketEvents(config.Namespace, "read-all", onReadAll)
+}
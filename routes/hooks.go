@@ -0,0 +1,292 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/jsonpath"
+	"github.com/cuttle-ai/websockets/limiter"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the inbound hooks of the third party systems. A source posts its own payload to /hooks/{source}
+ * and the payload is mapped to an event for the users selected from it as per the hook mapping of the source in the config.
+ * The payload is signed by the source as hex(hmac-sha256(secret, timestamp + "." + body)) with the unix timestamp sent
+ * along, so that a new system can trigger the realtime updates without a custom integration. The requests signed
+ * beyond the max skew are rejected and a signature is accepted only once within the skew window, so that a captured request can't be replayed.
+ * The users of a hook mapping with a tenant are limited to the ones last seen connected in the tenant.
+ */
+
+const (
+	//hooksPattern is the pattern of the hooks endpoint. The source is the path after it
+	hooksPattern = "/hooks/"
+	//userTenantPrefix is the prefix of the store keys of the tenants in which the users were last seen connected
+	userTenantPrefix = "tenant/"
+)
+
+var (
+	//hookPaths has the compiled users and payload paths of the hook mappings mapped by the source
+	hookPaths = map[string][2]jsonpath.Path{}
+	//hookTenants is set if any hook mapping has a tenant. The tenants of the users are remembered only then
+	hookTenants bool
+	//hookBuckets has the rate limiters of the hook sources
+	hookBuckets = map[string]*limiter.Bucket{}
+	//hookBucketsLock is the lock for the hook buckets
+	hookBucketsLock sync.Mutex
+)
+
+//hookBucket returns the rate limiter of the source. It is nil if the source is not rate limited
+func hookBucket(source string, h config.HookMapping) *limiter.Bucket {
+	if h.Rate <= 0 {
+		return nil
+	}
+	hookBucketsLock.Lock()
+	defer hookBucketsLock.Unlock()
+	b, ok := hookBuckets[source]
+	if !ok {
+		b = limiter.NewBucket(h.Rate, h.Burst)
+		hookBuckets[source] = b
+	}
+	return b
+}
+
+//verifyHook verifies the hmac signature of the timestamp and the hook body. The timestamp has to be within the max skew of now
+func verifyHook(h config.HookMapping, req *http.Request, body []byte, now time.Time) bool {
+	ts := req.Header.Get(h.TimestampHeader)
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(t, 0)); skew > config.HookMaxSkew || skew < -config.HookMaxSkew {
+		return false
+	}
+	sig := strings.TrimPrefix(req.Header.Get(h.SignatureHeader), "sha256=")
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+//rememberTenant remembers the tenant in which the user connected if any hook mapping is limited to a tenant
+func rememberTenant(userID uint, tenant string) {
+	if !hookTenants || len(tenant) == 0 {
+		return
+	}
+	if err := store.Default.Set(userTenantPrefix+strconv.FormatUint(uint64(userID), 10), []byte(tenant), 0); err != nil {
+		log.Error("error while saving the tenant of user", userID, err.Error())
+	}
+}
+
+//tenantUsers returns the users last seen connected in the tenant
+func tenantUsers(tenant string, users []uint) ([]uint, error) {
+	result := make([]uint, 0, len(users))
+	for _, u := range users {
+		b, err := store.Default.Get(userTenantPrefix + strconv.FormatUint(uint64(u), 10))
+		if err == store.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if string(b) == tenant {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+//hookUsers returns the ids of the users in the values selected from the payload.
+//The ids can be numbers, numeric strings or arrays of them
+func hookUsers(values []interface{}) []uint {
+	users := []uint{}
+	for _, v := range values {
+		var id uint64
+		switch t := v.(type) {
+		case float64:
+			if t <= 0 || t != float64(uint64(t)) {
+				continue
+			}
+			id = uint64(t)
+		case string:
+			i, err := strconv.ParseUint(t, 10, 64)
+			if err != nil || i == 0 {
+				continue
+			}
+			id = i
+		case []interface{}:
+			for _, u := range hookUsers(t) {
+				if !containsUser(users, u) {
+					users = append(users, u)
+				}
+			}
+			continue
+		default:
+			continue
+		}
+		if !containsUser(users, uint(id)) {
+			users = append(users, uint(id))
+		}
+	}
+	return users
+}
+
+//Hook maps the payload posted by a third party source to the event of its hook mapping and sends it to the users selected from the payload
+func Hook(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * We will find the hook mapping of the source
+	 * Then we will check the rate limit of the source
+	 * Then we will read the body and verify the signature
+	 * Then we will reject the signature if it was already accepted
+	 * Then we will decode the payload and map it to the event for the users of the tenant
+	 * Will write the response
+	 * Then will publish the event to the bus
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodPost {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//finding the hook mapping
+	source := req.URL.Path[strings.Index(req.URL.Path, hooksPattern)+len(hooksPattern):]
+	h, ok := config.Hooks[source]
+	paths, compiled := hookPaths[source]
+	if !ok || !compiled {
+		appCtx.Log.Warn("hook request from unknown source", source)
+		response.WriteError(res, response.Error{Err: "Unknown source"}, http.StatusNotFound)
+		return
+	}
+
+	//checking the rate limit
+	if b := hookBucket(source, h); b != nil && !b.Allow() {
+		appCtx.Log.Warn("rate limit exceeded for the hook source", source)
+		response.WriteRetry(res, response.Error{Err: "Rate limit exceeded", Code: response.CodeRateLimited}, http.StatusTooManyRequests, b.Delay())
+		return
+	}
+
+	//reading and verifying the body
+	body, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, config.IngestMaxBodySize))
+	if err != nil {
+		appCtx.Log.Error("error while reading the hook request of source", source, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't read the request body"}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if !verifyHook(h, req, body, time.Now()) {
+		appCtx.Log.Warn("invalid or stale signature for the hook request of source", source)
+		response.WriteError(res, response.Error{Err: "Invalid signature"}, http.StatusUnauthorized)
+		return
+	}
+
+	//rejecting the replayed signature. The timestamps are accepted on either side of now within the skew
+	first, err := firstSeen("hook/"+source, req.Header.Get(h.SignatureHeader), 2*config.HookMaxSkew)
+	if err != nil {
+		appCtx.Log.Error("error while recording the signature of the hook request of source", source, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't verify the signature"}, http.StatusServiceUnavailable)
+		return
+	}
+	if !first {
+		appCtx.Log.Warn("replayed signature for the hook request of source", source)
+		response.WriteError(res, response.Error{Err: "Signature was already used"}, http.StatusUnauthorized)
+		return
+	}
+
+	//mapping the payload to the event
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		appCtx.Log.Error("error while parsing the hook payload of source", source, err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	users := hookUsers(paths[0].Get(doc))
+	if len(h.Tenant) != 0 {
+		appCtx.Tenant = h.Tenant
+		if users, err = tenantUsers(h.Tenant, users); err != nil {
+			appCtx.Log.Error("error while getting the users of the tenant", h.Tenant, "for the hook of source", source, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't get the users of the tenant"}, http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(users) == 0 {
+		appCtx.Log.Warn("no users found at", paths[0].String(), "in the hook payload of source", source)
+		response.Write(res, response.Message{Message: "no users to notify in the payload"})
+		return
+	}
+	var payload interface{} = doc
+	if len(h.Payload) != 0 {
+		values := paths[1].Get(doc)
+		payload = values
+		if len(values) == 1 {
+			payload = values[0]
+		}
+	}
+	be := &bus.Event{Source: bus.SourceHook, AppContext: appCtx, Users: users}
+	be.Notification.Event, be.Notification.Payload = h.Event, payload
+	if err := bus.Check(be); err != nil {
+		appCtx.Log.Error("error while validating the hook event of source", source, err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//sending response
+	response.Write(res, response.Message{Message: "sending the event", Data: len(users)})
+
+	//publishing the event to the users
+	appCtx.Log.Info("sending hook event", h.Event, "from source", source, "to", len(users), "users")
+	if err := bus.Publish(be); err != nil {
+		appCtx.Log.Error("error while publishing the hook event", h.Event, "of source", source, err.Error())
+	}
+}
+
+func init() {
+	for source, h := range config.Hooks {
+		if len(h.Event) == 0 || len(h.Secret) == 0 {
+			log.Error("skipping the hook of source", source, "as its event or secret is missing")
+			continue
+		}
+		users, err := jsonpath.Compile(h.Users)
+		if err != nil {
+			log.Error("skipping the hook of source", source, "as its users path", h.Users, "is invalid")
+			continue
+		}
+		payload := jsonpath.Path{}
+		if len(h.Payload) != 0 {
+			if payload, err = jsonpath.Compile(h.Payload); err != nil {
+				log.Error("skipping the hook of source", source, "as its payload path", h.Payload, "is invalid")
+				continue
+			}
+		}
+		hookPaths[source] = [2]jsonpath.Path{users, payload}
+		hookTenants = hookTenants || len(h.Tenant) != 0
+	}
+	AddRoutes(Route{
+		Version:         "v1",
+		HandlerFunc:     Hook,
+		Pattern:         hooksPattern,
+		Unauthenticated: true,
+	})
+}
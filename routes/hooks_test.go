@@ -0,0 +1,90 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/jsonpath"
+	"github.com/cuttle-ai/websockets/log"
+)
+
+//hookRequest returns the hook request of the source with the body signed at the timestamp with the signature
+func hookRequest(h config.HookMapping, source, body, ts, sig string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1"+hooksPattern+source, strings.NewReader(body))
+	req.Header.Set(h.TimestampHeader, ts)
+	req.Header.Set(h.SignatureHeader, sig)
+	return req
+}
+
+func TestVerifyHook(t *testing.T) {
+	h := config.HookMapping{Secret: "hook-secret", SignatureHeader: "X-Signature", TimestampHeader: "X-Timestamp"}
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-config.HookMaxSkew-time.Second).Unix(), 10)
+	future := strconv.FormatInt(now.Add(config.HookMaxSkew+time.Second).Unix(), 10)
+	body := `{"user":5}`
+
+	cases := []struct {
+		name  string
+		ts    string
+		sig   string
+		valid bool
+	}{
+		{"valid", ts, "sha256=" + sign("hook-secret", ts, "", body), true},
+		{"valid without the prefix", ts, sign("hook-secret", ts, "", body), true},
+		{"other secret", ts, "sha256=" + sign("other-secret", ts, "", body), false},
+		{"stale timestamp", stale, "sha256=" + sign("hook-secret", stale, "", body), false},
+		{"future timestamp", future, "sha256=" + sign("hook-secret", future, "", body), false},
+		{"missing timestamp", "", "sha256=" + sign("hook-secret", "", "", body), false},
+		{"bad hex", ts, "sha256=xyz", false},
+		{"empty signature", ts, "", false},
+	}
+	for _, c := range cases {
+		if got := verifyHook(h, hookRequest(h, "verify", body, c.ts, c.sig), []byte(body), now); got != c.valid {
+			t.Errorf("%s: expected the signature to be valid %t. got %t", c.name, c.valid, got)
+		}
+	}
+}
+
+func TestHookReplay(t *testing.T) {
+	h := config.HookMapping{Secret: "replay-secret", SignatureHeader: "X-Signature", TimestampHeader: "X-Timestamp"}
+	h.Event, h.Users = "hook-replay-test", "$.user"
+	users, err := jsonpath.Compile(h.Users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Hooks["replay-test"], hookPaths["replay-test"] = h, [2]jsonpath.Path{users, {}}
+	defer delete(config.Hooks, "replay-test")
+	defer delete(hookPaths, "replay-test")
+	body := `{"user":5}`
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := "sha256=" + sign("replay-secret", ts, "", body)
+
+	cases := []struct {
+		name string
+		sig  string
+		code int
+	}{
+		{"first", sig, http.StatusOK},
+		{"replayed", sig, http.StatusUnauthorized},
+		{"replayed without the prefix", sig[len("sha256="):], http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		res := httptest.NewRecorder()
+		ctx := context.WithValue(context.Background(), AppContextKey, config.NewAppContext(log.NewLogger(0), 0))
+		Hook(ctx, res, hookRequest(h, "replay-test", body, ts, c.sig))
+		if res.Code != c.code {
+			t.Errorf("%s: expected %d. got %d %s", c.name, c.code, res.Code, res.Body.String())
+		}
+	}
+}
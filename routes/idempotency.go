@@ -0,0 +1,220 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the idempotency keys of the notification requests.
+ * The callers retrying a request send the same Idempotency-Key header. The first request with a key of a caller
+ * claims the key for a short lease renewed while it is processed, so the key of an instance dying midway is freed.
+ * Once processed, its response is kept in the store for the idempotency window. The repeated requests get the
+ * kept response without the notification being sent again. The responses of the failures worth retrying are not kept.
+ */
+
+const (
+	//IdempotencyKeyHeader is the header with which the callers make a request idempotent
+	IdempotencyKeyHeader = "Idempotency-Key"
+	//IdempotentReplayedHeader is set on the responses replayed for the repeated requests
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	//idempotencyPrefix is the store key prefix of the results of the idempotent requests
+	idempotencyPrefix = "idempotency/"
+	//maxIdempotencyKey is the max length of an idempotency key
+	maxIdempotencyKey = 255
+)
+
+//idempotentResult is the result of the request with an idempotency key kept in the store
+type idempotentResult struct {
+	//BodyHash is the hex encoded sha256 hash of the request body
+	BodyHash string `json:"bodyHash"`
+	//Done is set once the request is processed
+	Done bool `json:"done"`
+	//Status of the response
+	Status int `json:"status,omitempty"`
+	//ContentType of the response
+	ContentType string `json:"contentType,omitempty"`
+	//Body of the response
+	Body []byte `json:"body,omitempty"`
+}
+
+//recorder records the status and the body of the response written by a handler
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+//WriteHeader records the status and writes it
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+//Write records the body and writes it
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+//retryable reports whether the request ending with the status is worth retrying. Their results are not kept
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+//renewLease renews the lease of the claimed idempotency key till the stop channel is closed. The returned channel
+//is closed once it has stopped renewing, so that the kept result isn't overwritten by a late renewal
+func renewLease(appCtx *config.AppContext, key string, claim []byte, stop chan struct{}) chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(config.IdempotencyLease / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if err := store.Default.Set(key, claim, config.IdempotencyLease); err != nil {
+					appCtx.Log.Error("error while renewing the lease of the idempotency key", key, err.Error())
+				}
+			}
+		}
+	}()
+	return stopped
+}
+
+//idempotencyKey returns the store key of the idempotency key scoped by the caller of the request
+func idempotencyKey(ctx context.Context, appCtx *config.AppContext, key string) string {
+	if k, ok := ctx.Value(APIKeyKey).(apikey.Key); ok {
+		return idempotencyPrefix + "key/" + k.ID + "/" + key
+	}
+	return idempotencyPrefix + "user/" + strconv.FormatUint(uint64(appCtx.Session.User.ID), 10) + "/" + key
+}
+
+//Idempotent wraps the handler func so that the requests repeated by a caller with the same idempotency key
+//within the idempotency window get the response of the original request without the handler running again.
+//If the store is unavailable, the requests are processed as if they had no key
+func Idempotent(h HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+		/*
+		 * We will skip the requests without the idempotency key
+		 * We will read the body upto the max size and restore it for the handler
+		 * Then we will claim the key for the lease. If it is already claimed, we will replay its result
+		 * Then we will run the handler renewing the lease
+		 * Then we will keep its result for the idempotency window unless it is worth retrying
+		 */
+		key := req.Header.Get(IdempotencyKeyHeader)
+		if len(key) == 0 {
+			h(ctx, res, req)
+			return
+		}
+		appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+		if len(key) > maxIdempotencyKey {
+			response.WriteError(res, response.Error{Err: "Invalid Params idempotency key is longer than " + strconv.Itoa(maxIdempotencyKey)}, http.StatusBadRequest)
+			return
+		}
+
+		//reading the body
+		body, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, config.IdempotencyMaxBodySize))
+		if err != nil {
+			appCtx.Log.Error("error while reading the idempotent request body", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't read the request body"}, http.StatusBadRequest)
+			return
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		r := idempotentResult{BodyHash: hex.EncodeToString(sum[:])}
+
+		//claiming the key
+		sk := idempotencyKey(ctx, appCtx, key)
+		b, _ := json.Marshal(r)
+		claimed, err := store.Default.SetNX(sk, b, config.IdempotencyLease)
+		if err != nil {
+			appCtx.Log.Error("error while claiming the idempotency key", key, ". Processing the request without it", err.Error())
+			h(ctx, res, req)
+			return
+		}
+		if !claimed {
+			replayIdempotent(appCtx, res, sk, r.BodyHash)
+			return
+		}
+
+		//running the handler
+		rec := &recorder{ResponseWriter: res}
+		stop := make(chan struct{})
+		stopped := renewLease(appCtx, sk, b, stop)
+		func() {
+			//the renewal stops even if the handler panics
+			defer close(stop)
+			h(ctx, rec, req)
+		}()
+		<-stopped
+
+		//keeping the result
+		if retryable(rec.status) {
+			store.Default.Delete(sk)
+			return
+		}
+		r.Done, r.Status, r.ContentType, r.Body = true, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()
+		b, err = json.Marshal(r)
+		if err == nil {
+			err = store.Default.Set(sk, b, config.IdempotencyWindow)
+		}
+		if err != nil {
+			appCtx.Log.Error("error while keeping the result of the request with the idempotency key", key, err.Error())
+		}
+	}
+}
+
+//replayIdempotent writes the kept result of the original request with the idempotency key.
+//The repeated request must have the same body as the original one
+func replayIdempotent(appCtx *config.AppContext, res http.ResponseWriter, key, bodyHash string) {
+	b, err := store.Default.Get(key)
+	r := idempotentResult{}
+	if err == nil {
+		err = json.Unmarshal(b, &r)
+	}
+	if err != nil {
+		appCtx.Log.Error("error while getting the result of the request with the idempotency key", key, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't get the result of the original request", Code: response.CodeIdempotencyInProgress}, http.StatusConflict)
+		return
+	}
+	if r.BodyHash != bodyHash {
+		response.WriteError(res, response.Error{Err: "Idempotency key was used with a different request", Code: response.CodeIdempotencyKeyReused}, http.StatusUnprocessableEntity)
+		return
+	}
+	if !r.Done {
+		response.WriteError(res, response.Error{Err: "Original request with the idempotency key is in progress", Code: response.CodeIdempotencyInProgress}, http.StatusConflict)
+		return
+	}
+	appCtx.Log.Info("replaying the result of the request with the idempotency key", key)
+	if len(r.ContentType) != 0 {
+		res.Header().Set("Content-Type", r.ContentType)
+	}
+	res.Header().Set(IdempotentReplayedHeader, "true")
+	res.WriteHeader(r.Status)
+	res.Write(r.Body)
+}
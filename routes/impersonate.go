@@ -0,0 +1,58 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the impersonation mode for the support engineers.
+ * An admin connection can watch the event stream of a user with the impersonate event.
+ * Every notification emitted to the user's connections is mirrored to the admin connection as the impersonation event
+ * with the sensitive fields redacted. The view is read only as the admin connection keeps its own identity.
+ * Every start and stop of an impersonation is audit logged and it stops automatically after the max duration.
+ */
+
+//onImpersonate starts the impersonation of the user by the admin connection
+func onImpersonate(conn socketio.Conn, userID uint) string {
+	/*
+	 * We will check whether the connection is of an admin
+	 * Then we will start the tap and audit log it
+	 * Then we will stop it after the max duration
+	 */
+	appCtx := conn.Context().(*config.AppContext)
+	if !config.IsAdmin(appCtx.Session.User.ID) {
+		log.Warn("AUDIT: non admin user", appCtx.Session.User.ID, "tried to impersonate user", userID)
+		return "forbidden"
+	}
+	adminID := appCtx.Session.User.ID
+	delivery.Tap(userID, conn)
+	log.Info("AUDIT: impersonation of user", userID, "started by admin", adminID, "from the connection", conn.ID())
+	time.AfterFunc(config.ImpersonationMaxDuration, func() {
+		if delivery.Untap(userID, conn) {
+			log.Info("AUDIT: impersonation of user", userID, "by admin", adminID, "expired")
+		}
+	})
+	return "ok"
+}
+
+//onImpersonateStop stops the impersonation of the user by the admin connection
+func onImpersonateStop(conn socketio.Conn, userID uint) {
+	appCtx := conn.Context().(*config.AppContext)
+	if delivery.Untap(userID, conn) {
+		log.Info("AUDIT: impersonation of user", userID, "stopped by admin", appCtx.Session.User.ID)
+	}
+}
+
+func init() {
+	config.RegisterWebsocketEvents(config.Namespace, "impersonate", onImpersonate)
+	config.RegisterWebsocketEvents(config.Namespace, "impersonate-stop", onImpersonateStop)
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/codec"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/limiter"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the ingestion endpoint for the third party systems outside the mesh.
+ * The request body is signed by the source with its key as hex(hmac-sha256(key, timestamp + "." + body)).
+ */
+
+const (
+	//IngestSourceHeader is the header having the name of the source pushing the event
+	IngestSourceHeader = "X-Cuttle-Source"
+	//IngestTimestampHeader is the header having the unix timestamp at which the request was signed
+	IngestTimestampHeader = "X-Cuttle-Timestamp"
+	//IngestSignatureHeader is the header having the signature of the request as sha256=<hex>
+	IngestSignatureHeader = "X-Cuttle-Signature"
+)
+
+//IngestEvent is the event pushed by a third party source
+type IngestEvent struct {
+	delivery.Notification
+	//Users has the ids of the users to whom the event is destined
+	Users []uint `json:"users"`
+}
+
+var (
+	//ingestBuckets has the rate limiters of the sources
+	ingestBuckets = map[string]*limiter.Bucket{}
+	//ingestBucketsLock is the lock for the ingest buckets
+	ingestBucketsLock sync.Mutex
+)
+
+//ingestBucket returns the rate limiter of the source
+func ingestBucket(name string, src config.IngestSource) *limiter.Bucket {
+	ingestBucketsLock.Lock()
+	defer ingestBucketsLock.Unlock()
+	b, ok := ingestBuckets[name]
+	if !ok {
+		b = limiter.NewBucket(src.Rate, src.Burst)
+		ingestBuckets[name] = b
+	}
+	return b
+}
+
+//verifyIngest verifies the timestamp and the signature of the ingest request body
+func verifyIngest(src config.IngestSource, req *http.Request, body []byte) bool {
+	return verifySignature([]byte(src.Key), config.IngestMaxSkew, req, "", body)
+}
+
+//Ingest accepts the signed events from the third party sources and sends them to the destined users
+func Ingest(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * We will find the source of the request
+	 * Then we will check the rate limit of the source before reading the body, so that a flood costs no read or hmac
+	 * Then we will read the body and verify the signature
+	 * Then we will parse the event, check the allowlist and validate it
+	 * Will write the response
+	 * Then will publish the event to the bus
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodPost {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//finding the source
+	name := req.Header.Get(IngestSourceHeader)
+	src, ok := config.IngestSources[name]
+	if !ok {
+		appCtx.Log.Warn("ingest request from unknown source", name)
+		response.WriteError(res, response.Error{Err: "Unknown source"}, http.StatusUnauthorized)
+		return
+	}
+
+	//checking the rate limit
+	if b := ingestBucket(name, src); !b.Allow() {
+		appCtx.Log.Warn("rate limit exceeded for the ingest source", name)
+		response.WriteRetry(res, response.Error{Err: "Rate limit exceeded", Code: response.CodeRateLimited}, http.StatusTooManyRequests, b.Delay())
+		return
+	}
+
+	//reading and verifying the body
+	body, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, config.IngestMaxBodySize))
+	if err != nil {
+		appCtx.Log.Error("error while reading the ingest request of source", name, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't read the request body"}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if !verifyIngest(src, req, body) {
+		appCtx.Log.Warn("invalid signature for the ingest request of source", name)
+		response.WriteError(res, response.Error{Err: "Invalid signature"}, http.StatusUnauthorized)
+		return
+	}
+
+	//parsing the event
+	e := &IngestEvent{}
+	err = codec.ForContentType(req.Header.Get("Content-Type")).Unmarshal(body, e)
+	if err != nil {
+		appCtx.Log.Error("error while parsing the ingest event of source", name, err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	if !src.Allowed(e.Event) {
+		appCtx.Log.Warn("ingest source", name, "is not allowed to push the event", e.Event)
+		response.WriteError(res, response.Error{Err: "Event not allowed " + e.Event}, http.StatusForbidden)
+		return
+	}
+
+	be := &bus.Event{Source: bus.SourceIngest, AppContext: appCtx, Notification: e.Notification, Users: e.Users}
+	if err := bus.Check(be); err != nil {
+		appCtx.Log.Error("error while validating the ingest event of source", name, err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//sending response
+	response.Write(res, response.Message{Message: "ingesting the event"})
+
+	//publishing the event to the users
+	appCtx.Log.Info("ingesting event", e.Event, "from source", name, "for", len(e.Users), "users")
+	if err := bus.Publish(be); err != nil {
+		appCtx.Log.Error("error while publishing the ingest event", e.Event, "of source", name, err.Error())
+	}
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:         "v1",
+		HandlerFunc:     Ingest,
+		Pattern:         "/ingest",
+		Unauthenticated: true,
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+)
+
+//ingest posts the signed body of the source to the ingest handler and returns the status code
+func ingest(source, body, ts, sig string) int {
+	req := signedRequest(http.MethodPost, "/v1/ingest", body, ts, sig)
+	req.Header.Set(IngestSourceHeader, source)
+	res := httptest.NewRecorder()
+	Ingest(context.WithValue(context.Background(), AppContextKey, config.NewAppContext(log.NewLogger(0), 0)), res, req)
+	return res.Code
+}
+
+func TestVerifyIngest(t *testing.T) {
+	src := config.IngestSource{Key: "etl-key"}
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-config.IngestMaxSkew-time.Minute).Unix(), 10)
+	body := `{"event":"dataset-ready","users":[1]}`
+
+	cases := []struct {
+		name  string
+		ts    string
+		sig   string
+		valid bool
+	}{
+		{"valid", ts, "sha256=" + sign("etl-key", ts, "", body), true},
+		{"other key", ts, "sha256=" + sign("other-key", ts, "", body), false},
+		{"other body", ts, "sha256=" + sign("etl-key", ts, "", body+" "), false},
+		{"stale timestamp", stale, "sha256=" + sign("etl-key", stale, "", body), false},
+		{"timestamp not a number", "now", "sha256=" + sign("etl-key", "now", "", body), false},
+		{"bad hex", ts, "sha256=not-hex", false},
+		{"odd hex", ts, "sha256=abc", false},
+	}
+	for _, c := range cases {
+		req := signedRequest(http.MethodPost, "/v1/ingest", body, c.ts, c.sig)
+		if got := verifyIngest(src, req, []byte(body)); got != c.valid {
+			t.Errorf("%s: expected the signature to be valid %t. got %t", c.name, c.valid, got)
+		}
+	}
+}
+
+func TestIngestRateLimitBeforeBody(t *testing.T) {
+	config.IngestSources["ingest-test"] = config.IngestSource{Key: "ingest-key", Rate: 0.001, Burst: 1}
+	defer delete(config.IngestSources, "ingest-test")
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	cases := []struct {
+		name   string
+		source string
+		sig    string
+		code   int
+	}{
+		{"unknown source", "ingest-unknown", "sha256=00", http.StatusUnauthorized},
+		{"forged signature within the burst", "ingest-test", "sha256=00", http.StatusUnauthorized},
+		{"forged signature beyond the burst is limited before the body is verified", "ingest-test", "sha256=00", http.StatusTooManyRequests},
+	}
+	for _, c := range cases {
+		if code := ingest(c.source, `{"event":"x"}`, ts, c.sig); code != c.code {
+			t.Errorf("%s: expected %d. got %d", c.name, c.code, code)
+		}
+	}
+}
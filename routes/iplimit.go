@@ -0,0 +1,35 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/limiter"
+)
+
+/*
+ * This file contains the rate limit on the http requests of an ip. The requests are limited before
+ * they take an app context from the pool, so a single misbehaving client can't exhaust the pool for everyone.
+ * The ip of the client is taken from the X-Forwarded-For header only if the request came from a trusted proxy.
+ * The limiters of the least recently seen ips are evicted beyond the max size, so a flood of new ips can't reset
+ * the limits of the ips seen recently.
+ */
+
+//ipLimiters has the rate limiters of the ips
+var ipLimiters = limiter.NewBuckets(config.IPRateLimit, config.IPRateBurst, config.IPLimitersSize)
+
+//allowIP reports whether the ip can make a request now. If not, the time after which it can retry is returned
+func allowIP(ip string) (time.Duration, bool) {
+	if config.IPRateLimit <= 0 {
+		return 0, true
+	}
+	b := ipLimiters.Get(ip)
+	if b.Allow() {
+		return 0, true
+	}
+	return b.Delay(), false
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/json"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/journal"
+	"github.com/cuttle-ai/websockets/log"
+)
+
+/*
+ * This file contains the write ahead journaling of the accepted notifications.
+ * The events targeting users are journaled as they enter the enrich stage, before they can be held or delivered,
+ * and marked done once their copies queued to the connections by the deliver stage are emitted or dropped.
+ * The events without any live connection are done once they are kept for the offline users.
+ * The events held by the pause are done as they are queued in the store.
+ * The live events aren't journaled as the connections they target are gone after a crash.
+ * The events left pending by a crash are published again on the startup.
+ */
+
+//notificationJournal is the journal of the accepted notifications. It is nil if the journaling is disabled
+var notificationJournal *journal.Journal
+
+//OpenJournal opens the journal of the accepted notifications and publishes the ones left pending by the last run.
+//It does nothing if the journaling is disabled
+func OpenJournal() error {
+	/*
+	 * We will open the journal
+	 * Then we will publish the pending events
+	 */
+	//opening the journal
+	if len(config.JournalPath) == 0 {
+		return nil
+	}
+	j, pending, err := journal.Open(config.JournalPath, config.JournalSync, config.JournalMaxSize)
+	if err != nil {
+		return err
+	}
+	notificationJournal = j
+
+	//publishing the pending events
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	replayed := 0
+	for _, r := range pending {
+		h := heldEvent{}
+		if err := json.Unmarshal(r.Data, &h); err != nil {
+			log.Error("error while decoding the journaled event", r.ID, err.Error())
+			j.Done(r.ID)
+			continue
+		}
+		e := &bus.Event{Source: h.Source, AppContext: appCtx, Notification: h.Notification, Users: h.Users, Room: h.Room, Live: h.Live, Journal: r.ID}
+		if err := bus.Publish(e); err != nil {
+			log.Error("error while publishing the journaled event", h.Notification.Event, err.Error())
+			continue
+		}
+		replayed++
+	}
+	if len(pending) != 0 {
+		log.Info("published", replayed, "of the", len(pending), "events pending in the journal")
+	}
+	return nil
+}
+
+//CloseJournal closes the journal of the accepted notifications
+func CloseJournal() error {
+	if notificationJournal == nil {
+		return nil
+	}
+	return notificationJournal.Close()
+}
+
+//journalEvent appends the event targeting users to the journal before it is held or delivered.
+//The event is still delivered if it couldn't be journaled
+func journalEvent(e *bus.Event) error {
+	if notificationJournal == nil || e.Journal != 0 || e.Live || e.Conns != nil || len(e.Users) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(heldEvent{Source: e.Source, Notification: e.Notification, Users: e.Users, Room: e.Room, Live: e.Live})
+	if err == nil {
+		e.Journal, err = notificationJournal.Append(b)
+	}
+	if err != nil {
+		e.AppContext.Log.Error("error while journaling the event", e.Notification.Event, err.Error())
+	}
+	return nil
+}
+
+//trackJournal tracks the copies of the journaled event queued to the connections by the deliver stage
+//so that it is marked done only once they are emitted or dropped
+func trackJournal(e *bus.Event) error {
+	if notificationJournal == nil || e.Journal == 0 {
+		return nil
+	}
+	id, event := e.Journal, e.Notification.Event
+	e.Notification = e.Notification.Track(delivery.NewTracker(func() { doneJournal(id, event) }))
+	return nil
+}
+
+//finishJournal marks the journaled event done once it leaves the pipeline. The event delivered by the deliver stage
+//is marked done once its copies queued to the connections are emitted or dropped. The halted events are dropped or
+//held in the store. The failed events are done too as the failure is logged by the publisher and publishing them
+//again would fail the same way
+func finishJournal(e *bus.Event, err error) {
+	if notificationJournal == nil || e.Journal == 0 {
+		return
+	}
+	if t := e.Notification.Tracker(); t != nil && err == nil {
+		t.Release()
+		return
+	}
+	doneJournal(e.Journal, e.Notification.Event)
+}
+
+//doneJournal marks the journaled event with the id done
+func doneJournal(id uint64, event string) {
+	if err := notificationJournal.Done(id); err != nil {
+		log.Error("error while marking the journaled event", event, "done", err.Error())
+	}
+}
+
+func init() {
+	bus.Use(bus.Enrich, journalEvent)
+	bus.Use(bus.Deliver, trackJournal)
+	bus.OnFinish(finishJournal)
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the maintenance mode of the service.
+ * The maintenance mode is kept in the store so that every instance sees it. Instances reload it periodically in the background
+ * and the requests are served with the mode loaded last.
+ * In the maintenance mode, the new websocket connections are rejected, the connected clients are sent the maintenance event
+ * and the notifications sent are queued in the store. They are delivered once the maintenance ends unless their ttl has passed.
+ */
+
+//MaintenanceState is the maintenance mode of the service
+type MaintenanceState struct {
+	//Enabled is true if the service is in the maintenance mode
+	Enabled bool `json:"enabled"`
+	//Message shown to the clients
+	Message string `json:"message,omitempty"`
+	//Until is the expected end of the maintenance. The clients are told to retry after it
+	Until *time.Time `json:"until,omitempty"`
+	//CreatedBy is the id of the admin who started the maintenance. 0 if it was started by the config
+	CreatedBy uint `json:"createdBy"`
+	//CreatedAt is the time at which the maintenance started
+	CreatedAt time.Time `json:"createdAt"`
+	//Queued is the no. of notifications queued for the delivery after the maintenance
+	Queued int `json:"queued"`
+}
+
+const (
+	//maintenanceKey is the store key of the maintenance mode
+	maintenanceKey = "maintenance"
+	//maintenanceQueue is the store queue of the notifications sent in the maintenance mode
+	maintenanceQueue = "maintenance-queue"
+	//maintenanceRetryAfter is the retry hint of the rejected connections when the end of the maintenance isn't known
+	maintenanceRetryAfter = time.Minute
+)
+
+//errMaintenanceQueueFull is returned when the notification couldn't be queued in the maintenance mode
+var errMaintenanceQueueFull = errors.New("maintenance queue is full or unavailable")
+
+var (
+	//maintenanceState is the maintenance mode loaded from the store
+	maintenanceState MaintenanceState
+	//maintenanceLock is the lock for the maintenance mode. The store isn't called under it
+	maintenanceLock sync.RWMutex
+)
+
+//loadMaintenance loads the maintenance mode from the store and swaps it in.
+//It reports whether the maintenance mode was turned on or off
+func loadMaintenance() (MaintenanceState, bool, error) {
+	m := MaintenanceState{}
+	b, err := store.Default.Get(maintenanceKey)
+	if err != nil && err != store.ErrNotFound {
+		return m, false, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return m, false, err
+		}
+	}
+	maintenanceLock.Lock()
+	changed := m.Enabled != maintenanceState.Enabled
+	maintenanceState = m
+	maintenanceLock.Unlock()
+	return m, changed, nil
+}
+
+//maintenance returns the maintenance mode of the service loaded last
+func maintenance() MaintenanceState {
+	maintenanceLock.RLock()
+	defer maintenanceLock.RUnlock()
+	return maintenanceState
+}
+
+//retryAfter returns the time after which the clients can retry
+func (m MaintenanceState) retryAfter(now time.Time) time.Duration {
+	if m.Until != nil && m.Until.After(now) {
+		return m.Until.Sub(now)
+	}
+	return maintenanceRetryAfter
+}
+
+//holdMaintenance queues the event for the delivery after the maintenance. The audit record with the id is finished
+//once the event is published
+func holdMaintenance(e *bus.Event, auditID uint64) error {
+	if n, err := store.Default.Len(maintenanceQueue); err != nil || n >= config.MaintenanceQueueMax {
+		return errMaintenanceQueueFull
+	}
+	h := hold(e)
+	h.Audit = auditID
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return store.Default.Push(maintenanceQueue, b, config.ReplayRetention)
+}
+
+//notifyMaintenance sends the maintenance event to the websocket connections of the instance
+func notifyMaintenance(m MaintenanceState) {
+	appCtxReq := AppContextRequest{
+		Type: FetchAllWs,
+		Out:  make(chan AppContextRequest),
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+	n := delivery.Notification{Lane: delivery.AlertLane, Priority: delivery.PrioritySystem}
+	n.Event = config.MaintenanceEvent
+	n.Payload = m
+	sent := 0
+	for _, conns := range resCtx.UsersWsConns {
+		for _, conn := range conns {
+			if err := delivery.Send(conn, n); err != nil {
+				log.Error("error while sending the maintenance event to the connection", conn.ID(), err.Error())
+				continue
+			}
+			sent++
+		}
+	}
+	log.Info("sent the maintenance event with enabled", m.Enabled, "to", sent, "connections")
+}
+
+//endMaintenance publishes the notifications queued in the maintenance mode. The queue is drained again after the refresh
+//interval for the notifications queued by the instances which hadn't seen the end of the maintenance yet
+func endMaintenance() {
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	published := 0
+	for round := 0; round < 2; round++ {
+		if round != 0 {
+			time.Sleep(config.MaintenanceRefresh)
+		}
+		n, err := publishHeld(appCtx, maintenanceQueue)
+		published += n
+		if err != nil {
+			log.Error("error while getting the notifications queued in the maintenance mode", err.Error())
+			return
+		}
+	}
+	log.Info("published", published, "notifications queued in the maintenance mode")
+}
+
+//refreshMaintenance reloads the maintenance mode. When it is turned on or off, the connections
+//of the instance are sent the maintenance event and the queued notifications are published once it ends
+func refreshMaintenance() error {
+	m, changed, err := loadMaintenance()
+	if err != nil || !changed {
+		return err
+	}
+	notifyMaintenance(m)
+	if !m.Enabled {
+		go endMaintenance()
+	}
+	return nil
+}
+
+//setMaintenance saves the maintenance mode in the store and applies it to the instance
+func setMaintenance(m MaintenanceState) error {
+	if m.Enabled {
+		b, err := json.Marshal(m)
+		if err == nil {
+			err = store.Default.Set(maintenanceKey, b, 0)
+		}
+		if err != nil {
+			return err
+		}
+	} else if err := store.Default.Delete(maintenanceKey); err != nil {
+		return err
+	}
+	maintenanceLock.Lock()
+	changed := m.Enabled != maintenanceState.Enabled
+	maintenanceState = m
+	maintenanceLock.Unlock()
+	if changed {
+		go func() {
+			notifyMaintenance(m)
+			if !m.Enabled {
+				endMaintenance()
+			}
+		}()
+	}
+	return nil
+}
+
+//Maintenance returns the maintenance mode with GET, puts the service into the maintenance mode with POST
+//and ends it with DELETE. The notifications queued in the maintenance mode are delivered once it ends
+func Maintenance(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	switch req.Method {
+	case http.MethodGet:
+		m := maintenance()
+		m.Queued, _ = store.Default.Len(maintenanceQueue)
+		response.Write(res, response.Message{Message: "maintenance mode", Data: m})
+	case http.MethodPost:
+		m := &MaintenanceState{}
+		if err := decode(req, m); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the maintenance request", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if len(m.Message) == 0 {
+			m.Message = config.MaintenanceMessage
+		}
+		m.Enabled, m.CreatedBy, m.CreatedAt, m.Queued = true, appCtx.Session.User.ID, time.Now(), 0
+		if err := setMaintenance(*m); err != nil {
+			appCtx.Log.Error("error while starting the maintenance mode", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't start the maintenance mode"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: maintenance mode started by admin", appCtx.Session.User.ID, "with the message", m.Message)
+		response.Write(res, response.Message{Message: "maintenance mode started", Data: m})
+	case http.MethodDelete:
+		if !maintenance().Enabled {
+			response.WriteError(res, response.Error{Err: "Service is not in the maintenance mode"}, http.StatusConflict)
+			return
+		}
+		if err := setMaintenance(MaintenanceState{}); err != nil {
+			appCtx.Log.Error("error while ending the maintenance mode", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't end the maintenance mode"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: maintenance mode ended by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "maintenance mode ended"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	if config.Maintenance {
+		b, _ := json.Marshal(MaintenanceState{Enabled: true, Message: config.MaintenanceMessage, CreatedAt: time.Now()})
+		if _, err := store.Default.SetNX(maintenanceKey, b, 0); err != nil {
+			log.Error("error while starting the maintenance mode", err.Error())
+		}
+	}
+	config.Refresh("maintenance", config.MaintenanceRefresh, refreshMaintenance)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Maintenance),
+		Pattern:     "/admin/maintenance",
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/json"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/jsonpath"
+	"github.com/cuttle-ai/websockets/log"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the mapping of the messages of the external sources like the nats subjects or the postgres channels
+ * to the events. The messages are sent to the users selected from them or live to all the connections of the namespace
+ */
+
+//mapper maps the messages of a source to the events as per the mapping
+type mapper struct {
+	//source of the events. Eg. nats
+	source string
+	config.EventMapping
+	//users and payload are the compiled paths of the mapping
+	users   jsonpath.Path
+	payload jsonpath.Path
+}
+
+//newMapper compiles the paths of the mapping
+func newMapper(source string, m config.EventMapping) (*mapper, error) {
+	mp := &mapper{source: source, EventMapping: m}
+	var err error
+	if len(m.Users) != 0 {
+		if mp.users, err = jsonpath.Compile(m.Users); err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Payload) != 0 {
+		if mp.payload, err = jsonpath.Compile(m.Payload); err != nil {
+			return nil, err
+		}
+	}
+	return mp, nil
+}
+
+//event returns the bus event of the message
+func (m *mapper) event(data []byte) *bus.Event {
+	/*
+	 * We will decode the message. The messages which are not json are sent as string payloads
+	 * Then we will select the users and the payload
+	 * If the mapping has no users, we will send it live to the connections of the namespace
+	 */
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		doc = string(data)
+	}
+	e := &bus.Event{Source: m.source, AppContext: config.NewAppContext(log.NewLogger(0), 0)}
+	e.Notification.Event, e.Notification.Payload = m.Event, doc
+	if len(m.Payload) != 0 {
+		values := m.payload.Get(doc)
+		e.Notification.Payload = values
+		if len(values) == 1 {
+			e.Notification.Payload = values[0]
+		}
+	}
+	if len(m.Users) != 0 {
+		e.Users = hookUsers(m.users.Get(doc))
+		return e
+	}
+
+	//live to the namespace
+	appCtxReq := AppContextRequest{
+		Type: FetchAllWs,
+		Out:  make(chan AppContextRequest),
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+	conns := []socketio.Conn{}
+	for _, cs := range resCtx.UsersWsConns {
+		for _, conn := range cs {
+			if conn.Namespace() == m.Namespace {
+				conns = append(conns, conn)
+			}
+		}
+	}
+	e.Live, e.Conns = true, map[uint][]socketio.Conn{0: conns}
+	return e
+}
+
+//publish maps the message received from the origin, eg. the subject or the channel, and publishes it to the bus
+func (m *mapper) publish(origin string, data []byte) {
+	e := m.event(data)
+	if len(m.Users) != 0 && len(e.Users) == 0 {
+		log.Warn("no users found at", m.Users, "in the", m.source, "message of", origin)
+		return
+	}
+	if err := bus.Check(e); err != nil {
+		log.Error("error while validating the event of the", m.source, "message of", origin, err.Error())
+		return
+	}
+	if err := bus.Publish(e); err != nil {
+		log.Error("error while publishing the event", m.Event, "of the", m.source, "message of", origin, err.Error())
+	}
+}
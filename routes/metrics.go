@@ -0,0 +1,212 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the metrics endpoint exporting the metrics of the instance in the prometheus text format.
+ * It is served without the user session so that the scrapers can reach it. If the metrics token is configured,
+ * the scrapers have to pass it as the bearer token.
+ */
+
+//MetricsContentType is the content type of the prometheus text format
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+//metricsWriter writes the metrics in the prometheus text format. The help and type of a metric are written once
+type metricsWriter struct {
+	w    io.Writer
+	seen map[string]bool
+}
+
+//labelEscaper escapes the label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//header writes the help and the type of the metric if not written yet
+func (m *metricsWriter) header(name, kind, help string) {
+	if m.seen[name] {
+		return
+	}
+	m.seen[name] = true
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+//sample writes the sample of the metric with the labels given as name, value pairs
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	ls := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		ls = append(ls, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	if len(ls) != 0 {
+		name += "{" + strings.Join(ls, ",") + "}"
+	}
+	fmt.Fprintln(m.w, name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+//write writes the header and the sample of the metric
+func (m *metricsWriter) write(name, kind, help string, value float64, labels ...string) {
+	m.header(name, kind, help)
+	m.sample(name, value, labels...)
+}
+
+//writeMetrics writes the metrics of the instance
+func writeMetrics(w io.Writer) {
+	/*
+	 * We will write the usage of the app context pool and the connections
+	 * We will write the connections of each namespace
+	 * We will write the delivery counters and the emit latency
+	 * Then we will write the metrics of the pipeline stages and the queues
+	 */
+	m := &metricsWriter{w: w, seen: map[string]bool{}}
+
+	//app context pool and connections
+	statsReq := AppContextRequest{Type: FetchStats, Out: make(chan AppContextRequest)}
+	go SendRequest(AppContextRequestChan, statsReq)
+	stats := (<-statsReq.Out).Stats
+	m.write("websockets_app_context_pool_size", "gauge", "Max no. of app contexts in the pool.", float64(stats.Size))
+	m.write("websockets_app_context_pool_active", "gauge", "No. of app contexts in use.", float64(stats.Active))
+	m.write("websockets_app_context_pool_waiting", "gauge", "No. of requests waiting for an app context as the pool is exhausted.", float64(stats.Waiting))
+	m.write("websockets_app_context_reserved", "gauge", "No. of app context slots reserved by the internal services.", float64(stats.Reserved))
+	m.write("websockets_app_context_reserved_active", "gauge", "No. of reserved app contexts in use.", float64(stats.ReservedActive))
+	m.write("websockets_connections", "gauge", "No. of websocket connections.", float64(stats.Connections))
+	m.write("websockets_connected_users", "gauge", "No. of users having a websocket connection.", float64(stats.Users))
+	standby := 0.0
+	if config.Standby() {
+		standby = 1
+	}
+	m.write("websockets_standby", "gauge", "1 if the instance is a standby yet to be promoted.", standby)
+	waiting, shed := HandshakeQueueStats()
+	m.write("websockets_handshake_queue", "gauge", "No. of websocket handshakes waiting for the admission.", float64(waiting))
+	m.write("websockets_handshakes_shed_total", "counter", "No. of websocket handshakes shed as the admission queue was full.", float64(shed))
+	guests, maxGuests := GuestStats()
+	m.write("websockets_guest_contexts", "gauge", "No. of guest app contexts in use.", float64(guests))
+	m.write("websockets_guest_contexts_max", "gauge", "Max no. of guest app contexts.", float64(maxGuests))
+
+	//connections of each namespace
+	connsReq := AppContextRequest{Type: FetchAllWs, Out: make(chan AppContextRequest)}
+	go SendRequest(AppContextRequestChan, connsReq)
+	byNamespace := map[string]int{}
+	for _, conns := range (<-connsReq.Out).UsersWsConns {
+		for _, conn := range conns {
+			byNamespace[conn.Namespace()]++
+		}
+	}
+	for ns := range config.NamespaceQuotas {
+		if _, ok := byNamespace[ns]; !ok {
+			byNamespace[ns] = 0
+		}
+	}
+	for _, ns := range sortedKeys(byNamespace) {
+		m.write("websockets_namespace_connections", "gauge", "No. of websocket connections of the namespace.", float64(byNamespace[ns]), "namespace", ns)
+	}
+	for ns, q := range config.NamespaceQuotas {
+		m.write("websockets_namespace_max_connections", "gauge", "Max no. of connections allowed to the namespace. 0 is unlimited.", float64(q.MaxConnections), "namespace", ns)
+	}
+	restarts, _ := config.WebSocketsHealth()
+	m.write("websockets_server_restarts_total", "counter", "No. of times the websockets server was restarted.", float64(restarts))
+	for _, p := range config.Panics() {
+		m.write("websockets_panics_total", "counter", "No. of panics recovered in the handler.", float64(p.Count), "handler", p.Handler)
+	}
+	for _, w := range config.Workers() {
+		m.write("websockets_workers_running", "gauge", "No. of workers of the pool running.", float64(w.Running), "pool", w.Name)
+		m.write("websockets_worker_restarts_total", "counter", "No. of times a worker of the pool was restarted after a panic.", float64(w.Restarts), "pool", w.Name)
+	}
+
+	//delivery counters and emit latency
+	counters := delivery.Counters()
+	m.write("websockets_notifications_sent_total", "counter", "No. of notification copies emitted to the connections.", float64(counters["sent"]))
+	m.write("websockets_notifications_failed_total", "counter", "No. of notification copies which couldn't be emitted.", float64(counters["failed"]))
+	for _, k := range sortedCounterKeys(counters) {
+		if k == "sent" || k == "failed" {
+			continue
+		}
+		m.write("websockets_delivery_"+k+"_total", "counter", "Delivery counter "+k+".", float64(counters[k]))
+	}
+	counts, sum := delivery.EmitLatency()
+	m.header("websockets_emit_latency_seconds", "histogram", "Time taken to emit a notification to a connection.")
+	var cumulative uint64
+	for i, b := range delivery.EmitLatencyBuckets {
+		cumulative += counts[i]
+		m.sample("websockets_emit_latency_seconds_bucket", float64(cumulative), "le", strconv.FormatFloat(b, 'g', -1, 64))
+	}
+	cumulative += counts[len(counts)-1]
+	m.sample("websockets_emit_latency_seconds_bucket", float64(cumulative), "le", "+Inf")
+	m.sample("websockets_emit_latency_seconds_sum", sum)
+	m.sample("websockets_emit_latency_seconds_count", float64(cumulative))
+
+	//pipeline stages and queues
+	for _, s := range bus.Stats() {
+		m.write("websockets_pipeline_processed_total", "counter", "No. of events processed by the pipeline stage.", float64(s.Processed), "stage", s.Stage)
+	}
+	for _, s := range bus.Stats() {
+		m.write("websockets_pipeline_errors_total", "counter", "No. of events failed by the pipeline stage.", float64(s.Errors), "stage", s.Stage)
+	}
+	for _, s := range bus.Stats() {
+		m.write("websockets_pipeline_in_flight", "gauge", "No. of events in the pipeline stage.", float64(s.InFlight), "stage", s.Stage)
+	}
+	for _, q := range bus.Queues() {
+		m.write("websockets_queue_depth", "gauge", "No. of items in the queue.", float64(q.Depth), "queue", q.Name)
+	}
+	for _, q := range bus.Queues() {
+		m.write("websockets_queue_capacity", "gauge", "Capacity of the queue.", float64(q.Capacity), "queue", q.Name)
+	}
+	m.write("websockets_pipeline_alarms", "gauge", "No. of active alarms of the pipeline.", float64(len(bus.Alarms())))
+}
+
+//sortedKeys returns the keys of the map in the sorted order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//sortedCounterKeys returns the keys of the counters in the sorted order
+func sortedCounterKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//Metrics writes the metrics of the instance in the prometheus text format
+func Metrics(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	if len(config.MetricsToken) != 0 {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.MetricsToken)) != 1 {
+			response.WriteError(res, response.Error{Err: "Invalid metrics token"}, http.StatusUnauthorized)
+			return
+		}
+	}
+	res.Header().Set("Content-Type", MetricsContentType)
+	writeMetrics(res)
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:         "v1",
+		HandlerFunc:     Metrics,
+		Pattern:         "/metrics",
+		Unauthenticated: true,
+	})
+}
@@ -0,0 +1,371 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/redact"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the mirroring of the events of a user to the connections of a developer.
+ * Engineers use it to reproduce the realtime issues reported by a customer against the production traffic.
+ * An admin requests the mirror for a developer, who has to be an admin too, with the reason.
+ * The mirror becomes active only after the user consents to it, unless the consent is disabled,
+ * and the user can revoke it any time. It expires after its ttl.
+ * The mirrored copies are emitted as the original events with the sensitive fields redacted. They aren't recorded,
+ * acknowledged or escalated for the developer. The mirrors are kept in the store so that every instance sees them.
+ * Instances reload the active mirrors in the background periodically.
+ * Every request, consent, revoke and stop is audit logged.
+ */
+
+const (
+	//MirrorPending is the status of a mirror waiting for the consent of the user
+	MirrorPending = "pending"
+	//MirrorActive is the status of a mirror whose events are mirrored
+	MirrorActive = "active"
+)
+
+//mirrorPrefix is the store key prefix of the mirrors
+const mirrorPrefix = "mirror/"
+
+//Mirror is the mirroring of the events of a user to a developer
+type Mirror struct {
+	//ID of the mirror
+	ID string `json:"id"`
+	//UserID is the id of the user whose events are mirrored
+	UserID uint `json:"userId"`
+	//DeveloperID is the id of the developer to whose connections the events are mirrored
+	DeveloperID uint `json:"developerId"`
+	//Reason of the mirror like the support ticket. Shown to the user while asking for the consent
+	Reason string `json:"reason"`
+	//TTLMinutes is the time in minutes till which the mirror stays active after the consent. Defaults to the max duration
+	TTLMinutes int64 `json:"ttlMinutes"`
+	//Status of the mirror
+	Status string `json:"status"`
+	//CreatedBy is the id of the admin who requested the mirror
+	CreatedBy uint `json:"createdBy"`
+	//CreatedAt is the time at which the mirror was requested
+	CreatedAt time.Time `json:"createdAt"`
+	//ExpiresAt is the time at which the active mirror expires
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+//ttl returns the time till which the mirror stays active
+func (m Mirror) ttl() time.Duration {
+	t := time.Duration(m.TTLMinutes) * time.Minute
+	if t <= 0 || t > config.MirrorMaxDuration {
+		t = config.MirrorMaxDuration
+	}
+	return t
+}
+
+//activate activates the mirror from now
+func (m *Mirror) activate(now time.Time) {
+	e := now.Add(m.ttl())
+	m.Status, m.ExpiresAt = MirrorActive, &e
+}
+
+var (
+	//mirrors has the active mirrors loaded from the store mapped by the mirrored user
+	mirrors = map[uint][]Mirror{}
+	//mirrorsLock is the lock for the mirrors. The store isn't called under it
+	mirrorsLock sync.RWMutex
+)
+
+//saveMirror saves the mirror in the store. Pending mirrors are kept for the max duration
+//and the active ones till they expire
+func saveMirror(m Mirror) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	ttl := config.MirrorMaxDuration
+	if m.ExpiresAt != nil {
+		ttl = time.Until(*m.ExpiresAt)
+	}
+	if err := store.Default.Set(mirrorPrefix+m.ID, b, ttl); err != nil {
+		return err
+	}
+	reloadMirrors()
+	return nil
+}
+
+//getMirror returns the mirror with the id from the store
+func getMirror(id string) (Mirror, error) {
+	m := Mirror{}
+	b, err := store.Default.Get(mirrorPrefix + id)
+	if err != nil {
+		return m, err
+	}
+	return m, json.Unmarshal(b, &m)
+}
+
+//deleteMirror deletes the mirror from the store
+func deleteMirror(id string) error {
+	if err := store.Default.Delete(mirrorPrefix + id); err != nil {
+		return err
+	}
+	reloadMirrors()
+	return nil
+}
+
+//listMirrors returns the mirrors in the store
+func listMirrors() ([]Mirror, error) {
+	ms, err := store.Default.Scan(mirrorPrefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Mirror, 0, len(ms))
+	for _, b := range ms {
+		m := Mirror{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			log.Error("error while decoding the mirror", err.Error())
+			continue
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+//loadMirrors loads the active mirrors from the store and swaps them in
+func loadMirrors() error {
+	ms, err := listMirrors()
+	if err != nil {
+		return err
+	}
+	loaded := map[uint][]Mirror{}
+	for _, m := range ms {
+		if m.Status == MirrorActive {
+			loaded[m.UserID] = append(loaded[m.UserID], m)
+		}
+	}
+	mirrorsLock.Lock()
+	mirrors = loaded
+	mirrorsLock.Unlock()
+	return nil
+}
+
+//reloadMirrors reloads the mirrors changed by the instance so that it doesn't wait for the refresh to see them
+func reloadMirrors() {
+	if err := loadMirrors(); err != nil {
+		log.Error("error while reloading the mirrors", err.Error())
+	}
+}
+
+//activeMirrors returns the active mirrors of the user from the mirrors loaded last
+func activeMirrors(userID uint, now time.Time) []Mirror {
+	mirrorsLock.RLock()
+	defer mirrorsLock.RUnlock()
+	result := []Mirror{}
+	for _, m := range mirrors[userID] {
+		if m.ExpiresAt != nil && now.Before(*m.ExpiresAt) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+//mirrorEvent emits the redacted copy of the notification of the user to the connections of the developers mirroring the user
+func mirrorEvent(e *bus.Event, userID uint, n delivery.Notification) {
+	/*
+	 * We will get the active mirrors of the user
+	 * Then we will strip the delivery options and redact the copy
+	 * Then we will send it to the connections of the developers
+	 */
+	ms := activeMirrors(userID, time.Now())
+	if len(ms) == 0 {
+		return
+	}
+
+	//stripping and redacting the copy
+	c := n
+	c.Seq, c.Ack, c.ID, c.Callback, c.Category = 0, false, "", "", ""
+	c.Payload = redact.Fields(n.Payload, config.ImpersonationRedactFields)
+
+	//sending to the developers
+	for _, m := range ms {
+		appCtxReq := AppContextRequest{
+			Type:       FetchWs,
+			Out:        make(chan AppContextRequest),
+			AppContext: e.AppContext,
+			UserID:     m.DeveloperID,
+		}
+		go SendRequest(AppContextRequestChan, appCtxReq)
+		resCtx := <-appCtxReq.Out
+		for _, conn := range resCtx.WsConns {
+			if err := delivery.Send(conn, c); err != nil {
+				e.AppContext.Log.Warn("error while mirroring the notification", n.Event, "of user", userID, "to the developer connection", conn.ID(), err.Error())
+			}
+		}
+	}
+}
+
+//newID returns a random id for the mirrors and the announcements
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//Mirrors requests a mirror with POST, lists the mirrors with GET
+//and stops the mirror given in the id query param with DELETE
+func Mirrors(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	id := req.URL.Query().Get("id")
+
+	switch req.Method {
+	case http.MethodPost:
+		m := &Mirror{}
+		if err := decode(req, m); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the mirror request", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if m.UserID == 0 || m.DeveloperID == 0 || m.UserID == m.DeveloperID || len(m.Reason) == 0 {
+			response.WriteError(res, response.Error{Err: "Invalid Params userId, a different developerId and reason are required"}, http.StatusBadRequest)
+			return
+		}
+		if !config.IsAdmin(m.DeveloperID) {
+			response.WriteError(res, response.Error{Err: "Invalid Params the developer has to be an admin"}, http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		m.ID, m.Status, m.CreatedBy, m.CreatedAt, m.ExpiresAt = newID(), MirrorPending, appCtx.Session.User.ID, now, nil
+		if !config.MirrorRequireConsent {
+			m.activate(now)
+		}
+		if err := saveMirror(*m); err != nil {
+			appCtx.Log.Error("error while saving the mirror of user", m.UserID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't request the mirror"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: mirror", m.ID, "of user", m.UserID, "to developer", m.DeveloperID, "requested by admin", appCtx.Session.User.ID,
+			"with the reason", m.Reason, "in the status", m.Status)
+		response.Write(res, response.Message{Message: "mirror requested", Data: m})
+	case http.MethodGet:
+		ms, err := listMirrors()
+		if err != nil {
+			appCtx.Log.Error("error while listing the mirrors", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't list the mirrors"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "mirrors", Data: ms})
+	case http.MethodDelete:
+		if _, err := getMirror(id); err != nil {
+			response.WriteError(res, response.Error{Err: "Couldn't find the mirror " + id}, http.StatusNotFound)
+			return
+		}
+		if err := deleteMirror(id); err != nil {
+			appCtx.Log.Error("error while stopping the mirror", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't stop the mirror"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: mirror", id, "stopped by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "mirror stopped"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+//MirrorConsent lets the user list the mirrors of their events with GET, consent to the mirror given in the id query param
+//with POST and revoke it with DELETE
+func MirrorConsent(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * We will list the mirrors of the user for GET
+	 * Else we will get the mirror of the user
+	 * Then we will consent or revoke it as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	userID := appCtx.Session.User.ID
+
+	//listing the mirrors of the user
+	if req.Method == http.MethodGet {
+		ms, err := listMirrors()
+		if err != nil {
+			appCtx.Log.Error("error while listing the mirrors of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't list the mirrors"}, http.StatusInternalServerError)
+			return
+		}
+		result := []Mirror{}
+		for _, m := range ms {
+			if m.UserID == userID {
+				result = append(result, m)
+			}
+		}
+		response.Write(res, response.Message{Message: "mirrors", Data: result})
+		return
+	}
+
+	//getting the mirror
+	id := req.URL.Query().Get("id")
+	m, err := getMirror(id)
+	if err != nil || m.UserID != userID {
+		response.WriteError(res, response.Error{Err: "Couldn't find the mirror " + id}, http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		if m.Status == MirrorActive {
+			response.Write(res, response.Message{Message: "mirror already active", Data: m})
+			return
+		}
+		m.activate(time.Now())
+		if err := saveMirror(m); err != nil {
+			appCtx.Log.Error("error while activating the mirror", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't activate the mirror"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: mirror", id, "of user", userID, "to developer", m.DeveloperID, "consented by the user till", m.ExpiresAt)
+		response.Write(res, response.Message{Message: "mirror active", Data: m})
+	case http.MethodDelete:
+		if err := deleteMirror(id); err != nil {
+			appCtx.Log.Error("error while revoking the mirror", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't revoke the mirror"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: mirror", id, "of user", userID, "to developer", m.DeveloperID, "revoked by the user")
+		response.Write(res, response.Message{Message: "mirror revoked"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	config.Refresh("mirrors", config.MirrorRefresh, loadMirrors)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Mirrors),
+		Pattern:     "/admin/mirrors",
+	})
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: MirrorConsent,
+		Pattern:     "/mirrors/consent",
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build full && !minimal
+// +build full,!minimal
+
+package routes
+
+import (
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/push"
+	"github.com/cuttle-ai/websockets/push/apns"
+	"github.com/cuttle-ai/websockets/push/fcm"
+)
+
+/*
+ * This file contains the mobile push bridge forwarding the notifications to the devices through fcm and apns.
+ * The credentials are read from vault, or from the config when the vault is skipped.
+ * The devices register their tokens with the push devices api.
+ */
+
+//readFCMAccount reads the fcm service account json from vault. The config is used when the vault is skipped
+func readFCMAccount() (string, error) {
+	if config.SkipVault {
+		return config.FCMServiceAccount, nil
+	}
+	secret := struct {
+		ServiceAccount string `json:"serviceAccount"`
+	}{}
+	err := readVault(config.FCMPath, &secret)
+	return secret.ServiceAccount, err
+}
+
+//readAPNSKey reads the apns signing key from vault. The config is used when the vault is skipped
+func readAPNSKey() (string, error) {
+	if config.SkipVault {
+		return config.APNSKey, nil
+	}
+	secret := struct {
+		Key string `json:"key"`
+	}{}
+	err := readVault(config.APNSPath, &secret)
+	return secret.Key, err
+}
+
+//StartMobilePush registers the fcm and the apns senders of the push notifications if they are enabled
+func StartMobilePush() error {
+	/*
+	 * We will register the fcm sender
+	 * Then we will register the apns sender
+	 */
+	if config.FCM {
+		a, err := readFCMAccount()
+		if err != nil {
+			return err
+		}
+		s, err := fcm.NewSender([]byte(a), config.PushTTL)
+		if err != nil {
+			return err
+		}
+		push.Register(fcm.Provider, s)
+		config.SetCapability(config.CapabilityPushFallback, true)
+		log.Info("Sending the push notifications to the mobile devices through fcm of the project", s.Account.ProjectID)
+	}
+
+	//apns
+	if config.APNS {
+		k, err := readAPNSKey()
+		if err != nil {
+			return err
+		}
+		s, err := apns.NewSender([]byte(k), config.APNSKeyID, config.APNSTeamID, config.APNSTopic, config.APNSSandbox, config.PushTTL)
+		if err != nil {
+			return err
+		}
+		push.Register(apns.Provider, s)
+		config.SetCapability(config.CapabilityPushFallback, true)
+		log.Info("Sending the push notifications to the apple devices through apns for the app", config.APNSTopic)
+	}
+	return nil
+}
+
+func init() {
+	config.RegisterSubsystem(config.SubsystemMobilePush)
+}
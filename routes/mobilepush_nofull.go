@@ -0,0 +1,27 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !full || minimal
+// +build !full minimal
+
+package routes
+
+import (
+	"errors"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the mobile push bridge of the build profiles without it.
+ * The fcm and the apns senders are compiled in only by the full build profile
+ */
+
+//StartMobilePush fails if fcm or apns is enabled as the mobile push bridge is not compiled into the build
+func StartMobilePush() error {
+	if !config.FCM && !config.APNS {
+		return nil
+	}
+	return errors.New("mobile push bridge is not compiled into the " + config.BuildProfile + " build. Build with -tags full")
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build full && !minimal
+// +build full,!minimal
+
+package routes
+
+import (
+	"encoding/json"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/webhook"
+	socketio "github.com/googollee/go-socket.io"
+	"github.com/nats-io/nats.go"
+)
+
+/*
+ * This file contains the nats subject bridge. The messages of the subscribed subjects are mapped to the events
+ * sent to the users selected from them or to all the connections of the namespace of the subject.
+ * The messages sent to the users are shared by the instances in a queue group as the instance routes them to every connection.
+ * The messages sent live to the namespace are received by every instance as each one sends them only to its own connections.
+ * The events emitted by the clients are published on their subjects with the same body as the webhooks
+ */
+
+//natsQueue is the default queue group of the subjects mapped to the users
+const natsQueue = "cuttle-ai-websockets"
+
+//natsConn is the connection to the nats server. It is nil if the bridge is not started
+var natsConn *nats.Conn
+
+//subscribeNATS subscribes to the subject and publishes its messages to the bus
+func subscribeNATS(subject string, s config.NATSSubject) error {
+	/*
+	 * We will create the mapper of the subject
+	 * If the messages are sent to the users, we will subscribe in the queue group
+	 * Else every instance will subscribe to the subject
+	 */
+	m, err := newMapper(bus.SourceNATS, s.EventMapping)
+	if err != nil {
+		return err
+	}
+	handler := func(msg *nats.Msg) {
+		m.publish(msg.Subject, msg.Data)
+	}
+
+	//subscribing in the queue group
+	if len(s.Users) != 0 {
+		queue := s.Queue
+		if len(queue) == 0 {
+			queue = natsQueue
+		}
+		_, err = natsConn.QueueSubscribe(subject, queue, handler)
+		return err
+	}
+
+	//subscribing on every instance
+	_, err = natsConn.Subscribe(subject, handler)
+	return err
+}
+
+//publishNATS publishes the event emitted by the client of the connection on its nats subject
+func publishNATS(conn socketio.Conn, namespace, event string, args []interface{}) {
+	subject, ok := config.NATSClientEvents[event]
+	if !ok {
+		return
+	}
+	appCtx, ok := conn.Context().(*config.AppContext)
+	if !ok {
+		return
+	}
+	var payload interface{} = args
+	if len(args) == 0 {
+		payload = nil
+	} else if len(args) == 1 {
+		payload = args[0]
+	}
+	b, err := json.Marshal(webhook.Event{
+		Event:        event,
+		Namespace:    namespace,
+		UserID:       appCtx.Session.User.ID,
+		Tenant:       appCtx.Tenant,
+		ConnectionID: conn.ID(),
+		Payload:      payload,
+	})
+	if err != nil {
+		appCtx.Log.Error("error while encoding the client event", event, "for nats", err.Error())
+		return
+	}
+	if err := natsConn.Publish(subject, b); err != nil {
+		appCtx.Log.Error("error while publishing the client event", event, "of user", appCtx.Session.User.ID, "on the nats subject", subject, err.Error())
+	}
+}
+
+//StartNATS connects to the nats server, subscribes to the subjects and publishes the client events if the nats bridge is enabled
+func StartNATS() error {
+	/*
+	 * We will connect to the nats server
+	 * Then we will subscribe to the subjects
+	 * Then we will publish the client events
+	 */
+	if len(config.NATSURL) == 0 {
+		return nil
+	}
+	c, err := nats.Connect(config.NATSURL, nats.Name("cuttle-ai-websockets"), nats.MaxReconnects(-1))
+	if err != nil {
+		return err
+	}
+	natsConn = c
+
+	//subscribing to the subjects
+	for subject, s := range config.NATSSubjects {
+		if err := subscribeNATS(subject, s); err != nil {
+			return err
+		}
+	}
+
+	//publishing the client events
+	for event := range config.NATSClientEvents {
+		listenClientEvent(event)
+	}
+	config.OnClientEvent(publishNATS)
+	log.Info("Bridging", len(config.NATSSubjects), "nats subjects and", len(config.NATSClientEvents), "client events")
+	return nil
+}
+
+func init() {
+	config.RegisterSubsystem(config.SubsystemNATS)
+}
@@ -0,0 +1,27 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !full || minimal
+// +build !full minimal
+
+package routes
+
+import (
+	"errors"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the nats bridge of the build profiles without it.
+ * The nats bridge is compiled in only by the full build profile
+ */
+
+//StartNATS fails if the nats bridge is enabled as it is not compiled into the build
+func StartNATS() error {
+	if len(config.NATSURL) == 0 {
+		return nil
+	}
+	return errors.New("nats bridge is not compiled into the " + config.BuildProfile + " build. Build with -tags full")
+}
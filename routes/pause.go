@@ -0,0 +1,294 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/audit"
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the pausing of the delivery of an event across the cluster.
+ * Admins pause an event when a buggy producer floods it and resume it once fixed.
+ * The paused events are kept in the store so that every instance sees them. Instances reload them in the background
+ * periodically and check the events against the loaded ones.
+ * While paused, the published events are either queued in the store and delivered on resume or dropped.
+ * The events sent to the connections resolved by the publisher, like the broadcasts, can't be queued and are dropped.
+ */
+
+//Pause is the pause of the delivery of an event
+type Pause struct {
+	//Event whose delivery is paused
+	Event string `json:"event"`
+	//Mode of the pause. queue or drop
+	Mode string `json:"mode"`
+	//CreatedBy is the id of the admin who paused the event
+	CreatedBy uint `json:"createdBy"`
+	//CreatedAt is the time at which the event was paused
+	CreatedAt time.Time `json:"createdAt"`
+	//Queued is the no. of events queued for the delivery on resume
+	Queued int `json:"queued"`
+}
+
+//heldEvent is a bus event queued while its delivery is paused
+type heldEvent struct {
+	Source       string                `json:"source"`
+	Notification delivery.Notification `json:"notification"`
+	Users        []uint                `json:"users,omitempty"`
+	Room         string                `json:"room,omitempty"`
+	Live         bool                  `json:"live,omitempty"`
+	//Audit is the id of the audit record of the queued notification. It is finished once the event is published
+	Audit uint64 `json:"audit,omitempty"`
+}
+
+//errHeldExpired is the error with which the audit record of a held event expired in the queue is finished
+var errHeldExpired = errors.New("expired in the queue")
+
+//hold returns the event to be queued. The deadline of its notification is resolved from the time of acceptance
+//so that the time spent in the queue counts towards its ttl
+func hold(e *bus.Event) heldEvent {
+	h := heldEvent{Source: e.Source, Notification: e.Notification, Users: e.Users, Room: e.Room, Live: e.Live}
+	h.Notification.ResolveDeadline(time.Now())
+	return h
+}
+
+const (
+	//pausePrefix is the store key prefix of the paused events
+	pausePrefix = "pause/"
+	//pauseQueuePrefix is the store queue prefix of the events queued while paused
+	pauseQueuePrefix = "paused/"
+	//pauseDrainBatch is the no. of queued events published at once on resume
+	pauseDrainBatch = 100
+)
+
+var (
+	//pauses has the paused events loaded from the store mapped by the event
+	pauses = map[string]Pause{}
+	//pausesLock is the lock for the paused events. The store isn't called under it
+	pausesLock sync.RWMutex
+)
+
+//loadPauses loads the paused events from the store and swaps them in
+func loadPauses() error {
+	ps, err := store.Default.Scan(pausePrefix)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]Pause, len(ps))
+	for _, b := range ps {
+		p := Pause{}
+		if err := json.Unmarshal(b, &p); err != nil {
+			log.Error("error while decoding the paused event", err.Error())
+			continue
+		}
+		loaded[p.Event] = p
+	}
+	pausesLock.Lock()
+	pauses = loaded
+	pausesLock.Unlock()
+	return nil
+}
+
+//paused returns the pause of the event if its delivery is paused
+func paused(event string) (Pause, bool) {
+	pausesLock.RLock()
+	defer pausesLock.RUnlock()
+	p, ok := pauses[event]
+	return p, ok
+}
+
+//pauseEvent holds back the event if its delivery is paused. It is queued or dropped as per the mode of the pause
+func pauseEvent(e *bus.Event) error {
+	/*
+	 * We will check whether the event is paused
+	 * We will drop it if the pause drops or the event can't be queued
+	 * Then we will queue it within the max queue size
+	 */
+	p, ok := paused(e.Notification.Event)
+	if !ok {
+		return nil
+	}
+	if p.Mode == config.PauseDrop || e.Conns != nil {
+		e.AppContext.Log.Warn("dropping the event", e.Notification.Event, "from", e.Source, "as its delivery is paused")
+		return bus.ErrHalt
+	}
+
+	//queueing the event
+	q := pauseQueuePrefix + p.Event
+	if n, err := store.Default.Len(q); err != nil || n >= config.PauseQueueMax {
+		e.AppContext.Log.Warn("dropping the event", e.Notification.Event, "from", e.Source, "as the queue of the paused event is full or unavailable")
+		return bus.ErrHalt
+	}
+	b, err := json.Marshal(hold(e))
+	if err == nil {
+		err = store.Default.Push(q, b, config.ReplayRetention)
+	}
+	if err != nil {
+		e.AppContext.Log.Error("error while queueing the paused event", e.Notification.Event, err.Error())
+	}
+	return bus.ErrHalt
+}
+
+//publishHeld publishes the events held in the queue till it is empty. The events whose deadline has passed
+//in the queue are dropped and marked expired. It returns the no. of events published
+func publishHeld(appCtx *config.AppContext, q string) (int, error) {
+	published := 0
+	for {
+		bs, err := store.Default.Pop(q, pauseDrainBatch)
+		if err != nil {
+			return published, err
+		}
+		if len(bs) == 0 {
+			return published, nil
+		}
+		for _, b := range bs {
+			h := heldEvent{}
+			if err := json.Unmarshal(b, &h); err != nil {
+				log.Error("error while decoding the held event of the queue", q, err.Error())
+				continue
+			}
+			if h.Notification.Expired(time.Now()) {
+				delivery.Expire(h.Users, h.Notification, "queue "+q)
+				finishHeld(appCtx, h, 0, errHeldExpired)
+				continue
+			}
+			e := &bus.Event{Source: h.Source, AppContext: appCtx, Notification: h.Notification, Users: h.Users, Room: h.Room, Live: h.Live}
+			err := bus.Publish(e)
+			finishHeld(appCtx, h, e.Sent, err)
+			if err != nil {
+				log.Error("error while publishing the held event of the queue", q, err.Error())
+				continue
+			}
+			published++
+		}
+	}
+}
+
+//finishHeld records the result of the held event in its audit record if it has one
+func finishHeld(appCtx *config.AppContext, h heldEvent, sent int, err error) {
+	if h.Audit == 0 {
+		return
+	}
+	if aErr := audit.Finish(appCtx.Db, &audit.Record{ID: h.Audit}, sent, err); aErr != nil {
+		log.Error("error while recording the result of the held notification", h.Notification.Event, "in the audit log", aErr.Error())
+	}
+}
+
+//drainPaused publishes the events queued while the event was paused.
+//It drains again after the refresh interval to pick the events queued by the instances yet to see the resume
+func drainPaused(event string) {
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	q := pauseQueuePrefix + event
+	published := 0
+	for round := 0; round < 2; round++ {
+		if round != 0 {
+			time.Sleep(config.PauseRefresh)
+		}
+		n, err := publishHeld(appCtx, q)
+		published += n
+		if err != nil {
+			log.Error("error while getting the queued events of the resumed event", event, err.Error())
+			return
+		}
+	}
+	log.Info("published", published, "queued events of the resumed event", event)
+}
+
+//Pauses pauses the delivery of an event with POST, lists the paused events with GET
+//and resumes the event given in the event query param with DELETE
+func Pauses(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	switch req.Method {
+	case http.MethodPost:
+		p := &Pause{}
+		if err := decode(req, p); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the pause request", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if len(p.Mode) == 0 {
+			p.Mode = config.PauseMode
+		}
+		if len(p.Event) == 0 || (p.Mode != config.PauseQueue && p.Mode != config.PauseDrop) {
+			response.WriteError(res, response.Error{Err: "Invalid Params event is required and mode has to be queue or drop"}, http.StatusBadRequest)
+			return
+		}
+		p.CreatedBy, p.CreatedAt, p.Queued = appCtx.Session.User.ID, time.Now(), 0
+		b, err := json.Marshal(p)
+		if err == nil {
+			err = store.Default.Set(pausePrefix+p.Event, b, 0)
+		}
+		if err != nil {
+			appCtx.Log.Error("error while pausing the event", p.Event, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't pause the event"}, http.StatusInternalServerError)
+			return
+		}
+		pausesLock.Lock()
+		pauses[p.Event] = *p
+		pausesLock.Unlock()
+		log.Info("AUDIT: delivery of the event", p.Event, "paused in the", p.Mode, "mode by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "event paused", Data: p})
+	case http.MethodGet:
+		pausesLock.RLock()
+		result := make([]Pause, 0, len(pauses))
+		for _, p := range pauses {
+			result = append(result, p)
+		}
+		pausesLock.RUnlock()
+		for i := range result {
+			result[i].Queued, _ = store.Default.Len(pauseQueuePrefix + result[i].Event)
+		}
+		response.Write(res, response.Message{Message: "paused events", Data: result})
+	case http.MethodDelete:
+		event := req.URL.Query().Get("event")
+		if _, ok := paused(event); !ok {
+			response.WriteError(res, response.Error{Err: "Couldn't find the paused event " + event}, http.StatusNotFound)
+			return
+		}
+		if err := store.Default.Delete(pausePrefix + event); err != nil {
+			appCtx.Log.Error("error while resuming the event", event, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't resume the event"}, http.StatusInternalServerError)
+			return
+		}
+		pausesLock.Lock()
+		delete(pauses, event)
+		pausesLock.Unlock()
+		go drainPaused(event)
+		log.Info("AUDIT: delivery of the event", event, "resumed by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "event resumed"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	bus.Use(bus.Enrich, pauseEvent)
+	config.Refresh("pauses", config.PauseRefresh, loadPauses)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Pauses),
+		Pattern:     "/admin/pauses",
+	})
+}
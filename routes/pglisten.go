@@ -0,0 +1,140 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/lib/pq"
+)
+
+/*
+ * This file contains the postgres LISTEN/NOTIFY source. The channels are listened to on a dedicated connection to the
+ * database of the service and the payloads notified on them, eg. by the triggers, are mapped to the events.
+ * The channels mapped to the users are listened to only by the leader, as the instance routes the events to every connection
+ * and each instance listening to them would send the users duplicates. The channels sent live to the namespace are listened to
+ * by every instance as each one sends them only to its own connections.
+ * The listener connects again by itself when the connection breaks. The notifications sent while it was disconnected are lost.
+ */
+
+const (
+	//pgListenMinReconnect is the time waited before connecting the listener again
+	pgListenMinReconnect = 10 * time.Second
+	//pgListenMaxReconnect is the max time waited before connecting the listener again
+	pgListenMaxReconnect = time.Minute
+	//pgListenPing is the interval after which an idle listener pings the database to check the connection
+	pgListenPing = 90 * time.Second
+	//pgListenLeader is the name of the leader election for the channels mapped to the users
+	pgListenLeader = "pg-listen"
+)
+
+//pgListenEvent logs the connection events of the listener
+func pgListenEvent(ev pq.ListenerEventType, err error) {
+	switch ev {
+	case pq.ListenerEventDisconnected:
+		log.Warn("postgres listener disconnected", err)
+	case pq.ListenerEventReconnected:
+		log.Info("postgres listener connected again")
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Error("postgres listener couldn't connect", err)
+	}
+}
+
+//openPGListener connects a listener to the channels of the mappers
+func openPGListener(mappers map[string]*mapper) (*pq.Listener, error) {
+	l := pq.NewListener(config.NewDbConfig().ConnString(), pgListenMinReconnect, pgListenMaxReconnect, pgListenEvent)
+	for channel := range mappers {
+		if err := l.Listen(channel); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+//listenPG maps the notifications of the listener to the events of their channels till the done channel is closed
+func listenPG(l *pq.Listener, mappers map[string]*mapper, done <-chan struct{}) {
+	for {
+		select {
+		case n := <-l.Notify:
+			//nil is sent once the listener has connected again
+			if n == nil {
+				continue
+			}
+			m, ok := mappers[n.Channel]
+			if !ok {
+				continue
+			}
+			m.publish(n.Channel, []byte(n.Extra))
+		case <-time.After(pgListenPing):
+			go l.Ping()
+		case <-done:
+			return
+		}
+	}
+}
+
+//listenPGLeader listens to the channels mapped to the users while the instance is the leader
+func listenPGLeader(mappers map[string]*mapper) leader.Worker {
+	return func(done <-chan struct{}) {
+		l, err := openPGListener(mappers)
+		if err != nil {
+			log.Error("error while listening to the postgres channels of the users", err.Error())
+			return
+		}
+		defer l.Close()
+		log.Info("Listening to", len(mappers), "postgres channels of the users as the leader")
+		listenPG(l, mappers, done)
+	}
+}
+
+//StartPGListen listens to the postgres channels and sends their notifications to the clients if any channel is configured
+func StartPGListen() error {
+	/*
+	 * We will check whether the database is enabled
+	 * Then we will compile the mappings of the channels split by whether they are mapped to the users
+	 * Then we will listen to the live channels on this instance
+	 * Then we will listen to the channels of the users on the leader
+	 */
+	if len(config.PGListenChannels) == 0 {
+		return nil
+	}
+	if config.DB() == nil {
+		return errors.New("postgres channels can't be listened to as the database is not enabled")
+	}
+	users, live := map[string]*mapper{}, map[string]*mapper{}
+	for channel, cm := range config.PGListenChannels {
+		m, err := newMapper(bus.SourcePG, cm)
+		if err != nil {
+			return errors.New("mapping of the postgres channel " + channel + " is invalid: " + err.Error())
+		}
+		if len(cm.Users) != 0 {
+			users[channel] = m
+		} else {
+			live[channel] = m
+		}
+	}
+
+	//listening to the live channels
+	if len(live) != 0 {
+		l, err := openPGListener(live)
+		if err != nil {
+			return err
+		}
+		go listenPG(l, live, nil)
+		log.Info("Listening to", len(live), "live postgres channels")
+	}
+
+	//listening to the channels of the users
+	if len(users) != 0 {
+		leader.Run(pgListenLeader, config.DB(), listenPGLeader(users))
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/push"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/shadow"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the stages of the event bus pipeline through which all the notifications are delivered.
+ * validate checks the event, the priority, the channels, the fallback and the callback
+ * enrich traces the acceptance of the notification for the target users and sends the notifications with a fallback in the ack mode
+ * route resolves the websocket connections of the target users or the room
+ * deliver records the notification for the replay and the history, keeps the escalation, mirrors it to the shadow instance
+ * and sends it to the connections. If the user has no live connection, the notification is kept as offline
+ * and sent to the push subscriptions of the user and its fallback. The notifications requesting the push channel are always pushed
+ * The metrics of the stages, the watched queues and the active alarms are served by the admin api
+ */
+
+//systemSources are the sources of the events published by the service itself or the internal services.
+//The events of the other sources can have the system priority only if they are published by a service user
+var systemSources = map[string]bool{
+	bus.SourceRPC:        true,
+	bus.SourceBroadcast:  true,
+	bus.SourceEscalation: true,
+	bus.SourceUnread:     true,
+}
+
+//canSendSystem reports whether the publisher of the event can send the notification with the system priority
+func canSendSystem(e *bus.Event) bool {
+	if systemSources[e.Source] {
+		return true
+	}
+	return e.AppContext != nil && e.AppContext.Session.User != nil && config.IsService(e.AppContext.Session.User.ID)
+}
+
+//validateEvent checks whether the event has the event name, the targets, a known priority allowed for the publisher,
+//channels and fallback and an allowed callback
+func validateEvent(e *bus.Event) error {
+	if len(e.Notification.Event) == 0 {
+		return errors.New("event is missing")
+	}
+	if len(e.Users) == 0 && len(e.Room) == 0 && e.Conns == nil {
+		return errors.New("event has no target")
+	}
+	if err := e.Notification.Priority.Check(); err != nil {
+		return err
+	}
+	if e.Notification.Priority == delivery.PrioritySystem && !canSendSystem(e) {
+		return errors.New("system priority is reserved for the internal services")
+	}
+	if err := e.Notification.CheckChannels(); err != nil {
+		return err
+	}
+	if err := e.Notification.CheckFallback(); err != nil {
+		return err
+	}
+	return delivery.CheckCallback(e.Notification.Callback)
+}
+
+//enrichEvent traces the acceptance of the notification for the target users.
+//The notifications with a fallback are sent in the ack mode so that the fallback is used when no connection acknowledges them
+func enrichEvent(e *bus.Event) error {
+	if len(e.Notification.Fallback) != 0 {
+		e.Notification.Ack = true
+	}
+	for _, u := range e.Users {
+		delivery.Trace(delivery.StageAccepted, u, e.Notification)
+	}
+	return nil
+}
+
+//routeEvent resolves the websocket connections of the room or the target users unless given by the publisher.
+//The connections of all the target users are fetched from the registry in a single request
+func routeEvent(e *bus.Event) error {
+	if e.Conns != nil {
+		return nil
+	}
+	if len(e.Room) != 0 {
+		e.Conns = map[uint][]socketio.Conn{0: delivery.Members(e.Room)}
+		return nil
+	}
+	appCtxReq := AppContextRequest{
+		Type:       FetchBulkWs,
+		Out:        make(chan AppContextRequest),
+		AppContext: e.AppContext,
+		UserIDs:    e.Users,
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+	e.Conns = resCtx.UsersWsConns
+	return nil
+}
+
+//deliverEvent records the notification for the replay and the history of each target user and sends it to their connections
+//and the developers mirroring them.
+//The notifications of the users without a live connection in any instance are kept as offline, pushed to their devices and sent to their fallback.
+//Live events are only sent to the connections
+func deliverEvent(e *bus.Event) error {
+	if e.Live {
+		e.AppContext.Log.Info("sending live notification event", e.Notification.Event, "in lane", e.Notification.Lane, "from", e.Source, log.F("event", e.Notification.Event))
+		for u, conns := range e.Conns {
+			e.Sent += sendTo(e, conns, e.Notification)
+			if u != 0 {
+				mirrorEvent(e, u, e.Notification)
+			}
+		}
+		return nil
+	}
+	for _, u := range e.Users {
+		//recording the notification for the replay and the history
+		n := e.Notification
+		if err := delivery.Record(e.AppContext.Db, u, &n); err != nil {
+			e.AppContext.Log.Error("error while recording the notification for the replay of user", u, err.Error())
+		} else if err := delivery.AddHistory(e.AppContext.Db, u, n); err != nil {
+			e.AppContext.Log.Error("error while adding the notification", n.Seq, "to the history of user", u, err.Error())
+		}
+
+		//keeping the escalation and mirroring the notification
+		escalate(u, n)
+		shadow.Mirror(u, n)
+
+		//sending notification to the user. If the user has no live connection, it is kept for the next connect
+		e.AppContext.Log.Info("sending notification event", n.Event, "in lane", n.Lane, "to user", u, log.F("event", n.Event), log.F("targetUserId", u))
+		sent := sendTo(e, e.Conns[u], n)
+		e.Sent += sent
+		mirrorEvent(e, u, n)
+		if sent != 0 {
+			if n.Wants(delivery.ChannelPush) {
+				push.Notify(u, n)
+			}
+			continue
+		}
+		if len(e.Conns[u]) == 0 && connectedElsewhere(e, u) {
+			continue
+		}
+		if err := delivery.SaveOffline(e.AppContext.Db, u, n.Seq); err != nil {
+			e.AppContext.Log.Error("error while keeping the offline notification", n.Seq, "of user", u, err.Error())
+		}
+		push.Notify(u, n)
+		fallback(u, n)
+	}
+	return nil
+}
+
+//connectedElsewhere reports whether another instance holds a connection of the user. Such a user is sent the notification
+//by that instance, so it isn't kept offline, pushed or sent to the fallback here. If the presence can't be checked, it is taken as offline
+func connectedElsewhere(e *bus.Event, userID uint) bool {
+	ok, err := connected(userID)
+	if err != nil {
+		e.AppContext.Log.Error("error while checking whether user", userID, "is connected to another instance", err.Error())
+		return false
+	}
+	return ok
+}
+
+//sendTo sends the notification to the connections and returns the no. of connections to which it was sent
+func sendTo(e *bus.Event, conns []socketio.Conn, n delivery.Notification) int {
+	sent := 0
+	for _, conn := range conns {
+		if err := delivery.Send(conn, n); err != nil {
+			e.AppContext.Log.Error("error while sending notification event", n.Event, "from", e.Source, "to connection", conn.ID(), err.Error(), log.F("event", n.Event))
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+//PipelineStatus is the status of the pipeline
+type PipelineStatus struct {
+	//Stages has the metrics of the stages
+	Stages []bus.StageStats `json:"stages"`
+	//Queues has the metrics of the watched queues
+	Queues []bus.QueueStats `json:"queues"`
+	//Alarms has the active alarms
+	Alarms []bus.Alarm `json:"alarms"`
+}
+
+//Pipeline returns the metrics of the stages and the queues of the pipeline along with the active alarms
+func Pipeline(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	response.Write(res, response.Message{Message: "pipeline status", Data: PipelineStatus{
+		Stages: bus.Stats(),
+		Queues: bus.Queues(),
+		Alarms: bus.Alarms(),
+	}})
+}
+
+func init() {
+	bus.Use(bus.Validate, validateEvent)
+	bus.Use(bus.Enrich, enrichEvent)
+	bus.Use(bus.Route, routeEvent)
+	bus.Use(bus.Deliver, deliverEvent)
+	if len(config.ReceiptCallbackHosts) != 0 {
+		bus.WatchQueue("receipts", delivery.ReceiptQueue)
+	}
+	if shadow.Enabled() {
+		bus.WatchQueue("shadow", shadow.Queue)
+	}
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Pipeline),
+		Pattern:     "/admin/pipeline",
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/preference"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the notification preferences api of the users and their enforcement in the pipeline.
+ * The target users who have disabled or muted the category of a notification are dropped from the event.
+ * If no target user is left, the event is halted.
+ */
+
+//preferEvent drops the target users of the event who don't allow the category of its notification.
+//The unread counts are sent irrespective of the preferences
+func preferEvent(e *bus.Event) error {
+	/*
+	 * We will skip the events without the target users and the unread counts
+	 * Then we will keep the users allowing the category
+	 * If no user is left we will halt the event
+	 */
+	if len(e.Users) == 0 || e.Source == bus.SourceUnread {
+		return nil
+	}
+	category := e.Notification.Category
+	if len(category) == 0 {
+		category = e.Notification.Event
+	}
+
+	//keeping the users allowing the category
+	now := time.Now()
+	users := make([]uint, 0, len(e.Users))
+	for _, u := range e.Users {
+		ok, err := preference.Allows(u, category, now)
+		if err != nil {
+			e.AppContext.Log.Error("error while loading the notification preferences of user", u, err.Error())
+		}
+		if ok {
+			users = append(users, u)
+			continue
+		}
+		e.AppContext.Log.Info("dropping the notification", category, "for user", u, "as per the preferences", log.F("event", e.Notification.Event), log.F("targetUserId", u))
+	}
+	e.Users = users
+	if len(users) == 0 {
+		return bus.ErrHalt
+	}
+	return nil
+}
+
+//Preferences returns the notification preferences of the user with GET, saves the preference for a category
+//with POST and deletes the preference of the category query param with DELETE
+func Preferences(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	userID := appCtx.Session.User.ID
+
+	switch req.Method {
+	case http.MethodGet:
+		ps, err := preference.List(userID)
+		if err != nil {
+			appCtx.Log.Error("error while getting the notification preferences of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't get the notification preferences"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "notification preferences", Data: ps})
+	case http.MethodPost:
+		p := &preference.Preference{}
+		if err := decode(req, p); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the notification preference", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if len(p.Category) == 0 {
+			response.WriteError(res, response.Error{Err: "Invalid Params category is required"}, http.StatusBadRequest)
+			return
+		}
+		p.UserID = userID
+		saved, err := preference.Set(*p)
+		if err != nil {
+			appCtx.Log.Error("error while saving the notification preference", p.Category, "of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't save the notification preference"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "saved the notification preference", Data: saved})
+	case http.MethodDelete:
+		category := req.URL.Query().Get("category")
+		err := preference.Delete(userID, category)
+		if err == preference.ErrNotFound {
+			response.WriteError(res, response.Error{Err: "Notification preference not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while deleting the notification preference", category, "of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't delete the notification preference"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "deleted the notification preference"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	if err := preference.Init(config.DB()); err != nil {
+		log.Error("error while initing the notification preferences", err.Error())
+	}
+	bus.Use(bus.Enrich, preferEvent)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Preferences,
+		Pattern:     "/notification/preferences",
+	})
+}
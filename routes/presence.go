@@ -0,0 +1,412 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the presence of the users. A user is online while having at least one live connection in any instance.
+ * The app context routine reports when a user gets the first connection or loses the last one in the instance.
+ * The presence is kept in the shared store as a key per user with a ttl. Every instance having a connection of the user
+ * refreshes it with a heartbeat along with a key of its own. The user key is deleted once no instance has a connection
+ * of the user, else it expires with the ttl if the instances holding it are gone. So a query needs one lookup per user.
+ * Clients subscribe to the users with the subscribe-presence event and are sent the user-online and user-offline events.
+ * The changes in the instance are sent at once and the changes in the other instances are picked by the heartbeat.
+ * The presence is scoped by the tenant of the user. The instances also keep an unscoped key per user, so that the delivery
+ * can check whether any instance holds a connection of the user without knowing the tenant.
+ */
+
+const (
+	//UserOnlineEvent is emitted to the subscribers when a user gets online
+	UserOnlineEvent = "user-online"
+	//UserOfflineEvent is emitted to the subscribers when a user goes offline
+	UserOfflineEvent = "user-offline"
+	//presencePrefix is the store key prefix of the presence
+	presencePrefix = "presence/"
+	//connectedPrefix is the store key prefix of the unscoped presence of the users
+	connectedPrefix = "connected/"
+)
+
+//Presence is the presence of a user
+type Presence struct {
+	//UserID of the user
+	UserID uint `json:"userId"`
+	//Online is true if the user has a live connection
+	Online bool `json:"online"`
+	//At is the time of the change
+	At time.Time `json:"at"`
+	//tenant of the user
+	tenant string
+}
+
+//notification returns the notification with which the presence change is emitted to the subscribers
+func (p Presence) notification() delivery.Notification {
+	n := delivery.Notification{Lane: delivery.DataLane}
+	n.Event = UserOfflineEvent
+	if p.Online {
+		n.Event = UserOnlineEvent
+	}
+	n.Payload = p
+	return n
+}
+
+//PresenceQuery is the request to get the presence of the users
+type PresenceQuery struct {
+	//Users whose presence is queried
+	Users []uint `json:"users"`
+	//Tenant of the users. Only the admins and the internal services can query another tenant
+	Tenant string `json:"tenant"`
+}
+
+var (
+	//presencePending has the latest presence change of each user reported by the app context routine
+	//and not yet picked by the presence routine, mapped by the scoped user key
+	presencePending = map[string]Presence{}
+	//presencePendingLock is the lock for the pending presence changes
+	presencePendingLock sync.Mutex
+	//presenceSignal wakes the presence routine up when a presence change is pending
+	presenceSignal = make(chan struct{}, 1)
+	//presenceSubscribers has the subscribed connections mapped by the scoped user key and the connection id
+	presenceSubscribers = map[string]map[string]socketio.Conn{}
+	//presenceKnown has the presence last sent to the subscribers mapped by the scoped user key
+	presenceKnown = map[string]bool{}
+	//presenceLock is the lock for the presence subscribers and the presence known
+	presenceLock sync.RWMutex
+)
+
+//presenceKey returns the store key of the presence of the user scoped by the tenant
+func presenceKey(tenant string, userID uint) string {
+	return presencePrefix + tenant + "/" + strconv.FormatUint(uint64(userID), 10)
+}
+
+//connectedKey returns the store key of the presence of the user in any tenant
+func connectedKey(userID uint) string {
+	return connectedPrefix + strconv.FormatUint(uint64(userID), 10)
+}
+
+//instanceKey returns the store key of the presence kept by the instance under the key of the user
+func instanceKey(key string) string {
+	return key + "/" + config.ServiceDomain + ":" + config.Port
+}
+
+//presenceChanged keeps the presence change of the user pending for the presence routine. It is called by the app context
+//routine, so it never blocks. A change replaces the pending change of the user, so the latest state of every user is kept
+//without an unbounded queue and the presence routine only skips the states already replaced
+func presenceChanged(userID uint, tenant string, online bool) {
+	presencePendingLock.Lock()
+	presencePending[presenceKey(tenant, userID)] = Presence{UserID: userID, Online: online, At: time.Now(), tenant: tenant}
+	presencePendingLock.Unlock()
+	select {
+	case presenceSignal <- struct{}{}:
+	default:
+	}
+}
+
+//pendingPresence returns the pending presence changes and clears them
+func pendingPresence() map[string]Presence {
+	presencePendingLock.Lock()
+	defer presencePendingLock.Unlock()
+	ps := presencePending
+	presencePending = map[string]Presence{}
+	return ps
+}
+
+//online reports whether the users of the tenant are online in any instance
+func online(tenant string, users []uint) (map[uint]bool, error) {
+	result := make(map[uint]bool, len(users))
+	for _, u := range users {
+		_, err := store.Default.Get(presenceKey(tenant, u))
+		if err != nil && err != store.ErrNotFound {
+			return nil, err
+		}
+		result[u] = err == nil
+	}
+	return result, nil
+}
+
+//connected reports whether any instance holds a connection of the user in any tenant
+func connected(userID uint) (bool, error) {
+	_, err := store.Default.Get(connectedKey(userID))
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//keepKey keeps the presence change of the instance under the key of the user. The key is deleted only
+//if no other instance has a connection of the user. It returns the presence of the user across the instances
+func keepKey(key string, online bool, at []byte) (bool, error) {
+	if online {
+		if err := store.Default.Set(instanceKey(key), at, config.PresenceTTL); err != nil {
+			return true, err
+		}
+		return true, store.Default.Set(key, at, config.PresenceTTL)
+	}
+	if err := store.Default.Delete(instanceKey(key)); err != nil {
+		return false, err
+	}
+	others, err := store.Default.Scan(key + "/")
+	if err != nil {
+		return false, err
+	}
+	if len(others) != 0 {
+		return true, nil
+	}
+	return false, store.Default.Delete(key)
+}
+
+//keepPresence keeps the presence change of the instance in the store under the tenant and the unscoped keys of the user.
+//It returns the presence of the user in the tenant across the instances
+func keepPresence(p Presence) (bool, error) {
+	at := []byte(p.At.Format(time.RFC3339))
+	online, err := keepKey(presenceKey(p.tenant, p.UserID), p.Online, at)
+	if _, cErr := keepKey(connectedKey(p.UserID), p.Online, at); err == nil {
+		err = cErr
+	}
+	return online, err
+}
+
+//runPresence keeps the presence changes in the store and emits them to the subscribers.
+//The presence of the online users of the instance is refreshed and the presence of the subscribed users
+//is checked for the changes in the other instances periodically
+func runPresence() {
+	t := time.NewTicker(config.PresenceTTL / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-presenceSignal:
+			for _, p := range pendingPresence() {
+				online, err := keepPresence(p)
+				if err != nil {
+					log.Error("error while keeping the presence of user", p.UserID, err.Error())
+				}
+				p.Online = online
+				emitPresence(p)
+			}
+		case <-t.C:
+			refreshPresence()
+			watchPresence()
+		}
+	}
+}
+
+//refreshPresence refreshes the ttl of the presence of the users connected to the instance
+func refreshPresence() {
+	appCtxReq := AppContextRequest{Type: FetchAllWs, Out: make(chan AppContextRequest)}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	now := []byte(time.Now().Format(time.RFC3339))
+	for u, conns := range (<-appCtxReq.Out).UsersWsConns {
+		appCtx, ok := conns[0].Context().(*config.AppContext)
+		if !ok {
+			continue
+		}
+		for _, k := range []string{presenceKey(appCtx.Tenant, u), connectedKey(u)} {
+			err := store.Default.Set(instanceKey(k), now, config.PresenceTTL)
+			if err == nil {
+				err = store.Default.Set(k, now, config.PresenceTTL)
+			}
+			if err != nil {
+				log.Error("error while refreshing the presence of user", u, err.Error())
+			}
+		}
+	}
+}
+
+//watchPresence emits the presence of the subscribed users changed in the other instances
+func watchPresence() {
+	presenceLock.RLock()
+	keys := make([]string, 0, len(presenceSubscribers))
+	for k := range presenceSubscribers {
+		keys = append(keys, k)
+	}
+	presenceLock.RUnlock()
+	now := time.Now()
+	for _, k := range keys {
+		_, err := store.Default.Get(k)
+		if err != nil && err != store.ErrNotFound {
+			log.Error("error while watching the presence", k, err.Error())
+			continue
+		}
+		tenant, userID := presenceOf(k)
+		emitPresence(Presence{UserID: userID, Online: err == nil, At: now, tenant: tenant})
+	}
+}
+
+//presenceOf returns the tenant and the user of the presence key
+func presenceOf(key string) (string, uint) {
+	k := strings.TrimPrefix(key, presencePrefix)
+	i := strings.LastIndex(k, "/")
+	id, _ := strconv.ParseUint(k[i+1:], 10, 64)
+	return k[:i], uint(id)
+}
+
+//emitPresence emits the presence change to the subscribers of the user if it differs from the presence last sent to them
+func emitPresence(p Presence) {
+	k := presenceKey(p.tenant, p.UserID)
+	presenceLock.Lock()
+	if known, ok := presenceKnown[k]; ok && known == p.Online {
+		presenceLock.Unlock()
+		return
+	}
+	subs := make([]socketio.Conn, 0, len(presenceSubscribers[k]))
+	for _, conn := range presenceSubscribers[k] {
+		subs = append(subs, conn)
+	}
+	if len(subs) != 0 {
+		presenceKnown[k] = p.Online
+	}
+	presenceLock.Unlock()
+	for _, conn := range subs {
+		if err := delivery.Send(conn, p.notification()); err != nil {
+			log.Error("error while sending the presence of user", p.UserID, "to the connection", conn.ID(), err.Error())
+		}
+	}
+}
+
+//onSubscribePresence subscribes the connection to the presence changes of the users of its tenant
+//and sends their current presence
+func onSubscribePresence(conn socketio.Conn, users []uint) {
+	/*
+	 * We will add the connection to the subscribers of the users
+	 * Then we will send the current presence of the users
+	 */
+	appCtx := conn.Context().(*config.AppContext)
+	if len(users) > config.PresenceMaxUsers {
+		appCtx.Log.Warn("connection", conn.ID(), "tried to subscribe to the presence of", len(users), "users")
+		users = users[:config.PresenceMaxUsers]
+	}
+	presenceLock.Lock()
+	for _, u := range users {
+		k := presenceKey(appCtx.Tenant, u)
+		subs, ok := presenceSubscribers[k]
+		if !ok {
+			subs = map[string]socketio.Conn{}
+			presenceSubscribers[k] = subs
+		}
+		subs[conn.ID()] = conn
+	}
+	presenceLock.Unlock()
+
+	//sending the current presence
+	ps, err := online(appCtx.Tenant, users)
+	if err != nil {
+		appCtx.Log.Error("error while getting the presence for the connection", conn.ID(), err.Error())
+		return
+	}
+	presenceLock.Lock()
+	for _, u := range users {
+		if k := presenceKey(appCtx.Tenant, u); len(presenceSubscribers[k]) != 0 {
+			if _, ok := presenceKnown[k]; !ok {
+				presenceKnown[k] = ps[u]
+			}
+		}
+	}
+	presenceLock.Unlock()
+	now := time.Now()
+	for _, u := range users {
+		if err := delivery.Send(conn, Presence{UserID: u, Online: ps[u], At: now}.notification()); err != nil {
+			appCtx.Log.Error("error while sending the presence of user", u, "to the connection", conn.ID(), err.Error())
+		}
+	}
+}
+
+//onUnsubscribePresence unsubscribes the connection from the presence changes of the users
+func onUnsubscribePresence(conn socketio.Conn, users []uint) {
+	appCtx := conn.Context().(*config.AppContext)
+	presenceLock.Lock()
+	defer presenceLock.Unlock()
+	for _, u := range users {
+		k := presenceKey(appCtx.Tenant, u)
+		delete(presenceSubscribers[k], conn.ID())
+		if len(presenceSubscribers[k]) == 0 {
+			delete(presenceSubscribers, k)
+			delete(presenceKnown, k)
+		}
+	}
+}
+
+//unsubscribeAllPresence unsubscribes the connection from the presence changes of all the users
+func unsubscribeAllPresence(conn socketio.Conn) {
+	presenceLock.Lock()
+	defer presenceLock.Unlock()
+	for k, subs := range presenceSubscribers {
+		delete(subs, conn.ID())
+		if len(subs) == 0 {
+			delete(presenceSubscribers, k)
+			delete(presenceKnown, k)
+		}
+	}
+}
+
+//OnlineUsers returns whether the users in the request are online in any instance
+func OnlineUsers(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request payload
+	 * Only the admins and the internal services can query another tenant
+	 * Then we will get the presence of the users
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parse the request payload
+	q := &PresenceQuery{}
+	if err := decode(req, q); err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the presence query", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if len(q.Users) == 0 || len(q.Users) > config.PresenceMaxUsers {
+		response.WriteError(res, response.Error{Err: "Invalid Params users should have 1 to " + strconv.Itoa(config.PresenceMaxUsers) + " users"}, http.StatusBadRequest)
+		return
+	}
+
+	//checking the tenant
+	userID := appCtx.Session.User.ID
+	if len(q.Tenant) == 0 {
+		q.Tenant = appCtx.Tenant
+	}
+	if q.Tenant != appCtx.Tenant && !config.IsAdmin(userID) && !config.IsService(userID) {
+		appCtx.Log.Warn("user", userID, "tried to query the presence of the tenant", q.Tenant)
+		response.WriteError(res, response.Error{Err: "Only admins and internal services can query the presence of another tenant"}, http.StatusForbidden)
+		return
+	}
+
+	//getting the presence
+	ps, err := online(q.Tenant, q.Users)
+	if err != nil {
+		appCtx.Log.Error("error while getting the presence of the users", err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't get the presence of the users"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(res, response.Message{Message: "presence of the users", Data: ps})
+}
+
+func init() {
+	go runPresence()
+	config.RegisterWebsocketEvents(config.Namespace, "subscribe-presence", onSubscribePresence)
+	config.RegisterWebsocketEvents(config.Namespace, "unsubscribe-presence", onUnsubscribePresence)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: OnlineUsers,
+		Pattern:     "/presence",
+	})
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/push"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the push subscriptions and the device tokens api of the users.
+ * The browsers and the mobile devices of a user subscribe to the push notifications so that they get the notifications
+ * sent while the user has no live connection. The browsers subscribe with their web push endpoint and the mobile devices
+ * register their fcm or apns device token. Only the providers whose sender is compiled into the build and enabled can be subscribed.
+ */
+
+//PushSubscriptions returns the push subscriptions of the user with GET, saves the subscription of a device with POST
+//and deletes the subscription of the id query param with DELETE
+func PushSubscriptions(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	userID := appCtx.Session.User.ID
+
+	switch req.Method {
+	case http.MethodGet:
+		ss, err := push.List(userID)
+		if err != nil {
+			appCtx.Log.Error("error while getting the push subscriptions of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't get the push subscriptions"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "push subscriptions", Data: ss})
+	case http.MethodPost:
+		s := &push.Subscription{}
+		if err := decode(req, s); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the push subscription", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if len(s.Endpoint) == 0 {
+			response.WriteError(res, response.Error{Err: "Invalid Params endpoint is required"}, http.StatusBadRequest)
+			return
+		}
+		s.Token = ""
+		if !push.Enabled(s.Provider) {
+			response.WriteError(res, response.Error{Err: "Invalid Params push provider " + s.Provider + " is not enabled"}, http.StatusBadRequest)
+			return
+		}
+		s.UserID = userID
+		saved, err := push.Subscribe(*s)
+		if err == push.ErrEndpointNotAllowed || err == push.ErrPrivateAddress {
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err == push.ErrTaken {
+			response.WriteError(res, response.Error{Err: "Push subscription belongs to another user"}, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while saving the push subscription of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't save the push subscription"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "saved the push subscription", Data: saved})
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(req.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			response.WriteError(res, response.Error{Err: "Invalid Params id should be a number"}, http.StatusBadRequest)
+			return
+		}
+		err = push.Unsubscribe(userID, uint(id))
+		if err == push.ErrNotFound {
+			response.WriteError(res, response.Error{Err: "Push subscription not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while deleting the push subscription", id, "of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't delete the push subscription"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "deleted the push subscription"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+//PushDevices returns the device tokens of the user with GET, registers the device token with POST
+//and deletes the device token of the token query param with DELETE
+func PushDevices(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	userID := appCtx.Session.User.ID
+
+	switch req.Method {
+	case http.MethodGet:
+		ss, err := push.List(userID)
+		if err != nil {
+			appCtx.Log.Error("error while getting the device tokens of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't get the device tokens"}, http.StatusInternalServerError)
+			return
+		}
+		devices := []push.Subscription{}
+		for _, s := range ss {
+			if len(s.Token) != 0 {
+				devices = append(devices, s)
+			}
+		}
+		response.Write(res, response.Message{Message: "device tokens", Data: devices})
+	case http.MethodPost:
+		s := &push.Subscription{}
+		if err := decode(req, s); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the device token", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if len(s.Token) == 0 {
+			response.WriteError(res, response.Error{Err: "Invalid Params token is required"}, http.StatusBadRequest)
+			return
+		}
+		if !push.Enabled(s.Provider) {
+			response.WriteError(res, response.Error{Err: "Invalid Params push provider " + s.Provider + " is not enabled"}, http.StatusBadRequest)
+			return
+		}
+		s.UserID, s.Endpoint, s.P256dh, s.Auth = userID, "", "", ""
+		saved, err := push.Subscribe(*s)
+		if err == push.ErrInvalidToken {
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if err == push.ErrTaken {
+			response.WriteError(res, response.Error{Err: "Device token belongs to another user"}, http.StatusConflict)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while registering the device token of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't register the device token"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "registered the device token", Data: saved})
+	case http.MethodDelete:
+		token := req.URL.Query().Get("token")
+		err := push.UnsubscribeToken(userID, token)
+		if err == push.ErrNotFound {
+			response.WriteError(res, response.Error{Err: "Device token not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while deleting the device token of user", userID, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't delete the device token"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "deleted the device token"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	if err := push.Init(config.DB()); err != nil {
+		log.Error("error while initing the push subscriptions", err.Error())
+	}
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: PushSubscriptions,
+		Pattern:     "/notification/push/subscriptions",
+	})
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: PushDevices,
+		Pattern:     "/notification/push/devices",
+	})
+}
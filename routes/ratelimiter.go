@@ -5,12 +5,11 @@
 package routes
 
 import (
-	"strings"
 	"time"
 
 	authConfig "github.com/cuttle-ai/auth-service/config"
 	"github.com/cuttle-ai/websockets/config"
-	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/tracing"
 	socketio "github.com/googollee/go-socket.io"
 )
 
@@ -34,8 +33,62 @@ const (
 	Fetch RequestType = 3
 	//FetchWs will fetch the websocket connections
 	FetchWs RequestType = 4
+	//FetchAllWs will fetch the websocket connections of all the users
+	FetchAllWs RequestType = 5
+	//FetchBulkWs will fetch the websocket connections of the listed users
+	FetchBulkWs RequestType = 6
+	//FetchStats will fetch the usage of the app context pool
+	FetchStats RequestType = 7
+	//Reserve will reserve or renew the app context slots of an internal service
+	Reserve RequestType = 8
+	//Release will release the app context slots reserved by an internal service
+	Release RequestType = 9
+	//FetchReservations will fetch the reservations of the internal services
+	FetchReservations RequestType = 10
 )
 
+//PoolStats is the usage of the app context pool
+type PoolStats struct {
+	//Size is the max no. of app contexts
+	Size int
+	//Active is the no. of app contexts in use
+	Active int
+	//Users is the no. of users having a websocket connection
+	Users int
+	//Connections is the no. of websocket connections
+	Connections int
+	//Reserved is the no. of app context slots reserved by the internal services
+	Reserved int
+	//ReservedActive is the no. of reserved app contexts in use
+	ReservedActive int
+	//Waiting is the no. of requests waiting for an app context as the pool is exhausted
+	Waiting int
+}
+
+//Reservation is the app context slots reserved by an internal service outside the pool of the users
+type Reservation struct {
+	//UserID of the service
+	UserID uint `json:"userId"`
+	//Slots is the no. of slots reserved
+	Slots int `json:"slots"`
+	//Active is the no. of reserved slots in use
+	Active int `json:"active"`
+	//ExpiresAt is the time at which the reservation is released unless renewed
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+//reservedSlots has the app context ids reserved by a service
+type reservedSlots struct {
+	//free has the reserved ids not in use
+	free []int
+	//active is the no. of reserved ids in use
+	active int
+	//size is the no. of slots reserved
+	size int
+	//expiresAt is the time at which the reservation is released
+	expiresAt time.Time
+}
+
 //AppContextRequest is the request to get, return or try clean up app contexts
 type AppContextRequest struct {
 	//AppContext is the appcontext being requested
@@ -54,9 +107,29 @@ type AppContextRequest struct {
 	Ws socketio.Conn
 	//WsConns has the list of web socket connections for the user
 	WsConns []socketio.Conn
+	//UserID is the id of the user whose websocket connections are fetched.
+	//If not given, the user of the app context's session is used
+	UserID uint
+	//UsersWsConns has the web socket connections of all the users for the fetch all requests
+	//and of the listed users for the fetch bulk requests
+	UsersWsConns map[uint][]socketio.Conn
+	//UserIDs are the ids of the users whose websocket connections are fetched in the fetch bulk requests
+	UserIDs []uint
+	//Stats is the usage of the app context pool for the fetch stats requests
+	Stats PoolStats
+	//Reservation is the reservation to be made by the reserve requests and the one made in the reply
+	Reservation Reservation
+	//Reservations are the reservations of the services for the fetch reservations requests
+	Reservations []Reservation
+	//Handshake is set on the get requests of the websocket handshakes. The per user connection limit applies to them
+	Handshake bool
+	//LimitExceeded is set on the get requests rejected as the user has reached the per user connection limit
+	LimitExceeded bool
+	//Trace is the trace context of the caller. The get, fetch and finished requests are traced as its children if set
+	Trace tracing.SpanContext
 }
 
-//AppContextRequestChan channel through which the app context routine takes requests from
+//AppContextRequestChan channel through which the app context routines take requests from
 var AppContextRequestChan = make(chan AppContextRequest)
 
 //SendRequest is to send request to the channel. When this function used as go routines
@@ -65,93 +138,26 @@ func SendRequest(ch chan AppContextRequest, req AppContextRequest) {
 	ch <- req
 }
 
-//AppContext is the app context go routine running to
+//AppContext serves the app context requests from the channel with the sharded registry.
+//The requests are taken by as many go routines as the registry shards, so they don't wait on each other
 func AppContext(in chan AppContextRequest) {
 	/*
-	 * We will keep two maps for storing busy requests and free requests
-	 * First we will generate the id pool and store it in
-	 * We will start inifinite loop waiting for the requests
+	 * We will create the registry with the pool of the users and the reservable ids
+	 * Then we will serve the requests from the channel in the go routines
 	 */
-	//maps for storing the free and used requests
-	freeMaps := make([]int, config.MaxRequests)
-	authenticatedMap := make(map[int]time.Time, config.MaxRequests)
-	appCtxs := make(map[int]*config.AppContext)
-	userMap := make(map[uint][]socketio.Conn)
-
-	//generate the request pool
-	for i := 1; i <= config.MaxRequests; i++ {
-		freeMaps = append(freeMaps, i)
+	r := newRegistry(config.MaxRequests, config.ReservedRequests, config.RegistryShards)
+	for i := 1; i < config.RegistryShards; i++ {
+		go r.serve(in)
 	}
+	r.serve(in)
+}
 
-	//starting the infinite loop waiting for the requests
-	for {
-		req := <-in
-		switch req.Type {
-		case Get:
-			//If it is a get request we will try to get get a app context from the store
-			if len(freeMaps) == 0 {
-				req.Exhausted = true
-				go SendRequest(req.Out, req)
-				return
-			}
-			id := freeMaps[0]
-			freeMaps = freeMaps[1:]
-			authenticatedMap[id] = time.Now()
-			req.AppContext = config.NewAppContext(log.NewLogger(id), id)
-			req.AppContext.Session = req.Session
-			req.Exhausted = false
-			appCtxs[req.AppContext.ID] = req.AppContext
-			go SendRequest(req.Out, req)
-		case Fetch:
-			req.Exhausted = true
-			appCtx, ok := appCtxs[req.ID]
-			if ok {
-				//couldn't find the session
-				req.Exhausted = false
-				uCo, ok := userMap[appCtx.Session.User.ID]
-				if !ok {
-					uCo = []socketio.Conn{}
-				}
-				uCo = append(uCo, req.Ws)
-				userMap[appCtx.Session.User.ID] = uCo
-			}
-			req.AppContext = appCtx
-			go SendRequest(req.Out, req)
-		case FetchWs:
-			req.WsConns, req.Exhausted = userMap[req.AppContext.Session.User.ID]
-			go SendRequest(req.Out, req)
-		case Finished:
-			//we will return the request ids
-			delete(authenticatedMap, req.AppContext.ID)
-			delete(appCtxs, req.AppContext.ID)
-			freeMaps = append(freeMaps, req.AppContext.ID)
-			conns, ok := userMap[req.AppContext.Session.User.ID]
-			if !ok {
-				req.AppContext.Log.Error("couldn't find the user connection map for the user", req.AppContext.Session.User.ID, "and appctx id", req.AppContext.ID)
-				continue
-			}
-			for i := 0; i < len(conns); i++ {
-				if strings.Compare(conns[i].ID(), req.Ws.ID()) == 0 {
-					conns = append(conns[:i], conns[i+1:]...)
-					break
-				}
-			}
-			userMap[req.AppContext.Session.User.ID] = conns
-		case CleanUp:
-			//clean up the timed out requests
-			n := time.Now()
-			maxLife := config.MaxRequestLife
-			toBeAdded := []int{}
-			for k, v := range authenticatedMap {
-				if v.Add(maxLife).Before(n) {
-					toBeAdded = append(toBeAdded, k)
-					delete(authenticatedMap, k)
-					delete(appCtxs, k)
-				}
-			}
-			freeMaps = append(freeMaps, toBeAdded...)
-		}
+//sessionUserID returns the id of the user of the session. 0 if the session has no user
+func sessionUserID(s authConfig.Session) uint {
+	if s.User == nil {
+		return 0
 	}
+	return s.User.ID
 }
 
 //CleanUpCheck is the cleanup check to be used as a go routine which periodically sends cleanup
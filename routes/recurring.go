@@ -0,0 +1,283 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/cron"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the recurring notifications like the weekly digests.
+ * A recurring notification is a notification template sent to the users or a room as per its cron expression.
+ * The recurring notifications are kept in the store and the scheduler runs the due ones on the leader instance.
+ * A run missed while no instance was the leader is run once and the schedule continues from then.
+ */
+
+//recurringPrefix is the store key prefix of the recurring notifications
+const recurringPrefix = "recurring/"
+
+//Recurring is a notification sent periodically as per a cron expression
+type Recurring struct {
+	//ID of the recurring notification
+	ID string `json:"id"`
+	//Cron is the cron expression of the schedule. Eg. 0 9 * * 1 for every monday at 9
+	Cron string `json:"cron"`
+	//Timezone is the IANA timezone in which the cron expression is evaluated. Defaults to UTC
+	Timezone string `json:"timezone,omitempty"`
+	//Tenant in which the room is scoped. Defaults to the tenant of the admin
+	Tenant string `json:"tenant,omitempty"`
+	//Users to whom the notification is sent
+	Users []uint `json:"users,omitempty"`
+	//Room to which the notification is sent. Room notifications are live only
+	Room string `json:"room,omitempty"`
+	//Notification is the template of the notification sent on every run
+	Notification delivery.Notification `json:"notification"`
+	//NextRun is the time of the next run
+	NextRun time.Time `json:"nextRun"`
+	//LastRun is the time of the last run. Nil if it never ran
+	LastRun *time.Time `json:"lastRun,omitempty"`
+	//CreatedBy is the id of the admin who created the recurring notification
+	CreatedBy uint `json:"createdBy"`
+	//CreatedAt is the time at which the recurring notification was created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//next returns the time of the run after the given time as per the cron expression in the timezone
+func (r Recurring) next(t time.Time) (time.Time, error) {
+	s, err := cron.Parse(r.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.UTC
+	if len(r.Timezone) != 0 {
+		if loc, err = time.LoadLocation(r.Timezone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	next := s.Next(t.In(loc))
+	if next.IsZero() {
+		return next, errors.New("cron expression " + r.Cron + " never runs")
+	}
+	return next, nil
+}
+
+//event returns the bus event of a run of the recurring notification
+func (r Recurring) event(appCtx *config.AppContext) *bus.Event {
+	e := &bus.Event{Source: bus.SourceRecurring, AppContext: appCtx, Notification: r.Notification, Users: r.Users}
+	if len(r.Room) != 0 {
+		e.Room, e.Users, e.Live = roomKey(r.Tenant, r.Room), nil, true
+	}
+	return e
+}
+
+//saveRecurring saves the recurring notification in the store
+func saveRecurring(r Recurring) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return store.Default.Set(recurringPrefix+r.ID, b, 0)
+}
+
+//getRecurring returns the recurring notification with the id from the store
+func getRecurring(id string) (Recurring, error) {
+	r := Recurring{}
+	b, err := store.Default.Get(recurringPrefix + id)
+	if err != nil {
+		return r, err
+	}
+	return r, json.Unmarshal(b, &r)
+}
+
+//listRecurring returns the recurring notifications in the store
+func listRecurring() ([]Recurring, error) {
+	rs, err := store.Default.Scan(recurringPrefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Recurring, 0, len(rs))
+	for _, b := range rs {
+		r := Recurring{}
+		if err := json.Unmarshal(b, &r); err != nil {
+			log.Error("error while decoding the recurring notification", err.Error())
+			continue
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+//runRecurring periodically runs the due recurring notifications till done is closed. It runs only on the leader instance
+func runRecurring(done <-chan struct{}) {
+	t := time.NewTicker(config.RecurringCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		rs, err := listRecurring()
+		if err != nil {
+			log.Error("error while getting the recurring notifications", err.Error())
+			continue
+		}
+		now := time.Now()
+		for _, r := range rs {
+			if r.NextRun.After(now) {
+				continue
+			}
+			runRecurringOnce(r, now)
+		}
+	}
+}
+
+//runRecurringOnce publishes the run of the due recurring notification and saves its next run
+func runRecurringOnce(r Recurring, now time.Time) {
+	/*
+	 * We will find the next run and save it before publishing so that a failing run isn't repeated
+	 * Then we will publish the notification
+	 */
+	next, err := r.next(now)
+	if err != nil {
+		log.Error("error while finding the next run of the recurring notification", r.ID, "so removing it", err.Error())
+		store.Default.Delete(recurringPrefix + r.ID)
+		return
+	}
+	r.LastRun, r.NextRun = &now, next
+	if err := saveRecurring(r); err != nil {
+		log.Error("error while saving the next run of the recurring notification", r.ID, err.Error())
+		return
+	}
+
+	//publishing the notification
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	appCtx.Tenant = r.Tenant
+	e := r.event(appCtx)
+	if err := bus.Publish(e); err != nil {
+		log.Error("error while publishing the recurring notification", r.ID, err.Error())
+		return
+	}
+	log.Info("ran the recurring notification", r.ID, "with the event", r.Notification.Event, "sent to", e.Sent, "connections with the next run at", next)
+}
+
+//validRecurring checks the recurring notification and sets its next run
+func validRecurring(appCtx *config.AppContext, r *Recurring) error {
+	if len(r.Tenant) == 0 {
+		r.Tenant = appCtx.Tenant
+	}
+	next, err := r.next(time.Now())
+	if err != nil {
+		return err
+	}
+	r.NextRun = next
+	return bus.Check(r.event(appCtx))
+}
+
+//RecurringNotifications creates a recurring notification with POST, lists them with GET, replaces the one
+//given in the id query param with PUT and deletes it with DELETE
+func RecurringNotifications(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	id := req.URL.Query().Get("id")
+
+	switch req.Method {
+	case http.MethodGet:
+		rs, err := listRecurring()
+		if err != nil {
+			appCtx.Log.Error("error while listing the recurring notifications", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't list the recurring notifications"}, http.StatusInternalServerError)
+			return
+		}
+		inTenants := []Recurring{}
+		for _, r := range rs {
+			if inTenant(ctx, r.Tenant) {
+				inTenants = append(inTenants, r)
+			}
+		}
+		rs = inTenants
+		response.Write(res, response.Message{Message: "recurring notifications", Data: rs})
+	case http.MethodPost, http.MethodPut:
+		var old Recurring
+		if req.Method == http.MethodPut {
+			o, err := getRecurring(id)
+			if err != nil || !inTenant(ctx, o.Tenant) {
+				response.WriteError(res, response.Error{Err: "Couldn't find the recurring notification " + id}, http.StatusNotFound)
+				return
+			}
+			old = o
+		}
+		r := &Recurring{}
+		if err := decode(req, r); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the recurring notification", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if err := validRecurring(appCtx, r); err != nil {
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		if !inTenant(ctx, r.Tenant) {
+			appCtx.Log.Warn("api key of user", appCtx.Session.User.ID, "tried to save a recurring notification in the tenant", r.Tenant)
+			writeTenantForbidden(res)
+			return
+		}
+		r.ID, r.CreatedBy, r.CreatedAt, r.LastRun = newID(), appCtx.Session.User.ID, time.Now(), nil
+		if req.Method == http.MethodPut {
+			r.ID, r.CreatedBy, r.CreatedAt, r.LastRun = old.ID, old.CreatedBy, old.CreatedAt, old.LastRun
+		}
+		if err := saveRecurring(*r); err != nil {
+			appCtx.Log.Error("error while saving the recurring notification", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't save the recurring notification"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: recurring notification", r.ID, "with the event", r.Notification.Event, "and cron", r.Cron, "for the users", r.Users, "room", r.Room, "saved by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "recurring notification saved", Data: r})
+	case http.MethodDelete:
+		if r, err := getRecurring(id); err != nil || !inTenant(ctx, r.Tenant) {
+			response.WriteError(res, response.Error{Err: "Couldn't find the recurring notification " + id}, http.StatusNotFound)
+			return
+		}
+		if err := store.Default.Delete(recurringPrefix + id); err != nil {
+			appCtx.Log.Error("error while deleting the recurring notification", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't delete the recurring notification"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: recurring notification", id, "deleted by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "recurring notification deleted"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	leader.Run("recurring", config.DB(), runRecurring)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(RecurringNotifications),
+		Pattern:     "/admin/recurring",
+		Scope:       apikey.ScopeNotifyBroadcast,
+	})
+}
@@ -0,0 +1,462 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/tracing"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the registry of the app contexts and the websocket connections of the users.
+ * The app contexts are sharded by their id and the connections by the user id, each shard having its own lock.
+ * The free ids of the pool of the users are kept in a buffered channel, so getting and returning an id needs no lock.
+ * Once the pool is exhausted the requests can wait for an id to be returned till the pool wait timeout.
+ * Only the reservations of the services share a lock as they are rarely changed.
+ * The registry is served by many go routines taking the requests from the same channel.
+ */
+
+//registryShard is a shard of the registry
+type registryShard struct {
+	//appCtxs has the app contexts in use mapped by the id
+	appCtxs map[int]*config.AppContext
+	//authenticated has the time at which the app contexts in use were given mapped by the id
+	authenticated map[int]time.Time
+	//users has the websocket connections of the users mapped by the user id
+	users map[uint][]socketio.Conn
+	//m is the lock for the shard
+	m sync.RWMutex
+}
+
+//registry is the registry of the app contexts and the websocket connections
+type registry struct {
+	//waiting is the no. of requests waiting for an id to be returned to the exhausted pool.
+	//It is kept first for the 64 bit alignment of the atomic operations
+	waiting int64
+	//shards of the registry
+	shards []*registryShard
+	//free has the ids of the pool of the users not in use
+	free chan int
+	//reservable has the ids which can be reserved by the services
+	reservable []int
+	//reservations has the reservations of the services mapped by the user id of the service
+	reservations map[uint]*reservedSlots
+	//reservedOwners has the services owning the reserved ids in use
+	reservedOwners map[int]uint
+	//reservedActive is the no. of reserved ids in use
+	reservedActive int
+	//reservedLock is the lock for the reservations
+	reservedLock sync.Mutex
+}
+
+//newRegistry returns a registry with the pool of the users of the given size, the reservable ids after it
+//and the given no. of shards
+func newRegistry(size, reserved, shards int) *registry {
+	/*
+	 * We will create the shards
+	 * Then we will generate the id pool of the users
+	 * The ids after the pool of the users are kept for the reservations of the services
+	 */
+	//creating the shards
+	if shards < 1 {
+		shards = 1
+	}
+	r := &registry{
+		shards:         make([]*registryShard, shards),
+		free:           make(chan int, size),
+		reservable:     make([]int, 0, reserved),
+		reservations:   map[uint]*reservedSlots{},
+		reservedOwners: map[int]uint{},
+	}
+	for i := range r.shards {
+		r.shards[i] = &registryShard{
+			appCtxs:       map[int]*config.AppContext{},
+			authenticated: map[int]time.Time{},
+			users:         map[uint][]socketio.Conn{},
+		}
+	}
+
+	//generating the id pool
+	for i := 1; i <= size; i++ {
+		r.free <- i
+	}
+	for i := size + 1; i <= size+reserved; i++ {
+		r.reservable = append(r.reservable, i)
+	}
+	return r
+}
+
+//ctxShard returns the shard of the app context id
+func (r *registry) ctxShard(id int) *registryShard {
+	return r.shards[id%len(r.shards)]
+}
+
+//userShard returns the shard of the user id
+func (r *registry) userShard(uID uint) *registryShard {
+	return r.shards[uID%uint(len(r.shards))]
+}
+
+//traceRequest starts the span of the app context request as a child of the trace context of the caller.
+//The request isn't traced if it has no trace context
+func traceRequest(name string, req AppContextRequest) *tracing.Span {
+	if !req.Trace.Valid() {
+		return nil
+	}
+	id := req.ID
+	if req.AppContext != nil {
+		id = req.AppContext.ID
+	}
+	return tracing.Start(req.Trace, name, tracing.KindInternal).Set("appcontext.id", id)
+}
+
+//serve serves the requests from the channel
+func (r *registry) serve(in chan AppContextRequest) {
+	for req := range in {
+		switch req.Type {
+		case Get:
+			//the request may wait for the pool, so it is served in its own go routine
+			go func(req AppContextRequest) {
+				span := traceRequest("appcontext.get", req)
+				res := r.get(req)
+				if res.AppContext != nil {
+					span.Set("appcontext.id", res.AppContext.ID)
+				}
+				span.Set("appcontext.exhausted", res.Exhausted).Set("appcontext.limit_exceeded", res.LimitExceeded).End(nil)
+				SendRequest(req.Out, res)
+			}(req)
+		case Fetch:
+			span := traceRequest("appcontext.fetch", req)
+			res := r.fetch(req)
+			span.End(nil)
+			go SendRequest(req.Out, res)
+		case FetchWs:
+			uID := req.UserID
+			if uID == 0 {
+				uID = req.AppContext.Session.User.ID
+			}
+			s := r.userShard(uID)
+			s.m.RLock()
+			req.WsConns, req.Exhausted = s.users[uID]
+			req.WsConns = append([]socketio.Conn(nil), req.WsConns...)
+			s.m.RUnlock()
+			go SendRequest(req.Out, req)
+		case FetchAllWs:
+			req.UsersWsConns = map[uint][]socketio.Conn{}
+			for _, s := range r.shards {
+				s.m.RLock()
+				for k, v := range s.users {
+					if len(v) != 0 {
+						req.UsersWsConns[k] = append([]socketio.Conn{}, v...)
+					}
+				}
+				s.m.RUnlock()
+			}
+			go SendRequest(req.Out, req)
+		case FetchBulkWs:
+			req.UsersWsConns = make(map[uint][]socketio.Conn, len(req.UserIDs))
+			for _, uID := range req.UserIDs {
+				s := r.userShard(uID)
+				s.m.RLock()
+				if v := s.users[uID]; len(v) != 0 {
+					req.UsersWsConns[uID] = append([]socketio.Conn{}, v...)
+				}
+				s.m.RUnlock()
+			}
+			go SendRequest(req.Out, req)
+		case FetchStats:
+			go SendRequest(req.Out, r.stats(req))
+		case Reserve:
+			go SendRequest(req.Out, r.reserve(req))
+		case Release:
+			//we will release the free slots of the reservation. The ones in use are released once they finish
+			r.reservedLock.Lock()
+			rs, ok := r.reservations[req.Reservation.UserID]
+			req.Exhausted = !ok
+			if ok {
+				r.reservable = append(r.reservable, rs.free...)
+				delete(r.reservations, req.Reservation.UserID)
+			}
+			r.reservedLock.Unlock()
+			go SendRequest(req.Out, req)
+		case FetchReservations:
+			r.reservedLock.Lock()
+			req.Reservations = make([]Reservation, 0, len(r.reservations))
+			for uID, rs := range r.reservations {
+				req.Reservations = append(req.Reservations, Reservation{UserID: uID, Slots: rs.size, Active: rs.active, ExpiresAt: rs.expiresAt})
+			}
+			r.reservedLock.Unlock()
+			go SendRequest(req.Out, req)
+		case Finished:
+			span := traceRequest("appcontext.finished", req)
+			r.finished(req)
+			span.End(nil)
+		case CleanUp:
+			r.cleanUp(time.Now())
+		}
+	}
+}
+
+//get returns the request with an app context from the reservation of the user or else from the pool.
+//If the pool is exhausted, it waits for an id to be returned till the pool wait timeout.
+//The handshakes of the users at the connection limit are rejected or the oldest connection is evicted
+func (r *registry) get(req AppContextRequest) AppContextRequest {
+	/*
+	 * We will check the connection limit of the user for the handshakes
+	 * Then we will take an id from the reservation of the user or else from the pool waiting for it if exhausted
+	 * Then we will create the app context and keep it in its shard
+	 */
+	//checking the connection limit
+	uID := sessionUserID(req.Session)
+	if req.Handshake && config.MaxConnectionsPerUser > 0 {
+		s := r.userShard(uID)
+		s.m.RLock()
+		conns := s.users[uID]
+		var oldest socketio.Conn
+		if len(conns) != 0 {
+			oldest = conns[0]
+		}
+		s.m.RUnlock()
+		if len(conns) >= config.MaxConnectionsPerUser {
+			if config.UserLimitPolicy != config.UserLimitEvict {
+				req.LimitExceeded = true
+				return req
+			}
+			log.Warn("evicting the oldest connection", oldest.ID(), "of user", uID, "as the user has reached the connection limit")
+			go config.Disconnect(oldest, config.CloseTooManyConnections)
+		}
+	}
+
+	//taking an id
+	id, ok := r.reservedID(uID)
+	if !ok {
+		select {
+		case id = <-r.free:
+		default:
+			if id, ok = r.wait(); !ok {
+				req.Exhausted = true
+				return req
+			}
+		}
+	}
+
+	//creating the app context
+	l := log.NewLogger(id)
+	l.UserID = uID
+	req.AppContext = config.NewAppContext(l, id)
+	req.AppContext.Session = req.Session
+	req.Exhausted = false
+	s := r.ctxShard(id)
+	s.m.Lock()
+	s.appCtxs[id] = req.AppContext
+	s.authenticated[id] = time.Now()
+	s.m.Unlock()
+	return req
+}
+
+//reservedID takes a free id from the reservation of the user. It reports whether an id was taken
+func (r *registry) reservedID(uID uint) (int, bool) {
+	r.reservedLock.Lock()
+	defer r.reservedLock.Unlock()
+	rs, ok := r.reservations[uID]
+	if !ok || len(rs.free) == 0 {
+		return 0, false
+	}
+	id := rs.free[0]
+	rs.free = rs.free[1:]
+	rs.active++
+	r.reservedOwners[id] = uID
+	r.reservedActive++
+	return id, true
+}
+
+//wait waits for an id to be returned to the pool till the pool wait timeout. It reports whether an id was taken
+func (r *registry) wait() (int, bool) {
+	if config.PoolWaitTimeout <= 0 {
+		return 0, false
+	}
+	atomic.AddInt64(&r.waiting, 1)
+	defer atomic.AddInt64(&r.waiting, -1)
+	t := time.NewTimer(config.PoolWaitTimeout)
+	defer t.Stop()
+	select {
+	case id := <-r.free:
+		return id, true
+	case <-t.C:
+		return 0, false
+	}
+}
+
+//fetch returns the request with the app context of the id and adds the websocket connection to the user
+func (r *registry) fetch(req AppContextRequest) AppContextRequest {
+	s := r.ctxShard(req.ID)
+	s.m.RLock()
+	appCtx, ok := s.appCtxs[req.ID]
+	s.m.RUnlock()
+	req.AppContext, req.Exhausted = appCtx, !ok
+	if !ok {
+		//couldn't find the session
+		return req
+	}
+	uID := appCtx.Session.User.ID
+	s = r.userShard(uID)
+	s.m.Lock()
+	if len(s.users[uID]) == 0 {
+		presenceChanged(uID, appCtx.Tenant, true)
+	}
+	s.users[uID] = append(s.users[uID], req.Ws)
+	s.m.Unlock()
+	return req
+}
+
+//finished returns the id of the app context of the request and removes its websocket connection from the user
+func (r *registry) finished(req AppContextRequest) {
+	if r.remove(req.AppContext.ID) {
+		r.release(req.AppContext.ID)
+	}
+	uID := req.AppContext.Session.User.ID
+	s := r.userShard(uID)
+	s.m.Lock()
+	defer s.m.Unlock()
+	conns, ok := s.users[uID]
+	if !ok {
+		req.AppContext.Log.Error("couldn't find the user connection map for the user", uID, "and appctx id", req.AppContext.ID)
+		return
+	}
+	if req.Ws == nil {
+		return
+	}
+	for i := 0; i < len(conns); i++ {
+		if conns[i].ID() == req.Ws.ID() {
+			conns = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	s.users[uID] = conns
+	if len(conns) == 0 {
+		delete(s.users, uID)
+		presenceChanged(uID, req.AppContext.Tenant, false)
+	}
+}
+
+//remove removes the app context of the id from its shard. It reports whether the app context was in use
+func (r *registry) remove(id int) bool {
+	s := r.ctxShard(id)
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, ok := s.appCtxs[id]
+	delete(s.appCtxs, id)
+	delete(s.authenticated, id)
+	return ok
+}
+
+//release returns the app context id to the pool it came from
+func (r *registry) release(id int) {
+	r.reservedLock.Lock()
+	defer r.reservedLock.Unlock()
+	owner, ok := r.reservedOwners[id]
+	if !ok {
+		r.free <- id
+		return
+	}
+	delete(r.reservedOwners, id)
+	r.reservedActive--
+	rs, ok := r.reservations[owner]
+	if !ok {
+		r.reservable = append(r.reservable, id)
+		return
+	}
+	rs.active--
+	if rs.active+len(rs.free) < rs.size {
+		rs.free = append(rs.free, id)
+	} else {
+		r.reservable = append(r.reservable, id)
+	}
+}
+
+//reserve grows or shrinks the reservation of the service in the request and renews it
+func (r *registry) reserve(req AppContextRequest) AppContextRequest {
+	r.reservedLock.Lock()
+	defer r.reservedLock.Unlock()
+	rs, ok := r.reservations[req.Reservation.UserID]
+	if !ok {
+		rs = &reservedSlots{}
+	}
+	want := req.Reservation.Slots
+	if want-rs.size > len(r.reservable) {
+		req.Exhausted = true
+		return req
+	}
+	for ; rs.size < want; rs.size++ {
+		rs.free = append(rs.free, r.reservable[0])
+		r.reservable = r.reservable[1:]
+	}
+	for ; rs.size > want && len(rs.free) != 0; rs.size-- {
+		r.reservable = append(r.reservable, rs.free[0])
+		rs.free = rs.free[1:]
+	}
+	rs.size = want
+	rs.expiresAt = time.Now().Add(config.ReservationTTL)
+	r.reservations[req.Reservation.UserID] = rs
+	req.Reservation = Reservation{UserID: req.Reservation.UserID, Slots: rs.size, Active: rs.active, ExpiresAt: rs.expiresAt}
+	return req
+}
+
+//stats returns the request with the usage of the pool
+func (r *registry) stats(req AppContextRequest) AppContextRequest {
+	req.Stats = PoolStats{Size: cap(r.free), Waiting: int(atomic.LoadInt64(&r.waiting))}
+	for _, s := range r.shards {
+		s.m.RLock()
+		req.Stats.Active += len(s.appCtxs)
+		for _, v := range s.users {
+			if len(v) != 0 {
+				req.Stats.Users++
+				req.Stats.Connections += len(v)
+			}
+		}
+		s.m.RUnlock()
+	}
+	r.reservedLock.Lock()
+	req.Stats.ReservedActive = r.reservedActive
+	for _, rs := range r.reservations {
+		req.Stats.Reserved += rs.size
+	}
+	r.reservedLock.Unlock()
+	req.Stats.Active -= req.Stats.ReservedActive
+	return req
+}
+
+//cleanUp cleans up the timed out app contexts and the expired reservations
+func (r *registry) cleanUp(n time.Time) {
+	for _, s := range r.shards {
+		expired := []int{}
+		s.m.Lock()
+		for k, v := range s.authenticated {
+			if v.Add(config.MaxRequestLife).Before(n) {
+				delete(s.authenticated, k)
+				delete(s.appCtxs, k)
+				expired = append(expired, k)
+			}
+		}
+		s.m.Unlock()
+		for _, id := range expired {
+			r.release(id)
+		}
+	}
+	r.reservedLock.Lock()
+	defer r.reservedLock.Unlock()
+	for uID, rs := range r.reservations {
+		if rs.expiresAt.Before(n) {
+			log.Warn("releasing the expired app context reservation of the service", uID)
+			r.reservable = append(r.reservable, rs.free...)
+			delete(r.reservations, uID)
+		}
+	}
+}
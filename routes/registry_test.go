@@ -0,0 +1,73 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes"
+)
+
+//get sends a get request for the user to the registry and returns the reply
+func get(ch chan routes.AppContextRequest, uID uint) routes.AppContextRequest {
+	req := routes.AppContextRequest{
+		Type:    routes.Get,
+		Out:     make(chan routes.AppContextRequest),
+		Session: authConfig.Session{User: &authModels.User{ID: uID}},
+	}
+	go routes.SendRequest(ch, req)
+	return <-req.Out
+}
+
+func TestRegistryPool(t *testing.T) {
+	ch := make(chan routes.AppContextRequest)
+	go routes.AppContext(ch)
+
+	//the pool is shared by the concurrent requests without giving an id twice
+	ids := map[int]bool{}
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < config.MaxRequests; i++ {
+		wg.Add(1)
+		go func(u uint) {
+			defer wg.Done()
+			res := get(ch, u)
+			if res.Exhausted {
+				t.Error("expected an app context from the pool")
+				return
+			}
+			m.Lock()
+			if ids[res.AppContext.ID] {
+				t.Errorf("app context id %d given twice", res.AppContext.ID)
+			}
+			ids[res.AppContext.ID] = true
+			m.Unlock()
+		}(uint(i + 1))
+	}
+	wg.Wait()
+
+	//the pool is exhausted till an app context is finished
+	if res := get(ch, 1); !res.Exhausted {
+		t.Fatalf("expected the pool to be exhausted. got the app context %d", res.AppContext.ID)
+	}
+	ctx := config.NewAppContext(log.NewLogger(7), 7)
+	ctx.Session = authConfig.Session{User: &authModels.User{ID: 1}}
+	routes.SendRequest(ch, routes.AppContextRequest{Type: routes.Finished, AppContext: ctx})
+	//the finished request may still be served by another routine
+	res := get(ch, 2)
+	for i := 0; i < 100 && res.Exhausted; i++ {
+		time.Sleep(10 * time.Millisecond)
+		res = get(ch, 2)
+	}
+	if res.Exhausted || res.AppContext.ID != 7 {
+		t.Fatalf("expected the finished app context 7 to be reused. got %+v", res)
+	}
+}
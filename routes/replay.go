@@ -0,0 +1,126 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/filter"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the replay api of the notifications delivered to the user.
+ * Clients acknowledge the sequence no. of the notifications they have seen with the ack event.
+ * The replay resumes from the last acknowledged sequence no. of the device.
+ * Clients mark the notifications as read with the read event so that the producers get the read receipts
+ * and the unread count of the user is updated.
+ */
+
+//onAck moves the cursor of the connection's device to the acknowledged sequence no.
+func onAck(conn socketio.Conn, seq uint64) {
+	appCtx := conn.Context().(*config.AppContext)
+	err := delivery.Ack(appCtx.Db, appCtx.Session.User.ID, appCtx.DeviceID, seq)
+	if err != nil {
+		appCtx.Log.Error("error while acknowledging the sequence", seq, "for the device", appCtx.DeviceID, err.Error())
+	}
+}
+
+//onRead marks the notification with the sequence no. as read in the history and sends the read receipt to its producer
+func onRead(conn socketio.Conn, seq uint64) {
+	appCtx := conn.Context().(*config.AppContext)
+	if _, err := markRead(appCtx, []uint64{seq}); err != nil {
+		appCtx.Log.Error("error while marking the notification", seq, "of user", appCtx.Session.User.ID, "as read", err.Error())
+	}
+}
+
+//onReplay sends the notifications delivered to the user after the given sequence no. to the connection.
+//If the sequence no. is 0, the replay resumes from the last acknowledged sequence no. of the device
+func onReplay(conn socketio.Conn, since uint64) {
+	/*
+	 * We will get the notifications to be replayed
+	 * Then we will send them to the connection
+	 */
+	appCtx := conn.Context().(*config.AppContext)
+	ds, err := delivery.Since(appCtx.Db, appCtx.Session.User.ID, appCtx.DeviceID, since, filter.Query{})
+	if err != nil {
+		appCtx.Log.Error("error while getting the notifications to be replayed for the device", appCtx.DeviceID, err.Error())
+		return
+	}
+	appCtx.Log.Info("replaying", len(ds), "notifications to the connection", conn.ID())
+	for _, d := range ds {
+		if err := delivery.Send(conn, d.Notification()); err != nil {
+			appCtx.Log.Error("error while replaying the notification", d.Seq, "to the connection", conn.ID(), err.Error())
+			return
+		}
+	}
+}
+
+//Replay returns the notifications delivered to the user after the since query param.
+//If since is not given, the notifications after the last acknowledged sequence no. of the device are returned.
+//The notifications can be filtered by the event, seq and createdAt fields. Eg. event[prefix]=dashboard-.
+//They can be sorted by the seq and createdAt fields and paginated with the limit and offset params
+func Replay(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the query params
+	 * Then we will get the notifications to be replayed
+	 * Will write the response
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parsing the query params
+	deviceID := req.URL.Query().Get(DeviceIDParam)
+	var since uint64
+	if s := req.URL.Query().Get("since"); len(s) != 0 {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the since param", s, err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		since = v
+	}
+	q, err := filter.Parse(delivery.DeliveredSchema, req.URL.Query(), "since", DeviceIDParam)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the filters", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//getting the notifications
+	ds, err := delivery.Since(appCtx.Db, appCtx.Session.User.ID, deviceID, since, q)
+	if err != nil {
+		appCtx.Log.Error("error while getting the notifications to be replayed for the device", deviceID, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't get the notifications"}, http.StatusInternalServerError)
+		return
+	}
+
+	response.Write(res, response.Message{Message: "replaying notifications", Data: ds})
+}
+
+func init() {
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	if err := delivery.InitReplay(appCtx.Db); err != nil {
+		log.Error("error while initing the replay of the notifications", err.Error())
+	}
+	config.RegisterWebsocketEvents(config.Namespace, "ack", onAck)
+	config.RegisterWebsocketEvents(config.Namespace, "replay", onReplay)
+	config.RegisterWebsocketEvents(config.Namespace, "read", onRead)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Replay,
+		Pattern:     "/notification/replay",
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the reservation api of the app context slots for the internal services.
+ * A service holding long lived connections reserves slots outside the pool of the users.
+ * Its requests take the reserved slots first and fall back to the pool of the users once they are used up.
+ * So the internal traffic can't starve the connections of the users. The reservations are renewed by reserving again
+ * before they expire.
+ */
+
+//ReservationRequest is the request to reserve the app context slots
+type ReservationRequest struct {
+	//Slots is the no. of slots to be reserved. The existing reservation is resized to it
+	Slots int `json:"slots"`
+}
+
+//reservations returns the reservations of the services
+func reservations() []Reservation {
+	appCtxReq := AppContextRequest{Type: FetchReservations, Out: make(chan AppContextRequest)}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	return (<-appCtxReq.Out).Reservations
+}
+
+//Reservations reserves or renews the app context slots of the service with POST, returns its reservation with GET
+//and releases it with DELETE. Admins get the reservations of all the services with GET
+func Reservations(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Only the internal services can reserve
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	userID := appCtx.Session.User.ID
+
+	//checking the service
+	if req.Method == http.MethodGet && config.IsAdmin(userID) {
+		response.Write(res, response.Message{Message: "reservations", Data: reservations()})
+		return
+	}
+	if !config.IsService(userID) {
+		appCtx.Log.Warn("non service user", userID, "tried to reserve the app context slots")
+		response.WriteError(res, response.Error{Err: "Only internal services can reserve the app context slots"}, http.StatusForbidden)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		r := &ReservationRequest{}
+		if err := decode(req, r); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the reservation request", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if r.Slots <= 0 || r.Slots > config.MaxReservation {
+			response.WriteError(res, response.Error{Err: "Invalid Params slots should be between 1 and " + strconv.Itoa(config.MaxReservation)}, http.StatusBadRequest)
+			return
+		}
+		appCtxReq := AppContextRequest{Type: Reserve, Out: make(chan AppContextRequest), Reservation: Reservation{UserID: userID, Slots: r.Slots}}
+		go SendRequest(AppContextRequestChan, appCtxReq)
+		resCtx := <-appCtxReq.Out
+		if resCtx.Exhausted {
+			appCtx.Log.Warn("couldn't reserve", r.Slots, "app context slots for the service", userID)
+			response.WriteError(res, response.Error{Err: "Not enough app context slots left to be reserved", Code: response.CodeReservationExhausted}, http.StatusServiceUnavailable)
+			return
+		}
+		log.Info("AUDIT: service", userID, "reserved", resCtx.Reservation.Slots, "app context slots till", resCtx.Reservation.ExpiresAt)
+		response.Write(res, response.Message{Message: "app context slots reserved", Data: resCtx.Reservation})
+	case http.MethodGet:
+		for _, r := range reservations() {
+			if r.UserID == userID {
+				response.Write(res, response.Message{Message: "reservation", Data: r})
+				return
+			}
+		}
+		response.WriteError(res, response.Error{Err: "Couldn't find the reservation of the service"}, http.StatusNotFound)
+	case http.MethodDelete:
+		appCtxReq := AppContextRequest{Type: Release, Out: make(chan AppContextRequest), Reservation: Reservation{UserID: userID}}
+		go SendRequest(AppContextRequestChan, appCtxReq)
+		if (<-appCtxReq.Out).Exhausted {
+			response.WriteError(res, response.Error{Err: "Couldn't find the reservation of the service"}, http.StatusNotFound)
+			return
+		}
+		log.Info("AUDIT: service", userID, "released its app context reservation")
+		response.Write(res, response.Message{Message: "app context reservation released"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Reservations,
+		Pattern:     "/reservations",
+	})
+}
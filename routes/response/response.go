@@ -8,6 +8,8 @@ package response
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/cuttle-ai/websockets/log"
 )
@@ -16,10 +18,42 @@ import (
  * This file contains the response templates
  */
 
+//Codes of the errors for the clients to act upon instead of the error message
+const (
+	//CodeRateLimited is sent when the client made too many requests
+	CodeRateLimited = "rate_limited"
+	//CodePoolExhausted is sent when the app context pool of the instance is exhausted
+	CodePoolExhausted = "pool_exhausted"
+	//CodeTooManyConnections is sent when the user has opened too many websocket connections
+	CodeTooManyConnections = "too_many_connections"
+	//CodeHandshakeQueueFull is sent when the websocket handshake was shed as the admission queue is full
+	CodeHandshakeQueueFull = "handshake_queue_full"
+	//CodeReservationExhausted is sent when the app context slots couldn't be reserved
+	CodeReservationExhausted = "reservation_exhausted"
+	//CodeOriginNotAllowed is sent when the origin of the page isn't allowed to open the websocket connections
+	CodeOriginNotAllowed = "origin_not_allowed"
+	//CodeClientCertificateRequired is sent when an internal route is called without a verified client certificate
+	CodeClientCertificateRequired = "client_certificate_required"
+	//CodeScopeNotGranted is sent when the api key of the request doesn't have the scope of the route
+	CodeScopeNotGranted = "scope_not_granted"
+	//CodeMaintenance is sent when the service is in the maintenance mode
+	CodeMaintenance = "maintenance"
+	//CodeIdempotencyInProgress is sent when the original request with the idempotency key is still being processed
+	CodeIdempotencyInProgress = "idempotency_in_progress"
+	//CodeIdempotencyKeyReused is sent when the idempotency key was used earlier with a different request body
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	//CodeInternal is sent when the handler of the request failed unexpectedly
+	CodeInternal = "internal_error"
+)
+
 //Error is the datastructure for writing error response
 type Error struct {
 	//Err is the error happened in string format
 	Err string `json:"error"`
+	//Code is the machine readable code of the error. Eg. pool_exhausted
+	Code string `json:"code,omitempty"`
+	//RetryAfterMs is the time in milliseconds after which the client can retry the request
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
 }
 
 //Message is the message to be given for successfull response
@@ -44,6 +78,14 @@ func WriteError(res http.ResponseWriter, err Error, code int) {
 	}
 }
 
+//WriteRetry will write the error response with the Retry-After header and the retry duration in the error.
+//The header has the duration rounded up to the seconds
+func WriteRetry(res http.ResponseWriter, err Error, code int, after time.Duration) {
+	err.RetryAfterMs = int64(after / time.Millisecond)
+	res.Header().Set("Retry-After", strconv.FormatInt(int64((after+time.Second-1)/time.Second), 10))
+	WriteError(res, err, code)
+}
+
 //Write will write the response to the response writer
 //payload is any json serializable object
 func Write(res http.ResponseWriter, payload Message) {
@@ -0,0 +1,116 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+/*
+ * This file contains the streamed responses. The handlers write the items of a large response one by one
+ * and each item is flushed to the client if the response writer supports it, so nothing is buffered in memory.
+ * The items are written as a json array or as newline delimited json.
+ * The streams have to complete within the write timeout of the server.
+ */
+
+const (
+	//FormatJSON streams the items as a json array
+	FormatJSON = "json"
+	//FormatNDJSON streams the items as newline delimited json
+	FormatNDJSON = "ndjson"
+)
+
+//NDJSONContentType is the content type of the newline delimited json
+const NDJSONContentType = "application/x-ndjson"
+
+//StreamFormat returns the format of the stream accepted by the client of the request
+func StreamFormat(req *http.Request) string {
+	if strings.Contains(req.Header.Get("Accept"), NDJSONContentType) {
+		return FormatNDJSON
+	}
+	return FormatJSON
+}
+
+//Flush flushes the data written so far to the client if the response writer supports it
+func Flush(res http.ResponseWriter) {
+	if f, ok := res.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Stream writes the items of a response one by one
+type Stream struct {
+	//res is the response writer
+	res http.ResponseWriter
+	//format of the stream
+	format string
+	//n is the no. of items written
+	n int
+	//err is the first error while writing
+	err error
+}
+
+//NewStream starts the stream of the response in the format. The status and the headers have to be set before it
+func NewStream(res http.ResponseWriter, format string) *Stream {
+	if format == FormatNDJSON {
+		res.Header().Set("Content-Type", NDJSONContentType)
+	} else {
+		format = FormatJSON
+		res.Header().Set("Content-Type", "application/json")
+	}
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	return &Stream{res: res, format: format}
+}
+
+//write writes the bytes unless an earlier write failed
+func (s *Stream) write(b []byte) {
+	if s.err != nil {
+		return
+	}
+	_, s.err = s.res.Write(b)
+}
+
+//Write writes the item and flushes it. Once a write fails, the later writes are skipped and the error is returned
+func (s *Stream) Write(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.format == FormatNDJSON {
+		s.write(append(b, '\n'))
+	} else {
+		if s.n == 0 {
+			s.write([]byte{'['})
+		} else {
+			s.write([]byte{','})
+		}
+		s.write(b)
+	}
+	if s.err != nil {
+		return s.err
+	}
+	s.n++
+	Flush(s.res)
+	return nil
+}
+
+//Count returns the no. of items written
+func (s *Stream) Count() int {
+	return s.n
+}
+
+//Close ends the stream. It has to be called once all the items are written
+func (s *Stream) Close() error {
+	if s.format == FormatJSON {
+		if s.n == 0 {
+			s.write([]byte{'['})
+		}
+		s.write([]byte{']', '\n'})
+	}
+	Flush(s.res)
+	return s.err
+}
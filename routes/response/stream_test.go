@@ -0,0 +1,57 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package response_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the tests of the streamed responses
+ */
+
+type item struct {
+	ID int `json:"id"`
+}
+
+func TestStream(t *testing.T) {
+	cases := []struct {
+		format string
+		items  int
+		want   string
+	}{
+		{response.FormatJSON, 0, "[]\n"},
+		{response.FormatJSON, 2, `[{"id":0},{"id":1}]` + "\n"},
+		{response.FormatNDJSON, 2, `{"id":0}` + "\n" + `{"id":1}` + "\n"},
+	}
+	for _, c := range cases {
+		res := httptest.NewRecorder()
+		s := response.NewStream(res, c.format)
+		for i := 0; i < c.items; i++ {
+			if err := s.Write(item{ID: i}); err != nil {
+				t.Fatal(c.format, err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(c.format, err)
+		}
+		if got := res.Body.String(); got != c.want {
+			t.Errorf("%s stream of %d items = %q, want %q", c.format, c.items, got, c.want)
+		}
+		if !res.Flushed {
+			t.Errorf("%s stream wasn't flushed", c.format)
+		}
+		if c.format == response.FormatJSON {
+			items := []item{}
+			if err := json.Unmarshal(res.Body.Bytes(), &items); err != nil || len(items) != c.items {
+				t.Errorf("json stream couldn't be decoded into %d items: %v", c.items, err)
+			}
+		}
+	}
+}
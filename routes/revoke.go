@@ -0,0 +1,259 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the revocation of the sessions pushed by the internal services.
+ * When a session is invalidated, say on logout, the auth service or the other internal services push it
+ * over the http api or the rpc. The websocket connections of the session are sent the session expired event
+ * and closed with the auth expired reason. So the clients re-authenticate instead of staying connected
+ * till the max request life. The session is invalidated in the session cache as well.
+ * The revocation is published in the store so that every instance applies it to its connections and its session cache.
+ * The instances apply the revocations published by the others periodically.
+ */
+
+//SessionExpiredEvent is the event emitted to the connections of a revoked session before they are closed
+const SessionExpiredEvent = "session-expired"
+
+//RevokeArgs is the argument of the revoke rpc method and the payload of the revoke api
+type RevokeArgs struct {
+	//Caller is the name of the service revoking the session. Used for logging
+	Caller string `json:"caller"`
+	//Token is the rpc send token of the service
+	Token string `json:"-"`
+	//SessionID is the id of the revoked session. It is the auth token of the session
+	SessionID string `json:"sessionId"`
+	//UserID whose sessions are all revoked if the session id isn't given
+	UserID uint `json:"userId"`
+}
+
+//RevokeReply is the reply of the revoke rpc method
+type RevokeReply struct {
+	//Closed is the no. of connections of the instance closed
+	Closed int `json:"closed"`
+}
+
+//revocationPrefix is the store key prefix of the revocations published for the instances
+const revocationPrefix = "revocation/"
+
+//publishedRevocation is a revocation published in the store for every instance to apply
+type publishedRevocation struct {
+	//ID of the revocation
+	ID string `json:"id"`
+	//Token of the revoked session. Empty if all the sessions of the user were revoked
+	Token string `json:"token,omitempty"`
+	//UserID of the revoked session
+	UserID uint `json:"userId"`
+}
+
+var (
+	//appliedRevocations has the ids of the published revocations applied by the instance
+	appliedRevocations = map[string]bool{}
+	//appliedRevocationsLock is the lock for the applied revocations
+	appliedRevocationsLock sync.Mutex
+)
+
+//publishRevocation revokes the session on the instance and publishes the revocation in the store for the other instances.
+//It returns the no. of connections of the instance closed
+func publishRevocation(r config.Revocation) (int, error) {
+	closed := revokeSession(r)
+	p := publishedRevocation{ID: newID(), Token: r.Token, UserID: r.UserID}
+	appliedRevocationsLock.Lock()
+	appliedRevocations[p.ID] = true
+	appliedRevocationsLock.Unlock()
+	b, err := json.Marshal(p)
+	if err == nil {
+		err = store.Default.Set(revocationPrefix+p.ID, b, config.RevocationRetention)
+	}
+	return closed, err
+}
+
+//applyRevocations applies the revocations published by the other instances which the instance hasn't applied yet.
+//The ids of the revocations gone from the store are forgotten
+func applyRevocations() error {
+	/*
+	 * We will get the published revocations
+	 * Then we will find the ones not yet applied
+	 * Then we will apply them
+	 */
+	bs, err := store.Default.Scan(revocationPrefix)
+	if err != nil {
+		return err
+	}
+	ps := make([]publishedRevocation, 0, len(bs))
+	for _, b := range bs {
+		p := publishedRevocation{}
+		if err := json.Unmarshal(b, &p); err != nil {
+			log.Error("error while decoding the published revocation", err.Error())
+			continue
+		}
+		ps = append(ps, p)
+	}
+
+	//finding the ones not yet applied
+	pending := []publishedRevocation{}
+	published := make(map[string]bool, len(ps))
+	appliedRevocationsLock.Lock()
+	for _, p := range ps {
+		published[p.ID] = true
+		if !appliedRevocations[p.ID] {
+			appliedRevocations[p.ID] = true
+			pending = append(pending, p)
+		}
+	}
+	for id := range appliedRevocations {
+		if !published[id] {
+			delete(appliedRevocations, id)
+		}
+	}
+	appliedRevocationsLock.Unlock()
+
+	//applying them
+	for _, p := range pending {
+		if closed := revokeSession(config.Revocation{Token: p.Token, UserID: p.UserID}); closed != 0 {
+			log.Info("closed", closed, "websocket connections of the session revoked for user", p.UserID, "by another instance")
+		}
+	}
+	return nil
+}
+
+//RPCSession has the rpc methods for the internal services to push the session changes
+type RPCSession struct{}
+
+//Revoke closes the websocket connections of the revoked session on every instance.
+//The revocations are rejected if the rpc send token isn't configured
+func (r *RPCSession) Revoke(args RevokeArgs, reply *RevokeReply) error {
+	if len(config.RPCSendToken) == 0 || subtle.ConstantTimeCompare([]byte(args.Token), []byte(config.RPCSendToken)) != 1 {
+		log.Warn("service", args.Caller, "tried to revoke a session over rpc with an invalid token")
+		return ErrRPCToken
+	}
+	closed, err := publishRevocation(config.Revocation{Token: args.SessionID, UserID: args.UserID})
+	reply.Closed = closed
+	log.Info("AUDIT: service", args.Caller, "revoked the session of user", args.UserID, "over rpc closing", closed, "connections")
+	if err != nil {
+		log.Error("error while publishing the revocation of the session of user", args.UserID, "to the other instances", err.Error())
+		return errors.New("couldn't publish the revocation to the other instances")
+	}
+	return nil
+}
+
+//revokeSession invalidates the revoked session and closes its websocket connections.
+//All the sessions of the user are revoked if the revocation has no token. It returns the no. of connections closed
+func revokeSession(r config.Revocation) int {
+	/*
+	 * We will invalidate the session in the cache
+	 * Then we will get the websocket connections of the user or else of all the users
+	 * Then we will close the connections of the session
+	 */
+	//invalidating the cache
+	if len(r.Token) != 0 {
+		InvalidateSession(r.Token)
+	} else if r.UserID != 0 {
+		InvalidateUserSessions(r.UserID)
+	} else {
+		return 0
+	}
+
+	//getting the connections
+	conns := []socketio.Conn{}
+	if r.UserID != 0 {
+		appCtxReq := AppContextRequest{Type: FetchWs, Out: make(chan AppContextRequest), UserID: r.UserID}
+		go SendRequest(AppContextRequestChan, appCtxReq)
+		conns = (<-appCtxReq.Out).WsConns
+	} else {
+		appCtxReq := AppContextRequest{Type: FetchAllWs, Out: make(chan AppContextRequest)}
+		go SendRequest(AppContextRequestChan, appCtxReq)
+		for _, cs := range (<-appCtxReq.Out).UsersWsConns {
+			conns = append(conns, cs...)
+		}
+	}
+
+	//closing the connections of the session
+	reason := config.CloseAuthExpired.WithMessage("session of the user was revoked")
+	closed := 0
+	for _, conn := range conns {
+		appCtx, ok := conn.Context().(*config.AppContext)
+		if !ok || (len(r.Token) != 0 && appCtx.Session.ID != r.Token) {
+			continue
+		}
+		conn.Emit(SessionExpiredEvent, reason)
+		config.Disconnect(conn, reason)
+		closed++
+	}
+	return closed
+}
+
+//RevokeSession closes the websocket connections of the session or of all the sessions of the user revoked by an internal service
+func RevokeSession(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Only the internal services can revoke the sessions
+	 * Then we will parse the request payload
+	 * Then we will revoke the session on every instance
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodPost {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+
+	//checking the service
+	userID := appCtx.Session.User.ID
+	if !config.IsService(userID) {
+		appCtx.Log.Warn("non service user", userID, "tried to revoke a session")
+		response.WriteError(res, response.Error{Err: "Only internal services can revoke the sessions"}, http.StatusForbidden)
+		return
+	}
+
+	//parse the request payload
+	args := &RevokeArgs{}
+	if err := decode(req, args); err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the revoke request", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if len(args.SessionID) == 0 && args.UserID == 0 {
+		response.WriteError(res, response.Error{Err: "Invalid Params sessionId or userId is required"}, http.StatusBadRequest)
+		return
+	}
+
+	//revoking the session
+	closed, err := publishRevocation(config.Revocation{Token: args.SessionID, UserID: args.UserID})
+	log.Info("AUDIT: service", userID, "revoked the session of user", args.UserID, "closing", closed, "connections")
+	if err != nil {
+		appCtx.Log.Error("error while publishing the revocation of the session of user", args.UserID, "to the other instances", err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't publish the revocation to the other instances"}, http.StatusInternalServerError)
+		return
+	}
+	response.Write(res, response.Message{Message: "session revoked", Data: RevokeReply{Closed: closed}})
+}
+
+func init() {
+	config.RegisterRPC(new(RPCSession))
+	config.Refresh("revocations", config.RevocationRefresh, applyRevocations)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: RevokeSession,
+		Pattern:     "/sessions/revoke",
+	})
+}
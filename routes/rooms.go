@@ -0,0 +1,163 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/routes/response"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the rooms api. Connections join the named rooms with the join event and leave them with the
+ * leave event. The connections of a user can also be added to or removed from the rooms over http.
+ * A notification with a room is sent to all the connections in the room instead of the user's connections.
+ * Only the admins and the users having a connection in the room can send to it.
+ * The rooms are scoped by the tenant of the user and can be joined only as per the room acl.
+ */
+
+//RoomMembership is the request to add or remove the connections of a user to the rooms
+type RoomMembership struct {
+	//Rooms to be joined or left
+	Rooms []string `json:"rooms"`
+	//UserID is the id of the user whose connections join or leave the rooms. Defaults to the requesting user.
+	//Only admins can give another user
+	UserID uint `json:"userId"`
+}
+
+//roomKey returns the name of the room scoped by the tenant
+func roomKey(tenant, room string) string {
+	return tenant + "/" + room
+}
+
+//inRoom reports whether any connection of the user is in the room
+func inRoom(userID uint, room string) bool {
+	for _, conn := range delivery.Members(room) {
+		if appCtx, ok := conn.Context().(*config.AppContext); ok && appCtx.Session.User.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+//onJoin adds the connection to the rooms it can join and acknowledges with the rooms joined
+func onJoin(conn socketio.Conn, rooms []string) []string {
+	appCtx := conn.Context().(*config.AppContext)
+	joined := []string{}
+	for _, r := range rooms {
+		if !config.CanJoinRoom(appCtx.Session.User.ID, appCtx.Role, r) {
+			appCtx.Log.Warn("user", appCtx.Session.User.ID, "with the role", appCtx.Role, "isn't allowed to join the room", r)
+			continue
+		}
+		delivery.Join(roomKey(appCtx.Tenant, r), conn)
+		joined = append(joined, r)
+	}
+	return joined
+}
+
+//onLeave removes the connection from the rooms
+func onLeave(conn socketio.Conn, rooms []string) {
+	appCtx := conn.Context().(*config.AppContext)
+	for _, r := range rooms {
+		delivery.Leave(roomKey(appCtx.Tenant, r), conn)
+	}
+}
+
+//JoinRooms adds the connections of the user to the rooms
+func JoinRooms(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	updateRooms(ctx, res, req, true)
+}
+
+//LeaveRooms removes the connections of the user from the rooms
+func LeaveRooms(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	updateRooms(ctx, res, req, false)
+}
+
+//updateRooms adds or removes the connections of the user in the request to the rooms
+func updateRooms(ctx context.Context, res http.ResponseWriter, req *http.Request, join bool) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request payload
+	 * Only admins can change the rooms of another user
+	 * The rooms to be joined have to be allowed for the user
+	 * Then we will get the websocket connections of the user
+	 * We will add or remove the connections to the rooms
+	 * Will write the response
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parse the request payload
+	m := &RoomMembership{}
+	err := decode(req, m)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the room membership", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if len(m.Rooms) == 0 {
+		response.WriteError(res, response.Error{Err: "Invalid Params rooms are missing"}, http.StatusBadRequest)
+		return
+	}
+	if m.UserID == 0 {
+		m.UserID = appCtx.Session.User.ID
+	}
+	if m.UserID != appCtx.Session.User.ID && !config.IsAdmin(appCtx.Session.User.ID) {
+		appCtx.Log.Warn("non admin user", appCtx.Session.User.ID, "tried to change the rooms of user", m.UserID)
+		response.WriteError(res, response.Error{Err: "Only admins can change the rooms of another user"}, http.StatusForbidden)
+		return
+	}
+	for _, r := range m.Rooms {
+		if join && !config.CanJoinRoom(appCtx.Session.User.ID, appCtx.Role, r) {
+			appCtx.Log.Warn("user", appCtx.Session.User.ID, "with the role", appCtx.Role, "isn't allowed to join the room", r)
+			response.WriteError(res, response.Error{Err: "Not allowed to join the room " + r}, http.StatusForbidden)
+			return
+		}
+	}
+
+	//getting the user's websocket clients
+	appCtxReq := AppContextRequest{
+		Type:       FetchWs,
+		Out:        make(chan AppContextRequest),
+		AppContext: appCtx,
+		UserID:     m.UserID,
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+
+	//changing the rooms
+	for _, conn := range resCtx.WsConns {
+		for _, r := range m.Rooms {
+			if join {
+				delivery.Join(roomKey(appCtx.Tenant, r), conn)
+			} else {
+				delivery.Leave(roomKey(appCtx.Tenant, r), conn)
+			}
+		}
+	}
+
+	response.Write(res, response.Message{Message: "updated the rooms", Data: len(resCtx.WsConns)})
+}
+
+func init() {
+	config.RegisterWebsocketEvents(config.Namespace, "join", onJoin)
+	config.RegisterWebsocketEvents(config.Namespace, "leave", onLeave)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: JoinRooms,
+		Pattern:     "/rooms/join",
+	})
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: LeaveRooms,
+		Pattern:     "/rooms/leave",
+	})
+}
@@ -6,16 +6,28 @@ package routes
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/codec"
 	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/email"
 	"github.com/cuttle-ai/websockets/log"
 	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/tracing"
 	socketio "github.com/googollee/go-socket.io"
 
 	authConfig "github.com/cuttle-ai/auth-service/config"
+	authModels "github.com/cuttle-ai/auth-service/models"
 
 	"github.com/cuttle-ai/websockets/version"
 )
@@ -24,7 +36,8 @@ import (
  * This file has the definition of route data structure
  */
 
-//HandlerFunc is the Handler func with the context
+//HandlerFunc is the Handler func with the context. The response writer is passed as is, so the handlers
+//can stream large responses with response.NewStream and flush the partial responses
 type HandlerFunc func(context.Context, http.ResponseWriter, *http.Request)
 
 //Route is a route with explicit versions
@@ -35,6 +48,23 @@ type Route struct {
 	Pattern string
 	//HandlerFunc is the handler func of the route
 	HandlerFunc HandlerFunc
+	//Unauthenticated routes are served without the user session. Such routes have to authenticate the
+	//requests by themselves and must not use the session in the app context
+	Unauthenticated bool
+	//Authenticate returns the session of the request in place of the auth cookie session.
+	//It has to write the error response if the request couldn't be authenticated
+	Authenticate func(http.ResponseWriter, *http.Request) (authConfig.Session, bool)
+	//Guest serves the request as a guest without the app context pool if it has no credential.
+	//It reports whether the request was served
+	Guest func(http.ResponseWriter, *http.Request) bool
+	//Connection routes open the websocket connections. The per user connection limit applies to their handshakes
+	Connection bool
+	//Internal routes are called only by the internal services. With mutual tls, they are served only to the
+	//clients with a verified certificate on the internal listener
+	Internal bool
+	//Scope is the api key scope required to call the route. The requests authenticated with an api key
+	//are served only on the routes whose scope is granted to the key
+	Scope string
 }
 
 type appCtxKey struct {
@@ -44,6 +74,30 @@ type appCtxKey struct {
 //AppContextKey is the key with which the application is saved in the request context
 var AppContextKey = appCtxKey{key: "app-context"}
 
+//TenantHeader is the header set by the trusted gateway with the tenant of the user.
+//It is ignored unless the request comes from a trusted proxy or with a verified client certificate
+const TenantHeader = "cuttle-ai-tenant"
+
+//RoleHeader is the header set by the trusted gateway with the role of the user in the tenant.
+//It is ignored unless the request comes from a trusted proxy or with a verified client certificate
+const RoleHeader = "cuttle-ai-role"
+
+//trustedGateway reports whether the request comes from a trusted proxy or with a verified client certificate,
+//so that the gateway headers set on it can be trusted. The clients can set the headers on their own requests
+func trustedGateway(req *http.Request) bool {
+	if req.TLS != nil && len(req.TLS.VerifiedChains) != 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return config.TrustedProxy(host)
+}
+
+//RequestIDHeader is the header with the id of the request. A new id is given if the request doesn't have one
+const RequestIDHeader = "X-Request-ID"
+
 //Register registers the route with the default http handler func
 func (r Route) Register(s *http.ServeMux) {
 	/*
@@ -60,81 +114,274 @@ func (r Route) Register(s *http.ServeMux) {
 func (r Route) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	/*
 	 * Will get the context
-	 * We will get the auth-access token from the header
-	 * Will get session information about the logged in user
+	 * We will start the span of the request as a child of the trace context of the caller
+	 * If the ip of the client has made too many requests, we will reject the request
+	 * If the route is internal, we will reject the requests without a verified client certificate when mutual tls is enabled
+	 * If the route opens the websocket connections, we will reject the requests from the origins not allowed
+	 * In the maintenance mode, we will reject the new websocket connections
+	 * If the route serves the guests, will serve the request as a guest if it has no credential
+	 * If the route is not unauthenticated, will get session information about the logged in user. The requests with an
+	 * api key are authenticated with the key and rejected if the key doesn't have the scope of the route
+	 * The websocket handshakes wait in the admission queue till they are admitted
 	 * We will fetch the app context for the request
-	 * If app contexts have exhausted, we will reject the request
-	 * Then we will set the app context in request
-	 * Execute request handler func
+	 * If the user has too many connections or the app contexts have exhausted, we will reject the request
+	 * Then we will set the tenant and role of the user from the headers of the trusted gateway. The tenant of the api key takes precedence
+	 * Then we will set the request id from the header or a new one in the logger of the app context
+	 * Then we will set the trace context in the db handle and the request headers for the socket.io connection
+	 * Then we will set the app context and the api key in request
+	 * Execute request handler func. The span of a websocket handshake ends before the long lived connection is served
 	 */
 	//getting the context
 	ctx := req.Context()
 
-	//getting the auth token from the header
-	cookie, cErr := req.Cookie(authConfig.AuthHeaderKey)
-	if cErr != nil {
-		log.Warn("Auth cookie not found")
-		response.WriteError(res, response.Error{Err: "Couldn't find the auth header " + authConfig.AuthHeaderKey}, http.StatusForbidden)
-		_, cancel := context.WithCancel(ctx)
-		cancel()
+	//starting the span of the request
+	ip := clientIP(req)
+	span := tracing.Start(tracing.Extract(req.Header), "HTTP "+req.Method+" "+r.Pattern, tracing.KindServer).
+		Set("http.method", req.Method).Set("http.target", req.URL.Path).Set("http.client_ip", ip)
+	//the span of a handshake is ended before the connection is served, so the deferred end skips it
+	ended := false
+	defer func() {
+		if !ended {
+			span.End(nil)
+		}
+	}()
+	ctx = tracing.With(ctx, span)
+
+	//checking the rate limit of the ip
+	if after, ok := allowIP(ip); !ok {
+		log.Warn("ip", ip, "has exceeded the rate limit of", config.IPRateLimit, "requests per second")
+		response.WriteRetry(res, response.Error{Err: "Too many requests. Please try after some time.", Code: response.CodeRateLimited}, http.StatusTooManyRequests, after)
 		return
 	}
 
-	//will get information about the user
-	u, ok := authConfig.GetAutenticatedUser(cookie.Value)
-	if !ok {
-		log.Warn("User information not found the given auth header")
-		response.WriteError(res, response.Error{Err: "Couldn't find the user session " + cookie.Value}, http.StatusForbidden)
-		_, cancel := context.WithCancel(ctx)
-		cancel()
+	//checking the client certificate of the internal route
+	if r.Internal && config.MTLSEnabled() && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+		log.Warn("rejecting the request to the internal route", r.Pattern, "without a client certificate from the ip", ip)
+		response.WriteError(res, response.Error{Err: "Internal route " + r.Pattern + " requires a verified client certificate", Code: response.CodeClientCertificateRequired}, http.StatusForbidden)
+		return
+	}
+
+	//checking the origin of the websocket connection
+	if origin := req.Header.Get("Origin"); r.Connection && !config.OriginAllowed(origin) {
+		log.Warn("rejecting the websocket connection request from the origin", origin, "and ip", ip)
+		response.WriteError(res, response.Error{Err: "Origin " + origin + " is not allowed to open the websocket connections", Code: response.CodeOriginNotAllowed}, http.StatusForbidden)
+		return
+	}
+
+	//rejecting the new websocket connections in the maintenance mode
+	if m := maintenance(); r.Connection && m.Enabled && len(req.URL.Query().Get("sid")) == 0 {
+		log.Warn("rejecting the websocket connection request from the ip", ip, "in the maintenance mode")
+		response.WriteRetry(res, response.Error{Err: m.Message, Code: response.CodeMaintenance}, http.StatusServiceUnavailable, m.retryAfter(time.Now()))
+		return
+	}
+
+	//serving the guests
+	if r.Guest != nil && r.Guest(res, req) {
+		return
+	}
+
+	//getting the session of the user
+	sess := authConfig.Session{}
+	key, isKey := apikey.Key{}, false
+	if !r.Unauthenticated {
+		var ok bool
+		authenticate := session
+		if r.Authenticate != nil {
+			authenticate = r.Authenticate
+		}
+		if len(req.Header.Get(APIKeyHeader)) != 0 {
+			sess, key, ok = apiKeySession(res, req)
+			isKey = ok
+		} else {
+			sess, ok = authenticate(res, req)
+		}
+		if !ok {
+			_, cancel := context.WithCancel(ctx)
+			cancel()
+			return
+		}
+	}
+
+	//checking the scope of the api key
+	if isKey && (len(r.Scope) == 0 || !key.Allows(r.Scope)) {
+		log.Warn("api key", key.ID, "doesn't have the scope", r.Scope, "to access", req.URL.Path)
+		response.WriteError(res, response.Error{Err: "API key doesn't have the scope to access " + req.URL.Path, Code: response.CodeScopeNotGranted}, http.StatusForbidden)
+		return
+	}
+
+	//waiting for the admission of the handshake
+	handshake := r.Connection && len(req.URL.Query().Get("sid")) == 0
+	if handshake && !admitHandshake(res, req) {
+		log.Warn("websocket handshake of user", sessionUserID(sess), "was not admitted")
 		return
 	}
-	sess := authConfig.Session{ID: cookie.Value, Authenticated: true, User: &u}
 
 	//fetching the app context
 	appCtxReq := AppContextRequest{
-		Type:    Get,
-		Out:     make(chan AppContextRequest),
-		Session: sess,
+		Type:      Get,
+		Out:       make(chan AppContextRequest),
+		Session:   sess,
+		Handshake: handshake,
+		Trace:     span.Context(),
 	}
 	go SendRequest(AppContextRequestChan, appCtxReq)
 	resCtx := <-appCtxReq.Out
 
+	//checking whether the user has too many connections
+	if resCtx.LimitExceeded {
+		log.Warn("user", sess.User.ID, "has reached the limit of", config.MaxConnectionsPerUser, "connections")
+		response.WriteError(res, response.Error{Err: "User has opened too many connections. Please close some of them and try again.", Code: response.CodeTooManyConnections}, http.StatusTooManyRequests)
+		_, cancel := context.WithCancel(ctx)
+		cancel()
+		return
+	}
+
 	//checking whether the app context exhausted or not
 	if resCtx.Exhausted {
 		//reject the request
 		log.Error("We have exhausted the request limits")
-		response.WriteError(res, response.Error{Err: "We have exhuasted the server request limits. Please try after some time."}, http.StatusTooManyRequests)
+		response.WriteRetry(res, response.Error{Err: "We have exhuasted the server request limits. Please try after some time.", Code: response.CodePoolExhausted}, http.StatusTooManyRequests, config.PoolRetryAfter)
 		_, cancel := context.WithCancel(ctx)
 		cancel()
 		return
 	}
 
+	//setting the tenant and role of the user
+	if trustedGateway(req) {
+		resCtx.AppContext.Tenant = req.Header.Get(TenantHeader)
+		resCtx.AppContext.Role = req.Header.Get(RoleHeader)
+	} else if len(req.Header.Get(TenantHeader)) != 0 || len(req.Header.Get(RoleHeader)) != 0 {
+		log.Warn("ignoring the tenant and role headers of the request from the untrusted ip", ip)
+	}
+	if isKey {
+		resCtx.AppContext.Tenant = key.Tenant
+	}
+
+	//setting the request id in the logger of the app context
+	requestID := req.Header.Get(RequestIDHeader)
+	if len(requestID) == 0 {
+		requestID = newID()
+	}
+	res.Header().Set(RequestIDHeader, requestID)
+	if l, ok := resCtx.AppContext.Log.(*log.Logger); ok {
+		l.RequestID = requestID
+	}
+	span.Set("request.id", requestID).Set("appcontext.id", resCtx.AppContext.ID).Set("enduser.id", sessionUserID(sess)).Set("tenant", resCtx.AppContext.Tenant)
+
+	//setting the trace context
+	resCtx.AppContext.Db = tracing.DB(config.DB(), span.Context())
+	tracing.Inject(req.Header, span.Context())
+
 	//setting the app context
 	newCtx := context.WithValue(ctx, AppContextKey, resCtx.AppContext)
+	if isKey {
+		newCtx = context.WithValue(newCtx, APIKeyKey, key)
+	}
 	req.Header.Set("cuttle-ai-context-id", strconv.Itoa(resCtx.AppContext.ID))
 
 	//executing the request
+	if r.Connection {
+		ended = true
+		span.End(nil)
+	}
 	r.Exec(newCtx, res, req)
 }
 
+//BenchmarkTokenHeader is the header with which the synthetic clients pass the test token in the benchmark mode
+const BenchmarkTokenHeader = "cuttle-ai-bench-token"
+
+//BenchmarkTokenParam is the query param with which the synthetic clients pass the test token in the benchmark mode
+const BenchmarkTokenParam = "bench_token"
+
+//session returns the session of the logged in user from the auth cookie or the bearer token of the request.
+//If the session couldn't be found, the error response is written and false is returned
+func session(res http.ResponseWriter, req *http.Request) (authConfig.Session, bool) {
+	/*
+	 * In the benchmark mode, we will accept the synthetic user of a valid test token
+	 * We will get the auth-access token from the cookie or else from the bearer authorization header
+	 * Will get session information about the logged in user
+	 */
+	//checking the benchmark test token
+	if config.BenchmarkEnabled() {
+		token := req.Header.Get(BenchmarkTokenHeader)
+		if len(token) == 0 {
+			token = req.URL.Query().Get(BenchmarkTokenParam)
+		}
+		if uID, ok := config.VerifyBenchmarkToken(token); ok {
+			return authConfig.Session{ID: token, Authenticated: true, User: &authModels.User{ID: uID}}, true
+		}
+	}
+
+	//getting the auth token from the cookie or the bearer authorization header
+	token := bearerToken(req)
+	if cookie, cErr := req.Cookie(authConfig.AuthHeaderKey); cErr == nil {
+		token = cookie.Value
+	}
+	if len(token) == 0 {
+		log.Warn("Auth cookie or bearer token not found")
+		response.WriteError(res, response.Error{Err: "Couldn't find the auth header " + authConfig.AuthHeaderKey + " or the bearer token"}, http.StatusForbidden)
+		return authConfig.Session{}, false
+	}
+
+	//will get information about the user
+	u, ok := authenticatedUser(token)
+	if !ok {
+		log.Warn("User information not found the given auth token")
+		response.WriteError(res, response.Error{Err: "Couldn't find the user session of the auth token"}, http.StatusForbidden)
+		return authConfig.Session{}, false
+	}
+	return authConfig.Session{ID: token, Authenticated: true, User: &u}, true
+}
+
 //Exec will execute the handler func. By default it will set response content type as as json.
-//It will also cancel the context at the end. So no need of explicitly invoking the same in the handler funcs
+//It will also cancel the context at the end. So no need of explicitly invoking the same in the handler funcs.
+//A panic in the handler is recovered and the request fails with an internal server error
 func (r Route) Exec(ctx context.Context, res http.ResponseWriter, req *http.Request) {
 	/*
 	 * Will get the cancel for the context
+	 * Will recover from a panic in the handler. The aborted requests are left to the http server
 	 * Will set the content type of response as json
 	 * Will execute the handlerfunc
 	 * Cancelling the context at the end
 	 */
 	//getting the context cancel
 	c, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//recovering from the panic
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		if rec == http.ErrAbortHandler {
+			panic(rec)
+		}
+		config.Panicked("route:"+r.Pattern, rec)
+		if appCtx, ok := ctx.Value(AppContextKey).(*config.AppContext); ok {
+			appCtx.Log.Error("handler of", r.Pattern, "panicked for the request", req.Method, req.URL.Path, rec)
+		}
+		response.WriteError(res, response.Error{Err: "Internal server error", Code: response.CodeInternal}, http.StatusInternalServerError)
+	}()
 
 	//executing the handler
 	r.HandlerFunc(c, res, req)
+}
 
-	//cancelling the context
-	cancel()
+//connContextID returns the id of the app context set in the handshake header of the connection
+func connContextID(conn socketio.Conn) (int, error) {
+	contextHeader := conn.RemoteHeader().Get("cuttle-ai-context-id")
+	if len(contextHeader) == 0 {
+		log.Error("couldn't find the context header", contextHeader)
+		return 0, errors.New("error while connecting. Couldn't find the app context info. This is likely to be an internal error")
+	}
+	contextID, err := strconv.Atoi(contextHeader)
+	if err != nil {
+		//error while parsing the context id
+		log.Error("couldn't parse the context id", contextHeader)
+		return 0, errors.New("error while connecting. Couldn't parse the app context info. This is likely to be an internal error")
+	}
+	return contextID, nil
 }
 
 func onConnect(conn socketio.Conn) error {
@@ -143,29 +390,30 @@ func onConnect(conn socketio.Conn) error {
 	 * Then we will try to get the context header from remote connection
 	 * Then we will try to fetch the app context
 	 * Then will set the context as appcontext
+	 * Then we will set the connect time, device id, locale, timezone, codec and application context of the connection
+	 * and remember the user as known along with their email address for the email fallback
+	 * Then we will open the delivery outbox for the connection and send the notifications kept while the user was offline,
+	 * the ones flushed by the instances shut down, the announcements in their window and the unread count
 	 */
 	//getting the logger
 	l := log.NewLogger(0)
 
 	//getting the app context header
-	contextHeader := conn.RemoteHeader().Get("cuttle-ai-context-id")
-	if len(contextHeader) == 0 {
-		log.Error("couldn't find the context header", contextHeader)
-		return errors.New("error while connecting. Couldn't find the app context info. This is likely to be an internal error")
-	}
-	contextID, err := strconv.Atoi(contextHeader)
+	contextID, err := connContextID(conn)
 	if err != nil {
-		//error while parsing the context id
-		log.Error("couldn't parse the context id", contextHeader)
-		return errors.New("error while connecting. Couldn't parse the app context info. This is likely to be an internal error")
+		return err
+	}
+	if contextID < 0 {
+		return onGuestConnect(conn, contextID)
 	}
 
 	//fetching the app context
 	appCtxReq := AppContextRequest{
-		Type: Fetch,
-		Out:  make(chan AppContextRequest),
-		ID:   contextID,
-		Ws:   conn,
+		Type:  Fetch,
+		Out:   make(chan AppContextRequest),
+		ID:    contextID,
+		Ws:    conn,
+		Trace: tracing.Extract(conn.RemoteHeader()),
 	}
 	go SendRequest(AppContextRequestChan, appCtxReq)
 	resCtx := <-appCtxReq.Out
@@ -173,12 +421,177 @@ func onConnect(conn socketio.Conn) error {
 	//setting the app context
 	conn.SetContext(resCtx.AppContext)
 
+	//setting the connect time, device id, locale, timezone, codec and application context
+	resCtx.AppContext.ConnectedAt = time.Now()
+	resCtx.AppContext.DeviceID = deviceID(conn)
+	resCtx.AppContext.Locale = locale(conn)
+	resCtx.AppContext.Timezone = timezone(conn, l)
+	resCtx.AppContext.Codec = connCodec(conn, l)
+	resCtx.AppContext.SetClientContext(clientContext(conn, l))
+	go email.Remember(resCtx.AppContext.Session.User.ID, resCtx.AppContext.Session.User.Email)
+	go rememberTenant(resCtx.AppContext.Session.User.ID, resCtx.AppContext.Tenant)
+
+	//opening the outbox and sending the offline notifications, the announcements and the unread count
+	delivery.Open(conn)
+	go sendOffline(conn, resCtx.AppContext)
+	go sendFlushed(conn, resCtx.AppContext)
+	go sendAnnouncements(conn, resCtx.AppContext)
+	go sendUnreadCount(conn, resCtx.AppContext)
+
 	l.Info("Client connected with id", conn.ID(), "and user id", resCtx.AppContext.Session.User.ID)
 	return nil
 }
 
+//sendOffline sends the notifications kept while the user was offline to the connection
+func sendOffline(conn socketio.Conn, appCtx *config.AppContext) {
+	ds, err := delivery.TakeOffline(appCtx.Db, appCtx.Session.User.ID)
+	if err != nil {
+		appCtx.Log.Error("error while getting the offline notifications of the user", appCtx.Session.User.ID, err.Error())
+		return
+	}
+	if len(ds) == 0 {
+		return
+	}
+	appCtx.Log.Info("sending", len(ds), "offline notifications to the connection", conn.ID())
+	for _, d := range ds {
+		if err := delivery.Send(conn, d.Notification()); err != nil {
+			appCtx.Log.Error("error while sending the offline notification", d.Seq, "to the connection", conn.ID(), err.Error())
+			return
+		}
+	}
+}
+
+//DeviceIDHeader is the header with which the clients can pass the device id while connecting
+const DeviceIDHeader = "cuttle-ai-device-id"
+
+//DeviceIDParam is the query param with which the clients can pass the device id while connecting
+const DeviceIDParam = "device_id"
+
+//deviceID returns the device id of the websocket connection from the handshake query param or the header
+func deviceID(conn socketio.Conn) string {
+	u := conn.URL()
+	if id := u.Query().Get(DeviceIDParam); len(id) != 0 {
+		return id
+	}
+	return conn.RemoteHeader().Get(DeviceIDHeader)
+}
+
+//LocaleParam is the query param with which the clients can pass the locale while connecting.
+//If not given the first language in the Accept-Language header is used
+const LocaleParam = "locale"
+
+//TimezoneParam is the query param with which the clients can pass the IANA timezone while connecting. Eg. Asia/Kolkata
+const TimezoneParam = "tz"
+
+//TimezoneHeader is the header with which the clients can pass the IANA timezone while connecting
+const TimezoneHeader = "cuttle-ai-timezone"
+
+//locale returns the locale of the websocket connection from the handshake query param or the Accept-Language header
+func locale(conn socketio.Conn) string {
+	/*
+	 * We will check the query param
+	 * Then we will take the first language from the Accept-Language header ignoring the quality values
+	 */
+	u := conn.URL()
+	if l := u.Query().Get(LocaleParam); len(l) != 0 {
+		return l
+	}
+	lang := strings.Split(conn.RemoteHeader().Get("Accept-Language"), ",")[0]
+	lang = strings.TrimSpace(strings.Split(lang, ";")[0])
+	if len(lang) == 0 || lang == "*" {
+		return config.DefaultLocale
+	}
+	return lang
+}
+
+//timezone returns the timezone of the websocket connection from the handshake query param or the header.
+//If the timezone is not given or invalid, UTC is returned
+func timezone(conn socketio.Conn, l config.Logger) *time.Location {
+	u := conn.URL()
+	tz := u.Query().Get(TimezoneParam)
+	if len(tz) == 0 {
+		tz = conn.RemoteHeader().Get(TimezoneHeader)
+	}
+	if len(tz) == 0 {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		l.Warn("invalid timezone", tz, "from the connection", conn.ID(), err.Error())
+		return time.UTC
+	}
+	return loc
+}
+
+//CodecParam is the query param with which the clients can choose the codec of the payloads while connecting.
+//Eg. json, msgpack or protobuf
+const CodecParam = "codec"
+
+//connCodec returns the codec chosen by the websocket connection. If not given or unknown, the default codec is returned
+func connCodec(conn socketio.Conn, l config.Logger) codec.Codec {
+	u := conn.URL()
+	name := u.Query().Get(CodecParam)
+	if len(name) == 0 {
+		return codec.Default
+	}
+	c, ok := codec.Get(name)
+	if !ok {
+		l.Warn("unknown codec", name, "from the connection", conn.ID())
+		return codec.Default
+	}
+	return c
+}
+
+//ClientContextParam is the query param with which the clients can pass the json application context blob while connecting.
+//Eg. {"page": "dashboard", "dashboard": {"id": 42}, "flags": ["beta"]}
+const ClientContextParam = "context"
+
+//ClientContextHeader is the header with which the clients can pass the json application context blob while connecting
+const ClientContextHeader = "cuttle-ai-app-context"
+
+//clientContext returns the application context blob sent by the connection from the handshake query param or the header.
+//If not given, too large or invalid, an empty blob is returned
+func clientContext(conn socketio.Conn, l config.Logger) map[string]interface{} {
+	blob := map[string]interface{}{}
+	u := conn.URL()
+	c := u.Query().Get(ClientContextParam)
+	if len(c) == 0 {
+		c = conn.RemoteHeader().Get(ClientContextHeader)
+	}
+	if len(c) == 0 {
+		return blob
+	}
+	if len(c) > config.ClientContextMaxSize {
+		l.Warn("application context of the connection", conn.ID(), "is larger than", config.ClientContextMaxSize, "bytes")
+		return blob
+	}
+	if err := json.Unmarshal([]byte(c), &blob); err != nil {
+		l.Warn("invalid application context from the connection", conn.ID(), err.Error())
+		return map[string]interface{}{}
+	}
+	return blob
+}
+
+//decode decodes the request body into the value with the codec of the request's content type
+func decode(req *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return codec.ForContentType(req.Header.Get("Content-Type")).Unmarshal(b, v)
+}
+
 func onDisconnect(conn socketio.Conn, message string) {
 	appCtx := conn.Context().(*config.AppContext)
+	if appCtx.Guest {
+		onGuestDisconnect(conn, appCtx)
+		return
+	}
+	//closing the outbox of the connection and its state and presence subscriptions
+	delivery.Close(conn)
+	unsubscribeAllState(conn)
+	unsubscribeAllPresence(conn)
+
 	//removing the user from the context
 	appCtxReq := AppContextRequest{
 		Type:       Finished,
@@ -189,7 +602,56 @@ func onDisconnect(conn socketio.Conn, message string) {
 	appCtx.Log.Info("Client disconnected with id", conn.ID(), "and user id", appCtx.Session.User.ID, "with message", message)
 }
 
+//parserErrors are the errors of the packet parser of the websockets server. They are not typed by the parser
+var parserErrors = map[string]bool{
+	"first packet should be TEXT frame": true,
+	"invalid packet type":               true,
+	"buffer packet should be BINARY":    true,
+}
+
+//protocolError reports whether the error is from a packet of the client which couldn't be decoded.
+//The stream of the connection can't be read any further after it
+func protocolError(err error) bool {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+	return err == io.ErrUnexpectedEOF || parserErrors[err.Error()]
+}
+
+//onError counts the network error of the connection reported by the websockets server as a transient or fatal write error.
+//The connections sending the packets which couldn't be decoded are closed with the protocol violation reason.
+//The client is warned of the other errors with the internal error reason and the connection stays open.
+//The error text is only logged and never sent to the client
+func onError(conn socketio.Conn, err error) {
+	_, network := err.(net.Error)
+	if network {
+		delivery.CountWriteError(err)
+	}
+	if conn == nil {
+		log.Warn("error in the websockets server. transient", delivery.Transient(err), err.Error())
+		return
+	}
+	log.Warn("error in the connection", conn.ID(), "transient", delivery.Transient(err), err.Error())
+	switch {
+	case network:
+	case protocolError(err):
+		config.Disconnect(conn, config.CloseProtocolViolation)
+	default:
+		config.Warn(conn, config.CloseInternalError)
+	}
+}
+
 func init() {
 	config.RegisterWebsocketOnConnect(config.Namespace, onConnect)
+	config.RegisterWebsocketOnError(config.Namespace, onError)
 	config.RegisterWebsocketOnDisconnect(config.Namespace, onDisconnect)
+	for ns := range config.NamespaceAuthPolicies {
+		if ns == config.Namespace || ns == config.TailNamespace {
+			continue
+		}
+		config.RegisterWebsocketOnConnect(ns, onConnect)
+		config.RegisterWebsocketOnError(ns, onError)
+		config.RegisterWebsocketOnDisconnect(ns, onDisconnect)
+	}
 }
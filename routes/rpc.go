@@ -0,0 +1,107 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"strconv"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+)
+
+/*
+ * This file contains the rpc service through which the internal services discovered via the discovery service
+ * send the notifications without going through the http apis and the cookie based auth.
+ * The payload of the notification is gob encoded. So it has to be of a gob registered type.
+ * The generic json like payloads, map[string]interface{} and []interface{}, are registered.
+ */
+
+//RPCNotification has the rpc methods for the internal services to send the notifications
+type RPCNotification struct{}
+
+//SendArgs is the argument of the send rpc and grpc methods
+type SendArgs struct {
+	//Caller is the name of the service sending the notification. Used for logging
+	Caller string `json:"caller"`
+	//Token is the rpc send token of the service. The grpc callers pass the token in the metadata
+	Token string `json:"-"`
+	//Notification to be sent
+	Notification delivery.Notification `json:"notification"`
+	//Users are the ids of the users to whom the notification is sent
+	Users []uint `json:"users"`
+	//Tenant of the room. Required if the notification targets a room
+	Tenant string `json:"tenant"`
+}
+
+//SendReply is the reply of the send rpc and grpc methods
+type SendReply struct {
+	//Users is the no. of users to whom the notification was published
+	Users int `json:"users"`
+	//Sent is the no. of connections to which the notification was sent
+	Sent int `json:"sent"`
+}
+
+//ErrRPCToken is returned when the rpc send token of the caller doesn't match
+var ErrRPCToken = errors.New("invalid rpc send token")
+
+//Send publishes the notification to the users or the room and replies once it is delivered.
+//Room notifications are live only. The notifications are rejected if the rpc send token isn't configured
+func (r *RPCNotification) Send(args SendArgs, reply *SendReply) error {
+	if len(config.RPCSendToken) == 0 || subtle.ConstantTimeCompare([]byte(args.Token), []byte(config.RPCSendToken)) != 1 {
+		log.Warn("service", args.Caller, "tried to send notification over rpc with an invalid token")
+		return ErrRPCToken
+	}
+	return sendFromService(bus.SourceRPC, args, reply)
+}
+
+//sendFromService publishes the notification sent by an internal service from the source to the users or the room
+func sendFromService(source string, args SendArgs, reply *SendReply) error {
+	/*
+	 * We will resolve the targets of the event
+	 * Then we will validate the event
+	 * Then we will publish the event to the bus or fan it out if it has more users than a batch
+	 */
+	//resolving the targets
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	e := &bus.Event{Source: source, AppContext: appCtx, Notification: args.Notification}
+	if len(args.Notification.Room) != 0 {
+		e.Room = roomKey(args.Tenant, args.Notification.Room)
+		e.Live = true
+	} else {
+		e.Users = dedupe(args.Users)
+	}
+	if len(e.Users) > config.BulkMaxUsers {
+		return errors.New("more than " + strconv.Itoa(config.BulkMaxUsers) + " users")
+	}
+
+	//validating the event
+	if err := bus.Check(e); err != nil {
+		return err
+	}
+
+	//publishing the event
+	log.Info("service", args.Caller, "is sending notification event", args.Notification.Event, "over", source, "to", len(e.Users), "users")
+	reply.Users = len(e.Users)
+	if len(e.Users) > config.BulkBatchSize {
+		reply.Sent = fanOutWait(e)
+		return nil
+	}
+	if err := bus.Publish(e); err != nil {
+		return err
+	}
+	reply.Sent = e.Sent
+	return nil
+}
+
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	config.RegisterRPC(new(RPCNotification))
+}
@@ -0,0 +1,440 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the declarative routing rules of the notifications.
+ * A rule matches the published events by the event name, the tenant of the publisher and the payload fields.
+ * The matched events are sent to more users or a room, moved to another lane, prioritized, renamed,
+ * have their payload fields set or removed, or are dropped.
+ * The rules are kept in the store so that every instance sees them. Instances reload them periodically in the background
+ * and the events are matched against the rules loaded last.
+ * Admins edit the rules with the admin api without redeploying the service.
+ */
+
+//RuleMatch has the conditions of a rule. All the given conditions have to match
+type RuleMatch struct {
+	//Events are the names of the events matched. A name ending with * matches the events with the prefix.
+	//Every event is matched if empty
+	Events []string `json:"events,omitempty"`
+	//Tenants are the tenants of the publishers matched. Every tenant is matched if empty
+	Tenants []string `json:"tenants,omitempty"`
+	//Fields are the values of the payload fields matched mapped by the dotted path of the field. Eg. order.status
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+//RuleAction has the changes made by a rule to the matched events
+type RuleAction struct {
+	//Users are the ids of the users to whom the notification is also sent
+	Users []uint `json:"users,omitempty"`
+	//Room to which the notification is sent instead of the users. The room is scoped to the tenant of the publisher
+	Room string `json:"room,omitempty"`
+	//Lane is the delivery lane to be used for the notification
+	Lane delivery.Lane `json:"lane,omitempty"`
+	//Ack sends the notification in the ack mode
+	Ack bool `json:"ack,omitempty"`
+	//Urgency of the notification
+	Urgency string `json:"urgency,omitempty"`
+	//Event is the name with which the notification is sent
+	Event string `json:"event,omitempty"`
+	//Set has the values of the payload fields set mapped by the dotted path of the field
+	Set map[string]interface{} `json:"set,omitempty"`
+	//Remove are the dotted paths of the payload fields removed
+	Remove []string `json:"remove,omitempty"`
+	//Drop drops the notification
+	Drop bool `json:"drop,omitempty"`
+}
+
+//Rule is a routing rule of the notifications
+type Rule struct {
+	//ID of the rule
+	ID string `json:"id"`
+	//Name of the rule
+	Name string `json:"name"`
+	//Order of the rule. The rules are applied in the ascending order
+	Order int `json:"order"`
+	//Disabled rules are not applied
+	Disabled bool `json:"disabled,omitempty"`
+	//Match has the conditions of the rule
+	Match RuleMatch `json:"match"`
+	//Action has the changes made to the matched events
+	Action RuleAction `json:"action"`
+	//Stop stops applying the rules after this one for the matched events
+	Stop bool `json:"stop,omitempty"`
+	//CreatedBy is the id of the admin who added the rule
+	CreatedBy uint `json:"createdBy"`
+	//CreatedAt is the time at which the rule was added
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//rulesPrefix is the store key prefix of the routing rules
+const rulesPrefix = "rules/"
+
+var (
+	//rules has the routing rules loaded from the store in the order of application
+	rules []Rule
+	//rulesLock is the lock for the rules. The store isn't called under it
+	rulesLock sync.RWMutex
+)
+
+//sortRules sorts the rules in the order of application
+func sortRules(rs []Rule) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		if rs[i].Order != rs[j].Order {
+			return rs[i].Order < rs[j].Order
+		}
+		return rs[i].ID < rs[j].ID
+	})
+}
+
+//loadRules loads the rules from the store and swaps them in
+func loadRules() error {
+	rs, err := store.Default.Scan(rulesPrefix)
+	if err != nil {
+		return err
+	}
+	loaded := make([]Rule, 0, len(rs))
+	for _, b := range rs {
+		r := Rule{}
+		if err := json.Unmarshal(b, &r); err != nil {
+			log.Error("error while decoding the routing rule", err.Error())
+			continue
+		}
+		loaded = append(loaded, r)
+	}
+	sortRules(loaded)
+	rulesLock.Lock()
+	rules = loaded
+	rulesLock.Unlock()
+	return nil
+}
+
+//currentRules returns the routing rules in the order of application
+func currentRules() []Rule {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	return rules
+}
+
+//validate returns an error if the rule can't be applied
+func (r Rule) validate() error {
+	if len(r.Name) == 0 {
+		return errors.New("name is required")
+	}
+	if len(r.Action.Lane) != 0 && r.Action.Lane != delivery.AlertLane && r.Action.Lane != delivery.DataLane {
+		return errors.New("lane has to be alert or data")
+	}
+	if len(r.Action.Room) != 0 && len(r.Action.Users) != 0 {
+		return errors.New("a rule can't send to both the users and a room")
+	}
+	for _, p := range append(r.Action.Remove, fieldPaths(r.Action.Set)...) {
+		if len(p) == 0 || strings.HasPrefix(p, ".") || strings.HasSuffix(p, ".") {
+			return errors.New("invalid payload field " + p)
+		}
+	}
+	return nil
+}
+
+//fieldPaths returns the paths of the fields
+func fieldPaths(fields map[string]interface{}) []string {
+	result := make([]string, 0, len(fields))
+	for p := range fields {
+		result = append(result, p)
+	}
+	return result
+}
+
+//matches reports whether the event matches the conditions of the rule. payload is the generic json payload of the event
+func (m RuleMatch) matches(e *bus.Event, payload interface{}) bool {
+	if len(m.Events) != 0 && !eventMatched(e.Notification.Event, m.Events) {
+		return false
+	}
+	tenant := ""
+	if e.AppContext != nil {
+		tenant = e.AppContext.Tenant
+	}
+	if !contains(m.Tenants, tenant) {
+		return false
+	}
+	for p, v := range m.Fields {
+		f, ok := field(payload, p)
+		if !ok || fmt.Sprint(f) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+//eventMatched reports whether the event matches any of the names. A name ending with * matches the prefix
+func eventMatched(event string, names []string) bool {
+	for _, n := range names {
+		if n == event || (strings.HasSuffix(n, "*") && strings.HasPrefix(event, strings.TrimSuffix(n, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+//genericPayload returns the payload as the generic json values
+func genericPayload(payload interface{}) (interface{}, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	return v, err
+}
+
+//field returns the value of the field at the dotted path in the generic json payload
+func field(payload interface{}, path string) (interface{}, bool) {
+	v := payload
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+//setField sets the value of the field at the dotted path in the generic json payload. The missing objects are created.
+//The payload is returned as an object is created if it isn't one
+func setField(payload interface{}, path string, value interface{}) interface{} {
+	root, ok := payload.(map[string]interface{})
+	if !ok {
+		root = map[string]interface{}{}
+	}
+	m, ks := root, strings.Split(path, ".")
+	for _, k := range ks[:len(ks)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[k] = next
+		}
+		m = next
+	}
+	m[ks[len(ks)-1]] = value
+	return root
+}
+
+//removeField removes the field at the dotted path in the generic json payload
+func removeField(payload interface{}, path string) {
+	ks := strings.Split(path, ".")
+	parent, ok := field(payload, strings.Join(ks[:len(ks)-1], "."))
+	if len(ks) == 1 {
+		parent, ok = payload, true
+	}
+	if m, isMap := parent.(map[string]interface{}); ok && isMap {
+		delete(m, ks[len(ks)-1])
+	}
+}
+
+//apply makes the changes of the action to the event. payload is the generic json payload of the event.
+//It returns the payload after the changes
+func (a RuleAction) apply(e *bus.Event, payload interface{}) interface{} {
+	if len(a.Room) != 0 && e.Conns == nil && e.AppContext != nil {
+		e.Room, e.Live, e.Users = roomKey(e.AppContext.Tenant, a.Room), true, nil
+		e.Notification.Room = a.Room
+	}
+	if len(a.Users) != 0 && len(e.Room) == 0 && e.Conns == nil {
+		for _, u := range a.Users {
+			if !containsUser(e.Users, u) {
+				e.Users = append(e.Users, u)
+			}
+		}
+	}
+	if len(a.Lane) != 0 {
+		e.Notification.Lane = a.Lane
+	}
+	if a.Ack {
+		e.Notification.Ack = true
+	}
+	if len(a.Urgency) != 0 {
+		e.Notification.Urgency = a.Urgency
+	}
+	if len(a.Event) != 0 {
+		e.Notification.Event = a.Event
+	}
+	for p, v := range a.Set {
+		payload = setField(payload, p, v)
+	}
+	for _, p := range a.Remove {
+		removeField(payload, p)
+	}
+	return payload
+}
+
+//containsUser reports whether the user is in the list
+func containsUser(users []uint, u uint) bool {
+	for _, v := range users {
+		if v == u {
+			return true
+		}
+	}
+	return false
+}
+
+//rulesEvent applies the routing rules matching the event in their order
+func rulesEvent(e *bus.Event) error {
+	/*
+	 * We will get the rules
+	 * We will get the generic payload to match and change the fields
+	 * Then we will apply the matched rules in their order
+	 * The changed payload is set back to the notification
+	 */
+	rs := currentRules()
+	if len(rs) == 0 {
+		return nil
+	}
+
+	//getting the payload
+	payload, err := genericPayload(e.Notification.Payload)
+	if err != nil {
+		e.AppContext.Log.Error("error while decoding the payload of the event", e.Notification.Event, "for the routing rules", err.Error())
+		return nil
+	}
+
+	//applying the rules
+	changed := false
+	for _, r := range rs {
+		if r.Disabled || !r.Match.matches(e, payload) {
+			continue
+		}
+		if r.Action.Drop {
+			e.AppContext.Log.Info("dropping the event", e.Notification.Event, "from", e.Source, "as per the routing rule", r.Name)
+			return bus.ErrHalt
+		}
+		payload = r.Action.apply(e, payload)
+		changed = changed || len(r.Action.Set) != 0 || len(r.Action.Remove) != 0
+		if r.Stop {
+			break
+		}
+	}
+	if changed {
+		e.Notification.Payload = payload
+	}
+	return nil
+}
+
+//Rules adds a routing rule with POST, lists the rules with GET
+//and deletes the rule given in the id query param with DELETE
+func Rules(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	switch req.Method {
+	case http.MethodPost:
+		r := &Rule{}
+		if err := decode(req, r); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the routing rule", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if err := r.validate(); err != nil {
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		n := len(currentRules())
+		if len(r.ID) == 0 && n >= config.MaxRules {
+			response.WriteError(res, response.Error{Err: "Max no. of routing rules reached"}, http.StatusConflict)
+			return
+		}
+		if len(r.ID) == 0 {
+			r.ID = newID()
+		}
+		r.CreatedBy, r.CreatedAt = appCtx.Session.User.ID, time.Now()
+		b, err := json.Marshal(r)
+		if err == nil {
+			err = store.Default.Set(rulesPrefix+r.ID, b, 0)
+		}
+		if err != nil {
+			appCtx.Log.Error("error while saving the routing rule", r.Name, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't save the routing rule"}, http.StatusInternalServerError)
+			return
+		}
+		rulesLock.Lock()
+		updated := make([]Rule, 0, len(rules)+1)
+		for _, v := range rules {
+			if v.ID != r.ID {
+				updated = append(updated, v)
+			}
+		}
+		updated = append(updated, *r)
+		sortRules(updated)
+		rules = updated
+		rulesLock.Unlock()
+		log.Info("AUDIT: routing rule", r.ID, r.Name, "saved by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "routing rule saved", Data: r})
+	case http.MethodGet:
+		response.Write(res, response.Message{Message: "routing rules", Data: currentRules()})
+	case http.MethodDelete:
+		id := req.URL.Query().Get("id")
+		found := false
+		for _, r := range currentRules() {
+			found = found || r.ID == id
+		}
+		if !found {
+			response.WriteError(res, response.Error{Err: "Couldn't find the routing rule " + id}, http.StatusNotFound)
+			return
+		}
+		if err := store.Default.Delete(rulesPrefix + id); err != nil {
+			appCtx.Log.Error("error while deleting the routing rule", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't delete the routing rule"}, http.StatusInternalServerError)
+			return
+		}
+		rulesLock.Lock()
+		updated := make([]Rule, 0, len(rules))
+		for _, r := range rules {
+			if r.ID != id {
+				updated = append(updated, r)
+			}
+		}
+		rules = updated
+		rulesLock.Unlock()
+		log.Info("AUDIT: routing rule", id, "deleted by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "routing rule deleted"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	bus.Use(bus.Enrich, rulesEvent)
+	config.Refresh("rules", config.RulesRefresh, loadRules)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Rules),
+		Pattern:     "/admin/rules",
+	})
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+)
+
+/*
+ * This file contains the sampling of the high volume events like the telemetry where the freshness matters
+ * more than the completeness. The events with a sampling policy are sampled per target user.
+ * The events not delivered are held back and only the latest of them is kept. Once the events of the user go quiet
+ * for the flush interval, the latest held event is delivered. So the user always ends up with the latest event.
+ * Room events, the urgent events and the events sent to the connections resolved by the publisher are not sampled.
+ * The sampling state is kept per instance.
+ */
+
+//sampler is the sampling state of an event of a user
+type sampler struct {
+	//count is the no. of events of the user
+	count int
+	//windowStart is the start of the minute window of the rate
+	windowStart time.Time
+	//sent is the no. of events delivered in the window
+	sent int
+	//held is the latest event held back by the sampling
+	held *bus.Event
+	//lastSeen is the time at which the last event came
+	lastSeen time.Time
+}
+
+//samplerKey is the key of the sampling state of an event of a user
+type samplerKey struct {
+	userID uint
+	event  string
+}
+
+var (
+	//samplers has the sampling states mapped by the user and the event
+	samplers = map[samplerKey]*sampler{}
+	//samplersLock is the lock for the sampling states
+	samplersLock sync.Mutex
+)
+
+//allow reports whether the event can be delivered now as per the policy. It has to be called with the samplers lock
+func (s *sampler) allow(p config.SamplingPolicy, now time.Time) bool {
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart, s.sent = now, 0
+	}
+	return p.PerMinute <= 0 || s.sent < p.PerMinute
+}
+
+//sampleEvent drops the target users of the event to whom it isn't to be delivered as per its sampling policy.
+//The event is held back as the latest event of the dropped users
+func sampleEvent(e *bus.Event) error {
+	/*
+	 * We will skip the events without a policy, the urgent ones or the ones already sampled
+	 * Then we will sample the event for each target user
+	 * If no user is left, the event is halted
+	 */
+	p, ok := config.SamplingPolicies[e.Notification.Event]
+	if !ok || e.Sampled || e.Notification.Priority.Urgent() || len(e.Users) == 0 || e.Conns != nil {
+		return nil
+	}
+	e.Sampled = true
+
+	now := time.Now()
+	users := make([]uint, 0, len(e.Users))
+	samplersLock.Lock()
+	for _, u := range e.Users {
+		k := samplerKey{userID: u, event: e.Notification.Event}
+		s, ok := samplers[k]
+		if !ok {
+			s = &sampler{windowStart: now}
+			samplers[k] = s
+		}
+		s.count++
+		s.lastSeen = now
+		if (p.OneIn <= 1 || (s.count-1)%p.OneIn == 0) && s.allow(p, now) {
+			s.sent++
+			s.held = nil
+			users = append(users, u)
+			continue
+		}
+		held := *e
+		held.Users = []uint{u}
+		s.held = &held
+		delivery.Trace(delivery.StageDropped, u, e.Notification, "by the sampling of the event. It is held as the latest event")
+	}
+	samplersLock.Unlock()
+
+	if len(users) == 0 {
+		return bus.ErrHalt
+	}
+	e.Users = users
+	return nil
+}
+
+//flushSamplers delivers the latest held events of the users whose events went quiet for the flush interval.
+//The idle sampling states are dropped
+func flushSamplers(appCtx *config.AppContext, now time.Time) {
+	/*
+	 * We will find the held events which can be delivered
+	 * Then we will publish them outside the lock
+	 */
+	flush := []*bus.Event{}
+	samplersLock.Lock()
+	for k, s := range samplers {
+		if s.held == nil {
+			if now.Sub(s.lastSeen) >= time.Minute {
+				delete(samplers, k)
+			}
+			continue
+		}
+		if now.Sub(s.lastSeen) < config.SamplingFlush || !s.allow(config.SamplingPolicies[k.event], now) {
+			continue
+		}
+		s.sent++
+		s.held.AppContext = appCtx
+		flush = append(flush, s.held)
+		s.held = nil
+	}
+	samplersLock.Unlock()
+
+	for _, e := range flush {
+		if err := bus.Publish(e); err != nil {
+			log.Error("error while publishing the latest held event", e.Notification.Event, "for the user", e.Users, err.Error())
+		}
+	}
+}
+
+//runSamplers flushes the held events periodically
+func runSamplers() {
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	t := time.NewTicker(config.SamplingFlush)
+	defer t.Stop()
+	for now := range t.C {
+		flushSamplers(appCtx, now)
+	}
+}
+
+func init() {
+	if len(config.SamplingPolicies) == 0 {
+		return
+	}
+	bus.Use(bus.Enrich, sampleEvent)
+	go runSamplers()
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the local cache of the sessions validated by the auth provider.
+ * Only the valid sessions are cached, for the session cache ttl. The tokens are kept hashed.
+ * Sessions revoked in the identity system are invalidated as the auth provider reports them or the services push them.
+ * With the providers not reporting the revocations, they stay valid here till they expire from the cache
+ * unless they are invalidated explicitly.
+ */
+
+//cachedSession is a session validated by the auth provider
+type cachedSession struct {
+	//user of the session
+	user authModels.User
+	//expiresAt is the time at which the session is to be validated again
+	expiresAt time.Time
+}
+
+//SessionCacheStats has the stats of the session cache
+type SessionCacheStats struct {
+	//Size is the no. of sessions cached
+	Size int `json:"size"`
+	//Hits is the no. of lookups served from the cache
+	Hits uint64 `json:"hits"`
+	//Misses is the no. of lookups which went to the auth provider
+	Misses uint64 `json:"misses"`
+}
+
+var (
+	//sessionCache has the cached sessions mapped by the hash of their token
+	sessionCache = map[string]cachedSession{}
+	//sessionCacheStats has the hits and misses of the cache
+	sessionCacheStats SessionCacheStats
+	//sessionCacheLock is the lock for the session cache
+	sessionCacheLock sync.Mutex
+)
+
+//sessionKey returns the cache key of the token
+func sessionKey(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+//authenticatedUser returns the user of the auth token from the session cache or else from the auth provider
+func authenticatedUser(token string) (authModels.User, bool) {
+	/*
+	 * We will check the cache
+	 * Then we will get the user from the auth provider
+	 * Then we will cache the valid session, evicting the expired ones if the cache is full
+	 */
+	//checking the cache
+	if config.SessionCacheTTL <= 0 {
+		return config.Auth().ValidateSession(token)
+	}
+	k, now := sessionKey(token), time.Now()
+	sessionCacheLock.Lock()
+	s, ok := sessionCache[k]
+	if ok && now.Before(s.expiresAt) {
+		sessionCacheStats.Hits++
+		sessionCacheLock.Unlock()
+		return s.user, true
+	}
+	sessionCacheStats.Misses++
+	sessionCacheLock.Unlock()
+
+	//getting the user from the auth provider
+	u, ok := config.Auth().ValidateSession(token)
+	if !ok {
+		sessionCacheLock.Lock()
+		delete(sessionCache, k)
+		sessionCacheLock.Unlock()
+		return u, false
+	}
+
+	//caching the session
+	sessionCacheLock.Lock()
+	defer sessionCacheLock.Unlock()
+	if len(sessionCache) >= config.SessionCacheSize {
+		for ck, cs := range sessionCache {
+			if !now.Before(cs.expiresAt) {
+				delete(sessionCache, ck)
+			}
+		}
+	}
+	for ck := range sessionCache {
+		if len(sessionCache) < config.SessionCacheSize {
+			break
+		}
+		delete(sessionCache, ck)
+	}
+	sessionCache[k] = cachedSession{user: u, expiresAt: now.Add(config.SessionCacheTTL)}
+	return u, true
+}
+
+//InvalidateSession removes the session of the token from the cache. It returns whether the session was cached
+func InvalidateSession(token string) bool {
+	sessionCacheLock.Lock()
+	defer sessionCacheLock.Unlock()
+	k := sessionKey(token)
+	_, ok := sessionCache[k]
+	delete(sessionCache, k)
+	return ok
+}
+
+//InvalidateUserSessions removes the sessions of the user from the cache. 0 removes all the sessions.
+//It returns the no. of sessions removed
+func InvalidateUserSessions(userID uint) int {
+	sessionCacheLock.Lock()
+	defer sessionCacheLock.Unlock()
+	removed := 0
+	for k, s := range sessionCache {
+		if userID == 0 || s.user.ID == userID {
+			delete(sessionCache, k)
+			removed++
+		}
+	}
+	return removed
+}
+
+//WatchRevocations invalidates the cached sessions revoked in the identity system and closes their connections
+//till the context is cancelled.
+//It returns without watching if the auth provider doesn't report the revocations
+func WatchRevocations(ctx context.Context) {
+	ch, err := config.Auth().WatchRevocations(ctx)
+	if err == config.ErrNotSupported {
+		log.Info("auth provider", config.AuthProviderName, "doesn't report the revoked sessions. They expire from the session cache in", config.SessionCacheTTL)
+		return
+	}
+	if err != nil {
+		log.Error("couldn't watch the revoked sessions with the auth provider", config.AuthProviderName, err.Error())
+		return
+	}
+	go func() {
+		for r := range ch {
+			if closed := revokeSession(r); closed != 0 {
+				log.Info("closed", closed, "websocket connections of the session revoked for user", r.UserID)
+			}
+		}
+	}()
+}
+
+//SessionCache returns the stats of the session cache with GET and invalidates the cached sessions
+//of the user given in the user query param with DELETE. DELETE without the user invalidates all the sessions
+func SessionCache(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	switch req.Method {
+	case http.MethodGet:
+		sessionCacheLock.Lock()
+		s := sessionCacheStats
+		s.Size = len(sessionCache)
+		sessionCacheLock.Unlock()
+		response.Write(res, response.Message{Message: "session cache", Data: s})
+	case http.MethodDelete:
+		userID := uint64(0)
+		if u := req.URL.Query().Get("user"); len(u) != 0 {
+			id, err := strconv.ParseUint(u, 10, 64)
+			if err != nil {
+				response.WriteError(res, response.Error{Err: "Invalid Params user " + err.Error()}, http.StatusBadRequest)
+				return
+			}
+			userID = id
+		}
+		removed := InvalidateUserSessions(uint(userID))
+		log.Info("AUDIT:", removed, "cached sessions of user", userID, "invalidated by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "sessions invalidated", Data: map[string]int{"invalidated": removed}})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(SessionCache),
+		Pattern:     "/admin/session-cache",
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	authConfig "github.com/cuttle-ai/auth-service/config"
+	authModels "github.com/cuttle-ai/auth-service/models"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the verification of the signed requests.
+ * The ingest requests of the third party sources sign the body with the shared secret as hex(hmac-sha256(secret, timestamp + "." + body)).
+ * The internal services sign the notification requests so that they don't need the user cookies. They sign the method and the path
+ * along with the body as hex(hmac-sha256(secret, timestamp + "." + method + "." + path + "." + body)), so that a signed request
+ * can't be sent to another endpoint. A signature is accepted only once within the skew window, so a captured request can't be replayed.
+ */
+
+//seenPrefix is the store key prefix of the signatures already accepted
+const seenPrefix = "signatures/"
+
+//signedTarget returns the method and the path of the request signed along with the body of the notification requests
+func signedTarget(req *http.Request) string {
+	return req.Method + "." + req.URL.Path + "."
+}
+
+//verifySignature verifies the timestamp and the signature of the target and the request body with the key.
+//The target is signed between the timestamp and the body
+func verifySignature(key []byte, maxSkew time.Duration, req *http.Request, target string, body []byte) bool {
+	/*
+	 * We will check whether the timestamp is within the allowed skew
+	 * Then we will compute the signature and compare it in constant time
+	 */
+	ts := req.Header.Get(IngestTimestampHeader)
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || math.Abs(time.Since(time.Unix(t, 0)).Seconds()) > maxSkew.Seconds() {
+		return false
+	}
+	sig := strings.TrimPrefix(req.Header.Get(IngestSignatureHeader), "sha256=")
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ts + "." + target))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+//firstSeen records the signature of the kind as seen for the ttl and reports whether it wasn't seen before.
+//The ttl must cover the window in which the timestamp of the signature is accepted
+func firstSeen(kind, sig string, ttl time.Duration) (bool, error) {
+	return store.Default.SetNX(seenPrefix+kind+"/"+strings.ToLower(strings.TrimPrefix(sig, "sha256=")), []byte{1}, ttl)
+}
+
+//signedSession returns the session of the signing service user if the request is signed with any of the
+//signing secrets. The body is verified before it is decoded and restored for the handler.
+//The requests without a signature are authenticated with the auth cookie or the bearer token
+func signedSession(res http.ResponseWriter, req *http.Request) (authConfig.Session, bool) {
+	/*
+	 * If the request isn't signed, we will get the session of the logged in user
+	 * We will read the body and verify its signature with the signing secrets
+	 * Then we will reject the signature if it was already accepted
+	 * Then we will restore the body and return the session of the signing service user
+	 */
+	//checking the signature
+	if len(req.Header.Get(IngestSignatureHeader)) == 0 || len(config.SigningSecrets) == 0 {
+		return session(res, req)
+	}
+
+	//reading and verifying the body
+	body, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, config.SigningMaxBodySize))
+	if err != nil {
+		log.Error("error while reading the signed request body", err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't read the request body"}, http.StatusBadRequest)
+		return authConfig.Session{}, false
+	}
+	req.Body.Close()
+	verified := false
+	for _, s := range config.SigningSecrets {
+		if verifySignature([]byte(s), config.SigningMaxSkew, req, signedTarget(req), body) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		log.Warn("invalid signature for the signed request to", req.URL.Path, "from the ip", clientIP(req))
+		response.WriteError(res, response.Error{Err: "Invalid signature"}, http.StatusUnauthorized)
+		return authConfig.Session{}, false
+	}
+
+	//rejecting the replayed signature. The timestamps are accepted on either side of now within the skew
+	first, err := firstSeen("signed", req.Header.Get(IngestSignatureHeader), 2*config.SigningMaxSkew)
+	if err != nil {
+		log.Error("error while recording the signature of the signed request to", req.URL.Path, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't verify the signature"}, http.StatusServiceUnavailable)
+		return authConfig.Session{}, false
+	}
+	if !first {
+		log.Warn("replayed signature for the signed request to", req.URL.Path, "from the ip", clientIP(req))
+		response.WriteError(res, response.Error{Err: "Signature was already used"}, http.StatusUnauthorized)
+		return authConfig.Session{}, false
+	}
+
+	//restoring the body
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return authConfig.Session{ID: "signed", Authenticated: true, User: &authModels.User{ID: config.SigningUserID}}, true
+}
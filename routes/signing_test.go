@@ -0,0 +1,103 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+//sign returns the hex signature of the target and the body signed at the timestamp with the key
+func sign(key, ts, target, body string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(ts + "." + target + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//signedRequest returns the request to the path with the body, the timestamp and the signature headers
+func signedRequest(method, path, body, ts, sig string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(IngestTimestampHeader, ts)
+	req.Header.Set(IngestSignatureHeader, sig)
+	return req
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10)
+	body := `{"event":"signed"}`
+	target := "POST./v1/notification/send."
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		ts     string
+		sig    string
+		valid  bool
+	}{
+		{"valid", http.MethodPost, "/v1/notification/send", ts, "sha256=" + sign("secret", ts, target, body), true},
+		{"valid without the prefix", http.MethodPost, "/v1/notification/send", ts, sign("secret", ts, target, body), true},
+		{"other key", http.MethodPost, "/v1/notification/send", ts, "sha256=" + sign("other", ts, target, body), false},
+		{"other path", http.MethodPost, "/v1/notification/send/bulk", ts, "sha256=" + sign("secret", ts, target, body), false},
+		{"other method", http.MethodPut, "/v1/notification/send", ts, "sha256=" + sign("secret", ts, target, body), false},
+		{"body only", http.MethodPost, "/v1/notification/send", ts, "sha256=" + sign("secret", ts, "", body), false},
+		{"stale timestamp", http.MethodPost, "/v1/notification/send", stale, "sha256=" + sign("secret", stale, target, body), false},
+		{"future timestamp", http.MethodPost, "/v1/notification/send", future, "sha256=" + sign("secret", future, target, body), false},
+		{"missing timestamp", http.MethodPost, "/v1/notification/send", "", "sha256=" + sign("secret", "", target, body), false},
+		{"bad hex", http.MethodPost, "/v1/notification/send", ts, "sha256=zz" + sign("secret", ts, target, body)[2:], false},
+		{"empty signature", http.MethodPost, "/v1/notification/send", ts, "", false},
+	}
+	for _, c := range cases {
+		req := signedRequest(c.method, c.path, body, c.ts, c.sig)
+		if got := verifySignature([]byte("secret"), 5*time.Minute, req, signedTarget(req), []byte(body)); got != c.valid {
+			t.Errorf("%s: expected the signature to be valid %t. got %t", c.name, c.valid, got)
+		}
+	}
+}
+
+func TestSignedSessionReplay(t *testing.T) {
+	config.SigningSecrets = []string{"new-secret", "old-secret"}
+	config.SigningUserID = 77
+	defer func() { config.SigningSecrets = []string{} }()
+	body := `{"event":"signed-replay"}`
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := "sha256=" + sign("old-secret", ts, "POST./v1/notification/send.", body)
+
+	cases := []struct {
+		name string
+		sig  string
+		code int
+	}{
+		{"signed with the old secret", sig, http.StatusOK},
+		{"replayed", sig, http.StatusUnauthorized},
+		{"replayed in upper case", strings.ToUpper(sig[len("sha256="):]), http.StatusUnauthorized},
+		{"forged", "sha256=" + sign("forged", ts, "POST./v1/notification/send.", body), http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		res := httptest.NewRecorder()
+		sess, ok := signedSession(res, signedRequest(http.MethodPost, "/v1/notification/send", body, ts, c.sig))
+		if c.code == http.StatusOK {
+			if !ok || sess.User.ID != config.SigningUserID {
+				t.Errorf("%s: expected the session of the signing user. got %t %d %s", c.name, ok, res.Code, res.Body.String())
+			}
+			continue
+		}
+		if ok || res.Code != c.code {
+			t.Errorf("%s: expected %d. got %t %d %s", c.name, c.code, ok, res.Code, res.Body.String())
+		}
+	}
+}
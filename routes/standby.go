@@ -0,0 +1,65 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the promotion of the warm standby instance and the warmers of its caches
+ */
+
+//StandbyStatus is the standby status of the instance
+type StandbyStatus struct {
+	//Standby is true if the instance is a standby yet to be promoted
+	Standby bool `json:"standby"`
+}
+
+//Standby returns the standby status of the instance with GET and promotes the standby instance to active with POST
+func Standby(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	switch req.Method {
+	case http.MethodGet:
+		response.Write(res, response.Message{Message: "standby status", Data: StandbyStatus{Standby: config.Standby()}})
+	case http.MethodPost:
+		started := time.Now()
+		ok, err := config.Promote()
+		if err != nil {
+			appCtx.Log.Error("error while promoting the standby instance", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't promote the instance " + err.Error()}, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			response.WriteError(res, response.Error{Err: "Instance is already active"}, http.StatusConflict)
+			return
+		}
+		log.Info("AUDIT: standby instance promoted to active by admin", appCtx.Session.User.ID, "in", time.Since(started))
+		response.Write(res, response.Message{Message: "instance promoted", Data: StandbyStatus{}})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	config.RegisterWarmer("auth", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.HealthCheckTimeout)
+		defer cancel()
+		if err := config.CheckAuth(ctx); err != nil {
+			log.Warn("auth service isn't reachable from the standby instance", err.Error())
+		}
+	})
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Standby),
+		Pattern:     "/admin/standby",
+	})
+}
@@ -0,0 +1,201 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/store"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the state events. A state event has a key and only its latest value is kept, like the
+ * retained messages of mqtt. Eg. status of a job or the presence list of a dashboard.
+ * Clients subscribe to the keys with the subscribe-state event and immediately receive the current state of the keys.
+ * After that every update of the keys is emitted to them till they unsubscribe or disconnect.
+ * The keys are scoped by the tenant of the publisher and the subscriber.
+ */
+
+//StateEvent is the default event with which the state is emitted to the subscribers
+const StateEvent = "state"
+
+//State is the latest value of a state key
+type State struct {
+	//Key of the state
+	Key string `json:"key"`
+	//Event with which the state is emitted. Defaults to state
+	Event string `json:"event,omitempty"`
+	//Payload is the value of the state
+	Payload interface{} `json:"payload"`
+	//TTLMs is the time in milliseconds after which the state expires. 0 means it never expires
+	TTLMs int64 `json:"ttlMs,omitempty"`
+	//UpdatedAt is the time at which the state was last updated
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+//notification returns the notification with which the state is emitted to the subscribers
+func (s State) notification() delivery.Notification {
+	n := delivery.Notification{Lane: delivery.DataLane}
+	n.Event = s.Event
+	n.Payload = s
+	return n
+}
+
+var (
+	//stateSubscribers has the subscribed connections of each state key mapped by the scoped key
+	stateSubscribers = map[string]map[string]socketio.Conn{}
+	//stateLock is the lock for the state subscribers
+	stateLock sync.RWMutex
+)
+
+//stateKey returns the key of the state scoped by the tenant
+func stateKey(tenant, key string) string {
+	return "state/" + tenant + "/" + key
+}
+
+//onSubscribeState subscribes the connection to the keys and sends their current state
+func onSubscribeState(conn socketio.Conn, keys []string) {
+	/*
+	 * We will add the connection to the subscribers of the keys
+	 * Then we will send the current state of the keys
+	 */
+	appCtx := conn.Context().(*config.AppContext)
+	stateLock.Lock()
+	for _, k := range keys {
+		sk := stateKey(appCtx.Tenant, k)
+		subs, ok := stateSubscribers[sk]
+		if !ok {
+			subs = map[string]socketio.Conn{}
+			stateSubscribers[sk] = subs
+		}
+		subs[conn.ID()] = conn
+	}
+	stateLock.Unlock()
+
+	//sending the current state
+	for _, k := range keys {
+		b, err := store.Default.Get(stateKey(appCtx.Tenant, k))
+		if err == store.ErrNotFound {
+			continue
+		}
+		s := State{}
+		if err == nil {
+			err = json.Unmarshal(b, &s)
+		}
+		if err != nil {
+			appCtx.Log.Error("error while getting the state", k, "for the connection", conn.ID(), err.Error())
+			continue
+		}
+		if err := delivery.Send(conn, s.notification()); err != nil {
+			appCtx.Log.Error("error while sending the state", k, "to the connection", conn.ID(), err.Error())
+		}
+	}
+}
+
+//onUnsubscribeState unsubscribes the connection from the keys
+func onUnsubscribeState(conn socketio.Conn, keys []string) {
+	appCtx := conn.Context().(*config.AppContext)
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	for _, k := range keys {
+		sk := stateKey(appCtx.Tenant, k)
+		delete(stateSubscribers[sk], conn.ID())
+		if len(stateSubscribers[sk]) == 0 {
+			delete(stateSubscribers, sk)
+		}
+	}
+}
+
+//unsubscribeAllState unsubscribes the connection from all the keys
+func unsubscribeAllState(conn socketio.Conn) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	for sk, subs := range stateSubscribers {
+		delete(subs, conn.ID())
+		if len(subs) == 0 {
+			delete(stateSubscribers, sk)
+		}
+	}
+}
+
+//PublishState sets the latest value of the state key in the tenant of the publisher and emits it to the subscribers
+func PublishState(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request payload
+	 * Then we will keep the state in the store
+	 * Then we will emit it to the subscribers
+	 * Will write the response
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parse the request payload
+	s := &State{}
+	err := decode(req, s)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the state", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if len(s.Key) == 0 {
+		response.WriteError(res, response.Error{Err: "Invalid Params key is missing"}, http.StatusBadRequest)
+		return
+	}
+	if len(s.Event) == 0 {
+		s.Event = StateEvent
+	}
+	s.UpdatedAt = time.Now()
+
+	//keeping the state
+	sk := stateKey(appCtx.Tenant, s.Key)
+	b, err := json.Marshal(s)
+	if err == nil {
+		err = store.Default.Set(sk, b, time.Duration(s.TTLMs)*time.Millisecond)
+	}
+	if err != nil {
+		appCtx.Log.Error("error while keeping the state", s.Key, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't keep the state"}, http.StatusInternalServerError)
+		return
+	}
+
+	//emitting to the subscribers
+	stateLock.RLock()
+	subs := make([]socketio.Conn, 0, len(stateSubscribers[sk]))
+	for _, conn := range stateSubscribers[sk] {
+		subs = append(subs, conn)
+	}
+	stateLock.RUnlock()
+	for _, conn := range subs {
+		if err := delivery.Send(conn, s.notification()); err != nil {
+			appCtx.Log.Error("error while sending the state", s.Key, "to the connection", conn.ID(), err.Error())
+		}
+	}
+	log.Info("published the state", s.Key, "in tenant", appCtx.Tenant, "to", len(subs), "subscribers by", appCtx.Session.User.ID)
+	response.Write(res, response.Message{Message: "published the state", Data: map[string]int{"subscribers": len(subs)}})
+}
+
+func init() {
+	config.RegisterWebsocketEvents(config.Namespace, "subscribe-state", onSubscribeState)
+	config.RegisterWebsocketEvents(config.Namespace, "unsubscribe-state", onUnsubscribeState)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(PublishState),
+		Pattern:     "/notification/state",
+		Scope:       apikey.ScopeNotifyBroadcast,
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/store"
+)
+
+/*
+ * This file contains the suppression of the duplicate notifications. Eg. the bursts of identical alerts fired by the anomaly detector.
+ * A notification with the same event and payload as one sent to a user within the dedup window is suppressed for the user.
+ * The notifications sent to a user are remembered in the store for the window, so the duplicates are suppressed across the instances.
+ * The suppression runs after the pause and the sampling so that the events held back by them aren't suppressed on their delivery.
+ * The live events, the escalations and the unread counts are never suppressed.
+ */
+
+//dedupPrefix is the store key prefix of the notifications sent to the users within the dedup window
+const dedupPrefix = "dedup/"
+
+//dedupWindow returns the dedup window of the notification. 0 if it isn't deduplicated
+func dedupWindow(n delivery.Notification) time.Duration {
+	if n.DedupWindow > 0 {
+		return time.Duration(n.DedupWindow) * time.Second
+	}
+	return config.DedupWindow
+}
+
+//dedupEvent drops the target users of the event who were sent the same event with the same payload within the dedup window.
+//The urgent notifications aren't deduplicated
+func dedupEvent(e *bus.Event) error {
+	/*
+	 * We will skip the events which aren't deduplicated and the urgent ones
+	 * Then we will hash the payload
+	 * Then we will keep the users to whom the notification wasn't sent within the window
+	 * If no user is left, the event is halted
+	 */
+	window := dedupWindow(e.Notification)
+	if window <= 0 || e.Live || len(e.Users) == 0 || e.Notification.Priority.Urgent() || e.Source == bus.SourceEscalation || e.Source == bus.SourceUnread {
+		return nil
+	}
+
+	//hashing the payload
+	p, err := json.Marshal(e.Notification.Payload)
+	if err != nil {
+		e.AppContext.Log.Error("error while hashing the payload of the notification", e.Notification.Event, ". Delivering it", err.Error())
+		return nil
+	}
+	sum := sha256.Sum256(p)
+	hash := hex.EncodeToString(sum[:])
+
+	//keeping the users to whom it wasn't sent
+	users := make([]uint, 0, len(e.Users))
+	for _, u := range e.Users {
+		k := dedupPrefix + strconv.FormatUint(uint64(u), 10) + "/" + e.Notification.Event + "/" + hash
+		first, err := store.Default.SetNX(k, []byte(e.Notification.Event), window)
+		if err != nil {
+			e.AppContext.Log.Error("error while checking the duplicate notification", e.Notification.Event, "of user", u, ". Delivering it", err.Error())
+		}
+		if first || err != nil {
+			users = append(users, u)
+			continue
+		}
+		delivery.CountDeduplicated()
+		delivery.Trace(delivery.StageDropped, u, e.Notification, "as the same notification was sent within the dedup window of ", window)
+		e.AppContext.Log.Info("suppressing the duplicate notification", e.Notification.Event, "from", e.Source, "for user", u, log.F("event", e.Notification.Event), log.F("targetUserId", u))
+	}
+	e.Users = users
+	if len(users) == 0 {
+		return bus.ErrHalt
+	}
+	return nil
+}
+
+func init() {
+	bus.Use(bus.Enrich, dedupEvent)
+}
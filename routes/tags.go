@@ -0,0 +1,119 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the emission of the notifications by the tags of the application context of the connections.
+ * Clients send the application context at the handshake and update it with the context event as the user navigates.
+ * The notifications emitted by tags are live only. They are not recorded for the replay.
+ */
+
+//TagEmit is a notification to be emitted to the connections having the tags
+type TagEmit struct {
+	delivery.Notification
+	//Tags the application context of the connections must have. All of them must match
+	Tags []string `json:"tags"`
+	//Tenants to which the users of the connections must belong. Empty matches every tenant
+	Tenants []string `json:"tenants"`
+}
+
+//onContext updates the application context of the connection as the user navigates
+func onContext(conn socketio.Conn, blob map[string]interface{}) {
+	appCtx := conn.Context().(*config.AppContext)
+	if b, err := json.Marshal(blob); err != nil || len(b) > config.ClientContextMaxSize {
+		appCtx.Log.Warn("ignoring the invalid or too large application context from the connection", conn.ID())
+		return
+	}
+	appCtx.SetClientContext(blob)
+}
+
+//EmitByTag emits the notification to the connections whose application context has all the tags
+func EmitByTag(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the request payload and restrict the tenants to the one of the api key
+	 * Then we will get the websocket connections of all the users
+	 * Then we will publish the live event to the matching connections
+	 * Will write the response with the no. of connections emitted to
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parse the request payload
+	e := &TagEmit{}
+	err := decode(req, e)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the tag emit", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	defer req.Body.Close()
+	if len(e.Event) == 0 || len(e.Tags) == 0 {
+		response.WriteError(res, response.Error{Err: "Invalid Params event and tags are required"}, http.StatusBadRequest)
+		return
+	}
+	var ok bool
+	if e.Tenants, ok = restrictTenants(ctx, e.Tenants); !ok {
+		appCtx.Log.Warn("api key of user", appCtx.Session.User.ID, "tried to emit by tags to the tenants", e.Tenants)
+		writeTenantForbidden(res)
+		return
+	}
+
+	//getting the websocket connections of all the users
+	appCtxReq := AppContextRequest{
+		Type: FetchAllWs,
+		Out:  make(chan AppContextRequest),
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+
+	//matching the connections
+	matched := map[uint][]socketio.Conn{}
+	for uID, conns := range resCtx.UsersWsConns {
+		for _, conn := range conns {
+			cCtx, ok := conn.Context().(*config.AppContext)
+			if !ok || !contains(e.Tenants, cCtx.Tenant) || !cCtx.HasTags(e.Tags) {
+				continue
+			}
+			matched[uID] = append(matched[uID], conn)
+		}
+	}
+
+	//publishing the live event
+	be := &bus.Event{Source: bus.SourceREST, AppContext: appCtx, Notification: e.Notification, Live: true, Conns: matched}
+	if err := bus.Publish(be); err != nil {
+		appCtx.Log.Error("error while publishing the event", e.Event, "by tags", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+	sent := be.Sent
+	log.Info("emitted the event", e.Event, "with tags", e.Tags, "to", sent, "connections by", appCtx.Session.User.ID)
+	response.Write(res, response.Message{Message: "emitted the event by tags", Data: map[string]int{"connections": sent}})
+}
+
+func init() {
+	config.RegisterWebsocketEvents(config.Namespace, "context", onContext)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(EmitByTag),
+		Pattern:     "/notification/emit-by-tag",
+		Scope:       apikey.ScopeNotifyBroadcast,
+	})
+}
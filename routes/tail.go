@@ -0,0 +1,223 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"encoding/json"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the live event tail of the admin namespace for debugging the production issues.
+ * The admins connected to the admin namespace subscribe to a sampled stream of the events flowing through the instance.
+ * Only the event name, targets and payload size are sent, never the payload. Slow subscribers lose the events beyond their buffer.
+ */
+
+//TailEntry is an event flowing through the instance sent to the subscribers of the tail
+type TailEntry struct {
+	//Event name of the notification
+	Event string `json:"event"`
+	//Source is the ingestion path through which the event came
+	Source string `json:"source"`
+	//Tenant of the publisher
+	Tenant string `json:"tenant,omitempty"`
+	//Users are the target users of the event
+	Users []uint `json:"users,omitempty"`
+	//Room is the target room of the event
+	Room string `json:"room,omitempty"`
+	//PayloadSize is the size of the json encoded payload in bytes
+	PayloadSize int `json:"payloadSize"`
+	//Sent is the no. of connections to which the event was sent
+	Sent int `json:"sent"`
+	//Error is the error with which the event failed
+	Error string `json:"error,omitempty"`
+	//At is the time at which the event left the pipeline
+	At time.Time `json:"at"`
+}
+
+//TailFilter filters the events sent to a subscriber of the tail. Empty fields match all the events
+type TailFilter struct {
+	//Events to be sent
+	Events []string `json:"events"`
+	//User whose events are sent
+	User uint `json:"user"`
+	//Tenant whose events are sent
+	Tenant string `json:"tenant"`
+}
+
+//match reports whether the entry matches the filter
+func (f TailFilter) match(t TailEntry) bool {
+	return contains(f.Events, t.Event) && (f.User == 0 || containsUser(t.Users, f.User)) && (len(f.Tenant) == 0 || f.Tenant == t.Tenant)
+}
+
+//tailSubscriber is an admin connection subscribed to the tail
+type tailSubscriber struct {
+	conn    socketio.Conn
+	filter  TailFilter
+	entries chan TailEntry
+	dropped int
+}
+
+var (
+	//tailSubscribers has the subscribers of the tail mapped by the connection id
+	tailSubscribers = map[string]*tailSubscriber{}
+	//tailLock is the lock for the subscribers of the tail
+	tailLock sync.Mutex
+)
+
+//tailEvent sends the sampled events leaving the pipeline to the subscribers of the tail
+func tailEvent(e *bus.Event, err error) {
+	/*
+	 * We will skip if no one has subscribed or the event isn't sampled
+	 * Then we will describe the event
+	 * Then we will send it to the subscribers whose filter matches without blocking
+	 */
+	tailLock.Lock()
+	n := len(tailSubscribers)
+	tailLock.Unlock()
+	if n == 0 || mrand.Float64()*100 >= config.TailSampleRate {
+		return
+	}
+
+	//describing the event
+	t := TailEntry{Event: e.Notification.Event, Source: e.Source, Users: e.Users, Room: e.Notification.Room, Sent: e.Sent, At: time.Now()}
+	if e.AppContext != nil {
+		t.Tenant = e.AppContext.Tenant
+	}
+	if b, mErr := json.Marshal(e.Notification.Payload); mErr == nil {
+		t.PayloadSize = len(b)
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+
+	//sending to the subscribers
+	tailLock.Lock()
+	defer tailLock.Unlock()
+	for _, s := range tailSubscribers {
+		if !s.filter.match(t) {
+			continue
+		}
+		select {
+		case s.entries <- t:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+//emit emits the entries of the subscriber to its connection till it unsubscribes
+func (s *tailSubscriber) emit() {
+	for t := range s.entries {
+		s.conn.Emit(config.TailEvent, t)
+	}
+}
+
+//onTailSubscribe subscribes the admin connection to the tail with the filter. It replaces the existing subscription
+func onTailSubscribe(conn socketio.Conn, f TailFilter) string {
+	appCtx := conn.Context().(*config.AppContext)
+	if !config.IsAdmin(appCtx.Session.User.ID) {
+		log.Warn("AUDIT: non admin user", appCtx.Session.User.ID, "tried to subscribe to the event tail")
+		return "forbidden"
+	}
+	s := &tailSubscriber{conn: conn, filter: f, entries: make(chan TailEntry, config.TailBuffer)}
+	tailLock.Lock()
+	old := tailSubscribers[conn.ID()]
+	tailSubscribers[conn.ID()] = s
+	tailLock.Unlock()
+	if old != nil {
+		close(old.entries)
+	}
+	go s.emit()
+	log.Info("AUDIT: event tail with the events", f.Events, "user", f.User, "tenant", f.Tenant, "subscribed by admin", appCtx.Session.User.ID)
+	return "ok"
+}
+
+//unsubscribeTail stops the subscription of the connection to the tail. It reports whether it was subscribed
+func unsubscribeTail(conn socketio.Conn) bool {
+	tailLock.Lock()
+	s, ok := tailSubscribers[conn.ID()]
+	delete(tailSubscribers, conn.ID())
+	tailLock.Unlock()
+	if !ok {
+		return false
+	}
+	close(s.entries)
+	if s.dropped != 0 {
+		log.Warn("dropped", s.dropped, "events of the tail of the connection", conn.ID(), "as it was slow")
+	}
+	return true
+}
+
+//onTailUnsubscribe stops the subscription of the admin connection to the tail
+func onTailUnsubscribe(conn socketio.Conn) {
+	appCtx := conn.Context().(*config.AppContext)
+	if unsubscribeTail(conn) {
+		log.Info("AUDIT: event tail unsubscribed by admin", appCtx.Session.User.ID)
+	}
+}
+
+//onTailConnect sets the app context of the connection to the admin namespace.
+//The guests can't connect to it and the non admin users are disconnected
+func onTailConnect(conn socketio.Conn) error {
+	/*
+	 * We will get the app context id from the handshake header
+	 * Then we will fetch the app context and set it as the context of the connection
+	 * Then we will open the outbox of the connection. The offline notifications are left for the user's own connections
+	 * Then we will disconnect the user if not an admin
+	 */
+	//getting the app context id
+	contextID, err := connContextID(conn)
+	if err != nil {
+		return err
+	}
+	if contextID < 0 {
+		log.Warn("guest connection", conn.ID(), "tried to connect to the admin namespace")
+		return config.ErrAuthPolicy
+	}
+
+	//fetching the app context
+	appCtxReq := AppContextRequest{
+		Type: Fetch,
+		Out:  make(chan AppContextRequest),
+		ID:   contextID,
+		Ws:   conn,
+	}
+	go SendRequest(AppContextRequestChan, appCtxReq)
+	resCtx := <-appCtxReq.Out
+	conn.SetContext(resCtx.AppContext)
+	resCtx.AppContext.ConnectedAt = time.Now()
+	delivery.Open(conn)
+
+	//checking the admin
+	if !config.IsAdmin(resCtx.AppContext.Session.User.ID) {
+		log.Warn("AUDIT: non admin user", resCtx.AppContext.Session.User.ID, "tried to connect to the admin namespace")
+		config.Disconnect(conn, config.CloseForbidden)
+	}
+	return nil
+}
+
+//onTailDisconnect stops the subscription of the connection to the tail and releases its app context
+func onTailDisconnect(conn socketio.Conn, message string) {
+	unsubscribeTail(conn)
+	onDisconnect(conn, message)
+}
+
+func init() {
+	bus.OnFinish(tailEvent)
+	config.RegisterWebsocketOnConnect(config.TailNamespace, onTailConnect)
+	config.RegisterWebsocketOnError(config.TailNamespace, onError)
+	config.RegisterWebsocketOnDisconnect(config.TailNamespace, onTailDisconnect)
+	config.RegisterWebsocketEvents(config.TailNamespace, "subscribe", onTailSubscribe)
+	config.RegisterWebsocketEvents(config.TailNamespace, "unsubscribe", onTailUnsubscribe)
+}
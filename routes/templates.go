@@ -0,0 +1,110 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/bus"
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/templates"
+)
+
+/*
+ * This file contains the payload templates api and the rendering of the payloads of the notifications sent with a template.
+ * The payload is rendered as the event is validated, so the rest of the pipeline sees the final payload.
+ * The template and the vars are cleared once rendered so that the events held back aren't rendered again.
+ */
+
+//renderEvent renders the payload of the notification from its template and the vars
+func renderEvent(e *bus.Event) error {
+	if len(e.Notification.Template) == 0 {
+		return nil
+	}
+	if e.Notification.Payload != nil {
+		return errors.New("payload and the template " + e.Notification.Template + " can't be given together")
+	}
+	p, err := templates.Render(e.Notification.Template, e.Notification.Vars)
+	if err != nil {
+		return errors.New("couldn't render the template " + e.Notification.Template + ": " + err.Error())
+	}
+	e.Notification.Payload, e.Notification.Template, e.Notification.Vars = p, "", nil
+	return nil
+}
+
+//Templates returns the payload templates with GET, saves a template with POST
+//and deletes the template of the name query param with DELETE
+func Templates(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	switch req.Method {
+	case http.MethodGet:
+		ts, err := templates.List()
+		if err != nil {
+			appCtx.Log.Error("error while getting the payload templates", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't get the payload templates"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "payload templates", Data: ts})
+	case http.MethodPost:
+		t := &templates.Template{}
+		if err := decode(req, t); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the payload template", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if len(t.Name) == 0 {
+			response.WriteError(res, response.Error{Err: "Invalid Params name is required"}, http.StatusBadRequest)
+			return
+		}
+		saved, err := templates.Set(*t)
+		if err != nil {
+			appCtx.Log.Error("error while saving the payload template", t.Name, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't save the payload template " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		log.Info("AUDIT: payload template", t.Name, "saved by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "saved the payload template", Data: saved})
+	case http.MethodDelete:
+		name := req.URL.Query().Get("name")
+		err := templates.Delete(name)
+		if err == templates.ErrNotFound {
+			response.WriteError(res, response.Error{Err: "Payload template not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while deleting the payload template", name, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't delete the payload template"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: payload template", name, "deleted by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "deleted the payload template"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	if err := templates.Init(config.DB()); err != nil {
+		log.Error("error while initing the payload templates", err.Error())
+	}
+	bus.Use(bus.Validate, renderEvent)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Templates),
+		Pattern:     "/admin/templates",
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*
+ * This file contains the auth token passed in the websocket handshake by the browsers which can't attach
+ * the auth cookie cross origin. The token can be passed in the auth token query param or as the websocket
+ * sub protocol following the auth protocol. Eg. Sec-WebSocket-Protocol: cuttle-auth, <token>.
+ * Browsers fail the websocket unless the server selects one of the requested sub protocols. So the auth protocol is
+ * added to the upgrade response written by the websockets server.
+ * The query param ends up in the access logs. So the clients should pass short lived tokens in it.
+ */
+
+const (
+	//AuthTokenParam is the query param with which the clients pass the auth token in the websocket handshake
+	AuthTokenParam = "auth_token"
+	//AuthProtocol is the websocket sub protocol after which the clients pass the auth token
+	AuthProtocol = "cuttle-auth"
+)
+
+//protocolToken returns the auth token passed as the websocket sub protocol following the auth protocol
+func protocolToken(req *http.Request) string {
+	protocols := strings.Split(req.Header.Get("Sec-WebSocket-Protocol"), ",")
+	for i := 0; i+1 < len(protocols); i++ {
+		if strings.TrimSpace(protocols[i]) == AuthProtocol {
+			return strings.TrimSpace(protocols[i+1])
+		}
+	}
+	return ""
+}
+
+//handshakeToken returns the auth token passed in the query param or as the websocket sub protocol
+func handshakeToken(req *http.Request) string {
+	if t := req.URL.Query().Get(AuthTokenParam); len(t) != 0 {
+		return t
+	}
+	return protocolToken(req)
+}
+
+//protocolWriter selects the auth protocol in the websocket upgrade response
+type protocolWriter struct {
+	http.ResponseWriter
+}
+
+//Hijack hijacks the connection for the websocket and wraps it to add the auth protocol to the upgrade response
+func (p protocolWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := p.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	c, rw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &protocolConn{Conn: c}, rw, nil
+}
+
+//protocolConn adds the auth protocol to the websocket upgrade response written in its first write
+type protocolConn struct {
+	net.Conn
+	//written is set after the first write
+	written bool
+}
+
+//Write adds the auth protocol header to the upgrade response unless a sub protocol is already selected
+func (c *protocolConn) Write(b []byte) (int, error) {
+	if c.written {
+		return c.Conn.Write(b)
+	}
+	c.written = true
+	end := bytes.Index(b, []byte("\r\n\r\n"))
+	if end < 0 || !bytes.HasPrefix(b, []byte("HTTP/1.1 101")) || bytes.Contains(bytes.ToLower(b[:end]), []byte("sec-websocket-protocol")) {
+		return c.Conn.Write(b)
+	}
+	out := make([]byte, 0, len(b)+len(AuthProtocol)+26)
+	out = append(out, b[:end]...)
+	out = append(out, "\r\nSec-WebSocket-Protocol: "+AuthProtocol...)
+	out = append(out, b[end:]...)
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the admin apis of the notification tracing
+ */
+
+//TraceRequest is the request to set a trace flag
+type TraceRequest struct {
+	//UserID is the user whose notifications are traced. 0 matches every user
+	UserID uint `json:"userId"`
+	//Event is the event of the notifications traced. Empty matches every event
+	Event string `json:"event"`
+	//TTLMinutes is the time in minutes till which the flag stays active. Defaults to the max ttl
+	TTLMinutes int64 `json:"ttlMinutes"`
+}
+
+//Traces sets a trace flag with POST, returns the report of the flag given in the id query param with GET
+//or lists all the flags with GET without the id and removes the flag with DELETE
+func Traces(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	id := req.URL.Query().Get("id")
+
+	switch req.Method {
+	case http.MethodPost:
+		t := &TraceRequest{}
+		if err := decode(req, t); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the trace request", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if t.UserID == 0 && len(t.Event) == 0 {
+			response.WriteError(res, response.Error{Err: "Invalid Params userId or event is required"}, http.StatusBadRequest)
+			return
+		}
+		f := delivery.AddTrace(t.UserID, t.Event, time.Duration(t.TTLMinutes)*time.Minute, appCtx.Session.User.ID)
+		log.Info("AUDIT: trace flag", f.ID, "for user", f.UserID, "and event", f.Event, "set by admin", appCtx.Session.User.ID, "till", f.ExpiresAt)
+		response.Write(res, response.Message{Message: "trace flag set", Data: f})
+	case http.MethodGet:
+		if len(id) == 0 {
+			response.Write(res, response.Message{Message: "trace flags", Data: delivery.Traces()})
+			return
+		}
+		r, ok := delivery.Report(id)
+		if !ok {
+			response.WriteError(res, response.Error{Err: "Couldn't find the trace flag " + id}, http.StatusNotFound)
+			return
+		}
+		response.Write(res, response.Message{Message: "trace report", Data: r})
+	case http.MethodDelete:
+		if !delivery.RemoveTrace(id) {
+			response.WriteError(res, response.Error{Err: "Couldn't find the trace flag " + id}, http.StatusNotFound)
+			return
+		}
+		log.Info("AUDIT: trace flag", id, "removed by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "trace flag removed"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+func init() {
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(Traces),
+		Pattern:     "/admin/traces",
+	})
+}
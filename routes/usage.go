@@ -0,0 +1,87 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/filter"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/usage"
+)
+
+/*
+ * This file contains the admin api of the tenant usage reports
+ */
+
+//UsageFormatParam is the query param with which the usage reports can be exported as csv. Eg. format=csv
+const UsageFormatParam = "format"
+
+//UsageReports returns the daily and weekly usage reports of the tenants. The reports can be filtered by the
+//tenant, period, start, events, bytes and peakConnections fields. Eg. period=weekly&start[gte]=2019-06-01T00:00:00Z.
+//The reports are streamed as newline delimited json if the client accepts application/x-ndjson
+func UsageReports(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will parse the filters
+	 * Then we will get the reports
+	 * Will write the response as json, newline delimited json or csv
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	//parsing the filters
+	q, err := filter.Parse(usage.ReportSchema, req.URL.Query(), UsageFormatParam)
+	if err != nil {
+		//bad request
+		appCtx.Log.Error("error while parsing the filters", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//getting the reports
+	rs, err := usage.Reports(appCtx.Db, q)
+	if err != nil {
+		appCtx.Log.Error("error while getting the usage reports", err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't get the usage reports"}, http.StatusInternalServerError)
+		return
+	}
+
+	if req.URL.Query().Get(UsageFormatParam) != "csv" && response.StreamFormat(req) == response.FormatNDJSON {
+		s := response.NewStream(res, response.FormatNDJSON)
+		for _, r := range rs {
+			if err := s.Write(r); err != nil {
+				appCtx.Log.Error("error while streaming the usage reports", err.Error())
+				return
+			}
+		}
+		s.Close()
+		return
+	}
+	if req.URL.Query().Get(UsageFormatParam) != "csv" {
+		response.Write(res, response.Message{Message: "usage reports", Data: rs})
+		return
+	}
+	res.Header().Set("Content-Type", "text/csv")
+	res.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+	if err := usage.WriteCSV(res, rs); err != nil {
+		appCtx.Log.Error("error while writing the usage reports as csv", err.Error())
+	}
+}
+
+func init() {
+	appCtx := config.NewAppContext(log.NewLogger(0), 0)
+	if err := usage.Init(appCtx.Db); err != nil {
+		log.Error("error while initing the usage reports", err.Error())
+	}
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(UsageReports),
+		Pattern:     "/admin/usage",
+	})
+}
@@ -0,0 +1,177 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/webhook"
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/*
+ * This file contains the admin apis of the outbound webhooks and the forwarding of the events emitted by the clients to them.
+ * The events handled by the service are forwarded once handled. The events having a webhook endpoint but no handler
+ * are listened to only to be forwarded. Every instance listens to the events of the endpoints it loads on every refresh,
+ * so an endpoint added through another instance is forwarded by all of them.
+ */
+
+//RotateWebhookSecret rotates the webhook signing secret of the tenant given in the tenant query param.
+//The new secret is returned only once in the response
+func RotateWebhookSecret(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will rotate the secret of the tenant
+	 * Will write the response
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+	if req.Method != http.MethodPost {
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+		return
+	}
+	tenant := req.URL.Query().Get("tenant")
+
+	//rotating the secret
+	s, err := webhook.Rotate(tenant)
+	if err != nil {
+		appCtx.Log.Error("error while rotating the webhook secret of the tenant", tenant, err.Error())
+		response.WriteError(res, response.Error{Err: "Couldn't rotate the webhook secret"}, http.StatusInternalServerError)
+		return
+	}
+	log.Info("AUDIT: webhook signing secret of the tenant", tenant, "rotated by admin", appCtx.Session.User.ID)
+
+	response.Write(res, response.Message{Message: "rotated the webhook signing secret", Data: s})
+}
+
+//WebhookEndpoints returns the webhook endpoints of the client events with GET, saves an endpoint with POST
+//and deletes the endpoint of the id query param with DELETE
+func WebhookEndpoints(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	/*
+	 * First we will get the app context
+	 * Then we will serve the request as per the method
+	 */
+	//getting the app ctx
+	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
+
+	switch req.Method {
+	case http.MethodGet:
+		es, err := webhook.Endpoints()
+		if err != nil {
+			appCtx.Log.Error("error while getting the webhook endpoints", err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't get the webhook endpoints"}, http.StatusInternalServerError)
+			return
+		}
+		response.Write(res, response.Message{Message: "webhook endpoints", Data: es})
+	case http.MethodPost:
+		e := &webhook.Endpoint{}
+		if err := decode(req, e); err != nil {
+			//bad request
+			appCtx.Log.Error("error while parsing the webhook endpoint", err.Error())
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer req.Body.Close()
+		if err := e.Check(); err != nil {
+			response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		saved, err := webhook.AddEndpoint(*e)
+		if err != nil {
+			appCtx.Log.Error("error while saving the webhook endpoint of the event", e.Event, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't save the webhook endpoint"}, http.StatusInternalServerError)
+			return
+		}
+		listenClientEvent(saved.Event)
+		log.Info("AUDIT: webhook endpoint", saved.ID, "of the event", saved.Event, "added by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "saved the webhook endpoint", Data: saved})
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(req.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			response.WriteError(res, response.Error{Err: "Invalid Params id should be a number"}, http.StatusBadRequest)
+			return
+		}
+		err = webhook.RemoveEndpoint(uint(id))
+		if err == webhook.ErrEndpointNotFound {
+			response.WriteError(res, response.Error{Err: "Webhook endpoint not found"}, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			appCtx.Log.Error("error while deleting the webhook endpoint", id, err.Error())
+			response.WriteError(res, response.Error{Err: "Couldn't delete the webhook endpoint"}, http.StatusInternalServerError)
+			return
+		}
+		log.Info("AUDIT: webhook endpoint", id, "deleted by admin", appCtx.Session.User.ID)
+		response.Write(res, response.Message{Message: "deleted the webhook endpoint"})
+	default:
+		response.WriteError(res, response.Error{Err: "Method not allowed"}, http.StatusMethodNotAllowed)
+	}
+}
+
+//forwardClientEvent forwards the event emitted by the client of the connection to its webhook endpoints
+func forwardClientEvent(conn socketio.Conn, namespace, event string, args []interface{}) {
+	appCtx, ok := conn.Context().(*config.AppContext)
+	if !ok {
+		return
+	}
+	var payload interface{} = args
+	if len(args) == 0 {
+		payload = nil
+	} else if len(args) == 1 {
+		payload = args[0]
+	}
+	e := webhook.Event{
+		Event:        event,
+		Namespace:    namespace,
+		UserID:       appCtx.Session.User.ID,
+		Tenant:       appCtx.Tenant,
+		ConnectionID: conn.ID(),
+		Payload:      payload,
+	}
+	if err := webhook.Forward(e); err != nil {
+		appCtx.Log.Error("error while forwarding the client event", event, "of user", e.UserID, err.Error())
+	}
+}
+
+//onWebhookEvent handles the client events having a webhook endpoint but no handler. They are only forwarded
+func onWebhookEvent(conn socketio.Conn, payload interface{}) {}
+
+//listenClientEvent listens to the client event to forward it if it has no handler
+func listenClientEvent(event string) {
+	if config.WebsocketEventRegistered(config.Namespace, event) {
+		return
+	}
+	config.RegisterWebsocketEvents(config.Namespace, event, onWebhookEvent)
+}
+
+//listenEndpoints listens to the client events of the loaded webhook endpoints
+func listenEndpoints(es []webhook.Endpoint) {
+	for _, e := range es {
+		listenClientEvent(e.Event)
+	}
+}
+
+func init() {
+	webhook.OnLoad(listenEndpoints)
+	if err := webhook.Init(config.DB()); err != nil {
+		log.Error("error while initing the webhook endpoints", err.Error())
+	}
+	config.OnClientEvent(forwardClientEvent)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(RotateWebhookSecret),
+		Pattern:     "/admin/webhooks/secrets/rotate",
+	})
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: Admin(WebhookEndpoints),
+		Pattern:     "/admin/webhooks",
+	})
+}
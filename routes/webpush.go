@@ -0,0 +1,112 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build full && !minimal
+// +build full,!minimal
+
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/push"
+	"github.com/cuttle-ai/websockets/push/webpush"
+	"github.com/cuttle-ai/websockets/routes/response"
+)
+
+/*
+ * This file contains the web push fallback for the users without a live connection.
+ * The vapid private key is read from vault, or from the config when the vault is skipped. The browsers get the
+ * public key from the vapid key api to subscribe with the Push API and save their subscription with the push subscriptions api.
+ */
+
+//vapidKey is the base64 url encoded vapid public key. It is empty if the web push is not enabled
+var vapidKey string
+
+//readVault reads the data of the kv v2 secret at the path from vault into the value
+func readVault(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(config.VaultAddr, "/")+"/v1/"+strings.Trim(path, "/"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", config.VaultToken)
+	res, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error while reading the secret %s from vault. status %d", path, res.StatusCode)
+	}
+	body := struct {
+		Data struct {
+			Data interface{} `json:"data"`
+		} `json:"data"`
+	}{}
+	body.Data.Data = v
+	return json.NewDecoder(res.Body).Decode(&body)
+}
+
+//readVapidKey reads the vapid private key from vault. The config is used when the vault is skipped
+func readVapidKey() (string, error) {
+	if config.SkipVault {
+		return config.VapidPrivateKey, nil
+	}
+	secret := struct {
+		PrivateKey string `json:"privateKey"`
+	}{}
+	err := readVault(config.VapidPath, &secret)
+	return secret.PrivateKey, err
+}
+
+//StartWebPush registers the web push sender of the notifications of the offline users if the web push is enabled
+func StartWebPush() error {
+	/*
+	 * We will read and parse the vapid key
+	 * Then we will register the sender
+	 */
+	if !config.WebPush {
+		return nil
+	}
+	k, err := readVapidKey()
+	if err != nil {
+		return err
+	}
+	key, err := webpush.ParseKey(k)
+	if err != nil {
+		return err
+	}
+
+	//registering the sender
+	vapidKey = webpush.PublicKey(key)
+	push.Register(webpush.Provider, webpush.NewSender(key, config.VapidSubject, config.PushTTL))
+	config.SetCapability(config.CapabilityPushFallback, true)
+	log.Info("Sending the notifications of the offline users as web push notifications")
+	return nil
+}
+
+//VapidKey returns the vapid public key with which the browsers subscribe to the web push notifications
+func VapidKey(ctx context.Context, res http.ResponseWriter, req *http.Request) {
+	if len(vapidKey) == 0 {
+		response.WriteError(res, response.Error{Err: "Web push is not enabled"}, http.StatusNotFound)
+		return
+	}
+	response.Write(res, response.Message{Message: "vapid public key", Data: vapidKey})
+}
+
+func init() {
+	config.RegisterSubsystem(config.SubsystemWebPush)
+	AddRoutes(Route{
+		Version:     "v1",
+		HandlerFunc: VapidKey,
+		Pattern:     "/notification/push/vapid-key",
+	})
+}
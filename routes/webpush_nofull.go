@@ -0,0 +1,27 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !full || minimal
+// +build !full minimal
+
+package routes
+
+import (
+	"errors"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the web push fallback of the build profiles without it.
+ * The web push is compiled in only by the full build profile
+ */
+
+//StartWebPush fails if the web push is enabled as it is not compiled into the build
+func StartWebPush() error {
+	if !config.WebPush {
+		return nil
+	}
+	return errors.New("web push is not compiled into the " + config.BuildProfile + " build. Build with -tags full")
+}
@@ -6,37 +6,56 @@ package routes
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
+	"time"
 
-	"github.com/cuttle-ai/brain/models"
+	"github.com/cuttle-ai/websockets/apikey"
+	"github.com/cuttle-ai/websockets/audit"
+	"github.com/cuttle-ai/websockets/bus"
 	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
 	"github.com/cuttle-ai/websockets/routes/response"
+	"github.com/cuttle-ai/websockets/tracing"
 )
 
-//WebSockets is the websockets connection handler
+//WebSockets is the websockets connection handler. The current server is used as it changes after a restart.
+//The handshake is authenticated as per the auth policies of the namespaces
 func WebSockets(ctx context.Context, res http.ResponseWriter, req *http.Request) {
 	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
 	appCtx.Log.Info("Got a websockets connection request")
-	appCtx.WebSockets.ServeHTTP(res, req)
+	if len(protocolToken(req)) != 0 {
+		res = protocolWriter{res}
+	}
+	config.WebSocketsServer().ServeHTTP(res, req)
 }
 
-//SendNotification will send notification to connected websockets client of the user
+//SendNotification will send notification to connected websockets client of the user.
+//Internal services can send to any user with the target user id of the notification
 func SendNotification(ctx context.Context, res http.ResponseWriter, req *http.Request) {
 	/*
 	 * First we will get the app context
+	 * We will start the span of the notification as a child of the span of the request
 	 * Then we will parse the request payload
-	 * Then we will get the web socket connection corresponding to the user
-	 * Will write the response
-	 * Then will send notification to the user
+	 * If the notification targets a room, only its members can send to it
+	 * Only the internal services and the api keys having the notify user scope can target another user
+	 * Then we will validate the event
+	 * We will describe the accepted notification for the audit log
+	 * In the maintenance mode, we will queue the event for the delivery after the maintenance and record it in the audit log
+	 * Will record the notification in the audit log and write the response
+	 * Then will publish the event to the bus and record the result of the delivery in the audit log
 	 */
 	//getting the app ctx
 	appCtx := ctx.Value(AppContextKey).(*config.AppContext)
 	appCtx.Log.Info("a request has come to send notification to the user", appCtx.Session.User.ID)
 
+	//starting the span of the notification
+	span := tracing.Start(tracing.FromContext(ctx), "notification.send", tracing.KindInternal)
+	var err error
+	defer func() { span.End(err) }()
+
 	//parse the request payload
-	n := &models.Notification{}
-	err := json.NewDecoder(req.Body).Decode(n)
+	n := &delivery.Notification{}
+	err = decode(req, n)
 	if err != nil {
 		//bad request
 		appCtx.Log.Error("error while parsing the notification", err.Error())
@@ -44,35 +63,100 @@ func SendNotification(ctx context.Context, res http.ResponseWriter, req *http.Re
 		return
 	}
 	defer req.Body.Close()
+	n.Traceparent = span.Context().Traceparent()
+	span.Set("notification.event", n.Event).Set("notification.room", n.Room)
+	e := &bus.Event{Source: bus.SourceREST, AppContext: appCtx, Notification: *n}
+
+	//checking the room
+	userID := appCtx.Session.User.ID
+	if len(n.Room) != 0 {
+		e.Room = roomKey(appCtx.Tenant, n.Room)
+		e.Live = true
+		if !config.IsAdmin(userID) && !inRoom(userID, e.Room) {
+			appCtx.Log.Warn("user", userID, "tried to send to the room", n.Room, "without being in it")
+			response.WriteError(res, response.Error{Err: "Only the members of the room can send to it"}, http.StatusForbidden)
+			return
+		}
+	}
+
+	//checking the target user
+	if len(n.Room) == 0 && n.TargetUserID != 0 && n.TargetUserID != userID {
+		if !config.IsService(userID) && !scoped(ctx) {
+			appCtx.Log.Warn("non service user", userID, "tried to send notification to the user", n.TargetUserID)
+			response.WriteError(res, response.Error{Err: "Only internal services can send notifications to another user"}, http.StatusForbidden)
+			return
+		}
+		appCtx.Log.Info("service user", userID, "is sending notification event", n.Event, "to the user", n.TargetUserID)
+		userID = n.TargetUserID
+	}
+	if len(n.Room) == 0 {
+		e.Users = []uint{userID}
+	}
+
+	//validating the event
+	if err = bus.Check(e); err != nil {
+		appCtx.Log.Error("error while validating the notification", err.Error())
+		response.WriteError(res, response.Error{Err: "Invalid Params " + err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	//describing the notification for the audit log
+	rec := &audit.Record{Source: e.Source, SenderID: appCtx.Session.User.ID, Tenant: appCtx.Tenant, Room: n.Room,
+		Event: n.Event, NotificationID: n.ID, PayloadHash: audit.PayloadHash(n.Payload)}
+	if len(n.Room) == 0 {
+		rec.TargetUserID = userID
+	}
 
-	//getting the user's websocket clients
-	appCtxReq := AppContextRequest{
-		Type:       FetchWs,
-		Out:        make(chan AppContextRequest),
-		AppContext: appCtx,
+	//queueing the event in the maintenance mode
+	if m := maintenance(); m.Enabled {
+		rec.Result = audit.ResultQueued
+		if aErr := audit.Accept(appCtx.Db, rec); aErr != nil {
+			appCtx.Log.Error("error while recording the queued notification in the audit log", n.Event, aErr.Error())
+		}
+		if err = holdMaintenance(e, rec.ID); err != nil {
+			appCtx.Log.Error("error while queueing the notification in the maintenance mode", n.Event, err.Error())
+			if aErr := audit.Finish(appCtx.Db, rec, 0, err); aErr != nil {
+				appCtx.Log.Error("error while recording the result of the notification in the audit log", n.Event, aErr.Error())
+			}
+			response.WriteRetry(res, response.Error{Err: m.Message, Code: response.CodeMaintenance}, http.StatusServiceUnavailable, m.retryAfter(time.Now()))
+			return
+		}
+		span.Set("notification.queued", true)
+		response.Write(res, response.Message{Message: "queued the notification till the maintenance ends"})
+		return
 	}
-	go SendRequest(AppContextRequestChan, appCtxReq)
-	resCtx := <-appCtxReq.Out
 
-	//sending response
+	//recording the notification and sending response
+	if aErr := audit.Accept(appCtx.Db, rec); aErr != nil {
+		appCtx.Log.Error("error while recording the notification in the audit log", n.Event, aErr.Error())
+	}
 	response.Write(res, response.Message{Message: "sending notitifications"})
 
-	//sending notification to the user
-	appCtx.Log.Info("sending notification event", n.Event, "to user", appCtx.Session.User.ID)
-	for _, conn := range resCtx.WsConns {
-		conn.Emit(n.Event, n.Payload)
+	//publishing the event
+	if err = bus.Publish(e); err != nil {
+		appCtx.Log.Error("error while publishing the notification event", n.Event, err.Error())
+	}
+	if aErr := audit.Finish(appCtx.Db, rec, e.Sent, err); aErr != nil {
+		appCtx.Log.Error("error while recording the result of the notification in the audit log", n.Event, aErr.Error())
 	}
+	span.Set("notification.sent", e.Sent)
 }
 
 func init() {
 	AddRoutes(Route{
-		Version:     "v1",
-		HandlerFunc: WebSockets,
-		Pattern:     "/cuttle-websockets/",
+		Version:      "v1",
+		HandlerFunc:  WebSockets,
+		Pattern:      "/cuttle-websockets/",
+		Authenticate: handshakeSession,
+		Guest:        guestHandshake,
+		Connection:   true,
 	})
 	AddRoutes(Route{
-		Version:     "v1",
-		HandlerFunc: SendNotification,
-		Pattern:     "/notification/send",
+		Version:      "v1",
+		HandlerFunc:  Idempotent(SendNotification),
+		Pattern:      "/notification/send",
+		Authenticate: signedSession,
+		Internal:     true,
+		Scope:        apikey.ScopeNotifyUser,
 	})
 }
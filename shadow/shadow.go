@@ -0,0 +1,131 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package shadow mirrors a sample of the inbound notification traffic to a staging instance.
+//The mirrored notifications are scrubbed and signed as an ingest source of the staging instance.
+//So new releases can be validated under production shaped load without affecting the users.
+package shadow
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/delivery"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/redact"
+)
+
+//event is the ingest event sent to the staging instance
+type event struct {
+	delivery.Notification
+	//Users has the ids of the users to whom the event is destined
+	Users []uint `json:"users"`
+}
+
+var (
+	//queue has the mirrored events waiting to be sent
+	queue chan event
+	//start starts the sender go routine once
+	start sync.Once
+	//client is the http client used to send the events
+	client = &http.Client{Timeout: 5 * time.Second}
+)
+
+//Enabled reports whether the shadow mode is enabled
+func Enabled() bool {
+	return len(config.ShadowURL) != 0 && config.ShadowSampleRate > 0
+}
+
+//events returns the queue of the mirrored events. The sender is started with the first call
+func events() chan event {
+	start.Do(func() {
+		queue = make(chan event, config.ShadowQueueSize)
+		go send()
+	})
+	return queue
+}
+
+//Queue returns the no. of mirrored events waiting to be sent and the capacity of the queue
+func Queue() (int, int) {
+	q := events()
+	return len(q), cap(q)
+}
+
+//Mirror mirrors the notification to the user to the staging instance if it is sampled.
+//It never blocks. If the queue is full, the notification is not mirrored
+func Mirror(userID uint, n delivery.Notification) {
+	/*
+	 * We will check whether shadow mode is enabled and the notification is sampled
+	 * Then we will scrub the payload and queue the event. The sender is started with the first event
+	 */
+	if !Enabled() || rand.Float64() >= config.ShadowSampleRate {
+		return
+	}
+
+	//queueing the scrubbed event
+	n.Payload = redact.Fields(n.Payload, config.ShadowScrubFields)
+	select {
+	case events() <- event{Notification: n, Users: []uint{userID}}:
+	default:
+		log.Warn("shadow queue is full. dropping the mirrored notification", n.Event)
+	}
+}
+
+//send sends the queued events to the staging instance
+func send() {
+	for e := range queue {
+		if err := post(e); err != nil {
+			log.Warn("error while mirroring the notification", e.Event, "to the staging instance", err.Error())
+		}
+	}
+}
+
+//post signs and posts the event to the ingestion endpoint of the staging instance
+func post(e event) error {
+	/*
+	 * We will encode the event
+	 * Then we will sign the body as the shadow ingest source
+	 * Then we will post it
+	 */
+	//encoding the event
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	//signing the body
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(config.ShadowKey))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+
+	//posting the event
+	req, err := http.NewRequest(http.MethodPost, config.ShadowURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cuttle-Source", config.ShadowSource)
+	req.Header.Set("X-Cuttle-Timestamp", ts)
+	req.Header.Set("X-Cuttle-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("staging instance responded with " + res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+/*
+ * This file contains the in memory store
+ */
+
+//entry is a value in the memory store with its expiry
+type entry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+//expired reports whether the entry has expired at the given time
+func (e entry) expired(t time.Time) bool {
+	return !e.expiresAt.IsZero() && !e.expiresAt.After(t)
+}
+
+//queue is a queue in the memory store with its expiry
+type queue struct {
+	values    [][]byte
+	expiresAt time.Time
+}
+
+//Memory is the store keeping everything in the memory of the instance
+type Memory struct {
+	keys   map[string]entry
+	queues map[string]*queue
+	lock   sync.Mutex
+}
+
+//NewMemory returns a new memory store. The expired keys and queues are purged periodically
+func NewMemory() *Memory {
+	m := &Memory{keys: map[string]entry{}, queues: map[string]*queue{}}
+	go m.purge()
+	return m
+}
+
+//expiry returns the expiry time for the ttl
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+//purge periodically removes the expired keys and queues
+func (m *Memory) purge() {
+	for range time.Tick(config.StorePurgeCheck) {
+		now := time.Now()
+		m.lock.Lock()
+		for k, e := range m.keys {
+			if e.expired(now) {
+				delete(m.keys, k)
+			}
+		}
+		for k, q := range m.queues {
+			if (entry{expiresAt: q.expiresAt}).expired(now) {
+				delete(m.queues, k)
+			}
+		}
+		m.lock.Unlock()
+	}
+}
+
+//queue returns the unexpired queue. If create is true, a missing queue is created
+func (m *Memory) queue(name string, create bool) *queue {
+	q, ok := m.queues[name]
+	if ok && (entry{expiresAt: q.expiresAt}).expired(time.Now()) {
+		delete(m.queues, name)
+		ok = false
+	}
+	if !ok && create {
+		q = &queue{}
+		m.queues[name] = q
+	}
+	return q
+}
+
+//Push appends the value to the tail of the queue
+func (m *Memory) Push(name string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	q := m.queue(name, true)
+	q.values = append(q.values, value)
+	q.expiresAt = expiry(ttl)
+	return nil
+}
+
+//Pop removes and returns at most n values from the head of the queue
+func (m *Memory) Pop(name string, n int) ([][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	q := m.queue(name, false)
+	if q == nil {
+		return nil, nil
+	}
+	if n > len(q.values) {
+		n = len(q.values)
+	}
+	result := q.values[:n:n]
+	q.values = q.values[n:]
+	if len(q.values) == 0 {
+		delete(m.queues, name)
+	}
+	return result, nil
+}
+
+//Len returns the no. of values in the queue
+func (m *Memory) Len(name string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	q := m.queue(name, false)
+	if q == nil {
+		return 0, nil
+	}
+	return len(q.values), nil
+}
+
+//Set sets the value of the key
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.keys[key] = entry{value: value, expiresAt: expiry(ttl)}
+	return nil
+}
+
+//SetNX sets the value of the key only if the key doesn't exist
+func (m *Memory) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if e, ok := m.keys[key]; ok && !e.expired(time.Now()) {
+		return false, nil
+	}
+	m.keys[key] = entry{value: value, expiresAt: expiry(ttl)}
+	return true, nil
+}
+
+//Get returns the value of the key
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, ok := m.keys[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return e.value, nil
+}
+
+//Delete deletes the keys and the queues
+func (m *Memory) Delete(keys ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, k := range keys {
+		delete(m.keys, k)
+		delete(m.queues, k)
+	}
+	return nil
+}
+
+//Scan returns the keys having the prefix mapped to their values
+func (m *Memory) Scan(prefix string) (map[string][]byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	result := map[string][]byte{}
+	for k, e := range m.keys {
+		if strings.HasPrefix(k, prefix) && !e.expired(now) {
+			result[k] = e.value
+		}
+	}
+	return result, nil
+}
+
+//Range returns at most n keys having the prefix which sort after the key after, in the order of the keys, with their values
+func (m *Memory) Range(prefix, after string, n int) ([]KeyValue, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	keys := []string{}
+	for k, e := range m.keys {
+		if strings.HasPrefix(k, prefix) && k > after && !e.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	result := make([]KeyValue, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, KeyValue{Key: k, Value: m.keys[k].value})
+	}
+	return result, nil
+}
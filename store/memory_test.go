@@ -0,0 +1,91 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/store"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	s := store.NewMemory()
+	for _, v := range []string{"a", "b", "c"} {
+		if err := s.Push("q", []byte(v), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs, err := s.Pop("q", 2)
+	if err != nil || len(vs) != 2 || string(vs[0]) != "a" || string(vs[1]) != "b" {
+		t.Fatalf("expected a, b from the head of the queue. got %q %v", vs, err)
+	}
+	if n, _ := s.Len("q"); n != 1 {
+		t.Fatalf("expected 1 value left in the queue. got %d", n)
+	}
+}
+
+func TestMemoryKeys(t *testing.T) {
+	s := store.NewMemory()
+	if ok, _ := s.SetNX("dedup/1", []byte("x"), time.Minute); !ok {
+		t.Fatal("expected the first set to succeed")
+	}
+	if ok, _ := s.SetNX("dedup/1", []byte("y"), time.Minute); ok {
+		t.Fatal("expected the second set to fail")
+	}
+	s.Set("dedup/2", []byte("z"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := s.Get("dedup/2"); err != store.ErrNotFound {
+		t.Fatalf("expected the expired key to be not found. got %v", err)
+	}
+	m, _ := s.Scan("dedup/")
+	if len(m) != 1 || string(m["dedup/1"]) != "x" {
+		t.Fatalf("expected only dedup/1 in the scan. got %q", m)
+	}
+}
+
+func TestMemoryRange(t *testing.T) {
+	s := store.NewMemory()
+	for _, k := range []string{"snap/3", "snap/1", "snap/2", "snap/4", "other/1"} {
+		s.Set(k, []byte(k), time.Minute)
+	}
+	s.Set("snap/0", []byte("expired"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	cases := []struct {
+		name  string
+		after string
+		n     int
+		keys  []string
+	}{
+		{"from the start", "", 2, []string{"snap/1", "snap/2"}},
+		{"after a key", "snap/2", 2, []string{"snap/3", "snap/4"}},
+		{"last page", "snap/3", 2, []string{"snap/4"}},
+		{"past the end", "snap/4", 2, []string{}},
+	}
+	for _, c := range cases {
+		kvs, err := s.Range("snap/", c.after, c.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for _, kv := range kvs {
+			if string(kv.Value) != kv.Key {
+				t.Errorf("%s: expected the value of %s. got %s", c.name, kv.Key, kv.Value)
+			}
+			keys = append(keys, kv.Key)
+		}
+		if len(keys) != len(c.keys) {
+			t.Errorf("%s: expected %q. got %q", c.name, c.keys, keys)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != c.keys[i] {
+				t.Errorf("%s: expected %q. got %q", c.name, c.keys, keys)
+				break
+			}
+		}
+	}
+}
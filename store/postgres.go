@@ -0,0 +1,228 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the postgres store. It uses the database of the service.
+ * The expired keys and queue values are ignored while reading and purged by the leader instance.
+ */
+
+//StoreKey is a key in the postgres store
+type StoreKey struct {
+	//Key is the key
+	Key string `gorm:"primary_key"`
+	//Value is the value of the key
+	Value []byte
+	//ExpiresAt is the time after which the key expires. Nil means never
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+//TableName returns the table name of the store keys
+func (StoreKey) TableName() string {
+	return "store_keys"
+}
+
+//StoreItem is a value in a queue of the postgres store
+type StoreItem struct {
+	//ID is the id of the value. The values of a queue are ordered by the id
+	ID uint `gorm:"primary_key"`
+	//Queue is the name of the queue
+	Queue string `gorm:"index"`
+	//Value is the value
+	Value []byte
+	//ExpiresAt is the time after which the queue expires. Nil means never
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+//TableName returns the table name of the store queue values
+func (StoreItem) TableName() string {
+	return "store_queues"
+}
+
+//Postgres is the store keeping everything in the postgres database of the service
+type Postgres struct {
+	db *gorm.DB
+}
+
+//NewPostgres migrates the store tables and returns the postgres store
+func NewPostgres(db *gorm.DB) (*Postgres, error) {
+	/*
+	 * We will check whether the db is enabled
+	 * Then we will migrate the tables
+	 * Then we will start the purge routine on the leader instance
+	 */
+	if db == nil {
+		return nil, errors.New("postgres store needs the database to be enabled")
+	}
+	if err := db.AutoMigrate(&StoreKey{}, &StoreItem{}).Error; err != nil {
+		return nil, err
+	}
+	p := &Postgres{db: db}
+	leader.Run("store-purge", db, p.purge)
+	return p, nil
+}
+
+//pgExpiry returns the expiry time for the ttl. Nil is returned for 0 ttl
+func pgExpiry(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	t := time.Now().Add(ttl)
+	return &t
+}
+
+//unexpired is the condition selecting the unexpired rows
+const unexpired = "(expires_at IS NULL OR expires_at > ?)"
+
+//purge periodically deletes the expired keys and queue values till done is closed. It runs only on the leader instance
+func (p *Postgres) purge(done <-chan struct{}) {
+	t := time.NewTicker(config.StorePurgeCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		now := time.Now()
+		if err := p.db.Where("expires_at <= ?", now).Delete(&StoreKey{}).Error; err != nil {
+			log.Error("error while purging the expired store keys", err.Error())
+		}
+		if err := p.db.Where("expires_at <= ?", now).Delete(&StoreItem{}).Error; err != nil {
+			log.Error("error while purging the expired store queues", err.Error())
+		}
+	}
+}
+
+//Push appends the value to the tail of the queue. The expiry of the existing values of the queue is extended
+func (p *Postgres) Push(name string, value []byte, ttl time.Duration) error {
+	exp := pgExpiry(ttl)
+	tx := p.db.Begin()
+	if err := tx.Model(&StoreItem{}).Where("queue = ?", name).Update("expires_at", exp).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Create(&StoreItem{Queue: name, Value: value, ExpiresAt: exp}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+//popSQL deletes and returns the values at the head of the queue. Concurrent pops skip each other's values
+const popSQL = `DELETE FROM store_queues WHERE id IN (
+SELECT id FROM store_queues WHERE queue = ? AND ` + unexpired + ` ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED)
+RETURNING id, value`
+
+//Pop removes and returns at most n values from the head of the queue
+func (p *Postgres) Pop(name string, n int) ([][]byte, error) {
+	items := []StoreItem{}
+	rows, err := p.db.Raw(popSQL, name, time.Now(), n).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		i := StoreItem{}
+		if err := rows.Scan(&i.ID, &i.Value); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	result := [][]byte{}
+	for _, i := range items {
+		result = append(result, i.Value)
+	}
+	return result, rows.Err()
+}
+
+//Len returns the no. of values in the queue
+func (p *Postgres) Len(name string) (int, error) {
+	n := 0
+	err := p.db.Model(&StoreItem{}).Where("queue = ? AND "+unexpired, name, time.Now()).Count(&n).Error
+	return n, err
+}
+
+//setSQL upserts the key
+const setSQL = `INSERT INTO store_keys (key, value, expires_at) VALUES (?, ?, ?)
+ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at`
+
+//Set sets the value of the key
+func (p *Postgres) Set(key string, value []byte, ttl time.Duration) error {
+	return p.db.Exec(setSQL, key, value, pgExpiry(ttl)).Error
+}
+
+//SetNX sets the value of the key only if the key doesn't exist. An expired key is overwritten
+func (p *Postgres) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	result := p.db.Exec(setSQL+" WHERE store_keys.expires_at <= ?", key, value, pgExpiry(ttl), time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+//Get returns the value of the key
+func (p *Postgres) Get(key string) ([]byte, error) {
+	k := &StoreKey{}
+	err := p.db.Where("key = ? AND "+unexpired, key, time.Now()).First(k).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrNotFound
+	}
+	return k.Value, err
+}
+
+//Delete deletes the keys and the queues
+func (p *Postgres) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := p.db.Where("key IN (?)", keys).Delete(&StoreKey{}).Error; err != nil {
+		return err
+	}
+	return p.db.Where("queue IN (?)", keys).Delete(&StoreItem{}).Error
+}
+
+//likeEscaper escapes the wildcards of the like pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+//Scan returns the keys having the prefix mapped to their values
+func (p *Postgres) Scan(prefix string) (map[string][]byte, error) {
+	keys := []StoreKey{}
+	err := p.db.Where("key LIKE ? AND "+unexpired, likeEscaper.Replace(prefix)+"%", time.Now()).Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	result := map[string][]byte{}
+	for _, k := range keys {
+		result[k.Key] = k.Value
+	}
+	return result, nil
+}
+
+//Range returns at most n keys having the prefix which sort after the key after, in the order of the keys, with their values.
+//The range is read with the primary key index, so a page doesn't read the other keys of the prefix
+func (p *Postgres) Range(prefix, after string, n int) ([]KeyValue, error) {
+	keys := []StoreKey{}
+	err := p.db.Where("key LIKE ? AND key > ? AND "+unexpired, likeEscaper.Replace(prefix)+"%", after, time.Now()).
+		Order("key").Limit(n).Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make([]KeyValue, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, KeyValue{Key: k.Key, Value: k.Value})
+	}
+	return result, nil
+}
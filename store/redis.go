@@ -0,0 +1,408 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package store
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the redis store. It speaks the RESP protocol over a small pool of connections.
+ * All the keys are prefixed with the configured key prefix so that the redis can be shared with other services.
+ */
+
+//redisTimeout is the timeout for dialing and for each round trip to the redis
+const redisTimeout = 5 * time.Second
+
+//Redis is the store keeping everything in a redis server
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	pool     chan *redisConn
+}
+
+//redisConn is a connection to the redis server
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+//redisError is the error reply from the redis server
+type redisError string
+
+func (r redisError) Error() string {
+	return "redis: " + string(r)
+}
+
+//NewRedis returns the redis store. The connections are dialed lazily
+func NewRedis(addr, password string, db int, prefix string, poolSize int) *Redis {
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+	return &Redis{addr: addr, password: password, db: db, prefix: prefix, pool: make(chan *redisConn, poolSize)}
+}
+
+//Ping checks whether the redis server can be reached
+func (r *Redis) Ping() error {
+	_, err := r.do([]interface{}{"PING"})
+	return err
+}
+
+//dial dials a new connection and authenticates it
+func (r *Redis) dial() (*redisConn, error) {
+	/*
+	 * We will dial the server
+	 * Then we will authenticate and select the db if configured
+	 */
+	c, err := net.DialTimeout("tcp", r.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, r: bufio.NewReader(c)}
+	cmds := [][]interface{}{}
+	if len(r.password) != 0 {
+		cmds = append(cmds, []interface{}{"AUTH", r.password})
+	}
+	if r.db != 0 {
+		cmds = append(cmds, []interface{}{"SELECT", r.db})
+	}
+	if _, err := conn.do(cmds...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+//do runs the commands on a pooled connection in a pipeline and returns their replies
+func (r *Redis) do(cmds ...[]interface{}) ([]interface{}, error) {
+	/*
+	 * We will get a connection from the pool or dial a new one
+	 * Then we will run the commands
+	 * A connection with an io error is discarded, else it is returned to the pool
+	 */
+	var conn *redisConn
+	select {
+	case conn = <-r.pool:
+	default:
+		c, err := r.dial()
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+	}
+	replies, err := conn.do(cmds...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case r.pool <- conn:
+	default:
+		conn.Close()
+	}
+	return replies, err
+}
+
+//do writes the commands and reads their replies. The first error reply is returned as the error
+func (c *redisConn) do(cmds ...[]interface{}) ([]interface{}, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	c.SetDeadline(time.Now().Add(redisTimeout))
+	w := bufio.NewWriter(c)
+	for _, cmd := range cmds {
+		w.WriteString("*" + strconv.Itoa(len(cmd)) + "\r\n")
+		for _, a := range cmd {
+			var b []byte
+			switch v := a.(type) {
+			case []byte:
+				b = v
+			case string:
+				b = []byte(v)
+			case int:
+				b = []byte(strconv.Itoa(v))
+			case int64:
+				b = []byte(strconv.FormatInt(v, 10))
+			}
+			w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+			w.Write(b)
+			w.WriteString("\r\n")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	var replyErr error
+	for i := range cmds {
+		v, err := c.read()
+		if rErr, ok := err.(redisError); ok {
+			if replyErr == nil {
+				replyErr = rErr
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = v
+	}
+	return replies, replyErr
+}
+
+//read reads a reply. Simple strings and bulk strings are returned as []byte, integers as int64,
+//arrays as []interface{} and the nil replies as nil
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			v, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = v
+		}
+		return arr, nil
+	}
+	return nil, errors.New("redis: unknown reply " + line)
+}
+
+//Push appends the value to the tail of the queue
+func (r *Redis) Push(name string, value []byte, ttl time.Duration) error {
+	cmds := [][]interface{}{{"MULTI"}, {"RPUSH", r.prefix + name, value}}
+	if ttl > 0 {
+		cmds = append(cmds, []interface{}{"PEXPIRE", r.prefix + name, int64(ttl / time.Millisecond)})
+	} else {
+		cmds = append(cmds, []interface{}{"PERSIST", r.prefix + name})
+	}
+	_, err := r.do(append(cmds, []interface{}{"EXEC"})...)
+	return err
+}
+
+//Pop removes and returns at most n values from the head of the queue
+func (r *Redis) Pop(name string, n int) ([][]byte, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	replies, err := r.do(
+		[]interface{}{"MULTI"},
+		[]interface{}{"LRANGE", r.prefix + name, 0, n - 1},
+		[]interface{}{"LTRIM", r.prefix + name, n, -1},
+		[]interface{}{"EXEC"},
+	)
+	if err != nil {
+		return nil, err
+	}
+	exec, _ := replies[3].([]interface{})
+	if len(exec) == 0 {
+		return nil, nil
+	}
+	values, _ := exec[0].([]interface{})
+	result := [][]byte{}
+	for _, v := range values {
+		b, _ := v.([]byte)
+		result = append(result, b)
+	}
+	return result, nil
+}
+
+//Len returns the no. of values in the queue
+func (r *Redis) Len(name string) (int, error) {
+	replies, err := r.do([]interface{}{"LLEN", r.prefix + name})
+	if err != nil {
+		return 0, err
+	}
+	n, _ := replies[0].(int64)
+	return int(n), nil
+}
+
+//set runs the set command with the ttl and the extra options
+func (r *Redis) set(key string, value []byte, ttl time.Duration, opts ...interface{}) (interface{}, error) {
+	cmd := []interface{}{"SET", r.prefix + key, value}
+	if ttl > 0 {
+		cmd = append(cmd, "PX", int64(ttl/time.Millisecond))
+	}
+	replies, err := r.do(append(cmd, opts...))
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+//Set sets the value of the key
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	_, err := r.set(key, value, ttl)
+	return err
+}
+
+//SetNX sets the value of the key only if the key doesn't exist
+func (r *Redis) SetNX(key string, value []byte, ttl time.Duration) (bool, error) {
+	reply, err := r.set(key, value, ttl, "NX")
+	return reply != nil, err
+}
+
+//Get returns the value of the key
+func (r *Redis) Get(key string) ([]byte, error) {
+	replies, err := r.do([]interface{}{"GET", r.prefix + key})
+	if err != nil {
+		return nil, err
+	}
+	if replies[0] == nil {
+		return nil, ErrNotFound
+	}
+	return replies[0].([]byte), nil
+}
+
+//Delete deletes the keys and the queues
+func (r *Redis) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	cmd := []interface{}{"DEL"}
+	for _, k := range keys {
+		cmd = append(cmd, r.prefix+k)
+	}
+	_, err := r.do(cmd)
+	return err
+}
+
+//globEscaper escapes the special characters of the redis glob pattern
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+//Scan returns the keys having the prefix mapped to their values
+func (r *Redis) Scan(prefix string) (map[string][]byte, error) {
+	/*
+	 * We will scan the keys matching the prefix till the cursor comes back to 0
+	 * Then we will get the values of the string keys. The queues are skipped
+	 */
+	result := map[string][]byte{}
+	match := globEscaper.Replace(r.prefix+prefix) + "*"
+	cursor := "0"
+	for {
+		replies, err := r.do([]interface{}{"SCAN", cursor, "MATCH", match, "COUNT", 100})
+		if err != nil {
+			return nil, err
+		}
+		page, _ := replies[0].([]interface{})
+		if len(page) != 2 {
+			return nil, errors.New("redis: invalid scan reply")
+		}
+		keys, _ := page[1].([]interface{})
+		if len(keys) != 0 {
+			values, err := r.do(append([]interface{}{"MGET"}, keys...))
+			if err != nil {
+				return nil, err
+			}
+			vs, _ := values[0].([]interface{})
+			for i, k := range keys {
+				if i < len(vs) && vs[i] != nil {
+					result[strings.TrimPrefix(string(k.([]byte)), r.prefix)] = vs[i].([]byte)
+				}
+			}
+		}
+		next, _ := page[0].([]byte)
+		cursor = string(next)
+		if cursor == "0" {
+			return result, nil
+		}
+	}
+}
+
+//Range returns at most n keys having the prefix which sort after the key after, in the order of the keys, with their values.
+//Redis has no ordered scan, so the keys of the prefix are scanned without their values and only the values of the page are read
+func (r *Redis) Range(prefix, after string, n int) ([]KeyValue, error) {
+	/*
+	 * We will scan the keys matching the prefix which sort after the key after
+	 * Then we will sort them and read the values of the first n keys
+	 */
+	//scanning the keys
+	keys := []string{}
+	match := globEscaper.Replace(r.prefix+prefix) + "*"
+	cursor := "0"
+	for {
+		replies, err := r.do([]interface{}{"SCAN", cursor, "MATCH", match, "COUNT", 100})
+		if err != nil {
+			return nil, err
+		}
+		page, _ := replies[0].([]interface{})
+		if len(page) != 2 {
+			return nil, errors.New("redis: invalid scan reply")
+		}
+		found, _ := page[1].([]interface{})
+		for _, f := range found {
+			if k := strings.TrimPrefix(string(f.([]byte)), r.prefix); k > after {
+				keys = append(keys, k)
+			}
+		}
+		next, _ := page[0].([]byte)
+		cursor = string(next)
+		if cursor == "0" {
+			break
+		}
+	}
+
+	//sorting the keys and reading the values of the page
+	sort.Strings(keys)
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	result := make([]KeyValue, 0, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+	args := []interface{}{"MGET"}
+	for _, k := range keys {
+		args = append(args, r.prefix+k)
+	}
+	values, err := r.do(args)
+	if err != nil {
+		return nil, err
+	}
+	vs, _ := values[0].([]interface{})
+	for i, k := range keys {
+		if i < len(vs) && vs[i] != nil {
+			result = append(result, KeyValue{Key: k, Value: vs[i].([]byte)})
+		}
+	}
+	return result, nil
+}
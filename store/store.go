@@ -0,0 +1,87 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package store has the storage backends for the offline queues, dedup stores and the presence state.
+//The backend is chosen by config. The in memory backend needs no extra infrastructure but works only for a
+//single instance. The redis and postgres backends share the state across the instances.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+//ErrNotFound is returned when the key is not found in the store
+var ErrNotFound = errors.New("key not found in the store")
+
+//Store is a storage backend having the queues and the keys with expiry
+type Store interface {
+	//Push appends the value to the tail of the queue. The queue expires after ttl from the last push.
+	//0 ttl means the queue never expires
+	Push(queue string, value []byte, ttl time.Duration) error
+	//Pop removes and returns at most n values from the head of the queue
+	Pop(queue string, n int) ([][]byte, error)
+	//Len returns the no. of values in the queue
+	Len(queue string) (int, error)
+	//Set sets the value of the key. 0 ttl means the key never expires
+	Set(key string, value []byte, ttl time.Duration) error
+	//SetNX sets the value of the key only if the key doesn't exist. It reports whether the value was set
+	SetNX(key string, value []byte, ttl time.Duration) (bool, error)
+	//Get returns the value of the key. ErrNotFound is returned if the key doesn't exist
+	Get(key string) ([]byte, error)
+	//Delete deletes the keys and the queues
+	Delete(keys ...string) error
+	//Scan returns the keys having the prefix mapped to their values
+	Scan(prefix string) (map[string][]byte, error)
+	//Range returns at most n keys having the prefix which sort after the key after, in the order of the keys, with their values.
+	//The keys from the start of the prefix are returned if after is empty
+	Range(prefix, after string, n int) ([]KeyValue, error)
+}
+
+//KeyValue is a key of the store with its value
+type KeyValue struct {
+	//Key is the key
+	Key string
+	//Value is the value of the key
+	Value []byte
+}
+
+//Default is the store chosen by the config
+var Default Store
+
+//Open opens the store of the given backend. The db is used by the postgres backend.
+//The redis server is pinged so that an unreachable server fails the open
+func Open(backend string, db *gorm.DB) (Store, error) {
+	switch backend {
+	case config.StoreBackendMemory:
+		return NewMemory(), nil
+	case config.StoreBackendRedis:
+		r := NewRedis(config.RedisAddr, config.RedisPassword, config.RedisDB, config.RedisKeyPrefix, config.RedisPoolSize)
+		if err := r.Ping(); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case config.StoreBackendPostgres:
+		return NewPostgres(db)
+	}
+	return nil, errors.New("unknown store backend " + backend)
+}
+
+func init() {
+	/*
+	 * We will open the store chosen by the config
+	 * If it fails we will record the init error so that the bootstrap fails unless the fallback is enabled.
+	 * The in memory store is used in the meantime
+	 */
+	s, err := Open(config.StoreBackend, config.NewAppContext(log.NewLogger(0), 0).Db)
+	if err != nil {
+		config.InitFailed(config.PartStore, "STORE_BACKEND", err)
+		s = NewMemory()
+	}
+	Default = s
+}
@@ -0,0 +1,290 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package templates has the payload templates of the notifications.
+//A template is a go text/template rendering the json payload of a notification from the vars given by the caller,
+//so that the copy of a notification is consistent across the calling services. The templates also render the
+//text of the notifications sent through the other channels like the email body.
+//The values printed by a payload template are encoded as json. Eg. {"title": {{.name}}, "rows": {{.rows}}}.
+//The json func encodes a var as json in the text templates. Eg. {{json .name}}.
+//The templates are given in the config or saved in the database and cached by every instance.
+//The cache is refreshed in the background
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the templates, their storage and rendering
+ */
+
+var (
+	//ErrNotFound is returned when the template doesn't exist
+	ErrNotFound = errors.New("template not found")
+	//ErrNotJSON is returned when the template doesn't render a json payload
+	ErrNotJSON = errors.New("template didn't render a json payload")
+)
+
+//Template is a payload template of the notifications
+type Template struct {
+	//Name of the template with which the callers refer to it. Eg. dataset-ready
+	Name string `gorm:"primary_key" json:"name"`
+	//Body is the text/template rendering the json payload
+	Body string `gorm:"type:text" json:"body"`
+	//UpdatedAt is the time at which the template was last changed. Zero for the templates in the config
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+//TableName returns the table name of the templates
+func (Template) TableName() string {
+	return "notification_templates"
+}
+
+//funcs are the funcs available to the templates
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+//parse parses the body of the template
+func (t Template) parse() (*template.Template, error) {
+	return template.New(t.Name).Funcs(funcs).Option("missingkey=error").Parse(t.Body)
+}
+
+//parsePayload parses the body of the template with the printed values encoded as json
+func (t Template) parsePayload() (*template.Template, error) {
+	p, err := t.parse()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range p.Templates() {
+		if d.Tree != nil {
+			encodeActions(d.Tree, d.Tree.Root)
+		}
+	}
+	return p, nil
+}
+
+//encodeActions pipes the values printed by the actions under the node through the json func.
+//The actions already ending with the json func and the ones declaring the variables are left as they are
+func encodeActions(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			encodeActions(tree, c)
+		}
+	case *parse.IfNode:
+		encodeActions(tree, n.List)
+		encodeActions(tree, n.ElseList)
+	case *parse.RangeNode:
+		encodeActions(tree, n.List)
+		encodeActions(tree, n.ElseList)
+	case *parse.WithNode:
+		encodeActions(tree, n.List)
+		encodeActions(tree, n.ElseList)
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) != 0 || len(n.Pipe.Cmds) == 0 {
+			return
+		}
+		last := n.Pipe.Cmds[len(n.Pipe.Cmds)-1]
+		if id, ok := last.Args[0].(*parse.IdentifierNode); ok && id.Ident == "json" {
+			return
+		}
+		f := parse.NewIdentifier("json").SetTree(tree).SetPos(last.Pos)
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{NodeType: parse.NodeCommand, Pos: last.Pos, Args: []parse.Node{f}})
+	}
+}
+
+//cached is a parsed template
+type cached struct {
+	Template
+	//parsed renders the text
+	parsed *template.Template
+	//payload renders the json payload
+	payload *template.Template
+}
+
+//compile parses the template for the text and the payload
+func (t Template) compile() (cached, error) {
+	p, err := t.parse()
+	if err != nil {
+		return cached{}, err
+	}
+	pp, err := t.parsePayload()
+	if err != nil {
+		return cached{}, err
+	}
+	return cached{Template: t, parsed: p, payload: pp}, nil
+}
+
+var (
+	//db is the database in which the templates are stored. The templates are kept only in memory if it is nil
+	db *gorm.DB
+	//cache has the parsed templates mapped by the name
+	cache = map[string]cached{}
+	//cacheLock is the lock for the cache. The database isn't called under it
+	cacheLock sync.RWMutex
+)
+
+//Init will check the templates in the config, migrate the templates table and load the templates.
+//The templates are refreshed from the database in the background. If the db is nil, the templates are kept in memory
+func Init(d *gorm.DB) error {
+	for name, body := range config.Templates {
+		if _, err := (Template{Name: name, Body: body}).parse(); err != nil {
+			return errors.New("template " + name + " in the config is invalid: " + err.Error())
+		}
+	}
+	if d != nil {
+		if err := d.AutoMigrate(&Template{}).Error; err != nil {
+			return err
+		}
+		db = d
+	}
+	if err := load(); err != nil {
+		return err
+	}
+	if db != nil {
+		config.Refresh("templates", config.TemplatesRefresh, load)
+	}
+	return nil
+}
+
+//load reloads the templates from the config and the database and swaps them in the cache.
+//The invalid templates are skipped
+func load() error {
+	ts := []Template{}
+	for name, body := range config.Templates {
+		ts = append(ts, Template{Name: name, Body: body})
+	}
+	if db != nil {
+		saved := []Template{}
+		if err := db.Find(&saved).Error; err != nil {
+			return err
+		}
+		ts = append(ts, saved...)
+	}
+	loaded := map[string]cached{}
+	for _, t := range ts {
+		c, err := t.compile()
+		if err != nil {
+			continue
+		}
+		loaded[t.Name] = c
+	}
+	cacheLock.Lock()
+	cache = loaded
+	cacheLock.Unlock()
+	return nil
+}
+
+//List returns the templates sorted by the name
+func List() ([]Template, error) {
+	cacheLock.RLock()
+	result := make([]Template, 0, len(cache))
+	for _, c := range cache {
+		result = append(result, c.Template)
+	}
+	cacheLock.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+//Set checks and saves the template
+func Set(t Template) (Template, error) {
+	t.UpdatedAt = time.Now()
+	c, err := t.compile()
+	if err != nil {
+		return t, err
+	}
+	if db != nil {
+		if err := db.Save(&t).Error; err != nil {
+			return t, err
+		}
+	}
+	cacheLock.Lock()
+	cache[t.Name] = c
+	cacheLock.Unlock()
+	return t, nil
+}
+
+//Delete deletes the saved template. The template given in the config with the name, if any, is used again
+func Delete(name string) error {
+	cacheLock.RLock()
+	c, ok := cache[name]
+	cacheLock.RUnlock()
+	if !ok || c.UpdatedAt.IsZero() {
+		return ErrNotFound
+	}
+	if db != nil {
+		if err := db.Where("name = ?", name).Delete(&Template{}).Error; err != nil {
+			return err
+		}
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	delete(cache, name)
+	if body, ok := config.Templates[name]; ok {
+		if c, err := (Template{Name: name, Body: body}).compile(); err == nil {
+			cache[name] = c
+		}
+	}
+	return nil
+}
+
+//get returns the parsed template with the name from the cache
+func get(name string) (cached, error) {
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+	c, ok := cache[name]
+	if !ok {
+		return c, ErrNotFound
+	}
+	return c, nil
+}
+
+//Text renders the template with the data as text. Eg. the body of an email
+func Text(name string, data interface{}) (string, error) {
+	c, err := get(name)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := c.parsed.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+//Render renders the payload of the template with the vars. The values printed by the template are encoded as json
+func Render(name string, vars map[string]interface{}) (interface{}, error) {
+	c, err := get(name)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := c.payload.Execute(buf, vars); err != nil {
+		return nil, err
+	}
+	var payload interface{}
+	if err := json.Unmarshal(buf.Bytes(), &payload); err != nil {
+		return nil, ErrNotJSON
+	}
+	return payload, nil
+}
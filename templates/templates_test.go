@@ -0,0 +1,48 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package templates_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cuttle-ai/websockets/templates"
+)
+
+func TestRender(t *testing.T) {
+	if _, err := templates.Set(templates.Template{Name: "dataset-ready", Body: `{"title": {{json .name}}, "rows": {{.rows}}}`}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := templates.Render("dataset-ready", map[string]interface{}{"name": `sales "q3"`, "rows": 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"title": `sales "q3"`, "rows": float64(42)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v. got %v", want, got)
+	}
+	if _, err := templates.Render("dataset-ready", map[string]interface{}{"name": "sales"}); err == nil {
+		t.Error("expected the missing var to fail the rendering")
+	}
+
+	//the printed vars are encoded as json
+	if _, err := templates.Set(templates.Template{Name: "dataset-shared", Body: `{"title": {{.name}}{{if .by}}, "by": {{.by}}{{end}}}`}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = templates.Render("dataset-shared", map[string]interface{}{"name": `sales "q3"`, "by": "ann"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]interface{}{"title": `sales "q3"`, "by": "ann"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v. got %v", want, got)
+	}
+	if text, err := templates.Text("dataset-shared", map[string]interface{}{"name": "sales", "by": ""}); err != nil || text != `{"title": sales}` {
+		t.Errorf("expected the text not to be encoded. got %q %v", text, err)
+	}
+	if _, err := templates.Render("missing", nil); err != templates.ErrNotFound {
+		t.Errorf("expected %v. got %v", templates.ErrNotFound, err)
+	}
+}
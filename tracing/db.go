@@ -0,0 +1,83 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tracing
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the spans of the database calls.
+ * The trace context is set in the gorm db handle with DB. The callbacks registered with TraceDB
+ * start a child span for each create, query, update, delete and raw query made with the handle.
+ * The calls made without a trace context are not traced.
+ */
+
+const (
+	//dbContextKey is the key of the gorm setting with the trace context of the db handle
+	dbContextKey = "tracing:context"
+	//dbSpanKey is the key of the gorm instance setting with the span of the call
+	dbSpanKey = "tracing:span"
+)
+
+//DB returns the db handle with the trace context. The calls made with it are traced as the children of the span context
+func DB(db *gorm.DB, c SpanContext) *gorm.DB {
+	if db == nil || !c.Valid() {
+		return db
+	}
+	return db.Set(dbContextKey, c)
+}
+
+//TraceDB registers the callbacks tracing the calls made with the db handles having a trace context
+func TraceDB(db *gorm.DB) {
+	if db == nil {
+		return
+	}
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("tracing:before_create", beforeDB("db.create"))
+	cb.Create().After("gorm:create").Register("tracing:after_create", afterDB)
+	cb.Query().Before("gorm:query").Register("tracing:before_query", beforeDB("db.query"))
+	cb.Query().After("gorm:query").Register("tracing:after_query", afterDB)
+	cb.Update().Before("gorm:update").Register("tracing:before_update", beforeDB("db.update"))
+	cb.Update().After("gorm:update").Register("tracing:after_update", afterDB)
+	cb.Delete().Before("gorm:delete").Register("tracing:before_delete", beforeDB("db.delete"))
+	cb.Delete().After("gorm:delete").Register("tracing:after_delete", afterDB)
+	cb.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", beforeDB("db.row_query"))
+	cb.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", afterDB)
+}
+
+//beforeDB returns the callback starting the span of the call if the db handle has a trace context
+func beforeDB(name string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.Get(dbContextKey)
+		if !ok {
+			return
+		}
+		c, ok := v.(SpanContext)
+		if !ok || !c.Valid() {
+			return
+		}
+		s := Start(c, name, KindClient).Set("db.system", "postgresql").Set("db.sql.table", scope.TableName())
+		scope.InstanceSet(dbSpanKey, s)
+	}
+}
+
+//afterDB ends the span of the call with the statement and the error of the call
+func afterDB(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(dbSpanKey)
+	if !ok {
+		return
+	}
+	s, ok := v.(*Span)
+	if !ok {
+		return
+	}
+	s.Set("db.statement", scope.SQL)
+	if scope.HasError() && !gorm.IsRecordNotFoundError(scope.DB().Error) {
+		s.End(scope.DB().Error)
+		return
+	}
+	s.End(nil)
+}
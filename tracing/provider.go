@@ -0,0 +1,86 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/cuttle-ai/websockets/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+ * This file contains the tracer provider of the service.
+ * The new traces are sampled with the sample ratio and the ones with a parent follow the sampled flag of the parent.
+ * The sampled spans are queued in the batch span processor and posted to the OTLP http collector in batches.
+ * Spans are dropped by the processor when the queue is full so that the tracing never blocks the delivery.
+ * Without a collector, the spans aren't exported but the trace context is still propagated.
+ */
+
+var (
+	//provider is the tracer provider of the service
+	provider *sdktrace.TracerProvider
+	//startProvider starts the tracer provider once
+	startProvider sync.Once
+)
+
+//tracer returns the tracer of the service starting the tracer provider if required
+func tracer() trace.Tracer {
+	startProvider.Do(func() {
+		opts := []sdktrace.TracerProviderOption{
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.OTelSampleRatio))),
+			sdktrace.WithResource(resource.NewSchemaless(
+				attribute.String("service.name", config.OTelServiceName),
+				attribute.String("service.version", version.Default.Code),
+			)),
+		}
+		if len(config.OTelEndpoint) != 0 {
+			exp, err := exporter()
+			if err != nil {
+				log.Error("error while creating the exporter to the collector", config.OTelEndpoint, ". Spans won't be exported", err.Error())
+			} else {
+				opts = append(opts, sdktrace.WithBatcher(exp,
+					sdktrace.WithMaxQueueSize(config.OTelQueueSize),
+					sdktrace.WithMaxExportBatchSize(config.OTelBatchSize),
+					sdktrace.WithBatchTimeout(config.OTelExportInterval),
+				))
+			}
+		}
+		provider = sdktrace.NewTracerProvider(opts...)
+	})
+	return provider.Tracer("github.com/cuttle-ai/websockets/tracing")
+}
+
+//exporter returns the exporter posting the spans to the traces endpoint of the collector
+func exporter() (*otlptrace.Exporter, error) {
+	u, err := url.Parse(config.OTelEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+//Shutdown exports the spans waiting in the queue and stops the tracer provider
+func Shutdown(ctx context.Context) error {
+	tracer()
+	return provider.Shutdown(ctx)
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package tracing has the opentelemetry tracing of the notifications. The W3C trace context of the incoming
+//requests is propagated through the http handlers, the app context pool, the event bus and the database calls
+//down to the emit to the websocket connection, so that a notification can be traced from the calling service.
+//The spans are recorded with the opentelemetry sdk. The new traces are sampled as per the sample ratio and the ones
+//started by the callers follow their sampled flag. The sampled spans are exported to the OTLP http collector in batches.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//Header is the W3C trace context header with which the trace is propagated
+const Header = "traceparent"
+
+//propagator propagates the trace context with the W3C trace context headers
+var propagator = propagation.TraceContext{}
+
+//SpanContext is the trace context of a span propagated to its children
+type SpanContext struct {
+	trace.SpanContext
+}
+
+//Valid reports whether the span context has a trace
+func (c SpanContext) Valid() bool {
+	return c.IsValid()
+}
+
+//Traceparent returns the span context as the W3C traceparent header value. Empty if the span context is invalid
+func (c SpanContext) Traceparent() string {
+	h := http.Header{}
+	Inject(h, c)
+	return h.Get(Header)
+}
+
+//Parse parses the W3C traceparent header value. It reports whether the value is a valid trace context
+func Parse(traceparent string) (SpanContext, bool) {
+	if len(traceparent) == 0 {
+		return SpanContext{}, false
+	}
+	h := http.Header{}
+	h.Set(Header, traceparent)
+	c := Extract(h)
+	return c, c.Valid()
+}
+
+//Extract returns the trace context of the request headers. It is invalid if the headers don't have one
+func Extract(h http.Header) SpanContext {
+	ctx := propagator.Extract(context.Background(), propagation.HeaderCarrier(h))
+	return SpanContext{trace.SpanContextFromContext(ctx)}
+}
+
+//Inject sets the trace context in the headers of an outgoing request if it is valid
+func Inject(h http.Header, c SpanContext) {
+	if c.Valid() {
+		propagator.Inject(trace.ContextWithSpanContext(context.Background(), c.SpanContext), propagation.HeaderCarrier(h))
+	}
+}
+
+//Kind is the kind of a span
+type Kind = trace.SpanKind
+
+const (
+	//KindInternal is the span of an operation within the service
+	KindInternal = trace.SpanKindInternal
+	//KindServer is the span of a request served by the service
+	KindServer = trace.SpanKindServer
+	//KindClient is the span of a call made by the service to a downstream service or the database
+	KindClient = trace.SpanKindClient
+	//KindProducer is the span of a message sent by the service. Eg. the emit to a websocket connection
+	KindProducer = trace.SpanKindProducer
+)
+
+//Span is a timed operation of a trace. The methods of a nil span do nothing
+type Span struct {
+	span trace.Span
+}
+
+//Start starts a span as a child of the parent. A new trace is started if the parent is invalid
+//and it is sampled as per the sample ratio
+func Start(parent SpanContext, name string, kind Kind) *Span {
+	ctx := context.Background()
+	if parent.Valid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, parent.SpanContext)
+	}
+	_, s := tracer().Start(ctx, name, trace.WithSpanKind(kind))
+	return &Span{span: s}
+}
+
+//Context returns the span context of the span. It is invalid for a nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{s.span.SpanContext()}
+}
+
+//Set sets the attribute of the span. It is skipped if the span isn't sampled
+func (s *Span) Set(key string, value interface{}) *Span {
+	if s == nil || !s.span.IsRecording() {
+		return s
+	}
+	s.span.SetAttributes(attr(key, value))
+	return s
+}
+
+//attr returns the attribute of the value
+func attr(key string, v interface{}) attribute.KeyValue {
+	switch t := v.(type) {
+	case bool:
+		return attribute.Bool(key, t)
+	case int:
+		return attribute.Int(key, t)
+	case int32:
+		return attribute.Int64(key, int64(t))
+	case int64:
+		return attribute.Int64(key, t)
+	case uint:
+		return attribute.Int64(key, int64(t))
+	case uint32:
+		return attribute.Int64(key, int64(t))
+	case uint64:
+		return attribute.Int64(key, int64(t))
+	case float32:
+		return attribute.Float64(key, float64(t))
+	case float64:
+		return attribute.Float64(key, t)
+	case string:
+		return attribute.String(key, t)
+	}
+	return attribute.String(key, fmt.Sprint(v))
+}
+
+//End ends the span with the error of the operation if any. A span is ended only once
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+//With returns the context with the span context of the span
+func With(ctx context.Context, s *Span) context.Context {
+	return trace.ContextWithSpanContext(ctx, s.Context().SpanContext)
+}
+
+//FromContext returns the span context saved in the context. It is invalid if the context doesn't have one
+func FromContext(ctx context.Context) SpanContext {
+	return SpanContext{trace.SpanContextFromContext(ctx)}
+}
@@ -0,0 +1,287 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package usage
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/filter"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the daily and weekly usage reports of the tenants.
+ * The reports of the completed days and weeks are generated from the hourly samples by the leader.
+ * The peak connections of a period is the max over its hours of the peak connections summed across the instances.
+ */
+
+const (
+	//Daily is the period of the daily reports. A day starts at 00:00 UTC
+	Daily = "daily"
+	//Weekly is the period of the weekly reports. A week starts on monday 00:00 UTC
+	Weekly = "weekly"
+)
+
+//ReportsBackfill is the no. of completed periods for which the missing reports are generated
+const ReportsBackfill = 4
+
+//Report is the usage of a tenant in a day or a week
+type Report struct {
+	//Tenant of the usage
+	Tenant string `gorm:"primary_key" json:"tenant"`
+	//Period of the report. daily or weekly
+	Period string `gorm:"primary_key" json:"period"`
+	//Start of the period
+	Start time.Time `gorm:"primary_key" json:"start"`
+	//Events is the no. of events sent
+	Events uint64 `json:"events"`
+	//Bytes is the no. of payload bytes delivered
+	Bytes uint64 `json:"bytes"`
+	//PeakConnections is the max no. of concurrent connections
+	PeakConnections int `json:"peakConnections"`
+	//CreatedAt is the time at which the report was generated
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//TableName returns the table name of the usage reports
+func (Report) TableName() string {
+	return "usage_reports"
+}
+
+//ReportSchema is the filter schema of the usage reports
+var ReportSchema = filter.Schema{
+	Fields: map[string]filter.Field{
+		"tenant":          {Column: "tenant", Type: filter.String, Ops: filter.Text},
+		"period":          {Column: "period", Type: filter.String, Ops: []filter.Op{filter.Eq}},
+		"start":           {Column: "start", Type: filter.Time, Ops: filter.Comparison, Sortable: true},
+		"events":          {Column: "events", Type: filter.Uint, Ops: filter.Comparison, Sortable: true},
+		"bytes":           {Column: "bytes", Type: filter.Uint, Ops: filter.Comparison, Sortable: true},
+		"peakConnections": {Column: "peak_connections", Type: filter.Int, Ops: filter.Comparison, Sortable: true},
+	},
+	MaxLimit: 1000,
+}
+
+//field returns the value of the field of the report as per the filter schema
+func (r Report) field(name string) interface{} {
+	switch name {
+	case "tenant":
+		return r.Tenant
+	case "period":
+		return r.Period
+	case "start":
+		return r.Start
+	case "events":
+		return r.Events
+	case "bytes":
+		return r.Bytes
+	case "peakConnections":
+		return int64(r.PeakConnections)
+	}
+	return nil
+}
+
+//reports has the in memory reports mapped by the tenant, period and start. Used when the database is not enabled
+var reports = map[string]Report{}
+
+//key returns the key of the report in the in memory reports
+func (r Report) key() string {
+	return r.Tenant + "/" + r.Period + "/" + r.Start.Format(time.RFC3339)
+}
+
+//periodStart returns the start of the period having the time
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == Daily {
+		return day
+	}
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+//periodEnd returns the start of the next period
+func periodEnd(period string, start time.Time) time.Time {
+	if period == Daily {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.AddDate(0, 0, 7)
+}
+
+//aggregate returns the reports of the tenants in the period from the hourly samples in it
+func aggregate(period string, start time.Time, ss []Sample) []Report {
+	/*
+	 * We will sum the events and bytes of each tenant
+	 * We will sum the peak connections of the instances in each hour of the tenant
+	 * Then the max of the hourly sums is the peak connections of the tenant
+	 */
+	byTenant := map[string]*Report{}
+	hourly := map[string]map[time.Time]int{}
+	for _, s := range ss {
+		r, ok := byTenant[s.Tenant]
+		if !ok {
+			r = &Report{Tenant: s.Tenant, Period: period, Start: start}
+			byTenant[s.Tenant] = r
+			hourly[s.Tenant] = map[time.Time]int{}
+		}
+		r.Events += s.Events
+		r.Bytes += s.Bytes
+		hourly[s.Tenant][s.Hour.UTC()] += s.PeakConnections
+	}
+	result := make([]Report, 0, len(byTenant))
+	for tenant, r := range byTenant {
+		for _, peak := range hourly[tenant] {
+			if peak > r.PeakConnections {
+				r.PeakConnections = peak
+			}
+		}
+		result = append(result, *r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tenant < result[j].Tenant })
+	return result
+}
+
+//generated reports whether the reports of the period are already generated
+func generated(db *gorm.DB, period string, start time.Time) (bool, error) {
+	if db != nil {
+		n := 0
+		err := db.Model(&Report{}).Where("period = ? AND start = ?", period, start).Count(&n).Error
+		return n != 0, err
+	}
+	samplesLock.Lock()
+	defer samplesLock.Unlock()
+	for _, r := range reports {
+		if r.Period == period && r.Start.Equal(start) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//generate generates and saves the reports of the period from the hourly samples
+func generate(db *gorm.DB, period string, start time.Time) error {
+	ss, err := samplesBetween(db, start, periodEnd(period, start))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, r := range aggregate(period, start, ss) {
+		r.CreatedAt = now
+		if db != nil {
+			if err := db.Save(&r).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		samplesLock.Lock()
+		reports[r.key()] = r
+		samplesLock.Unlock()
+	}
+	return nil
+}
+
+//generateMissing generates the reports of the recently completed periods which are not generated yet
+func generateMissing(db *gorm.DB, now time.Time) {
+	for _, period := range []string{Daily, Weekly} {
+		start := periodStart(period, now)
+		for i := 0; i < ReportsBackfill; i++ {
+			end := start
+			if period == Daily {
+				start = start.AddDate(0, 0, -1)
+			} else {
+				start = start.AddDate(0, 0, -7)
+			}
+			if end.Before(now.Add(-config.UsageSampleRetention)) {
+				break
+			}
+			ok, err := generated(db, period, start)
+			if err == nil && !ok {
+				err = generate(db, period, start)
+			}
+			if err != nil {
+				log.Error("error while generating the", period, "usage reports of", start.Format("2006-01-02"), err.Error())
+			}
+		}
+	}
+}
+
+//runReports generates the missing reports every hour and purges the expired samples till done is closed.
+//It runs only on the leader instance
+func runReports(db *gorm.DB, done <-chan struct{}) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		now := time.Now()
+		generateMissing(db, now)
+		if err := purgeSamples(db, now.Add(-config.UsageSampleRetention)); err != nil {
+			log.Error("error while purging the expired usage samples", err.Error())
+		}
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+//Reports returns the generated usage reports matching the query
+func Reports(db *gorm.DB, q filter.Query) ([]Report, error) {
+	result := []Report{}
+	if db != nil {
+		if len(q.Sort) == 0 {
+			db = db.Order("start DESC").Order("tenant")
+		}
+		err := q.Apply(db).Find(&result).Error
+		return result, err
+	}
+	samplesLock.Lock()
+	for _, r := range reports {
+		if q.Match(r.field) {
+			result = append(result, r)
+		}
+	}
+	samplesLock.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Start.Equal(result[j].Start) {
+			return result[i].Start.After(result[j].Start)
+		}
+		return result[i].Tenant < result[j].Tenant
+	})
+	if q.Offset >= len(result) {
+		return []Report{}, nil
+	}
+	result = result[q.Offset:]
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+//WriteCSV writes the reports as csv with a header row
+func WriteCSV(w io.Writer, rs []Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"tenant", "period", "start", "events", "bytes", "peak_connections"}); err != nil {
+		return err
+	}
+	for _, r := range rs {
+		err := cw.Write([]string{
+			r.Tenant,
+			r.Period,
+			r.Start.Format("2006-01-02"),
+			strconv.FormatUint(r.Events, 10),
+			strconv.FormatUint(r.Bytes, 10),
+			strconv.Itoa(r.PeakConnections),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
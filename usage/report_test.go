@@ -0,0 +1,44 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package usage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriodStart(t *testing.T) {
+	thu := time.Date(2019, 6, 13, 15, 4, 5, 0, time.UTC)
+	if d := periodStart(Daily, thu); !d.Equal(time.Date(2019, 6, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the day to start at midnight. got %v", d)
+	}
+	if w := periodStart(Weekly, thu); !w.Equal(time.Date(2019, 6, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the week to start on monday. got %v", w)
+	}
+	sun := time.Date(2019, 6, 16, 23, 0, 0, 0, time.UTC)
+	if w := periodStart(Weekly, sun); !w.Equal(time.Date(2019, 6, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected sunday to be in the week started on monday. got %v", w)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	day := time.Date(2019, 6, 13, 0, 0, 0, 0, time.UTC)
+	ss := []Sample{
+		{Tenant: "a", Hour: day, Instance: "i1", Events: 10, Bytes: 100, PeakConnections: 3},
+		{Tenant: "a", Hour: day, Instance: "i2", Events: 5, Bytes: 50, PeakConnections: 4},
+		{Tenant: "a", Hour: day.Add(time.Hour), Instance: "i1", Events: 1, Bytes: 10, PeakConnections: 6},
+		{Tenant: "b", Hour: day, Instance: "i1", Events: 2, Bytes: 20, PeakConnections: 1},
+	}
+	rs := aggregate(Daily, day, ss)
+	if len(rs) != 2 || rs[0].Tenant != "a" || rs[1].Tenant != "b" {
+		t.Fatalf("expected the reports of tenants a and b. got %+v", rs)
+	}
+	if rs[0].Events != 16 || rs[0].Bytes != 160 {
+		t.Fatalf("expected 16 events and 160 bytes for tenant a. got %+v", rs[0])
+	}
+	if rs[0].PeakConnections != 7 {
+		t.Fatalf("expected the peak to be the max of the hourly sums across instances. got %d", rs[0].PeakConnections)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package usage has the per tenant usage of the service for the chargeback.
+//Every instance counts the events sent, the bytes delivered and the concurrent connections of the tenants
+//and periodically flushes them to the hourly samples. The leader aggregates the samples into the daily and weekly reports
+package usage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/leader"
+	"github.com/cuttle-ai/websockets/log"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the usage counters of the instance and their hourly samples
+ */
+
+//Sample is the usage of a tenant in an hour on an instance
+type Sample struct {
+	//Tenant of the usage
+	Tenant string `gorm:"primary_key"`
+	//Hour is the start of the hour of the usage
+	Hour time.Time `gorm:"primary_key"`
+	//Instance which counted the usage
+	Instance string `gorm:"primary_key"`
+	//Events is the no. of events sent
+	Events uint64
+	//Bytes is the no. of payload bytes delivered
+	Bytes uint64
+	//PeakConnections is the max no. of concurrent connections in the hour
+	PeakConnections int
+}
+
+//TableName returns the table name of the usage samples
+func (Sample) TableName() string {
+	return "usage_samples"
+}
+
+//counter has the usage of a tenant since the last flush
+type counter struct {
+	events      uint64
+	bytes       uint64
+	connections int
+	peak        int
+}
+
+var (
+	//counters has the usage counters of the tenants since the last flush
+	counters = map[string]*counter{}
+	//countersLock is the lock for the counters
+	countersLock sync.Mutex
+	//samples has the in memory hourly samples mapped by the tenant and hour. Used when the database is not enabled
+	samples = map[string]*Sample{}
+	//samplesLock is the lock for the in memory samples and reports
+	samplesLock sync.Mutex
+)
+
+//get returns the counter of the tenant. It has to be called with the counters lock
+func get(tenant string) *counter {
+	c, ok := counters[tenant]
+	if !ok {
+		c = &counter{}
+		counters[tenant] = c
+	}
+	return c
+}
+
+//Sent counts an event sent to a connection of the tenant with the payload size in bytes
+func Sent(tenant string, bytes int) {
+	countersLock.Lock()
+	defer countersLock.Unlock()
+	c := get(tenant)
+	c.events++
+	c.bytes += uint64(bytes)
+}
+
+//Connected counts a new connection of the tenant
+func Connected(tenant string) {
+	countersLock.Lock()
+	defer countersLock.Unlock()
+	c := get(tenant)
+	c.connections++
+	if c.connections > c.peak {
+		c.peak = c.connections
+	}
+}
+
+//Disconnected counts a closed connection of the tenant
+func Disconnected(tenant string) {
+	countersLock.Lock()
+	defer countersLock.Unlock()
+	c := get(tenant)
+	if c.connections > 0 {
+		c.connections--
+	}
+}
+
+//Init will migrate the usage tables, start flushing the counters of the instance and
+//start generating the reports on the leader instance. If the db is nil, the usage is kept in memory
+func Init(db *gorm.DB) error {
+	if db != nil {
+		if err := db.AutoMigrate(&Sample{}, &Report{}).Error; err != nil {
+			return err
+		}
+	}
+	go flushLoop(db)
+	leader.Run("usage-reports", db, func(done <-chan struct{}) {
+		runReports(db, done)
+	})
+	return nil
+}
+
+//flushLoop periodically flushes the usage counters to the hourly samples
+func flushLoop(db *gorm.DB) {
+	t := time.NewTicker(config.UsageFlush)
+	defer t.Stop()
+	for range t.C {
+		for _, s := range snapshot(time.Now()) {
+			if err := saveSample(db, s); err != nil {
+				log.Error("error while saving the usage sample of the tenant", s.Tenant, err.Error())
+			}
+		}
+	}
+}
+
+//snapshot returns the samples of the counters in the hour of the given time and resets them.
+//The peak of the next flush starts from the current connections
+func snapshot(now time.Time) []Sample {
+	countersLock.Lock()
+	defer countersLock.Unlock()
+	hour := now.UTC().Truncate(time.Hour)
+	result := []Sample{}
+	for tenant, c := range counters {
+		if c.events != 0 || c.peak != 0 {
+			result = append(result, Sample{
+				Tenant:          tenant,
+				Hour:            hour,
+				Instance:        config.ServiceDomain + ":" + config.Port,
+				Events:          c.events,
+				Bytes:           c.bytes,
+				PeakConnections: c.peak,
+			})
+		}
+		if c.connections == 0 {
+			delete(counters, tenant)
+			continue
+		}
+		c.events, c.bytes, c.peak = 0, 0, c.connections
+	}
+	return result
+}
+
+//upsertSampleSQL adds the sample to the existing sample of the tenant, hour and instance
+const upsertSampleSQL = `INSERT INTO usage_samples (tenant, hour, instance, events, bytes, peak_connections)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (tenant, hour, instance) DO UPDATE SET events = usage_samples.events + excluded.events,
+bytes = usage_samples.bytes + excluded.bytes,
+peak_connections = GREATEST(usage_samples.peak_connections, excluded.peak_connections)`
+
+//saveSample adds the sample to the hourly samples
+func saveSample(db *gorm.DB, s Sample) error {
+	if db != nil {
+		return db.Exec(upsertSampleSQL, s.Tenant, s.Hour, s.Instance, s.Events, s.Bytes, s.PeakConnections).Error
+	}
+	samplesLock.Lock()
+	defer samplesLock.Unlock()
+	key := s.Tenant + "/" + s.Hour.Format(time.RFC3339)
+	old, ok := samples[key]
+	if !ok {
+		samples[key] = &s
+		return nil
+	}
+	old.Events += s.Events
+	old.Bytes += s.Bytes
+	if s.PeakConnections > old.PeakConnections {
+		old.PeakConnections = s.PeakConnections
+	}
+	return nil
+}
+
+//samplesBetween returns the hourly samples in the range [from, to)
+func samplesBetween(db *gorm.DB, from, to time.Time) ([]Sample, error) {
+	result := []Sample{}
+	if db != nil {
+		err := db.Where("hour >= ? AND hour < ?", from, to).Find(&result).Error
+		return result, err
+	}
+	samplesLock.Lock()
+	defer samplesLock.Unlock()
+	for _, s := range samples {
+		if !s.Hour.Before(from) && s.Hour.Before(to) {
+			result = append(result, *s)
+		}
+	}
+	return result, nil
+}
+
+//purgeSamples deletes the hourly samples before the given time
+func purgeSamples(db *gorm.DB, before time.Time) error {
+	if db != nil {
+		return db.Where("hour < ?", before).Delete(&Sample{}).Error
+	}
+	samplesLock.Lock()
+	defer samplesLock.Unlock()
+	for k, s := range samples {
+		if s.Hour.Before(before) {
+			delete(samples, k)
+		}
+	}
+	return nil
+}
@@ -23,6 +23,13 @@ var (
 	Default = V1
 )
 
+var (
+	//Commit is the vcs commit from which the application is built. Set with -ldflags "-X github.com/cuttle-ai/websockets/version.Commit=<commit>"
+	Commit = "unknown"
+	//BuildTime is the time at which the application is built. Set with -ldflags "-X github.com/cuttle-ai/websockets/version.BuildTime=<time>"
+	BuildTime = "unknown"
+)
+
 const (
 	//AppName is the name of the application
 	AppName = "websockets"
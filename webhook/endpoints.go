@@ -0,0 +1,211 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/jinzhu/gorm"
+)
+
+/*
+ * This file contains the webhook endpoints to which the events emitted by the clients are forwarded.
+ * The endpoints are given in the config or saved in the database and cached by every instance.
+ * The cache is refreshed in the background and the hooks are told of the endpoints loaded on every refresh
+ */
+
+var (
+	//ErrEndpointNotFound is returned when the webhook endpoint doesn't exist
+	ErrEndpointNotFound = errors.New("webhook endpoint not found")
+	//ErrInvalidURL is returned when the url of the webhook endpoint is not an absolute http(s) url
+	ErrInvalidURL = errors.New("webhook url should be an absolute http or https url")
+)
+
+//Endpoint is a webhook to which an event emitted by the clients is forwarded
+type Endpoint struct {
+	//ID of the endpoint. Zero for the endpoints in the config
+	ID uint `gorm:"primary_key" json:"id"`
+	//Event emitted by the clients which is forwarded
+	Event string `gorm:"index" json:"event"`
+	//URL to which the event is posted
+	URL string `gorm:"type:text" json:"url"`
+	//Tenant whose clients' events are forwarded. The events are signed with the secrets of the tenant.
+	//The events of all the tenants are forwarded and signed with the secrets of the default tenant if empty
+	Tenant string `json:"tenant,omitempty"`
+	//CreatedAt is the time at which the endpoint was saved
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//TableName returns the table name of the webhook endpoints
+func (Endpoint) TableName() string {
+	return "webhook_endpoints"
+}
+
+//Check checks whether the endpoint has the event and a valid url
+func (e Endpoint) Check() error {
+	if len(e.Event) == 0 {
+		return errors.New("webhook event is missing")
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+var (
+	//db is the database in which the endpoints are stored. The endpoints are kept only in memory if it is nil
+	db *gorm.DB
+	//endpoints has the cached endpoints
+	endpoints []Endpoint
+	//endpointsSeq is the last id given to an endpoint kept in memory
+	endpointsSeq uint
+	//endpointsLock is the lock for the endpoints. The database isn't called under it
+	endpointsLock sync.RWMutex
+	//loadHooks are called with the endpoints loaded
+	loadHooks []func([]Endpoint)
+)
+
+//OnLoad registers the hook called with the endpoints loaded at the init and on every refresh
+func OnLoad(h func([]Endpoint)) {
+	endpointsLock.Lock()
+	defer endpointsLock.Unlock()
+	loadHooks = append(loadHooks, h)
+}
+
+//Init will check the endpoints in the config, migrate the webhook endpoints table and load the endpoints.
+//The endpoints are refreshed from the database in the background. If the db is nil, the endpoints are kept in memory
+func Init(d *gorm.DB) error {
+	for event, urls := range config.ClientWebhooks {
+		for _, u := range urls {
+			if err := (Endpoint{Event: event, URL: u}).Check(); err != nil {
+				return errors.New("webhook " + u + " of the event " + event + " in the config is invalid: " + err.Error())
+			}
+		}
+	}
+	if d != nil {
+		if err := d.AutoMigrate(&Endpoint{}).Error; err != nil {
+			return err
+		}
+		db = d
+	}
+	if err := loadEndpoints(); err != nil {
+		return err
+	}
+	if db != nil {
+		config.Refresh("webhooks", config.WebhooksRefresh, loadEndpoints)
+	}
+	return nil
+}
+
+//loadEndpoints reloads the endpoints from the config and the database, swaps them in the cache and calls the hooks
+func loadEndpoints() error {
+	es := []Endpoint{}
+	for event, urls := range config.ClientWebhooks {
+		for _, u := range urls {
+			es = append(es, Endpoint{Event: event, URL: u})
+		}
+	}
+	if db != nil {
+		saved := []Endpoint{}
+		if err := db.Find(&saved).Error; err != nil {
+			return err
+		}
+		es = append(es, saved...)
+	}
+	endpointsLock.Lock()
+	if db == nil {
+		for _, e := range endpoints {
+			if e.ID != 0 {
+				es = append(es, e)
+			}
+		}
+	}
+	endpoints = es
+	hooks := loadHooks
+	endpointsLock.Unlock()
+	for _, h := range hooks {
+		h(append([]Endpoint{}, es...))
+	}
+	return nil
+}
+
+//Endpoints returns the cached webhook endpoints sorted by the event
+func Endpoints() ([]Endpoint, error) {
+	endpointsLock.RLock()
+	result := append([]Endpoint{}, endpoints...)
+	endpointsLock.RUnlock()
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Event < result[j].Event })
+	return result, nil
+}
+
+//EndpointsOf returns the webhook endpoints of the event to which the events of the clients of the tenant are forwarded
+func EndpointsOf(event, tenant string) ([]Endpoint, error) {
+	es, err := Endpoints()
+	if err != nil {
+		return nil, err
+	}
+	result := []Endpoint{}
+	for _, e := range es {
+		if e.Event == event && (len(e.Tenant) == 0 || e.Tenant == tenant) {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+//AddEndpoint checks and saves the webhook endpoint
+func AddEndpoint(e Endpoint) (Endpoint, error) {
+	if err := e.Check(); err != nil {
+		return e, err
+	}
+	e.ID, e.CreatedAt = 0, time.Now()
+	if db != nil {
+		if err := db.Create(&e).Error; err != nil {
+			return e, err
+		}
+	}
+	endpointsLock.Lock()
+	defer endpointsLock.Unlock()
+	if db == nil {
+		endpointsSeq++
+		e.ID = endpointsSeq
+	}
+	endpoints = append(endpoints, e)
+	return e, nil
+}
+
+//RemoveEndpoint deletes the saved webhook endpoint with the id
+func RemoveEndpoint(id uint) error {
+	if id == 0 {
+		return ErrEndpointNotFound
+	}
+	if db != nil {
+		r := db.Where("id = ?", id).Delete(&Endpoint{})
+		if r.Error != nil {
+			return r.Error
+		}
+		if r.RowsAffected == 0 {
+			return ErrEndpointNotFound
+		}
+	}
+	endpointsLock.Lock()
+	defer endpointsLock.Unlock()
+	for i, e := range endpoints {
+		if e.ID == id {
+			endpoints = append(endpoints[:i:i], endpoints[i+1:]...)
+			return nil
+		}
+	}
+	if db == nil {
+		return ErrEndpointNotFound
+	}
+	return nil
+}
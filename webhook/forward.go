@@ -0,0 +1,176 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+	"github.com/cuttle-ai/websockets/log"
+)
+
+/*
+ * This file contains the forwarding of the events emitted by the clients to the webhook endpoints.
+ * The events are queued and posted by the forwarders as signed webhooks. A failed post is retried with an exponential backoff
+ * unless the endpoint rejected the event with a 4xx status other than 408 and 429. The retries wait in a delay queue and not
+ * in the forwarders, so a failing endpoint doesn't hold up the events of the others. The events are dropped when the queue is full.
+ */
+
+//forwarders is the no. of goroutines posting the client events to the webhooks
+const forwarders = 4
+
+//retryBackoff is the time waited before the first retry of a failed post. It is doubled for every retry
+var retryBackoff = time.Second
+
+//Event is an event emitted by a client forwarded to the webhooks
+type Event struct {
+	//ID of the event. It is same for all the retries of a post
+	ID string `json:"id"`
+	//Event emitted by the client
+	Event string `json:"event"`
+	//Namespace in which the event was emitted
+	Namespace string `json:"namespace"`
+	//UserID is the id of the user of the client
+	UserID uint `json:"userId"`
+	//Tenant of the user
+	Tenant string `json:"tenant,omitempty"`
+	//ConnectionID is the id of the websocket connection of the client
+	ConnectionID string `json:"connectionId"`
+	//Payload emitted by the client. It is an array if the client emitted more than one arg
+	Payload interface{} `json:"payload"`
+	//At is the time at which the event was received
+	At time.Time `json:"at"`
+}
+
+//forwardJob is an event to be posted to an endpoint
+type forwardJob struct {
+	Event
+	endpoint Endpoint
+	//attempt is the no. of times the event was posted to the endpoint
+	attempt int
+}
+
+var (
+	//forwardQueue is the queue of the events waiting to be forwarded
+	forwardQueue chan forwardJob
+	//startForwarders starts the forwarders once
+	startForwarders sync.Once
+	//eventSeq is the last sequence no. given to a client event of the instance
+	eventSeq uint64
+	//delayed is the no. of the events waiting in the delay queue for their retry
+	delayed int64
+	//forwardClient is the http client used to post the events
+	forwardClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+//queue returns the queue of the events to be forwarded starting the forwarders if not started
+func queue() chan forwardJob {
+	startForwarders.Do(func() {
+		forwardQueue = make(chan forwardJob, config.WebhookQueueSize)
+		config.SuperviseWorkers("webhooks", forwarders, forward)
+	})
+	return forwardQueue
+}
+
+//Forward queues the event to be posted to the webhook endpoints of the event and the tenant
+func Forward(e Event) error {
+	es, err := EndpointsOf(e.Event, e.Tenant)
+	if err != nil || len(es) == 0 {
+		return err
+	}
+	if len(e.ID) == 0 {
+		e.ID = "evt_" + strconv.FormatInt(time.Now().UnixNano(), 36) + "_" + strconv.FormatUint(atomic.AddUint64(&eventSeq, 1), 36)
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	for _, ep := range es {
+		enqueue(forwardJob{Event: e, endpoint: ep})
+	}
+	return nil
+}
+
+//enqueue queues the job for the forwarders without blocking. The job is dropped if the queue is full
+func enqueue(j forwardJob) {
+	select {
+	case queue() <- j:
+	default:
+		log.Warn("webhook queue is full. dropping the client event", j.Event.Event, "of user", j.UserID, "for", j.endpoint.URL)
+	}
+}
+
+//delay puts the job in the delay queue from which it is queued again for the forwarders after the wait.
+//The job is dropped if the delay queue is full
+func delay(j forwardJob, wait time.Duration) {
+	if atomic.AddInt64(&delayed, 1) > int64(config.WebhookQueueSize) {
+		atomic.AddInt64(&delayed, -1)
+		log.Warn("webhook retry queue is full. dropping the client event", j.Event.Event, "of user", j.UserID, "for", j.endpoint.URL)
+		return
+	}
+	time.AfterFunc(wait, func() {
+		atomic.AddInt64(&delayed, -1)
+		enqueue(j)
+	})
+}
+
+//forward posts the queued events to their endpoints. The failed posts are delayed for their retry
+func forward() {
+	for j := range forwardQueue {
+		retry, err := post(j.endpoint, j.Event)
+		if err == nil {
+			continue
+		}
+		if !retry || j.attempt >= config.WebhookRetries {
+			log.Error("error while forwarding the client event", j.Event.Event, j.ID, "of user", j.UserID, "to", j.endpoint.URL, err.Error())
+			continue
+		}
+		wait := retryBackoff << uint(j.attempt)
+		j.attempt++
+		log.Warn("retrying the client event", j.Event.Event, j.ID, "to", j.endpoint.URL, "in", wait, err.Error())
+		delay(j, wait)
+	}
+}
+
+//post posts the event to the endpoint as a signed webhook. It reports whether the post can be retried on error
+func post(ep Endpoint, e Event) (bool, error) {
+	/*
+	 * We will encode the event
+	 * Then we will sign it with the secrets of the tenant of the endpoint
+	 * Then we will post it
+	 */
+	body, err := json.Marshal(e)
+	if err != nil {
+		return false, err
+	}
+	h, err := Sign(tenantOrDefault(ep.Tenant), e.ID, time.Now(), body)
+	if err != nil {
+		return true, err
+	}
+
+	//posting the event
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header = h
+	req.Header.Set("Content-Type", "application/json")
+	res, err := forwardClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode >= 500 || res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests
+	return retry, errors.New("webhook responded with " + res.Status)
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+//receiver is a test webhook receiver responding with the statuses in order and 200 after them
+type receiver struct {
+	statuses []int
+	ids      []string
+	signed   bool
+	m        sync.Mutex
+}
+
+func (r *receiver) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.ids = append(r.ids, req.Header.Get(IDHeader))
+	r.signed = len(req.Header.Get(SignatureHeader)) != 0
+	status := http.StatusOK
+	if len(r.statuses) != 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	res.WriteHeader(status)
+}
+
+//received waits for the receiver to get the no. of posts and returns the ids of the posts
+func (r *receiver) received(t *testing.T, n int) []string {
+	for i := 0; i < 200; i++ {
+		r.m.Lock()
+		ids := append([]string{}, r.ids...)
+		r.m.Unlock()
+		if len(ids) >= n {
+			return ids
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d posts", n)
+	return nil
+}
+
+func init() {
+	retryBackoff = 10 * time.Millisecond
+	Store = &memoryStore{secrets: map[string][]Secret{}}
+}
+
+func TestForwardRetry(t *testing.T) {
+	if _, err := Rotate(DefaultTenant); err != nil {
+		t.Fatal(err)
+	}
+	r := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	if _, err := AddEndpoint(Endpoint{Event: "report-ready", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Forward(Event{Event: "report-ready", UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	//the failed posts are retried with the same id
+	ids := r.received(t, 3)
+	if ids[0] != ids[1] || ids[1] != ids[2] {
+		t.Errorf("expected the retries to have the same id. got %v", ids)
+	}
+	if !r.signed {
+		t.Error("expected the posts to be signed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if ids = r.received(t, 3); len(ids) != 3 {
+		t.Errorf("expected no post after the success. got %d posts", len(ids))
+	}
+}
+
+func TestForwardRejected(t *testing.T) {
+	if _, err := Rotate(DefaultTenant); err != nil {
+		t.Fatal(err)
+	}
+	r := &receiver{statuses: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	if _, err := AddEndpoint(Endpoint{Event: "report-rejected", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Forward(Event{Event: "report-rejected", UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	r.received(t, 1)
+	time.Sleep(50 * time.Millisecond)
+	if ids := r.received(t, 1); len(ids) != 1 {
+		t.Errorf("expected the rejected event not to be retried. got %d posts", len(ids))
+	}
+}
+
+func TestOnLoad(t *testing.T) {
+	config.ClientWebhooks = map[string][]string{"report-shared": {"https://hooks.cuttle.ai/shared"}}
+	defer func() { config.ClientWebhooks = map[string][]string{} }()
+	saved, err := AddEndpoint(Endpoint{Event: "report-saved", URL: "https://hooks.cuttle.ai/saved"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the hooks are told of the endpoints of the config and the saved ones on every load
+	events := map[string]bool{}
+	OnLoad(func(es []Endpoint) {
+		for _, e := range es {
+			events[e.Event] = true
+		}
+	})
+	if err := loadEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	if !events["report-shared"] || !events["report-saved"] {
+		t.Errorf("expected the hook to get the endpoints of the config and the saved ones. got %v", events)
+	}
+	if err := RemoveEndpoint(saved.ID); err != nil {
+		t.Fatal(err)
+	}
+}
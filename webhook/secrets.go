@@ -0,0 +1,224 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package webhook has the outbound webhooks of the service.
+//Webhooks are signed with the per tenant signing secrets as per the standard webhooks spec
+//so that the receivers can verify them with the common verification libraries.
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cuttle-ai/websockets/config"
+)
+
+//SecretPrefix is the prefix of the webhook signing secrets
+const SecretPrefix = "whsec_"
+
+//DefaultTenant is the tenant whose secrets are used for the webhooks without a tenant
+const DefaultTenant = "default"
+
+//ErrNoSecret is returned when the tenant doesn't have a valid signing secret
+var ErrNoSecret = errors.New("couldn't find a valid webhook signing secret for the tenant")
+
+//Secret is a webhook signing secret of a tenant
+type Secret struct {
+	//Key is the secret key prefixed with whsec_ and base64 encoded
+	Key string `json:"key"`
+	//CreatedAt is the time at which the secret was created
+	CreatedAt time.Time `json:"createdAt"`
+	//ExpiresAt is the time after which the secret is not valid. Nil if the secret is active
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+//Valid reports whether the secret is valid at the given time
+func (s Secret) Valid(t time.Time) bool {
+	return s.ExpiresAt == nil || s.ExpiresAt.After(t)
+}
+
+//Bytes returns the raw bytes of the secret key
+func (s Secret) Bytes() ([]byte, error) {
+	k := s.Key
+	if len(k) > len(SecretPrefix) && k[:len(SecretPrefix)] == SecretPrefix {
+		k = k[len(SecretPrefix):]
+	}
+	return base64.StdEncoding.DecodeString(k)
+}
+
+//SecretStore stores the webhook signing secrets of the tenants
+type SecretStore interface {
+	//Get returns the secrets of the tenant
+	Get(tenant string) ([]Secret, error)
+	//Put replaces the secrets of the tenant
+	Put(tenant string, secrets []Secret) error
+}
+
+//memoryStore is the in memory secret store used when the vault is skipped
+type memoryStore struct {
+	secrets map[string][]Secret
+	m       sync.Mutex
+}
+
+//Get returns the secrets of the tenant
+func (m *memoryStore) Get(tenant string) ([]Secret, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return append([]Secret{}, m.secrets[tenant]...), nil
+}
+
+//Put replaces the secrets of the tenant
+func (m *memoryStore) Put(tenant string, secrets []Secret) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.secrets[tenant] = append([]Secret{}, secrets...)
+	return nil
+}
+
+//cachedSecrets are the secrets of a tenant cached from the store
+type cachedSecrets struct {
+	//secrets of the tenant
+	secrets []Secret
+	//fetchedAt is the time at which the secrets were fetched from the store
+	fetchedAt time.Time
+}
+
+var (
+	//Store is the secret store of the application
+	Store SecretStore
+	//cache has the secrets of the tenants cached from the store
+	cache = map[string]cachedSecrets{}
+	//cacheLock is the lock for the cache. The store is never called with it
+	cacheLock sync.Mutex
+	//rotateLock serializes the rotations of the secrets
+	rotateLock sync.Mutex
+)
+
+func init() {
+	if config.SkipVault {
+		Store = &memoryStore{secrets: map[string][]Secret{}}
+		return
+	}
+	Store = NewVaultStore(config.VaultAddr, config.VaultToken, config.WebhookSecretsPath)
+	config.SetCapability(config.CapabilityWebhookSigning, true)
+}
+
+//tenantOrDefault returns the default tenant for the empty tenant
+func tenantOrDefault(tenant string) string {
+	if len(tenant) == 0 {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+//cached returns the secrets of the tenant from the cache. The secrets are fetched from the store
+//if they are not cached or their refresh interval has passed. The stale secrets are used if the store fails
+//and the store is tried again only after the refresh interval
+func cached(tenant string) ([]Secret, error) {
+	/*
+	 * We will get the secrets from the cache
+	 * If they are fresh we will return them
+	 * Else we will fetch them from the store without the lock and cache them
+	 */
+	start := time.Now()
+	cacheLock.Lock()
+	c, ok := cache[tenant]
+	cacheLock.Unlock()
+	if ok && start.Sub(c.fetchedAt) < config.WebhookSecretsRefresh {
+		return c.secrets, nil
+	}
+
+	//fetching from the store
+	ss, err := Store.Get(tenant)
+	if err != nil && !ok {
+		return nil, err
+	}
+	if err == nil {
+		c.secrets = ss
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	//a rotation might have cached newer secrets while fetching
+	if latest, found := cache[tenant]; found && latest.fetchedAt.After(start) {
+		return latest.secrets, nil
+	}
+	c.fetchedAt = start
+	cache[tenant] = c
+	return c.secrets, nil
+}
+
+//Secrets returns the valid signing secrets of the tenant. Newest secret comes first
+func Secrets(tenant string) ([]Secret, error) {
+	/*
+	 * We will get the secrets of the tenant
+	 * Then we will filter the valid secrets
+	 */
+	ss, err := cached(tenantOrDefault(tenant))
+	if err != nil {
+		return nil, err
+	}
+	n := time.Now()
+	result := []Secret{}
+	for i := len(ss) - 1; i >= 0; i-- {
+		if ss[i].Valid(n) {
+			result = append(result, ss[i])
+		}
+	}
+	if len(result) == 0 {
+		return nil, ErrNoSecret
+	}
+	return result, nil
+}
+
+//newSecret generates a new random secret
+func newSecret() (Secret, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Secret{}, err
+	}
+	return Secret{Key: SecretPrefix + base64.StdEncoding.EncodeToString(b), CreatedAt: time.Now()}, nil
+}
+
+//Rotate generates a new signing secret for the tenant. The existing secrets remain valid
+//till the grace period so that the receivers can move to the new secret. Expired secrets are removed
+func Rotate(tenant string) (Secret, error) {
+	/*
+	 * We will get the secrets of the tenant from the store
+	 * Then we will expire the active secrets after the grace period and remove the expired ones
+	 * Then we will add the new secret and save it in the store
+	 */
+	tenant = tenantOrDefault(tenant)
+	rotateLock.Lock()
+	defer rotateLock.Unlock()
+	ss, err := Store.Get(tenant)
+	if err != nil {
+		return Secret{}, err
+	}
+	s, err := newSecret()
+	if err != nil {
+		return Secret{}, err
+	}
+	expiry := s.CreatedAt.Add(config.WebhookSecretGrace)
+	result := []Secret{}
+	for _, v := range ss {
+		if !v.Valid(s.CreatedAt) {
+			continue
+		}
+		if v.ExpiresAt == nil {
+			v.ExpiresAt = &expiry
+		}
+		result = append(result, v)
+	}
+	result = append(result, s)
+	if err := Store.Put(tenant, result); err != nil {
+		return Secret{}, err
+	}
+	cacheLock.Lock()
+	cache[tenant] = cachedSecrets{secrets: result, fetchedAt: time.Now()}
+	cacheLock.Unlock()
+	return s, nil
+}
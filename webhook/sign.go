@@ -0,0 +1,60 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the signing of the webhooks as per the standard webhooks spec.
+ * The signed content is the message id, timestamp and the body joined by dots.
+ * During a rotation, the webhook carries the signatures with all the valid secrets of the tenant.
+ */
+
+const (
+	//IDHeader is the header having the unique id of the webhook message
+	IDHeader = "webhook-id"
+	//TimestampHeader is the header having the unix timestamp at which the webhook was signed
+	TimestampHeader = "webhook-timestamp"
+	//SignatureHeader is the header having the space separated signatures of the webhook
+	SignatureHeader = "webhook-signature"
+)
+
+//signature returns the v1 signature of the content with the secret
+func signature(secret []byte, id string, t time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "." + strconv.FormatInt(t.Unix(), 10) + "."))
+	mac.Write(body)
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//Sign returns the headers to be set in the webhook request having the id, timestamp and
+//the signatures with all the valid signing secrets of the tenant
+func Sign(tenant, id string, t time.Time, body []byte) (http.Header, error) {
+	ss, err := Secrets(tenant)
+	if err != nil {
+		return nil, err
+	}
+	sigs := make([]string, 0, len(ss))
+	for _, s := range ss {
+		k, err := s.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, signature(k, id, t, body))
+	}
+	h := http.Header{}
+	h.Set(IDHeader, id)
+	h.Set(TimestampHeader, strconv.FormatInt(t.Unix(), 10))
+	h.Set(SignatureHeader, strings.Join(sigs, " "))
+	return h, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 Cuttle.ai. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+ * This file contains the secret store backed by the kv v2 secrets engine of vault
+ */
+
+//VaultStore stores the secrets of each tenant under the kv v2 path in vault
+type VaultStore struct {
+	//Addr is the address of the vault server
+	Addr string
+	//Token is the token to access the vault server
+	Token string
+	//Path is the kv v2 data path under which the secrets are stored
+	Path string
+	//Client is the http client used to talk to vault
+	Client *http.Client
+}
+
+//vaultSecrets is the data stored in vault for a tenant
+type vaultSecrets struct {
+	//Secrets of the tenant
+	Secrets []Secret `json:"secrets"`
+}
+
+//NewVaultStore returns a vault secret store for the given vault server and path
+func NewVaultStore(addr, token, path string) *VaultStore {
+	return &VaultStore{
+		Addr:   strings.TrimRight(addr, "/"),
+		Token:  token,
+		Path:   strings.Trim(path, "/"),
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+//url returns the url of the secrets of the tenant
+func (v *VaultStore) url(tenant string) string {
+	return v.Addr + "/v1/" + v.Path + "/" + tenant
+}
+
+//Get returns the secrets of the tenant from vault
+func (v *VaultStore) Get(tenant string) ([]Secret, error) {
+	/*
+	 * We will read the secret from vault
+	 * If it is not found, the tenant doesn't have any secrets yet
+	 * Then we will decode the secrets
+	 */
+	req, err := http.NewRequest(http.MethodGet, v.url(tenant), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	res, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return []Secret{}, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error while reading the webhook secrets of %s from vault. status %d", tenant, res.StatusCode)
+	}
+	body := struct {
+		Data struct {
+			Data vaultSecrets `json:"data"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Data.Data.Secrets, nil
+}
+
+//Put writes the secrets of the tenant to vault
+func (v *VaultStore) Put(tenant string, secrets []Secret) error {
+	b, err := json.Marshal(map[string]interface{}{"data": vaultSecrets{Secrets: secrets}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, v.url(tenant), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("error while writing the webhook secrets of %s to vault. status %d", tenant, res.StatusCode)
+	}
+	return nil
+}